@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// newAlertsCommand returns the alerts subcommand. It prints the recommended alerts on the metrics of this version
+// of the controller, so monitoring can be bootstrapped and kept in sync with metric renames on upgrades.
+func newAlertsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "alerts",
+		Short: "Print the recommended alerts on the metrics of the controller",
+		Args:  cobra.NoArgs,
+	}
+	format := cmd.Flags().String(
+		"format",
+		"prometheusrule",
+		"Output format, \"prometheusrule\" for a PrometheusRule of the Prometheus Operator or \"prometheus\" for a Prometheus rule file.",
+	)
+
+	cmd.RunE = func(*cobra.Command, []string) error {
+		data, err := metrics.MarshalAlerts(*format)
+		if err != nil {
+			klog.Errorf("Invalid --format: %v", err)
+			return exitCode(1)
+		}
+		fmt.Print(string(data))
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/jhjaggars/capa-annotator/pkg/version"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// defaultCommand is run if no subcommand is given, so that deployments passing only the flags of the controller
+// keep working.
+const defaultCommand = "serve"
+
+// rootFlags are the persistent flags of the root command, shared by all subcommands.
+type rootFlags struct {
+	kubeconfig *kubeconfigFlags
+	configFile *string
+	profile    *string
+}
+
+// newRootCommand returns the cobra command of the binary with the subcommands. The kubeconfig, --config,
+// --profile and klog flags are persistent flags of the root command. The configuration file and the profile set
+// the flags of the subcommand that runs which are not set explicitly; the subcommands define their own flags.
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:               filepath.Base(os.Args[0]),
+		Short:             "Annotates Cluster API MachineDeployments with the capacity of their AWS instance types",
+		SilenceErrors:     true,
+		SilenceUsage:      true,
+		CompletionOptions: cobra.CompletionOptions{DisableDefaultCmd: true},
+	}
+
+	fs := root.PersistentFlags()
+	f := &rootFlags{kubeconfig: addKubeconfigFlags(fs)}
+	f.configFile = fs.String(
+		"config",
+		"",
+		fmt.Sprintf("Path to a YAML configuration file of apiVersion %s and kind %s covering the annotation, cache, selection, concurrency and AWS flags. Explicitly set flags take precedence over the file, which takes precedence over --profile.", configAPIVersion, configKind),
+	)
+	f.profile = fs.String(
+		"profile",
+		"",
+		fmt.Sprintf("Preset of tuning values for --max-concurrent-reconciles, --sync-period, --instance-types-cache-ttl, --kube-api-qps and --kube-api-burst. One of %q. Explicitly set flags take precedence over the preset. The airgapped preset requires --instance-types-file.", profileNames()),
+	)
+	klogFlags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(klogFlags)
+	if err := klogFlags.Set("logtostderr", "true"); err != nil {
+		klog.Fatalf("Error setting logtostderr flag: %v", err)
+	}
+	fs.AddGoFlagSet(klogFlags)
+
+	root.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
+		if err := applyConfigFile(cmd.Flags(), *f.configFile); err != nil {
+			return fmt.Errorf("invalid --config: %w", err)
+		}
+		if err := applyProfile(cmd.Flags(), *f.profile); err != nil {
+			return fmt.Errorf("invalid --profile: %w", err)
+		}
+		return nil
+	}
+
+	root.AddCommand(
+		newServeCommand("serve", "Run the controller (default)", serveController, f),
+		newServeCommand("run-once", "Annotate all MachineDeployments once and exit, taking the flags of serve", serveRunOnce, f),
+		newServeCommand("diff", "Print the pending annotation changes of all MachineDeployments, taking the flags of serve", serveDiff, f),
+		newExportCommand(),
+		newValidateCommand(),
+		newWhatIfCommand(),
+		newUninstallCommand(f),
+		newSupportBundleCommand(f),
+		newAlertsCommand(),
+		newVersionCommand(),
+	)
+	return root
+}
+
+// execute runs the subcommand of the arguments and returns its exit code. Without a subcommand, the default
+// command is run with all arguments, unless help is requested. Invalid arguments exit with 2.
+func execute(root *cobra.Command, args []string) int {
+	if cmd, _, err := root.Find(args); err == nil && cmd == root && !slices.Contains(args, "-h") && !slices.Contains(args, "--help") {
+		args = append([]string{defaultCommand}, args...)
+	}
+
+	root.SetArgs(args)
+	cmd, err := root.ExecuteC()
+	var code exitCode
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &code):
+		return int(code)
+	default:
+		fmt.Fprintf(root.ErrOrStderr(), "Error: %v\nRun '%s --help' for usage.\n", err, cmd.CommandPath())
+		return 2
+	}
+}
+
+// exitCode is the error of a command exiting with a non-zero code, which reported its failure already.
+type exitCode int
+
+func (c exitCode) Error() string {
+	return "exit status " + strconv.Itoa(int(c))
+}
+
+// exit returns the error of the exit code of a command, nil for zero.
+func exit(code int) error {
+	if code == 0 {
+		return nil
+	}
+	return exitCode(code)
+}
+
+// newVersionCommand returns the version subcommand.
+func newVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version and exit",
+		Args:  cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			fmt.Println(version.String)
+		},
+	}
+}
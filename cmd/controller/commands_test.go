@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func TestExecute(t *testing.T) {
+	testCases := []struct {
+		name            string
+		args            []string
+		expectedCommand string
+		expectedFlags   map[string]string
+		expectedCode    int
+	}{
+		{
+			name:            "no arguments",
+			expectedCommand: "serve",
+		},
+		{
+			name:            "flags only",
+			args:            []string{"--namespace", "default"},
+			expectedCommand: "serve",
+			expectedFlags:   map[string]string{"namespace": "default"},
+		},
+		{
+			name:            "subcommand with persistent flags",
+			args:            []string{"--kubeconfig", "/tmp/kubeconfig", "export", "--region", "us-east-1", "-v", "2"},
+			expectedCommand: "export",
+			expectedFlags:   map[string]string{"kubeconfig": "/tmp/kubeconfig", "region": "us-east-1", "v": "2"},
+			expectedCode:    3,
+		},
+		{
+			name:            "profile",
+			args:            []string{"--profile=large", "--sync-period=1h"},
+			expectedCommand: "serve",
+			expectedFlags:   map[string]string{"max-concurrent-reconciles": "10", "sync-period": "1h0m0s"},
+		},
+		{
+			name:            "profile of a subcommand without the tuning flags",
+			args:            []string{"export", "--profile=large"},
+			expectedCommand: "export",
+			expectedCode:    3,
+		},
+		{
+			name: "help flag",
+			args: []string{"-h"},
+		},
+		{
+			name: "help flag of a subcommand",
+			args: []string{"export", "--help"},
+		},
+		{
+			name:         "unknown subcommand",
+			args:         []string{"unknown"},
+			expectedCode: 2,
+		},
+		{
+			name:         "unknown flag",
+			args:         []string{"export", "--bogus"},
+			expectedCode: 2,
+		},
+		{
+			name:         "unknown profile",
+			args:         []string{"--profile=huge"},
+			expectedCode: 2,
+		},
+		{
+			name:         "argument",
+			args:         []string{"export", "us-east-1"},
+			expectedCode: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			root := newRootCommand()
+			root.SetOut(io.Discard)
+			root.SetErr(io.Discard)
+
+			ranCommand, ranFlags := "", map[string]string{}
+			record := func(name string, code int) {
+				cmd, _, err := root.Find([]string{name})
+				g.Expect(err).ToNot(HaveOccurred())
+				cmd.RunE = func(cmd *cobra.Command, _ []string) error {
+					ranCommand = cmd.Name()
+					for flagName := range tc.expectedFlags {
+						ranFlags[flagName] = cmd.Flag(flagName).Value.String()
+					}
+					return exit(code)
+				}
+			}
+			record("serve", 0)
+			record("export", 3)
+
+			g.Expect(execute(root, tc.args)).To(Equal(tc.expectedCode))
+			g.Expect(ranCommand).To(Equal(tc.expectedCommand))
+			if tc.expectedFlags != nil {
+				g.Expect(ranFlags).To(Equal(tc.expectedFlags))
+			}
+		})
+	}
+}
+
+func TestNewRootCommand(t *testing.T) {
+	g := NewWithT(t)
+
+	root := newRootCommand()
+	names := map[string]bool{}
+	for _, c := range root.Commands() {
+		names[c.Name()] = true
+		g.Expect(c.Short).ToNot(BeEmpty(), c.Name())
+		g.Expect(c.Runnable()).To(BeTrue(), c.Name())
+		for _, flagName := range []string{"kubeconfig", "context", "config", "profile", "v"} {
+			g.Expect(c.Flag(flagName)).ToNot(BeNil(), "flag --%s of %s", flagName, c.Name())
+		}
+	}
+	g.Expect(names).To(HaveKey(defaultCommand))
+	for _, name := range []string{"run-once", "diff", "export", "validate", "what-if", "uninstall", "support-bundle", "alerts", "version"} {
+		g.Expect(names).To(HaveKey(name))
+	}
+
+	// The serve modes take the same flags
+	serve, _, err := root.Find([]string{defaultCommand})
+	g.Expect(err).ToNot(HaveOccurred())
+	runOnce, _, err := root.Find([]string{"run-once"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(runOnce.LocalFlags().HasFlags()).To(BeTrue())
+	serve.LocalFlags().VisitAll(func(f *pflag.Flag) {
+		g.Expect(runOnce.LocalFlags().Lookup(f.Name)).ToNot(BeNil(), f.Name)
+	})
+	g.Expect(serve.Flag(chaosFlag).Hidden).To(BeTrue())
+	g.Expect(serve.UsageString()).ToNot(ContainSubstring("--" + chaosFlag))
+}
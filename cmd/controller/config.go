@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// configAPIVersion is the supported version of the configuration file.
+	configAPIVersion = "capa-annotator.io/v1alpha1"
+	// configKind is the kind of the configuration file.
+	configKind = "ControllerConfiguration"
+)
+
+// controllerConfig is the configuration file of the controller, an alternative to the growing flag surface that is
+// easier to manage via GitOps. Each field sets the flag named by its flag tag, flags set explicitly on the command
+// line take precedence. Omitted fields keep the flag values, which are validated like the flags themselves.
+type controllerConfig struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// Profile selects a preset of tuning values, overridden by the fields of the configuration.
+	Profile *string `json:"profile,omitempty" flag:"profile"`
+
+	Annotations configAnnotations `json:"annotations,omitempty"`
+	Cache       configCache       `json:"cache,omitempty"`
+	Selection   configSelection   `json:"selection,omitempty"`
+	Concurrency configConcurrency `json:"concurrency,omitempty"`
+	AWS         configAWS         `json:"aws,omitempty"`
+}
+
+// configAnnotations configures the annotations written to MachineDeployments.
+type configAnnotations struct {
+	Scheme                      *string          `json:"scheme,omitempty" flag:"annotation-scheme"`
+	AdditionalScheme            *string          `json:"additionalScheme,omitempty" flag:"additional-annotation-scheme"`
+	MigrateFromScheme           *string          `json:"migrateFromScheme,omitempty" flag:"migrate-from-annotation-scheme"`
+	MigrationWindow             *metav1.Duration `json:"migrationWindow,omitempty" flag:"annotation-migration-window"`
+	MemoryUnit                  *string          `json:"memoryUnit,omitempty" flag:"memory-unit"`
+	AdditionalMemoryAnnotation  *string          `json:"additionalMemoryAnnotation,omitempty" flag:"additional-memory-annotation"`
+	AdditionalMemoryUnit        *string          `json:"additionalMemoryUnit,omitempty" flag:"additional-memory-unit"`
+	OmitZeroGPU                 *bool            `json:"omitZeroGPU,omitempty" flag:"omit-zero-gpu"`
+	MaxPodsMode                 *string          `json:"maxPodsMode,omitempty" flag:"max-pods-mode"`
+	EphemeralStorageMode        *string          `json:"ephemeralStorageMode,omitempty" flag:"ephemeral-storage-mode"`
+	AcceleratorLabel            *bool            `json:"acceleratorLabel,omitempty" flag:"accelerator-label"`
+	AcceleratorName             *string          `json:"acceleratorName,omitempty" flag:"accelerator-name"`
+	ArchLabelConflictPolicy     *string          `json:"archLabelConflictPolicy,omitempty" flag:"arch-label-conflict-policy"`
+	ValidateAMIArchitecture     *bool            `json:"validateAMIArchitecture,omitempty" flag:"validate-ami-architecture"`
+	TaintsFromBootstrapTemplate *bool            `json:"taintsFromBootstrapTemplate,omitempty" flag:"taints-from-bootstrap-template"`
+	TaintsSourceAnnotation      *string          `json:"taintsSourceAnnotation,omitempty" flag:"taints-source-annotation"`
+	InstanceTypeAliases         *string          `json:"instanceTypeAliases,omitempty" flag:"instance-type-aliases"`
+	CapacityPostProcessors      *string          `json:"capacityPostProcessors,omitempty" flag:"capacity-post-processors"`
+	BranchInterfaceLimits       *string          `json:"branchInterfaceLimits,omitempty" flag:"branch-interface-limits"`
+	VCPUCorrections             *string          `json:"vcpuCorrections,omitempty" flag:"vcpu-corrections"`
+	CustomAnnotations           *string          `json:"customAnnotations,omitempty" flag:"custom-annotations"`
+}
+
+// configCache configures the instance types cache.
+type configCache struct {
+	TTL          *metav1.Duration `json:"ttl,omitempty" flag:"instance-types-cache-ttl"`
+	MaxEntries   *int             `json:"maxEntries,omitempty" flag:"instance-types-cache-max-entries"`
+	RefreshAhead *metav1.Duration `json:"refreshAhead,omitempty" flag:"instance-types-refresh-ahead"`
+	ConfigMap    *string          `json:"configMap,omitempty" flag:"instance-types-cache-configmap"`
+	File         *string          `json:"file,omitempty" flag:"instance-types-file"`
+	Fallback     *bool            `json:"fallback,omitempty" flag:"instance-types-fallback"`
+}
+
+// configSelection configures the MachineDeployments that are reconciled.
+type configSelection struct {
+	Namespace                 *string  `json:"namespace,omitempty" flag:"namespace"`
+	NamespaceScoped           *bool    `json:"namespaceScoped,omitempty" flag:"namespace-scoped"`
+	ExcludeNamespaces         []string `json:"excludeNamespaces,omitempty" flag:"exclude-namespaces"`
+	MachineDeploymentSelector *string  `json:"machineDeploymentSelector,omitempty" flag:"machinedeployment-selector"`
+	WatchFilter               *string  `json:"watchFilter,omitempty" flag:"watch-filter"`
+	OptIn                     *bool    `json:"optIn,omitempty" flag:"opt-in"`
+}
+
+// configConcurrency configures the reconcile concurrency and the load on the API server.
+type configConcurrency struct {
+	MaxConcurrentReconciles *int             `json:"maxConcurrentReconciles,omitempty" flag:"max-concurrent-reconciles"`
+	SyncPeriod              *metav1.Duration `json:"syncPeriod,omitempty" flag:"sync-period"`
+	KubeAPIQPS              *float64         `json:"kubeAPIQPS,omitempty" flag:"kube-api-qps"`
+	KubeAPIBurst            *int             `json:"kubeAPIBurst,omitempty" flag:"kube-api-burst"`
+}
+
+// configAWS configures the AWS clients.
+type configAWS struct {
+	IdentitySecretNamespace *string          `json:"identitySecretNamespace,omitempty" flag:"aws-identity-secret-namespace"`
+	RoleARNAllowList        []string         `json:"roleARNAllowList,omitempty" flag:"role-arn-allow-list"`
+	ClientTTL               *metav1.Duration `json:"clientTTL,omitempty" flag:"aws-client-ttl"`
+	RetryInitialDelay       *metav1.Duration `json:"retryInitialDelay,omitempty" flag:"aws-retry-initial-delay"`
+	RetryMaxDelay           *metav1.Duration `json:"retryMaxDelay,omitempty" flag:"aws-retry-max-delay"`
+}
+
+// parseConfig parses and validates the YAML of a configuration file.
+func parseConfig(data []byte) (*controllerConfig, error) {
+	config := &controllerConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+	if config.APIVersion != configAPIVersion {
+		return nil, fmt.Errorf("unsupported apiVersion %q of configuration, must be %q", config.APIVersion, configAPIVersion)
+	}
+	if config.Kind != configKind {
+		return nil, fmt.Errorf("unsupported kind %q of configuration, must be %q", config.Kind, configKind)
+	}
+	return config, nil
+}
+
+// applyConfigFile sets the flags of the configuration file that were not set explicitly. It must be called after
+// parsing and before applyProfile, so that the profile does not override the configuration. Fields of flags the
+// command does not define are skipped, e.g. the cache of what-if. An empty path keeps the flag values.
+func applyConfigFile(fs *pflag.FlagSet, path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %w", err)
+	}
+	config, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *pflag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	values, err := configFlagValues(reflect.ValueOf(config).Elem(), "")
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		if fs.Lookup(value.flag) == nil {
+			klog.V(4).Infof("Skipping field %s of configuration, the command has no flag --%s", value.field, value.flag)
+			continue
+		}
+		if explicit[value.flag] {
+			klog.V(2).Infof("Flag --%s overrides field %s of configuration", value.flag, value.field)
+			continue
+		}
+		if err := fs.Set(value.flag, value.value); err != nil {
+			return fmt.Errorf("invalid field %s of configuration: %w", value.field, err)
+		}
+	}
+	klog.Infof("Loaded configuration from %s", path)
+	return nil
+}
+
+// configFlagValue is the value a field of the configuration sets its flag to.
+type configFlagValue struct {
+	field string
+	flag  string
+	value string
+}
+
+// configFlagValues returns the flag values of the set fields of the configuration struct, recursing into the
+// sections. Fields are named by their JSON path, e.g. cache.ttl.
+func configFlagValues(config reflect.Value, prefix string) ([]configFlagValue, error) {
+	values := []configFlagValue{}
+	for i := 0; i < config.NumField(); i++ {
+		field := config.Type().Field(i)
+		name := prefix + strings.Split(field.Tag.Get("json"), ",")[0]
+		value := config.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			sectionValues, err := configFlagValues(value, name+".")
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, sectionValues...)
+			continue
+		}
+		flagName := field.Tag.Get("flag")
+		if flagName == "" || value.IsNil() {
+			continue
+		}
+
+		var flagValue string
+		switch v := value.Interface().(type) {
+		case *string:
+			flagValue = *v
+		case *bool:
+			flagValue = strconv.FormatBool(*v)
+		case *int:
+			flagValue = strconv.Itoa(*v)
+		case *float64:
+			flagValue = strconv.FormatFloat(*v, 'f', -1, 64)
+		case *metav1.Duration:
+			flagValue = v.Duration.String()
+		case []string:
+			flagValue = strings.Join(v, ",")
+		default:
+			return nil, fmt.Errorf("unsupported type %T of field %s of configuration", v, name)
+		}
+		values = append(values, configFlagValue{field: name, flag: flagName, value: flagValue})
+	}
+	return values, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
+)
+
+func TestParseConfig(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      string
+		expectedErr string
+	}{
+		{
+			name: "valid configuration",
+			config: `apiVersion: capa-annotator.io/v1alpha1
+kind: ControllerConfiguration
+cache:
+  ttl: 12h
+`,
+		},
+		{
+			name: "unsupported apiVersion",
+			config: `apiVersion: capa-annotator.io/v2
+kind: ControllerConfiguration
+`,
+			expectedErr: `unsupported apiVersion "capa-annotator.io/v2"`,
+		},
+		{
+			name: "missing kind",
+			config: `apiVersion: capa-annotator.io/v1alpha1
+`,
+			expectedErr: `unsupported kind ""`,
+		},
+		{
+			name: "unknown field",
+			config: `apiVersion: capa-annotator.io/v1alpha1
+kind: ControllerConfiguration
+cache:
+  size: 10
+`,
+			expectedErr: `unknown field "size"`,
+		},
+		{
+			name: "invalid duration",
+			config: `apiVersion: capa-annotator.io/v1alpha1
+kind: ControllerConfiguration
+cache:
+  ttl: tomorrow
+`,
+			expectedErr: "failed to parse configuration",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			_, err := parseConfig([]byte(tc.config))
+			if tc.expectedErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectedErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	g := NewWithT(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	g.Expect(os.WriteFile(path, []byte(`apiVersion: capa-annotator.io/v1alpha1
+kind: ControllerConfiguration
+profile: large
+annotations:
+  scheme: cluster-autoscaler
+cache:
+  ttl: 12h
+selection:
+  excludeNamespaces: [kube-system, "tenant-*"]
+concurrency:
+  maxConcurrentReconciles: 4
+  kubeAPIQPS: 35.5
+`), 0o600)).To(Succeed())
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	annotationFlags := addAnnotationFlags(fs)
+	profile := fs.String("profile", "", "")
+	ttl := fs.Duration("instance-types-cache-ttl", 24*time.Hour, "")
+	excludeNamespaces := fs.String("exclude-namespaces", "", "")
+	concurrency := fs.Int("max-concurrent-reconciles", 1, "")
+	syncPeriod := fs.Duration("sync-period", 10*time.Minute, "")
+	qps := fs.Float64("kube-api-qps", 20, "")
+	fs.Int("kube-api-burst", 30, "")
+	g.Expect(fs.Parse([]string{"--instance-types-cache-ttl=6h"})).To(Succeed())
+
+	g.Expect(applyConfigFile(fs, path)).To(Succeed())
+	g.Expect(applyProfile(fs, *profile)).To(Succeed())
+
+	g.Expect(*annotationFlags.annotationScheme).To(Equal("cluster-autoscaler"))
+	g.Expect(*excludeNamespaces).To(Equal("kube-system,tenant-*"))
+	g.Expect(*qps).To(Equal(35.5))
+	// Explicit flags take precedence over the configuration, which takes precedence over the profile
+	g.Expect(*ttl).To(Equal(6 * time.Hour))
+	g.Expect(*concurrency).To(Equal(4))
+	g.Expect(*syncPeriod).To(Equal(30 * time.Minute))
+
+	g.Expect(os.WriteFile(path, []byte(`apiVersion: capa-annotator.io/v1alpha1
+kind: ControllerConfiguration
+cache:
+  fallback: true
+`), 0o600)).To(Succeed())
+	// Fields of flags the command does not define are skipped
+	g.Expect(applyConfigFile(fs, path)).To(Succeed())
+}
+
+// TestConfigFlagsExist verifies that all fields of the configuration set flags of the controller.
+func TestConfigFlagsExist(t *testing.T) {
+	g := NewWithT(t)
+
+	serve, _, err := newRootCommand().Find([]string{defaultCommand})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var check func(config reflect.Type)
+	check = func(config reflect.Type) {
+		for i := 0; i < config.NumField(); i++ {
+			field := config.Field(i)
+			if field.Type.Kind() == reflect.Struct {
+				check(field.Type)
+				continue
+			}
+			flagName := field.Tag.Get("flag")
+			if flagName == "" {
+				continue
+			}
+			g.Expect(serve.Flag(flagName)).ToNot(BeNil(), "flag --%s of field %s", flagName, field.Name)
+		}
+	}
+	check(reflect.TypeOf(controllerConfig{}))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+// leaderElectionID is the name of the lease of the controller.
+const leaderElectionID = "capa-annotator-leader"
+
+// leaderElectionScope returns the lease name and namespace used for leader election. A namespace-scoped controller
+// watches only its namespace and holds a lease named after it, by default in the watched namespace, so that
+// independent instances in different namespaces do not contend for the same lease. Likewise, a controller with a
+// watch filter holds a lease named after the filter value.
+func leaderElectionScope(namespaceScoped bool, watchNamespace, leaseNamespace, watchFilter string) (string, string, error) {
+	name := leaderElectionID
+	if namespaceScoped {
+		if watchNamespace == "" {
+			return "", "", fmt.Errorf("--namespace must be set for a namespace-scoped controller")
+		}
+		if leaseNamespace == "" {
+			leaseNamespace = watchNamespace
+		}
+		name += "-" + watchNamespace
+	}
+
+	if watchFilter != "" {
+		name += "-" + watchFilter
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return "", "", fmt.Errorf("--watch-filter %q cannot be used in the lease name %q: %s", watchFilter, name, strings.Join(errs, ", "))
+		}
+	}
+	return name, leaseNamespace, nil
+}
+
+// validateLeaderElectionTimings checks the durations of the leader election the way the leader elector does,
+// so that invalid flags fail at startup instead of when the manager starts the election.
+func validateLeaderElectionTimings(leaseDuration, renewDeadline, retryPeriod time.Duration) error {
+	if leaseDuration <= 0 || renewDeadline <= 0 || retryPeriod <= 0 {
+		return fmt.Errorf("--leader-elect-lease-duration, --leader-elect-renew-deadline and --leader-elect-retry-period must be positive")
+	}
+	if leaseDuration <= renewDeadline {
+		return fmt.Errorf("--leader-elect-lease-duration %v must be greater than --leader-elect-renew-deadline %v", leaseDuration, renewDeadline)
+	}
+	if renewDeadline <= time.Duration(leaderelection.JitterFactor*float64(retryPeriod)) {
+		return fmt.Errorf("--leader-elect-renew-deadline %v must be greater than %v times --leader-elect-retry-period %v", renewDeadline, leaderelection.JitterFactor, retryPeriod)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestLeaderElectionScope(t *testing.T) {
+	testCases := []struct {
+		name              string
+		namespaceScoped   bool
+		watchNamespace    string
+		leaseNamespace    string
+		watchFilter       string
+		expectedName      string
+		expectedNamespace string
+		expectErr         bool
+	}{
+		{
+			name:              "cluster-scoped",
+			watchNamespace:    "team-a",
+			expectedName:      "capa-annotator-leader",
+			expectedNamespace: "",
+		},
+		{
+			name:              "cluster-scoped with lease namespace",
+			leaseNamespace:    "capa-annotator-system",
+			expectedName:      "capa-annotator-leader",
+			expectedNamespace: "capa-annotator-system",
+		},
+		{
+			name:              "namespace-scoped",
+			namespaceScoped:   true,
+			watchNamespace:    "team-a",
+			expectedName:      "capa-annotator-leader-team-a",
+			expectedNamespace: "team-a",
+		},
+		{
+			name:              "namespace-scoped with shared lease namespace",
+			namespaceScoped:   true,
+			watchNamespace:    "team-b",
+			leaseNamespace:    "capa-annotator-system",
+			expectedName:      "capa-annotator-leader-team-b",
+			expectedNamespace: "capa-annotator-system",
+		},
+		{
+			name:              "watch filter",
+			watchFilter:       "shard-1",
+			expectedName:      "capa-annotator-leader-shard-1",
+			expectedNamespace: "",
+		},
+		{
+			name:              "namespace-scoped with watch filter",
+			namespaceScoped:   true,
+			watchNamespace:    "team-a",
+			watchFilter:       "canary",
+			expectedName:      "capa-annotator-leader-team-a-canary",
+			expectedNamespace: "team-a",
+		},
+		{
+			name:        "watch filter not valid in lease names",
+			watchFilter: "Shard_1",
+			expectErr:   true,
+		},
+		{
+			name:            "namespace-scoped without namespace",
+			namespaceScoped: true,
+			expectErr:       true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			name, namespace, err := leaderElectionScope(tc.namespaceScoped, tc.watchNamespace, tc.leaseNamespace, tc.watchFilter)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(name).To(Equal(tc.expectedName))
+			g.Expect(namespace).To(Equal(tc.expectedNamespace))
+		})
+	}
+}
+
+func TestValidateLeaderElectionTimings(t *testing.T) {
+	testCases := []struct {
+		name          string
+		leaseDuration time.Duration
+		renewDeadline time.Duration
+		retryPeriod   time.Duration
+		expectErr     bool
+	}{
+		{
+			name:          "defaults",
+			leaseDuration: leaseDuration,
+			renewDeadline: renewDeadline,
+			retryPeriod:   retryPeriod,
+		},
+		{
+			name:          "renew deadline not shorter than lease duration",
+			leaseDuration: 60 * time.Second,
+			renewDeadline: 60 * time.Second,
+			retryPeriod:   10 * time.Second,
+			expectErr:     true,
+		},
+		{
+			name:          "retry period too long for renew deadline",
+			leaseDuration: 60 * time.Second,
+			renewDeadline: 30 * time.Second,
+			retryPeriod:   25 * time.Second,
+			expectErr:     true,
+		},
+		{
+			name:          "zero retry period",
+			leaseDuration: 60 * time.Second,
+			renewDeadline: 30 * time.Second,
+			expectErr:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := validateLeaderElectionTimings(tc.leaseDuration, tc.renewDeadline, tc.retryPeriod)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	machinesetcontroller "github.com/jhjaggars/capa-annotator/pkg/controller"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// newExportCommand returns the export subcommand. It writes the capacities of the instance types offered in a
+// region, for generating the dataset of --instance-types-file in air-gapped environments and for capacity planning.
+func newExportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the capacities of the instance types of a region",
+		Args:  cobra.NoArgs,
+	}
+	fs := cmd.Flags()
+	region := fs.String(
+		"region",
+		"",
+		"The AWS region of the instance types.",
+	)
+	format := fs.String(
+		"format",
+		machinesetcontroller.ExportFormatYAML,
+		"Output format, \"yaml\" or \"json\" for a dataset of --instance-types-file, or \"csv\" for a table.",
+	)
+	output := fs.String(
+		"output",
+		"",
+		"Path of the file to write, standard output if empty.",
+	)
+
+	cmd.RunE = func(*cobra.Command, []string) error {
+		switch *format {
+		case machinesetcontroller.ExportFormatYAML, machinesetcontroller.ExportFormatJSON, machinesetcontroller.ExportFormatCSV:
+		default:
+			klog.Errorf("Invalid --format %q, must be one of yaml, json or csv", *format)
+			return exitCode(1)
+		}
+
+		r := &machinesetcontroller.Reconciler{
+			AwsClientBuilder: awsclient.NewValidatedClient,
+			RegionCache:      awsclient.NewRegionCache(),
+		}
+		dataset, err := r.ExportInstanceTypes(*region)
+		if err != nil {
+			klog.Errorf("Error exporting instance types: %v", err)
+			return exitCode(1)
+		}
+		data, err := machinesetcontroller.MarshalInstanceTypesDataset(dataset, *format)
+		if err != nil {
+			klog.Errorf("Error encoding instance types: %v", err)
+			return exitCode(1)
+		}
+
+		if *output == "" {
+			_, err = os.Stdout.Write(data)
+		} else {
+			err = os.WriteFile(*output, data, 0o644)
+		}
+		if err != nil {
+			klog.Errorf("Error writing instance types: %v", err)
+			return exitCode(1)
+		}
+		return nil
+	}
+	return cmd
+}
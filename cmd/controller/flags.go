@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"time"
+
+	machinesetcontroller "github.com/jhjaggars/capa-annotator/pkg/controller"
+	"github.com/spf13/pflag"
+)
+
+// annotationFlags holds the flags that influence which annotations are written.
+// They are shared between the controller and the subcommands computing annotations.
+type annotationFlags struct {
+	memoryUnit                 *string
+	additionalMemoryAnnotation *string
+	additionalMemoryUnit       *string
+	omitZeroGPU                *bool
+	annotationScheme           *string
+	additionalScheme           *string
+	migrateFromScheme          *string
+	migrationWindow            *time.Duration
+	instanceTypeAliases        *string
+	capacityPostProcessors     *string
+	maxPodsMode                *string
+	branchInterfaceLimits      *string
+	vcpuCorrections            *string
+	ephemeralStorageMode       *string
+	acceleratorLabel           *bool
+	acceleratorName            *string
+	customAnnotations          *string
+	archConflictPolicy         *string
+	validateAMIArchitecture    *bool
+	taintsFromBootstrap        *bool
+	taintsSourceAnnotation     *string
+}
+
+// addAnnotationFlags registers the annotation flags on the given flag set.
+func addAnnotationFlags(fs *pflag.FlagSet) *annotationFlags {
+	return &annotationFlags{
+		memoryUnit: fs.String(
+			"memory-unit",
+			string(machinesetcontroller.MemoryUnitMiB),
+			"Unit of the memory annotation. One of MiB (plain number of mebibytes), Mi, Ki (Kubernetes quantities) or bytes.",
+		),
+		additionalMemoryAnnotation: fs.String(
+			"additional-memory-annotation",
+			"",
+			"Optional second annotation key that receives the memory in --additional-memory-unit, e.g. to serve multiple consumers during a transition.",
+		),
+		additionalMemoryUnit: fs.String(
+			"additional-memory-unit",
+			string(machinesetcontroller.MemoryUnitBytes),
+			"Unit of the --additional-memory-annotation. One of MiB, Mi, Ki or bytes.",
+		),
+		omitZeroGPU: fs.Bool(
+			"omit-zero-gpu",
+			false,
+			"Omit the GPU annotation for instance types without GPUs instead of writing \"0\". A previously written GPU annotation is removed.",
+		),
+		annotationScheme: fs.String(
+			"annotation-scheme",
+			string(machinesetcontroller.AnnotationSchemeOpenShift),
+			"Capacity annotation keys to write. One of openshift (machine.openshift.io) or cluster-autoscaler (capacity.cluster-autoscaler.kubernetes.io).",
+		),
+		additionalScheme: fs.String(
+			"additional-annotation-scheme",
+			"",
+			"Optional annotation scheme written in addition to --annotation-scheme, e.g. cluster-autoscaler to serve the upstream cluster-autoscaler next to the machine.openshift.io keys.",
+		),
+		migrateFromScheme: fs.String(
+			"migrate-from-annotation-scheme",
+			"",
+			"Annotation scheme being migrated from. Both schemes are written for --annotation-migration-window, afterwards the keys of the old scheme are removed.",
+		),
+		migrationWindow: fs.Duration(
+			"annotation-migration-window",
+			7*24*time.Hour,
+			"Duration both annotation schemes are written while migrating. Only applicable if --migrate-from-annotation-scheme is set.",
+		),
+		instanceTypeAliases: fs.String(
+			"instance-type-aliases",
+			"",
+			"Path to a YAML file mapping instance type names unknown to the EC2 API, e.g. of AWS Outposts or private offers, to a canonical instance type or an explicit capacity. The aliases are consulted before the EC2 API.",
+		),
+		capacityPostProcessors: fs.String(
+			"capacity-post-processors",
+			"",
+			"Comma-separated post-processors adjusting the capacity before it is written, applied in order, e.g. \"reserve-memory=512,max-gpu=4\". One of reserve-cpu (vCPUs), reserve-memory (MiB) or max-gpu.",
+		),
+		maxPodsMode: fs.String(
+			"max-pods-mode",
+			"",
+			"Calculation of the capacity.cluster-autoscaler.kubernetes.io/maxPods annotation. One of eni (VPC CNI), eni-trunking (VPC CNI with security groups for pods) or eni-prefix (VPC CNI with prefix delegation). Unset does not write the annotation.",
+		),
+		branchInterfaceLimits: fs.String(
+			"branch-interface-limits",
+			"",
+			"Path to a YAML file mapping instance types to their maximum number of branch interfaces, which the EC2 API does not expose. Required by --max-pods-mode=eni-trunking.",
+		),
+		ephemeralStorageMode: fs.String(
+			"ephemeral-storage-mode",
+			"",
+			"Source of the capacity.cluster-autoscaler.kubernetes.io/ephemeral-disk annotation. One of root-volume (size of the root volume of the AWSMachineTemplate) or instance-store (NVMe instance store volumes, falling back to the root volume). Unset does not write the annotation.",
+		),
+		acceleratorLabel: fs.Bool(
+			"accelerator-label",
+			false,
+			"Add the cluster-api/accelerator label to the labels annotation of instance types with GPUs, so that GPU workloads selecting nodes by the label can trigger a scale from zero.",
+		),
+		acceleratorName: fs.String(
+			"accelerator-name",
+			"",
+			"Value of the cluster-api/accelerator label for all instance types with GPUs. Defaults to the GPU manufacturer and name of the instance type, e.g. nvidia-t4. Only applicable with --accelerator-label.",
+		),
+		archConflictPolicy: fs.String(
+			"arch-label-conflict-policy",
+			string(machinesetcontroller.ArchConflictPolicyAWS),
+			"Handling of a kubernetes.io/arch label in spec.template.metadata.labels of MachineDeployments conflicting with the architecture of the instance type. One of aws (write the architecture of the instance type), user (write the architecture of the label) or error (emit an ArchitectureConflict event instead of setting the annotations).",
+		),
+		customAnnotations: fs.String(
+			"custom-annotations",
+			"",
+			"Path to a YAML file mapping additional annotation keys to Go templates rendered over the capacity of the instance type, e.g. \"{{ .VCPU }}x{{ .MemoryGiB }}GiB\".",
+		),
+		validateAMIArchitecture: fs.Bool(
+			"validate-ami-architecture",
+			false,
+			"Cross-check the architecture of the AMI of AWSMachineTemplates referencing an AMI by ID against the architecture of the instance type, and emit an AMIArchitectureMismatch warning event on mismatches. Requires the ec2:DescribeImages permission.",
+		),
+		taintsFromBootstrap: fs.Bool(
+			"taints-from-bootstrap-template",
+			false,
+			"Write the taints of the node registration of the KubeadmConfigTemplate of MachineDeployments to the capacity.cluster-autoscaler.kubernetes.io/taints annotation.",
+		),
+		taintsSourceAnnotation: fs.String(
+			"taints-source-annotation",
+			"",
+			"Optional annotation of MachineDeployments holding taints in the format \"key=value:NoSchedule,key:NoExecute\", written to the capacity.cluster-autoscaler.kubernetes.io/taints annotation. Takes precedence over --taints-from-bootstrap-template for taints with the same key and effect.",
+		),
+		vcpuCorrections: fs.String(
+			"vcpu-corrections",
+			"",
+			"Path to a YAML file mapping instance types to the number of vCPUs their nodes report to the kubelet, written instead of the vCPUs reported by the EC2 API, e.g. for bare metal instance types.",
+		),
+	}
+}
+
+// apply validates the annotation flags and configures the reconciler accordingly.
+// The InstanceTypesCache of the reconciler must already be set, it is wrapped if instance type aliases are configured.
+func (f *annotationFlags) apply(r *machinesetcontroller.Reconciler) error {
+	memoryUnit, err := machinesetcontroller.ParseMemoryUnit(*f.memoryUnit)
+	if err != nil {
+		return fmt.Errorf("invalid --memory-unit: %w", err)
+	}
+
+	additionalMemoryUnit, err := machinesetcontroller.ParseMemoryUnit(*f.additionalMemoryUnit)
+	if err != nil {
+		return fmt.Errorf("invalid --additional-memory-unit: %w", err)
+	}
+
+	if err := machinesetcontroller.ValidateAdditionalMemoryKey(*f.additionalMemoryAnnotation); err != nil {
+		return fmt.Errorf("invalid --additional-memory-annotation: %w", err)
+	}
+
+	annotationScheme, err := machinesetcontroller.ParseAnnotationScheme(*f.annotationScheme)
+	if err != nil {
+		return fmt.Errorf("invalid --annotation-scheme: %w", err)
+	}
+
+	var additionalScheme machinesetcontroller.AnnotationScheme
+	if *f.additionalScheme != "" {
+		additionalScheme, err = machinesetcontroller.ParseAnnotationScheme(*f.additionalScheme)
+		if err != nil {
+			return fmt.Errorf("invalid --additional-annotation-scheme: %w", err)
+		}
+	}
+
+	var migrateFromScheme machinesetcontroller.AnnotationScheme
+	if *f.migrateFromScheme != "" {
+		migrateFromScheme, err = machinesetcontroller.ParseAnnotationScheme(*f.migrateFromScheme)
+		if err != nil {
+			return fmt.Errorf("invalid --migrate-from-annotation-scheme: %w", err)
+		}
+		if *f.migrationWindow <= 0 {
+			return fmt.Errorf("--annotation-migration-window must be positive")
+		}
+	}
+
+	if *f.instanceTypeAliases != "" {
+		aliases, err := machinesetcontroller.LoadInstanceTypeAliases(*f.instanceTypeAliases)
+		if err != nil {
+			return fmt.Errorf("invalid --instance-type-aliases: %w", err)
+		}
+		r.InstanceTypesCache = machinesetcontroller.NewAliasedInstanceTypesCache(r.InstanceTypesCache, aliases)
+	}
+
+	capacityPostProcessors, err := machinesetcontroller.ParseCapacityPostProcessors(*f.capacityPostProcessors)
+	if err != nil {
+		return fmt.Errorf("invalid --capacity-post-processors: %w", err)
+	}
+
+	maxPodsMode, err := machinesetcontroller.ParseMaxPodsMode(*f.maxPodsMode)
+	if err != nil {
+		return fmt.Errorf("invalid --max-pods-mode: %w", err)
+	}
+
+	var branchInterfaceLimits map[string]int64
+	switch {
+	case *f.branchInterfaceLimits != "" && maxPodsMode != machinesetcontroller.MaxPodsModeENITrunking:
+		return fmt.Errorf("--branch-interface-limits is only applicable with --max-pods-mode=%s", machinesetcontroller.MaxPodsModeENITrunking)
+	case *f.branchInterfaceLimits != "":
+		branchInterfaceLimits, err = machinesetcontroller.LoadBranchInterfaceLimits(*f.branchInterfaceLimits)
+		if err != nil {
+			return fmt.Errorf("invalid --branch-interface-limits: %w", err)
+		}
+	case maxPodsMode == machinesetcontroller.MaxPodsModeENITrunking:
+		return fmt.Errorf("--max-pods-mode=%s requires --branch-interface-limits", machinesetcontroller.MaxPodsModeENITrunking)
+	}
+
+	ephemeralStorageMode, err := machinesetcontroller.ParseEphemeralStorageMode(*f.ephemeralStorageMode)
+	if err != nil {
+		return fmt.Errorf("invalid --ephemeral-storage-mode: %w", err)
+	}
+
+	var vcpuCorrections map[string]int64
+	if *f.vcpuCorrections != "" {
+		vcpuCorrections, err = machinesetcontroller.LoadVCPUCorrections(*f.vcpuCorrections)
+		if err != nil {
+			return fmt.Errorf("invalid --vcpu-corrections: %w", err)
+		}
+	}
+
+	if *f.acceleratorName != "" {
+		if !*f.acceleratorLabel {
+			return fmt.Errorf("--accelerator-name is only applicable with --accelerator-label")
+		}
+		if err := machinesetcontroller.ValidateAcceleratorName(*f.acceleratorName); err != nil {
+			return fmt.Errorf("invalid --accelerator-name: %w", err)
+		}
+	}
+
+	var customAnnotations []machinesetcontroller.CustomAnnotation
+	if *f.customAnnotations != "" {
+		customAnnotations, err = machinesetcontroller.LoadCustomAnnotations(*f.customAnnotations)
+		if err != nil {
+			return fmt.Errorf("invalid --custom-annotations: %w", err)
+		}
+	}
+
+	archConflictPolicy, err := machinesetcontroller.ParseArchConflictPolicy(*f.archConflictPolicy)
+	if err != nil {
+		return fmt.Errorf("invalid --arch-label-conflict-policy: %w", err)
+	}
+
+	if err := machinesetcontroller.ValidateTaintsSourceAnnotation(*f.taintsSourceAnnotation); err != nil {
+		return fmt.Errorf("invalid --taints-source-annotation: %w", err)
+	}
+
+	r.MemoryUnit = memoryUnit
+	r.AdditionalMemoryKey = *f.additionalMemoryAnnotation
+	r.AdditionalMemoryUnit = additionalMemoryUnit
+	r.OmitZeroGPU = *f.omitZeroGPU
+	r.AnnotationScheme = annotationScheme
+	r.AdditionalAnnotationScheme = additionalScheme
+	r.MigrateFromAnnotationScheme = migrateFromScheme
+	r.MigrationWindow = *f.migrationWindow
+	r.MaxPodsMode = maxPodsMode
+	r.BranchInterfaceLimits = branchInterfaceLimits
+	r.VCPUCorrections = vcpuCorrections
+	r.EphemeralStorageMode = ephemeralStorageMode
+	r.AcceleratorLabel = *f.acceleratorLabel
+	r.AcceleratorName = *f.acceleratorName
+	r.CustomAnnotations = customAnnotations
+	r.ArchConflictPolicy = archConflictPolicy
+	r.ValidateAMIArchitecture = *f.validateAMIArchitecture
+	r.TaintsFromBootstrapTemplate = *f.taintsFromBootstrap
+	r.TaintsSourceAnnotation = *f.taintsSourceAnnotation
+	r.AnnotationConfigHash = f.hash()
+	// Post-processors registered via the library API run before the built-in ones
+	r.CapacityPostProcessors = append(r.CapacityPostProcessors, capacityPostProcessors...)
+	return nil
+}
+
+// chaosFlag enables the chaos mode, a developer mode hidden from the usage.
+const chaosFlag = "chaos"
+
+// hash returns a hash of the values of the annotation flags, so that lookups skipped with
+// --skip-unchanged-lookups are repeated once the flags changed. Files referenced by the flags are not hashed.
+func (f *annotationFlags) hash() string {
+	h := sha256.New()
+	v := reflect.ValueOf(f).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fmt.Fprintf(h, "%s=%v\n", v.Type().Field(i).Name, v.Field(i).Elem().Interface())
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// kubeconfigFlags select the cluster and the identity of the Kubernetes API client, so that the controller and
+// its subcommands can be run against any management cluster from outside of it.
+type kubeconfigFlags struct {
+	kubeconfig *string
+	context    *string
+	as         *string
+	asGroups   []string
+	asUID      *string
+}
+
+// addKubeconfigFlags adds the flags of the Kubernetes API client to the flag set, named like the kubeconfig flag
+// of controller-runtime.
+func addKubeconfigFlags(fs *pflag.FlagSet) *kubeconfigFlags {
+	f := &kubeconfigFlags{}
+	f.kubeconfig = fs.String(
+		config.KubeconfigFlagName,
+		"",
+		"Paths to a kubeconfig. Only required if out-of-cluster.",
+	)
+	f.context = fs.String(
+		"context",
+		"",
+		"The kubeconfig context to use. Defaults to the current context of the kubeconfig.",
+	)
+	f.as = fs.String(
+		"as",
+		"",
+		"Username to impersonate for the requests to the Kubernetes API.",
+	)
+	fs.Func(
+		"as-group",
+		"Group to impersonate for the requests to the Kubernetes API, can be repeated. Requires --as.",
+		func(group string) error {
+			f.asGroups = append(f.asGroups, group)
+			return nil
+		},
+	)
+	f.asUID = fs.String(
+		"as-uid",
+		"",
+		"UID to impersonate for the requests to the Kubernetes API. Requires --as.",
+	)
+	return f
+}
+
+// restConfig returns the configuration of the Kubernetes API client. Without --kubeconfig, the configuration is
+// loaded like by controller-runtime: from the KUBECONFIG environment variable, the in-cluster configuration or
+// the kubeconfig in the home directory.
+func (f *kubeconfigFlags) restConfig() (*rest.Config, error) {
+	if *f.as == "" && (len(f.asGroups) > 0 || *f.asUID != "") {
+		return nil, errors.New("--as-group and --as-uid require --as")
+	}
+
+	var cfg *rest.Config
+	var err error
+	if path := *f.kubeconfig; path != "" {
+		cfg, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
+			&clientcmd.ConfigOverrides{CurrentContext: *f.context},
+		).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig %s: %w", path, err)
+		}
+	} else {
+		cfg, err = config.GetConfigWithContext(*f.context)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if *f.as != "" {
+		cfg.Impersonate = rest.ImpersonationConfig{
+			UserName: *f.as,
+			UID:      *f.asUID,
+			Groups:   f.asGroups,
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/pflag"
+)
+
+// profiles are bundles of tuning flag values for common deployment sizes. Flags set explicitly on the
+// command line take precedence over the values of the selected profile.
+var profiles = map[string]map[string]string{
+	// small suits clusters with up to a few dozen MachineDeployments. These are the flag defaults.
+	"small": {
+		"max-concurrent-reconciles": "1",
+		"sync-period":               "10m",
+		"instance-types-cache-ttl":  "24h",
+		"kube-api-qps":              "20",
+		"kube-api-burst":            "30",
+	},
+	// large suits management clusters with hundreds of MachineDeployments.
+	"large": {
+		"max-concurrent-reconciles": "10",
+		"sync-period":               "30m",
+		"instance-types-cache-ttl":  "24h",
+		"kube-api-qps":              "50",
+		"kube-api-burst":            "100",
+	},
+	// airgapped suits clusters without access to the EC2 API. Instance types are served from the dataset of
+	// --instance-types-file, which the profile requires, and the cached instance types are refreshed rarely.
+	"airgapped": {
+		"max-concurrent-reconciles": "1",
+		"sync-period":               "1h",
+		"instance-types-cache-ttl":  "168h",
+		"kube-api-qps":              "20",
+		"kube-api-burst":            "30",
+	},
+}
+
+// profileRequiredFlags are the flags a profile cannot preset, but requires to be set explicitly or by the
+// configuration file.
+var profileRequiredFlags = map[string][]string{
+	"airgapped": {"instance-types-file"},
+}
+
+// profileNames returns the sorted names of the profiles.
+func profileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyProfile sets the flags of the named profile that were not set explicitly, and checks that the flags
+// required by the profile are set. It must be called after parsing. Flags the command does not define are
+// skipped. An empty name keeps the flag defaults.
+func applyProfile(fs *pflag.FlagSet, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	values, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, must be one of %q", name, profileNames())
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *pflag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for flagName, value := range values {
+		if explicit[flagName] || fs.Lookup(flagName) == nil {
+			continue
+		}
+		if err := fs.Set(flagName, value); err != nil {
+			return fmt.Errorf("error setting --%s of profile %q: %w", flagName, name, err)
+		}
+	}
+
+	for _, flagName := range profileRequiredFlags[name] {
+		if !explicit[flagName] && fs.Lookup(flagName) != nil {
+			return fmt.Errorf("profile %q requires --%s", name, flagName)
+		}
+	}
+	return nil
+}
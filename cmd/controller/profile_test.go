@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
+)
+
+func TestApplyProfile(t *testing.T) {
+	testCases := []struct {
+		name                string
+		args                []string
+		profile             string
+		expectedConcurrency int
+		expectedSyncPeriod  time.Duration
+		expectedTTL         time.Duration
+		expectErr           bool
+	}{
+		{
+			name:                "no profile",
+			expectedConcurrency: 1,
+			expectedSyncPeriod:  10 * time.Minute,
+			expectedTTL:         24 * time.Hour,
+		},
+		{
+			name:                "large profile",
+			profile:             "large",
+			expectedConcurrency: 10,
+			expectedSyncPeriod:  30 * time.Minute,
+			expectedTTL:         24 * time.Hour,
+		},
+		{
+			name:                "explicit flags take precedence",
+			args:                []string{"--max-concurrent-reconciles=4", "--sync-period=10m", "--instance-types-file=instance-types.yaml"},
+			profile:             "airgapped",
+			expectedConcurrency: 4,
+			expectedSyncPeriod:  10 * time.Minute,
+			expectedTTL:         168 * time.Hour,
+		},
+		{
+			name:      "airgapped profile without an instance types file",
+			profile:   "airgapped",
+			expectErr: true,
+		},
+		{
+			name:      "unknown profile",
+			profile:   "huge",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			concurrency := fs.Int("max-concurrent-reconciles", 1, "")
+			syncPeriod := fs.Duration("sync-period", 10*time.Minute, "")
+			ttl := fs.Duration("instance-types-cache-ttl", 24*time.Hour, "")
+			fs.Float64("kube-api-qps", 20, "")
+			fs.Int("kube-api-burst", 30, "")
+			fs.String("instance-types-file", "", "")
+			g.Expect(fs.Parse(tc.args)).To(Succeed())
+
+			err := applyProfile(fs, tc.profile)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(*concurrency).To(Equal(tc.expectedConcurrency))
+			g.Expect(*syncPeriod).To(Equal(tc.expectedSyncPeriod))
+			g.Expect(*ttl).To(Equal(tc.expectedTTL))
+		})
+	}
+}
@@ -0,0 +1,756 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	"github.com/jhjaggars/capa-annotator/pkg/client/azure"
+	"github.com/jhjaggars/capa-annotator/pkg/client/gcp"
+	machinesetcontroller "github.com/jhjaggars/capa-annotator/pkg/controller"
+	"github.com/jhjaggars/capa-annotator/pkg/httpserver"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	"github.com/jhjaggars/capa-annotator/pkg/version"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/textlogger"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	expv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// The modes of --crd-compatibility.
+const (
+	crdCompatibilityEnforce  = "enforce"
+	crdCompatibilityReadOnly = "read-only"
+)
+
+// The default durations for the leader election operations.
+var (
+	leaseDuration = 120 * time.Second
+	renewDeadline = 110 * time.Second
+	retryPeriod   = 20 * time.Second
+)
+
+// serveMode is the mode the controller is run in by runServe.
+type serveMode int
+
+const (
+	// serveController runs the controller until it is stopped.
+	serveController serveMode = iota
+	// serveRunOnce annotates all MachineDeployments once and exits.
+	serveRunOnce
+	// serveDiff prints the annotation changes of all MachineDeployments without writing them.
+	serveDiff
+)
+
+// newServeCommand returns a subcommand running the controller in the given mode with the flags of serve.
+func newServeCommand(name, short string, mode serveMode, root *rootFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   name,
+		Short: short,
+		Args:  cobra.NoArgs,
+	}
+	f := addServeFlags(cmd.Flags())
+
+	cmd.RunE = func(cmd *cobra.Command, _ []string) error {
+		return exit(runServe(mode, f, root.kubeconfig, cmd.Flags()))
+	}
+	return cmd
+}
+
+// runServe implements the serve, run-once and diff subcommands. The /debug/config endpoint serves the flags fs
+// of the command.
+func runServe(mode serveMode, f *serveFlags, kubeconfig *kubeconfigFlags, fs *pflag.FlagSet) int {
+	if *f.printVersion {
+		fmt.Println(version.String)
+		return 0
+	}
+
+	crossNamespaceTemplateRules, err := machinesetcontroller.ParseCrossNamespaceRules(*f.crossNamespaceTemplateAllowList)
+	if err != nil {
+		klog.Fatalf("Invalid --cross-namespace-template-allow-list: %v", err)
+	}
+	// A namespace-scoped controller cannot read templates in other namespaces
+	if *f.namespaceScoped {
+		if len(crossNamespaceTemplateRules) > 0 {
+			klog.Fatal("--cross-namespace-template-allow-list cannot be used with --namespace-scoped")
+		}
+		*f.denyCrossNamespaceTemplates = true
+	}
+
+	// The run-once and diff subcommands imply --run-once, a diff runs it with dry runs
+	if mode != serveController {
+		*f.runOnce = true
+	}
+	if *f.runOnce {
+		if *f.annotateControlPlanes || *f.annotateMachinePools || *f.annotateManagedMachinePools || *f.annotateMachineSets || *f.annotateClusterSummary {
+			klog.Fatal("--run-once only annotates MachineDeployments and cannot be combined with the --annotate-* flags")
+		}
+		if *f.webhookPort != 0 || *f.runtimeExtensionPort != 0 {
+			klog.Fatal("--run-once cannot be combined with --webhook-port or --runtime-extension-port")
+		}
+		if *f.workloadClusterSelector != "" {
+			klog.Fatal("--run-once cannot be combined with --workload-cluster-selector")
+		}
+		*f.leaderElect = false
+	}
+
+	if *f.runtimeExtensionOnly {
+		if *f.runtimeExtensionPort == 0 {
+			klog.Fatal("--runtime-extension-only requires --runtime-extension-port")
+		}
+		if *f.runOnce {
+			klog.Fatal("--runtime-extension-only cannot be combined with --run-once")
+		}
+		if *f.annotateControlPlanes || *f.annotateMachinePools || *f.annotateManagedMachinePools || *f.annotateMachineSets || *f.annotateClusterSummary {
+			klog.Fatal("--runtime-extension-only cannot be combined with the --annotate-* flags")
+		}
+		if *f.workloadClusterSelector != "" {
+			klog.Fatal("--runtime-extension-only cannot be combined with --workload-cluster-selector")
+		}
+	}
+
+	if (*f.namespacePatchBudget > 0 || *f.namespaceWarningEventBudget > 0) && *f.namespaceBudgetWindow <= 0 {
+		klog.Fatal("--namespace-budget-window must be positive")
+	}
+
+	if *f.retryBudget > 0 && (*f.retryBudgetWindow <= 0 || *f.retryBackoff <= 0) {
+		klog.Fatal("--retry-budget-window and --retry-backoff must be positive")
+	}
+
+	if *f.awsRetryInitialDelay < 0 || (*f.awsRetryInitialDelay > 0 && *f.awsRetryMaxDelay < *f.awsRetryInitialDelay) {
+		klog.Fatal("--aws-retry-initial-delay must not be negative and --aws-retry-max-delay must not be below it")
+	}
+
+	roleARNPatterns, err := machinesetcontroller.ParseRoleARNPatterns(*f.roleARNAllowList)
+	if err != nil {
+		klog.Fatalf("Invalid --role-arn-allow-list: %v", err)
+	}
+
+	excludedNamespaces, err := machinesetcontroller.ParseNamespacePatterns(*f.excludeNamespaces)
+	if err != nil {
+		klog.Fatalf("Invalid --exclude-namespaces: %v", err)
+	}
+
+	coverageWindows, err := machinesetcontroller.ParseCoverageWindows(*f.coverageSLOWindows)
+	if err != nil {
+		klog.Fatalf("Invalid --coverage-slo-windows: %v", err)
+	}
+	if *f.coverageSLOInterval > 0 && (*f.coverageSLOTarget <= 0 || *f.coverageSLOTarget >= 1) {
+		klog.Fatal("--coverage-slo-target must be between 0 and 1")
+	}
+
+	var chaos *machinesetcontroller.Chaos
+	if *f.chaosSpec != "" {
+		chaos, err = machinesetcontroller.ParseChaos(*f.chaosSpec)
+		if err != nil {
+			klog.Fatalf("Invalid --chaos: %v", err)
+		}
+		klog.Warningf("Chaos mode enabled, injecting faults at %s. Do not use in production.", chaos)
+	}
+
+	var typePolicy *machinesetcontroller.InstanceTypePolicy
+	if *f.instanceTypePolicy != "" {
+		typePolicy, err = machinesetcontroller.LoadInstanceTypePolicy(*f.instanceTypePolicy)
+		if err != nil {
+			klog.Fatalf("Invalid --instance-type-policy: %v", err)
+		}
+	} else if *f.webhookPort != 0 {
+		klog.Fatal("--webhook-port requires --instance-type-policy")
+	}
+
+	if *f.crdCompatibility != crdCompatibilityEnforce && *f.crdCompatibility != crdCompatibilityReadOnly {
+		klog.Fatalf("Invalid --crd-compatibility %q, must be %q or %q", *f.crdCompatibility, crdCompatibilityEnforce, crdCompatibilityReadOnly)
+	}
+
+	if *f.leaderElect {
+		if err := validateLeaderElectionTimings(*f.leaderElectLeaseDuration, *f.leaderElectRenewDeadline, *f.leaderElectRetryPeriod); err != nil {
+			klog.Fatalf("Invalid leader election flags: %v", err)
+		}
+	}
+
+	// A burst below 1 would block every request of the rate limited client
+	if *f.kubeAPIQPS <= 0 || *f.kubeAPIBurst < 1 {
+		klog.Fatalf("Invalid --kube-api-qps %v or --kube-api-burst %d, must be positive", *f.kubeAPIQPS, *f.kubeAPIBurst)
+	}
+
+	if *f.instanceTypesCacheMaxEntries < 0 {
+		klog.Fatalf("Invalid --instance-types-cache-max-entries %d, must not be negative", *f.instanceTypesCacheMaxEntries)
+	}
+
+	var mdSelector labels.Selector
+	if *f.machineDeploymentSelector != "" {
+		mdSelector, err = labels.Parse(*f.machineDeploymentSelector)
+		if err != nil {
+			klog.Fatalf("Invalid --machinedeployment-selector: %v", err)
+		}
+	}
+
+	var clusterSelector labels.Selector
+	if *f.workloadClusterSelector != "" {
+		clusterSelector, err = labels.Parse(*f.workloadClusterSelector)
+		if err != nil {
+			klog.Fatalf("Invalid --workload-cluster-selector: %v", err)
+		}
+		if *f.workloadClusterInterval <= 0 {
+			klog.Fatal("--workload-cluster-interval must be positive")
+		}
+	}
+
+	var cacheConfigMap client.ObjectKey
+	if *f.instanceTypesCacheConfigMap != "" {
+		namespace, name, ok := strings.Cut(*f.instanceTypesCacheConfigMap, "/")
+		if !ok || namespace == "" || name == "" {
+			klog.Fatalf("Invalid --instance-types-cache-configmap %q, must be <namespace>/<name>", *f.instanceTypesCacheConfigMap)
+		}
+		cacheConfigMap = client.ObjectKey{Namespace: namespace, Name: name}
+	}
+
+	if *f.instanceTypesRefreshAhead < 0 || (*f.instanceTypesRefreshAhead > 0 && *f.instanceTypesRefreshAhead >= *f.instanceTypesCacheTTL) {
+		klog.Fatalf("Invalid --instance-types-refresh-ahead %v, must be shorter than --instance-types-cache-ttl %v", *f.instanceTypesRefreshAhead, *f.instanceTypesCacheTTL)
+	}
+
+	if *f.metricsNamespaces != "" {
+		metrics.SetNamespaceAllowList(strings.Split(*f.metricsNamespaces, ","))
+	}
+
+	// Get a config to talk to the apiserver
+	cfg, err := kubeconfig.restConfig()
+	if err != nil {
+		klog.Fatalf("Error getting configuration: %v", err)
+	}
+	cfg.QPS = float32(*f.kubeAPIQPS)
+	cfg.Burst = *f.kubeAPIBurst
+
+	// Check that the CRDs watched by the enabled controllers are served in the supported versions
+	requiredCRDs := append([]schema.GroupVersionKind{}, machinesetcontroller.MachineDeploymentCRDs...)
+	if *f.annotateControlPlanes {
+		requiredCRDs = append(requiredCRDs, machinesetcontroller.KubeadmControlPlaneGVK)
+	}
+	if *f.annotateMachinePools {
+		requiredCRDs = append(requiredCRDs, machinesetcontroller.AWSMachinePoolGVK)
+	}
+	if *f.annotateManagedMachinePools {
+		requiredCRDs = append(requiredCRDs, machinesetcontroller.AWSManagedMachinePoolGVK)
+	}
+	if *f.annotateMachineSets {
+		requiredCRDs = append(requiredCRDs, machinesetcontroller.MachineSetCRDs...)
+	}
+	if *f.annotateClusterSummary {
+		requiredCRDs = append(requiredCRDs, machinesetcontroller.ClusterSummaryCRDs...)
+	}
+	if clusterSelector != nil {
+		requiredCRDs = append(requiredCRDs, machinesetcontroller.WorkloadClusterCRDs...)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		klog.Fatalf("Error creating discovery client: %v", err)
+	}
+	compatibility, err := machinesetcontroller.CheckCRDCompatibility(discoveryClient, requiredCRDs)
+	if err != nil {
+		klog.Fatalf("Error checking CRD compatibility: %v", err)
+	}
+	readOnly := !compatibility.Compatible() && *f.crdCompatibility == crdCompatibilityReadOnly
+	switch {
+	case compatibility.Compatible():
+		klog.Infof("CRD compatibility: %s", compatibility)
+	case readOnly:
+		klog.Errorf("%s. Running read-only: only the controllers whose CRDs are served are started, and they do not write.", compatibility)
+	default:
+		klog.Errorf("%s. No controllers are started, upgrade capa-annotator or use --crd-compatibility=read-only.", compatibility)
+	}
+	// startController returns whether the controller watching the kinds is started
+	startController := func(kinds ...schema.GroupVersionKind) bool {
+		return compatibility.Compatible() || (readOnly && compatibility.Serves(kinds...))
+	}
+
+	// Setup a Manager
+	capacityReporter := &machinesetcontroller.CapacityReporter{Interval: *f.capacityReportInterval}
+	annotationHealth := &machinesetcontroller.AnnotationHealthHandler{}
+	cacheDump := &machinesetcontroller.CacheDumpHandler{}
+	cacheExpiry := &machinesetcontroller.CacheExpiryHandler{}
+	extraHandlers := map[string]http.Handler{
+		"/version":             version.Handler(),
+		"/annotation-health":   annotationHealth,
+		"/debug/caches":        cacheDump,
+		"/debug/caches/expire": cacheExpiry,
+		"/debug/config":        configHandler(fs),
+		"/debug/alerts":        &metrics.AlertsHandler{},
+	}
+	if *f.capacityReportInterval > 0 {
+		extraHandlers["/debug/capacity-report"] = capacityReporter
+	}
+	quotaPreflighter := &machinesetcontroller.QuotaPreflighter{Interval: *f.quotaPreflightInterval}
+	if *f.quotaPreflightInterval > 0 {
+		extraHandlers["/debug/quota-preflight"] = quotaPreflighter
+	}
+	reconcileHistory := &machinesetcontroller.ReconcileHistoryHandler{}
+	if *f.reconcileHistorySize > 0 {
+		extraHandlers["/debug/reconcile-history"] = reconcileHistory
+	}
+	if *f.debugTokenFile != "" {
+		for path, handler := range extraHandlers {
+			if strings.HasPrefix(path, "/debug/") {
+				extraHandlers[path] = httpserver.BearerTokenHandler(*f.debugTokenFile, handler)
+			}
+		}
+	}
+
+	leaseName, leaseNamespace, err := leaderElectionScope(*f.namespaceScoped, *f.watchNamespace, *f.leaderElectResourceNamespace, *f.watchFilter)
+	if err != nil {
+		klog.Fatal(err)
+	}
+
+	opts := manager.Options{
+		LeaderElection:          *f.leaderElect,
+		LeaderElectionNamespace: leaseNamespace,
+		LeaderElectionID:        leaseName,
+		LeaseDuration:           f.leaderElectLeaseDuration,
+		Cache: cache.Options{
+			SyncPeriod: f.syncPeriod,
+		},
+		// The metrics and health endpoints are served on their own listeners
+		Metrics: server.Options{
+			BindAddress: "0",
+		},
+		// Slow the default retry and renew election rate to reduce etcd writes at idle: BZ 1858400
+		RetryPeriod:   f.leaderElectRetryPeriod,
+		RenewDeadline: f.leaderElectRenewDeadline,
+		// Release the lease when the manager stops, so that the next leader of a rolling restart does not wait
+		// out the lease duration. This requires the process to exit once the manager has stopped, which it does.
+		LeaderElectionReleaseOnCancel: true,
+		// The webhook server is only started if the admission webhook is registered
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Port:    *f.webhookPort,
+			CertDir: *f.webhookCertDir,
+		}),
+	}
+
+	// Identity Secrets are read directly, instead of caching all Secrets or none outside of the watched namespace
+	if *f.awsIdentitySecretNamespace != "" {
+		opts.Client.Cache = &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}}}
+	}
+
+	if *f.watchNamespace != "" {
+		opts.Cache.DefaultNamespaces = map[string]cache.Config{
+			*watchNamespace: {},
+		}
+		klog.Infof("Watching CAPI objects only in namespace %q for reconciliation.", *f.watchNamespace)
+	}
+
+	// MachineDeployments not matching the selector are not even cached
+	if mdSelector != nil {
+		opts.Cache.ByObject = map[client.Object]cache.ByObject{
+			&clusterv1.MachineDeployment{}: {Label: mdSelector},
+		}
+		klog.Infof("Reconciling only MachineDeployments matching %q.", mdSelector)
+	}
+
+	mgr, err := manager.New(cfg, opts)
+	if err != nil {
+		klog.Fatalf("Error creating manager: %v", err)
+	}
+
+	// Setup Scheme for all resources
+	if err := clusterv1.AddToScheme(mgr.GetScheme()); err != nil {
+		klog.Fatalf("Error setting up CAPI scheme: %v", err)
+	}
+
+	if err := infrav1.AddToScheme(mgr.GetScheme()); err != nil {
+		klog.Fatalf("Error setting up CAPA scheme: %v", err)
+	}
+
+	if err := expv1.AddToScheme(mgr.GetScheme()); err != nil {
+		klog.Fatalf("Error setting up CAPI experimental scheme: %v", err)
+	}
+
+	if err := corev1.AddToScheme(mgr.GetScheme()); err != nil {
+		klog.Fatal(err)
+	}
+
+	describeRegionsCache := awsclient.NewRegionCache()
+	clientPool := awsclient.NewClientPoolWithTTL(awsclient.NewValidatedClient, *f.awsClientTTL)
+
+	ctrl.SetLogger(textlogger.NewLogger(textlogger.NewConfig()))
+	setupLog := ctrl.Log.WithName("setup")
+
+	reconciler := &machinesetcontroller.Reconciler{
+		Client:             mgr.GetClient(),
+		Log:                ctrl.Log.WithName("controllers").WithName("MachineDeployment"),
+		AwsClientBuilder:   chaos.WrapAWSClientBuilder(clientPool.GetClient),
+		RegionCache:        describeRegionsCache,
+		InstanceTypesCache: machinesetcontroller.NewBoundedInstanceTypesCache(*f.instanceTypesCacheTTL, *f.instanceTypesCacheMaxEntries),
+
+		AvailabilityZonesCache: machinesetcontroller.NewAvailabilityZonesCache(),
+
+		ParkedReconcileInterval: *f.parkedReconcileInterval,
+		ParkedAfter:             *f.parkedAfter,
+
+		ReannotationInterval:         *f.reannotationInterval,
+		PeriodicReannotationInterval: *f.periodicReannotationInterval,
+
+		NewInstanceTypePollInterval:      *f.newInstanceTypePollInterval,
+		UnknownInstanceTypeRetryInterval: *f.unknownInstanceTypeRetryInterval,
+
+		InstanceTypesRefreshAhead: *f.instanceTypesRefreshAhead,
+
+		InstanceTypesCacheConfigMap: cacheConfigMap,
+
+		ReconcileHistorySize:             *f.reconcileHistorySize,
+		MachineDeploymentCapacityMetrics: *f.machineDeploymentCapacityMetrics,
+
+		SkipUnchangedLookups:      *f.skipUnchangedLookups,
+		OptIn:                     *f.optIn,
+		WatchFilterValue:          *f.watchFilter,
+		MachineDeploymentSelector: mdSelector,
+		ExcludedNamespaces:        excludedNamespaces,
+
+		IdentitySecretNamespace: *f.awsIdentitySecretNamespace,
+		IdentityClientBuilder:   chaos.WrapIdentityClientBuilder(clientPool.GetIdentityClient),
+		RoleARNPatterns:         roleARNPatterns,
+
+		DenyCrossNamespaceTemplates: *f.denyCrossNamespaceTemplates,
+		CrossNamespaceTemplateRules: crossNamespaceTemplateRules,
+
+		NamespaceQuota: machinesetcontroller.NamespaceQuota{
+			Patches:       *f.namespacePatchBudget,
+			WarningEvents: *f.namespaceWarningEventBudget,
+			Window:        *f.namespaceBudgetWindow,
+		},
+
+		RetryBudget: machinesetcontroller.RetryBudget{
+			Attempts: *f.retryBudget,
+			Window:   *f.retryBudgetWindow,
+			Backoff:  *f.retryBackoff,
+		},
+		AWSRetry: machinesetcontroller.AWSRetry{
+			InitialDelay: *f.awsRetryInitialDelay,
+			MaxDelay:     *f.awsRetryMaxDelay,
+		},
+
+		InstanceTypePolicy: typePolicy,
+
+		Chaos: chaos,
+	}
+	// The dataset replaces the EC2 API, the AWS clients must not call AWS
+	if *f.instanceTypesFile != "" {
+		dataset, err := machinesetcontroller.LoadInstanceTypesDataset(*f.instanceTypesFile)
+		if err != nil {
+			klog.Fatalf("Invalid --instance-types-file: %v", err)
+		}
+		if *f.newInstanceTypePollInterval > 0 || *f.unknownInstanceTypeRetryInterval > 0 || *f.instanceTypesRefreshAhead > 0 {
+			klog.Fatal("--instance-types-file cannot be combined with --new-instance-type-poll-interval, --unknown-instance-type-retry-interval or --instance-types-refresh-ahead")
+		}
+		klog.Infof("Serving %d instance types from %s without calling the EC2 API", len(dataset.InstanceTypes), *f.instanceTypesFile)
+		reconciler.InstanceTypesCache = machinesetcontroller.NewStaticInstanceTypesCache(dataset)
+		reconciler.AvailabilityZonesCache = machinesetcontroller.NewStaticAvailabilityZonesCache(dataset)
+		reconciler.AwsClientBuilder = machinesetcontroller.OfflineAWSClientBuilder
+		reconciler.IdentityClientBuilder = machinesetcontroller.OfflineIdentityClientBuilder
+	} else if *f.instanceTypesFallback {
+		dataset, err := machinesetcontroller.EmbeddedInstanceTypesDataset()
+		if err != nil {
+			klog.Fatalf("Error loading the embedded instance types: %v", err)
+		}
+		reconciler.InstanceTypesCache = machinesetcontroller.NewFallbackInstanceTypesCache(reconciler.InstanceTypesCache, dataset)
+	}
+	if err := f.annotationFlags.apply(reconciler); err != nil {
+		klog.Fatal(err)
+	}
+	// Writes are validated by the API server but not persisted
+	if readOnly {
+		reconciler.Client = client.NewDryRunClient(reconciler.Client)
+	}
+
+	if *f.genericTemplates != "" {
+		templates, err := machinesetcontroller.LoadGenericTemplates(*f.genericTemplates)
+		if err != nil {
+			klog.Fatalf("Invalid --generic-templates: %v", err)
+		}
+		reconciler.Providers = append(reconciler.Providers, machinesetcontroller.NewGenericProvider(templates, reconciler))
+	}
+	if *f.azureProvider {
+		credentials, err := azure.CredentialsFromEnvironment()
+		if err != nil {
+			klog.Fatalf("Invalid --azure-provider: %v", err)
+		}
+		reconciler.Providers = append(reconciler.Providers,
+			machinesetcontroller.NewAzureProvider(azure.NewSKUClient(credentials), *f.instanceTypesCacheTTL))
+	}
+	if *f.gcpProvider {
+		credentials, err := gcp.CredentialsFromEnvironment()
+		if err != nil {
+			klog.Fatalf("Invalid --gcp-provider: %v", err)
+		}
+		machineTypesClient, err := gcp.NewMachineTypesClient(credentials)
+		if err != nil {
+			klog.Fatalf("Invalid --gcp-provider: %v", err)
+		}
+		reconciler.Providers = append(reconciler.Providers,
+			machinesetcontroller.NewGCPProvider(machineTypesClient, *f.instanceTypesCacheTTL))
+	}
+
+	switch {
+	case *f.auditSink == "":
+	case *f.auditSink == "stdout":
+		reconciler.AuditSink = machinesetcontroller.NewWriterAuditSink(os.Stdout)
+	case strings.HasPrefix(*f.auditSink, "http://") || strings.HasPrefix(*f.auditSink, "https://"):
+		webhookAuditSink := machinesetcontroller.NewWebhookAuditSink(*f.auditSink)
+		if err := mgr.Add(webhookAuditSink); err != nil {
+			klog.Fatalf("Error adding audit sink: %v", err)
+		}
+		reconciler.AuditSink = webhookAuditSink
+	default:
+		klog.Fatalf("Invalid --audit-sink %q, must be \"stdout\" or an http(s) URL", *f.auditSink)
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+	var run *machinesetcontroller.RunOnce
+	if *f.runOnce {
+		if !startController(machinesetcontroller.MachineDeploymentCRDs...) {
+			klog.Fatal("--run-once requires the MachineDeployment CRDs to be served")
+		}
+		var stop context.CancelFunc
+		ctx, stop = context.WithCancel(ctx)
+		run = &machinesetcontroller.RunOnce{Reconciler: reconciler, Stop: stop, DryRun: mode == serveDiff}
+		if err := run.SetupWithManager(mgr); err != nil {
+			klog.Fatalf("Error setting up the run: %v", err)
+		}
+	} else if !*f.runtimeExtensionOnly && startController(machinesetcontroller.MachineDeploymentCRDs...) {
+		if err := reconciler.SetupWithManager(mgr, controller.Options{MaxConcurrentReconciles: *f.maxConcurrentReconciles}); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "MachineDeployment")
+			os.Exit(1)
+		}
+	}
+
+	if *f.annotateControlPlanes && startController(machinesetcontroller.KubeadmControlPlaneGVK) {
+		controlPlaneReconciler := &machinesetcontroller.ControlPlaneReconciler{Reconciler: reconciler}
+		if err := controlPlaneReconciler.SetupWithManager(mgr, controller.Options{MaxConcurrentReconciles: *f.maxConcurrentReconciles}); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "KubeadmControlPlane")
+			os.Exit(1)
+		}
+	}
+
+	if *f.annotateMachinePools && startController(machinesetcontroller.AWSMachinePoolGVK) {
+		machinePoolReconciler := &machinesetcontroller.MachinePoolReconciler{Reconciler: reconciler}
+		if err := machinePoolReconciler.SetupWithManager(mgr, controller.Options{MaxConcurrentReconciles: *f.maxConcurrentReconciles}); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "AWSMachinePool")
+			os.Exit(1)
+		}
+	}
+
+	if *f.annotateManagedMachinePools && startController(machinesetcontroller.AWSManagedMachinePoolGVK) {
+		managedMachinePoolReconciler := &machinesetcontroller.ManagedMachinePoolReconciler{Reconciler: reconciler}
+		if err := managedMachinePoolReconciler.SetupWithManager(mgr, controller.Options{MaxConcurrentReconciles: *f.maxConcurrentReconciles}); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "AWSManagedMachinePool")
+			os.Exit(1)
+		}
+	}
+
+	if *f.annotateMachineSets && startController(machinesetcontroller.MachineSetCRDs...) {
+		machineSetReconciler := &machinesetcontroller.MachineSetReconciler{Reconciler: reconciler}
+		if err := machineSetReconciler.SetupWithManager(mgr, controller.Options{MaxConcurrentReconciles: *f.maxConcurrentReconciles}); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "MachineSet")
+			os.Exit(1)
+		}
+	}
+
+	if *f.annotateClusterSummary && startController(machinesetcontroller.ClusterSummaryCRDs...) {
+		clusterSummaryReconciler := &machinesetcontroller.ClusterSummaryReconciler{Reconciler: reconciler}
+		if err := clusterSummaryReconciler.SetupWithManager(mgr, controller.Options{MaxConcurrentReconciles: *f.maxConcurrentReconciles}); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ClusterSummary")
+			os.Exit(1)
+		}
+	}
+
+	if clusterSelector != nil && startController(machinesetcontroller.WorkloadClusterCRDs...) {
+		workloadClusters := &machinesetcontroller.WorkloadClusters{
+			Reconciler: reconciler,
+			Selector:   clusterSelector,
+			Interval:   *f.workloadClusterInterval,
+		}
+		if err := workloadClusters.SetupWithManager(mgr); err != nil {
+			klog.Fatalf("Error adding workload clusters: %v", err)
+		}
+	}
+
+	if *f.runtimeExtensionPort != 0 && startController(machinesetcontroller.MachineDeploymentCRDs...) {
+		if *f.runtimeExtensionPort == *f.webhookPort {
+			klog.Fatal("--runtime-extension-port must differ from --webhook-port")
+		}
+		runtimeExtension := &machinesetcontroller.RuntimeExtension{Reconciler: reconciler, Standalone: *f.runtimeExtensionOnly}
+		if err := runtimeExtension.SetupWithManager(mgr, *f.runtimeExtensionPort, *f.webhookCertDir); err != nil {
+			klog.Fatalf("Error adding runtime extension: %v", err)
+		}
+	}
+
+	if *f.webhookPort != 0 {
+		mgr.GetWebhookServer().Register("/validate-instance-type-policy", &webhook.Admission{
+			Handler: &machinesetcontroller.InstanceTypePolicyWebhook{Policy: typePolicy},
+		})
+	}
+
+	annotationHealth.Reconciler = reconciler
+	cacheDump.Reconciler = reconciler
+	cacheExpiry.Reconciler = reconciler
+	reconcileHistory.Reconciler = reconciler
+
+	if *f.capacityReportInterval > 0 && startController(machinesetcontroller.MachineDeploymentCRDs...) {
+		capacityReporter.Reconciler = reconciler
+		if err := mgr.Add(capacityReporter); err != nil {
+			klog.Fatalf("Error adding capacity reporter: %v", err)
+		}
+	}
+
+	if *f.quotaPreflightInterval > 0 && startController(machinesetcontroller.MachineDeploymentCRDs...) {
+		quotaPreflighter.Reconciler = reconciler
+		if err := mgr.Add(quotaPreflighter); err != nil {
+			klog.Fatalf("Error adding quota preflight: %v", err)
+		}
+	}
+
+	if *f.coverageSLOInterval > 0 && startController(machinesetcontroller.MachineDeploymentCRDs...) {
+		coverageSLO := &machinesetcontroller.CoverageSLO{
+			Reconciler: reconciler,
+			Interval:   *f.coverageSLOInterval,
+			Target:     *f.coverageSLOTarget,
+			Grace:      *f.coverageSLOGrace,
+			Windows:    coverageWindows,
+		}
+		if err := mgr.Add(coverageSLO); err != nil {
+			klog.Fatalf("Error adding coverage SLO: %v", err)
+		}
+	}
+
+	metricsListeners, healthListeners, err := openListeners(*f.socketActivation, *f.metricsAddress, *f.healthAddr)
+	if err != nil {
+		klog.Fatalf("Error opening listeners: %v", err)
+	}
+
+	if err := mgr.Add(&httpserver.Server{
+		Name:      "metrics",
+		Handler:   httpserver.MetricsHandler(extraHandlers),
+		Listeners: metricsListeners,
+	}); err != nil {
+		klog.Fatalf("Error adding metrics server: %v", err)
+	}
+
+	profilingListeners, err := httpserver.Listen(*f.profilingAddress)
+	if err != nil {
+		klog.Fatalf("Error opening profiling listeners: %v", err)
+	}
+	profilingHandler := httpserver.ProfilingHandler()
+	if *f.debugTokenFile != "" {
+		profilingHandler = httpserver.BearerTokenHandler(*f.debugTokenFile, profilingHandler)
+	}
+	if err := mgr.Add(&httpserver.Server{
+		Name:      "profiling",
+		Handler:   profilingHandler,
+		Listeners: profilingListeners,
+	}); err != nil {
+		klog.Fatalf("Error adding profiling server: %v", err)
+	}
+
+	checks := map[string]healthz.Checker{"ping": healthz.Ping}
+	readyChecks := map[string]healthz.Checker{"ping": healthz.Ping, "crd-compatibility": compatibility.Check}
+	if err := mgr.Add(&httpserver.Server{
+		Name:      "health probes",
+		Handler:   httpserver.HealthHandler(checks, readyChecks),
+		Listeners: healthListeners,
+	}); err != nil {
+		klog.Fatalf("Error adding health probe server: %v", err)
+	}
+
+	// Start the Cmd
+	err = mgr.Start(ctx)
+	if err != nil {
+		klog.Fatalf("Error starting manager: %v", err)
+	}
+
+	if run != nil {
+		if run.Err != nil {
+			klog.Errorf("Error annotating MachineDeployments: %v", run.Err)
+			return 1
+		}
+		if mode == serveDiff {
+			// Like diff(1), the exit code is 1 if anything would change and 2 on failures
+			run.Summary.PrintDiff(os.Stdout)
+			switch {
+			case len(run.Summary.Failed) > 0:
+				return 2
+			case len(run.Summary.Changed) > 0:
+				return 1
+			}
+			return 0
+		}
+		run.Summary.Print(os.Stdout)
+		if len(run.Summary.Failed) > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// openListeners opens the listeners of the metrics and health endpoints. With socket activation, the sockets
+// named "metrics" and "health" replace the respective addresses.
+func openListeners(socketActivation bool, metricsAddress, healthAddress string) ([]net.Listener, []net.Listener, error) {
+	activated := map[string][]net.Listener{}
+	if socketActivation {
+		var err error
+		activated, err = httpserver.ActivatedListeners()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	metricsListeners := activated["metrics"]
+	if len(metricsListeners) == 0 {
+		var err error
+		metricsListeners, err = httpserver.Listen(metricsAddress)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	healthListeners := activated["health"]
+	if len(healthListeners) == 0 {
+		var err error
+		healthListeners, err = httpserver.Listen(healthAddress)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return metricsListeners, healthListeners, nil
+}
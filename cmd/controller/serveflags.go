@@ -0,0 +1,474 @@
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// serveFlags holds the flags of the controller, taken by the serve, run-once and diff subcommands.
+type serveFlags struct {
+	printVersion                     *bool
+	metricsAddress                   *string
+	profilingAddress                 *string
+	watchNamespace                   *string
+	machineDeploymentSelector        *string
+	skipUnchangedLookups             *bool
+	excludeNamespaces                *string
+	optIn                            *bool
+	watchFilter                      *string
+	namespaceScoped                  *bool
+	leaderElectResourceNamespace     *string
+	runOnce                          *bool
+	leaderElect                      *bool
+	leaderElectLeaseDuration         *time.Duration
+	leaderElectRenewDeadline         *time.Duration
+	leaderElectRetryPeriod           *time.Duration
+	healthAddr                       *string
+	socketActivation                 *bool
+	parkedReconcileInterval          *time.Duration
+	parkedAfter                      *time.Duration
+	reannotationInterval             *time.Duration
+	periodicReannotationInterval     *time.Duration
+	newInstanceTypePollInterval      *time.Duration
+	unknownInstanceTypeRetryInterval *time.Duration
+	reconcileHistorySize             *int
+	machineDeploymentCapacityMetrics *bool
+	annotateControlPlanes            *bool
+	annotateMachinePools             *bool
+	annotateManagedMachinePools      *bool
+	annotateMachineSets              *bool
+	annotateClusterSummary           *bool
+	workloadClusterSelector          *string
+	workloadClusterInterval          *time.Duration
+	azureProvider                    *bool
+	gcpProvider                      *bool
+	genericTemplates                 *string
+	awsIdentitySecretNamespace       *string
+	roleARNAllowList                 *string
+	denyCrossNamespaceTemplates      *bool
+	crossNamespaceTemplateAllowList  *string
+	capacityReportInterval           *time.Duration
+	quotaPreflightInterval           *time.Duration
+	debugTokenFile                   *string
+	coverageSLOInterval              *time.Duration
+	coverageSLOTarget                *float64
+	coverageSLOGrace                 *time.Duration
+	coverageSLOWindows               *string
+	maxConcurrentReconciles          *int
+	syncPeriod                       *time.Duration
+	instanceTypesCacheTTL            *time.Duration
+	instanceTypesCacheMaxEntries     *int
+	instanceTypesCacheConfigMap      *string
+	instanceTypesFile                *string
+	instanceTypesFallback            *bool
+	instanceTypesRefreshAhead        *time.Duration
+	awsClientTTL                     *time.Duration
+	kubeAPIQPS                       *float64
+	kubeAPIBurst                     *int
+	auditSink                        *string
+	namespacePatchBudget             *int
+	namespaceWarningEventBudget      *int
+	namespaceBudgetWindow            *time.Duration
+	retryBudget                      *int
+	retryBudgetWindow                *time.Duration
+	retryBackoff                     *time.Duration
+	awsRetryInitialDelay             *time.Duration
+	awsRetryMaxDelay                 *time.Duration
+	instanceTypePolicy               *string
+	webhookPort                      *int
+	webhookCertDir                   *string
+	runtimeExtensionPort             *int
+	runtimeExtensionOnly             *bool
+	crdCompatibility                 *string
+	annotationFlags                  *annotationFlags
+	metricsNamespaces                *string
+	chaosSpec                        *string
+}
+
+// addServeFlags registers the flags of the controller on the given flag set.
+func addServeFlags(fs *pflag.FlagSet) *serveFlags {
+	f := &serveFlags{
+		printVersion: fs.Bool(
+			"version",
+			false,
+			"print version and exit",
+		),
+		metricsAddress: fs.String(
+			"metrics-bind-address",
+			":8080",
+			"Comma-separated addresses for hosting metrics, e.g. \"0.0.0.0:8080,[::]:8080\" to listen on both IPv4 and IPv6. \"0\" disables the metrics endpoint.",
+		),
+		profilingAddress: fs.String(
+			"profiling-bind-address",
+			"",
+			"Comma-separated addresses for serving the runtime profiles of net/http/pprof at /debug/pprof/, e.g. \"localhost:6060\". Empty disables profiling.",
+		),
+		watchNamespace: fs.String(
+			"namespace",
+			"",
+			"Namespace that the controller watches to reconcile machine-api objects. If unspecified, the controller watches for machine-api objects across all namespaces.",
+		),
+		machineDeploymentSelector: fs.String(
+			"machinedeployment-selector",
+			"",
+			"Label selector, e.g. \"capa-annotator=enabled\" or \"team notin (a,b)\", restricting the MachineDeployments that are cached and reconciled. Empty reconciles all MachineDeployments.",
+		),
+		skipUnchangedLookups: fs.Bool(
+			"skip-unchanged-lookups",
+			false,
+			"Skip the instance type lookup, including the AWS clients and calls, of MachineDeployments whose template, instance type, region and annotations are unchanged since their last successful lookup. Refreshed instance type data is then only applied once any of them changes.",
+		),
+		excludeNamespaces: fs.String(
+			"exclude-namespaces",
+			"",
+			"Comma-separated list of namespaces, or patterns in the syntax of path.Match such as \"tenant-*\", whose MachineDeployments are not reconciled.",
+		),
+		optIn: fs.Bool(
+			"opt-in",
+			false,
+			"Only annotate MachineDeployments with the capa-annotator/enabled annotation set to \"true\". By default, all MachineDeployments are annotated unless the annotation is set to \"false\".",
+		),
+		watchFilter: fs.String(
+			"watch-filter",
+			"",
+			"Label value that MachineDeployments must carry in the cluster.x-k8s.io/watch-filter label to be reconciled, like the --watch-filter flag of the Cluster API controllers. The leader election lease is named after the value. Empty reconciles all MachineDeployments.",
+		),
+		namespaceScoped: fs.Bool(
+			"namespace-scoped",
+			false,
+			"Scope the controller to --namespace, so that independent instances can run in different namespaces of one management cluster. The leader election lease is named after the namespace and held in it unless --leader-elect-resource-namespace is set, and cross-namespace template references are denied.",
+		),
+		leaderElectResourceNamespace: fs.String(
+			"leader-elect-resource-namespace",
+			"",
+			"The namespace of resource object that is used for locking during leader election. If unspecified and running in cluster, defaults to the service account namespace for the controller. Required for leader-election outside of a cluster.",
+		),
+		runOnce: fs.Bool(
+			"run-once",
+			false,
+			"Annotate all MachineDeployments once, print a summary and exit, with a non-zero exit code if any MachineDeployment could not be annotated. This runs the controller as a Job or CronJob instead of a long-lived Deployment. Leader election is disabled and only MachineDeployments are annotated.",
+		),
+		leaderElect: fs.Bool(
+			"leader-elect",
+			false,
+			"Start a leader election client and gain leadership before executing the main loop. Enable this when running replicated components for high availability.",
+		),
+		leaderElectLeaseDuration: fs.Duration(
+			"leader-elect-lease-duration",
+			leaseDuration,
+			"The duration that non-leader candidates will wait after observing a leadership renewal until attempting to acquire leadership of a led but unrenewed leader slot. This is effectively the maximum duration that a leader can be stopped before it is replaced by another candidate. This is only applicable if leader election is enabled.",
+		),
+		leaderElectRenewDeadline: fs.Duration(
+			"leader-elect-renew-deadline",
+			renewDeadline,
+			"The duration that the acting leader will retry refreshing leadership before giving up. Must be shorter than --leader-elect-lease-duration. This is only applicable if leader election is enabled.",
+		),
+		leaderElectRetryPeriod: fs.Duration(
+			"leader-elect-retry-period",
+			retryPeriod,
+			"The duration the clients should wait between attempting acquisition and renewal of leadership. This is only applicable if leader election is enabled.",
+		),
+		healthAddr: fs.String(
+			"health-addr",
+			":9440",
+			"Comma-separated addresses for health checking, e.g. \"0.0.0.0:9440,[::]:9440\" to listen on both IPv4 and IPv6. \"0\" disables the health endpoint.",
+		),
+		socketActivation: fs.Bool(
+			"socket-activation",
+			false,
+			"Use the sockets passed by systemd socket activation. Sockets named \"metrics\" and \"health\" (FileDescriptorName=) replace --metrics-bind-address and --health-addr respectively.",
+		),
+		parkedReconcileInterval: fs.Duration(
+			"parked-reconcile-interval",
+			0,
+			"Reduced reconcile interval for parked MachineDeployments, i.e. MachineDeployments with zero replicas whose spec has not changed for --parked-after. Zero disables the parked tier and reconciles all MachineDeployments on every resync.",
+		),
+		parkedAfter: fs.Duration(
+			"parked-after",
+			24*time.Hour,
+			"Duration without spec changes after which a MachineDeployment with zero replicas is considered parked. The time of the last spec change is recorded in the capa-annotator/spec-changed annotation, so it survives controller restarts. Only applicable if --parked-reconcile-interval is set.",
+		),
+		reannotationInterval: fs.Duration(
+			"reannotation-interval",
+			time.Second,
+			"Interval at which MachineDeployments annotated by another controller version are reannotated after an upgrade, so that new annotation features roll out at a bounded rate. The version is taken from the capa-annotator/provenance annotation. Zero disables the reannotation, MachineDeployments are then updated with the next resyncs.",
+		),
+		periodicReannotationInterval: fs.Duration(
+			"periodic-reannotation-interval",
+			0,
+			"Interval at which successfully annotated MachineDeployments are reconciled again, with a 10% jitter, to pick up corrections of the instance type data and region changes independently of --sync-period. Lookups skipped by --skip-unchanged-lookups are made again once the data is older than the interval. Zero disables the periodic reannotation.",
+		),
+		newInstanceTypePollInterval: fs.Duration(
+			"new-instance-type-poll-interval",
+			0,
+			"Interval at which the instance types of regions with MachineDeployments referencing unknown instance types are fetched, so that MachineDeployments created ahead of the regional launch of an instance type are annotated as soon as it is available. Zero disables the polling, such MachineDeployments are then annotated after the next refresh of the instance types cache.",
+		),
+		unknownInstanceTypeRetryInterval: fs.Duration(
+			"unknown-instance-type-retry-interval",
+			0,
+			"Interval at which MachineDeployments referencing instance types unknown in their region are requeued, fetching the instance type again from the EC2 API at most once per interval. A change of the AWSMachineTemplate clears the negative result. Zero disables the retries, such MachineDeployments are then reconciled with the next resyncs.",
+		),
+		reconcileHistorySize: fs.Int(
+			"reconcile-history-size",
+			0,
+			"Number of reconcile outcomes (time, result, annotation values and changes, errors) kept in memory per MachineDeployment. The history is served at /debug/reconcile-history on the metrics endpoint. Zero disables the history.",
+		),
+		machineDeploymentCapacityMetrics: fs.Bool(
+			"machinedeployment-capacity-metrics",
+			false,
+			"Export the annotated vCPUs, memory and GPUs of each MachineDeployment as gauges labeled by namespace, name, cluster and instance type, e.g. for capacity planning dashboards. The number of series grows with the number of MachineDeployments.",
+		),
+		annotateControlPlanes: fs.Bool(
+			"annotate-control-planes",
+			false,
+			"Also write the capacity annotations on KubeadmControlPlanes, resolved from the AWSMachineTemplate of their machine template. Requires the KubeadmControlPlane CRD to be installed.",
+		),
+		annotateMachinePools: fs.Bool(
+			"annotate-machine-pools",
+			false,
+			"Also write the capacity annotations on AWSMachinePools, resolved from the instance type of their launch template. Requires the AWSMachinePool CRD to be installed.",
+		),
+		annotateManagedMachinePools: fs.Bool(
+			"annotate-managed-machine-pools",
+			false,
+			"Also write the capacity annotations on MachinePools of EKS managed node groups, resolved from the instance type of their AWSManagedMachinePool. Requires the MachinePool and AWSManagedMachinePool CRDs to be installed.",
+		),
+		annotateMachineSets: fs.Bool(
+			"annotate-machine-sets",
+			false,
+			"Also write the capacity annotations on MachineSets that are not owned by a MachineDeployment, resolved from the AWSMachineTemplate of their machine template.",
+		),
+		annotateClusterSummary: fs.Bool(
+			"annotate-cluster-summary",
+			false,
+			"Also write a summary of the capacity of the MachineDeployments of each Cluster, at the autoscaler min and max size, to the Cluster.",
+		),
+		workloadClusterSelector: fs.String(
+			"workload-cluster-selector",
+			"",
+			"Label selector of the Clusters whose workload clusters run their own Cluster API instances, e.g. \"capa-annotator/workload-cluster=true\". Their MachineDeployments are annotated once per --workload-cluster-interval, connecting with the kubeconfig Secrets of the Clusters. Empty disables the workload clusters.",
+		),
+		workloadClusterInterval: fs.Duration(
+			"workload-cluster-interval",
+			10*time.Minute,
+			"Interval at which the MachineDeployments of the workload clusters selected by --workload-cluster-selector are annotated.",
+		),
+		azureProvider: fs.Bool(
+			"azure-provider",
+			false,
+			"Also annotate MachineDeployments of AzureMachineTemplates, resolved from the Compute Resource SKUs API with the service principal of the AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET environment variables.",
+		),
+		gcpProvider: fs.Bool(
+			"gcp-provider",
+			false,
+			"Also annotate MachineDeployments of GCPMachineTemplates, resolved from the Compute Engine API with the service account key of GOOGLE_APPLICATION_CREDENTIALS or the metadata server.",
+		),
+		genericTemplates: fs.String(
+			"generic-templates",
+			"",
+			"Path to a YAML file of infrastructure template kinds, with the JSONPaths of their EC2 instance type and AWS region, annotated without compiled-in types.",
+		),
+		awsIdentitySecretNamespace: fs.String(
+			"aws-identity-secret-namespace",
+			"",
+			"Namespace of the Secrets of AWSClusterStaticIdentities, i.e. the namespace of the CAPA controller. If set, the AWS clients authenticate with the identityRef of the AWSCluster or AWSManagedControlPlane of each Cluster instead of the controller's own credentials, assuming the roles of AWSClusterRoleIdentities.",
+		),
+		roleARNAllowList: fs.String(
+			"role-arn-allow-list",
+			"",
+			"Comma-separated patterns of the roles MachineDeployments and Clusters may name in the capa-annotator/role-arn annotation, e.g. arn:aws:iam::*:role/capa-annotator. The role is assumed for the AWS calls of the MachineDeployment. If empty, the annotation is ignored.",
+		),
+		denyCrossNamespaceTemplates: fs.Bool(
+			"deny-cross-namespace-templates",
+			false,
+			"Deny MachineDeployments referencing AWSMachineTemplates in other namespaces, unless allowed by --cross-namespace-template-allow-list.",
+		),
+		crossNamespaceTemplateAllowList: fs.String(
+			"cross-namespace-template-allow-list",
+			"",
+			"Comma-separated list of allowed cross-namespace template references in the form <MachineDeployment namespace>:<template namespace>. Either side may be \"*\". Only applicable if --deny-cross-namespace-templates is set.",
+		),
+		capacityReportInterval: fs.Duration(
+			"capacity-report-interval",
+			0,
+			"Interval of the capacity audit building a report of every instance type in use, its capacity and the MachineDeployments using it. The latest report is served at /debug/capacity-report on the metrics endpoint. Zero disables the report.",
+		),
+		quotaPreflightInterval: fs.Duration(
+			"quota-preflight-interval",
+			0,
+			"Interval of the quota preflight checking whether scaling each MachineDeployment up by one replica would exceed the running On-Demand instances vCPU quota of the account in its region. The latest preflight is served at /debug/quota-preflight on the metrics endpoint. Zero disables the preflight.",
+		),
+		debugTokenFile: fs.String(
+			"debug-token-file",
+			"",
+			"File holding a bearer token required by the /debug/ endpoints of the metrics and profiling listeners, e.g. mounted from a Secret. The file is read on every request, so that a rotated token takes effect without a restart. Empty serves the endpoints without authentication.",
+		),
+		coverageSLOInterval: fs.Duration(
+			"coverage-slo-interval",
+			0,
+			"Interval at which the annotation coverage of the MachineDeployments is measured and exported with the burn rates of the coverage SLO. Zero disables the coverage metrics.",
+		),
+		coverageSLOTarget: fs.Float64(
+			"coverage-slo-target",
+			0.99,
+			"Target share of MachineDeployments annotated within --coverage-slo-grace of their creation.",
+		),
+		coverageSLOGrace: fs.Duration(
+			"coverage-slo-grace",
+			5*time.Minute,
+			"Duration after the creation of a MachineDeployment within which it must be annotated to meet the coverage SLO.",
+		),
+		coverageSLOWindows: fs.String(
+			"coverage-slo-windows",
+			"5m,30m,1h,6h",
+			"Comma-separated windows the burn rate of the coverage SLO is exported for.",
+		),
+		maxConcurrentReconciles: fs.Int(
+			"max-concurrent-reconciles",
+			1,
+			"Maximum number of MachineDeployments reconciled concurrently.",
+		),
+		syncPeriod: fs.Duration(
+			"sync-period",
+			10*time.Minute,
+			"Interval at which all watched MachineDeployments are reconciled again. controller-runtime applies a fixed jitter of 10% to spread the resyncs.",
+		),
+		instanceTypesCacheTTL: fs.Duration(
+			"instance-types-cache-ttl",
+			machinesetcontroller.DefaultInstanceTypesCacheTTL,
+			"Duration after which the cached instance types of a region are fetched again from the EC2 API.",
+		),
+		instanceTypesCacheMaxEntries: fs.Int(
+			"instance-types-cache-max-entries",
+			0,
+			"Maximum number of regions in the instance types cache. Once reached, the least recently used region is evicted and fetched again on its next use. Zero is unbounded.",
+		),
+		instanceTypesCacheConfigMap: fs.String(
+			"instance-types-cache-configmap",
+			"",
+			"ConfigMap, as <namespace>/<name>, the instance types cache is persisted to and restored from at startup, so that a restarted controller does not fetch all regions with its first reconciles. Empty disables the persistence.",
+		),
+		instanceTypesFile: fs.String(
+			"instance-types-file",
+			"",
+			"Path to a YAML or JSON dataset of instance type capacities to serve the lookups from, without calling the EC2 API, for management clusters that cannot reach it. Empty fetches the instance types from the EC2 API.",
+		),
+		instanceTypesFallback: fs.Bool(
+			"instance-types-fallback",
+			false,
+			"Serve the instance types from a snapshot of common instance types embedded in the binary while they cannot be fetched from the EC2 API, e.g. during outages or throttling. MachineDeployments annotated from the snapshot get a StaleInstanceTypeData warning event.",
+		),
+		instanceTypesRefreshAhead: fs.Duration(
+			"instance-types-refresh-ahead",
+			0,
+			"Duration before their expiry at which the cached instance types of regions are refreshed in the background with the controller's own credentials, so that newly launched instance types become available without a cache miss during a reconcile. Must be shorter than --instance-types-cache-ttl. Zero disables the background refresh.",
+		),
+		awsClientTTL: fs.Duration(
+			"aws-client-ttl",
+			awsclient.DefaultClientPoolTTL,
+			"Duration after which the pooled AWS client of a credential identity and region is constructed again. Zero keeps the clients forever.",
+		),
+		kubeAPIQPS: fs.Float64(
+			"kube-api-qps",
+			20,
+			"Maximum queries per second to the Kubernetes API server. Raise it with --kube-api-burst when patching many MachineDeployments is throttled.",
+		),
+		kubeAPIBurst: fs.Int(
+			"kube-api-burst",
+			30,
+			"Maximum burst of queries to the Kubernetes API server.",
+		),
+		auditSink: fs.String(
+			"audit-sink",
+			"",
+			"Optional sink receiving a JSON record of every annotation change and failure, for a longer retention than Kubernetes Events. Either \"stdout\", which is kept apart from the logs on stderr, or an http(s) URL the records are posted to.",
+		),
+		namespacePatchBudget: fs.Int(
+			"namespace-patch-budget",
+			0,
+			"Number of MachineDeployment patches per namespace and --namespace-budget-window. Patches beyond the budget are deferred to the next window. Zero is unlimited.",
+		),
+		namespaceWarningEventBudget: fs.Int(
+			"namespace-warning-event-budget",
+			0,
+			"Number of warning events per namespace and --namespace-budget-window. Warning events beyond the budget are dropped, they are still sent to the audit sink. Zero is unlimited.",
+		),
+		namespaceBudgetWindow: fs.Duration(
+			"namespace-budget-window",
+			time.Minute,
+			"Duration of the windows of the namespace budgets.",
+		),
+		retryBudget: fs.Int(
+			"retry-budget",
+			0,
+			"Number of failed reconciles of a MachineDeployment within --retry-budget-window after which the controller gives up, emits a GaveUp event aggregating the error causes and retries after --retry-backoff. Zero disables the budget.",
+		),
+		retryBudgetWindow: fs.Duration(
+			"retry-budget-window",
+			time.Hour,
+			"Duration in which the failed reconciles of a MachineDeployment are counted against --retry-budget.",
+		),
+		retryBackoff: fs.Duration(
+			"retry-backoff",
+			6*time.Hour,
+			"Interval at which MachineDeployments are retried after exhausting --retry-budget.",
+		),
+		awsRetryInitialDelay: fs.Duration(
+			"aws-retry-initial-delay",
+			5*time.Second,
+			"Delay after which a MachineDeployment is retried if its instance type lookup failed for transient reasons, e.g. throttling or timeouts. The delay doubles with every consecutive failure up to --aws-retry-max-delay. Zero disables the backoff, such MachineDeployments are then retried at the next resync.",
+		),
+		awsRetryMaxDelay: fs.Duration(
+			"aws-retry-max-delay",
+			5*time.Minute,
+			"Maximum delay between retries of transient instance type lookup failures.",
+		),
+		instanceTypePolicy: fs.String(
+			"instance-type-policy",
+			"",
+			"Path to a YAML file of rules allowing or denying instance types and families per namespace. MachineDeployments violating the policy get a PolicyViolation event instead of the annotations.",
+		),
+		webhookPort: fs.Int(
+			"webhook-port",
+			0,
+			"Port of the validating admission webhook rejecting AWSMachineTemplates that violate --instance-type-policy. Zero disables the webhook.",
+		),
+		webhookCertDir: fs.String(
+			"webhook-cert-dir",
+			"/tmp/k8s-webhook-server/serving-certs",
+			"Directory containing the tls.crt and tls.key serving certificate of the admission webhook and the runtime extension.",
+		),
+		runtimeExtensionPort: fs.Int(
+			"runtime-extension-port",
+			0,
+			"Port of the Cluster API Runtime Extension annotating the MachineDeployments of ClusterClass based Clusters from the lifecycle hooks of the topology controller. Zero disables the runtime extension.",
+		),
+		runtimeExtensionOnly: fs.Bool(
+			"runtime-extension-only",
+			false,
+			"Only serve the runtime extension of --runtime-extension-port, without running the MachineDeployment controller. MachineDeployments are then annotated from the lifecycle hooks only.",
+		),
+		crdCompatibility: fs.String(
+			"crd-compatibility",
+			crdCompatibilityEnforce,
+			"What to do if the Cluster API or CAPA CRDs watched by the controllers are not served in a supported version at startup: \"enforce\" starts no controllers, \"read-only\" starts the controllers whose CRDs are served without writing. Either way the readiness check fails.",
+		),
+		annotationFlags: addAnnotationFlags(fs),
+		metricsNamespaces: fs.String(
+			"metrics-namespaces",
+			"",
+			"Comma-separated allow-list of namespaces that are reported with their own namespace label in the reconcile metrics. All other namespaces are reported as \"_other\" to bound the metric cardinality.",
+		),
+		// Developer flag, left out of the usage
+		chaosSpec: fs.String(
+			chaosFlag,
+			"",
+			"Inject faults at the given rates for soak tests, e.g. delay=0.1,max-delay=2s,cache-flush=0.01,aws-failure=0.05. Not meant for production.",
+		),
+	}
+	if err := fs.MarkHidden(chaosFlag); err != nil {
+		panic(err)
+	}
+	return f
+}
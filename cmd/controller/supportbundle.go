@@ -0,0 +1,322 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/version"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// controllerPodLabel selects the pods of the controller deployed with deploy/deployment.yaml.
+const controllerPodLabel = "app.kubernetes.io/name"
+
+// supportBundleSources are the endpoints of the metrics listener collected into a support bundle, by file name.
+// Endpoints of disabled features respond with 404, which is recorded in the manifest.
+var supportBundleSources = []struct {
+	file string
+	path string
+}{
+	{file: "version.json", path: "/version"},
+	{file: "config.json", path: "/debug/config"},
+	{file: "metrics.txt", path: "/metrics"},
+	{file: "annotation-health.json", path: "/annotation-health"},
+	{file: "caches.json", path: "/debug/caches"},
+	{file: "capacity-report.json", path: "/debug/capacity-report"},
+	{file: "reconcile-history.json", path: "/debug/reconcile-history"},
+}
+
+// bundleFile is a file of a support bundle.
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+// bundleManifestEntry records where a file of a support bundle was collected from.
+type bundleManifestEntry struct {
+	File   string `json:"file"`
+	Source string `json:"source"`
+	// Status is the HTTP status of the response, if any.
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bundleManifest describes the content of a support bundle.
+type bundleManifest struct {
+	CreatedAt      time.Time             `json:"createdAt"`
+	ToolVersion    string                `json:"toolVersion"`
+	MetricsAddress string                `json:"metricsAddress"`
+	Files          []bundleManifestEntry `json:"files"`
+}
+
+// newSupportBundleCommand returns the support-bundle subcommand. It collects the diagnostics of a running
+// controller from its metrics listener, together with pointers to its logs, into a single archive that
+// can be attached to issues.
+func newSupportBundleCommand(root *rootFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "support-bundle",
+		Short: "Collect the diagnostics of a running controller into an archive",
+		Args:  cobra.NoArgs,
+	}
+	fs := cmd.Flags()
+	metricsAddress := fs.String(
+		"metrics-address",
+		"http://localhost:8080",
+		"Address of the metrics listener of the controller, e.g. forwarded with kubectl port-forward.",
+	)
+	controllerNamespace := fs.String(
+		"controller-namespace",
+		"capa-annotator-system",
+		"Namespace the controller is deployed in, used to locate its pods for the log pointers.",
+	)
+	output := fs.String(
+		"output",
+		"",
+		"Path of the archive to write. Defaults to capa-annotator-support-bundle-<time>.tar.gz in the current directory.",
+	)
+	timeout := fs.Duration(
+		"timeout",
+		30*time.Second,
+		"Timeout of collecting the support bundle.",
+	)
+	tokenFile := fs.String(
+		"token-file",
+		"",
+		"File holding the bearer token of the /debug/ endpoints, if the controller is started with --debug-token-file.",
+	)
+
+	cmd.RunE = func(*cobra.Command, []string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+
+		// The log pointers are best effort, the bundle is still useful without access to the cluster
+		var c client.Client
+		cfg, err := root.kubeconfig.restConfig()
+		if err == nil {
+			scheme := runtime.NewScheme()
+			if err = corev1.AddToScheme(scheme); err == nil {
+				c, err = client.New(cfg, client.Options{Scheme: scheme})
+			}
+		}
+		if err != nil {
+			klog.Warningf("Error creating client, log pointers are omitted: %v", err)
+		}
+
+		httpClient := http.DefaultClient
+		if *tokenFile != "" {
+			token, err := os.ReadFile(*tokenFile)
+			if err != nil {
+				klog.Errorf("Error reading token file: %v", err)
+				return exitCode(1)
+			}
+			httpClient = &http.Client{Transport: &bearerTokenTransport{token: strings.TrimSpace(string(token)), base: http.DefaultTransport}}
+		}
+
+		files := collectSupportBundle(ctx, httpClient, *metricsAddress, c, *controllerNamespace)
+
+		if *output == "" {
+			*output = fmt.Sprintf("capa-annotator-support-bundle-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+		}
+		f, err := os.Create(*output)
+		if err != nil {
+			klog.Errorf("Error creating support bundle: %v", err)
+			return exitCode(1)
+		}
+		if err := writeSupportBundle(f, files); err != nil {
+			_ = f.Close()
+			klog.Errorf("Error writing support bundle: %v", err)
+			return exitCode(1)
+		}
+		if err := f.Close(); err != nil {
+			klog.Errorf("Error writing support bundle: %v", err)
+			return exitCode(1)
+		}
+
+		fmt.Printf("Wrote support bundle to %s, see manifest.json for the collected files\n", *output)
+		return nil
+	}
+	return cmd
+}
+
+// bearerTokenTransport presents a bearer token with every request.
+type bearerTokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+// RoundTrip sends the request with the bearer token.
+func (t *bearerTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
+
+// collectSupportBundle collects the files of a support bundle from the metrics listener at metricsAddress
+// and the controller pods in namespace. Sources that cannot be collected are recorded in the manifest
+// instead of failing the bundle. The client is optional.
+func collectSupportBundle(ctx context.Context, httpClient *http.Client, metricsAddress string, c client.Client, namespace string) []bundleFile {
+	if !strings.Contains(metricsAddress, "://") {
+		metricsAddress = "http://" + metricsAddress
+	}
+	metricsAddress = strings.TrimSuffix(metricsAddress, "/")
+
+	manifest := bundleManifest{
+		CreatedAt:      time.Now().UTC(),
+		ToolVersion:    version.Version,
+		MetricsAddress: metricsAddress,
+	}
+	files := []bundleFile{}
+
+	for _, source := range supportBundleSources {
+		entry := bundleManifestEntry{File: source.file, Source: metricsAddress + source.path}
+		data, status, err := fetchBundleSource(ctx, httpClient, entry.Source)
+		entry.Status = status
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		if data != nil {
+			files = append(files, bundleFile{name: source.file, data: data})
+		} else {
+			entry.File = ""
+		}
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	entry := bundleManifestEntry{File: "logs.txt", Source: "pods in namespace " + namespace}
+	logs, err := controllerLogPointers(ctx, c, namespace)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	files = append(files, bundleFile{name: entry.File, data: logs})
+	manifest.Files = append(manifest.Files, entry)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		// The manifest only consists of strings and numbers
+		panic(err)
+	}
+	return append([]bundleFile{{name: "manifest.json", data: data}}, files...)
+}
+
+// fetchBundleSource fetches a source of a support bundle. The body is returned for all responses, since
+// e.g. the annotation health responds with 503 if MachineDeployments are not annotated completely.
+func fetchBundleSource(ctx context.Context, httpClient *http.Client, source string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, resp.StatusCode, fmt.Errorf("not served, the feature is probably disabled: %s", strings.TrimSpace(string(data)))
+	}
+	return data, resp.StatusCode, nil
+}
+
+// controllerLogPointers describes the controller pods in namespace and how to get their logs. Logs
+// are not collected themselves, as they can be large and are often shipped elsewhere.
+func controllerLogPointers(ctx context.Context, c client.Client, namespace string) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Logs of all controller pods:\n  kubectl logs -n %s -l %s=capa-annotator --all-containers --prefix\n", namespace, controllerPodLabel)
+
+	if c == nil {
+		return []byte(b.String()), fmt.Errorf("no access to the cluster")
+	}
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(namespace), client.MatchingLabels{controllerPodLabel: "capa-annotator"}); err != nil {
+		return []byte(b.String()), fmt.Errorf("error listing controller pods: %w", err)
+	}
+
+	for _, pod := range pods.Items {
+		fmt.Fprintf(&b, "\nPod %s on node %q, phase %s, started %s\n", pod.Name, pod.Spec.NodeName, pod.Status.Phase, pod.CreationTimestamp.UTC().Format(time.RFC3339))
+		for _, status := range pod.Status.ContainerStatuses {
+			fmt.Fprintf(&b, "  Container %s, image %s, %d restart(s)\n", status.Name, status.Image, status.RestartCount)
+			fmt.Fprintf(&b, "    kubectl logs -n %s %s -c %s\n", namespace, pod.Name, status.Name)
+			if status.RestartCount > 0 {
+				fmt.Fprintf(&b, "    kubectl logs -n %s %s -c %s --previous\n", namespace, pod.Name, status.Name)
+			}
+		}
+	}
+	if len(pods.Items) == 0 {
+		fmt.Fprintf(&b, "\nNo controller pods found in namespace %s\n", namespace)
+	}
+	return []byte(b.String()), nil
+}
+
+// writeSupportBundle writes the files as a gzip compressed tar archive below a capa-annotator-support-bundle directory.
+func writeSupportBundle(w io.Writer, files []bundleFile) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	modTime := time.Now()
+	for _, file := range files {
+		header := &tar.Header{
+			Name:    "capa-annotator-support-bundle/" + file.name,
+			Mode:    0o644,
+			Size:    int64(len(file.data)),
+			ModTime: modTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// configHandler serves the values of the flags as JSON. Credentials of URLs, e.g. of the audit sink
+// webhook, are redacted.
+func configHandler(fs *pflag.FlagSet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		values := map[string]string{}
+		fs.VisitAll(func(f *pflag.Flag) {
+			values[f.Name] = redactURL(f.Value.String())
+		})
+
+		data, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	})
+}
+
+// redactURL redacts the password and query of value if it is a URL with a host.
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return value
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "redacted"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	machinesetcontroller "github.com/jhjaggars/capa-annotator/pkg/controller"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newUninstallCommand returns the uninstall subcommand. It removes all controller-managed annotations
+// from MachineDeployments so that the controller can be removed without leaving stale capacity data behind.
+// The controller must be stopped before, otherwise it writes the annotations back right away.
+func newUninstallCommand(root *rootFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove the annotations written by the controller",
+		Args:  cobra.NoArgs,
+	}
+	fs := cmd.Flags()
+	namespace := fs.String(
+		"namespace",
+		"",
+		"Namespace to clean up. If unspecified, MachineDeployments in all namespaces are cleaned up.",
+	)
+	dryRun := fs.Bool(
+		"dry-run",
+		false,
+		"Only print which MachineDeployments would be cleaned up, without modifying them.",
+	)
+
+	cmd.RunE = func(*cobra.Command, []string) error {
+		klog.Info("Removing managed annotations, make sure the controller is stopped or the annotations are written back")
+
+		cfg, err := root.kubeconfig.restConfig()
+		if err != nil {
+			klog.Errorf("Error getting configuration: %v", err)
+			return exitCode(1)
+		}
+
+		scheme := runtime.NewScheme()
+		if err := clusterv1.AddToScheme(scheme); err != nil {
+			klog.Errorf("Error setting up CAPI scheme: %v", err)
+			return exitCode(1)
+		}
+
+		c, err := client.New(cfg, client.Options{Scheme: scheme})
+		if err != nil {
+			klog.Errorf("Error creating client: %v", err)
+			return exitCode(1)
+		}
+
+		cleaned, err := machinesetcontroller.Uninstall(context.Background(), c, *namespace, *dryRun)
+		if err != nil {
+			klog.Errorf("Error removing managed annotations: %v", err)
+			return exitCode(1)
+		}
+
+		if *dryRun {
+			fmt.Printf("%d MachineDeployment(s) would be cleaned up\n", cleaned)
+		} else {
+			fmt.Printf("Removed managed annotations from %d MachineDeployment(s)\n", cleaned)
+		}
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// newValidateCommand returns the validate subcommand. It checks the AWS credentials of the controller and their
+// permissions, and prints a report with hints to fix the failed checks, so misconfigured credentials are found
+// before they surface as reconcile errors. It exits with 1 if any check failed.
+func newValidateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the AWS credentials and permissions of the controller",
+		Args:  cobra.NoArgs,
+	}
+	region := cmd.Flags().String(
+		"region",
+		os.Getenv("AWS_REGION"),
+		"The AWS region in which the permissions are checked, defaults to AWS_REGION.",
+	)
+
+	cmd.RunE = func(*cobra.Command, []string) error {
+		if printChecks(os.Stdout, awsclient.ValidateCredentials(*region)) > 0 {
+			return exitCode(1)
+		}
+		return nil
+	}
+	return cmd
+}
+
+// printChecks prints the checks with the hints of those not passed, and returns the number of failed checks.
+func printChecks(w io.Writer, checks []awsclient.Check) int {
+	failed, warnings := 0, 0
+	for _, check := range checks {
+		fmt.Fprintf(w, "[%s] %s: %s\n", check.Status, check.Name, check.Message)
+		if check.Hint != "" {
+			fmt.Fprintf(w, "    hint: %s\n", check.Hint)
+		}
+		switch check.Status {
+		case awsclient.CheckFailed:
+			failed++
+		case awsclient.CheckWarning:
+			warnings++
+		}
+	}
+	fmt.Fprintf(w, "%d of %d checks failed, %d warnings\n", failed, len(checks), warnings)
+	return failed
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	machinesetcontroller "github.com/jhjaggars/capa-annotator/pkg/controller"
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// newWhatIfCommand returns the what-if subcommand. It prints the annotations the controller would write
+// for a proposed instance type and region, so node group changes can be evaluated before applying them.
+func newWhatIfCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "what-if",
+		Short: "Print the annotations of a proposed instance type",
+		Args:  cobra.NoArgs,
+	}
+	fs := cmd.Flags()
+	instanceType := fs.String(
+		"instance-type",
+		"",
+		"The proposed EC2 instance type, e.g. m5.large.",
+	)
+	region := fs.String(
+		"region",
+		"",
+		"The AWS region the instance type would be used in.",
+	)
+	annotationFlags := addAnnotationFlags(fs)
+
+	cmd.RunE = func(*cobra.Command, []string) error {
+		r := &machinesetcontroller.Reconciler{
+			AwsClientBuilder:   awsclient.NewValidatedClient,
+			RegionCache:        awsclient.NewRegionCache(),
+			InstanceTypesCache: machinesetcontroller.NewInstanceTypesCache(),
+		}
+		if err := annotationFlags.apply(r); err != nil {
+			klog.Error(err)
+			return exitCode(1)
+		}
+
+		annotations, err := r.WhatIf(*region, *instanceType)
+		if err != nil {
+			klog.Errorf("Error computing annotations: %v", err)
+			return exitCode(1)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(annotations); err != nil {
+			klog.Errorf("Error writing annotations: %v", err)
+			return exitCode(1)
+		}
+		return nil
+	}
+	return cmd
+}
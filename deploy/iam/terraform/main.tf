@@ -28,8 +28,14 @@ resource "aws_iam_policy" "capa_annotator" {
       {
         Effect = "Allow"
         Action = [
+          "ec2:DescribeAvailabilityZones",
+          "ec2:DescribeImages",
+          "ec2:DescribeInstances",
           "ec2:DescribeInstanceTypes",
-          "ec2:DescribeRegions"
+          "ec2:DescribeRegions",
+          "ec2:DescribeSubnets",
+          "outposts:GetOutpostInstanceTypes",
+          "servicequotas:GetServiceQuota"
         ]
         Resource = "*"
       }
@@ -0,0 +1,220 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package azure is a minimal client of the Azure Compute Resource SKUs API, used to look up the capacity
+// of the VM sizes of AzureMachineTemplates.
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/version"
+)
+
+const (
+	// DefaultAuthorityHost is the Microsoft Entra ID endpoint of the Azure public cloud.
+	DefaultAuthorityHost = "https://login.microsoftonline.com"
+	// DefaultResourceManagerEndpoint is the Azure Resource Manager endpoint of the Azure public cloud.
+	DefaultResourceManagerEndpoint = "https://management.azure.com"
+
+	skusAPIVersion = "2021-07-01"
+	// tokenExpiryMargin is the time before their expiry after which access tokens are renewed.
+	tokenExpiryMargin = 5 * time.Minute
+)
+
+// ResourceSKU is a SKU of the Microsoft.Compute resource provider.
+type ResourceSKU struct {
+	Name         string       `json:"name"`
+	ResourceType string       `json:"resourceType"`
+	Locations    []string     `json:"locations"`
+	Capabilities []Capability `json:"capabilities"`
+}
+
+// Capability is a capability of a SKU, e.g. vCPUs or MemoryGB.
+type Capability struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Capability returns the value of the capability with the given name and whether the SKU has it.
+func (s ResourceSKU) Capability(name string) (string, bool) {
+	for _, capability := range s.Capabilities {
+		if capability.Name == name {
+			return capability.Value, true
+		}
+	}
+	return "", false
+}
+
+// SKUClient lists the Compute SKUs available to a subscription in a location.
+type SKUClient interface {
+	ListResourceSKUs(ctx context.Context, subscriptionID, location string) ([]ResourceSKU, error)
+}
+
+// Credentials of a service principal authenticating with a client secret.
+type Credentials struct {
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// AuthorityHost defaults to DefaultAuthorityHost.
+	AuthorityHost string
+	// ResourceManagerEndpoint defaults to DefaultResourceManagerEndpoint.
+	ResourceManagerEndpoint string
+}
+
+// CredentialsFromEnvironment reads the credentials from the AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_CLIENT_SECRET
+// and, optionally, AZURE_AUTHORITY_HOST and AZURE_RESOURCE_MANAGER_ENDPOINT environment variables.
+func CredentialsFromEnvironment() (Credentials, error) {
+	credentials := Credentials{
+		TenantID:                os.Getenv("AZURE_TENANT_ID"),
+		ClientID:                os.Getenv("AZURE_CLIENT_ID"),
+		ClientSecret:            os.Getenv("AZURE_CLIENT_SECRET"),
+		AuthorityHost:           os.Getenv("AZURE_AUTHORITY_HOST"),
+		ResourceManagerEndpoint: os.Getenv("AZURE_RESOURCE_MANAGER_ENDPOINT"),
+	}
+	var missing []string
+	if credentials.TenantID == "" {
+		missing = append(missing, "AZURE_TENANT_ID")
+	}
+	if credentials.ClientID == "" {
+		missing = append(missing, "AZURE_CLIENT_ID")
+	}
+	if credentials.ClientSecret == "" {
+		missing = append(missing, "AZURE_CLIENT_SECRET")
+	}
+	if len(missing) > 0 {
+		return Credentials{}, fmt.Errorf("missing Azure credentials, set %s", strings.Join(missing, ", "))
+	}
+	return credentials, nil
+}
+
+// NewSKUClient returns a SKUClient authenticating with the client credentials flow of the service principal.
+func NewSKUClient(credentials Credentials) SKUClient {
+	if credentials.AuthorityHost == "" {
+		credentials.AuthorityHost = DefaultAuthorityHost
+	}
+	if credentials.ResourceManagerEndpoint == "" {
+		credentials.ResourceManagerEndpoint = DefaultResourceManagerEndpoint
+	}
+	return &skuClient{
+		credentials: credentials,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type skuClient struct {
+	credentials Credentials
+	httpClient  *http.Client
+
+	mutex       sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// ListResourceSKUs lists the virtual machine SKUs of the location, following the pages of the response.
+func (c *skuClient) ListResourceSKUs(ctx context.Context, subscriptionID, location string) ([]ResourceSKU, error) {
+	query := url.Values{}
+	query.Set("api-version", skusAPIVersion)
+	query.Set("$filter", fmt.Sprintf("location eq '%s'", location))
+	next := fmt.Sprintf("%s/subscriptions/%s/providers/Microsoft.Compute/skus?%s",
+		strings.TrimSuffix(c.credentials.ResourceManagerEndpoint, "/"), url.PathEscape(subscriptionID), query.Encode())
+
+	var skus []ResourceSKU
+	for next != "" {
+		var page struct {
+			Value    []ResourceSKU `json:"value"`
+			NextLink string        `json:"nextLink"`
+		}
+		if err := c.get(ctx, next, &page); err != nil {
+			return nil, fmt.Errorf("error listing resource SKUs of subscription %s in location %s: %w", subscriptionID, location, err)
+		}
+		for _, sku := range page.Value {
+			if sku.ResourceType == "virtualMachines" {
+				skus = append(skus, sku)
+			}
+		}
+		next = page.NextLink
+	}
+	return skus, nil
+}
+
+func (c *skuClient) get(ctx context.Context, url string, into interface{}) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("User-Agent", "github.com/jhjaggars capa-annotator/"+version.Version)
+	return c.do(request, into)
+}
+
+// accessToken returns a cached access token for Azure Resource Manager, requesting a new one before it expires.
+func (c *skuClient) accessToken(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", c.credentials.ClientID)
+	form.Set("client_secret", c.credentials.ClientSecret)
+	form.Set("scope", strings.TrimSuffix(c.credentials.ResourceManagerEndpoint, "/")+"/.default")
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(c.credentials.AuthorityHost, "/"), url.PathEscape(c.credentials.TenantID))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := c.do(request, &token); err != nil {
+		return "", fmt.Errorf("error requesting access token: %w", err)
+	}
+	c.token = token.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return c.token, nil
+}
+
+func (c *skuClient) do(request *http.Request, into interface{}) error {
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, into)
+}
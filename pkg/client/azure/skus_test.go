@@ -0,0 +1,100 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestListResourceSKUs(t *testing.T) {
+	g := NewWithT(t)
+
+	tokenRequests := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tenant/oauth2/v2.0/token":
+			tokenRequests++
+			g.Expect(r.ParseForm()).To(Succeed())
+			g.Expect(r.PostForm.Get("grant_type")).To(Equal("client_credentials"))
+			g.Expect(r.PostForm.Get("client_id")).To(Equal("client"))
+			g.Expect(r.PostForm.Get("client_secret")).To(Equal("secret"))
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600})
+		case "/subscriptions/subscription/providers/Microsoft.Compute/skus":
+			g.Expect(r.Header.Get("Authorization")).To(Equal("Bearer token"))
+			if r.URL.Query().Get("page") == "2" {
+				_, _ = w.Write([]byte(`{"value": [{"name": "Standard_NC6s_v3", "resourceType": "virtualMachines", "capabilities": [{"name": "GPUs", "value": "1"}]}]}`))
+				return
+			}
+			g.Expect(r.URL.Query().Get("$filter")).To(Equal("location eq 'westeurope'"))
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"value": []map[string]interface{}{
+					{"name": "Standard_D4s_v3", "resourceType": "virtualMachines", "capabilities": []map[string]string{{"name": "vCPUs", "value": "4"}}},
+					{"name": "Premium_LRS", "resourceType": "disks"},
+				},
+				"nextLink": server.URL + r.URL.Path + "?page=2",
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewSKUClient(Credentials{
+		TenantID:                "tenant",
+		ClientID:                "client",
+		ClientSecret:            "secret",
+		AuthorityHost:           server.URL,
+		ResourceManagerEndpoint: server.URL,
+	})
+
+	skus, err := client.ListResourceSKUs(context.Background(), "subscription", "westeurope")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(skus).To(HaveLen(2))
+	g.Expect(skus[0].Name).To(Equal("Standard_D4s_v3"))
+	vcpus, ok := skus[0].Capability("vCPUs")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(vcpus).To(Equal("4"))
+	g.Expect(skus[1].Name).To(Equal("Standard_NC6s_v3"))
+
+	// The access token is reused until it expires
+	_, err = client.ListResourceSKUs(context.Background(), "subscription", "westeurope")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tokenRequests).To(Equal(1))
+
+	_, err = client.ListResourceSKUs(context.Background(), "unknown", "westeurope")
+	g.Expect(err).To(MatchError(ContainSubstring("unexpected status 404")))
+}
+
+func TestCredentialsFromEnvironment(t *testing.T) {
+	g := NewWithT(t)
+
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_CLIENT_ID", "")
+	t.Setenv("AZURE_CLIENT_SECRET", "")
+	_, err := CredentialsFromEnvironment()
+	g.Expect(err).To(MatchError("missing Azure credentials, set AZURE_CLIENT_ID, AZURE_CLIENT_SECRET"))
+
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_CLIENT_SECRET", "secret")
+	credentials, err := CredentialsFromEnvironment()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(credentials.TenantID).To(Equal("tenant"))
+}
@@ -17,16 +17,20 @@ limitations under the License.
 package client
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
 	"github.com/jhjaggars/capa-annotator/pkg/version"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -37,6 +41,10 @@ import (
 	"github.com/aws/aws-sdk-go/service/elb/elbiface"
 	"github.com/aws/aws-sdk-go/service/elbv2"
 	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/outposts"
+	"github.com/aws/aws-sdk-go/service/outposts/outpostsiface"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	"github.com/aws/aws-sdk-go/service/servicequotas/servicequotasiface"
 )
 
 //go:generate go run ../../vendor/github.com/golang/mock/mockgen -source=./client.go -destination=./mock/client_generated.go -package=mock
@@ -73,12 +81,18 @@ type Client interface {
 	ELBv2DescribeTargetHealth(*elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error)
 	ELBv2RegisterTargets(*elbv2.RegisterTargetsInput) (*elbv2.RegisterTargetsOutput, error)
 	ELBv2DeregisterTargets(*elbv2.DeregisterTargetsInput) (*elbv2.DeregisterTargetsOutput, error)
+
+	GetOutpostInstanceTypes(*outposts.GetOutpostInstanceTypesInput) (*outposts.GetOutpostInstanceTypesOutput, error)
+
+	GetServiceQuota(*servicequotas.GetServiceQuotaInput) (*servicequotas.GetServiceQuotaOutput, error)
 }
 
 type awsClient struct {
-	ec2Client   ec2iface.EC2API
-	elbClient   elbiface.ELBAPI
-	elbv2Client elbv2iface.ELBV2API
+	ec2Client           ec2iface.EC2API
+	elbClient           elbiface.ELBAPI
+	elbv2Client         elbv2iface.ELBV2API
+	outpostsClient      outpostsiface.OutpostsAPI
+	serviceQuotasClient servicequotasiface.ServiceQuotasAPI
 }
 
 func (c *awsClient) DescribeDHCPOptions(input *ec2.DescribeDhcpOptionsInput) (*ec2.DescribeDhcpOptionsOutput, error) {
@@ -165,20 +179,30 @@ func (c *awsClient) ELBv2DeregisterTargets(input *elbv2.DeregisterTargetsInput)
 	return c.elbv2Client.DeregisterTargets(input)
 }
 
+func (c *awsClient) GetOutpostInstanceTypes(input *outposts.GetOutpostInstanceTypesInput) (*outposts.GetOutpostInstanceTypesOutput, error) {
+	return c.outpostsClient.GetOutpostInstanceTypes(input)
+}
+
+func (c *awsClient) GetServiceQuota(input *servicequotas.GetServiceQuotaInput) (*servicequotas.GetServiceQuotaOutput, error) {
+	return c.serviceQuotasClient.GetServiceQuota(input)
+}
+
 // NewClient creates our client wrapper object for the actual AWS clients we use.
-// For authentication the underlying clients will use IRSA (IAM Roles for Service Accounts)
-// or fall back to the default AWS credential chain.
-// Note: secretName and namespace parameters are deprecated and unused (kept for API compatibility).
+// For authentication the underlying clients will use the credentials of the Secret in the namespace
+// if secretName is set, e.g. the Secret of an AWSClusterStaticIdentity. Otherwise they use IRSA,
+// EKS Pod Identity or fall back to the default AWS credential chain.
 func NewClient(ctrlRuntimeClient client.Client, secretName, namespace, region string) (Client, error) {
-	s, err := newAWSSession(region)
+	s, err := newSession(ctrlRuntimeClient, secretName, namespace, region)
 	if err != nil {
 		return nil, err
 	}
 
 	return &awsClient{
-		ec2Client:   ec2.New(s),
-		elbClient:   elb.New(s),
-		elbv2Client: elbv2.New(s),
+		ec2Client:           ec2.New(s),
+		elbClient:           elb.New(s),
+		elbv2Client:         elbv2.New(s),
+		outpostsClient:      outposts.New(s),
+		serviceQuotasClient: servicequotas.New(s),
 	}, nil
 }
 
@@ -199,11 +223,14 @@ func NewClientFromKeys(accessKey, secretAccessKey, region string) (Client, error
 		return nil, err
 	}
 	s.Handlers.Build.PushBackNamed(addProviderVersionToUserAgent)
+	s.Handlers.Complete.PushBackNamed(recordAPICall)
 
 	return &awsClient{
-		ec2Client:   ec2.New(s),
-		elbClient:   elb.New(s),
-		elbv2Client: elbv2.New(s),
+		ec2Client:           ec2.New(s),
+		elbClient:           elb.New(s),
+		elbv2Client:         elbv2.New(s),
+		outpostsClient:      outposts.New(s),
+		serviceQuotasClient: servicequotas.New(s),
 	}, nil
 }
 
@@ -269,6 +296,54 @@ func (c *regionCache) GetCachedDescribeRegions(awsSession *session.Session) (*ec
 	return describeRegionsOutput, nil
 }
 
+// CachedRegions is the cached output of DescribeRegions for a set of credentials.
+type CachedRegions struct {
+	LastUpdate time.Time `json:"lastUpdate"`
+	// Stale is true if the regions are described again on their next use.
+	Stale bool `json:"stale"`
+	// Regions maps the names of the regions to their opt-in status.
+	Regions map[string]string `json:"regions"`
+}
+
+// RegionCacheDumper is implemented by region caches whose content can be inspected.
+type RegionCacheDumper interface {
+	// DumpRegions returns the cached regions by masked access key ID.
+	DumpRegions() map[string]CachedRegions
+}
+
+// DumpRegions returns the cached regions. The access key IDs are masked except for their last 4 characters.
+func (c *regionCache) DumpRegions() map[string]CachedRegions {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	dump := make(map[string]CachedRegions, len(c.data))
+	for accessKeyID, regionData := range c.data {
+		if regionData.describeRegionsOutput == nil {
+			continue
+		}
+		regions := make(map[string]string, len(regionData.describeRegionsOutput.Regions))
+		for _, region := range regionData.describeRegionsOutput.Regions {
+			if region != nil && region.RegionName != nil {
+				regions[*region.RegionName] = aws.StringValue(region.OptInStatus)
+			}
+		}
+		dump[maskAccessKeyID(accessKeyID)] = CachedRegions{
+			LastUpdate: regionData.lastUpdated,
+			Stale:      time.Since(regionData.lastUpdated) >= awsRegionsCacheExpirationDuration,
+			Regions:    regions,
+		}
+	}
+	return dump
+}
+
+// maskAccessKeyID masks all but the last 4 characters of an access key ID.
+func maskAccessKeyID(accessKeyID string) string {
+	if len(accessKeyID) <= 4 {
+		return strings.Repeat("*", len(accessKeyID))
+	}
+	return strings.Repeat("*", len(accessKeyID)-4) + accessKeyID[len(accessKeyID)-4:]
+}
+
 // Check that region is in the DescribeRegions list and is opted in.
 func validateRegion(describeRegionsOutput *ec2.DescribeRegionsOutput, region string) (*ec2.Region, error) {
 	var regionData *ec2.Region
@@ -292,18 +367,22 @@ func validateRegion(describeRegionsOutput *ec2.DescribeRegionsOutput, region str
 // NewValidatedClient creates our client wrapper object for the actual AWS clients we use.
 // This should behave the same as NewClient except it will validate the client configuration
 // (eg the region) before returning the client.
-// Note: ctrlRuntimeClient, secretName and namespace parameters are deprecated and unused (kept for API compatibility).
 func NewValidatedClient(ctrlRuntimeClient client.Client, secretName, namespace, region string, regionCache RegionCache) (Client, error) {
-	s, err := newAWSSession(region)
+	s, err := newSession(ctrlRuntimeClient, secretName, namespace, region)
 	if err != nil {
 		return nil, err
 	}
 
+	return newValidatedClient(s, region, regionCache)
+}
+
+// newValidatedClient validates the region of the session and creates the client wrapper object of the session.
+func newValidatedClient(s *session.Session, region string, regionCache RegionCache) (Client, error) {
 	// Check that the endpoint can be resolved by the endpoint resolver.
 	// If the endpoint is not resolvable locally, we try to validate using the AWS API.
 	// If the endpoint is not known, it is not a standard or configured custom region.
 	// In that case, the client will likely not be able to connect
-	_, err = s.Config.EndpointResolver.EndpointFor("ec2", region, func(opts *endpoints.Options) {
+	_, err := s.Config.EndpointResolver.EndpointFor("ec2", region, func(opts *endpoints.Options) {
 		opts.StrictMatching = true
 	})
 	if err != nil {
@@ -327,12 +406,40 @@ func NewValidatedClient(ctrlRuntimeClient client.Client, secretName, namespace,
 	}
 
 	return &awsClient{
-		ec2Client:   ec2.New(s),
-		elbClient:   elb.New(s),
-		elbv2Client: elbv2.New(s),
+		ec2Client:           ec2.New(s),
+		elbClient:           elb.New(s),
+		elbv2Client:         elbv2.New(s),
+		outpostsClient:      outposts.New(s),
+		serviceQuotasClient: servicequotas.New(s),
 	}, nil
 }
 
+// credentialSource is the source of the controller's own AWS credentials.
+type credentialSource string
+
+const (
+	credentialSourceEnvironment  credentialSource = "environment variables"
+	credentialSourceIRSA         credentialSource = "IRSA"
+	credentialSourcePodIdentity  credentialSource = "EKS Pod Identity"
+	credentialSourceDefaultChain credentialSource = "default credential chain"
+)
+
+// detectCredentialSource returns the source of the credentials the AWS SDK resolves from the environment,
+// in the order of its credential chain: access keys, IRSA, and EKS Pod Identity, whose agent serves
+// credentials on the container credentials endpoint.
+func detectCredentialSource() credentialSource {
+	switch {
+	case os.Getenv("AWS_ACCESS_KEY_ID") != "" && os.Getenv("AWS_SECRET_ACCESS_KEY") != "":
+		return credentialSourceEnvironment
+	case os.Getenv("AWS_ROLE_ARN") != "" && os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "":
+		return credentialSourceIRSA
+	case os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" && os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE") != "":
+		return credentialSourcePodIdentity
+	default:
+		return credentialSourceDefaultChain
+	}
+}
+
 func newAWSSession(region string) (*session.Session, error) {
 	sessionOptions := session.Options{
 		Config: aws.Config{
@@ -340,21 +447,25 @@ func newAWSSession(region string) (*session.Session, error) {
 		},
 	}
 
-	// Check for IRSA environment variables
-	roleARN := os.Getenv("AWS_ROLE_ARN")
-	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
-
-	// Prefer IRSA if configured, otherwise fall back to default credential chain
-	// This allows local testing with ~/.aws/credentials or environment variables
-	if roleARN != "" && tokenFile != "" {
-		klog.Infof("Using IRSA authentication with role: %s", roleARN)
-		// AWS SDK v1 will automatically detect and use web identity credentials
-		// from the environment variables - no explicit configuration needed
-	} else {
-		klog.Info("IRSA not configured, using default AWS credential chain (environment variables, ~/.aws/credentials, EC2 metadata, etc.)")
+	// AWS SDK v1 detects and uses all of these from the environment - no explicit configuration needed.
+	// The default credential chain allows local testing with ~/.aws/credentials.
+	switch detectCredentialSource() {
+	case credentialSourceEnvironment:
+		klog.Info("Using the AWS credentials of the environment variables")
+	case credentialSourceIRSA:
+		klog.Infof("Using IRSA authentication with role: %s", os.Getenv("AWS_ROLE_ARN"))
+	case credentialSourcePodIdentity:
+		// The SDK reads the token on every refresh, so that rotated tokens are picked up
+		tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE")
+		klog.Infof("Using EKS Pod Identity authentication with endpoint: %s", os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"))
+		if _, err := os.Stat(tokenFile); err != nil {
+			klog.Warningf("EKS Pod Identity token file is not readable, AWS calls will fail until it is: %v", err)
+		}
+	default:
+		klog.Info("IRSA and EKS Pod Identity not configured, using default AWS credential chain (~/.aws/credentials, EC2 metadata, etc.)")
 		// AWS SDK will use the default credential chain:
-		// 1. Environment variables (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY)
-		// 2. Shared credentials file (~/.aws/credentials)
+		// 1. Shared credentials file (~/.aws/credentials)
+		// 2. Container credentials endpoint
 		// 3. EC2 instance metadata
 	}
 
@@ -365,10 +476,31 @@ func newAWSSession(region string) (*session.Session, error) {
 	}
 
 	s.Handlers.Build.PushBackNamed(addProviderVersionToUserAgent)
+	s.Handlers.Complete.PushBackNamed(recordAPICall)
 
 	return s, nil
 }
 
+// unknownErrorCode is the error code of failed AWS API calls whose error is not an AWS error.
+const unknownErrorCode = "Unknown"
+
+// recordAPICall is a named handler that counts the completed requests made by the AWS SDK, once per call
+// regardless of the retries.
+var recordAPICall = request.NamedHandler{
+	Name: "capa-annotator/metrics",
+	Fn: func(r *request.Request) {
+		code := ""
+		if r.Error != nil {
+			code = unknownErrorCode
+			var awsErr awserr.Error
+			if errors.As(r.Error, &awsErr) {
+				code = awsErr.Code()
+			}
+		}
+		metrics.RecordAWSAPICall(r.ClientInfo.ServiceName, r.Operation.Name, code)
+	},
+}
+
 // addProviderVersionToUserAgent is a named handler that will add cluster-api-provider-aws
 // version information to requests made by the AWS SDK.
 var addProviderVersionToUserAgent = request.NamedHandler{
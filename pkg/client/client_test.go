@@ -1,10 +1,21 @@
 package client
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestNewAWSSessionIRSA(t *testing.T) {
@@ -72,3 +83,151 @@ func TestNewAWSSessionIRSA(t *testing.T) {
 		})
 	}
 }
+
+func TestDetectCredentialSource(t *testing.T) {
+	testCases := []struct {
+		name     string
+		env      map[string]string
+		expected credentialSource
+	}{
+		{
+			name:     "nothing configured",
+			expected: credentialSourceDefaultChain,
+		},
+		{
+			name: "EKS Pod Identity",
+			env: map[string]string{
+				"AWS_CONTAINER_CREDENTIALS_FULL_URI":     "http://169.254.170.23/v1/credentials",
+				"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE": "/var/run/secrets/pods.eks.amazonaws.com/serviceaccount/eks-pod-identity-token",
+			},
+			expected: credentialSourcePodIdentity,
+		},
+		{
+			name: "container credentials endpoint without a token",
+			env: map[string]string{
+				"AWS_CONTAINER_CREDENTIALS_FULL_URI": "http://169.254.170.23/v1/credentials",
+			},
+			expected: credentialSourceDefaultChain,
+		},
+		{
+			name: "IRSA takes precedence over EKS Pod Identity",
+			env: map[string]string{
+				"AWS_ROLE_ARN":                           "arn:aws:iam::123456789012:role/my-role",
+				"AWS_WEB_IDENTITY_TOKEN_FILE":            "/var/run/secrets/eks.amazonaws.com/serviceaccount/token",
+				"AWS_CONTAINER_CREDENTIALS_FULL_URI":     "http://169.254.170.23/v1/credentials",
+				"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE": "/var/run/secrets/pods.eks.amazonaws.com/serviceaccount/eks-pod-identity-token",
+			},
+			expected: credentialSourceIRSA,
+		},
+		{
+			name: "access keys take precedence over all",
+			env: map[string]string{
+				"AWS_ACCESS_KEY_ID":                      "AKIAEXAMPLE",
+				"AWS_SECRET_ACCESS_KEY":                  "secret",
+				"AWS_CONTAINER_CREDENTIALS_FULL_URI":     "http://169.254.170.23/v1/credentials",
+				"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE": "/var/run/secrets/pods.eks.amazonaws.com/serviceaccount/eks-pod-identity-token",
+			},
+			expected: credentialSourceEnvironment,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE",
+				"AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"} {
+				t.Setenv(name, tc.env[name])
+			}
+
+			g.Expect(detectCredentialSource()).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestNewAWSSessionPodIdentity(t *testing.T) {
+	g := NewWithT(t)
+
+	// The EKS Pod Identity agent serves credentials to requests authorized with the token
+	tokenFile := filepath.Join(t.TempDir(), "eks-pod-identity-token")
+	g.Expect(os.WriteFile(tokenFile, []byte("pod-identity-token"), 0o600)).To(Succeed())
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "pod-identity-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"AccessKeyId":     "AKIAPODIDENTITY",
+			"SecretAccessKey": "secret",
+			"Token":           "session-token",
+			"Expiration":      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+	}))
+	defer agent.Close()
+
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", agent.URL)
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", tokenFile)
+
+	s, err := newAWSSession("us-east-1")
+	g.Expect(err).ToNot(HaveOccurred())
+	value, err := s.Config.Credentials.Get()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(value.AccessKeyID).To(Equal("AKIAPODIDENTITY"))
+	g.Expect(value.SessionToken).To(Equal("session-token"))
+}
+
+func TestRecordAPICall(t *testing.T) {
+	g := NewWithT(t)
+
+	ec2API := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`<Response><Errors><Error><Code>UnauthorizedOperation</Code><Message>denied</Message></Error></Errors><RequestID>1</RequestID></Response>`))
+	}))
+	defer ec2API.Close()
+
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(ec2API.URL),
+		Credentials: credentials.NewStaticCredentials("AKIA", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	s.Handlers.Complete.PushBackNamed(recordAPICall)
+
+	calls := testutil.ToFloat64(metrics.AWSAPICalls.WithLabelValues("ec2", "DescribeInstanceTypes"))
+	failures := testutil.ToFloat64(metrics.AWSAPICallErrors.WithLabelValues("ec2", "DescribeInstanceTypes", "UnauthorizedOperation"))
+
+	_, err = ec2.New(s).DescribeInstanceTypes(&ec2.DescribeInstanceTypesInput{})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(testutil.ToFloat64(metrics.AWSAPICalls.WithLabelValues("ec2", "DescribeInstanceTypes"))).To(Equal(calls + 1))
+	g.Expect(testutil.ToFloat64(metrics.AWSAPICallErrors.WithLabelValues("ec2", "DescribeInstanceTypes", "UnauthorizedOperation"))).To(Equal(failures + 1))
+}
+
+func TestDumpRegions(t *testing.T) {
+	g := NewWithT(t)
+
+	cache := &regionCache{data: map[string]DescribeRegionsData{
+		"AKIAEXAMPLE1234": {
+			describeRegionsOutput: &ec2.DescribeRegionsOutput{Regions: []*ec2.Region{
+				{RegionName: aws.String("us-east-1"), OptInStatus: aws.String("opt-in-not-required")},
+				{RegionName: aws.String("af-south-1"), OptInStatus: aws.String("not-opted-in")},
+			}},
+			lastUpdated: time.Now().Add(-time.Hour),
+		},
+		// Failed calls are not dumped
+		"AKIAFAILED": {},
+	}}
+
+	dump := cache.DumpRegions()
+	g.Expect(dump).To(HaveLen(1))
+	g.Expect(dump).To(HaveKey("***********1234"))
+	g.Expect(dump["***********1234"].Stale).To(BeTrue())
+	g.Expect(dump["***********1234"].Regions).To(Equal(map[string]string{
+		"us-east-1":  "opt-in-not-required",
+		"af-south-1": "not-opted-in",
+	}))
+}
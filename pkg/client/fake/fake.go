@@ -1,25 +1,55 @@
 package fake
 
 import (
+	"strings"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/outposts"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
 	"github.com/jhjaggars/capa-annotator/pkg/client"
 	"k8s.io/client-go/kubernetes"
 )
 
+const (
+	// OutpostSubnetID is the ID of the fake subnet on the fake Outpost.
+	OutpostSubnetID = "subnet-0b9f8a2c1d3e4f5a6"
+	// OutpostARN is the ARN of the fake Outpost. a1.2xlarge is the only instance type available on it.
+	OutpostARN = "arn:aws:outposts:us-east-1:123456789012:outpost/op-0abcdef1234567890"
+	// VCPUQuota is the value of all fake service quotas.
+	VCPUQuota = 32
+)
+
 type awsClient struct {
 }
 
 func (c *awsClient) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
-	return &ec2.DescribeImagesOutput{
-		Images: []*ec2.Image{
-			{
-				ImageId: aws.String("ami-a9acbbd6"),
+	if len(input.ImageIds) == 0 {
+		return &ec2.DescribeImagesOutput{
+			Images: []*ec2.Image{
+				{
+					ImageId:      aws.String("ami-a9acbbd6"),
+					Architecture: aws.String(ec2.ArchitectureValuesX8664),
+				},
 			},
-		},
-	}, nil
+		}, nil
+	}
+
+	// Images whose ID contains "arm64" are arm64 images, all others x86_64 images
+	output := &ec2.DescribeImagesOutput{}
+	for _, imageID := range aws.StringValueSlice(input.ImageIds) {
+		architecture := ec2.ArchitectureValuesX8664
+		if strings.Contains(imageID, "arm64") {
+			architecture = ec2.ArchitectureValuesArm64
+		}
+		output.Images = append(output.Images, &ec2.Image{
+			ImageId:      aws.String(imageID),
+			Architecture: aws.String(architecture),
+		})
+	}
+	return output, nil
 }
 
 func (c *awsClient) DescribeVpcs(input *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
@@ -27,6 +57,16 @@ func (c *awsClient) DescribeVpcs(input *ec2.DescribeVpcsInput) (*ec2.DescribeVpc
 }
 
 func (c *awsClient) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	if len(input.SubnetIds) == 1 && aws.StringValue(input.SubnetIds[0]) == OutpostSubnetID {
+		return &ec2.DescribeSubnetsOutput{
+			Subnets: []*ec2.Subnet{
+				{
+					SubnetId:   aws.String(OutpostSubnetID),
+					OutpostArn: aws.String(OutpostARN),
+				},
+			},
+		}, nil
+	}
 	return &ec2.DescribeSubnetsOutput{
 		Subnets: []*ec2.Subnet{
 			{
@@ -37,7 +77,18 @@ func (c *awsClient) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.Descr
 }
 
 func (c *awsClient) DescribeAvailabilityZones(*ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
-	return &ec2.DescribeAvailabilityZonesOutput{}, nil
+	return &ec2.DescribeAvailabilityZonesOutput{
+		AvailabilityZones: []*ec2.AvailabilityZone{
+			{
+				ZoneName: aws.String("us-east-1a"),
+				ZoneId:   aws.String("use1-az6"),
+			},
+			{
+				ZoneName: aws.String("us-east-1b"),
+				ZoneId:   aws.String("use1-az1"),
+			},
+		},
+	}, nil
 }
 
 func (c *awsClient) DescribeSecurityGroups(input *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
@@ -74,6 +125,17 @@ func (c *awsClient) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput)
 				MemoryInfo: &ec2.MemoryInfo{
 					SizeInMiB: aws.Int64(16384),
 				},
+				NetworkInfo: &ec2.NetworkInfo{
+					MaximumNetworkInterfaces:  aws.Int64(4),
+					Ipv4AddressesPerInterface: aws.Int64(15),
+					DefaultNetworkCardIndex:   aws.Int64(0),
+					NetworkCards: []*ec2.NetworkCardInfo{
+						{
+							NetworkCardIndex:         aws.Int64(0),
+							MaximumNetworkInterfaces: aws.Int64(4),
+						},
+					},
+				},
 				VCpuInfo: &ec2.VCpuInfo{
 					DefaultVCpus: aws.Int64(8),
 				},
@@ -88,6 +150,17 @@ func (c *awsClient) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput)
 				MemoryInfo: &ec2.MemoryInfo{
 					SizeInMiB: aws.Int64(749568),
 				},
+				NetworkInfo: &ec2.NetworkInfo{
+					MaximumNetworkInterfaces:  aws.Int64(8),
+					Ipv4AddressesPerInterface: aws.Int64(30),
+					DefaultNetworkCardIndex:   aws.Int64(0),
+					NetworkCards: []*ec2.NetworkCardInfo{
+						{
+							NetworkCardIndex:         aws.Int64(0),
+							MaximumNetworkInterfaces: aws.Int64(8),
+						},
+					},
+				},
 				VCpuInfo: &ec2.VCpuInfo{
 					DefaultVCpus: aws.Int64(64),
 				},
@@ -197,6 +270,27 @@ func (c *awsClient) ELBv2DeregisterTargets(*elbv2.DeregisterTargetsInput) (*elbv
 	return &elbv2.DeregisterTargetsOutput{}, nil
 }
 
+func (c *awsClient) GetOutpostInstanceTypes(input *outposts.GetOutpostInstanceTypesInput) (*outposts.GetOutpostInstanceTypesOutput, error) {
+	return &outposts.GetOutpostInstanceTypesOutput{
+		InstanceTypes: []*outposts.InstanceTypeItem{
+			{
+				InstanceType: aws.String("a1.2xlarge"),
+			},
+		},
+		OutpostArn: aws.String(OutpostARN),
+	}, nil
+}
+
+func (c *awsClient) GetServiceQuota(input *servicequotas.GetServiceQuotaInput) (*servicequotas.GetServiceQuotaOutput, error) {
+	return &servicequotas.GetServiceQuotaOutput{
+		Quota: &servicequotas.ServiceQuota{
+			ServiceCode: input.ServiceCode,
+			QuotaCode:   input.QuotaCode,
+			Value:       aws.Float64(VCPUQuota),
+		},
+	}, nil
+}
+
 // NewClient creates a fake AWS client for testing.
 func NewClient(kubeClient kubernetes.Interface, secretName, namespace, region string) (client.Client, error) {
 	return &awsClient{}, nil
@@ -0,0 +1,280 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gcp is a minimal client of the Compute Engine machine types API, used to look up the capacity of
+// the machine types of GCPMachineTemplates.
+package gcp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/version"
+)
+
+const (
+	// DefaultComputeEndpoint is the endpoint of the Compute Engine API.
+	DefaultComputeEndpoint = "https://compute.googleapis.com/compute/v1"
+	// DefaultMetadataEndpoint is the endpoint of the metadata server of GCE instances and GKE workload identity.
+	DefaultMetadataEndpoint = "http://metadata.google.internal/computeMetadata/v1"
+
+	computeReadOnlyScope = "https://www.googleapis.com/auth/compute.readonly"
+	// tokenExpiryMargin is the time before their expiry after which access tokens are renewed.
+	tokenExpiryMargin = 5 * time.Minute
+)
+
+// MachineType is a Compute Engine machine type.
+type MachineType struct {
+	Name         string        `json:"name"`
+	GuestCpus    int64         `json:"guestCpus"`
+	MemoryMb     int64         `json:"memoryMb"`
+	Accelerators []Accelerator `json:"accelerators"`
+	// Architecture is X86_64 or ARM64, it is empty for older machine types, which are all X86_64.
+	Architecture string `json:"architecture"`
+}
+
+// Accelerator is a GPU attached to every instance of a machine type, e.g. of the A2 machine series.
+type Accelerator struct {
+	GuestAcceleratorType  string `json:"guestAcceleratorType"`
+	GuestAcceleratorCount int64  `json:"guestAcceleratorCount"`
+}
+
+// MachineTypesClient gets machine types of a zone.
+type MachineTypesClient interface {
+	GetMachineType(ctx context.Context, project, zone, machineType string) (MachineType, error)
+}
+
+// Credentials of a service account key file. Without a key, access tokens are requested from the metadata server.
+type Credentials struct {
+	// ServiceAccountKey is the JSON service account key.
+	ServiceAccountKey []byte
+	// ComputeEndpoint defaults to DefaultComputeEndpoint.
+	ComputeEndpoint string
+	// MetadataEndpoint defaults to DefaultMetadataEndpoint.
+	MetadataEndpoint string
+}
+
+// CredentialsFromEnvironment reads the service account key of the GOOGLE_APPLICATION_CREDENTIALS environment
+// variable, if set. Otherwise the metadata server is used, e.g. with GKE workload identity.
+func CredentialsFromEnvironment() (Credentials, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		return Credentials{}, nil
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("error reading GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+	}
+	if _, err := parseServiceAccountKey(key); err != nil {
+		return Credentials{}, fmt.Errorf("invalid GOOGLE_APPLICATION_CREDENTIALS %s: %w", path, err)
+	}
+	return Credentials{ServiceAccountKey: key}, nil
+}
+
+// serviceAccountKey is the part of a JSON service account key used to request access tokens.
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	TokenURI    string `json:"token_uri"`
+	privateKey  *rsa.PrivateKey
+}
+
+func parseServiceAccountKey(data []byte) (*serviceAccountKey, error) {
+	var key struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	if key.Type != "service_account" || key.ClientEmail == "" || key.TokenURI == "" {
+		return nil, fmt.Errorf("not a service account key")
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	return &serviceAccountKey{ClientEmail: key.ClientEmail, TokenURI: key.TokenURI, privateKey: privateKey}, nil
+}
+
+// NewMachineTypesClient returns a MachineTypesClient authenticating with the credentials.
+func NewMachineTypesClient(credentials Credentials) (MachineTypesClient, error) {
+	if credentials.ComputeEndpoint == "" {
+		credentials.ComputeEndpoint = DefaultComputeEndpoint
+	}
+	if credentials.MetadataEndpoint == "" {
+		credentials.MetadataEndpoint = DefaultMetadataEndpoint
+	}
+	c := &machineTypesClient{
+		credentials: credentials,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+	if len(credentials.ServiceAccountKey) > 0 {
+		key, err := parseServiceAccountKey(credentials.ServiceAccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service account key: %w", err)
+		}
+		c.key = key
+	}
+	return c, nil
+}
+
+type machineTypesClient struct {
+	credentials Credentials
+	httpClient  *http.Client
+	// key is nil if access tokens are requested from the metadata server.
+	key *serviceAccountKey
+
+	mutex       sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// GetMachineType gets the machine type in the zone of the project.
+func (c *machineTypesClient) GetMachineType(ctx context.Context, project, zone, machineType string) (MachineType, error) {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return MachineType{}, err
+	}
+	machineTypeURL := fmt.Sprintf("%s/projects/%s/zones/%s/machineTypes/%s", strings.TrimSuffix(c.credentials.ComputeEndpoint, "/"),
+		url.PathEscape(project), url.PathEscape(zone), url.PathEscape(machineType))
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, machineTypeURL, nil)
+	if err != nil {
+		return MachineType{}, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("User-Agent", "github.com/jhjaggars capa-annotator/"+version.Version)
+
+	result := MachineType{}
+	if err := c.do(request, &result); err != nil {
+		return MachineType{}, fmt.Errorf("error getting machine type %s in zone %s of project %s: %w", machineType, zone, project, err)
+	}
+	return result, nil
+}
+
+// accessToken returns a cached access token for the Compute Engine API, requesting a new one before it expires.
+func (c *machineTypesClient) accessToken(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	request, err := c.tokenRequest(ctx)
+	if err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := c.do(request, &token); err != nil {
+		return "", fmt.Errorf("error requesting access token: %w", err)
+	}
+	c.token = token.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return c.token, nil
+}
+
+// tokenRequest returns the request of an access token, exchanging a JWT signed with the service account key
+// or asking the metadata server.
+func (c *machineTypesClient) tokenRequest(ctx context.Context) (*http.Request, error) {
+	if c.key == nil {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			strings.TrimSuffix(c.credentials.MetadataEndpoint, "/")+"/instance/service-accounts/default/token?scopes="+url.QueryEscape(computeReadOnlyScope), nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Metadata-Flavor", "Google")
+		return request, nil
+	}
+
+	assertion, err := c.key.signedJWT(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return request, nil
+}
+
+// signedJWT returns the JWT asserting the identity of the service account, signed with its private key.
+func (k *serviceAccountKey) signedJWT(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   k.ClientEmail,
+		"scope": computeReadOnlyScope,
+		"aud":   k.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, k.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing JWT: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (c *machineTypesClient) do(request *http.Request, into interface{}) error {
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, into)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func newTestServer(g Gomega, publicKey *rsa.PublicKey) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			g.Expect(r.ParseForm()).To(Succeed())
+			parts := strings.Split(r.PostForm.Get("assertion"), ".")
+			g.Expect(parts).To(HaveLen(3))
+			signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+			g.Expect(err).ToNot(HaveOccurred())
+			digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			g.Expect(rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature)).To(Succeed())
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "key-token", "expires_in": 3600})
+		case "/metadata/instance/service-accounts/default/token":
+			g.Expect(r.Header.Get("Metadata-Flavor")).To(Equal("Google"))
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "metadata-token", "expires_in": 3600})
+		case "/compute/projects/project/zones/us-central1-a/machineTypes/a2-highgpu-1g":
+			g.Expect(r.Header.Get("Authorization")).To(HavePrefix("Bearer "))
+			_, _ = w.Write([]byte(`{"name": "a2-highgpu-1g", "guestCpus": 12, "memoryMb": 87040, "accelerators": [{"guestAcceleratorType": "nvidia-tesla-a100", "guestAcceleratorCount": 1}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestGetMachineType(t *testing.T) {
+	g := NewWithT(t)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	g.Expect(err).ToNot(HaveOccurred())
+	server := newTestServer(g, &privateKey.PublicKey)
+	defer server.Close()
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	key, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "annotator@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    server.URL + "/token",
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for _, credentials := range []Credentials{
+		{ServiceAccountKey: key, ComputeEndpoint: server.URL + "/compute"},
+		{ComputeEndpoint: server.URL + "/compute", MetadataEndpoint: server.URL + "/metadata"},
+	} {
+		client, err := NewMachineTypesClient(credentials)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		machineType, err := client.GetMachineType(context.Background(), "project", "us-central1-a", "a2-highgpu-1g")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(machineType).To(Equal(MachineType{
+			Name:         "a2-highgpu-1g",
+			GuestCpus:    12,
+			MemoryMb:     87040,
+			Accelerators: []Accelerator{{GuestAcceleratorType: "nvidia-tesla-a100", GuestAcceleratorCount: 1}},
+		}))
+
+		_, err = client.GetMachineType(context.Background(), "project", "us-central1-a", "unknown")
+		g.Expect(err).To(MatchError(ContainSubstring("unexpected status 404")))
+	}
+}
+
+func TestNewMachineTypesClientInvalidKey(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewMachineTypesClient(Credentials{ServiceAccountKey: []byte(`{"type": "authorized_user"}`)})
+	g.Expect(err).To(MatchError("invalid service account key: not a service account key"))
+}
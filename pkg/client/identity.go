@@ -0,0 +1,97 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultRoleSessionName is the session name of assumed roles without one.
+const defaultRoleSessionName = "capa-annotator"
+
+// Identity is the identity AWS clients authenticate with: the credentials of the Secret, or the controller's
+// own credentials if SecretName is empty, followed by the roles assumed in order, each with the credentials
+// of the previous one.
+type Identity struct {
+	SecretName      string
+	SecretNamespace string
+	Roles           []AssumeRole
+}
+
+// AssumeRole is a role assumed with STS, e.g. of an AWSClusterRoleIdentity.
+type AssumeRole struct {
+	RoleARN     string
+	SessionName string
+	ExternalID  string
+	// Duration of the role session. Zero uses the default of the AWS SDK.
+	Duration     time.Duration
+	InlinePolicy string
+	PolicyARNs   []string
+}
+
+// String returns a key of the identity, distinct for identities with different credentials.
+func (i Identity) String() string {
+	parts := []string{}
+	if i.SecretName != "" {
+		parts = append(parts, i.SecretNamespace+"/"+i.SecretName)
+	}
+	for _, role := range i.Roles {
+		parts = append(parts, role.RoleARN)
+	}
+	return strings.Join(parts, ">")
+}
+
+// IdentityClientBuilderFuncType is function type for building aws clients authenticating with an identity
+type IdentityClientBuilderFuncType func(client client.Client, identity Identity, region string, regionCache RegionCache) (Client, error)
+
+// NewValidatedIdentityClient behaves like NewValidatedClient, except that the client authenticates with the
+// identity, assuming its roles in order.
+func NewValidatedIdentityClient(ctrlRuntimeClient client.Client, identity Identity, region string, regionCache RegionCache) (Client, error) {
+	s, err := newSession(ctrlRuntimeClient, identity.SecretName, identity.SecretNamespace, region)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range identity.Roles {
+		s = s.Copy(&aws.Config{Credentials: stscreds.NewCredentials(s, role.RoleARN, role.configure)})
+	}
+
+	return newValidatedClient(s, region, regionCache)
+}
+
+// configure sets the options of the role on the provider of its credentials.
+func (r AssumeRole) configure(provider *stscreds.AssumeRoleProvider) {
+	provider.RoleSessionName = r.SessionName
+	if provider.RoleSessionName == "" {
+		provider.RoleSessionName = defaultRoleSessionName
+	}
+	if r.ExternalID != "" {
+		provider.ExternalID = aws.String(r.ExternalID)
+	}
+	if r.Duration > 0 {
+		provider.Duration = r.Duration
+	}
+	if r.InlinePolicy != "" {
+		provider.Policy = aws.String(r.InlinePolicy)
+	}
+	for _, arn := range r.PolicyARNs {
+		provider.PolicyArns = append(provider.PolicyArns, &sts.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	. "github.com/onsi/gomega"
+)
+
+func TestIdentityString(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(Identity{}.String()).To(BeEmpty())
+	g.Expect(Identity{SecretName: "credentials", SecretNamespace: "capa-system"}.String()).To(Equal("capa-system/credentials"))
+	g.Expect(Identity{Roles: []AssumeRole{{RoleARN: "arn:aws:iam::111111111111:role/a"}, {RoleARN: "arn:aws:iam::222222222222:role/b"}}}.String()).
+		To(Equal("arn:aws:iam::111111111111:role/a>arn:aws:iam::222222222222:role/b"))
+}
+
+func TestAssumeRoleConfigure(t *testing.T) {
+	g := NewWithT(t)
+
+	provider := &stscreds.AssumeRoleProvider{}
+	AssumeRole{RoleARN: "arn:aws:iam::111111111111:role/a"}.configure(provider)
+	g.Expect(provider.RoleSessionName).To(Equal(defaultRoleSessionName))
+	g.Expect(provider.ExternalID).To(BeNil())
+	g.Expect(provider.Policy).To(BeNil())
+	g.Expect(provider.PolicyArns).To(BeEmpty())
+
+	provider = &stscreds.AssumeRoleProvider{}
+	AssumeRole{
+		RoleARN:      "arn:aws:iam::111111111111:role/a",
+		SessionName:  "annotator",
+		ExternalID:   "external",
+		Duration:     time.Hour,
+		InlinePolicy: `{"Version":"2012-10-17"}`,
+		PolicyARNs:   []string{"arn:aws:iam::aws:policy/AmazonEC2ReadOnlyAccess"},
+	}.configure(provider)
+	g.Expect(provider.RoleSessionName).To(Equal("annotator"))
+	g.Expect(*provider.ExternalID).To(Equal("external"))
+	g.Expect(provider.Duration).To(Equal(time.Hour))
+	g.Expect(*provider.Policy).To(Equal(`{"Version":"2012-10-17"}`))
+	g.Expect(provider.PolicyArns).To(HaveLen(1))
+	g.Expect(*provider.PolicyArns[0].Arn).To(Equal("arn:aws:iam::aws:policy/AmazonEC2ReadOnlyAccess"))
+}
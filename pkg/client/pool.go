@@ -0,0 +1,136 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// poolKey identifies the clients of a pool. The identity is empty for the controller's own
+// credentials (IRSA, EKS Pod Identity or the default credential chain).
+type poolKey struct {
+	identity string
+	region   string
+}
+
+// DefaultClientPoolTTL is the default duration after which pooled clients are constructed again.
+const DefaultClientPoolTTL = time.Hour
+
+// poolEntry holds a client of the pool. The mutex serializes the construction of the client,
+// so that concurrent reconciles of the same identity and region construct it only once.
+type poolEntry struct {
+	mutex       sync.Mutex
+	client      Client
+	constructed time.Time
+}
+
+// ClientPool lazily constructs one AWS client per identity and region and reuses it afterwards, so that
+// reconciles hitting a warm pool do not pay the latency of the session setup and the web identity exchange.
+// Clients are constructed again after the TTL, so that changes of the environment, e.g. of the regions of
+// the account, are picked up. Failed constructions are not cached and are retried on the next call.
+// Access is synchronized via mutex.
+type ClientPool struct {
+	builder         AwsClientBuilderFuncType
+	identityBuilder IdentityClientBuilderFuncType
+	entries         map[poolKey]*poolEntry
+	mutex           sync.Mutex
+	ttl             time.Duration
+	now             func() time.Time
+}
+
+// NewClientPool creates an empty pool constructing clients with the given builder, which are never constructed
+// again. Clients of identities assuming roles are constructed with NewValidatedIdentityClient.
+func NewClientPool(builder AwsClientBuilderFuncType) *ClientPool {
+	return NewClientPoolWithTTL(builder, 0)
+}
+
+// NewClientPoolWithTTL creates an empty pool whose clients are constructed again after the TTL. A TTL of
+// zero keeps them forever.
+func NewClientPoolWithTTL(builder AwsClientBuilderFuncType, ttl time.Duration) *ClientPool {
+	return &ClientPool{
+		builder:         builder,
+		identityBuilder: NewValidatedIdentityClient,
+		entries:         map[poolKey]*poolEntry{},
+		ttl:             ttl,
+		now:             time.Now,
+	}
+}
+
+// GetClient returns the pooled client of the identity and region, constructing it on first use.
+// It has the signature of AwsClientBuilderFuncType, so it can be used in place of the builder.
+// The namespace is only part of the identity if a secret is given, since it is unused otherwise.
+func (p *ClientPool) GetClient(ctrlRuntimeClient client.Client, secretName, namespace, region string, regionCache RegionCache) (Client, error) {
+	key := poolKey{region: region}
+	if secretName != "" {
+		key.identity = namespace + "/" + secretName
+	}
+
+	return p.get(key, region, func() (Client, error) {
+		return p.builder(ctrlRuntimeClient, secretName, namespace, region, regionCache)
+	})
+}
+
+// GetIdentityClient returns the pooled client of the identity and region, constructing it on first use.
+// It has the signature of IdentityClientBuilderFuncType, so it can be used in place of the builder.
+// Identities without roles are constructed with the builder of the pool.
+func (p *ClientPool) GetIdentityClient(ctrlRuntimeClient client.Client, identity Identity, region string, regionCache RegionCache) (Client, error) {
+	if len(identity.Roles) == 0 {
+		return p.GetClient(ctrlRuntimeClient, identity.SecretName, identity.SecretNamespace, region, regionCache)
+	}
+
+	key := poolKey{identity: identity.String(), region: region}
+	return p.get(key, region, func() (Client, error) {
+		return p.identityBuilder(ctrlRuntimeClient, identity, region, regionCache)
+	})
+}
+
+// get returns the pooled client of the key, constructing it with build on first use and after the TTL.
+// The expired client is kept if the construction fails, since its credentials are still refreshed.
+func (p *ClientPool) get(key poolKey, region string, build func() (Client, error)) (Client, error) {
+	p.mutex.Lock()
+	entry, ok := p.entries[key]
+	if !ok {
+		entry = &poolEntry{}
+		p.entries[key] = entry
+	}
+	p.mutex.Unlock()
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	if entry.client != nil && (p.ttl <= 0 || p.now().Sub(entry.constructed) < p.ttl) {
+		return entry.client, nil
+	}
+
+	start := time.Now()
+	awsClient, err := build()
+	metrics.AWSClientConstructionDuration.WithLabelValues(region).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.AWSClientConstructionFailures.WithLabelValues(region).Inc()
+		if entry.client != nil {
+			klog.Warningf("Failed to construct the AWS client of region %s again, reusing the previous one: %v", region, err)
+			return entry.client, nil
+		}
+		return nil, err
+	}
+
+	entry.client = awsClient
+	entry.constructed = p.now()
+	return awsClient, nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// pooledTestClient is a distinct client per construction.
+type pooledTestClient struct {
+	Client
+	region string
+}
+
+func TestClientPool(t *testing.T) {
+	g := NewWithT(t)
+
+	var constructions atomic.Int32
+	failRegion := "eu-south-2"
+	pool := NewClientPool(func(_ client.Client, _, _, region string, _ RegionCache) (Client, error) {
+		constructions.Add(1)
+		if region == failRegion {
+			return nil, errors.New("region not resolved")
+		}
+		return &pooledTestClient{region: region}, nil
+	})
+
+	// Concurrent reconciles of the same region construct the client only once
+	clients := make([]Client, 10)
+	wg := sync.WaitGroup{}
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			clients[i], err = pool.GetClient(nil, "", "default", "us-east-1", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+		}(i)
+	}
+	wg.Wait()
+	g.Expect(constructions.Load()).To(BeEquivalentTo(1))
+	for _, c := range clients {
+		g.Expect(c).To(BeIdenticalTo(clients[0]))
+	}
+
+	// Without a secret, the namespace is not part of the identity
+	other, err := pool.GetClient(nil, "", "other", "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(other).To(BeIdenticalTo(clients[0]))
+
+	// Other regions and identities get their own client
+	west, err := pool.GetClient(nil, "", "default", "us-west-2", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(west.(*pooledTestClient).region).To(Equal("us-west-2"))
+	withSecret, err := pool.GetClient(nil, "credentials", "default", "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(withSecret).ToNot(BeIdenticalTo(clients[0]))
+	g.Expect(constructions.Load()).To(BeEquivalentTo(3))
+
+	// Failures are counted and retried
+	failures := testutil.ToFloat64(metrics.AWSClientConstructionFailures.WithLabelValues(failRegion))
+	for i := 0; i < 2; i++ {
+		_, err = pool.GetClient(nil, "", "default", failRegion, nil)
+		g.Expect(err).To(HaveOccurred())
+	}
+	g.Expect(constructions.Load()).To(BeEquivalentTo(5))
+	g.Expect(testutil.ToFloat64(metrics.AWSClientConstructionFailures.WithLabelValues(failRegion))).To(Equal(failures + 2))
+}
+
+func TestClientPoolIdentities(t *testing.T) {
+	g := NewWithT(t)
+
+	var constructions, identityConstructions atomic.Int32
+	pool := NewClientPool(func(_ client.Client, _, _, region string, _ RegionCache) (Client, error) {
+		constructions.Add(1)
+		return &pooledTestClient{region: region}, nil
+	})
+	pool.identityBuilder = func(_ client.Client, _ Identity, region string, _ RegionCache) (Client, error) {
+		identityConstructions.Add(1)
+		return &pooledTestClient{region: region}, nil
+	}
+
+	// Identities without roles share the clients of their secret
+	withSecret, err := pool.GetClient(nil, "credentials", "capa-system", "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	identity := Identity{SecretName: "credentials", SecretNamespace: "capa-system"}
+	withIdentity, err := pool.GetIdentityClient(nil, identity, "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(withIdentity).To(BeIdenticalTo(withSecret))
+	g.Expect(identityConstructions.Load()).To(BeZero())
+
+	// Identities assuming roles get their own client per role chain
+	identity.Roles = []AssumeRole{{RoleARN: "arn:aws:iam::111111111111:role/capa"}}
+	withRole, err := pool.GetIdentityClient(nil, identity, "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(withRole).ToNot(BeIdenticalTo(withSecret))
+	again, err := pool.GetIdentityClient(nil, identity, "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(again).To(BeIdenticalTo(withRole))
+
+	identity.Roles = append(identity.Roles, AssumeRole{RoleARN: "arn:aws:iam::222222222222:role/capa"})
+	chained, err := pool.GetIdentityClient(nil, identity, "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(chained).ToNot(BeIdenticalTo(withRole))
+	g.Expect(constructions.Load()).To(BeEquivalentTo(1))
+	g.Expect(identityConstructions.Load()).To(BeEquivalentTo(2))
+}
+
+func TestClientPoolTTL(t *testing.T) {
+	g := NewWithT(t)
+
+	var constructions atomic.Int32
+	fail := false
+	pool := NewClientPoolWithTTL(func(_ client.Client, _, _, region string, _ RegionCache) (Client, error) {
+		constructions.Add(1)
+		if fail {
+			return nil, errors.New("throttled")
+		}
+		return &pooledTestClient{region: region}, nil
+	}, time.Hour)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	pool.now = func() time.Time { return now }
+
+	first, err := pool.GetClient(nil, "", "default", "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	now = now.Add(59 * time.Minute)
+	again, err := pool.GetClient(nil, "", "default", "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(again).To(BeIdenticalTo(first))
+
+	// Expired clients are constructed again
+	now = now.Add(time.Minute)
+	refreshed, err := pool.GetClient(nil, "", "default", "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(refreshed).ToNot(BeIdenticalTo(first))
+	g.Expect(constructions.Load()).To(BeEquivalentTo(2))
+
+	// The expired client is reused while the construction fails
+	now = now.Add(time.Hour)
+	fail = true
+	reused, err := pool.GetClient(nil, "", "default", "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reused).To(BeIdenticalTo(refreshed))
+	g.Expect(constructions.Load()).To(BeEquivalentTo(3))
+}
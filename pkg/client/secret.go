@@ -0,0 +1,89 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The keys of the credentials in the Secret of an AWSClusterStaticIdentity
+	accessKeyIDKey     = "AccessKeyID"
+	secretAccessKeyKey = "SecretAccessKey"
+	sessionTokenKey    = "SessionToken"
+
+	// secretCredentialsTTL is the duration after which the Secret is read again, so that
+	// rotated credentials are picked up by pooled clients
+	secretCredentialsTTL = 5 * time.Minute
+)
+
+// secretCredentialsProvider retrieves the credentials of an AWSClusterStaticIdentity from its Secret.
+type secretCredentialsProvider struct {
+	credentials.Expiry
+
+	client client.Client
+	key    client.ObjectKey
+}
+
+// Retrieve reads the credentials from the Secret.
+func (p *secretCredentialsProvider) Retrieve() (credentials.Value, error) {
+	secret := &corev1.Secret{}
+	if err := p.client.Get(context.Background(), p.key, secret); err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to fetch credentials Secret %s: %w", p.key, err)
+	}
+
+	value := credentials.Value{
+		AccessKeyID:     string(secret.Data[accessKeyIDKey]),
+		SecretAccessKey: string(secret.Data[secretAccessKeyKey]),
+		SessionToken:    string(secret.Data[sessionTokenKey]),
+		ProviderName:    "AWSClusterStaticIdentity",
+	}
+	if value.AccessKeyID == "" || value.SecretAccessKey == "" {
+		return credentials.Value{}, fmt.Errorf("credentials Secret %s has no %s or %s", p.key, accessKeyIDKey, secretAccessKeyKey)
+	}
+
+	p.SetExpiration(time.Now().Add(secretCredentialsTTL), 0)
+	return value, nil
+}
+
+// newSession creates an AWS session with the credentials of the Secret, or with the controller's
+// own credentials if no Secret is given.
+func newSession(ctrlRuntimeClient client.Client, secretName, namespace, region string) (*session.Session, error) {
+	if secretName == "" {
+		return newAWSSession(region)
+	}
+
+	key := client.ObjectKey{Namespace: namespace, Name: secretName}
+	klog.Infof("Using the credentials of Secret %s", key)
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String(region),
+		Credentials: credentials.NewCredentials(&secretCredentialsProvider{client: ctrlRuntimeClient, key: key}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Handlers.Build.PushBackNamed(addProviderVersionToUserAgent)
+	s.Handlers.Complete.PushBackNamed(recordAPICall)
+
+	return s, nil
+}
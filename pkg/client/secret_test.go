@@ -0,0 +1,87 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSecretCredentialsProvider(t *testing.T) {
+	testCases := []struct {
+		name          string
+		data          map[string][]byte
+		expectedToken string
+		expectErr     bool
+	}{
+		{
+			name: "access keys",
+			data: map[string][]byte{
+				accessKeyIDKey:     []byte("AKIAEXAMPLE"),
+				secretAccessKeyKey: []byte("secret"),
+			},
+		},
+		{
+			name: "session token",
+			data: map[string][]byte{
+				accessKeyIDKey:     []byte("AKIAEXAMPLE"),
+				secretAccessKeyKey: []byte("secret"),
+				sessionTokenKey:    []byte("token"),
+			},
+			expectedToken: "token",
+		},
+		{
+			name:      "missing secret access key",
+			data:      map[string][]byte{accessKeyIDKey: []byte("AKIAEXAMPLE")},
+			expectErr: true,
+		},
+		{
+			name:      "missing secret",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			key := client.ObjectKey{Namespace: "capa-system", Name: "static-identity"}
+			builder := fake.NewClientBuilder()
+			if tc.data != nil {
+				builder = builder.WithObjects(&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+					Data:       tc.data,
+				})
+			}
+			provider := &secretCredentialsProvider{client: builder.Build(), key: key}
+
+			value, err := provider.Retrieve()
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(value.AccessKeyID).To(Equal("AKIAEXAMPLE"))
+			g.Expect(value.SecretAccessKey).To(Equal("secret"))
+			g.Expect(value.SessionToken).To(Equal(tc.expectedToken))
+			// The Secret is read again once the credentials expire
+			g.Expect(provider.IsExpired()).To(BeFalse())
+		})
+	}
+}
@@ -0,0 +1,262 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/outposts"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// CheckStatus is the outcome of a check of the AWS configuration.
+type CheckStatus string
+
+const (
+	// CheckPassed means the check succeeded.
+	CheckPassed CheckStatus = "ok"
+	// CheckWarning means the check could not be completed, or found a configuration that works but is not
+	// recommended.
+	CheckWarning CheckStatus = "warning"
+	// CheckFailed means the controller cannot work with the configuration.
+	CheckFailed CheckStatus = "failed"
+)
+
+// Check is the result of a check of the AWS configuration.
+type Check struct {
+	Name    string      `json:"name"`
+	Status  CheckStatus `json:"status"`
+	Message string      `json:"message"`
+	// Hint describes how to fix a warning or failure.
+	Hint string `json:"hint,omitempty"`
+}
+
+// policyHint points to the IAM policy of the controller.
+const policyHint = "attach the policy of deploy/iam/policy.json to the role of the controller"
+
+// ec2Permissions are the EC2 actions of the IAM policy of the controller, checked with dry runs.
+var ec2Permissions = []struct {
+	action string
+	call   func(*ec2.EC2) error
+}{
+	{"ec2:DescribeAvailabilityZones", func(c *ec2.EC2) error {
+		_, err := c.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{DryRun: aws.Bool(true)})
+		return err
+	}},
+	{"ec2:DescribeImages", func(c *ec2.EC2) error {
+		_, err := c.DescribeImages(&ec2.DescribeImagesInput{DryRun: aws.Bool(true), Owners: []*string{aws.String("self")}})
+		return err
+	}},
+	{"ec2:DescribeInstances", func(c *ec2.EC2) error {
+		_, err := c.DescribeInstances(&ec2.DescribeInstancesInput{DryRun: aws.Bool(true)})
+		return err
+	}},
+	{"ec2:DescribeInstanceTypes", func(c *ec2.EC2) error {
+		_, err := c.DescribeInstanceTypes(&ec2.DescribeInstanceTypesInput{DryRun: aws.Bool(true)})
+		return err
+	}},
+	{"ec2:DescribeRegions", func(c *ec2.EC2) error {
+		_, err := c.DescribeRegions(&ec2.DescribeRegionsInput{DryRun: aws.Bool(true)})
+		return err
+	}},
+	{"ec2:DescribeSubnets", func(c *ec2.EC2) error {
+		_, err := c.DescribeSubnets(&ec2.DescribeSubnetsInput{DryRun: aws.Bool(true)})
+		return err
+	}},
+}
+
+// ValidateCredentials checks the controller's own AWS credentials: the configuration of IRSA or EKS Pod Identity
+// in the environment, the identity the credentials resolve to, the region and the permissions of the IAM policy of
+// the controller in the region. Checks depending on a failed one are skipped.
+func ValidateCredentials(region string) []Check {
+	checks := checkCredentialEnvironment()
+	for _, check := range checks {
+		if check.Status == CheckFailed {
+			return checks
+		}
+	}
+
+	s, err := newAWSSession(region)
+	if err != nil {
+		return append(checks, Check{
+			Name:    "session",
+			Status:  CheckFailed,
+			Message: fmt.Sprintf("failed to create AWS session: %v", err),
+			Hint:    "check the AWS_* environment variables and the shared config file of the controller",
+		})
+	}
+	return append(checks, validateSession(s, region, NewRegionCache())...)
+}
+
+// checkCredentialEnvironment checks the environment variables of IRSA and EKS Pod Identity and their token files.
+func checkCredentialEnvironment() []Check {
+	source := detectCredentialSource()
+	check := Check{Name: "credential source", Status: CheckPassed, Message: string(source)}
+	switch source {
+	case credentialSourceEnvironment:
+		check.Status = CheckWarning
+		check.Message = "static access keys of AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY"
+		check.Hint = "prefer IRSA or EKS Pod Identity, whose credentials are rotated automatically"
+	case credentialSourceIRSA:
+		roleARN := os.Getenv("AWS_ROLE_ARN")
+		check.Message = fmt.Sprintf("IRSA with role %s", roleARN)
+		if _, err := arn.Parse(roleARN); err != nil {
+			check.Status = CheckFailed
+			check.Message = fmt.Sprintf("AWS_ROLE_ARN %q is not an ARN", roleARN)
+			check.Hint = "set the eks.amazonaws.com/role-arn annotation of the ServiceAccount to the ARN of the role"
+			return []Check{check}
+		}
+		return []Check{check, checkTokenFile("AWS_WEB_IDENTITY_TOKEN_FILE",
+			"the token is projected by the EKS Pod Identity webhook, check that the pod was created after the ServiceAccount was annotated")}
+	case credentialSourcePodIdentity:
+		check.Message = fmt.Sprintf("EKS Pod Identity with endpoint %s", os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"))
+		return []Check{check, checkTokenFile("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+			"the token is projected by EKS for pods of ServiceAccounts with a Pod Identity association, check that the pod was created after the association")}
+	default:
+		switch {
+		case os.Getenv("AWS_ROLE_ARN") != "" || os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "":
+			check.Status = CheckFailed
+			check.Message = "IRSA is configured partially, AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE must both be set"
+			check.Hint = "check the eks.amazonaws.com/role-arn annotation of the ServiceAccount and that the pod was created after it was set"
+		case os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "":
+			check.Status = CheckFailed
+			check.Message = "EKS Pod Identity is configured partially, AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE is not set"
+			check.Hint = "check that the pod was created after the Pod Identity association of its ServiceAccount"
+		default:
+			check.Status = CheckWarning
+			check.Message = "IRSA and EKS Pod Identity are not configured, the default credential chain is used"
+			check.Hint = "annotate the ServiceAccount of the controller with eks.amazonaws.com/role-arn, or create an EKS Pod Identity association for it"
+		}
+	}
+	return []Check{check}
+}
+
+// checkTokenFile checks that the token file named by the environment variable is readable and not empty.
+func checkTokenFile(env, hint string) Check {
+	path := os.Getenv(env)
+	check := Check{Name: "token file", Status: CheckPassed, Message: fmt.Sprintf("%s is readable", path)}
+	data, err := os.ReadFile(path)
+	switch {
+	case err != nil:
+		check.Status = CheckFailed
+		check.Message = fmt.Sprintf("%s of %s is not readable: %v", path, env, err)
+		check.Hint = hint
+	case len(strings.TrimSpace(string(data))) == 0:
+		check.Status = CheckFailed
+		check.Message = fmt.Sprintf("%s of %s is empty", path, env)
+		check.Hint = hint
+	}
+	return check
+}
+
+// validateSession checks the identity of the credentials of the session, the region and the permissions of the
+// IAM policy of the controller.
+func validateSession(s *session.Session, region string, regionCache RegionCache) []Check {
+	if _, err := s.Config.Credentials.Get(); err != nil {
+		return []Check{{
+			Name:    "credentials",
+			Status:  CheckFailed,
+			Message: fmt.Sprintf("no credentials could be resolved: %v", err),
+			Hint:    "configure IRSA or EKS Pod Identity for the ServiceAccount of the controller",
+		}}
+	}
+
+	identity, err := sts.New(s).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return []Check{{
+			Name:    "identity",
+			Status:  CheckFailed,
+			Message: fmt.Sprintf("STS rejected the credentials: %v", err),
+			Hint:    "with IRSA, check that the trust policy of the role allows sts:AssumeRoleWithWebIdentity for the ServiceAccount of the controller and the OIDC provider of the cluster",
+		}}
+	}
+	checks := []Check{{
+		Name:    "identity",
+		Status:  CheckPassed,
+		Message: fmt.Sprintf("%s of account %s", aws.StringValue(identity.Arn), aws.StringValue(identity.Account)),
+	}}
+
+	if region == "" {
+		return append(checks, Check{
+			Name:    "region",
+			Status:  CheckFailed,
+			Message: "no region to check",
+			Hint:    "pass --region, or set AWS_REGION",
+		})
+	}
+	if _, err := newValidatedClient(s, region, regionCache); err != nil {
+		return append(checks, Check{
+			Name:    "region",
+			Status:  CheckFailed,
+			Message: err.Error(),
+			Hint:    "check the region of the AWSClusters and that it is enabled in the account",
+		})
+	}
+	checks = append(checks, Check{Name: "region", Status: CheckPassed, Message: fmt.Sprintf("%s is resolvable", region)})
+
+	ec2Client := ec2.New(s)
+	for _, permission := range ec2Permissions {
+		checks = append(checks, permissionCheck(permission.action, permission.call(ec2Client), "DryRunOperation"))
+	}
+	// The Outposts API has no dry run, an Outpost that does not exist is only found with the permission
+	_, err = outposts.New(s).GetOutpostInstanceTypes(&outposts.GetOutpostInstanceTypesInput{OutpostId: aws.String("op-00000000000000000")})
+	checks = append(checks, permissionCheck("outposts:GetOutpostInstanceTypes", err, outposts.ErrCodeNotFoundException, outposts.ErrCodeValidationException))
+	// Neither has the Service Quotas API, the quota of the standard instances is read instead
+	_, err = servicequotas.New(s).GetServiceQuota(&servicequotas.GetServiceQuotaInput{
+		ServiceCode: aws.String("ec2"),
+		QuotaCode:   aws.String("L-1216C47A"),
+	})
+	checks = append(checks, permissionCheck("servicequotas:GetServiceQuota", err))
+	return checks
+}
+
+// permissionCheck returns the check of the permission of the action from the error of its call, which is
+// permitted if it failed with one of the codes.
+func permissionCheck(action string, err error, permittedCodes ...string) Check {
+	check := Check{Name: action, Status: CheckPassed, Message: "permitted"}
+	if err == nil {
+		return check
+	}
+
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		check.Status = CheckWarning
+		check.Message = fmt.Sprintf("could not be verified: %v", err)
+		return check
+	}
+	for _, code := range permittedCodes {
+		if awsErr.Code() == code {
+			return check
+		}
+	}
+	switch awsErr.Code() {
+	case "UnauthorizedOperation", "AccessDenied", outposts.ErrCodeAccessDeniedException:
+		check.Status = CheckFailed
+		check.Message = "not permitted"
+		check.Hint = policyHint
+	default:
+		check.Status = CheckWarning
+		check.Message = fmt.Sprintf("could not be verified: %v", awsErr.Code())
+	}
+	return check
+}
@@ -0,0 +1,183 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	. "github.com/onsi/gomega"
+)
+
+func TestCheckCredentialEnvironment(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	emptyTokenFile := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(emptyTokenFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		env      map[string]string
+		expected []CheckStatus
+	}{
+		{
+			name:     "nothing configured",
+			expected: []CheckStatus{CheckWarning},
+		},
+		{
+			name: "IRSA",
+			env: map[string]string{
+				"AWS_ROLE_ARN":                "arn:aws:iam::123456789012:role/my-role",
+				"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile,
+			},
+			expected: []CheckStatus{CheckPassed, CheckPassed},
+		},
+		{
+			name: "IRSA with missing token file",
+			env: map[string]string{
+				"AWS_ROLE_ARN":                "arn:aws:iam::123456789012:role/my-role",
+				"AWS_WEB_IDENTITY_TOKEN_FILE": filepath.Join(t.TempDir(), "missing"),
+			},
+			expected: []CheckStatus{CheckPassed, CheckFailed},
+		},
+		{
+			name: "IRSA with invalid role",
+			env: map[string]string{
+				"AWS_ROLE_ARN":                "my-role",
+				"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile,
+			},
+			expected: []CheckStatus{CheckFailed},
+		},
+		{
+			name: "IRSA without token file",
+			env: map[string]string{
+				"AWS_ROLE_ARN": "arn:aws:iam::123456789012:role/my-role",
+			},
+			expected: []CheckStatus{CheckFailed},
+		},
+		{
+			name: "EKS Pod Identity with empty token file",
+			env: map[string]string{
+				"AWS_CONTAINER_CREDENTIALS_FULL_URI":     "http://169.254.170.23/v1/credentials",
+				"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE": emptyTokenFile,
+			},
+			expected: []CheckStatus{CheckPassed, CheckFailed},
+		},
+		{
+			name: "EKS Pod Identity without token file",
+			env: map[string]string{
+				"AWS_CONTAINER_CREDENTIALS_FULL_URI": "http://169.254.170.23/v1/credentials",
+			},
+			expected: []CheckStatus{CheckFailed},
+		},
+		{
+			name: "access keys",
+			env: map[string]string{
+				"AWS_ACCESS_KEY_ID":     "AKIAEXAMPLE",
+				"AWS_SECRET_ACCESS_KEY": "secret",
+			},
+			expected: []CheckStatus{CheckWarning},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE",
+				"AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"} {
+				t.Setenv(name, tc.env[name])
+			}
+
+			statuses := []CheckStatus{}
+			for _, check := range checkCredentialEnvironment() {
+				statuses = append(statuses, check.Status)
+				if check.Status != CheckPassed {
+					g.Expect(check.Hint).ToNot(BeEmpty())
+				}
+			}
+			g.Expect(statuses).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestValidateSession(t *testing.T) {
+	g := NewWithT(t)
+
+	// A single endpoint serves STS, EC2, the Outposts and the Service Quotas API. Only DescribeImages is not permitted.
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/outposts/") {
+			w.Header().Set("X-Amzn-Errortype", "NotFoundException")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"outpost not found"}`))
+			return
+		}
+		if r.Header.Get("X-Amz-Target") == "ServiceQuotasV20190624.GetServiceQuota" {
+			_, _ = w.Write([]byte(`{"Quota":{"ServiceCode":"ec2","QuotaCode":"L-1216C47A","Value":32}}`))
+			return
+		}
+		_ = r.ParseForm()
+		switch action := r.Form.Get("Action"); {
+		case action == "GetCallerIdentity":
+			_, _ = w.Write([]byte(`<GetCallerIdentityResponse><GetCallerIdentityResult><Arn>arn:aws:sts::123456789012:assumed-role/capa-annotator/session</Arn><Account>123456789012</Account></GetCallerIdentityResult></GetCallerIdentityResponse>`))
+		case action == "DescribeImages":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`<Response><Errors><Error><Code>UnauthorizedOperation</Code><Message>denied</Message></Error></Errors><RequestID>1</RequestID></Response>`))
+		default:
+			g.Expect(r.Form.Get("DryRun")).To(Equal("true"), action)
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_, _ = w.Write([]byte(`<Response><Errors><Error><Code>DryRunOperation</Code><Message>would have succeeded</Message></Error></Errors><RequestID>1</RequestID></Response>`))
+		}
+	}))
+	defer api.Close()
+
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(api.URL),
+		Credentials: credentials.NewStaticCredentials("AKIA", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	checks := map[string]Check{}
+	for _, check := range validateSession(s, "us-east-1", NewRegionCache()) {
+		checks[check.Name] = check
+	}
+	g.Expect(checks).To(HaveLen(10))
+	g.Expect(checks["identity"].Message).To(Equal("arn:aws:sts::123456789012:assumed-role/capa-annotator/session of account 123456789012"))
+	g.Expect(checks["region"].Status).To(Equal(CheckPassed))
+	g.Expect(checks["ec2:DescribeInstanceTypes"].Status).To(Equal(CheckPassed))
+	g.Expect(checks["ec2:DescribeImages"].Status).To(Equal(CheckFailed))
+	g.Expect(checks["ec2:DescribeImages"].Hint).To(Equal(policyHint))
+	g.Expect(checks["outposts:GetOutpostInstanceTypes"].Status).To(Equal(CheckPassed))
+	g.Expect(checks["servicequotas:GetServiceQuota"].Status).To(Equal(CheckPassed))
+
+	checks = map[string]Check{}
+	for _, check := range validateSession(s, "", NewRegionCache()) {
+		checks[check.Name] = check
+	}
+	g.Expect(checks).To(HaveLen(2))
+	g.Expect(checks["region"].Status).To(Equal(CheckFailed))
+}
@@ -0,0 +1,86 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// acceleratorLabelKey is the label the cluster-autoscaler Cluster API provider uses to identify nodes with GPUs.
+const acceleratorLabelKey = "cluster-api/accelerator"
+
+// invalidLabelValueChars matches the characters not allowed in label values.
+var invalidLabelValueChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// ValidateAcceleratorName returns an error if the accelerator name is not a valid label value.
+func ValidateAcceleratorName(name string) error {
+	if errs := validation.IsValidLabelValue(name); len(errs) > 0 {
+		return fmt.Errorf("accelerator name %q is not a valid label value: %s", name, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// getGpuName returns the name of the GPUs, e.g. T4, if the instance type has GPUs.
+func getGpuName(gpuInfo *ec2.GpuInfo) string {
+	for _, gpu := range gpuInfo.Gpus {
+		if gpu.Name != nil && *gpu.Name != "" {
+			return *gpu.Name
+		}
+	}
+	return ""
+}
+
+// acceleratorName returns the value of the accelerator label for the instance type, or an empty string if it has
+// no GPUs. The configured AcceleratorName is used for all instance types with GPUs, otherwise the name is derived
+// from the GPU manufacturer and name, e.g. nvidia-t4. An empty string is also returned if neither is known, e.g.
+// for instance type aliases with explicit capacity.
+func (r *Reconciler) acceleratorName(instanceTypeInfo InstanceType) string {
+	if instanceTypeInfo.GPU <= 0 {
+		return ""
+	}
+	if r.AcceleratorName != "" {
+		return r.AcceleratorName
+	}
+
+	parts := []string{}
+	for _, part := range []string{instanceTypeInfo.GPUManufacturer, instanceTypeInfo.GPUName} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	name := invalidLabelValueChars.ReplaceAllString(strings.ToLower(strings.Join(parts, "-")), "-")
+	if len(name) > validation.LabelValueMaxLength {
+		name = name[:validation.LabelValueMaxLength]
+	}
+	name = strings.Trim(name, "-_.")
+	if ValidateAcceleratorName(name) != nil {
+		return ""
+	}
+	return name
+}
+
+// setAcceleratorLabel sets the accelerator label of the instance type on the given labels, or removes a stale
+// label, e.g. after switching to an instance type without GPUs.
+func (r *Reconciler) setAcceleratorLabel(labelsMap map[string]string, instanceTypeInfo InstanceType) {
+	if name := r.acceleratorName(instanceTypeInfo); name != "" {
+		labelsMap[acceleratorLabelKey] = name
+		return
+	}
+	delete(labelsMap, acceleratorLabelKey)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestAcceleratorName(t *testing.T) {
+	testCases := []struct {
+		name            string
+		acceleratorName string
+		instanceType    InstanceType
+		expected        string
+	}{
+		{
+			name:         "derived from the GPU manufacturer and name",
+			instanceType: InstanceType{GPU: 1, GPUManufacturer: "NVIDIA", GPUName: "T4"},
+			expected:     "nvidia-t4",
+		},
+		{
+			name:         "invalid characters are replaced",
+			instanceType: InstanceType{GPU: 1, GPUManufacturer: "AMD", GPUName: "Radeon Pro V520"},
+			expected:     "amd-radeon-pro-v520",
+		},
+		{
+			name:            "configured name",
+			acceleratorName: "gpu",
+			instanceType:    InstanceType{GPU: 1, GPUManufacturer: "NVIDIA", GPUName: "T4"},
+			expected:        "gpu",
+		},
+		{
+			name:            "no GPUs",
+			acceleratorName: "gpu",
+			instanceType:    InstanceType{},
+		},
+		{
+			name:         "unknown GPUs",
+			instanceType: InstanceType{GPU: 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			r := &Reconciler{AcceleratorLabel: true, AcceleratorName: tc.acceleratorName}
+			g.Expect(r.acceleratorName(tc.instanceType)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestValidateAcceleratorName(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(ValidateAcceleratorName("nvidia-tesla-t4")).To(Succeed())
+	g.Expect(ValidateAcceleratorName("NVIDIA T4")).ToNot(Succeed())
+}
+
+func TestReconcileWithAcceleratorLabel(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "p2.16xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.AcceleratorLabel = true
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(labelsKey, "cluster-api/accelerator=nvidia-k80,kubernetes.io/arch=amd64"))
+
+	// The label is removed after switching to an instance type without GPUs
+	awsMachineTemplate.Spec.Template.Spec.InstanceType = "a1.2xlarge"
+	g.Expect(r.Client.Update(ctx, awsMachineTemplate)).To(Succeed())
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(labelsKey, "kubernetes.io/arch=amd64"))
+}
@@ -0,0 +1,121 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// DataSourceAlias means the information was taken from the explicit capacity of an instance type alias.
+const DataSourceAlias DataSource = "alias"
+
+// InstanceTypeAlias maps an instance type name unknown to the EC2 API, e.g. of AWS Outposts or private offers,
+// either to a canonical instance type or to an explicit capacity.
+type InstanceTypeAlias struct {
+	// InstanceType is the canonical instance type looked up in place of the alias.
+	InstanceType string `json:"instanceType,omitempty"`
+
+	// VCPU, MemoryMb, GPU and Architecture are the explicit capacity of the alias, if InstanceType is not set.
+	VCPU         int64  `json:"vcpu,omitempty"`
+	MemoryMb     int64  `json:"memoryMb,omitempty"`
+	GPU          int64  `json:"gpu,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+}
+
+// LoadInstanceTypeAliases reads the instance type aliases from a YAML file mapping each alias to an InstanceTypeAlias.
+func LoadInstanceTypeAliases(path string) (map[string]InstanceTypeAlias, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance type aliases: %w", err)
+	}
+	return ParseInstanceTypeAliases(data)
+}
+
+// ParseInstanceTypeAliases parses and validates YAML mapping each alias to an InstanceTypeAlias.
+func ParseInstanceTypeAliases(data []byte) (map[string]InstanceTypeAlias, error) {
+	aliases := map[string]InstanceTypeAlias{}
+	if err := yaml.UnmarshalStrict(data, &aliases); err != nil {
+		return nil, fmt.Errorf("failed to parse instance type aliases: %w", err)
+	}
+
+	for name, alias := range aliases {
+		hasCapacity := alias.VCPU != 0 || alias.MemoryMb != 0 || alias.GPU != 0 || alias.Architecture != ""
+		switch {
+		case alias.InstanceType != "" && hasCapacity:
+			return nil, fmt.Errorf("instance type alias %q must set either instanceType or an explicit capacity, not both", name)
+		case alias.InstanceType != "":
+			if _, ok := aliases[alias.InstanceType]; ok {
+				return nil, fmt.Errorf("instance type alias %q refers to alias %q, aliases cannot be chained", name, alias.InstanceType)
+			}
+		case alias.VCPU <= 0 || alias.MemoryMb <= 0 || alias.GPU < 0:
+			return nil, fmt.Errorf("instance type alias %q must set instanceType or a positive vcpu and memoryMb", name)
+		case alias.Architecture != "" && alias.Architecture != string(ArchitectureAmd64) && alias.Architecture != string(ArchitectureArm64):
+			return nil, fmt.Errorf("instance type alias %q has unknown architecture %q, must be one of %q", name, alias.Architecture,
+				[]normalizedArch{ArchitectureAmd64, ArchitectureArm64})
+		}
+	}
+	return aliases, nil
+}
+
+// aliasedInstanceTypesCache resolves instance type aliases before looking up instance types in the wrapped cache.
+type aliasedInstanceTypesCache struct {
+	cache    InstanceTypesCache
+	aliases  map[string]InstanceTypeAlias
+	loadedAt time.Time
+}
+
+// NewAliasedInstanceTypesCache wraps the cache so that the given aliases are consulted before the EC2 API.
+func NewAliasedInstanceTypesCache(cache InstanceTypesCache, aliases map[string]InstanceTypeAlias) InstanceTypesCache {
+	return &aliasedInstanceTypesCache{
+		cache:    cache,
+		aliases:  aliases,
+		loadedAt: time.Now(),
+	}
+}
+
+// GetInstanceType returns the explicit capacity of an alias, or looks up the canonical instance type of an alias.
+// Instance types without an alias are looked up as is.
+func (a *aliasedInstanceTypesCache) GetInstanceType(awsClient awsclient.Client, cacheID string, instanceType string) (InstanceType, error) {
+	alias, ok := a.aliases[instanceType]
+	if !ok {
+		return a.cache.GetInstanceType(awsClient, cacheID, instanceType)
+	}
+
+	if alias.InstanceType != "" {
+		instanceTypeInfo, err := a.cache.GetInstanceType(awsClient, cacheID, alias.InstanceType)
+		if err != nil {
+			return InstanceType{}, fmt.Errorf("error looking up instance type %q of alias %q: %w", alias.InstanceType, instanceType, err)
+		}
+		return instanceTypeInfo, nil
+	}
+
+	architecture := ArchitectureAmd64
+	if alias.Architecture != "" {
+		architecture = normalizedArch(alias.Architecture)
+	}
+	return InstanceType{
+		InstanceType:    instanceType,
+		VCPU:            alias.VCPU,
+		MemoryMb:        alias.MemoryMb,
+		GPU:             alias.GPU,
+		CPUArchitecture: architecture,
+		Source:          DataSourceAlias,
+		FetchedAt:       a.loadedAt,
+	}, nil
+}
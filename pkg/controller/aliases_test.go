@@ -0,0 +1,183 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+)
+
+func TestParseInstanceTypeAliases(t *testing.T) {
+	testCases := []struct {
+		name      string
+		data      string
+		expected  map[string]InstanceTypeAlias
+		expectErr bool
+	}{
+		{
+			name:     "empty",
+			data:     "",
+			expected: map[string]InstanceTypeAlias{},
+		},
+		{
+			name: "canonical instance type and explicit capacity",
+			data: `
+outpost.a1.2xlarge:
+  instanceType: a1.2xlarge
+private.gpu:
+  vcpu: 8
+  memoryMb: 32768
+  gpu: 1
+  architecture: arm64
+`,
+			expected: map[string]InstanceTypeAlias{
+				"outpost.a1.2xlarge": {InstanceType: "a1.2xlarge"},
+				"private.gpu":        {VCPU: 8, MemoryMb: 32768, GPU: 1, Architecture: "arm64"},
+			},
+		},
+		{
+			name:      "unknown field",
+			data:      "private.gpu: {vcpus: 8}",
+			expectErr: true,
+		},
+		{
+			name:      "instance type and capacity",
+			data:      "private.gpu: {instanceType: a1.2xlarge, vcpu: 8, memoryMb: 32768}",
+			expectErr: true,
+		},
+		{
+			name:      "missing memory",
+			data:      "private.gpu: {vcpu: 8}",
+			expectErr: true,
+		},
+		{
+			name:      "unknown architecture",
+			data:      "private.gpu: {vcpu: 8, memoryMb: 32768, architecture: x86_64}",
+			expectErr: true,
+		},
+		{
+			name: "chained alias",
+			data: `
+outpost.a1.2xlarge: {instanceType: private.a1.2xlarge}
+private.a1.2xlarge: {instanceType: a1.2xlarge}
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			aliases, err := ParseInstanceTypeAliases([]byte(tc.data))
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(aliases).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestAliasedInstanceTypesCache(t *testing.T) {
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewAliasedInstanceTypesCache(NewInstanceTypesCache(), map[string]InstanceTypeAlias{
+		"outpost.a1.2xlarge": {InstanceType: "a1.2xlarge"},
+		"outpost.unknown":    {InstanceType: "unknown"},
+		"private.gpu":        {VCPU: 8, MemoryMb: 32768, GPU: 1},
+	})
+
+	testCases := []struct {
+		name         string
+		instanceType string
+		expectedVCPU int64
+		expectedGPU  int64
+		expectedArch normalizedArch
+		expectSource DataSource
+		expectErr    bool
+	}{
+		{
+			name:         "without alias",
+			instanceType: "a1.2xlarge",
+			expectedVCPU: 8,
+			expectedArch: ArchitectureAmd64,
+			expectSource: DataSourceAPI,
+		},
+		{
+			name:         "alias of canonical instance type",
+			instanceType: "outpost.a1.2xlarge",
+			expectedVCPU: 8,
+			expectedArch: ArchitectureAmd64,
+			expectSource: DataSourceCache,
+		},
+		{
+			name:         "alias with explicit capacity",
+			instanceType: "private.gpu",
+			expectedVCPU: 8,
+			expectedGPU:  1,
+			expectedArch: ArchitectureAmd64,
+			expectSource: DataSourceAlias,
+		},
+		{
+			name:         "alias of unknown instance type",
+			instanceType: "outpost.unknown",
+			expectErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			instanceTypeInfo, err := cache.GetInstanceType(fakeAWSClient, "us-east-1", tc.instanceType)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(instanceTypeInfo.VCPU).To(Equal(tc.expectedVCPU))
+			g.Expect(instanceTypeInfo.GPU).To(Equal(tc.expectedGPU))
+			g.Expect(instanceTypeInfo.CPUArchitecture).To(Equal(tc.expectedArch))
+			g.Expect(instanceTypeInfo.Source).To(Equal(tc.expectSource))
+		})
+	}
+}
+
+func TestReconcileWithInstanceTypeAlias(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "private.gpu", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "private"
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.InstanceTypesCache = NewAliasedInstanceTypesCache(r.InstanceTypesCache, map[string]InstanceTypeAlias{
+		"private.gpu": {VCPU: 8, MemoryMb: 32768, GPU: 1, Architecture: "arm64"},
+	})
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(memoryKey, "32768"))
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(gpuKey, "1"))
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(labelsKey, "kubernetes.io/arch=arm64"))
+	g.Expect(machineDeployment.Annotations[provenanceKey]).To(HavePrefix("source=alias,"))
+}
@@ -0,0 +1,77 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+)
+
+// imageArchitecture returns the kubernetes.io/arch name of the architecture of an AMI, or an empty string
+// for architectures without an instance type counterpart, e.g. i386.
+func imageArchitecture(architecture string) normalizedArch {
+	switch architecture {
+	case ec2.ArchitectureValuesX8664, ec2.ArchitectureValuesX8664Mac:
+		return ArchitectureAmd64
+	case ec2.ArchitectureValuesArm64, ec2.ArchitectureValuesArm64Mac:
+		return ArchitectureArm64
+	}
+	return ""
+}
+
+// amiArchitectures caches the architectures of AMIs per region. AMIs are immutable, so the entries do not expire.
+// Access is synchronized via mutex.
+type amiArchitectures struct {
+	architectures map[string]normalizedArch
+	mutex         sync.Mutex
+}
+
+func newAMIArchitectures() *amiArchitectures {
+	return &amiArchitectures{architectures: map[string]normalizedArch{}}
+}
+
+// get returns the architecture of the AMI of the AWSMachineTemplate. An empty architecture is returned if the
+// template does not reference an AMI by ID, e.g. when CAPA looks up the AMI, or if the architecture is unknown.
+func (a *amiArchitectures) get(awsClient awsclient.Client, region string, awsMachineTemplate *infrav1.AWSMachineTemplate) (string, normalizedArch, error) {
+	amiID := aws.StringValue(awsMachineTemplate.Spec.Template.Spec.AMI.ID)
+	if amiID == "" {
+		return "", "", nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	key := region + "/" + amiID
+	if architecture, ok := a.architectures[key]; ok {
+		return amiID, architecture, nil
+	}
+
+	output, err := awsClient.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{aws.String(amiID)}})
+	if err != nil {
+		return amiID, "", fmt.Errorf("describeImages request failed: %w", err)
+	}
+	for _, image := range output.Images {
+		if aws.StringValue(image.ImageId) == amiID {
+			architecture := imageArchitecture(aws.StringValue(image.Architecture))
+			a.architectures[key] = architecture
+			return amiID, architecture, nil
+		}
+	}
+	return amiID, "", fmt.Errorf("AMI %s not found", amiID)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestReconcileWithAMIArchitecture(t *testing.T) {
+	testCases := []struct {
+		name          string
+		amiID         string
+		expectedEvent string
+	}{
+		{
+			name:  "matching architecture",
+			amiID: "ami-0123456789abcdef0",
+		},
+		{
+			name:          "arm64 AMI on an amd64 instance type",
+			amiID:         "ami-arm64-0123456789",
+			expectedEvent: corev1.EventTypeWarning + " AMIArchitectureMismatch AMI ami-arm64-0123456789 of AWSMachineTemplate test-aws-template is an arm64 image, but instance type a1.2xlarge is amd64, instances will fail to launch",
+		},
+		{
+			name: "AMI looked up by CAPA",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			if tc.amiID != "" {
+				awsMachineTemplate.Spec.Template.Spec.AMI.ID = aws.String(tc.amiID)
+			}
+
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+			r.amiArchitectures = newAMIArchitectures()
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			g.Expect(err).ToNot(HaveOccurred())
+			// The annotations are set regardless of the mismatch
+			g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+			if tc.expectedEvent == "" {
+				g.Expect(recorder.Events).To(BeEmpty())
+				return
+			}
+			g.Expect(recorder.Events).To(Receive(Equal(tc.expectedEvent)))
+			g.Expect(r.amiArchitectures.architectures).To(HaveKeyWithValue("us-east-1/"+tc.amiID, ArchitectureArm64))
+		})
+	}
+}
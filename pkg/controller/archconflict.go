@@ -0,0 +1,52 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// ArchConflictPolicy is the handling of a kubernetes.io/arch node label of a MachineDeployment conflicting with the
+// architecture of its instance type.
+type ArchConflictPolicy string
+
+const (
+	// ArchConflictPolicyAWS writes the architecture of the instance type. This is the default.
+	ArchConflictPolicyAWS ArchConflictPolicy = "aws"
+	// ArchConflictPolicyUser writes the architecture of the node label of the MachineDeployment.
+	ArchConflictPolicyUser ArchConflictPolicy = "user"
+	// ArchConflictPolicyError does not set the annotations and emits an ArchitectureConflict warning event.
+	ArchConflictPolicyError ArchConflictPolicy = "error"
+)
+
+// ParseArchConflictPolicy validates the given architecture conflict policy. An empty policy defaults to ArchConflictPolicyAWS.
+func ParseArchConflictPolicy(policy string) (ArchConflictPolicy, error) {
+	switch ArchConflictPolicy(policy) {
+	case "":
+		return ArchConflictPolicyAWS, nil
+	case ArchConflictPolicyAWS, ArchConflictPolicyUser, ArchConflictPolicyError:
+		return ArchConflictPolicy(policy), nil
+	}
+	return "", fmt.Errorf("unknown architecture conflict policy %q, must be one of %q", policy,
+		[]ArchConflictPolicy{ArchConflictPolicyAWS, ArchConflictPolicyUser, ArchConflictPolicyError})
+}
+
+// userArchitecture returns the kubernetes.io/arch node label the MachineDeployment declares in its machine template,
+// and true if it conflicts with the architecture of the instance type.
+func userArchitecture(machineDeployment *clusterv1.MachineDeployment, architecture normalizedArch) (string, bool) {
+	userArch, ok := machineDeployment.Spec.Template.Labels[archLabelKey]
+	return userArch, ok && userArch != string(architecture)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestReconcileWithArchConflict(t *testing.T) {
+	testCases := []struct {
+		name           string
+		policy         ArchConflictPolicy
+		expectedLabels string
+		expectedEvent  string
+	}{
+		{
+			name:           "aws",
+			policy:         ArchConflictPolicyAWS,
+			expectedLabels: "kubernetes.io/arch=amd64",
+		},
+		{
+			name:           "user",
+			policy:         ArchConflictPolicyUser,
+			expectedLabels: "kubernetes.io/arch=arm64",
+		},
+		{
+			name:          "error",
+			policy:        ArchConflictPolicyError,
+			expectedEvent: corev1.EventTypeWarning + " ArchitectureConflict Failed to set autoscaling from zero annotations, node label kubernetes.io/arch=arm64 conflicts with architecture amd64 of instance type a1.2xlarge",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Spec.Template.Labels = map[string]string{archLabelKey: "arm64"}
+
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+			r.ArchConflictPolicy = tc.policy
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			g.Expect(err).ToNot(HaveOccurred())
+			if tc.expectedEvent != "" {
+				g.Expect(machineDeployment.Annotations).ToNot(HaveKey(labelsKey))
+				g.Expect(recorder.Events).To(Receive(Equal(tc.expectedEvent)))
+				return
+			}
+			g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(labelsKey, tc.expectedLabels))
+			g.Expect(recorder.Events).To(BeEmpty())
+		})
+	}
+}
+
+func TestParseArchConflictPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	policy, err := ParseArchConflictPolicy("")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(policy).To(Equal(ArchConflictPolicyAWS))
+
+	_, err = ParseArchConflictPolicy("ignore")
+	g.Expect(err).To(HaveOccurred())
+}
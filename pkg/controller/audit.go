@@ -0,0 +1,242 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// AuditSchemaVersion is the version of the AuditRecord schema. Fields are only added within a version.
+	AuditSchemaVersion = "v1"
+
+	// AuditTypeAnnotationsChanged is the type of records of annotations changed by the controller.
+	AuditTypeAnnotationsChanged = "AnnotationsChanged"
+	// AuditTypeFailure is the type of records of failures reported as warning events.
+	AuditTypeFailure = "Failure"
+)
+
+// AuditRecord is an annotation change or a failure of the controller. Unlike Kubernetes Events, which are
+// garbage collected after an hour, audit records are written to a sink with a longer retention.
+type AuditRecord struct {
+	SchemaVersion     string                      `json:"schemaVersion"`
+	Time              time.Time                   `json:"time"`
+	Type              string                      `json:"type"`
+	MachineDeployment string                      `json:"machineDeployment"`
+	Reason            string                      `json:"reason,omitempty"`
+	Message           string                      `json:"message,omitempty"`
+	Changes           map[string]AnnotationChange `json:"changes,omitempty"`
+}
+
+// AnnotationChange is the change of a single annotation. Old is nil for added and New is nil for removed annotations.
+type AnnotationChange struct {
+	Old *string `json:"old"`
+	New *string `json:"new"`
+}
+
+// AuditSink receives the audit records of the controller. Send must not block the reconcile.
+type AuditSink interface {
+	Send(auditRecord AuditRecord)
+}
+
+// diffAnnotations returns the changes from the old to the new annotations.
+func diffAnnotations(oldAnnotations, newAnnotations map[string]string) map[string]AnnotationChange {
+	changes := map[string]AnnotationChange{}
+	for key, oldValue := range oldAnnotations {
+		newValue, ok := newAnnotations[key]
+		switch {
+		case !ok:
+			changes[key] = AnnotationChange{Old: &oldValue}
+		case newValue != oldValue:
+			changes[key] = AnnotationChange{Old: &oldValue, New: &newValue}
+		}
+	}
+	for key, newValue := range newAnnotations {
+		if _, ok := oldAnnotations[key]; !ok {
+			changes[key] = AnnotationChange{New: &newValue}
+		}
+	}
+	return changes
+}
+
+// auditAnnotationChanges sends a record of the annotation changes of the MachineDeployment, if there are any.
+func (r *Reconciler) auditAnnotationChanges(obj client.Object, oldAnnotations map[string]string) {
+	if r.AuditSink == nil {
+		return
+	}
+
+	changes := diffAnnotations(oldAnnotations, obj.GetAnnotations())
+	if len(changes) == 0 {
+		return
+	}
+	r.AuditSink.Send(AuditRecord{
+		SchemaVersion:     AuditSchemaVersion,
+		Time:              time.Now().UTC(),
+		Type:              AuditTypeAnnotationsChanged,
+		MachineDeployment: client.ObjectKeyFromObject(obj).String(),
+		Changes:           changes,
+	})
+}
+
+// auditRecorder is an event recorder that also sends warning events as failure records to the audit sink.
+type auditRecorder struct {
+	record.EventRecorder
+	sink AuditSink
+}
+
+// Event records the event and sends warning events to the audit sink.
+func (a *auditRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	a.EventRecorder.Event(object, eventtype, reason, message)
+	a.audit(object, eventtype, reason, message)
+}
+
+// Eventf records the event and sends warning events to the audit sink.
+func (a *auditRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	a.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	a.audit(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// AnnotatedEventf records the event and sends warning events to the audit sink.
+func (a *auditRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	a.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	a.audit(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (a *auditRecorder) audit(object runtime.Object, eventtype, reason, message string) {
+	if eventtype != corev1.EventTypeWarning {
+		return
+	}
+
+	name := ""
+	if obj, ok := object.(client.Object); ok {
+		name = client.ObjectKeyFromObject(obj).String()
+	}
+	a.sink.Send(AuditRecord{
+		SchemaVersion:     AuditSchemaVersion,
+		Time:              time.Now().UTC(),
+		Type:              AuditTypeFailure,
+		MachineDeployment: name,
+		Reason:            reason,
+		Message:           message,
+	})
+}
+
+// WriterAuditSink writes each audit record as a line of JSON, e.g. to stdout, which is kept apart from the
+// log output on stderr. Access to the writer is synchronized via mutex.
+type WriterAuditSink struct {
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+// NewWriterAuditSink creates an audit sink writing JSON lines to the writer.
+func NewWriterAuditSink(writer io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{writer: writer}
+}
+
+// Send writes the record as a line of JSON.
+func (w *WriterAuditSink) Send(auditRecord AuditRecord) {
+	data, err := json.Marshal(auditRecord)
+	if err != nil {
+		klog.Errorf("Failed to encode audit record: %v", err)
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, err := w.writer.Write(append(data, '\n')); err != nil {
+		klog.Errorf("Failed to write audit record: %v", err)
+	}
+}
+
+// webhookAuditSinkQueueSize is the number of records buffered for delivery to the webhook.
+const webhookAuditSinkQueueSize = 1000
+
+// WebhookAuditSink posts each audit record as JSON to a webhook. Records are queued and delivered in the
+// background, so a slow webhook does not block the reconcile. Records are dropped if the queue is full.
+type WebhookAuditSink struct {
+	url    string
+	client *http.Client
+	queue  chan AuditRecord
+}
+
+// NewWebhookAuditSink creates an audit sink posting records to the URL. It must be started to deliver records.
+func NewWebhookAuditSink(url string) *WebhookAuditSink {
+	return &WebhookAuditSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan AuditRecord, webhookAuditSinkQueueSize),
+	}
+}
+
+// Send queues the record for delivery.
+func (w *WebhookAuditSink) Send(auditRecord AuditRecord) {
+	select {
+	case w.queue <- auditRecord:
+	default:
+		klog.Errorf("Dropping audit record of %s, the webhook queue is full", auditRecord.MachineDeployment)
+	}
+}
+
+// Start delivers the queued records until the context is cancelled.
+// It implements the controller-runtime Runnable interface.
+func (w *WebhookAuditSink) Start(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case auditRecord := <-w.queue:
+			if err := w.post(ctx, auditRecord); err != nil {
+				klog.Errorf("Failed to deliver audit record of %s: %v", auditRecord.MachineDeployment, err)
+			}
+		}
+	}
+}
+
+// post delivers a single record to the webhook.
+func (w *WebhookAuditSink) post(ctx context.Context, auditRecord AuditRecord) error {
+	data, err := json.Marshal(auditRecord)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// testAuditSink collects the audit records it receives.
+type testAuditSink struct {
+	records []AuditRecord
+	mutex   sync.Mutex
+}
+
+func (s *testAuditSink) Send(auditRecord AuditRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records = append(s.records, auditRecord)
+}
+
+func TestDiffAnnotations(t *testing.T) {
+	g := NewWithT(t)
+
+	changes := diffAnnotations(
+		map[string]string{"removed": "a", "changed": "b", "unchanged": "c"},
+		map[string]string{"changed": "B", "unchanged": "c", "added": "d"},
+	)
+	g.Expect(changes).To(Equal(map[string]AnnotationChange{
+		"removed": {Old: ptr.To("a")},
+		"changed": {Old: ptr.To("b"), New: ptr.To("B")},
+		"added":   {New: ptr.To("d")},
+	}))
+	g.Expect(diffAnnotations(map[string]string{"a": "b"}, map[string]string{"a": "b"})).To(BeEmpty())
+}
+
+func TestReconcileWithAuditSink(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "audited"
+
+	unknownMachineDeployment, unknownAWSMachineTemplate, _, _, err := newTestMachineDeployment("default", "invalid", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	unknownMachineDeployment.Name = "unknown"
+	unknownAWSMachineTemplate.Name = "unknown"
+	unknownMachineDeployment.Spec.Template.Spec.InfrastructureRef.Name = "unknown"
+
+	sink := &testAuditSink{}
+	r := newTestReconciler(g, machineDeployment, unknownMachineDeployment, awsMachineTemplate, unknownAWSMachineTemplate, cluster, awsCluster)
+	r.AuditSink = sink
+	r.recorder = &auditRecorder{EventRecorder: r.recorder, sink: sink}
+
+	// The first reconcile adds the annotations, the second one changes nothing
+	for i := 0; i < 2; i++ {
+		_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(sink.records).To(HaveLen(1))
+	g.Expect(sink.records[0].SchemaVersion).To(Equal(AuditSchemaVersion))
+	g.Expect(sink.records[0].Type).To(Equal(AuditTypeAnnotationsChanged))
+	g.Expect(sink.records[0].MachineDeployment).To(Equal("default/audited"))
+	g.Expect(sink.records[0].Changes).To(HaveKeyWithValue(cpuKey, AnnotationChange{New: ptr.To("8")}))
+
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(unknownMachineDeployment)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sink.records).To(HaveLen(2))
+	g.Expect(sink.records[1].Type).To(Equal(AuditTypeFailure))
+	g.Expect(sink.records[1].MachineDeployment).To(Equal("default/unknown"))
+	g.Expect(sink.records[1].Reason).To(Equal("FailedUpdate"))
+	g.Expect(sink.records[1].Message).To(ContainSubstring("instance type unknown"))
+}
+
+func TestWriterAuditSink(t *testing.T) {
+	g := NewWithT(t)
+
+	buffer := &bytes.Buffer{}
+	sink := NewWriterAuditSink(buffer)
+	sink.Send(AuditRecord{SchemaVersion: AuditSchemaVersion, Type: AuditTypeFailure, MachineDeployment: "default/a"})
+	sink.Send(AuditRecord{SchemaVersion: AuditSchemaVersion, Type: AuditTypeFailure, MachineDeployment: "default/b"})
+
+	lines := bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n"))
+	g.Expect(lines).To(HaveLen(2))
+	decoded := AuditRecord{}
+	g.Expect(json.Unmarshal(lines[1], &decoded)).To(Succeed())
+	g.Expect(decoded.MachineDeployment).To(Equal("default/b"))
+}
+
+func TestWebhookAuditSink(t *testing.T) {
+	g := NewWithT(t)
+
+	received := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received <- body
+	}))
+	defer server.Close()
+
+	sink := NewWebhookAuditSink(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = sink.Start(ctx)
+	}()
+
+	sink.Send(AuditRecord{SchemaVersion: AuditSchemaVersion, Type: AuditTypeFailure, MachineDeployment: "default/a"})
+
+	var body []byte
+	g.Eventually(received, 5*time.Second).Should(Receive(&body))
+	decoded := AuditRecord{}
+	g.Expect(json.Unmarshal(body, &decoded)).To(Succeed())
+	g.Expect(decoded.MachineDeployment).To(Equal("default/a"))
+}
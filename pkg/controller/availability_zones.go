@@ -0,0 +1,130 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	"k8s.io/klog/v2"
+)
+
+// AvailabilityZonesCache is a cache for the availability zones of regions.
+type AvailabilityZonesCache interface {
+	GetZoneID(awsClient awsclient.Client, cacheID string, zone string) (string, error)
+}
+
+// availabilityZonesRegion holds the cached zone IDs by zone name of a specific region and the time when it was last updated.
+type availabilityZonesRegion struct {
+	zoneIDs    map[string]string
+	lastUpdate time.Time
+}
+
+// DefaultAvailabilityZonesCacheTTL is the duration after which the cached availability zones of a region are refreshed.
+// Availability zones rarely change, so they are kept much longer than instance types.
+const DefaultAvailabilityZonesCacheTTL = 7 * 24 * time.Hour
+
+// availabilityZonesCache holds cached availability zones per region. Access is synchronized via rwmutex.
+type availabilityZonesCache struct {
+	cache   map[string]availabilityZonesRegion
+	ttl     time.Duration
+	rwmutex sync.RWMutex
+}
+
+// NewAvailabilityZonesCache creates an empty availability zones cache refreshed after DefaultAvailabilityZonesCacheTTL.
+func NewAvailabilityZonesCache() AvailabilityZonesCache {
+	return NewAvailabilityZonesCacheWithTTL(DefaultAvailabilityZonesCacheTTL)
+}
+
+// NewAvailabilityZonesCacheWithTTL creates an empty availability zones cache refreshed after the given duration.
+func NewAvailabilityZonesCacheWithTTL(ttl time.Duration) AvailabilityZonesCache {
+	return &availabilityZonesCache{
+		cache: map[string]availabilityZonesRegion{},
+		ttl:   ttl,
+	}
+}
+
+// GetZoneID returns the ID of the availability zone, e.g. use1-az1 for us-east-1a. If the cache is stale or nil
+// it is refreshed first from the EC2 API. An empty ID is returned if the zone does not exist in the region.
+// The fetched zones are specific to the region and the credentials of the awsClient, the cacheID must be the one
+// of cacheIDOf.
+func (a *availabilityZonesCache) GetZoneID(awsClient awsclient.Client, cacheID string, zone string) (string, error) {
+	a.rwmutex.RLock()
+	if !a.isCacheFresh(cacheID) {
+		a.rwmutex.RUnlock()
+		if err := a.refresh(awsClient, cacheID); err != nil {
+			return "", fmt.Errorf("error refreshing availability zones cache: %w", err)
+		}
+		a.rwmutex.RLock()
+	}
+	defer a.rwmutex.RUnlock()
+
+	return a.cache[cacheID].zoneIDs[zone], nil
+}
+
+// isCacheFresh checks whether the cache for given cacheID is populated and has been refreshed within the TTL.
+func (a *availabilityZonesCache) isCacheFresh(cacheID string) bool {
+	cacheForRegion, ok := a.cache[cacheID]
+	return ok && cacheForRegion.zoneIDs != nil && cacheForRegion.lastUpdate.After(time.Now().Add(-a.ttl))
+}
+
+// refresh ensures that the cache is updated in a thread safe way.
+func (a *availabilityZonesCache) refresh(awsClient awsclient.Client, cacheID string) error {
+	// Only one thread should refresh the cache at a time.
+	a.rwmutex.Lock()
+	defer a.rwmutex.Unlock()
+
+	if a.isCacheFresh(cacheID) {
+		// Another thread has already refreshed the cache.
+		return nil
+	}
+
+	zoneIDs, err := fetchEC2AvailabilityZones(awsClient)
+	if err != nil {
+		return fmt.Errorf("failed to refresh availability zones cache: %w", err)
+	}
+
+	a.cache[cacheID] = availabilityZonesRegion{zoneIDs: zoneIDs, lastUpdate: time.Now()}
+	return nil
+}
+
+// fetchEC2AvailabilityZones fetches the zone IDs by zone name of all availability zones of the region from EC2 API.
+// Zone names are mapped to different physical zones per AWS account, while zone IDs identify the same physical
+// zone in all accounts.
+func fetchEC2AvailabilityZones(awsClient awsclient.Client) (map[string]string, error) {
+	klog.V(3).Info("Refreshing availability zones cache")
+
+	if awsClient == nil {
+		return nil, errors.New("awsClient is nil")
+	}
+
+	// Include zones the account has not opted in to, e.g. Local Zones, so that their names are known as well
+	output, err := awsClient.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{
+		AllAvailabilityZones: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describeAvailabilityZones request failed: %w", err)
+	}
+
+	zoneIDs := make(map[string]string, len(output.AvailabilityZones))
+	for _, availabilityZone := range output.AvailabilityZones {
+		zoneIDs[aws.StringValue(availabilityZone.ZoneName)] = aws.StringValue(availabilityZone.ZoneId)
+	}
+	return zoneIDs, nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+)
+
+// countingZonesClient counts the DescribeAvailabilityZones requests and optionally fails them.
+type countingZonesClient struct {
+	awsclient.Client
+	requests int
+	err      error
+}
+
+func (c *countingZonesClient) DescribeAvailabilityZones(input *ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	c.requests++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.Client.DescribeAvailabilityZones(input)
+}
+
+func TestAvailabilityZonesCache(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	awsClient := &countingZonesClient{Client: fakeAWSClient}
+
+	cache := NewAvailabilityZonesCache()
+
+	// Zones are described once per region
+	for _, zone := range []string{"us-east-1a", "us-east-1a", "us-east-1b"} {
+		_, err := cache.GetZoneID(awsClient, "us-east-1", zone)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(awsClient.requests).To(Equal(1))
+
+	zoneID, err := cache.GetZoneID(awsClient, "us-east-1", "us-east-1b")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(zoneID).To(Equal("use1-az1"))
+
+	// Unknown zones do not refresh the cache
+	zoneID, err = cache.GetZoneID(awsClient, "us-east-1", "us-east-1z")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(zoneID).To(BeEmpty())
+	g.Expect(awsClient.requests).To(Equal(1))
+
+	_, err = cache.GetZoneID(awsClient, "us-west-2", "us-west-2a")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(awsClient.requests).To(Equal(2))
+
+	// Stale regions are refreshed and failed refreshes are not cached
+	staleCache := NewAvailabilityZonesCacheWithTTL(time.Nanosecond)
+	awsClient.err = errors.New("throttled")
+	_, err = staleCache.GetZoneID(awsClient, "us-east-1", "us-east-1a")
+	g.Expect(err).To(HaveOccurred())
+	awsClient.err = nil
+	_, err = staleCache.GetZoneID(awsClient, "us-east-1", "us-east-1a")
+	g.Expect(err).ToNot(HaveOccurred())
+	time.Sleep(time.Millisecond)
+	_, err = staleCache.GetZoneID(awsClient, "us-east-1", "us-east-1a")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(awsClient.requests).To(Equal(5))
+}
@@ -0,0 +1,72 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// AWSRetry is the exponential backoff of MachineDeployments whose instance type lookup failed for transient
+// reasons, e.g. throttling or timeouts. Instead of dropping the work until the next resync, the MachineDeployment
+// is requeued after InitialDelay, doubled with every consecutive failure up to MaxDelay.
+type AWSRetry struct {
+	// InitialDelay is the delay after the first transient failure. Zero disables the backoff.
+	InitialDelay time.Duration
+	// MaxDelay caps the delay.
+	MaxDelay time.Duration
+}
+
+// enabled returns true if transient failures are retried with backoff.
+func (b AWSRetry) enabled() bool {
+	return b.InitialDelay > 0 && b.MaxDelay > 0
+}
+
+// awsRetryTracker tracks the consecutive transient failures of MachineDeployments. Access is synchronized via mutex.
+type awsRetryTracker struct {
+	backoff  AWSRetry
+	failures map[types.NamespacedName]int
+	mutex    sync.Mutex
+}
+
+func newAWSRetryTracker(backoff AWSRetry) *awsRetryTracker {
+	return &awsRetryTracker{
+		backoff:  backoff,
+		failures: map[types.NamespacedName]int{},
+	}
+}
+
+// failed records a transient failure of the MachineDeployment and returns the delay until it is retried.
+func (t *awsRetryTracker) failed(key types.NamespacedName) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	failures := t.failures[key]
+	t.failures[key] = failures + 1
+	delay := t.backoff.InitialDelay
+	for i := 0; i < failures && delay < t.backoff.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, t.backoff.MaxDelay)
+}
+
+// forget removes the MachineDeployment from the tracker, the next failure is retried after the initial delay.
+func (t *awsRetryTracker) forget(key types.NamespacedName) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.failures, key)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestAWSRetryTracker(t *testing.T) {
+	g := NewWithT(t)
+
+	tracker := newAWSRetryTracker(AWSRetry{InitialDelay: 5 * time.Second, MaxDelay: 30 * time.Second})
+	key := types.NamespacedName{Namespace: "default", Name: "workers"}
+
+	delays := []time.Duration{}
+	for range 5 {
+		delays = append(delays, tracker.failed(key))
+	}
+	g.Expect(delays).To(Equal([]time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second}))
+
+	tracker.forget(key)
+	g.Expect(tracker.failed(key)).To(Equal(5 * time.Second))
+}
+
+func TestRetriable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name: "with no error",
+		},
+		{
+			name: "with an unknown instance type",
+			err:  annotatorerrors.Errorf(annotatorerrors.ErrUnknownInstanceType, "instance type %q not found", "invalid"),
+		},
+		{
+			name:     "with throttling",
+			err:      fmt.Errorf("error describing instance types: %w", awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)),
+			expected: true,
+		},
+		{
+			name:     "with a request error",
+			err:      awserr.New("RequestError", "send request failed", errors.New("connection reset by peer")),
+			expected: true,
+		},
+		{
+			name:     "with a server error",
+			err:      awserr.NewRequestFailure(awserr.New("InternalError", "An internal error has occurred.", nil), 500, "request-id"),
+			expected: true,
+		},
+		{
+			name: "with a client error",
+			err:  awserr.NewRequestFailure(awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation.", nil), 403, "request-id"),
+		},
+		{
+			name:     "with a timeout",
+			err:      context.DeadlineExceeded,
+			expected: true,
+		},
+		{
+			name: "with another error",
+			err:  errors.New("invalid"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(retriable(tc.err)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestReconcileWithAWSRetry(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "throttled"
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.AWSRetry = AWSRetry{InitialDelay: 5 * time.Second, MaxDelay: time.Minute}
+	r.awsRetries = newAWSRetryTracker(r.AWSRetry)
+	awsClient, err := r.AwsClientBuilder(nil, "", "", "", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	clientBuilder := r.AwsClientBuilder
+	r.AwsClientBuilder = func(client.Client, string, string, string, awsclient.RegionCache) (awsclient.Client, error) {
+		return &throttledInstanceTypesClient{Client: awsClient}, nil
+	}
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+
+	// Throttled lookups are retried with exponential backoff
+	result, err := r.Reconcile(ctx, req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(5 * time.Second))
+
+	result, err = r.Reconcile(ctx, req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(10 * time.Second))
+
+	// A successful lookup resets the backoff, the failed refresh is not served from the cache anymore
+	r.AwsClientBuilder = clientBuilder
+	r.InstanceTypesCache = NewInstanceTypesCache()
+	result, err = r.Reconcile(ctx, req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeZero())
+	g.Expect(r.awsRetries.failed(req.NamespacedName)).To(Equal(5 * time.Second))
+}
@@ -0,0 +1,167 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/client/azure"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	azureMachineTemplateKind = "AzureMachineTemplate"
+	azureClusterKind         = "AzureCluster"
+)
+
+// AzureProvider resolves the capacity of the VM sizes of AzureMachineTemplates from the Compute Resource SKUs API,
+// using the location and subscription of the AzureCluster of the Cluster of the MachineDeployment.
+type AzureProvider struct {
+	skuClient azure.SKUClient
+	ttl       time.Duration
+
+	mutex sync.Mutex
+	// skus holds the VM sizes per subscription and location.
+	skus map[string]azureLocationSKUs
+}
+
+type azureLocationSKUs struct {
+	instanceTypes map[string]InstanceType
+	lastUpdate    time.Time
+}
+
+// NewAzureProvider creates an Azure provider looking up the VM sizes with the SKU client. The VM sizes of a
+// location are listed again after the ttl.
+func NewAzureProvider(skuClient azure.SKUClient, ttl time.Duration) *AzureProvider {
+	return &AzureProvider{
+		skuClient: skuClient,
+		ttl:       ttl,
+		skus:      map[string]azureLocationSKUs{},
+	}
+}
+
+// Name returns the name of the provider.
+func (p *AzureProvider) Name() string {
+	return "azure"
+}
+
+// Handles returns true for AzureMachineTemplates.
+func (p *AzureProvider) Handles(ref corev1.ObjectReference) bool {
+	return ref.Kind == azureMachineTemplateKind && ref.GroupVersionKind().Group == "infrastructure.cluster.x-k8s.io"
+}
+
+// ResolveCapacity resolves the capacity of the VM size of the AzureMachineTemplate of the MachineDeployment.
+func (p *AzureProvider) ResolveCapacity(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (ProviderCapacity, error) {
+	ref := machineDeployment.Spec.Template.Spec.InfrastructureRef
+	template, err := getUnstructured(ctx, c, ref.APIVersion, ref.Kind, ref.Namespace, ref.Name, machineDeployment.Namespace)
+	if err != nil {
+		return ProviderCapacity{}, err
+	}
+	vmSize, _, err := unstructured.NestedString(template.Object, "spec", "template", "spec", "vmSize")
+	if err != nil || vmSize == "" {
+		return ProviderCapacity{}, fmt.Errorf("AzureMachineTemplate %s/%s has no vmSize", template.GetNamespace(), template.GetName())
+	}
+
+	azureCluster, err := getInfrastructureCluster(ctx, c, machineDeployment, azureClusterKind)
+	if err != nil {
+		return ProviderCapacity{}, err
+	}
+	location, _, _ := unstructured.NestedString(azureCluster.Object, "spec", "location")
+	subscriptionID, _, _ := unstructured.NestedString(azureCluster.Object, "spec", "subscriptionID")
+	if location == "" || subscriptionID == "" {
+		return ProviderCapacity{}, fmt.Errorf("AzureCluster %s/%s has no location or subscriptionID", azureCluster.GetNamespace(), azureCluster.GetName())
+	}
+
+	instanceType, err := p.getVMSize(ctx, subscriptionID, location, vmSize)
+	if err != nil {
+		return ProviderCapacity{}, err
+	}
+	return ProviderCapacity{Location: location, InstanceType: instanceType}, nil
+}
+
+// getVMSize returns the capacity of the VM size, listing the SKUs of the location if the cache is stale.
+func (p *AzureProvider) getVMSize(ctx context.Context, subscriptionID, location, vmSize string) (InstanceType, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	cacheID := subscriptionID + "/" + location
+	source := DataSourceCache
+	cached, ok := p.skus[cacheID]
+	if !ok || time.Since(cached.lastUpdate) > p.ttl {
+		skus, err := p.skuClient.ListResourceSKUs(ctx, subscriptionID, location)
+		if err != nil {
+			return InstanceType{}, err
+		}
+		cached = azureLocationSKUs{instanceTypes: map[string]InstanceType{}, lastUpdate: time.Now()}
+		for _, sku := range skus {
+			instanceType, err := azureInstanceType(sku)
+			if err != nil {
+				return InstanceType{}, err
+			}
+			cached.instanceTypes[strings.ToLower(sku.Name)] = instanceType
+		}
+		p.skus[cacheID] = cached
+		source = DataSourceAPI
+	}
+
+	instanceType, ok := cached.instanceTypes[strings.ToLower(vmSize)]
+	if !ok {
+		return InstanceType{}, fmt.Errorf("VM size %q not found in location %s", vmSize, location)
+	}
+	instanceType.Source = source
+	instanceType.FetchedAt = cached.lastUpdate
+	return instanceType, nil
+}
+
+// azureInstanceType converts the capabilities of a VM size SKU. MemoryGB is the memory in GiB.
+func azureInstanceType(sku azure.ResourceSKU) (InstanceType, error) {
+	instanceType := InstanceType{
+		InstanceType:    sku.Name,
+		CPUArchitecture: ArchitectureAmd64,
+	}
+	if value, ok := sku.Capability("vCPUs"); ok {
+		vcpus, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return InstanceType{}, fmt.Errorf("invalid vCPUs %q of VM size %s: %w", value, sku.Name, err)
+		}
+		instanceType.VCPU = vcpus
+	}
+	if value, ok := sku.Capability("MemoryGB"); ok {
+		memory, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return InstanceType{}, fmt.Errorf("invalid MemoryGB %q of VM size %s: %w", value, sku.Name, err)
+		}
+		instanceType.MemoryMb = int64(math.Round(memory * 1024))
+	}
+	if value, ok := sku.Capability("GPUs"); ok {
+		gpus, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return InstanceType{}, fmt.Errorf("invalid GPUs %q of VM size %s: %w", value, sku.Name, err)
+		}
+		instanceType.GPU = gpus
+	}
+	if value, ok := sku.Capability("CpuArchitectureType"); ok && strings.EqualFold(value, "Arm64") {
+		instanceType.CPUArchitecture = ArchitectureArm64
+	}
+	return instanceType, nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/client/azure"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type fakeSKUClient struct {
+	calls int
+}
+
+func (c *fakeSKUClient) ListResourceSKUs(_ context.Context, subscriptionID, location string) ([]azure.ResourceSKU, error) {
+	c.calls++
+	return []azure.ResourceSKU{
+		{
+			Name:         "Standard_D4s_v3",
+			ResourceType: "virtualMachines",
+			Capabilities: []azure.Capability{{Name: "vCPUs", Value: "4"}, {Name: "MemoryGB", Value: "16"}},
+		},
+		{
+			Name:         "Standard_NC6s_v3",
+			ResourceType: "virtualMachines",
+			Capabilities: []azure.Capability{{Name: "vCPUs", Value: "6"}, {Name: "MemoryGB", Value: "112"}, {Name: "GPUs", Value: "1"}},
+		},
+		{
+			Name:         "Standard_D4ps_v5",
+			ResourceType: "virtualMachines",
+			Capabilities: []azure.Capability{{Name: "vCPUs", Value: "4"}, {Name: "MemoryGB", Value: "16"}, {Name: "CpuArchitectureType", Value: "Arm64"}},
+		},
+	}, nil
+}
+
+func newTestAzureObjects(namespace, vmSize string) (*unstructured.Unstructured, *unstructured.Unstructured) {
+	template := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta1",
+		"kind":       "AzureMachineTemplate",
+		"metadata":   map[string]interface{}{"name": "test-azure-template", "namespace": namespace},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{"spec": map[string]interface{}{"vmSize": vmSize}},
+		},
+	}}
+	azureCluster := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta1",
+		"kind":       "AzureCluster",
+		"metadata":   map[string]interface{}{"name": "test-cluster-azure", "namespace": namespace},
+		"spec":       map[string]interface{}{"location": "westeurope", "subscriptionID": "00000000-0000-0000-0000-000000000000"},
+	}}
+	return template, azureCluster
+}
+
+func TestReconcileWithAzureProvider(t *testing.T) {
+	testCases := []struct {
+		name                string
+		vmSize              string
+		expectErr           bool
+		expectedAnnotations map[string]string
+	}{
+		{
+			name:   "VM size",
+			vmSize: "Standard_D4s_v3",
+			expectedAnnotations: map[string]string{
+				cpuKey:    "4",
+				memoryKey: "16384",
+			},
+		},
+		{
+			name:   "VM size with GPUs",
+			vmSize: "Standard_NC6s_v3",
+			expectedAnnotations: map[string]string{
+				cpuKey:    "6",
+				memoryKey: "114688",
+				gpuKey:    "1",
+			},
+		},
+		{
+			name:   "arm64 VM size",
+			vmSize: "Standard_D4ps_v5",
+			expectedAnnotations: map[string]string{
+				labelsKey: "kubernetes.io/arch=arm64",
+			},
+		},
+		{
+			name:      "unknown VM size",
+			vmSize:    "Standard_Unknown",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, _, cluster, _, err := newTestMachineDeployment("default", "", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			template, azureCluster := newTestAzureObjects("default", tc.vmSize)
+			machineDeployment.Spec.Template.Spec.InfrastructureRef = corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+				Kind:       "AzureMachineTemplate",
+				Name:       template.GetName(),
+			}
+			cluster.Spec.InfrastructureRef = &corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+				Kind:       "AzureCluster",
+				Name:       azureCluster.GetName(),
+			}
+
+			r := newTestReconciler(g, machineDeployment, template, cluster, azureCluster)
+			r.Providers = []Provider{NewAzureProvider(&fakeSKUClient{}, time.Hour)}
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			if tc.expectErr {
+				g.Expect(err).To(MatchError(ContainSubstring(`VM size "Standard_Unknown" not found in location westeurope`)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			for key, value := range tc.expectedAnnotations {
+				g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(key, value))
+			}
+		})
+	}
+}
+
+func TestAzureProviderCachesSKUs(t *testing.T) {
+	g := NewWithT(t)
+
+	skuClient := &fakeSKUClient{}
+	provider := NewAzureProvider(skuClient, time.Hour)
+	for _, vmSize := range []string{"Standard_D4s_v3", "standard_nc6s_v3"} {
+		instanceType, err := provider.getVMSize(ctx, "subscription", "westeurope", vmSize)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(instanceType.VCPU).ToNot(BeZero())
+	}
+	g.Expect(skuClient.calls).To(Equal(1))
+
+	_, err := provider.getVMSize(ctx, "subscription", "eastus", "Standard_D4s_v3")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(skuClient.calls).To(Equal(2))
+}
@@ -0,0 +1,117 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+)
+
+// CachedRegion is the content of a cache for a single region.
+type CachedRegion struct {
+	LastUpdate time.Time `json:"lastUpdate"`
+	// Stale is true if the region is refreshed from the EC2 API on its next use.
+	Stale   bool        `json:"stale"`
+	Entries interface{} `json:"entries"`
+}
+
+// CacheDump is the content of the caches of the reconciler, by cache ID. Caches that cannot be dumped,
+// e.g. custom implementations of the cache interfaces, are omitted.
+type CacheDump struct {
+	InstanceTypes     map[string]CachedRegion `json:"instanceTypes,omitempty"`
+	AvailabilityZones map[string]CachedRegion `json:"availabilityZones,omitempty"`
+	// Regions are the described regions by masked access key ID of the credentials describing them.
+	Regions map[string]awsclient.CachedRegions `json:"regions,omitempty"`
+}
+
+// instanceTypesDumper is implemented by instance types caches whose content can be dumped.
+type instanceTypesDumper interface {
+	dump() map[string]CachedRegion
+}
+
+// dump returns the cached instance types by cache ID.
+func (i *instanceTypesCache) dump() map[string]CachedRegion {
+	i.rwmutex.RLock()
+	defer i.rwmutex.RUnlock()
+
+	regions := make(map[string]CachedRegion, len(i.cache))
+	for cacheID, region := range i.cache {
+		regions[cacheID] = CachedRegion{LastUpdate: region.lastUpdate, Stale: !i.isCacheFresh(cacheID), Entries: region.instanceTypes}
+	}
+	return regions
+}
+
+// dump returns the cached instance types of the wrapped cache. Aliases are not dumped.
+func (a *aliasedInstanceTypesCache) dump() map[string]CachedRegion {
+	if cache, ok := a.cache.(instanceTypesDumper); ok {
+		return cache.dump()
+	}
+	return nil
+}
+
+// dump returns the cached instance types of the wrapped cache. Instance types of the dataset are not cached.
+func (f *fallbackInstanceTypesCache) dump() map[string]CachedRegion {
+	if cache, ok := f.cache.(instanceTypesDumper); ok {
+		return cache.dump()
+	}
+	return nil
+}
+
+// dump returns the cached zone IDs by cache ID.
+func (a *availabilityZonesCache) dump() map[string]CachedRegion {
+	a.rwmutex.RLock()
+	defer a.rwmutex.RUnlock()
+
+	regions := make(map[string]CachedRegion, len(a.cache))
+	for cacheID, region := range a.cache {
+		regions[cacheID] = CachedRegion{LastUpdate: region.lastUpdate, Stale: !a.isCacheFresh(cacheID), Entries: region.zoneIDs}
+	}
+	return regions
+}
+
+// DumpCaches returns the content of the instance types, availability zones and region caches.
+func (r *Reconciler) DumpCaches() CacheDump {
+	var dump CacheDump
+	if cache, ok := r.InstanceTypesCache.(instanceTypesDumper); ok {
+		dump.InstanceTypes = cache.dump()
+	}
+	if cache, ok := r.AvailabilityZonesCache.(*availabilityZonesCache); ok {
+		dump.AvailabilityZones = cache.dump()
+	}
+	if cache, ok := r.RegionCache.(awsclient.RegionCacheDumper); ok {
+		dump.Regions = cache.DumpRegions()
+	}
+	return dump
+}
+
+// CacheDumpHandler serves the content of the caches of the reconciler as JSON.
+type CacheDumpHandler struct {
+	// Reconciler is the reconciler owning the caches.
+	Reconciler *Reconciler
+}
+
+// ServeHTTP serves the cache dump.
+func (h *CacheDumpHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	data, err := json.MarshalIndent(h.Reconciler.DumpCaches(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
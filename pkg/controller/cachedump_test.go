@@ -0,0 +1,87 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+)
+
+func TestCacheDumpHandler(t *testing.T) {
+	g := NewWithT(t)
+
+	awsClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := &Reconciler{
+		InstanceTypesCache:     NewInstanceTypesCache(),
+		AvailabilityZonesCache: NewAvailabilityZonesCacheWithTTL(-time.Second),
+	}
+	_, err = r.InstanceTypesCache.GetInstanceType(awsClient, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = r.AvailabilityZonesCache.GetZoneID(awsClient, "us-east-1", "us-east-1a")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	recorder := httptest.NewRecorder()
+	(&CacheDumpHandler{Reconciler: r}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/caches", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	var dump struct {
+		InstanceTypes     map[string]CachedRegion `json:"instanceTypes"`
+		AvailabilityZones map[string]CachedRegion `json:"availabilityZones"`
+	}
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &dump)).To(Succeed())
+
+	g.Expect(dump.InstanceTypes).To(HaveKey("us-east-1"))
+	g.Expect(dump.InstanceTypes["us-east-1"].Stale).To(BeFalse())
+	g.Expect(dump.InstanceTypes["us-east-1"].Entries).To(HaveKey("a1.2xlarge"))
+
+	// The negative TTL makes the zones stale right away
+	g.Expect(dump.AvailabilityZones).To(HaveKey("us-east-1"))
+	g.Expect(dump.AvailabilityZones["us-east-1"].Stale).To(BeTrue())
+	g.Expect(dump.AvailabilityZones["us-east-1"].Entries).To(HaveKeyWithValue("us-east-1a", "use1-az6"))
+
+	// Wrapped caches are dumped, aliases and the dataset are not
+	dataset, err := EmbeddedInstanceTypesDataset()
+	g.Expect(err).ToNot(HaveOccurred())
+	r.InstanceTypesCache = NewFallbackInstanceTypesCache(NewAliasedInstanceTypesCache(r.InstanceTypesCache, nil), dataset)
+	g.Expect(r.DumpCaches().InstanceTypes).To(HaveKey("us-east-1"))
+
+	r.RegionCache = dumpingRegionCache{"****1234": {Regions: map[string]string{"us-east-1": "opt-in-not-required"}}}
+	g.Expect(r.DumpCaches().Regions).To(HaveKey("****1234"))
+
+	// Custom caches are omitted
+	r.InstanceTypesCache = struct{ InstanceTypesCache }{r.InstanceTypesCache}
+	g.Expect(r.DumpCaches().InstanceTypes).To(BeNil())
+}
+
+// dumpingRegionCache is a region cache serving a fixed dump.
+type dumpingRegionCache map[string]awsclient.CachedRegions
+
+func (c dumpingRegionCache) GetCachedDescribeRegions(*session.Session) (*ec2.DescribeRegionsOutput, error) {
+	return &ec2.DescribeRegionsOutput{}, nil
+}
+
+func (c dumpingRegionCache) DumpRegions() map[string]awsclient.CachedRegions {
+	return c
+}
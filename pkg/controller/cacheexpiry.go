@@ -0,0 +1,158 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"k8s.io/klog/v2"
+)
+
+// CacheExpiry is the result of force-expiring cache entries.
+type CacheExpiry struct {
+	// Regions are the cache IDs whose entries were expired.
+	Regions []string `json:"regions"`
+	// InstanceTypes are the expired instance types, empty if whole regions were expired.
+	InstanceTypes []string `json:"instanceTypes,omitempty"`
+}
+
+// instanceTypesExpirer is implemented by instance types caches whose entries can be force-expired.
+type instanceTypesExpirer interface {
+	expire(cacheID string, instanceTypes []string) []string
+}
+
+// expire force-expires the given instance types of the region, or the whole region if no instance types are
+// given, so that they are fetched again from the EC2 API on their next use. A region name as cacheID expires the
+// region of all identities, an empty cacheID expires all regions. The IDs of the expired regions are returned.
+func (i *instanceTypesCache) expire(cacheID string, instanceTypes []string) []string {
+	i.rwmutex.Lock()
+	defer i.rwmutex.Unlock()
+
+	// Failed refreshes are retried right away
+	for id := range i.failures {
+		if matchesCacheID(id, cacheID) {
+			delete(i.failures, id)
+		}
+	}
+
+	expired := []string{}
+	for id, region := range i.cache {
+		if !matchesCacheID(id, cacheID) {
+			continue
+		}
+		expired = append(expired, id)
+		if len(instanceTypes) == 0 {
+			i.deleteLocked(id)
+			continue
+		}
+		if region.expired == nil {
+			region.expired = map[string]struct{}{}
+		}
+		for _, instanceType := range instanceTypes {
+			region.expired[instanceType] = struct{}{}
+		}
+		i.cache[id] = region
+	}
+	return expired
+}
+
+// expire force-expires the given instance types in the wrapped cache. Aliases are read at startup and not expired.
+func (a *aliasedInstanceTypesCache) expire(cacheID string, instanceTypes []string) []string {
+	if cache, ok := a.cache.(instanceTypesExpirer); ok {
+		return cache.expire(cacheID, instanceTypes)
+	}
+	return nil
+}
+
+// matchesCacheID returns whether the cache ID is expired by the cacheID of expire: the same cache ID, the region
+// of the cache ID or the empty cacheID of all regions.
+func matchesCacheID(id, cacheID string) bool {
+	return cacheID == "" || id == cacheID || cacheRegion(id) == cacheID
+}
+
+// expire force-expires the availability zones of the region, or of all regions if cacheID is empty.
+func (a *availabilityZonesCache) expire(cacheID string) []string {
+	a.rwmutex.Lock()
+	defer a.rwmutex.Unlock()
+
+	expired := []string{}
+	for id := range a.cache {
+		if matchesCacheID(id, cacheID) {
+			expired = append(expired, id)
+			delete(a.cache, id)
+		}
+	}
+	return expired
+}
+
+// ExpireCaches force-expires cached entries without restarting the controller, e.g. after AWS corrected the
+// published data of instance types. The given instance types of the region are fetched again on their next
+// use. Without instance types, the instance types and availability zones of the whole region are expired.
+// An empty region expires all regions.
+func (r *Reconciler) ExpireCaches(region string, instanceTypes []string) CacheExpiry {
+	regions := map[string]struct{}{}
+	if cache, ok := r.InstanceTypesCache.(instanceTypesExpirer); ok {
+		for _, id := range cache.expire(region, instanceTypes) {
+			regions[id] = struct{}{}
+		}
+	}
+	if cache, ok := r.AvailabilityZonesCache.(*availabilityZonesCache); ok && len(instanceTypes) == 0 {
+		for _, id := range cache.expire(region) {
+			regions[id] = struct{}{}
+		}
+	}
+
+	expiry := CacheExpiry{Regions: []string{}, InstanceTypes: instanceTypes}
+	for id := range regions {
+		expiry.Regions = append(expiry.Regions, id)
+	}
+	sort.Strings(expiry.Regions)
+	klog.Infof("Expired cache entries of regions %q, instance types %q", expiry.Regions, instanceTypes)
+	return expiry
+}
+
+// CacheExpiryHandler force-expires cache entries of the reconciler on POST requests. The region and the
+// instance types are selected with the "region" and repeated "instanceType" query parameters.
+type CacheExpiryHandler struct {
+	// Reconciler is the reconciler owning the caches.
+	Reconciler *Reconciler
+}
+
+// ServeHTTP expires the selected cache entries and responds with the expired regions.
+func (h *CacheExpiryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "cache entries are expired with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	region := query.Get("region")
+	instanceTypes := query["instanceType"]
+	if region == "" && query.Get("all") != "true" {
+		http.Error(w, "select a region, or all regions with all=true", http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.MarshalIndent(h.Reconciler.ExpireCaches(region, instanceTypes), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
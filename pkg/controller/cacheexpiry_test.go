@@ -0,0 +1,96 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+)
+
+func TestExpireCaches(t *testing.T) {
+	g := NewWithT(t)
+
+	awsClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := &Reconciler{
+		InstanceTypesCache:     NewAliasedInstanceTypesCache(NewInstanceTypesCache(), nil),
+		AvailabilityZonesCache: NewAvailabilityZonesCache(),
+	}
+	for _, region := range []string{"us-east-1", "eu-west-1"} {
+		_, err = r.InstanceTypesCache.GetInstanceType(awsClient, region, "a1.2xlarge")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = r.AvailabilityZonesCache.GetZoneID(awsClient, region, "us-east-1a")
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	// Expired instance types are fetched again, the others are still served from the cache
+	expiry := r.ExpireCaches("us-east-1", []string{"a1.2xlarge"})
+	g.Expect(expiry.Regions).To(Equal([]string{"us-east-1"}))
+	info, err := r.InstanceTypesCache.GetInstanceType(awsClient, "us-east-1", "p2.16xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(info.Source).To(Equal(DataSourceCache))
+	info, err = r.InstanceTypesCache.GetInstanceType(awsClient, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(info.Source).To(Equal(DataSourceAPI))
+	info, err = r.InstanceTypesCache.GetInstanceType(awsClient, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(info.Source).To(Equal(DataSourceCache))
+
+	// Expiring a region expires its availability zones as well
+	expiry = r.ExpireCaches("eu-west-1", nil)
+	g.Expect(expiry.Regions).To(Equal([]string{"eu-west-1"}))
+	dump := r.AvailabilityZonesCache.(*availabilityZonesCache).dump()
+	g.Expect(dump).To(HaveKey("us-east-1"))
+	g.Expect(dump).ToNot(HaveKey("eu-west-1"))
+	info, err = r.InstanceTypesCache.GetInstanceType(awsClient, "eu-west-1", "p2.16xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(info.Source).To(Equal(DataSourceAPI))
+
+	expiry = r.ExpireCaches("", nil)
+	g.Expect(expiry.Regions).To(Equal([]string{"eu-west-1", "us-east-1"}))
+	g.Expect(r.AvailabilityZonesCache.(*availabilityZonesCache).dump()).To(BeEmpty())
+}
+
+func TestCacheExpiryHandler(t *testing.T) {
+	g := NewWithT(t)
+
+	awsClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := &Reconciler{InstanceTypesCache: NewInstanceTypesCache(), AvailabilityZonesCache: NewAvailabilityZonesCache()}
+	_, err = r.InstanceTypesCache.GetInstanceType(awsClient, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	handler := &CacheExpiryHandler{Reconciler: r}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/caches/expire?region=us-east-1", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/debug/caches/expire", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/debug/caches/expire?region=us-east-1&instanceType=a1.2xlarge&instanceType=m5.large", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	var expiry CacheExpiry
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &expiry)).To(Succeed())
+	g.Expect(expiry).To(Equal(CacheExpiry{Regions: []string{"us-east-1"}, InstanceTypes: []string{"a1.2xlarge", "m5.large"}}))
+}
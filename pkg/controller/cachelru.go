@@ -0,0 +1,93 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+)
+
+// regionLRU orders cache IDs by their last use. It has its own mutex, so that lookups holding the read lock of
+// their cache can record their use.
+type regionLRU struct {
+	mutex sync.Mutex
+	// order holds the cache IDs, the most recently used first.
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+func newRegionLRU() *regionLRU {
+	return &regionLRU{order: list.New(), elements: map[string]*list.Element{}}
+}
+
+// touch records the use of the cache ID.
+func (l *regionLRU) touch(cacheID string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if element, ok := l.elements[cacheID]; ok {
+		l.order.MoveToFront(element)
+		return
+	}
+	l.elements[cacheID] = l.order.PushFront(cacheID)
+}
+
+// remove forgets the cache ID.
+func (l *regionLRU) remove(cacheID string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if element, ok := l.elements[cacheID]; ok {
+		l.order.Remove(element)
+		delete(l.elements, cacheID)
+	}
+}
+
+// oldest returns the least recently used cache ID, if any.
+func (l *regionLRU) oldest() (string, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	element := l.order.Back()
+	if element == nil {
+		return "", false
+	}
+	return element.Value.(string), true
+}
+
+// storeLocked caches the instance types of the region as its most recent use, evicting the least recently used
+// regions beyond maxEntries. The caller must hold the write lock.
+func (i *instanceTypesCache) storeLocked(cacheID string, region instanceTypesRegion) {
+	i.cache[cacheID] = region
+	i.lru.touch(cacheID)
+	for i.maxEntries > 0 && len(i.cache) > i.maxEntries {
+		evicted, ok := i.lru.oldest()
+		if !ok || evicted == cacheID {
+			break
+		}
+		i.deleteLocked(evicted)
+		metrics.InstanceTypesCacheEvictions.WithLabelValues(cacheRegion(evicted)).Inc()
+	}
+	metrics.InstanceTypesCacheEntries.Set(float64(len(i.cache)))
+}
+
+// deleteLocked removes the region from the cache. The caller must hold the write lock.
+func (i *instanceTypesCache) deleteLocked(cacheID string) {
+	delete(i.cache, cacheID)
+	delete(i.failures, cacheID)
+	i.lru.remove(cacheID)
+	metrics.InstanceTypesCacheEntries.Set(float64(len(i.cache)))
+}
@@ -0,0 +1,119 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBoundedInstanceTypesCache(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	requests := map[string]int{}
+	lookup := func(cache InstanceTypesCache, region string) {
+		_, err := cache.GetInstanceType(&pagingInstanceTypesClient{Client: fakeAWSClient, region: region, requests: requests}, region, "a1.2xlarge")
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	cache := NewBoundedInstanceTypesCache(time.Hour, 2).(*instanceTypesCache)
+	lookup(cache, "us-east-1")
+	lookup(cache, "eu-west-1")
+	fetched := requests["us-east-1"]
+
+	// Using us-east-1 makes eu-west-1 the least recently used region, evicted by the third region
+	lookup(cache, "us-east-1")
+	g.Expect(requests["us-east-1"]).To(Equal(fetched))
+	evictions := testutil.ToFloat64(metrics.InstanceTypesCacheEvictions.WithLabelValues("eu-west-1"))
+	lookup(cache, "ap-south-1")
+	g.Expect(cache.cache).To(HaveLen(2))
+	g.Expect(cache.cache).To(HaveKey("us-east-1"))
+	g.Expect(cache.cache).To(HaveKey("ap-south-1"))
+	g.Expect(testutil.ToFloat64(metrics.InstanceTypesCacheEvictions.WithLabelValues("eu-west-1"))).To(Equal(evictions + 1))
+	g.Expect(testutil.ToFloat64(metrics.InstanceTypesCacheEntries)).To(Equal(2.0))
+
+	// The evicted region is fetched again on its next use
+	lookup(cache, "eu-west-1")
+	g.Expect(requests["eu-west-1"]).To(Equal(2 * fetched))
+	g.Expect(cache.cache).To(HaveLen(2))
+	g.Expect(cache.cache).ToNot(HaveKey("us-east-1"))
+
+	// Replaced regions count as used as well
+	cache.replace("us-east-1", cache.cache["eu-west-1"].instanceTypes)
+	g.Expect(cache.cache).To(HaveLen(2))
+	g.Expect(cache.cache).ToNot(HaveKey("ap-south-1"))
+
+	// Expired regions are removed from the LRU
+	g.Expect(cache.expire("us-east-1", nil)).To(Equal([]string{"us-east-1"}))
+	g.Expect(cache.lru.elements).To(HaveLen(1))
+	g.Expect(testutil.ToFloat64(metrics.InstanceTypesCacheEntries)).To(Equal(1.0))
+}
+
+func TestUnboundedInstanceTypesCache(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	cache := NewInstanceTypesCacheWithTTL(time.Hour).(*instanceTypesCache)
+	for _, region := range []string{"us-east-1", "eu-west-1", "ap-south-1"} {
+		_, err := cache.GetInstanceType(fakeAWSClient, region, "a1.2xlarge")
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(cache.cache).To(HaveLen(3))
+}
+
+func TestBoundedInstanceTypesCacheEvictionDuringLookup(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Without a TTL every lookup refreshes its region, which evicts the region of concurrent lookups
+	cache := NewBoundedInstanceTypesCache(0, 1).(*instanceTypesCache)
+	regions := []string{"us-east-1", "eu-west-1", "ap-south-1"}
+	errs := make(chan error, 50*len(regions))
+	var wg sync.WaitGroup
+	for range 50 {
+		for _, region := range regions {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := cache.GetInstanceType(fakeAWSClient, region, "a1.2xlarge")
+				errs <- err
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	// Regions evicted after their refresh are not reported as unknown instance types
+	for err := range errs {
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	// Nor recorded as used once evicted
+	g.Expect(cache.cache).To(HaveLen(1))
+	g.Expect(cache.lru.elements).To(HaveLen(1))
+	for cacheID := range cache.lru.elements {
+		g.Expect(cache.cache).To(HaveKey(cacheID))
+	}
+}
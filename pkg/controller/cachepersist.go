@@ -0,0 +1,275 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// instanceTypesCachePersistInterval is the interval at which the instance types cache is written to its ConfigMap.
+	instanceTypesCachePersistInterval = 5 * time.Minute
+	// instanceTypesCacheLoadTimeout bounds the read of the ConfigMap at startup.
+	instanceTypesCacheLoadTimeout = 30 * time.Second
+	// instanceTypesCacheKeySuffix is the suffix of the binaryData keys of the regions in the ConfigMap.
+	instanceTypesCacheKeySuffix = ".json.gz"
+	// maxConfigMapSize is the size limit of ConfigMaps enforced by the API server.
+	maxConfigMapSize = 1024 * 1024
+)
+
+// persistedRegion is the ConfigMap representation of the cached instance types of a region.
+type persistedRegion struct {
+	LastUpdate    time.Time               `json:"lastUpdate"`
+	InstanceTypes map[string]InstanceType `json:"instanceTypes"`
+}
+
+// instanceTypesPersister is implemented by instance types caches that can be persisted across restarts.
+type instanceTypesPersister interface {
+	// snapshot returns the cached regions by cache ID.
+	snapshot() map[string]persistedRegion
+	// restore caches the regions that are not cached yet and have not expired.
+	restore(regions map[string]persistedRegion) []string
+}
+
+// snapshot returns the cached regions by cache ID.
+func (i *instanceTypesCache) snapshot() map[string]persistedRegion {
+	i.rwmutex.RLock()
+	defer i.rwmutex.RUnlock()
+
+	regions := make(map[string]persistedRegion, len(i.cache))
+	for cacheID, region := range i.cache {
+		if region.instanceTypes != nil {
+			regions[cacheID] = persistedRegion{LastUpdate: region.lastUpdate, InstanceTypes: region.instanceTypes}
+		}
+	}
+	return regions
+}
+
+// restore caches the regions that are not cached yet and have not expired, keeping their time of update so that
+// they are refreshed after the TTL as if the controller had not restarted. The restored cache IDs are returned.
+func (i *instanceTypesCache) restore(regions map[string]persistedRegion) []string {
+	i.rwmutex.Lock()
+	defer i.rwmutex.Unlock()
+
+	restored := []string{}
+	for cacheID, region := range regions {
+		if _, ok := i.cache[cacheID]; ok || time.Since(region.LastUpdate) >= i.ttl {
+			continue
+		}
+		i.storeLocked(cacheID, instanceTypesRegion{instanceTypes: region.InstanceTypes, lastUpdate: region.LastUpdate})
+		restored = append(restored, cacheID)
+	}
+	sort.Strings(restored)
+	return restored
+}
+
+// snapshot returns the cached regions of the wrapped cache.
+func (a *aliasedInstanceTypesCache) snapshot() map[string]persistedRegion {
+	if cache, ok := a.cache.(instanceTypesPersister); ok {
+		return cache.snapshot()
+	}
+	return nil
+}
+
+// restore restores the regions in the wrapped cache.
+func (a *aliasedInstanceTypesCache) restore(regions map[string]persistedRegion) []string {
+	if cache, ok := a.cache.(instanceTypesPersister); ok {
+		return cache.restore(regions)
+	}
+	return nil
+}
+
+// instanceTypesCacheStore persists the instance types cache in a ConfigMap, so that a restarted controller does not
+// fetch all regions at once with its first reconciles. Each region is stored gzipped in its own binaryData key.
+// The ConfigMap is read with the API reader, so that ConfigMaps are not watched.
+type instanceTypesCacheStore struct {
+	reader client.Reader
+	writer client.Client
+	key    types.NamespacedName
+	cache  instanceTypesPersister
+
+	// persisted holds the time of update of the regions last written, to skip writes without changes.
+	persisted map[string]time.Time
+}
+
+// load restores the regions of the ConfigMap in the cache. A missing ConfigMap is not an error.
+func (s *instanceTypesCacheStore) load(ctx context.Context) error {
+	configMap := &corev1.ConfigMap{}
+	if err := s.reader.Get(ctx, s.key, configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get ConfigMap %s: %w", s.key, err)
+	}
+
+	regions := map[string]persistedRegion{}
+	for key, data := range configMap.BinaryData {
+		cacheID, ok := strings.CutSuffix(key, instanceTypesCacheKeySuffix)
+		if !ok {
+			continue
+		}
+		region, err := decodePersistedRegion(data)
+		if err != nil {
+			klog.Warningf("Ignoring the instance types of %s in ConfigMap %s: %v", cacheID, s.key, err)
+			continue
+		}
+		regions[cacheID] = region
+	}
+	if restored := s.cache.restore(regions); len(restored) > 0 {
+		klog.Infof("Restored the instance types of regions %v from ConfigMap %s", restored, s.key)
+	}
+	return nil
+}
+
+// Start writes the cache to the ConfigMap at instanceTypesCachePersistInterval until the context is done.
+// It implements the controller-runtime Runnable interface.
+func (s *instanceTypesCacheStore) Start(ctx context.Context) error {
+	ticker := time.NewTicker(instanceTypesCachePersistInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.persist(ctx); err != nil {
+				klog.Errorf("Failed to persist the instance types cache: %v", err)
+			}
+		}
+	}
+}
+
+// persist writes the cached regions to the ConfigMap if any was updated since the last write. Regions whose cache
+// ID is not a valid ConfigMap key are skipped.
+func (s *instanceTypesCacheStore) persist(ctx context.Context) error {
+	regions := s.cache.snapshot()
+	changed := len(regions) != len(s.persisted)
+	for cacheID, region := range regions {
+		if !s.persisted[cacheID].Equal(region.LastUpdate) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	binaryData := map[string][]byte{}
+	size := 0
+	for cacheID, region := range regions {
+		key := cacheID + instanceTypesCacheKeySuffix
+		if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+			klog.V(2).Infof("Not persisting the instance types of %s: %v", cacheID, errs)
+			continue
+		}
+		data, err := encodePersistedRegion(region)
+		if err != nil {
+			return fmt.Errorf("failed to encode the instance types of %s: %w", cacheID, err)
+		}
+		binaryData[key] = data
+		size += len(key) + len(data)
+	}
+	if size > maxConfigMapSize {
+		return fmt.Errorf("the instance types of %d regions exceed the size limit of ConfigMap %s, bound the cache with --instance-types-cache-max-entries", len(binaryData), s.key)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err := s.reader.Get(ctx, s.key, configMap)
+	switch {
+	case apierrors.IsNotFound(err):
+		configMap = &corev1.ConfigMap{}
+		configMap.Namespace = s.key.Namespace
+		configMap.Name = s.key.Name
+		configMap.BinaryData = binaryData
+		if err := s.writer.Create(ctx, configMap); err != nil {
+			return fmt.Errorf("failed to create ConfigMap %s: %w", s.key, err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to get ConfigMap %s: %w", s.key, err)
+	default:
+		configMap.BinaryData = binaryData
+		if err := s.writer.Update(ctx, configMap); err != nil {
+			return fmt.Errorf("failed to update ConfigMap %s: %w", s.key, err)
+		}
+	}
+
+	s.persisted = make(map[string]time.Time, len(regions))
+	for cacheID, region := range regions {
+		s.persisted[cacheID] = region.LastUpdate
+	}
+	klog.V(2).Infof("Persisted the instance types of %d regions to ConfigMap %s", len(binaryData), s.key)
+	return nil
+}
+
+func encodePersistedRegion(region persistedRegion) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(writer).Encode(region); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodePersistedRegion(data []byte) (persistedRegion, error) {
+	var region persistedRegion
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return region, err
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return region, err
+	}
+	err = json.Unmarshal(decoded, &region)
+	return region, err
+}
+
+// setupInstanceTypesCacheStore restores the instance types cache from its ConfigMap and adds the runnable
+// persisting it. Failing to load the ConfigMap is not fatal, the regions are then fetched on their first use.
+func (r *Reconciler) setupInstanceTypesCacheStore(mgr ctrl.Manager) error {
+	cache, ok := r.InstanceTypesCache.(instanceTypesPersister)
+	if !ok {
+		klog.Warningf("The instance types cache cannot be persisted, ignoring ConfigMap %s", r.InstanceTypesCacheConfigMap)
+		return nil
+	}
+
+	store := &instanceTypesCacheStore{reader: mgr.GetAPIReader(), writer: mgr.GetClient(), key: r.InstanceTypesCacheConfigMap, cache: cache}
+	ctx, cancel := context.WithTimeout(context.Background(), instanceTypesCacheLoadTimeout)
+	defer cancel()
+	if err := store.load(ctx); err != nil {
+		klog.Warningf("Failed to restore the instance types cache, fetching regions on their first use: %v", err)
+	}
+	if err := mgr.Add(store); err != nil {
+		return fmt.Errorf("failed adding the instance types cache store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestInstanceTypesCacheStore(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	k8sClient := newTestReconciler(g).Client
+	key := client.ObjectKey{Namespace: "capa-annotator-system", Name: "instance-types-cache"}
+
+	// The cache of the first controller is persisted
+	cache := NewInstanceTypesCacheWithTTL(time.Hour).(*instanceTypesCache)
+	store := &instanceTypesCacheStore{reader: k8sClient, writer: k8sClient, key: key, cache: cache}
+	g.Expect(store.load(ctx)).To(Succeed())
+	g.Expect(cache.cache).To(BeEmpty())
+
+	expected, err := cache.GetInstanceType(fakeAWSClient, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(store.persist(ctx)).To(Succeed())
+
+	configMap := &corev1.ConfigMap{}
+	g.Expect(k8sClient.Get(ctx, key, configMap)).To(Succeed())
+	g.Expect(configMap.BinaryData).To(HaveKey("us-east-1.json.gz"))
+	resourceVersion := configMap.ResourceVersion
+
+	// Without changes, the ConfigMap is not written again
+	g.Expect(store.persist(ctx)).To(Succeed())
+	g.Expect(k8sClient.Get(ctx, key, configMap)).To(Succeed())
+	g.Expect(configMap.ResourceVersion).To(Equal(resourceVersion))
+
+	// A restarted controller serves the region from the ConfigMap without calling the EC2 API
+	restarted := NewInstanceTypesCacheWithTTL(time.Hour).(*instanceTypesCache)
+	g.Expect((&instanceTypesCacheStore{reader: k8sClient, writer: k8sClient, key: key, cache: restarted}).load(ctx)).To(Succeed())
+	g.Expect(restarted.cache).To(HaveKey("us-east-1"))
+	g.Expect(restarted.cache["us-east-1"].lastUpdate).To(BeTemporally("==", cache.cache["us-east-1"].lastUpdate))
+
+	instanceType, err := restarted.GetInstanceType(nil, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(instanceType.VCPU).To(Equal(expected.VCPU))
+	g.Expect(instanceType.MemoryMb).To(Equal(expected.MemoryMb))
+	g.Expect(instanceType.Source).To(Equal(DataSourceCache))
+
+	// Regions expired since they were persisted are fetched again on their first use
+	expired := NewInstanceTypesCacheWithTTL(time.Nanosecond).(*instanceTypesCache)
+	g.Expect((&instanceTypesCacheStore{reader: k8sClient, writer: k8sClient, key: key, cache: expired}).load(ctx)).To(Succeed())
+	g.Expect(expired.cache).To(BeEmpty())
+
+	// Updated regions are written again
+	cache.replace("us-east-1", cache.cache["us-east-1"].instanceTypes)
+	g.Expect(store.persist(ctx)).To(Succeed())
+	g.Expect(k8sClient.Get(ctx, key, configMap)).To(Succeed())
+	g.Expect(configMap.ResourceVersion).ToNot(Equal(resourceVersion))
+}
+
+func TestLoadInstanceTypesCacheIgnoresInvalidEntries(t *testing.T) {
+	g := NewWithT(t)
+
+	key := client.ObjectKey{Namespace: "capa-annotator-system", Name: "instance-types-cache"}
+	configMap := &corev1.ConfigMap{BinaryData: map[string][]byte{"us-east-1.json.gz": []byte("not gzip"), "other": []byte("ignored")}}
+	configMap.Namespace = key.Namespace
+	configMap.Name = key.Name
+	k8sClient := newTestReconciler(g, configMap).Client
+
+	cache := NewInstanceTypesCacheWithTTL(time.Hour).(*instanceTypesCache)
+	g.Expect((&instanceTypesCacheStore{reader: k8sClient, writer: k8sClient, key: key, cache: cache}).load(ctx)).To(Succeed())
+	g.Expect(cache.cache).To(BeEmpty())
+}
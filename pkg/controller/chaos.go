@@ -0,0 +1,217 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/outposts"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	chaosFaultDelay      = "delay"
+	chaosFaultCacheFlush = "cache-flush"
+	chaosFaultAWSFailure = "aws-failure"
+
+	// defaultChaosMaxDelay is the maximum delay injected without max-delay.
+	defaultChaosMaxDelay = 5 * time.Second
+)
+
+// errChaos is the synthetic failure of AWS calls injected by the chaos mode.
+var errChaos = errors.New("synthetic AWS failure injected by the chaos mode")
+
+// Chaos randomly injects faults into the reconciles of MachineDeployments, to soak-test the backoff, retry budget
+// and parking of the controller in staging before a rollout. It is a developer mode not meant for production.
+// The rates are probabilities between 0 and 1 per reconcile or AWS call.
+type Chaos struct {
+	// DelayRate is the rate of reconciles delayed by up to MaxDelay.
+	DelayRate float64
+	// MaxDelay is the maximum delay of a reconcile.
+	MaxDelay time.Duration
+	// CacheFlushRate is the rate of reconciles expiring all cache entries first.
+	CacheFlushRate float64
+	// AWSFailureRate is the rate of AWS calls failing with a synthetic error.
+	AWSFailureRate float64
+}
+
+// ParseChaos parses a comma-separated list of <fault>=<rate> entries, with the faults delay, cache-flush and
+// aws-failure, plus an optional max-delay=<duration>, e.g. "delay=0.1,max-delay=2s,aws-failure=0.05".
+func ParseChaos(value string) (*Chaos, error) {
+	chaos := &Chaos{MaxDelay: defaultChaosMaxDelay}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %q, expected <fault>=<rate>", entry)
+		}
+
+		if key == "max-delay" {
+			maxDelay, err := time.ParseDuration(val)
+			if err != nil || maxDelay <= 0 {
+				return nil, fmt.Errorf("max-delay %q must be a positive duration", val)
+			}
+			chaos.MaxDelay = maxDelay
+			continue
+		}
+
+		rate, err := strconv.ParseFloat(val, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("rate %q of %s must be between 0 and 1", val, key)
+		}
+		switch key {
+		case chaosFaultDelay:
+			chaos.DelayRate = rate
+		case chaosFaultCacheFlush:
+			chaos.CacheFlushRate = rate
+		case chaosFaultAWSFailure:
+			chaos.AWSFailureRate = rate
+		default:
+			return nil, fmt.Errorf("unknown fault %q, expected one of %s, %s or %s", key, chaosFaultDelay, chaosFaultCacheFlush, chaosFaultAWSFailure)
+		}
+	}
+	return chaos, nil
+}
+
+// String returns the rates of the faults.
+func (c *Chaos) String() string {
+	return fmt.Sprintf("%s=%g,max-delay=%v,%s=%g,%s=%g", chaosFaultDelay, c.DelayRate, c.MaxDelay, chaosFaultCacheFlush, c.CacheFlushRate, chaosFaultAWSFailure, c.AWSFailureRate)
+}
+
+// inject returns whether the fault is injected at the rate, counting injected faults. A nil Chaos injects nothing.
+func (c *Chaos) inject(fault string, rate float64) bool {
+	if c == nil || rate <= 0 || rand.Float64() >= rate {
+		return false
+	}
+	metrics.ChaosFaults.WithLabelValues(fault).Inc()
+	return true
+}
+
+// injectChaos delays the reconcile and flushes the caches at the rates of the chaos mode.
+func (r *Reconciler) injectChaos(ctx context.Context) {
+	if r.Chaos.inject(chaosFaultCacheFlush, r.Chaos.CacheFlushRate) {
+		klog.V(2).Info("Chaos: flushing the caches")
+		r.ExpireCaches("", nil)
+	}
+	if r.Chaos.inject(chaosFaultDelay, r.Chaos.DelayRate) {
+		delay := time.Duration(rand.Int64N(int64(r.Chaos.MaxDelay)) + 1)
+		klog.V(2).Infof("Chaos: delaying the reconcile by %v", delay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+}
+
+// WrapAWSClientBuilder returns a builder whose clients fail AWS calls at the rate of the chaos mode.
+// A nil Chaos returns the builder.
+func (c *Chaos) WrapAWSClientBuilder(builder awsclient.AwsClientBuilderFuncType) awsclient.AwsClientBuilderFuncType {
+	if c == nil {
+		return builder
+	}
+	return func(ctrlRuntimeClient client.Client, secretName, namespace, region string, regionCache awsclient.RegionCache) (awsclient.Client, error) {
+		awsClient, err := builder(ctrlRuntimeClient, secretName, namespace, region, regionCache)
+		if err != nil {
+			return nil, err
+		}
+		return &chaosAWSClient{Client: awsClient, chaos: c}, nil
+	}
+}
+
+// WrapIdentityClientBuilder is WrapAWSClientBuilder for builders of identity clients.
+func (c *Chaos) WrapIdentityClientBuilder(builder awsclient.IdentityClientBuilderFuncType) awsclient.IdentityClientBuilderFuncType {
+	if c == nil {
+		return builder
+	}
+	return func(ctrlRuntimeClient client.Client, identity awsclient.Identity, region string, regionCache awsclient.RegionCache) (awsclient.Client, error) {
+		awsClient, err := builder(ctrlRuntimeClient, identity, region, regionCache)
+		if err != nil {
+			return nil, err
+		}
+		return &chaosAWSClient{Client: awsClient, chaos: c}, nil
+	}
+}
+
+// chaosAWSClient fails the AWS calls of the controller at the rate of the chaos mode.
+type chaosAWSClient struct {
+	awsclient.Client
+	chaos *Chaos
+}
+
+func (c *chaosAWSClient) fail() bool {
+	return c.chaos.inject(chaosFaultAWSFailure, c.chaos.AWSFailureRate)
+}
+
+func (c *chaosAWSClient) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	if c.fail() {
+		return nil, errChaos
+	}
+	return c.Client.DescribeInstanceTypes(input)
+}
+
+func (c *chaosAWSClient) DescribeAvailabilityZones(input *ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	if c.fail() {
+		return nil, errChaos
+	}
+	return c.Client.DescribeAvailabilityZones(input)
+}
+
+func (c *chaosAWSClient) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	if c.fail() {
+		return nil, errChaos
+	}
+	return c.Client.DescribeImages(input)
+}
+
+func (c *chaosAWSClient) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	if c.fail() {
+		return nil, errChaos
+	}
+	return c.Client.DescribeSubnets(input)
+}
+
+func (c *chaosAWSClient) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	if c.fail() {
+		return nil, errChaos
+	}
+	return c.Client.DescribeInstances(input)
+}
+
+func (c *chaosAWSClient) GetOutpostInstanceTypes(input *outposts.GetOutpostInstanceTypesInput) (*outposts.GetOutpostInstanceTypesOutput, error) {
+	if c.fail() {
+		return nil, errChaos
+	}
+	return c.Client.GetOutpostInstanceTypes(input)
+}
+
+func (c *chaosAWSClient) GetServiceQuota(input *servicequotas.GetServiceQuotaInput) (*servicequotas.GetServiceQuotaOutput, error) {
+	if c.fail() {
+		return nil, errChaos
+	}
+	return c.Client.GetServiceQuota(input)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestParseChaos(t *testing.T) {
+	testCases := []struct {
+		name        string
+		value       string
+		expected    *Chaos
+		expectedErr string
+	}{
+		{
+			name:     "all faults",
+			value:    "delay=0.1, max-delay=2s,cache-flush=0.01,aws-failure=1",
+			expected: &Chaos{DelayRate: 0.1, MaxDelay: 2 * time.Second, CacheFlushRate: 0.01, AWSFailureRate: 1},
+		},
+		{
+			name:     "default max delay",
+			value:    "delay=0.5",
+			expected: &Chaos{DelayRate: 0.5, MaxDelay: defaultChaosMaxDelay},
+		},
+		{
+			name:        "rate out of range",
+			value:       "aws-failure=1.5",
+			expectedErr: `rate "1.5" of aws-failure must be between 0 and 1`,
+		},
+		{
+			name:        "unknown fault",
+			value:       "outage=0.1",
+			expectedErr: `unknown fault "outage", expected one of delay, cache-flush or aws-failure`,
+		},
+		{
+			name:        "missing rate",
+			value:       "delay",
+			expectedErr: `invalid entry "delay", expected <fault>=<rate>`,
+		},
+		{
+			name:        "invalid max delay",
+			value:       "max-delay=0s",
+			expectedErr: `max-delay "0s" must be a positive duration`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			chaos, err := ParseChaos(tc.value)
+			if tc.expectedErr != "" {
+				g.Expect(err).To(MatchError(tc.expectedErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(chaos).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestChaosWrapAWSClientBuilder(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	builder := func(client.Client, string, string, string, awsclient.RegionCache) (awsclient.Client, error) {
+		return fakeClient, nil
+	}
+
+	// Without the chaos mode, the builder is used as is
+	var chaos *Chaos
+	awsClient, err := chaos.WrapAWSClientBuilder(builder)(nil, "", "", "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(awsClient).To(BeIdenticalTo(fakeClient))
+
+	chaos = &Chaos{AWSFailureRate: 1}
+	awsClient, err = chaos.WrapAWSClientBuilder(builder)(nil, "", "", "us-east-1", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = awsClient.DescribeInstanceTypes(&ec2.DescribeInstanceTypesInput{})
+	g.Expect(errors.Is(err, errChaos)).To(BeTrue())
+
+	chaos.AWSFailureRate = 0
+	_, err = awsClient.DescribeInstanceTypes(&ec2.DescribeInstanceTypesInput{})
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestInjectChaosFlushesCaches(t *testing.T) {
+	g := NewWithT(t)
+
+	awsClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := &Reconciler{
+		InstanceTypesCache: NewAliasedInstanceTypesCache(NewInstanceTypesCache(), nil),
+		Chaos:              &Chaos{CacheFlushRate: 1, MaxDelay: defaultChaosMaxDelay},
+	}
+	_, err = r.InstanceTypesCache.GetInstanceType(awsClient, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r.injectChaos(ctx)
+	info, err := r.InstanceTypesCache.GetInstanceType(awsClient, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(info.Source).To(Equal(DataSourceAPI))
+}
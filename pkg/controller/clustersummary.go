@@ -0,0 +1,199 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// clusterSummaryKey is the annotation of the Cluster holding its ClusterSummary as JSON.
+	clusterSummaryKey = "capa-annotator/capacity-summary"
+
+	// The node group size annotations of the cluster-autoscaler Cluster API provider.
+	autoscalerMinSizeKey = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-min-size"
+	autoscalerMaxSizeKey = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-max-size"
+)
+
+// ClusterSummary is the capacity of the MachineDeployments of a Cluster, written to the Cluster so that fleet
+// dashboards can read one object per cluster. The minimum and maximum capacity are the capacity of the annotated
+// MachineDeployments scaled to the autoscaler min and max size annotations, or to their replicas without them.
+type ClusterSummary struct {
+	MachineDeployments int   `json:"machineDeployments"`
+	Annotated          int   `json:"annotated"`
+	MinVCPU            int64 `json:"minVCPU"`
+	MaxVCPU            int64 `json:"maxVCPU"`
+	MinMemoryMb        int64 `json:"minMemoryMb"`
+	MaxMemoryMb        int64 `json:"maxMemoryMb"`
+	MinGPU             int64 `json:"minGPU"`
+	MaxGPU             int64 `json:"maxGPU"`
+}
+
+// ClusterSummaryReconciler writes the ClusterSummary of the MachineDeployments of Clusters to the Clusters.
+// It shares the configuration of the MachineDeployment Reconciler to read the capacity annotations.
+type ClusterSummaryReconciler struct {
+	Reconciler *Reconciler
+}
+
+// SetupWithManager creates a new controller for a manager.
+func (s *ClusterSummaryReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	_, err := ctrl.NewControllerManagedBy(mgr).
+		Named("clustersummary").
+		For(&clusterv1.Cluster{}).
+		// The summary changes whenever the annotations or the size of a MachineDeployment change
+		Watches(&clusterv1.MachineDeployment{}, handler.EnqueueRequestsFromMapFunc(clusterOfMachineDeployment)).
+		WithOptions(options).
+		Build(s)
+
+	if err != nil {
+		return fmt.Errorf("failed setting up with a controller manager: %w", err)
+	}
+	return nil
+}
+
+// clusterOfMachineDeployment maps a MachineDeployment to its Cluster.
+func clusterOfMachineDeployment(_ context.Context, obj client.Object) []reconcile.Request {
+	machineDeployment, ok := obj.(*clusterv1.MachineDeployment)
+	if !ok || machineDeployment.Spec.ClusterName == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: machineDeployment.Namespace, Name: machineDeployment.Spec.ClusterName}}}
+}
+
+// Reconcile implements controller runtime Reconciler interface.
+func (s *ClusterSummaryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r := s.Reconciler
+
+	cluster := &clusterv1.Cluster{}
+	if err := r.Client.Get(ctx, req.NamespacedName, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if !cluster.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := r.Client.List(ctx, machineDeployments, client.InNamespace(cluster.Namespace)); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to list MachineDeployments: %w", err)
+	}
+	summary := r.buildClusterSummary(cluster, machineDeployments.Items)
+
+	value, err := json.Marshal(summary)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if cluster.Annotations[clusterSummaryKey] == string(value) {
+		return ctrl.Result{}, nil
+	}
+
+	original := cluster.DeepCopy()
+	if cluster.Annotations == nil {
+		cluster.Annotations = map[string]string{}
+	}
+	cluster.Annotations[clusterSummaryKey] = string(value)
+	if err := r.Client.Patch(ctx, cluster, client.MergeFrom(original)); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to patch Cluster: %w", err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// buildClusterSummary sums up the capacity annotations of the MachineDeployments of the Cluster.
+func (r *Reconciler) buildClusterSummary(cluster *clusterv1.Cluster, machineDeployments []clusterv1.MachineDeployment) ClusterSummary {
+	summary := ClusterSummary{}
+	for i := range machineDeployments {
+		machineDeployment := &machineDeployments[i]
+		if machineDeployment.Spec.ClusterName != cluster.Name || !machineDeployment.DeletionTimestamp.IsZero() {
+			continue
+		}
+		summary.MachineDeployments++
+
+		vcpu, memoryMb, gpu, ok := r.annotatedCapacity(machineDeployment.Annotations)
+		if !ok {
+			continue
+		}
+		summary.Annotated++
+
+		minSize, maxSize := nodeGroupSize(machineDeployment)
+		summary.MinVCPU += vcpu * minSize
+		summary.MaxVCPU += vcpu * maxSize
+		summary.MinMemoryMb += memoryMb * minSize
+		summary.MaxMemoryMb += memoryMb * maxSize
+		summary.MinGPU += gpu * minSize
+		summary.MaxGPU += gpu * maxSize
+	}
+	return summary
+}
+
+// annotatedCapacity parses the capacity annotations of the AnnotationScheme. It returns false if the vCPU or
+// memory annotation is missing or invalid.
+func (r *Reconciler) annotatedCapacity(annotations map[string]string) (int64, int64, int64, bool) {
+	cpu, memory, gpu := r.AnnotationScheme.keys()
+
+	vcpu, err := strconv.ParseInt(annotations[cpu], 10, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	memoryMb, err := parseMemoryMiB(annotations[memory], r.MemoryUnit)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	// A missing GPU annotation, e.g. with OmitZeroGPU, means no GPUs
+	gpus, _ := strconv.ParseInt(annotations[gpu], 10, 64)
+	return vcpu, memoryMb, gpus, true
+}
+
+// parseMemoryMiB parses a memory annotation. Plain numbers are in the given unit, quantities carry their unit.
+func parseMemoryMiB(value string, unit MemoryUnit) (int64, error) {
+	if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if unit == MemoryUnitBytes {
+			return number / (1024 * 1024), nil
+		}
+		return number, nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, err
+	}
+	return quantity.Value() / (1024 * 1024), nil
+}
+
+// nodeGroupSize returns the autoscaler min and max size of the MachineDeployment. Without valid size annotations
+// the MachineDeployment is not autoscaled and both are its replicas.
+func nodeGroupSize(machineDeployment *clusterv1.MachineDeployment) (int64, int64) {
+	replicas := int64(1)
+	if machineDeployment.Spec.Replicas != nil {
+		replicas = int64(*machineDeployment.Spec.Replicas)
+	}
+	minSize, minErr := strconv.ParseInt(machineDeployment.Annotations[autoscalerMinSizeKey], 10, 64)
+	maxSize, maxErr := strconv.ParseInt(machineDeployment.Annotations[autoscalerMaxSizeKey], 10, 64)
+	if minErr != nil || maxErr != nil || minSize < 0 || maxSize < minSize {
+		return replicas, replicas
+	}
+	return minSize, maxSize
+}
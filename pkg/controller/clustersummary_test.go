@@ -0,0 +1,132 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestBuildClusterSummary(t *testing.T) {
+	testCases := []struct {
+		name            string
+		reconciler      *Reconciler
+		annotations     []map[string]string
+		expectedSummary ClusterSummary
+	}{
+		{
+			name:       "autoscaled and fixed size MachineDeployments",
+			reconciler: &Reconciler{},
+			annotations: []map[string]string{
+				{cpuKey: "8", memoryKey: "16384", gpuKey: "0", autoscalerMinSizeKey: "1", autoscalerMaxSizeKey: "10"},
+				{cpuKey: "16", memoryKey: "65536", gpuKey: "2"},
+				{},
+			},
+			expectedSummary: ClusterSummary{
+				MachineDeployments: 3,
+				Annotated:          2,
+				MinVCPU:            8 + 2*16,
+				MaxVCPU:            80 + 2*16,
+				MinMemoryMb:        16384 + 2*65536,
+				MaxMemoryMb:        163840 + 2*65536,
+				MinGPU:             4,
+				MaxGPU:             4,
+			},
+		},
+		{
+			name:       "cluster-autoscaler scheme with memory quantities",
+			reconciler: &Reconciler{AnnotationScheme: AnnotationSchemeClusterAutoscaler},
+			annotations: []map[string]string{
+				{caCPUKey: "8", caMemoryKey: "16384Mi", autoscalerMinSizeKey: "0", autoscalerMaxSizeKey: "3"},
+			},
+			expectedSummary: ClusterSummary{
+				MachineDeployments: 1,
+				Annotated:          1,
+				MaxVCPU:            24,
+				MaxMemoryMb:        49152,
+			},
+		},
+		{
+			name:       "invalid size annotations count the replicas",
+			reconciler: &Reconciler{MemoryUnit: MemoryUnitBytes},
+			annotations: []map[string]string{
+				{cpuKey: "8", memoryKey: "17179869184", autoscalerMinSizeKey: "5", autoscalerMaxSizeKey: "1"},
+			},
+			expectedSummary: ClusterSummary{
+				MachineDeployments: 1,
+				Annotated:          1,
+				MinVCPU:            16,
+				MaxVCPU:            16,
+				MinMemoryMb:        32768,
+				MaxMemoryMb:        32768,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cluster := &clusterv1.Cluster{}
+			cluster.Name = "test-cluster"
+			machineDeployments := []clusterv1.MachineDeployment{}
+			for _, annotations := range tc.annotations {
+				machineDeployment := clusterv1.MachineDeployment{}
+				machineDeployment.Annotations = annotations
+				machineDeployment.Spec.ClusterName = cluster.Name
+				machineDeployment.Spec.Replicas = ptr.To(int32(2))
+				machineDeployments = append(machineDeployments, machineDeployment)
+			}
+			// MachineDeployments of other clusters are not counted
+			other := clusterv1.MachineDeployment{}
+			other.Annotations = map[string]string{cpuKey: "8", memoryKey: "16384"}
+			other.Spec.ClusterName = "other-cluster"
+			machineDeployments = append(machineDeployments, other)
+
+			g.Expect(tc.reconciler.buildClusterSummary(cluster, machineDeployments)).To(Equal(tc.expectedSummary))
+		})
+	}
+}
+
+func TestClusterSummaryReconcile(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", map[string]string{
+		cpuKey:               "8",
+		memoryKey:            "16384",
+		autoscalerMinSizeKey: "0",
+		autoscalerMaxSizeKey: "5",
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "test-md"
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	summaryReconciler := &ClusterSummaryReconciler{Reconciler: r}
+
+	_, err = summaryReconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cluster)})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(cluster), cluster)).To(Succeed())
+	summary := ClusterSummary{}
+	g.Expect(json.Unmarshal([]byte(cluster.Annotations[clusterSummaryKey]), &summary)).To(Succeed())
+	g.Expect(summary).To(Equal(ClusterSummary{MachineDeployments: 1, Annotated: 1, MaxVCPU: 40, MaxMemoryMb: 81920}))
+
+	g.Expect(clusterOfMachineDeployment(ctx, machineDeployment)).To(ConsistOf(ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cluster)}))
+}
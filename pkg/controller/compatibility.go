@@ -0,0 +1,150 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+var (
+	// MachineDeploymentCRDs are the kinds watched by the MachineDeployment controller, in the versions it supports.
+	MachineDeploymentCRDs = []schema.GroupVersionKind{
+		clusterv1.GroupVersion.WithKind("MachineDeployment"),
+		infrav1.GroupVersion.WithKind("AWSMachineTemplate"),
+	}
+	// MachineSetCRDs are the kinds watched by the MachineSet controller.
+	MachineSetCRDs = []schema.GroupVersionKind{clusterv1.GroupVersion.WithKind("MachineSet")}
+	// ClusterSummaryCRDs are the kinds watched by the Cluster summary controller.
+	ClusterSummaryCRDs = []schema.GroupVersionKind{
+		clusterv1.GroupVersion.WithKind("Cluster"),
+		clusterv1.GroupVersion.WithKind("MachineDeployment"),
+	}
+	// WorkloadClusterCRDs are the kinds read on the management cluster to connect to the workload clusters.
+	WorkloadClusterCRDs = []schema.GroupVersionKind{clusterv1.GroupVersion.WithKind("Cluster")}
+)
+
+// CRDCompatibility is the result of checking that the API server serves the kinds the controllers watch, in the
+// versions the controller supports. Management clusters upgrading Cluster API or CAPA ahead of the controller
+// may stop serving them.
+type CRDCompatibility struct {
+	// Served are the versions served of the groups of the checked kinds, by group.
+	Served map[string][]string
+	// Missing are the checked kinds that are not served.
+	Missing []schema.GroupVersionKind
+}
+
+// CheckCRDCompatibility checks with discovery that the kinds are served in their versions, and records the
+// result in the crd_compatible metric.
+func CheckCRDCompatibility(discoveryClient discovery.DiscoveryInterface, kinds []schema.GroupVersionKind) (*CRDCompatibility, error) {
+	compatibility := &CRDCompatibility{Served: map[string][]string{}}
+
+	groups, err := discoveryClient.ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover the API groups: %w", err)
+	}
+	for _, group := range groups.Groups {
+		for _, version := range group.Versions {
+			compatibility.Served[group.Name] = append(compatibility.Served[group.Name], version.Version)
+		}
+	}
+
+	served := map[schema.GroupVersion]map[string]bool{}
+	for _, gvk := range kinds {
+		gv := gvk.GroupVersion()
+		if _, ok := served[gv]; !ok {
+			served[gv] = map[string]bool{}
+			resources, err := discoveryClient.ServerResourcesForGroupVersion(gv.String())
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to discover the resources of %s: %w", gv, err)
+			}
+			if resources != nil {
+				for _, resource := range resources.APIResources {
+					served[gv][resource.Kind] = true
+				}
+			}
+		}
+
+		if served[gv][gvk.Kind] {
+			metrics.CRDCompatible.WithLabelValues(gv.String(), gvk.Kind).Set(1)
+			continue
+		}
+		metrics.CRDCompatible.WithLabelValues(gv.String(), gvk.Kind).Set(0)
+		// Kinds watched by several controllers are listed once
+		if compatibility.serves(gvk) {
+			compatibility.Missing = append(compatibility.Missing, gvk)
+		}
+	}
+	return compatibility, nil
+}
+
+// Compatible returns whether all checked kinds are served.
+func (c *CRDCompatibility) Compatible() bool {
+	return len(c.Missing) == 0
+}
+
+// Serves returns whether none of the kinds are missing. Kinds that were not checked are assumed to be served.
+func (c *CRDCompatibility) Serves(kinds ...schema.GroupVersionKind) bool {
+	for _, gvk := range kinds {
+		if !c.serves(gvk) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *CRDCompatibility) serves(gvk schema.GroupVersionKind) bool {
+	for _, missing := range c.Missing {
+		if missing == gvk {
+			return false
+		}
+	}
+	return true
+}
+
+// String describes the missing kinds and the versions served of their groups.
+func (c *CRDCompatibility) String() string {
+	if c.Compatible() {
+		return "all CRDs are served in supported versions"
+	}
+
+	missing := []string{}
+	for _, gvk := range c.Missing {
+		served := "none"
+		if versions := c.Served[gvk.Group]; len(versions) > 0 {
+			served = strings.Join(versions, ", ")
+		}
+		missing = append(missing, fmt.Sprintf("%s %s (served versions of %s: %s)", gvk.Kind, gvk.GroupVersion(), gvk.Group, served))
+	}
+	sort.Strings(missing)
+	return "CRDs not served in supported versions: " + strings.Join(missing, "; ")
+}
+
+// Check fails if kinds are missing, so that it can be used as a readiness check.
+func (c *CRDCompatibility) Check(*http.Request) error {
+	if c.Compatible() {
+		return nil
+	}
+	return errors.New(c.String())
+}
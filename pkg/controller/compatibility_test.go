@@ -0,0 +1,81 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestCheckCRDCompatibility(t *testing.T) {
+	g := NewWithT(t)
+
+	// The management cluster was upgraded to a CAPA version that no longer serves v1beta2
+	discoveryClient := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{
+		{
+			GroupVersion: "cluster.x-k8s.io/v1beta1",
+			APIResources: []metav1.APIResource{{Name: "machinedeployments", Kind: "MachineDeployment"}, {Name: "clusters", Kind: "Cluster"}},
+		},
+		{
+			GroupVersion: "infrastructure.cluster.x-k8s.io/v1beta3",
+			APIResources: []metav1.APIResource{{Name: "awsmachinetemplates", Kind: "AWSMachineTemplate"}},
+		},
+	}}}
+
+	kinds := append(append([]schema.GroupVersionKind{}, MachineDeploymentCRDs...), ClusterSummaryCRDs...)
+	compatibility, err := CheckCRDCompatibility(discoveryClient, kinds)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(compatibility.Compatible()).To(BeFalse())
+	g.Expect(compatibility.Missing).To(ConsistOf(schema.GroupVersionKind{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta2", Kind: "AWSMachineTemplate"}))
+	g.Expect(compatibility.String()).To(Equal("CRDs not served in supported versions: " +
+		"AWSMachineTemplate infrastructure.cluster.x-k8s.io/v1beta2 (served versions of infrastructure.cluster.x-k8s.io: v1beta3)"))
+
+	// Controllers that do not watch the missing kinds can still be started
+	g.Expect(compatibility.Serves(ClusterSummaryCRDs...)).To(BeTrue())
+	g.Expect(compatibility.Serves(MachineDeploymentCRDs...)).To(BeFalse())
+
+	g.Expect(testutil.ToFloat64(metrics.CRDCompatible.WithLabelValues("infrastructure.cluster.x-k8s.io/v1beta2", "AWSMachineTemplate"))).To(Equal(0.0))
+	g.Expect(testutil.ToFloat64(metrics.CRDCompatible.WithLabelValues("cluster.x-k8s.io/v1beta1", "MachineDeployment"))).To(Equal(1.0))
+
+	g.Expect(compatibility.Check(httptest.NewRequest("GET", "/readyz", nil))).To(MatchError(compatibility.String()))
+}
+
+func TestCheckCRDCompatibilityCompatible(t *testing.T) {
+	g := NewWithT(t)
+
+	discoveryClient := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{
+		{
+			GroupVersion: "cluster.x-k8s.io/v1beta1",
+			APIResources: []metav1.APIResource{{Name: "machinedeployments", Kind: "MachineDeployment"}},
+		},
+		{
+			GroupVersion: "infrastructure.cluster.x-k8s.io/v1beta2",
+			APIResources: []metav1.APIResource{{Name: "awsmachinetemplates", Kind: "AWSMachineTemplate"}},
+		},
+	}}}
+
+	compatibility, err := CheckCRDCompatibility(discoveryClient, MachineDeploymentCRDs)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(compatibility.Compatible()).To(BeTrue())
+	g.Expect(compatibility.Served).To(HaveKeyWithValue("infrastructure.cluster.x-k8s.io", []string{"v1beta2"}))
+	g.Expect(compatibility.Check(httptest.NewRequest("GET", "/readyz", nil))).To(Succeed())
+}
@@ -0,0 +1,297 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conformance verifies that implementations of the extension points of the controller behave like the
+// upstream implementations. Forks and extensions call the functions of this package from their own tests with
+// their implementations, e.g.
+//
+//	func TestTemplateResolver(t *testing.T) {
+//		conformance.TemplateResolver(t, myResolver)
+//	}
+package conformance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	"github.com/jhjaggars/capa-annotator/pkg/controller"
+	utils "github.com/jhjaggars/capa-annotator/pkg/utils"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	namespace         = "conformance"
+	templateNamespace = "conformance-templates"
+	region            = "eu-central-1"
+)
+
+// TemplateResolver verifies that the resolver fetches the AWSMachineTemplate of the infrastructureRef of the
+// MachineDeployment, defaulting to the namespace of the MachineDeployment, and rejects invalid references.
+func TemplateResolver(t *testing.T, resolver controller.TemplateResolver) {
+	testCases := []struct {
+		name              string
+		ref               corev1.ObjectReference
+		expectedNamespace string
+		expectErr         bool
+	}{
+		{
+			name:              "same namespace",
+			ref:               corev1.ObjectReference{Kind: "AWSMachineTemplate", Name: "template"},
+			expectedNamespace: namespace,
+		},
+		{
+			name:              "explicit namespace",
+			ref:               corev1.ObjectReference{Kind: "AWSMachineTemplate", Name: "template", Namespace: templateNamespace},
+			expectedNamespace: templateNamespace,
+		},
+		{
+			name:      "empty name",
+			ref:       corev1.ObjectReference{Kind: "AWSMachineTemplate"},
+			expectErr: true,
+		},
+		{
+			name:      "other kind",
+			ref:       corev1.ObjectReference{Kind: "DockerMachineTemplate", Name: "template"},
+			expectErr: true,
+		},
+		{
+			name:      "missing template",
+			ref:       corev1.ObjectReference{Kind: "AWSMachineTemplate", Name: "missing"},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			c := newClient(g, newAWSMachineTemplate(namespace, "m5.large"), newAWSMachineTemplate(templateNamespace, "m5.xlarge"))
+			machineDeployment := newMachineDeployment()
+			machineDeployment.Spec.Template.Spec.InfrastructureRef = tc.ref
+
+			template, err := resolver.ResolveAWSMachineTemplate(context.Background(), c, machineDeployment)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(template.Namespace).To(Equal(tc.expectedNamespace))
+			g.Expect(template.Name).To(Equal(tc.ref.Name))
+		})
+	}
+}
+
+// RegionResolver verifies that the resolver takes the region from the AWSCluster of the Cluster of the
+// MachineDeployment and falls back to the region annotation of the MachineDeployment.
+func RegionResolver(t *testing.T, resolver controller.RegionResolver) {
+	testCases := []struct {
+		name           string
+		awsClusterSpec *infrav1.AWSClusterSpec
+		annotation     string
+		expectedRegion string
+		expectErr      bool
+	}{
+		{
+			name:           "from AWSCluster",
+			awsClusterSpec: &infrav1.AWSClusterSpec{Region: region},
+			annotation:     "us-east-1",
+			expectedRegion: region,
+		},
+		{
+			name:           "AWSCluster without region",
+			awsClusterSpec: &infrav1.AWSClusterSpec{},
+			annotation:     "us-east-1",
+			expectedRegion: "us-east-1",
+		},
+		{
+			name:           "without AWSCluster",
+			annotation:     "us-east-1",
+			expectedRegion: "us-east-1",
+		},
+		{
+			name:      "without AWSCluster and annotation",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: namespace},
+				Spec: clusterv1.ClusterSpec{
+					InfrastructureRef: &corev1.ObjectReference{Kind: "AWSCluster", Name: "cluster"},
+				},
+			}
+			objs := []client.Object{cluster}
+			if tc.awsClusterSpec != nil {
+				objs = append(objs, &infrav1.AWSCluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: namespace},
+					Spec:       *tc.awsClusterSpec,
+				})
+			}
+			c := newClient(g, objs...)
+
+			machineDeployment := newMachineDeployment()
+			if tc.annotation != "" {
+				machineDeployment.Annotations = map[string]string{utils.RegionAnnotation: tc.annotation}
+			}
+
+			resolved, err := resolver.ResolveRegion(context.Background(), c, machineDeployment)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(resolved).To(Equal(tc.expectedRegion))
+		})
+	}
+}
+
+// InstanceTypesCache verifies that caches created by newCache return the capacity reported by the EC2 API
+// of the fake AWS client, serve repeated lookups from cache and fail for unknown instance types.
+func InstanceTypesCache(t *testing.T, newCache func() controller.InstanceTypesCache) {
+	g := NewWithT(t)
+
+	awsClient := newAWSClient(g)
+	cache := newCache()
+
+	instanceType, err := cache.GetInstanceType(awsClient, region, "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(instanceType.InstanceType).To(Equal("a1.2xlarge"))
+	g.Expect(instanceType.VCPU).To(BeEquivalentTo(8))
+	g.Expect(instanceType.MemoryMb).To(BeEquivalentTo(16384))
+	g.Expect(instanceType.GPU).To(BeEquivalentTo(0))
+	g.Expect(instanceType.CPUArchitecture).To(Equal(controller.ArchitectureAmd64))
+	g.Expect(instanceType.Source).To(Equal(controller.DataSourceAPI))
+	g.Expect(instanceType.FetchedAt).ToNot(BeZero())
+
+	instanceType, err = cache.GetInstanceType(awsClient, region, "p2.16xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(instanceType.GPU).To(BeEquivalentTo(16))
+	g.Expect(instanceType.Source).To(Equal(controller.DataSourceCache))
+
+	_, err = cache.GetInstanceType(awsClient, region, "unknown.large")
+	g.Expect(err).To(HaveOccurred())
+}
+
+// AvailabilityZonesCache verifies that caches created by newCache return the zone IDs reported by the EC2 API
+// of the fake AWS client and an empty ID for unknown zones.
+func AvailabilityZonesCache(t *testing.T, newCache func() controller.AvailabilityZonesCache) {
+	g := NewWithT(t)
+
+	awsClient := newAWSClient(g)
+	cache := newCache()
+
+	zoneID, err := cache.GetZoneID(awsClient, region, "us-east-1a")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(zoneID).To(Equal("use1-az6"))
+
+	zoneID, err = cache.GetZoneID(awsClient, region, "us-east-1z")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(zoneID).To(BeEmpty())
+}
+
+// AuditSink verifies that the sink delivers the records it is sent completely and in order. received returns
+// the records delivered so far; delivery may be asynchronous, so it is polled for a few seconds.
+func AuditSink(t *testing.T, sink controller.AuditSink, received func() []controller.AuditRecord) {
+	g := NewWithT(t)
+
+	old, changed := "1", "2"
+	sent := []controller.AuditRecord{
+		{
+			SchemaVersion:     controller.AuditSchemaVersion,
+			Time:              time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			Type:              controller.AuditTypeAnnotationsChanged,
+			MachineDeployment: namespace + "/md",
+			Changes: map[string]controller.AnnotationChange{
+				"added":   {New: &changed},
+				"changed": {Old: &old, New: &changed},
+				"removed": {Old: &old},
+			},
+		},
+		{
+			SchemaVersion:     controller.AuditSchemaVersion,
+			Time:              time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC),
+			Type:              controller.AuditTypeFailure,
+			MachineDeployment: namespace + "/md",
+			Reason:            "FailedUpdate",
+			Message:           "Failed to resolve AWSMachineTemplate",
+		},
+	}
+	for _, auditRecord := range sent {
+		sink.Send(auditRecord)
+	}
+
+	g.Eventually(received, 5*time.Second).Should(HaveLen(len(sent)))
+	g.Expect(received()).To(Equal(sent))
+}
+
+// newClient returns a fake Kubernetes client holding the objects.
+func newClient(g Gomega, objs ...client.Object) client.Client {
+	testScheme := runtime.NewScheme()
+	g.Expect(scheme.AddToScheme(testScheme)).To(Succeed())
+	g.Expect(clusterv1.AddToScheme(testScheme)).To(Succeed())
+	g.Expect(infrav1.AddToScheme(testScheme)).To(Succeed())
+	return fake.NewClientBuilder().WithScheme(testScheme).WithObjects(objs...).Build()
+}
+
+// newAWSClient returns the fake AWS client.
+func newAWSClient(g Gomega) awsclient.Client {
+	awsClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	return awsClient
+}
+
+// newAWSMachineTemplate returns an AWSMachineTemplate named "template" in the namespace.
+func newAWSMachineTemplate(namespace, instanceType string) *infrav1.AWSMachineTemplate {
+	return &infrav1.AWSMachineTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "template", Namespace: namespace},
+		Spec: infrav1.AWSMachineTemplateSpec{
+			Template: infrav1.AWSMachineTemplateResource{
+				Spec: infrav1.AWSMachineSpec{InstanceType: instanceType},
+			},
+		},
+	}
+}
+
+// newMachineDeployment returns a MachineDeployment of the Cluster "cluster".
+func newMachineDeployment() *clusterv1.MachineDeployment {
+	return &clusterv1.MachineDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "md", Namespace: namespace},
+		Spec: clusterv1.MachineDeploymentSpec{
+			ClusterName: "cluster",
+			Template: clusterv1.MachineTemplateSpec{
+				Spec: clusterv1.MachineSpec{
+					ClusterName: "cluster",
+					InfrastructureRef: corev1.ObjectReference{
+						Kind: "AWSMachineTemplate",
+						Name: "template",
+					},
+				},
+			},
+		},
+	}
+}
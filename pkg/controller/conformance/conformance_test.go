@@ -0,0 +1,105 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/jhjaggars/capa-annotator/pkg/controller"
+)
+
+// The upstream implementations must pass the conformance suite themselves.
+
+func TestDefaultTemplateResolver(t *testing.T) {
+	TemplateResolver(t, controller.DefaultTemplateResolver)
+}
+
+func TestDefaultRegionResolver(t *testing.T) {
+	RegionResolver(t, controller.DefaultRegionResolver)
+}
+
+func TestInstanceTypesCache(t *testing.T) {
+	InstanceTypesCache(t, controller.NewInstanceTypesCache)
+}
+
+func TestAliasedInstanceTypesCache(t *testing.T) {
+	InstanceTypesCache(t, func() controller.InstanceTypesCache {
+		return controller.NewAliasedInstanceTypesCache(controller.NewInstanceTypesCache(), nil)
+	})
+}
+
+func TestAvailabilityZonesCache(t *testing.T) {
+	AvailabilityZonesCache(t, controller.NewAvailabilityZonesCache)
+}
+
+// syncBuffer is a buffer safe for concurrent use.
+type syncBuffer struct {
+	buffer bytes.Buffer
+	mutex  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+// records decodes the JSON lines written so far.
+func (b *syncBuffer) records() []controller.AuditRecord {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	records := []controller.AuditRecord{}
+	scanner := bufio.NewScanner(bytes.NewReader(b.buffer.Bytes()))
+	for scanner.Scan() {
+		auditRecord := controller.AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &auditRecord); err == nil {
+			records = append(records, auditRecord)
+		}
+	}
+	return records
+}
+
+func TestWriterAuditSink(t *testing.T) {
+	buffer := &syncBuffer{}
+	AuditSink(t, controller.NewWriterAuditSink(buffer), buffer.records)
+}
+
+func TestWebhookAuditSink(t *testing.T) {
+	buffer := &syncBuffer{}
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+		auditRecord := controller.AuditRecord{}
+		if err := json.NewDecoder(req.Body).Decode(&auditRecord); err == nil {
+			data, _ := json.Marshal(auditRecord)
+			_, _ = buffer.Write(append(data, '\n'))
+		}
+	}))
+	defer server.Close()
+
+	sink := controller.NewWebhookAuditSink(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = sink.Start(ctx)
+	}()
+
+	AuditSink(t, sink, buffer.records)
+}
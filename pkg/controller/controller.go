@@ -2,23 +2,35 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
 	utils "github.com/jhjaggars/capa-annotator/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlbuilder "sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
@@ -30,6 +42,10 @@ const (
 	gpuKey      = "machine.openshift.io/GPU"
 	labelsKey   = "capacity.cluster-autoscaler.kubernetes.io/labels"
 	archLabelKey = "kubernetes.io/arch"
+
+	// managedKeysKey records the annotation keys written by the controller, so that they can be
+	// removed again when the controller is uninstalled.
+	managedKeysKey = "capa-annotator/managed-keys"
 )
 
 // Reconciler reconciles MachineDeployments.
@@ -39,73 +55,589 @@ type Reconciler struct {
 	AwsClientBuilder   awsclient.AwsClientBuilderFuncType
 	RegionCache        awsclient.RegionCache
 	InstanceTypesCache InstanceTypesCache
+	// TemplateResolver resolves the AWSMachineTemplate of a MachineDeployment. Defaults to DefaultTemplateResolver.
+	TemplateResolver TemplateResolver
+	// RegionResolver resolves the AWS region of a MachineDeployment. Defaults to DefaultRegionResolver.
+	RegionResolver RegionResolver
+	// Providers resolve the capacity of MachineDeployments whose infrastructure template is not an AWSMachineTemplate.
+	Providers []Provider
+	// AvailabilityZonesCache resolves the zone IDs of MachineDeployments pinned to a single failure domain.
+	AvailabilityZonesCache AvailabilityZonesCache
+
+	// MemoryUnit is the unit of the memory annotation. Defaults to MiB.
+	MemoryUnit MemoryUnit
+	// AdditionalMemoryKey is an optional second annotation key receiving the memory in AdditionalMemoryUnit,
+	// e.g. to serve consumers expecting a different unit during a transition.
+	AdditionalMemoryKey string
+	// AdditionalMemoryUnit is the unit of the AdditionalMemoryKey annotation.
+	AdditionalMemoryUnit MemoryUnit
+	// AnnotationScheme is the set of capacity annotation keys written. Defaults to the OpenShift scheme.
+	AnnotationScheme AnnotationScheme
+	// AdditionalAnnotationScheme is an optional scheme written in addition to the AnnotationScheme, e.g. to
+	// serve both the OpenShift and the upstream cluster-autoscaler.
+	AdditionalAnnotationScheme AnnotationScheme
+	// MigrateFromAnnotationScheme is the scheme being migrated from. Both schemes are written for
+	// MigrationWindow, afterwards the keys of the old scheme are removed.
+	MigrateFromAnnotationScheme AnnotationScheme
+	// MigrationWindow is the duration both annotation schemes are written while migrating.
+	MigrationWindow time.Duration
+	// OmitZeroGPU omits the GPU annotation for instance types without GPUs instead of writing "0".
+	OmitZeroGPU bool
+	// MaxPodsMode is the calculation of the maxPods annotation. Defaults to MaxPodsModeNone, not writing it.
+	MaxPodsMode MaxPodsMode
+	// BranchInterfaceLimits are the maximum numbers of branch interfaces by instance type, used by
+	// MaxPodsModeENITrunking. The EC2 API does not expose them.
+	BranchInterfaceLimits map[string]int64
+	// VCPUCorrections are the numbers of vCPUs by instance type written instead of the vCPUs reported by the
+	// EC2 API, for instance types whose nodes report a different number to the kubelet.
+	VCPUCorrections map[string]int64
+	// EphemeralStorageMode is the source of the ephemeral-disk annotation. Defaults to EphemeralStorageModeNone,
+	// not writing it.
+	EphemeralStorageMode EphemeralStorageMode
+	// ValidateAMIArchitecture cross-checks the architecture of the AMI of the AWSMachineTemplate against the
+	// architecture of the instance type and emits a warning event on mismatches, which otherwise only surface
+	// as failed instance launches.
+	ValidateAMIArchitecture bool
+	// ArchConflictPolicy is the handling of a kubernetes.io/arch node label of MachineDeployments conflicting with
+	// the architecture of their instance type. Defaults to ArchConflictPolicyAWS.
+	ArchConflictPolicy ArchConflictPolicy
+	// TaintsFromBootstrapTemplate writes the taints of the node registration of the KubeadmConfigTemplate of
+	// MachineDeployments to the taints annotation.
+	TaintsFromBootstrapTemplate bool
+	// TaintsSourceAnnotation is an optional annotation of MachineDeployments whose taints are written to the taints
+	// annotation, taking precedence over the taints of the KubeadmConfigTemplate.
+	TaintsSourceAnnotation string
+	// AcceleratorLabel adds the accelerator label to the labels annotation of instance types with GPUs, so that
+	// workloads selecting GPU nodes by the label can trigger a scale from zero.
+	AcceleratorLabel bool
+	// AcceleratorName is the value of the accelerator label. Defaults to the GPU manufacturer and name of the
+	// instance type, e.g. nvidia-t4.
+	AcceleratorName string
+	// CustomAnnotations are additional annotations rendered from Go templates over the capacity of the instance type.
+	CustomAnnotations []CustomAnnotation
+	// CapacityPostProcessors adjust the capacity of the instance type in order before it is written.
+	CapacityPostProcessors []CapacityPostProcessor
+
+	// ParkedReconcileInterval is the reduced reconcile interval for parked MachineDeployments, i.e. MachineDeployments
+	// with zero replicas whose spec has not changed for ParkedAfter. Zero disables the parked tier.
+	ParkedReconcileInterval time.Duration
+	// ParkedAfter is the duration without spec changes after which a MachineDeployment with zero replicas is parked.
+	ParkedAfter time.Duration
+
+	// ReannotationInterval is the interval at which MachineDeployments annotated by another controller version
+	// are reannotated after an upgrade. Zero disables the reannotation, they are updated with the next resyncs.
+	ReannotationInterval time.Duration
+
+	// PeriodicReannotationInterval is the interval at which successfully annotated MachineDeployments are requeued,
+	// with a 10% jitter, to pick up corrections of the instance type data and region changes independently of the
+	// SyncPeriod of the manager. Zero disables the periodic reannotation.
+	PeriodicReannotationInterval time.Duration
+
+	// NewInstanceTypePollInterval is the interval at which the instance types of regions with MachineDeployments
+	// referencing unknown instance types are fetched, to reconcile them as soon as the instance types become
+	// available. Zero disables the polling, they are reconciled with the next resyncs.
+	NewInstanceTypePollInterval time.Duration
+
+	// UnknownInstanceTypeRetryInterval is the interval at which MachineDeployments referencing instance types unknown
+	// in their region are retried, fetching the instance type again from the EC2 API. A change of the template
+	// clears the negative result. Zero disables the retries, they are reconciled with the next resyncs.
+	UnknownInstanceTypeRetryInterval time.Duration
+
+	// InstanceTypesRefreshAhead is the duration before their expiry at which the cached instance types of regions
+	// are refreshed in the background, so that reconciles are served from the cache. It must be shorter than the
+	// TTL of the instance types cache. Zero disables the background refresh, regions are then refreshed by the
+	// first lookup after their expiry.
+	InstanceTypesRefreshAhead time.Duration
+
+	// InstanceTypesCacheConfigMap optionally names the ConfigMap the instance types cache is persisted to, so that
+	// the regions are not all fetched again after a restart. The ConfigMap is loaded at setup and written by the
+	// leader.
+	InstanceTypesCacheConfigMap client.ObjectKey
+
+	// ReconcileHistorySize is the number of reconcile outcomes kept in memory per MachineDeployment.
+	// Zero disables the reconcile history.
+	ReconcileHistorySize int
+
+	// MachineDeploymentCapacityMetrics exports the annotated capacity of each MachineDeployment as gauges labeled by
+	// namespace, name, cluster and instance type. The series grow with the number of MachineDeployments.
+	MachineDeploymentCapacityMetrics bool
+
+	// SkipUnchangedLookups skips the instance type lookup, and thereby the AWS clients and calls, of
+	// MachineDeployments whose template, instance type, region and annotations are unchanged since their last
+	// successful lookup.
+	SkipUnchangedLookups bool
+	// AnnotationConfigHash identifies the configuration of the annotations, e.g. a hash of the annotation flags.
+	// Lookups are not skipped once it changed.
+	AnnotationConfigHash string
+
+	// OptIn only annotates MachineDeployments with the capa-annotator/enabled annotation set to "true".
+	// Otherwise, all MachineDeployments are annotated unless the annotation is set to "false".
+	OptIn bool
+
+	// MachineDeploymentSelector restricts reconciliation to MachineDeployments matching the label selector, e.g. on
+	// management clusters shared with teams that do not want their MachineDeployments annotated. Nil reconciles
+	// all MachineDeployments.
+	MachineDeploymentSelector labels.Selector
+
+	// ExcludedNamespaces are the patterns, in the syntax of path.Match, of the namespaces whose MachineDeployments
+	// are not reconciled, e.g. namespaces of tenants running their own annotators.
+	ExcludedNamespaces []string
+
+	// WatchFilterValue restricts reconciliation to MachineDeployments whose cluster.x-k8s.io/watch-filter label
+	// has this value, so that multiple instances can partition the fleet. Empty reconciles all MachineDeployments.
+	WatchFilterValue string
+
+	// DenyCrossNamespaceTemplates denies references to AWSMachineTemplates in a namespace other than the
+	// MachineDeployment's, unless allowed by one of CrossNamespaceTemplateRules.
+	DenyCrossNamespaceTemplates bool
+	// CrossNamespaceTemplateRules are the cross-namespace template references allowed when
+	// DenyCrossNamespaceTemplates is set.
+	CrossNamespaceTemplateRules []CrossNamespaceRule
+
+	// IdentitySecretNamespace is the namespace of the Secrets of AWSClusterStaticIdentities, i.e. the namespace of
+	// the CAPA controller. If set, the AWS clients of MachineDeployments authenticate with the identity of their
+	// Cluster, otherwise with the controller's own credentials.
+	IdentitySecretNamespace string
+	// IdentityClientBuilder builds the AWS clients of identities assuming the roles of AWSClusterRoleIdentities.
+	// Without it, the roles are not assumed and the clients authenticate with the source identity.
+	IdentityClientBuilder awsclient.IdentityClientBuilderFuncType
+	// RoleARNPatterns are the patterns, in the syntax of path.Match, of the roles MachineDeployments and Clusters
+	// may name in the capa-annotator/role-arn annotation. The role is assumed with the identity of the Cluster
+	// for the AWS calls. Without patterns, the annotation is ignored.
+	RoleARNPatterns []string
+
+	// InstanceTypePolicy optionally restricts the instance types of MachineDeployments. MachineDeployments
+	// violating it are not annotated.
+	InstanceTypePolicy *InstanceTypePolicy
+
+	// NamespaceQuota limits the patches and warning events per namespace and time window. The zero value is unlimited.
+	NamespaceQuota NamespaceQuota
+
+	// RetryBudget limits the failed reconciles of a MachineDeployment per time window before the controller gives
+	// up and backs off. The zero value retries with the exponential backoff of the workqueue only.
+	RetryBudget RetryBudget
+
+	// AWSRetry requeues MachineDeployments whose instance type lookup failed for transient reasons with exponential
+	// backoff. The zero value drops the work until the next resync.
+	AWSRetry AWSRetry
+
+	// Chaos optionally injects faults into the reconciles for soak tests. It is not meant for production.
+	Chaos *Chaos
+
+	// AuditSink optionally receives a record of every annotation change and warning event, for a longer
+	// retention than Kubernetes Events.
+	AuditSink AuditSink
 
 	recorder record.EventRecorder
 	scheme   *runtime.Scheme
+	parked   *parkedTracker
+	history  *reconcileHistory
+
+	unknownInstanceTypes *unknownInstanceTypes
+	amiArchitectures     *amiArchitectures
+	budgets              *namespaceBudgets
+	retries              *retryTracker
+	awsRetries           *awsRetryTracker
+	unknownRetries       *unknownInstanceTypeRetries
+
+	// observe is called with the status of every reconcile, e.g. to summarize the reconciles of a run.
+	observe func(key types.NamespacedName, status *reconcileStatus)
 }
 
 // SetupWithManager creates a new controller for a manager.
 func (r *Reconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
-	_, err := ctrl.NewControllerManagedBy(mgr).
-		For(&clusterv1.MachineDeployment{}).
-		WithOptions(options).
-		Build(r)
+	builder := ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1.MachineDeployment{}, ctrlbuilder.WithPredicates(machineDeploymentChangedPredicate(), r.watchFilterPredicate(), r.selectorPredicate(), r.excludedNamespacesPredicate())).
+		// Topology generated templates are watched, so that MachineDeployments are annotated as soon as a rotated template is created
+		Watches(&infrav1.AWSMachineTemplate{}, handler.EnqueueRequestsFromMapFunc(r.machineDeploymentsOfTemplate)).
+		WithOptions(options)
 
-	if err != nil {
+	// Reannotations after an upgrade are enqueued through a channel
+	var reannotations chan event.GenericEvent
+	if r.ReannotationInterval > 0 {
+		reannotations = make(chan event.GenericEvent)
+		builder = builder.WatchesRawSource(source.Channel(reannotations, &handler.EnqueueRequestForObject{}))
+	}
+
+	// So are MachineDeployments whose instance type became available
+	var newInstanceTypes chan event.GenericEvent
+	if r.NewInstanceTypePollInterval > 0 {
+		newInstanceTypes = make(chan event.GenericEvent)
+		builder = builder.WatchesRawSource(source.Channel(newInstanceTypes, &handler.EnqueueRequestForObject{}))
+	}
+
+	if _, err := builder.Build(r); err != nil {
 		return fmt.Errorf("failed setting up with a controller manager: %w", err)
 	}
 
-	r.recorder = mgr.GetEventRecorderFor("machinedeployment-controller")
-	r.scheme = mgr.GetScheme()
+	if reannotations != nil {
+		reannotator := &fleetReannotator{client: mgr.GetClient(), interval: r.ReannotationInterval, events: reannotations}
+		if err := mgr.Add(reannotator); err != nil {
+			return fmt.Errorf("failed adding the fleet reannotator: %w", err)
+		}
+	}
+
+	if newInstanceTypes != nil {
+		r.unknownInstanceTypes = newUnknownInstanceTypes()
+		watcher := &newInstanceTypeWatcher{reconciler: r, interval: r.NewInstanceTypePollInterval, events: newInstanceTypes}
+		if err := mgr.Add(watcher); err != nil {
+			return fmt.Errorf("failed adding the new instance type watcher: %w", err)
+		}
+	}
+
+	if r.InstanceTypesRefreshAhead > 0 {
+		refresher := &instanceTypesRefresher{reconciler: r, ahead: r.InstanceTypesRefreshAhead}
+		if err := mgr.Add(refresher); err != nil {
+			return fmt.Errorf("failed adding the instance types refresher: %w", err)
+		}
+	}
+
+	if r.InstanceTypesCacheConfigMap.Name != "" {
+		if err := r.setupInstanceTypesCacheStore(mgr); err != nil {
+			return err
+		}
+	}
+
+	r.setup(mgr)
 	return nil
 }
 
+// setup initializes the event recorder and the trackers of the enabled features.
+func (r *Reconciler) setup(mgr ctrl.Manager) {
+	r.setupTrackers(mgr.GetEventRecorderFor("machinedeployment-controller"), mgr.GetScheme())
+}
+
+// setupTrackers initializes the reconciler with an event recorder and the trackers of the enabled features.
+func (r *Reconciler) setupTrackers(recorder record.EventRecorder, scheme *runtime.Scheme) {
+	r.recorder = recorder
+	if r.NamespaceQuota.enabled() {
+		r.budgets = newNamespaceBudgets(r.NamespaceQuota)
+		// Warning events beyond the budget are still audited
+		r.recorder = &quotaRecorder{EventRecorder: r.recorder, budgets: r.budgets}
+	}
+	if r.AuditSink != nil {
+		r.recorder = &auditRecorder{EventRecorder: r.recorder, sink: r.AuditSink}
+	}
+	r.scheme = scheme
+	if r.ParkedReconcileInterval > 0 {
+		r.parked = newParkedTracker(r.ParkedAfter, r.ParkedReconcileInterval)
+	}
+	if r.ReconcileHistorySize > 0 {
+		r.history = newReconcileHistory(r.ReconcileHistorySize)
+	}
+	if r.RetryBudget.enabled() {
+		r.retries = newRetryTracker(r.RetryBudget)
+	}
+	if r.AWSRetry.enabled() {
+		r.awsRetries = newAWSRetryTracker(r.AWSRetry)
+	}
+	if r.UnknownInstanceTypeRetryInterval > 0 {
+		r.unknownRetries = newUnknownInstanceTypeRetries(r.UnknownInstanceTypeRetryInterval)
+	}
+	if r.ValidateAMIArchitecture {
+		r.amiArchitectures = newAMIArchitectures()
+	}
+}
+
 // Reconcile implements controller runtime Reconciler interface.
-func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, reterr error) {
+	start := time.Now()
+	status := &reconcileStatus{result: metrics.ResultSuccess}
+	ctx = context.WithValue(ctx, reconcileStatusKey{}, status)
+	machineDeployment := &clusterv1.MachineDeployment{}
+	defer func() {
+		if reterr != nil && status.reason == "" {
+			status.reason = failureReason(reterr)
+		}
+		// Once the retry budget is exhausted, the failures are reported once and retried after the backoff
+		if r.retries != nil && status.reconciled {
+			if reterr == nil {
+				r.retries.succeeded(req.NamespacedName)
+			} else if gaveUp, causes := r.retries.failed(req.NamespacedName, machineDeployment.Generation, reterr); gaveUp {
+				r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "GaveUp", "Giving up after repeated failures, retrying in %v: %s", r.RetryBudget.Backoff, causes)
+				status.result = metrics.ResultGaveUp
+				status.message = causes
+				result, reterr = ctrl.Result{RequeueAfter: r.RetryBudget.Backoff}, nil
+			}
+		}
+		if reterr != nil {
+			status.result = metrics.ResultError
+			status.message = reterr.Error()
+		}
+		metrics.RecordReconcile(req.Namespace, status.result, time.Since(start))
+		if status.failed() {
+			if status.reason == "" {
+				status.reason = metrics.ReasonOther
+			}
+			metrics.RecordReconcileFailure(req.Namespace, status.reason)
+		}
+		if r.history != nil && status.reconciled {
+			r.history.record(req.NamespacedName, ReconcileOutcome{
+				Time:     start.UTC(),
+				Duration: time.Since(start).String(),
+				Result:   status.result,
+				Error:    status.message,
+				Values:   status.values,
+				Changes:  status.changes,
+			})
+		}
+		if r.observe != nil {
+			r.observe(req.NamespacedName, status)
+		}
+		if observe, ok := ctx.Value(reconcileObserverKey{}).(func(*reconcileStatus)); ok {
+			observe(status)
+		}
+	}()
+
 	logger := r.Log.WithValues("machinedeployment", req.Name, "namespace", req.Namespace)
 	logger.V(3).Info("Reconciling")
 
-	machineDeployment := &clusterv1.MachineDeployment{}
+	if r.Chaos != nil {
+		r.injectChaos(ctx)
+	}
+
 	if err := r.Client.Get(ctx, req.NamespacedName, machineDeployment); err != nil {
 		if apierrors.IsNotFound(err) {
 			// Object not found, return. Created objects are automatically garbage collected.
 			// For additional cleanup logic use finalizers.
+			if r.parked != nil {
+				r.parked.forget(req.NamespacedName)
+			}
+			if r.history != nil {
+				r.history.forget(req.NamespacedName)
+			}
+			if r.unknownInstanceTypes != nil {
+				r.unknownInstanceTypes.forget(req.NamespacedName)
+			}
+			if r.retries != nil {
+				r.retries.forget(req.NamespacedName)
+			}
+			if r.awsRetries != nil {
+				r.awsRetries.forget(req.NamespacedName)
+			}
+			if r.unknownRetries != nil {
+				r.unknownRetries.forget(req.NamespacedName)
+			}
+			metrics.ForgetInstanceTypeUse(req.NamespacedName.String())
+			metrics.ForgetMachineDeploymentCapacity(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return ctrl.Result{}, err
 	}
 
+	// MachineDeployments of other instances are still enqueued through their templates and the reannotations
+	if !r.matchesWatchFilter(machineDeployment) {
+		logger.V(3).Info("Skipping MachineDeployment not matching the watch filter", "watchFilter", r.WatchFilterValue)
+		return ctrl.Result{}, nil
+	}
+	if !r.matchesSelector(machineDeployment) {
+		logger.V(3).Info("Skipping MachineDeployment not matching the selector", "selector", r.MachineDeploymentSelector)
+		return ctrl.Result{}, nil
+	}
+	if r.namespaceExcluded(machineDeployment) {
+		logger.V(3).Info("Skipping MachineDeployment in excluded namespace")
+		return ctrl.Result{}, nil
+	}
+
+	// Annotations written before the MachineDeployment opted out are left as they are, e.g. to be edited by hand
+	if enabled, err := r.annotationEnabled(machineDeployment); !enabled {
+		if err != nil {
+			r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "InvalidAnnotation", "Not annotating MachineDeployment: %v", err)
+		}
+		logger.V(3).Info("Skipping MachineDeployment not enabled for annotation", "optIn", r.OptIn)
+		return ctrl.Result{}, nil
+	}
+
 	// Ignore deleted MachineDeployments, this can happen when foregroundDeletion
 	// is enabled
 	if !machineDeployment.DeletionTimestamp.IsZero() {
 		return ctrl.Result{}, nil
 	}
 
-	originalMachineDeploymentToPatch := client.MergeFrom(machineDeployment.DeepCopy())
+	// Ignore MachineDeployments of deleting Clusters, the lookups of their templates and AWSClusters
+	// race the deletion and would only produce misleading warning events during teardown
+	deleting, err := r.clusterDeleting(ctx, machineDeployment)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if deleting {
+		logger.V(3).Info("Skipping MachineDeployment of deleting Cluster", "cluster", machineDeployment.Spec.ClusterName)
+		return ctrl.Result{}, nil
+	}
+
+	// Parked MachineDeployments are only reconciled once per parked interval
+	if r.parked != nil {
+		if skip, requeueAfter := r.parked.shouldSkip(machineDeployment); skip {
+			logger.V(3).Info("Skipping reconcile of parked MachineDeployment", "requeueAfter", requeueAfter)
+			return ctrl.Result{RequeueAfter: requeueAfter}, nil
+		}
+	}
 
-	result, err := r.reconcile(ctx, machineDeployment)
+	if r.retries != nil {
+		if backingOff, requeueAfter := r.retries.backingOff(req.NamespacedName, machineDeployment.Generation); backingOff {
+			logger.V(3).Info("Skipping reconcile, the retry budget is exhausted", "requeueAfter", requeueAfter)
+			return ctrl.Result{RequeueAfter: requeueAfter}, nil
+		}
+	}
+
+	originalMachineDeployment := machineDeployment.DeepCopy()
+	originalMachineDeploymentToPatch := client.MergeFrom(originalMachineDeployment)
+
+	status.reconciled = true
+	reconcileResult, err := r.reconcile(ctx, machineDeployment)
 	if err != nil {
 		logger.Error(err, "Failed to reconcile MachineDeployment")
 		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "ReconcileError", "%v", err)
 		// we don't return here so we want to attempt to patch the machine regardless of an error.
 	}
 
-	if err := r.Client.Patch(ctx, machineDeployment, originalMachineDeploymentToPatch); err != nil {
+	if r.parked != nil {
+		r.parked.recordSpecChange(machineDeployment)
+	}
+
+	if status.lookupInputs != "" && err == nil && status.result == metrics.ResultSuccess {
+		setLookupHash(machineDeployment.Annotations, status.lookupInputs)
+	}
+
+	if r.budgets != nil {
+		if throttled, requeueAfter := r.throttlePatch(machineDeployment, originalMachineDeploymentToPatch); throttled {
+			logger.V(2).Info("Deferring patch, the patch budget of the namespace is used up", "requeueAfter", requeueAfter)
+			setReconcileResult(ctx, metrics.ResultThrottled, "patch budget of the namespace used up")
+			return ctrl.Result{RequeueAfter: requeueAfter}, nil
+		}
+	}
+
+	// Reconciles without changes, e.g. the periodic resyncs, do not write to the API server
+	if patchEmpty(machineDeployment, originalMachineDeploymentToPatch) {
+		logger.V(4).Info("Skipping patch, the annotations are unchanged")
+	} else if err := r.Client.Patch(ctx, machineDeployment, originalMachineDeploymentToPatch); err != nil {
 		return ctrl.Result{}, fmt.Errorf("failed to patch machineDeployment: %v", err)
 	}
+	r.auditAnnotationChanges(machineDeployment, originalMachineDeployment.Annotations)
+	changes := diffAnnotations(originalMachineDeployment.Annotations, machineDeployment.Annotations)
+	if len(changes) > 0 {
+		metrics.RecordAnnotationUpdate(machineDeployment.Namespace)
+	}
+	status.changes = changes
+	if r.history != nil {
+		status.values = managedValues(machineDeployment.Annotations)
+	}
+
+	if r.parked != nil && err == nil {
+		r.parked.reconciled(machineDeployment)
+	}
+
+	if r.PeriodicReannotationInterval > 0 && err == nil && status.result == metrics.ResultSuccess && reconcileResult.IsZero() {
+		reconcileResult.RequeueAfter = wait.Jitter(r.PeriodicReannotationInterval, 0.1)
+		status.periodicReannotation = true
+	}
 
-	return result, err
+	return reconcileResult, err
+}
+
+// patchEmpty returns true if the patch does not change the object.
+func patchEmpty(obj client.Object, patch client.Patch) bool {
+	data, err := patch.Data(obj)
+	return err == nil && string(data) == "{}"
+}
+
+// clusterDeleting returns true if the Cluster of the MachineDeployment is being deleted.
+// A missing Cluster is not considered deleting, the region can still be resolved from the annotation.
+func (r *Reconciler) clusterDeleting(ctx context.Context, machineDeployment *clusterv1.MachineDeployment) (bool, error) {
+	if machineDeployment.Spec.ClusterName == "" {
+		return false, nil
+	}
+
+	cluster := &clusterv1.Cluster{}
+	key := client.ObjectKey{Namespace: machineDeployment.Namespace, Name: machineDeployment.Spec.ClusterName}
+	if err := r.Client.Get(ctx, key, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, annotatorerrors.NewLookupError("Cluster", key.Namespace, key.Name, err)
+	}
+	return !cluster.DeletionTimestamp.IsZero(), nil
+}
+
+// reconcileStatus is the status of the running reconcile, reported to the metrics and the reconcile history.
+type reconcileStatus struct {
+	// result is the metrics result label.
+	result string
+	// message is the error or failure reason of reconciles that did not succeed.
+	message string
+	// reason is the reason label of failed reconciles, classified from their error unless set by the reconcile.
+	reason string
+	// reconciled is true once the MachineDeployment is reconciled, i.e. it was not skipped.
+	reconciled bool
+	// lookupInputs are the inputs of the lookup of a MachineDeployment annotated successfully.
+	lookupInputs string
+	// values are the managed annotations after a successful patch, kept for the reconcile history.
+	values map[string]string
+	// changes are the annotation changes of the patch.
+	changes map[string]AnnotationChange
+	// periodicReannotation is true if the MachineDeployment was annotated and is only requeued for its periodic
+	// reannotation.
+	periodicReannotation bool
+}
+
+// reconcileStatusKey is the context key of the status of the running reconcile.
+type reconcileStatusKey struct{}
+
+// reconcileObserverKey is the context key of a function called with the status of the reconcile, for callers of
+// Reconcile other than the controller, e.g. the runtime extension.
+type reconcileObserverKey struct{}
+
+// setReconcileResult sets the metrics result label and the failure reason of the running reconcile. This is used
+// for reconciles that fail without returning an error, since they would be counted as successful otherwise.
+func setReconcileResult(ctx context.Context, result, message string) {
+	if status, ok := ctx.Value(reconcileStatusKey{}).(*reconcileStatus); ok {
+		status.result = result
+		status.message = message
+	}
 }
 
 func (r *Reconciler) reconcile(ctx context.Context, machineDeployment *clusterv1.MachineDeployment) (ctrl.Result, error) {
 	klog.V(3).Infof("%v: Reconciling MachineDeployment", machineDeployment.Name)
 
+	// The MachineDeployment no longer counts as using its previous instance type if the annotations
+	// cannot be set, e.g. after switching to an unknown instance type
+	key := client.ObjectKeyFromObject(machineDeployment).String()
+	inUse := false
+	defer func() {
+		if !inUse {
+			metrics.ForgetInstanceTypeUse(key)
+			metrics.ForgetMachineDeploymentCapacity(client.ObjectKeyFromObject(machineDeployment))
+		}
+	}()
+
+	// Enforce the cross-namespace template policy before reading the template with the controller's privileges
+	if err := r.checkTemplateNamespace(machineDeployment); err != nil {
+		klog.Errorf("Refusing to resolve AWSMachineTemplate: %v", err)
+		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "Forbidden", "Refusing to resolve AWSMachineTemplate: %v", err)
+		setReconcileResult(ctx, metrics.ResultForbidden, fmt.Sprintf("refusing to resolve AWSMachineTemplate: %v", err))
+		// Retrying does not help until the MachineDeployment or the policy changes
+		return ctrl.Result{}, nil
+	}
+
+	// Other infrastructure providers resolve the capacity themselves
+	if provider := r.providerFor(machineDeployment); provider != nil {
+		var err error
+		inUse, err = r.reconcileWithProvider(ctx, machineDeployment, provider)
+		return ctrl.Result{}, err
+	}
+
 	// Resolve AWSMachineTemplate
-	awsMachineTemplate, err := utils.ResolveAWSMachineTemplate(ctx, r.Client, machineDeployment)
+	awsMachineTemplate, err := r.templateResolver().ResolveAWSMachineTemplate(ctx, r.Client, machineDeployment)
+	if err != nil && apierrors.IsNotFound(err) && topologyManaged(machineDeployment) {
+		// The topology controller is rotating the template, the MachineDeployment is reconciled again once it is created
+		klog.V(2).Infof("%v: Waiting for the rotated AWSMachineTemplate %s of the topology: %v", machineDeployment.Name, machineDeployment.Spec.Template.Spec.InfrastructureRef.Name, err)
+		return ctrl.Result{}, nil
+	}
 	if err != nil {
 		klog.Errorf("Failed to resolve AWSMachineTemplate: %v", err)
 		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve AWSMachineTemplate: %v", err)
+		setReconcileReason(ctx, metrics.ReasonTemplateMissing)
 		return ctrl.Result{}, err
 	}
 
@@ -114,65 +646,398 @@ func (r *Reconciler) reconcile(ctx context.Context, machineDeployment *clusterv1
 	if err != nil {
 		klog.Errorf("Failed to extract instance type: %v", err)
 		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "FailedUpdate", "Failed to extract instance type: %v", err)
+		setReconcileReason(ctx, metrics.ReasonTemplateInvalid)
 		return ctrl.Result{}, err
 	}
 
+	if err := r.InstanceTypePolicy.Check(machineDeployment.Namespace, instanceType); err != nil {
+		klog.Errorf("%v: Not setting scale from zero annotations: %v", machineDeployment.Name, err)
+		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "PolicyViolation", "Not setting autoscaling from zero annotations: %v", err)
+		setReconcileResult(ctx, metrics.ResultForbidden, err.Error())
+		// Retrying does not help until the instance type or the policy changes
+		return ctrl.Result{}, nil
+	}
+
 	// Resolve AWS region
-	region, err := utils.ResolveRegion(ctx, r.Client, machineDeployment)
+	region, err := r.regionResolver().ResolveRegion(ctx, r.Client, machineDeployment)
 	if err != nil {
 		klog.Errorf("Failed to resolve AWS region: %v", err)
 		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve AWS region: %v", err)
+		setReconcileReason(ctx, metrics.ReasonRegionUnknown)
+		return ctrl.Result{}, err
+	}
+
+	// The annotations are up to date if neither the inputs nor the annotations changed since the last lookup
+	inputs := r.lookupInputs(machineDeployment, awsMachineTemplate, instanceType, region)
+	if r.lookupUnchanged(machineDeployment, inputs) {
+		klog.V(3).Infof("%v: Skipping lookup of instance type %s, the template, region and annotations are unchanged", machineDeployment.Name, instanceType)
+		metrics.RecordLookupSkipped(machineDeployment.Namespace)
+		inUse = true
+		return ctrl.Result{}, nil
+	}
+
+	// Resolve the credentials, the empty identity uses IRSA, EKS Pod Identity or the default credential chain
+	identity, err := r.identity(ctx, machineDeployment)
+	if err != nil {
+		klog.Errorf("Failed to resolve AWS identity: %v", err)
+		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve AWS identity: %v", err)
+		setReconcileReason(ctx, metrics.ReasonIdentity)
 		return ctrl.Result{}, err
 	}
 
-	// Create AWS client (secretName is empty string, credentials will come from IRSA or default credential chain)
-	awsClient, err := r.AwsClientBuilder(r.Client, "", machineDeployment.Namespace, region, r.RegionCache)
+	// Create AWS client
+	awsClient, err := r.awsClientOf(identity, region)
 	if err != nil {
+		setReconcileReason(ctx, metrics.ReasonAWSError)
 		return ctrl.Result{}, fmt.Errorf("error creating aws client: %w", err)
 	}
 
+	// Instance types found unknown before are fetched again, the cached instance types of the region only change
+	// once the cache expires
+	cacheID := cacheIDOf(identity, region)
+	negative := negativeLookup{template: awsMachineTemplate.UID, generation: awsMachineTemplate.Generation, region: region, instanceType: instanceType}
+	if r.unknownRetries != nil && r.unknownRetries.due(client.ObjectKeyFromObject(machineDeployment), negative) {
+		if cache, ok := r.InstanceTypesCache.(instanceTypesExpirer); ok {
+			klog.V(3).Infof("%v: Fetching unknown instance type %s of region %s again", machineDeployment.Name, instanceType, region)
+			cache.expire(cacheID, []string{instanceType})
+		}
+	}
+
 	// Get instance type information
-	instanceTypeInfo, err := r.InstanceTypesCache.GetInstanceType(awsClient, region, instanceType)
+	instanceTypeInfo, err := r.InstanceTypesCache.GetInstanceType(awsClient, cacheID, instanceType)
 	if err != nil {
+		// Transient failures say nothing about the instance type, they are retried with backoff
+		if r.awsRetries != nil && retriable(err) {
+			delay := r.awsRetries.failed(client.ObjectKeyFromObject(machineDeployment))
+			klog.Warningf("%v: Unable to look up instance type %s, retrying in %v: %v", machineDeployment.Name, instanceType, delay, err)
+			r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "FailedUpdate", "Failed to look up instance type %s, retrying in %v: %v", instanceType, delay, err)
+			setReconcileResult(ctx, metrics.ResultFailed, fmt.Sprintf("failed to look up instance type %s: %v", instanceType, err))
+			setReconcileReason(ctx, metrics.ReasonAWSError)
+			return ctrl.Result{RequeueAfter: delay}, nil
+		}
 		klog.Errorf("Unable to set scale from zero annotations: unknown instance type %s: %v", instanceType, err)
-		klog.Errorf("Autoscaling from zero will not work. To fix this, manually populate machine annotations for your instance type: %v", []string{cpuKey, memoryKey, gpuKey})
+		klog.Errorf("Autoscaling from zero will not work. To fix this, manually populate machine annotations for your instance type: %v", r.capacityKeys())
 
 		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "FailedUpdate", "Failed to set autoscaling from zero annotations, instance type unknown")
+		setReconcileResult(ctx, metrics.ResultFailed, fmt.Sprintf("unknown instance type %s: %v", instanceType, err))
+		setReconcileReason(ctx, failureReason(err))
+		if r.unknownInstanceTypes != nil {
+			r.unknownInstanceTypes.record(region, instanceType, client.ObjectKeyFromObject(machineDeployment))
+		}
+		if r.unknownRetries != nil && errors.Is(err, annotatorerrors.ErrUnknownInstanceType) {
+			r.unknownRetries.record(client.ObjectKeyFromObject(machineDeployment), negative)
+			return ctrl.Result{RequeueAfter: r.UnknownInstanceTypeRetryInterval}, nil
+		}
 		return ctrl.Result{}, nil
 	}
+	if r.awsRetries != nil {
+		r.awsRetries.forget(client.ObjectKeyFromObject(machineDeployment))
+	}
+	if r.unknownRetries != nil {
+		r.unknownRetries.forget(client.ObjectKeyFromObject(machineDeployment))
+	}
+	if r.unknownInstanceTypes != nil {
+		r.unknownInstanceTypes.forget(client.ObjectKeyFromObject(machineDeployment))
+	}
+	if instanceTypeInfo.Source == DataSourceFallback {
+		r.recorder.Event(machineDeployment, corev1.EventTypeWarning, "StaleInstanceTypeData", staleDataMessage(instanceTypeInfo, region))
+	}
+
+	// Regional availability does not imply availability on the Outpost the MachineDeployment is placed on
+	outpostARN, err := resolveOutpost(awsClient, awsMachineTemplate)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error resolving outpost: %w", err)
+	}
+	if outpostARN != "" {
+		available, err := outpostHasInstanceType(awsClient, outpostARN, instanceType)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error listing instance types of outpost %s: %w", outpostARN, err)
+		}
+		if !available {
+			klog.Errorf("Unable to set scale from zero annotations: instance type %s is not available on outpost %s", instanceType, outpostARN)
+			r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "FailedUpdate", "Failed to set autoscaling from zero annotations, instance type %s is not available on outpost %s", instanceType, outpostARN)
+			setReconcileResult(ctx, metrics.ResultFailed, fmt.Sprintf("instance type %s is not available on outpost %s", instanceType, outpostARN))
+			return ctrl.Result{}, nil
+		}
+	}
+
+	// An AMI of the wrong architecture only surfaces as failed instance launches much later, the annotations
+	// are still set since they describe the instance type correctly
+	if r.amiArchitectures != nil {
+		amiID, amiArch, err := r.amiArchitectures.get(awsClient, region, awsMachineTemplate)
+		if err != nil {
+			klog.Warningf("%v: Unable to validate the architecture of AMI %s: %v", machineDeployment.Name, amiID, err)
+		} else if amiArch != "" && amiArch != instanceTypeInfo.CPUArchitecture {
+			klog.Warningf("%v: AMI %s is an %s image, but instance type %s is %s", machineDeployment.Name, amiID, amiArch, instanceType, instanceTypeInfo.CPUArchitecture)
+			r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "AMIArchitectureMismatch", "AMI %s of AWSMachineTemplate %s is an %s image, but instance type %s is %s, instances will fail to launch",
+				amiID, awsMachineTemplate.Name, amiArch, instanceType, instanceTypeInfo.CPUArchitecture)
+		}
+	}
+
+	// A MachineDeployment pinned to a single failure domain only creates nodes in that zone
+	var topologyLabels map[string]string
+	if zone := pinnedFailureDomain(machineDeployment); zone != "" {
+		zoneID, err := r.AvailabilityZonesCache.GetZoneID(awsClient, cacheID, zone)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error resolving availability zone %s: %w", zone, err)
+		}
+		if zoneID == "" {
+			klog.Warningf("%v: Failure domain %s is not an availability zone of region %s, omitting the zone ID label", machineDeployment.Name, zone, region)
+		}
+		topologyLabels = zoneLabels(zone, zoneID)
+	}
+
+	// Without the taints, scale-from-zero places pods onto simulated nodes that would actually be tainted
+	var taints []corev1.Taint
+	if r.taintsEnabled() {
+		taints, err = r.resolveTaints(ctx, machineDeployment)
+		if err != nil {
+			klog.Errorf("Failed to resolve taints: %v", err)
+			r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve taints: %v", err)
+			return ctrl.Result{}, err
+		}
+	}
 
 	// Set annotations
 	if machineDeployment.Annotations == nil {
 		machineDeployment.Annotations = make(map[string]string)
 	}
 
-	machineDeployment.Annotations[cpuKey] = strconv.FormatInt(instanceTypeInfo.VCPU, 10)
-	machineDeployment.Annotations[memoryKey] = strconv.FormatInt(instanceTypeInfo.MemoryMb, 10)
-	machineDeployment.Annotations[gpuKey] = strconv.FormatInt(instanceTypeInfo.GPU, 10)
+	// The instance type metrics keep reporting the capacity of the instance type itself
+	capacity, err := r.postProcessCapacity(machineDeployment, instanceTypeInfo)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error post-processing capacity of instance type %s: %w", instanceType, err)
+	}
 
-	// Parse existing labels, update architecture, and preserve user-provided labels
-	labelsMap := make(map[string]string)
-	if existingLabels, ok := machineDeployment.Annotations[labelsKey]; ok && existingLabels != "" {
-		// Parse comma-separated labels into map
-		for _, label := range strings.Split(existingLabels, ",") {
-			parts := strings.SplitN(strings.TrimSpace(label), "=", 2)
-			if len(parts) == 2 {
-				labelsMap[parts[0]] = parts[1]
+	// Silently overriding the architecture declared by the user causes mis-scheduling if either is wrong
+	if userArch, conflict := userArchitecture(machineDeployment, capacity.CPUArchitecture); conflict {
+		switch r.ArchConflictPolicy {
+		case ArchConflictPolicyUser:
+			klog.Warningf("%v: Keeping architecture %s of the node label instead of %s of instance type %s", machineDeployment.Name, userArch, capacity.CPUArchitecture, instanceType)
+			capacity.CPUArchitecture = normalizedArch(userArch)
+		case ArchConflictPolicyError:
+			message := fmt.Sprintf("node label %s=%s conflicts with architecture %s of instance type %s", archLabelKey, userArch, capacity.CPUArchitecture, instanceType)
+			klog.Errorf("%v: Unable to set scale from zero annotations: %s", machineDeployment.Name, message)
+			r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "ArchitectureConflict", "Failed to set autoscaling from zero annotations, %s", message)
+			setReconcileResult(ctx, metrics.ResultFailed, message)
+			return ctrl.Result{}, nil
+		default:
+			klog.Warningf("%v: Overriding architecture %s of the node label with %s of instance type %s", machineDeployment.Name, userArch, capacity.CPUArchitecture, instanceType)
+		}
+	}
+
+	capacity = withRootVolume(capacity, awsMachineTemplate)
+	r.setCapacityAnnotations(machineDeployment.Annotations, capacity, region, topologyLabels)
+	if r.taintsEnabled() {
+		setTaintsAnnotation(machineDeployment.Annotations, taints)
+	}
+	r.startMigration(machineDeployment.Annotations)
+	r.enforceLabelsSizeLimit(machineDeployment)
+
+	metrics.SetInstanceTypeInUse(key, region, instanceType, instanceTypeInfo.VCPU, instanceTypeInfo.MemoryMb, instanceTypeInfo.GPU)
+	r.setCapacityMetrics(machineDeployment, instanceType, capacity)
+	inUse = true
+	if r.SkipUnchangedLookups {
+		setLookupInputs(ctx, inputs)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// setCapacityAnnotations sets the capacity annotations for the instance type on the given annotations.
+// The topology labels, if any, are added to the labels annotation.
+func (r *Reconciler) setCapacityAnnotations(annotations map[string]string, instanceTypeInfo InstanceType, region string, topologyLabels map[string]string) {
+	capacity := r.schemeCapacity(r.AnnotationScheme, instanceTypeInfo)
+	schemes := []AnnotationScheme{r.AnnotationScheme}
+
+	// The additional scheme is written permanently, e.g. for clusters serving both autoscaler flavors
+	if additional := r.additionalScheme(); additional != "" {
+		for key, value := range r.schemeCapacity(additional, instanceTypeInfo) {
+			capacity[key] = value
+		}
+		schemes = append(schemes, additional)
+	}
+
+	// While migrating between annotation schemes, write both schemes for the migration window and
+	// remove the keys of the old scheme afterwards
+	if r.migrationPending(annotations) {
+		if r.inMigrationWindow(annotations) {
+			for key, value := range r.schemeCapacity(r.MigrateFromAnnotationScheme, instanceTypeInfo) {
+				capacity[key] = value
+			}
+			schemes = append(schemes, r.MigrateFromAnnotationScheme)
+		} else {
+			r.completeMigration(annotations)
+		}
+	}
+
+	if r.AdditionalMemoryKey != "" {
+		capacity[r.AdditionalMemoryKey] = r.AdditionalMemoryUnit.Format(instanceTypeInfo.MemoryMb)
+	}
+	if r.MaxPodsMode != MaxPodsModeNone {
+		if maxPods, ok := r.maxPods(instanceTypeInfo); ok {
+			capacity[maxPodsKey] = strconv.FormatInt(maxPods, 10)
+		}
+	}
+	if r.EphemeralStorageMode != EphemeralStorageModeNone {
+		if ephemeralStorage, ok := r.ephemeralStorage(instanceTypeInfo); ok {
+			capacity[ephemeralDiskKey] = ephemeralStorage
+		}
+	}
+	customAnnotations := r.renderCustomAnnotations(instanceTypeInfo, region)
+	for key, value := range customAnnotations {
+		capacity[key] = value
+	}
+	valuesChanged := false
+	for key, value := range capacity {
+		// Existing values are normalized to the canonical format, but cosmetic differences such as
+		// "08" or " 8" are not considered a change of the capacity values.
+		if !capacityValuesEqual(annotations[key], value) {
+			valuesChanged = true
+		}
+		annotations[key] = value
+	}
+
+	managedKeys := []string{labelsKey, provenanceKey}
+	for _, scheme := range schemes {
+		cpu, memory, gpu := scheme.keys()
+		managedKeys = append(managedKeys, cpu, memory, gpu)
+
+		// Some consumers misbehave when a GPU annotation of "0" is present, so optionally omit it
+		if _, ok := capacity[gpu]; !ok {
+			if existing, ok := annotations[gpu]; ok && !capacityValuesEqual(existing, "0") {
+				valuesChanged = true
+			}
+			delete(annotations, gpu)
+		}
+
+		// Do not keep a stale GPU type, e.g. after switching to an instance type without GPUs
+		if gpuType := scheme.gpuTypeKey(); gpuType != "" {
+			managedKeys = append(managedKeys, gpuType)
+			if _, ok := capacity[gpuType]; !ok {
+				if _, ok := annotations[gpuType]; ok {
+					valuesChanged = true
+				}
+				delete(annotations, gpuType)
+			}
+		}
+	}
+
+	if r.MaxPodsMode != MaxPodsModeNone {
+		managedKeys = append(managedKeys, maxPodsKey)
+		// Do not keep a stale value if the network limits of the instance type are unknown
+		if _, ok := capacity[maxPodsKey]; !ok {
+			if _, ok := annotations[maxPodsKey]; ok {
+				valuesChanged = true
+			}
+			delete(annotations, maxPodsKey)
+		}
+	}
+
+	for _, annotation := range r.CustomAnnotations {
+		managedKeys = append(managedKeys, annotation.Key)
+		// Do not keep a stale value if the template failed to render
+		if _, ok := customAnnotations[annotation.Key]; !ok {
+			if _, ok := annotations[annotation.Key]; ok {
+				valuesChanged = true
+			}
+			delete(annotations, annotation.Key)
+		}
+	}
+
+	if r.EphemeralStorageMode != EphemeralStorageModeNone {
+		managedKeys = append(managedKeys, ephemeralDiskKey)
+		// Do not keep a stale value if the size of the root volume is no longer set
+		if _, ok := capacity[ephemeralDiskKey]; !ok {
+			if _, ok := annotations[ephemeralDiskKey]; ok {
+				valuesChanged = true
 			}
+			delete(annotations, ephemeralDiskKey)
 		}
 	}
 
+	// Parse existing labels, update architecture, and preserve user-provided labels
+	labelsMap := parseLabels(annotations[labelsKey])
+
 	// Update or add architecture label
 	labelsMap[archLabelKey] = string(instanceTypeInfo.CPUArchitecture)
+	// Do not keep stale zone labels, e.g. after the failure domain was removed or changed to a zone without ID
+	delete(labelsMap, zoneLabelKey)
+	delete(labelsMap, zoneIDLabelKey)
+	for key, value := range topologyLabels {
+		labelsMap[key] = value
+	}
+	if r.AcceleratorLabel {
+		r.setAcceleratorLabel(labelsMap, instanceTypeInfo)
+	}
+
+	annotations[labelsKey] = serializeLabels(labelsMap)
+
+	// Record where the values came from, so that stale or unexpected values can be traced back
+	setProvenance(annotations, instanceTypeInfo, region, valuesChanged)
+
+	// Record which annotations are owned by the controller so they can be removed on uninstall
+	if r.AdditionalMemoryKey != "" {
+		managedKeys = append(managedKeys, r.AdditionalMemoryKey)
+	}
+	setManagedKeys(annotations, managedKeys...)
+}
+
+// setCapacityMetrics exports the capacity annotated on the MachineDeployment if per-MachineDeployment capacity
+// metrics are enabled.
+func (r *Reconciler) setCapacityMetrics(machineDeployment *clusterv1.MachineDeployment, instanceType string, capacity InstanceType) {
+	if r.MachineDeploymentCapacityMetrics {
+		metrics.SetMachineDeploymentCapacity(client.ObjectKeyFromObject(machineDeployment), machineDeployment.Spec.ClusterName, instanceType,
+			capacity.VCPU, capacity.MemoryMb, capacity.GPU)
+	}
+}
+
+// capacityKeys returns the capacity annotation keys of the configured annotation scheme.
+func (r *Reconciler) capacityKeys() []string {
+	cpu, memory, gpu := r.AnnotationScheme.keys()
+	return []string{cpu, memory, gpu}
+}
 
-	// Serialize back to comma-separated format
+// capacityValuesEqual returns true if the existing capacity annotation value is equal to the desired one.
+// Integer values are compared numerically, so that formatting variants like "08" or " 8" of hand-written
+// annotations are treated as equal to "8".
+func capacityValuesEqual(existing, desired string) bool {
+	if existing == desired {
+		return true
+	}
+	existingInt, err := strconv.ParseInt(strings.TrimSpace(existing), 10, 64)
+	if err != nil {
+		return false
+	}
+	desiredInt, err := strconv.ParseInt(desired, 10, 64)
+	if err != nil {
+		return false
+	}
+	return existingInt == desiredInt
+}
+
+// parseLabels parses the comma-separated key=value format of the labels annotation into a map.
+func parseLabels(value string) map[string]string {
+	labelsMap := make(map[string]string)
+	if value == "" {
+		return labelsMap
+	}
+	for _, label := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(label), "=", 2)
+		if len(parts) == 2 {
+			labelsMap[parts[0]] = parts[1]
+		}
+	}
+	return labelsMap
+}
+
+// serializeLabels serializes labels back to the comma-separated key=value format.
+// The labels are sorted for deterministic output.
+func serializeLabels(labelsMap map[string]string) string {
 	labels := make([]string, 0, len(labelsMap))
 	for k, v := range labelsMap {
 		labels = append(labels, fmt.Sprintf("%s=%s", k, v))
 	}
-	// Sort for deterministic output in tests
 	sort.Strings(labels)
-	machineDeployment.Annotations[labelsKey] = strings.Join(labels, ",")
-
-	return ctrl.Result{}, nil
+	return strings.Join(labels, ",")
 }
@@ -23,9 +23,11 @@ import (
 
 	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
 	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	gtypes "github.com/onsi/gomega/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -34,14 +36,19 @@ import (
 	"k8s.io/utils/ptr"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	expv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
+// defaultManagedKeys is the managed-keys annotation value of a successfully annotated MachineDeployment.
+const defaultManagedKeys = provenanceKey + "," + labelsKey + "," + gpuKey + "," + memoryKey + "," + cpuKey
+
 var _ = Describe("MachineDeploymentReconciler", func() {
 	var c client.Client
 	var stopMgr context.CancelFunc
@@ -117,6 +124,8 @@ var _ = Describe("MachineDeploymentReconciler", func() {
 			}
 			annotations := md.GetAnnotations()
 			if annotations != nil {
+				// The provenance contains the fetch timestamp, which is not deterministic
+				delete(annotations, provenanceKey)
 				return annotations
 			}
 			// Return an empty map to distinguish between empty annotations and errors
@@ -145,10 +154,11 @@ var _ = Describe("MachineDeploymentReconciler", func() {
 			instanceType:        "a1.2xlarge",
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    "8",
-				memoryKey: "16384",
-				gpuKey:    "0",
-				labelsKey: "kubernetes.io/arch=amd64",
+				cpuKey:         "8",
+				memoryKey:      "16384",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectedEvents: []string{},
 		}),
@@ -156,10 +166,11 @@ var _ = Describe("MachineDeploymentReconciler", func() {
 			instanceType:        "p2.16xlarge",
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    "64",
-				memoryKey: "749568",
-				gpuKey:    "16",
-				labelsKey: "kubernetes.io/arch=amd64",
+				cpuKey:         "64",
+				memoryKey:      "749568",
+				gpuKey:         "16",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectedEvents: []string{},
 		}),
@@ -170,12 +181,13 @@ var _ = Describe("MachineDeploymentReconciler", func() {
 				"annother": "existingAnnotation",
 			},
 			expectedAnnotations: map[string]string{
-				"existing": "annotation",
-				"annother": "existingAnnotation",
-				cpuKey:     "8",
-				memoryKey:  "16384",
-				gpuKey:     "0",
-				labelsKey:  "kubernetes.io/arch=amd64",
+				"existing":     "annotation",
+				"annother":     "existingAnnotation",
+				cpuKey:         "8",
+				memoryKey:      "16384",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectedEvents: []string{},
 		}),
@@ -183,10 +195,11 @@ var _ = Describe("MachineDeploymentReconciler", func() {
 			instanceType:        "m6g.4xlarge",
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    "16",
-				memoryKey: "65536",
-				gpuKey:    "0",
-				labelsKey: "kubernetes.io/arch=arm64",
+				cpuKey:         "16",
+				memoryKey:      "65536",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=arm64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectedEvents: []string{},
 		}),
@@ -194,10 +207,11 @@ var _ = Describe("MachineDeploymentReconciler", func() {
 			instanceType:        "m6i.8xlarge",
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    "32",
-				memoryKey: "131072",
-				gpuKey:    "0",
-				labelsKey: "kubernetes.io/arch=amd64",
+				cpuKey:         "32",
+				memoryKey:      "131072",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectedEvents: []string{},
 		}),
@@ -205,10 +219,11 @@ var _ = Describe("MachineDeploymentReconciler", func() {
 			instanceType:        "m6h.8xlarge",
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    "32",
-				memoryKey: "131072",
-				gpuKey:    "0",
-				labelsKey: "kubernetes.io/arch=amd64",
+				cpuKey:         "32",
+				memoryKey:      "131072",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectedEvents: []string{},
 		}),
@@ -234,7 +249,8 @@ var _ = Describe("MachineDeploymentReconciler", func() {
 				memoryKey: "16384",
 				gpuKey:    "0",
 				// Should preserve user labels and add/update architecture label
-				labelsKey: "custom-label=value,kubernetes.io/arch=amd64,node-role.kubernetes.io/worker=",
+				labelsKey:      "custom-label=value,kubernetes.io/arch=amd64,node-role.kubernetes.io/worker=",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectedEvents: []string{},
 		}),
@@ -248,7 +264,8 @@ var _ = Describe("MachineDeploymentReconciler", func() {
 				memoryKey: "65536",
 				gpuKey:    "0",
 				// Should update architecture from amd64 to arm64 and preserve custom label
-				labelsKey: "custom-label=value,kubernetes.io/arch=arm64",
+				labelsKey:      "custom-label=value,kubernetes.io/arch=arm64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectedEvents: []string{},
 		}),
@@ -305,10 +322,11 @@ func TestReconcile(t *testing.T) {
 			instanceType:        "a1.2xlarge",
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    "8",
-				memoryKey: "16384",
-				gpuKey:    "0",
-				labelsKey: "kubernetes.io/arch=amd64",
+				cpuKey:         "8",
+				memoryKey:      "16384",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectErr: false,
 		},
@@ -317,10 +335,11 @@ func TestReconcile(t *testing.T) {
 			instanceType:        "p2.16xlarge",
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    "64",
-				memoryKey: "749568",
-				gpuKey:    "16",
-				labelsKey: "kubernetes.io/arch=amd64",
+				cpuKey:         "64",
+				memoryKey:      "749568",
+				gpuKey:         "16",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectErr: false,
 		},
@@ -332,12 +351,13 @@ func TestReconcile(t *testing.T) {
 				"annother": "existingAnnotation",
 			},
 			expectedAnnotations: map[string]string{
-				"existing": "annotation",
-				"annother": "existingAnnotation",
-				cpuKey:     "8",
-				memoryKey:  "16384",
-				gpuKey:     "0",
-				labelsKey:  "kubernetes.io/arch=amd64",
+				"existing":     "annotation",
+				"annother":     "existingAnnotation",
+				cpuKey:         "8",
+				memoryKey:      "16384",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectErr: false,
 		},
@@ -360,10 +380,11 @@ func TestReconcile(t *testing.T) {
 			instanceType:        "m6g.4xlarge",
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    "16",
-				memoryKey: "65536",
-				gpuKey:    "0",
-				labelsKey: "kubernetes.io/arch=arm64",
+				cpuKey:         "16",
+				memoryKey:      "65536",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=arm64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectErr: false,
 		},
@@ -372,10 +393,11 @@ func TestReconcile(t *testing.T) {
 			instanceType:        "m6i.8xlarge",
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    "32",
-				memoryKey: "131072",
-				gpuKey:    "0",
-				labelsKey: "kubernetes.io/arch=amd64",
+				cpuKey:         "32",
+				memoryKey:      "131072",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectErr: false,
 		},
@@ -384,10 +406,11 @@ func TestReconcile(t *testing.T) {
 			instanceType:        "m6h.8xlarge",
 			existingAnnotations: make(map[string]string),
 			expectedAnnotations: map[string]string{
-				cpuKey:    "32",
-				memoryKey: "131072",
-				gpuKey:    "0",
-				labelsKey: "kubernetes.io/arch=amd64",
+				cpuKey:         "32",
+				memoryKey:      "131072",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectErr: false,
 		},
@@ -402,7 +425,8 @@ func TestReconcile(t *testing.T) {
 				memoryKey: "16384",
 				gpuKey:    "0",
 				// Should preserve user labels and add/update architecture label
-				labelsKey: "custom-label=value,kubernetes.io/arch=amd64,node-role.kubernetes.io/worker=",
+				labelsKey:      "custom-label=value,kubernetes.io/arch=amd64,node-role.kubernetes.io/worker=",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectErr: false,
 		},
@@ -417,7 +441,8 @@ func TestReconcile(t *testing.T) {
 				memoryKey: "65536",
 				gpuKey:    "0",
 				// Should update architecture from amd64 to arm64 and preserve custom label
-				labelsKey: "custom-label=value,kubernetes.io/arch=arm64",
+				labelsKey:      "custom-label=value,kubernetes.io/arch=arm64",
+				managedKeysKey: defaultManagedKeys,
 			},
 			expectErr: false,
 		},
@@ -465,6 +490,7 @@ func TestReconcile(t *testing.T) {
 
 			_, err = r.reconcile(ctx, machineDeployment)
 			g.Expect(err != nil).To(Equal(tc.expectErr))
+			expectProvenance(g, machineDeployment.Annotations, tc.expectedAnnotations)
 			g.Expect(machineDeployment.Annotations).To(Equal(tc.expectedAnnotations))
 		})
 	}
@@ -485,10 +511,11 @@ func TestReconcileWithIRSA(t *testing.T) {
 			setIRSAEnvVars: true,
 			expectErr:      false,
 			expectedAnnotations: map[string]string{
-				cpuKey:    "8",
-				memoryKey: "16384",
-				gpuKey:    "0",
-				labelsKey: "kubernetes.io/arch=amd64",
+				cpuKey:         "8",
+				memoryKey:      "16384",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 		},
 		{
@@ -497,10 +524,11 @@ func TestReconcileWithIRSA(t *testing.T) {
 			setIRSAEnvVars: false,
 			expectErr:      false,
 			expectedAnnotations: map[string]string{
-				cpuKey:    "8",
-				memoryKey: "16384",
-				gpuKey:    "0",
-				labelsKey: "kubernetes.io/arch=amd64",
+				cpuKey:         "8",
+				memoryKey:      "16384",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
 			},
 		},
 	}
@@ -556,6 +584,7 @@ func TestReconcileWithIRSA(t *testing.T) {
 				}
 			} else {
 				g.Expect(err).ToNot(HaveOccurred())
+				expectProvenance(g, machineDeployment.Annotations, tc.expectedAnnotations)
 				g.Expect(machineDeployment.Annotations).To(Equal(tc.expectedAnnotations))
 			}
 		})
@@ -588,6 +617,191 @@ func TestNormalizeArchitecture(t *testing.T) {
 	}
 }
 
+func TestCapacityValuesEqual(t *testing.T) {
+	testCases := []struct {
+		name     string
+		existing string
+		desired  string
+		expected bool
+	}{
+		{
+			name:     "identical",
+			existing: "8",
+			desired:  "8",
+			expected: true,
+		},
+		{
+			name:     "leading zero",
+			existing: "08",
+			desired:  "8",
+			expected: true,
+		},
+		{
+			name:     "surrounding whitespace",
+			existing: " 8 ",
+			desired:  "8",
+			expected: true,
+		},
+		{
+			name:     "different value",
+			existing: "16",
+			desired:  "8",
+			expected: false,
+		},
+		{
+			name:     "missing",
+			existing: "",
+			desired:  "0",
+			expected: false,
+		},
+		{
+			name:     "non-numeric",
+			existing: "eight",
+			desired:  "8",
+			expected: false,
+		},
+		{
+			name:     "quantity",
+			existing: "16384Mi",
+			desired:  "16384Mi",
+			expected: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			g := NewWithT(tt)
+			g.Expect(capacityValuesEqual(tc.existing, tc.desired)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestMemoryUnitFormat(t *testing.T) {
+	testCases := []struct {
+		unit     MemoryUnit
+		expected string
+	}{
+		{
+			unit:     "",
+			expected: "16384",
+		},
+		{
+			unit:     MemoryUnitMiB,
+			expected: "16384",
+		},
+		{
+			unit:     MemoryUnitMi,
+			expected: "16384Mi",
+		},
+		{
+			unit:     MemoryUnitKi,
+			expected: "16777216Ki",
+		},
+		{
+			unit:     MemoryUnitBytes,
+			expected: "17179869184",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.unit), func(tt *testing.T) {
+			g := NewWithT(tt)
+			g.Expect(tc.unit.Format(16384)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestValidateAdditionalMemoryKey(t *testing.T) {
+	testCases := []struct {
+		key       string
+		expectErr bool
+	}{
+		{key: ""},
+		{key: "example.com/memory-bytes"},
+		{key: memoryKey, expectErr: true},
+		{key: cpuKey, expectErr: true},
+		{key: labelsKey, expectErr: true},
+		{key: caMemoryKey, expectErr: true},
+		{key: maxPodsKey, expectErr: true},
+		{key: ephemeralDiskKey, expectErr: true},
+		{key: taintsKey, expectErr: true},
+		{key: managedKeysKey, expectErr: true},
+		{key: provenanceKey, expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.key, func(tt *testing.T) {
+			g := NewWithT(tt)
+			err := ValidateAdditionalMemoryKey(tc.key)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestWrittenAnnotationKeysAreReserved(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", map[string]string{
+		"example.com/taints": "dedicated=ml:NoSchedule",
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	awsMachineTemplate.Spec.Template.Spec.RootVolume = &infrav1.Volume{Size: 120}
+
+	// Enable every annotation written by the controller
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.AnnotationScheme = AnnotationSchemeOpenShift
+	r.AdditionalAnnotationScheme = AnnotationSchemeClusterAutoscaler
+	r.MaxPodsMode = MaxPodsModeENI
+	r.EphemeralStorageMode = EphemeralStorageModeRootVolume
+	r.TaintsSourceAnnotation = "example.com/taints"
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// A written annotation missing from writtenAnnotationKeys could be overwritten by a user-configured key
+	managedKeys := getManagedKeys(machineDeployment.Annotations)
+	g.Expect(managedKeys).To(ContainElements(maxPodsKey, ephemeralDiskKey, taintsKey))
+	for _, key := range managedKeys {
+		g.Expect(ValidateAdditionalMemoryKey(key)).ToNot(Succeed(), "annotation key %q is not reserved", key)
+	}
+}
+
+func TestReconcileWithAdditionalMemoryKey(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.MemoryUnit = MemoryUnitKi
+	r.AdditionalMemoryKey = "example.com/memory-bytes"
+	r.AdditionalMemoryUnit = MemoryUnitBytes
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(memoryKey, "16777216Ki"))
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue("example.com/memory-bytes", "17179869184"))
+	g.Expect(getManagedKeys(machineDeployment.Annotations)).To(ContainElement("example.com/memory-bytes"))
+}
+
+// expectProvenance verifies that the provenance annotation is recorded whenever capacity annotations are expected
+// and removes it from the annotations, as the fetch timestamp it contains is not deterministic.
+func expectProvenance(g Gomega, annotations map[string]string, expectedAnnotations map[string]string) {
+	if _, ok := expectedAnnotations[cpuKey]; !ok {
+		g.Expect(annotations).ToNot(HaveKey(provenanceKey))
+		return
+	}
+
+	g.Expect(annotations).To(HaveKey(provenanceKey))
+	provenance, err := ParseProvenance(annotations[provenanceKey])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(provenance.Source).To(Equal(DataSourceAPI))
+	g.Expect(provenance.Region).To(Equal("us-east-1"))
+	g.Expect(provenance.FetchedAt).ToNot(BeZero())
+	delete(annotations, provenanceKey)
+}
+
 // newTestMachineDeployment creates a test CAPI MachineDeployment with supporting infrastructure
 func newTestMachineDeployment(namespace, instanceType string, existingAnnotations map[string]string) (*clusterv1.MachineDeployment, *infrav1.AWSMachineTemplate, *clusterv1.Cluster, *infrav1.AWSCluster, error) {
 	annotations := make(map[string]string)
@@ -664,3 +878,323 @@ func newTestMachineDeployment(namespace, instanceType string, existingAnnotation
 
 	return machineDeployment, awsMachineTemplate, cluster, awsCluster, nil
 }
+
+// newTestReconciler creates a Reconciler using a fake Kubernetes client holding the given objects and
+// the fake AWS client.
+func newTestReconciler(g Gomega, objs ...client.Object) *Reconciler {
+	testScheme := runtime.NewScheme()
+	g.Expect(scheme.AddToScheme(testScheme)).To(Succeed())
+	g.Expect(clusterv1.AddToScheme(testScheme)).To(Succeed())
+	g.Expect(infrav1.AddToScheme(testScheme)).To(Succeed())
+	g.Expect(expv1.AddToScheme(testScheme)).To(Succeed())
+
+	fakeK8sClient := fake.NewClientBuilder().
+		WithScheme(testScheme).
+		WithObjects(objs...).
+		Build()
+
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	return &Reconciler{
+		Client:   fakeK8sClient,
+		recorder: record.NewFakeRecorder(10),
+		AwsClientBuilder: func(client client.Client, secretName, namespace, region string, regionCache awsclient.RegionCache) (awsclient.Client, error) {
+			return fakeAWSClient, nil
+		},
+		InstanceTypesCache:     NewInstanceTypesCache(),
+		AvailabilityZonesCache: NewAvailabilityZonesCache(),
+	}
+}
+
+func TestReconcileWithOmitZeroGPU(t *testing.T) {
+	testCases := []struct {
+		name                string
+		instanceType        string
+		existingAnnotations map[string]string
+		expectGPU           bool
+	}{
+		{
+			name:         "non-GPU type omits the GPU annotation",
+			instanceType: "a1.2xlarge",
+		},
+		{
+			name:         "previously written GPU annotation is removed",
+			instanceType: "a1.2xlarge",
+			existingAnnotations: map[string]string{
+				gpuKey: "0",
+			},
+		},
+		{
+			name:         "GPU type keeps the GPU annotation",
+			instanceType: "p2.16xlarge",
+			expectGPU:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", tc.instanceType, tc.existingAnnotations)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+			r.OmitZeroGPU = true
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			g.Expect(err).ToNot(HaveOccurred())
+			if tc.expectGPU {
+				g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(gpuKey, "16"))
+			} else {
+				g.Expect(machineDeployment.Annotations).ToNot(HaveKey(gpuKey))
+			}
+			g.Expect(machineDeployment.Annotations).To(HaveKey(cpuKey))
+		})
+	}
+}
+
+func TestWhatIf(t *testing.T) {
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := Reconciler{
+		AwsClientBuilder: func(client client.Client, secretName, namespace, region string, regionCache awsclient.RegionCache) (awsclient.Client, error) {
+			return fakeAWSClient, nil
+		},
+		InstanceTypesCache: NewInstanceTypesCache(),
+	}
+
+	testCases := []struct {
+		name           string
+		region         string
+		instanceType   string
+		expectErr      bool
+		expectedCPU    string
+		expectedLabels string
+		expectedMemory string
+	}{
+		{
+			name:           "known instance type",
+			region:         "us-east-1",
+			instanceType:   "a1.2xlarge",
+			expectedCPU:    "8",
+			expectedLabels: "kubernetes.io/arch=amd64",
+			expectedMemory: "16384",
+		},
+		{
+			name:         "missing region",
+			instanceType: "a1.2xlarge",
+			expectErr:    true,
+		},
+		{
+			name:      "missing instance type",
+			region:    "us-east-1",
+			expectErr: true,
+		},
+		{
+			name:         "unknown instance type",
+			region:       "us-east-1",
+			instanceType: "unknown.instance",
+			expectErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			annotations, err := r.WhatIf(tc.region, tc.instanceType)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(annotations).To(HaveKeyWithValue(cpuKey, tc.expectedCPU))
+			g.Expect(annotations).To(HaveKeyWithValue(memoryKey, tc.expectedMemory))
+			g.Expect(annotations).To(HaveKeyWithValue(labelsKey, tc.expectedLabels))
+			g.Expect(annotations).To(HaveKey(provenanceKey))
+		})
+	}
+}
+
+func TestReconcileResultMetric(t *testing.T) {
+	testCases := []struct {
+		name           string
+		namespace      string
+		instanceType   string
+		crossNamespace bool
+		expectedResult string
+	}{
+		{
+			name:           "with a valid instanceType",
+			namespace:      "result-success",
+			instanceType:   "a1.2xlarge",
+			expectedResult: metrics.ResultSuccess,
+		},
+		{
+			name:           "with an invalid instanceType",
+			namespace:      "result-failed",
+			instanceType:   "invalid",
+			expectedResult: metrics.ResultFailed,
+		},
+		{
+			name:           "with a denied cross-namespace template",
+			namespace:      "result-forbidden",
+			instanceType:   "a1.2xlarge",
+			crossNamespace: true,
+			expectedResult: metrics.ResultForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			metrics.SetNamespaceAllowList([]string{tc.namespace})
+			defer metrics.SetNamespaceAllowList(nil)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment(tc.namespace, tc.instanceType, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Name = "result"
+			if tc.crossNamespace {
+				machineDeployment.Spec.Template.Spec.InfrastructureRef.Namespace = "templates"
+			}
+
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+			r.DenyCrossNamespaceTemplates = true
+
+			_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			for _, result := range []string{metrics.ResultSuccess, metrics.ResultError, metrics.ResultFailed, metrics.ResultForbidden} {
+				expected := 0.0
+				if result == tc.expectedResult {
+					expected = 1
+				}
+				g.Expect(testutil.ToFloat64(metrics.ReconcileTotal.WithLabelValues(tc.namespace, result))).To(Equal(expected), result)
+			}
+		})
+	}
+}
+
+func TestReconcileForgetsInstanceTypeUse(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "switching"
+	awsCluster.Spec.Region = "ap-south-2"
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	series := testutil.CollectAndCount(metrics.InstanceTypeVCPU)
+
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(testutil.CollectAndCount(metrics.InstanceTypeVCPU)).To(Equal(series + 1))
+
+	// Switching to an unknown instance type must not keep exporting the capacity of the previous one
+	g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(awsMachineTemplate), awsMachineTemplate)).To(Succeed())
+	awsMachineTemplate.Spec.Template.Spec.InstanceType = "invalid"
+	g.Expect(r.Client.Update(ctx, awsMachineTemplate)).To(Succeed())
+
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(testutil.CollectAndCount(metrics.InstanceTypeVCPU)).To(Equal(series))
+}
+
+func TestReconcileWithDeletingCluster(t *testing.T) {
+	testCases := []struct {
+		name            string
+		clusterDeleting bool
+		expectAnnotated bool
+	}{
+		{
+			name:            "cluster not deleting",
+			expectAnnotated: true,
+		},
+		{
+			name:            "cluster deleting",
+			clusterDeleting: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Name = "teardown"
+			objs := []client.Object{machineDeployment, awsMachineTemplate, cluster, awsCluster}
+			if tc.clusterDeleting {
+				// The AWSCluster is already gone, which would fail the region lookup
+				cluster.DeletionTimestamp = ptr.To(metav1.Now())
+				cluster.Finalizers = []string{"cluster.cluster.x-k8s.io"}
+				objs = []client.Object{machineDeployment, awsMachineTemplate, cluster}
+			}
+
+			r := newTestReconciler(g, objs...)
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+
+			_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(recorder.Events).To(BeEmpty())
+
+			updated := &clusterv1.MachineDeployment{}
+			g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machineDeployment), updated)).To(Succeed())
+			if tc.expectAnnotated {
+				g.Expect(updated.Annotations).To(HaveKey(cpuKey))
+			} else {
+				g.Expect(updated.Annotations).ToNot(HaveKey(cpuKey))
+			}
+		})
+	}
+}
+
+func TestReconcileWithEKSCluster(t *testing.T) {
+	testCases := []struct {
+		name string
+		// infrastructureRef references the AWSManagedControlPlane instead of an AWSManagedCluster
+		controlPlaneAsInfrastructure bool
+	}{
+		{
+			name: "control plane reference",
+		},
+		{
+			name:                         "infrastructure reference",
+			controlPlaneAsInfrastructure: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, _, _, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			cluster, controlPlane := newTestEKSCluster("default", "eu-west-1")
+			if tc.controlPlaneAsInfrastructure {
+				cluster.Spec.InfrastructureRef, cluster.Spec.ControlPlaneRef = cluster.Spec.ControlPlaneRef, nil
+			}
+			machineDeployment.Name = "eks-workers"
+			machineDeployment.Spec.ClusterName = cluster.Name
+
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, controlPlane)
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+
+			_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(recorder.Events).To(BeEmpty())
+
+			updated := &clusterv1.MachineDeployment{}
+			g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machineDeployment), updated)).To(Succeed())
+			g.Expect(updated.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+			g.Expect(updated.Annotations).To(HaveKeyWithValue(provenanceKey, ContainSubstring("region=eu-west-1")))
+		})
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	utils "github.com/jhjaggars/capa-annotator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+// KubeadmControlPlaneGVK is the group version kind of the KubeadmControlPlanes. They are handled as unstructured
+// objects, so that the controller does not depend on the kubeadm bootstrap and control plane API packages.
+var KubeadmControlPlaneGVK = schema.GroupVersionKind{Group: "controlplane.cluster.x-k8s.io", Version: "v1beta1", Kind: "KubeadmControlPlane"}
+
+// newKubeadmControlPlane returns an empty unstructured KubeadmControlPlane.
+func newKubeadmControlPlane() *unstructured.Unstructured {
+	controlPlane := &unstructured.Unstructured{}
+	controlPlane.SetGroupVersionKind(KubeadmControlPlaneGVK)
+	return controlPlane
+}
+
+// ControlPlaneReconciler writes the capacity annotations of the AWSMachineTemplate of KubeadmControlPlanes to the
+// KubeadmControlPlanes. The cluster-autoscaler does not scale control planes, but the capacity is used by tooling,
+// e.g. for control plane right-sizing reports. It shares the configuration and caches of the MachineDeployment Reconciler.
+type ControlPlaneReconciler struct {
+	Reconciler *Reconciler
+
+	recorder record.EventRecorder
+}
+
+// SetupWithManager creates a new controller for a manager.
+func (c *ControlPlaneReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	_, err := ctrl.NewControllerManagedBy(mgr).
+		For(newKubeadmControlPlane()).
+		WithOptions(options).
+		Build(c)
+
+	if err != nil {
+		return fmt.Errorf("failed setting up with a controller manager: %w", err)
+	}
+
+	c.recorder = mgr.GetEventRecorderFor("kubeadmcontrolplane-controller")
+	if c.Reconciler.AuditSink != nil {
+		c.recorder = &auditRecorder{EventRecorder: c.recorder, sink: c.Reconciler.AuditSink}
+	}
+	return nil
+}
+
+// Reconcile implements controller runtime Reconciler interface.
+func (c *ControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r := c.Reconciler
+
+	controlPlane := newKubeadmControlPlane()
+	if err := r.Client.Get(ctx, req.NamespacedName, controlPlane); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if controlPlane.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, nil
+	}
+
+	// The template and region resolvers, and the template policy, operate on MachineDeployments
+	view, err := machineDeploymentView(controlPlane)
+	if err != nil {
+		c.recorder.Eventf(controlPlane, corev1.EventTypeWarning, "FailedUpdate", "Failed to read machine template: %v", err)
+		return ctrl.Result{}, nil
+	}
+	deleting, err := r.clusterDeleting(ctx, view)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if deleting {
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.checkTemplateNamespace(view); err != nil {
+		klog.Errorf("Refusing to resolve AWSMachineTemplate: %v", err)
+		c.recorder.Eventf(controlPlane, corev1.EventTypeWarning, "Forbidden", "Refusing to resolve AWSMachineTemplate: %v", err)
+		return ctrl.Result{}, nil
+	}
+
+	awsMachineTemplate, err := r.templateResolver().ResolveAWSMachineTemplate(ctx, r.Client, view)
+	if err != nil {
+		c.recorder.Eventf(controlPlane, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve AWSMachineTemplate: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	instanceType, err := utils.ExtractInstanceType(awsMachineTemplate)
+	if err != nil {
+		c.recorder.Eventf(controlPlane, corev1.EventTypeWarning, "FailedUpdate", "Failed to extract instance type: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	region, err := r.regionResolver().ResolveRegion(ctx, r.Client, view)
+	if err != nil {
+		c.recorder.Eventf(controlPlane, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve AWS region: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	return r.annotateUnstructured(ctx, c.recorder, controlPlane, region, instanceType)
+}
+
+// annotateUnstructured writes the capacity annotations of the instance type in the region to an unstructured object,
+// e.g. a KubeadmControlPlane or an AWSMachinePool. Warning events are recorded on the object.
+func (r *Reconciler) annotateUnstructured(ctx context.Context, recorder record.EventRecorder, obj *unstructured.Unstructured, region, instanceType string) (ctrl.Result, error) {
+	awsClient, err := r.AwsClientBuilder(r.Client, "", obj.GetNamespace(), region, r.RegionCache)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error creating aws client: %w", err)
+	}
+
+	instanceTypeInfo, err := r.InstanceTypesCache.GetInstanceType(awsClient, region, instanceType)
+	if err != nil {
+		klog.Errorf("Unable to set capacity annotations of %s %s/%s: unknown instance type %s: %v", obj.GetKind(), obj.GetNamespace(), obj.GetName(), instanceType, err)
+		recorder.Eventf(obj, corev1.EventTypeWarning, "FailedUpdate", "Failed to set capacity annotations, instance type unknown")
+		return ctrl.Result{}, nil
+	}
+
+	capacity, err := r.postProcessCapacity(nil, instanceTypeInfo)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error post-processing capacity of instance type %s: %w", instanceType, err)
+	}
+
+	original := obj.DeepCopy()
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	r.setCapacityAnnotations(annotations, capacity, region, nil)
+	obj.SetAnnotations(annotations)
+
+	patch := client.MergeFrom(original)
+	if patchEmpty(obj, patch) {
+		return ctrl.Result{}, nil
+	}
+	if err := r.Client.Patch(ctx, obj, patch); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to patch %s: %w", obj.GetKind(), err)
+	}
+	r.auditAnnotationChanges(obj, original.GetAnnotations())
+	return ctrl.Result{}, nil
+}
+
+// machineDeploymentView returns a MachineDeployment with the namespace, name, annotations, Cluster and
+// infrastructure template of the KubeadmControlPlane, so that it can be passed to the resolvers.
+// It is never written.
+func machineDeploymentView(controlPlane *unstructured.Unstructured) (*clusterv1.MachineDeployment, error) {
+	infrastructureRef := corev1.ObjectReference{}
+	ref, found, err := unstructured.NestedMap(controlPlane.Object, "spec", "machineTemplate", "infrastructureRef")
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec.machineTemplate.infrastructureRef: %w", err)
+	}
+	if found {
+		infrastructureRef.APIVersion, _, _ = unstructured.NestedString(ref, "apiVersion")
+		infrastructureRef.Kind, _, _ = unstructured.NestedString(ref, "kind")
+		infrastructureRef.Name, _, _ = unstructured.NestedString(ref, "name")
+		infrastructureRef.Namespace, _, _ = unstructured.NestedString(ref, "namespace")
+	}
+
+	return newMachineDeploymentView(controlPlane, infrastructureRef), nil
+}
+
+// newMachineDeploymentView returns a MachineDeployment with the namespace, name, annotations and Cluster of
+// the object and the given infrastructure template. The Cluster is taken from the cluster name label,
+// falling back to the owner references.
+func newMachineDeploymentView(obj *unstructured.Unstructured, infrastructureRef corev1.ObjectReference) *clusterv1.MachineDeployment {
+	clusterName := obj.GetLabels()[clusterv1.ClusterNameLabel]
+	if clusterName == "" {
+		for _, ownerReference := range obj.GetOwnerReferences() {
+			if ownerReference.Kind == "Cluster" {
+				clusterName = ownerReference.Name
+			}
+		}
+	}
+
+	view := &clusterv1.MachineDeployment{
+		Spec: clusterv1.MachineDeploymentSpec{
+			ClusterName: clusterName,
+			Template: clusterv1.MachineTemplateSpec{
+				Spec: clusterv1.MachineSpec{
+					ClusterName:       clusterName,
+					InfrastructureRef: infrastructureRef,
+				},
+			},
+		},
+	}
+	view.Namespace = obj.GetNamespace()
+	view.Name = obj.GetName()
+	view.Annotations = obj.GetAnnotations()
+	return view
+}
@@ -0,0 +1,126 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newTestKubeadmControlPlane returns a KubeadmControlPlane of the Cluster using the AWSMachineTemplate.
+func newTestKubeadmControlPlane(namespace, clusterName, templateName string) *unstructured.Unstructured {
+	controlPlane := newKubeadmControlPlane()
+	controlPlane.SetNamespace(namespace)
+	controlPlane.SetName("control-plane")
+	controlPlane.SetLabels(map[string]string{clusterv1.ClusterNameLabel: clusterName})
+	controlPlane.Object["spec"] = map[string]interface{}{
+		"replicas": int64(3),
+		"version":  "v1.32.0",
+		"machineTemplate": map[string]interface{}{
+			"infrastructureRef": map[string]interface{}{
+				"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta2",
+				"kind":       "AWSMachineTemplate",
+				"name":       templateName,
+			},
+		},
+	}
+	return controlPlane
+}
+
+func TestControlPlaneReconciler(t *testing.T) {
+	testCases := []struct {
+		name              string
+		instanceType      string
+		expectAnnotations bool
+		expectedEvent     string
+	}{
+		{
+			name:              "with a valid instanceType",
+			instanceType:      "a1.2xlarge",
+			expectAnnotations: true,
+		},
+		{
+			name:          "with an invalid instanceType",
+			instanceType:  "invalid",
+			expectedEvent: corev1.EventTypeWarning + " FailedUpdate ",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			_, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", tc.instanceType, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			controlPlane := newTestKubeadmControlPlane("default", cluster.Name, awsMachineTemplate.Name)
+
+			r := newTestReconciler(g, controlPlane, awsMachineTemplate, cluster, awsCluster)
+			recorder := record.NewFakeRecorder(10)
+			c := &ControlPlaneReconciler{Reconciler: r, recorder: recorder}
+
+			_, err = c.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(controlPlane)})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			updated := newKubeadmControlPlane()
+			g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(controlPlane), updated)).To(Succeed())
+			if tc.expectAnnotations {
+				g.Expect(updated.GetAnnotations()).To(HaveKeyWithValue(cpuKey, "8"))
+				g.Expect(updated.GetAnnotations()).To(HaveKeyWithValue(memoryKey, "16384"))
+				g.Expect(updated.GetAnnotations()).To(HaveKeyWithValue(labelsKey, "kubernetes.io/arch=amd64"))
+				g.Expect(updated.GetAnnotations()).To(HaveKey(provenanceKey))
+				// The spec is left untouched
+				g.Expect(updated.Object["spec"]).To(Equal(controlPlane.Object["spec"]))
+			} else {
+				g.Expect(updated.GetAnnotations()).ToNot(HaveKey(cpuKey))
+			}
+
+			if tc.expectedEvent == "" {
+				g.Expect(recorder.Events).To(BeEmpty())
+				return
+			}
+			g.Expect(recorder.Events).To(Receive(HavePrefix(tc.expectedEvent)))
+		})
+	}
+}
+
+func TestMachineDeploymentView(t *testing.T) {
+	g := NewWithT(t)
+
+	controlPlane := newTestKubeadmControlPlane("default", "", "template")
+	controlPlane.SetOwnerReferences(nil)
+	controlPlane.SetAnnotations(map[string]string{"capa.infrastructure.cluster.x-k8s.io/region": "eu-west-1"})
+
+	view, err := machineDeploymentView(controlPlane)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(view.Namespace).To(Equal("default"))
+	g.Expect(view.Name).To(Equal("control-plane"))
+	g.Expect(view.Spec.ClusterName).To(BeEmpty())
+	g.Expect(view.Spec.Template.Spec.InfrastructureRef.Kind).To(Equal("AWSMachineTemplate"))
+	g.Expect(view.Spec.Template.Spec.InfrastructureRef.Name).To(Equal("template"))
+	g.Expect(view.Annotations).To(HaveKey("capa.infrastructure.cluster.x-k8s.io/region"))
+
+	// Without the cluster name label, the Cluster is taken from the owner references
+	controlPlane.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: clusterv1.GroupVersion.String(), Kind: "Cluster", Name: "owner"}})
+	view, err = machineDeploymentView(controlPlane)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(view.Spec.ClusterName).To(Equal("owner"))
+}
@@ -0,0 +1,62 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// LoadVCPUCorrections reads the vCPU corrections from a YAML file mapping instance types to the number of
+// vCPUs their nodes report to the kubelet.
+func LoadVCPUCorrections(path string) (map[string]int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vCPU corrections: %w", err)
+	}
+	return ParseVCPUCorrections(data)
+}
+
+// ParseVCPUCorrections parses and validates YAML mapping instance types to the number of vCPUs their nodes report.
+func ParseVCPUCorrections(data []byte) (map[string]int64, error) {
+	corrections := map[string]int64{}
+	if err := yaml.UnmarshalStrict(data, &corrections); err != nil {
+		return nil, fmt.Errorf("failed to parse vCPU corrections: %w", err)
+	}
+	for instanceType, vcpu := range corrections {
+		if vcpu <= 0 {
+			return nil, fmt.Errorf("vCPU correction of instance type %q must be positive", instanceType)
+		}
+	}
+	return corrections, nil
+}
+
+// correctVCPU replaces the vCPUs reported by the EC2 API with the configured correction of the instance type,
+// e.g. for bare metal instance types whose nodes run with a different number of threads per core than
+// DescribeInstanceTypes assumes. Applied corrections are counted, so stale entries can be spotted.
+func (r *Reconciler) correctVCPU(capacity InstanceType) InstanceType {
+	vcpu, ok := r.VCPUCorrections[capacity.InstanceType]
+	if !ok || vcpu == capacity.VCPU {
+		return capacity
+	}
+
+	klog.V(4).Infof("Correcting the vCPUs of instance type %s from %d to %d", capacity.InstanceType, capacity.VCPU, vcpu)
+	metrics.RecordVCPUCorrection(capacity.InstanceType)
+	capacity.VCPU = vcpu
+	return capacity
+}
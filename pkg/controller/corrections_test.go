@@ -0,0 +1,62 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseVCPUCorrections(t *testing.T) {
+	g := NewWithT(t)
+
+	corrections, err := ParseVCPUCorrections([]byte("c7i.metal-24xl: 48\n"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(corrections).To(Equal(map[string]int64{"c7i.metal-24xl": 48}))
+
+	_, err = ParseVCPUCorrections([]byte("c7i.metal-24xl: 0\n"))
+	g.Expect(err).To(MatchError(ContainSubstring("must be positive")))
+
+	_, err = ParseVCPUCorrections([]byte("c7i.metal-24xl: all\n"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestPostProcessCapacityCorrectsVCPU(t *testing.T) {
+	g := NewWithT(t)
+
+	r := &Reconciler{
+		VCPUCorrections:        map[string]int64{"m7i.metal-24xl": 48, "a1.2xlarge": 8},
+		CapacityPostProcessors: []CapacityPostProcessor{builtinCapacityPostProcessors["reserve-cpu"](1)},
+	}
+	before := testutil.ToFloat64(metrics.VCPUCorrections.WithLabelValues("m7i.metal-24xl"))
+
+	// The post-processors apply to the corrected vCPUs
+	capacity, err := r.postProcessCapacity(nil, InstanceType{InstanceType: "m7i.metal-24xl", VCPU: 96})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(capacity.VCPU).To(Equal(int64(47)))
+	g.Expect(testutil.ToFloat64(metrics.VCPUCorrections.WithLabelValues("m7i.metal-24xl"))).To(Equal(before + 1))
+
+	// Corrections matching the reported vCPUs are not counted
+	capacity, err = r.postProcessCapacity(nil, InstanceType{InstanceType: "a1.2xlarge", VCPU: 8})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(capacity.VCPU).To(Equal(int64(7)))
+	g.Expect(testutil.ToFloat64(metrics.VCPUCorrections.WithLabelValues("a1.2xlarge"))).To(BeZero())
+
+	capacity, err = r.postProcessCapacity(nil, InstanceType{InstanceType: "m5.large", VCPU: 2})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(capacity.VCPU).To(Equal(int64(1)))
+}
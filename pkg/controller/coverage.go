@@ -0,0 +1,194 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// DefaultCoverageWindows are the windows the burn rate of the annotation coverage SLO is exported for, following
+// the common multi-window alerting on fast and slow burns.
+var DefaultCoverageWindows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour}
+
+// Coverage is the annotation coverage of the MachineDeployments visible to the reconciler at one point in time.
+type Coverage struct {
+	// InScope is the number of MachineDeployments not being deleted.
+	InScope int
+	// Annotated is the number of MachineDeployments in scope with complete annotations.
+	Annotated int
+	// Violations is the number of MachineDeployments in scope without complete annotations after the grace period.
+	Violations int
+}
+
+// BuildCoverage checks the annotations of all MachineDeployments visible to the reconciler. MachineDeployments
+// without complete annotations count as violations once they are older than the grace period.
+// MachineDeployments not annotated by the reconciler, e.g. because they opted out, are not in scope.
+func (r *Reconciler) BuildCoverage(ctx context.Context, now time.Time, grace time.Duration) (Coverage, error) {
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := r.Client.List(ctx, machineDeployments); err != nil {
+		return Coverage{}, fmt.Errorf("failed to list MachineDeployments: %w", err)
+	}
+
+	coverage := Coverage{}
+	for i := range machineDeployments.Items {
+		machineDeployment := &machineDeployments.Items[i]
+		if !machineDeployment.DeletionTimestamp.IsZero() || !r.inScope(machineDeployment) {
+			continue
+		}
+
+		coverage.InScope++
+		if r.hasCompleteAnnotations(machineDeployment.Annotations) {
+			coverage.Annotated++
+		} else if now.Sub(machineDeployment.CreationTimestamp.Time) > grace {
+			coverage.Violations++
+		}
+	}
+	return coverage, nil
+}
+
+// ParseCoverageWindows parses a comma-separated list of burn rate windows, e.g. "5m,1h,6h".
+func ParseCoverageWindows(value string) ([]time.Duration, error) {
+	windows := []time.Duration{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		window, err := time.ParseDuration(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", entry, err)
+		}
+		if window <= 0 {
+			return nil, fmt.Errorf("window %q must be positive", entry)
+		}
+		windows = append(windows, window)
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("at least one window is required")
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i] < windows[j] })
+	return windows, nil
+}
+
+// coverageSample is the coverage measured at one evaluation.
+type coverageSample struct {
+	at       time.Time
+	coverage Coverage
+}
+
+// CoverageSLO periodically measures the annotation coverage of the MachineDeployments and exports it together
+// with the burn rates of the SLO "Target of the MachineDeployments are annotated within Grace of their creation".
+// The samples of the longest window are kept in memory. Access to the samples is synchronized via mutex.
+type CoverageSLO struct {
+	// Reconciler is used to list the MachineDeployments.
+	Reconciler *Reconciler
+	// Interval is the interval between two measurements.
+	Interval time.Duration
+	// Target is the share of MachineDeployments annotated within Grace, e.g. 0.99.
+	Target float64
+	// Grace is the duration after the creation of a MachineDeployment within which it must be annotated.
+	Grace time.Duration
+	// Windows are the windows the burn rate is exported for.
+	Windows []time.Duration
+
+	samples []coverageSample
+	mutex   sync.Mutex
+	now     func() time.Time
+}
+
+// Start measures the coverage immediately and then once per interval until the context is cancelled.
+// It implements the controller-runtime Runnable interface.
+func (c *CoverageSLO) Start(ctx context.Context) error {
+	metrics.CoverageTarget.Set(c.Target)
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.evaluate(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection returns false, the coverage is read-only and exported on every replica.
+func (c *CoverageSLO) NeedLeaderElection() bool {
+	return false
+}
+
+// evaluate measures the coverage and exports it with the burn rates.
+func (c *CoverageSLO) evaluate(ctx context.Context) {
+	now := time.Now()
+	if c.now != nil {
+		now = c.now()
+	}
+
+	coverage, err := c.Reconciler.BuildCoverage(ctx, now, c.Grace)
+	if err != nil {
+		klog.Errorf("Failed to measure annotation coverage: %v", err)
+		return
+	}
+	metrics.SetCoverage(coverage.InScope, coverage.Annotated, coverage.Violations)
+
+	for window, burnRate := range c.record(now, coverage) {
+		metrics.SetCoverageBurnRate(window, burnRate)
+	}
+	klog.V(2).Infof("Annotation coverage: %d of %d MachineDeployments annotated, %d violations", coverage.Annotated, coverage.InScope, coverage.Violations)
+}
+
+// record adds the coverage to the samples, drops the samples older than the longest window and returns the burn
+// rate of each window: the share of violations among the MachineDeployments in scope of the samples within the
+// window, divided by the error budget 1 - Target.
+func (c *CoverageSLO) record(now time.Time, coverage Coverage) map[time.Duration]float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.samples = append(c.samples, coverageSample{at: now, coverage: coverage})
+	longest := c.Windows[len(c.Windows)-1]
+	for len(c.samples) > 0 && now.Sub(c.samples[0].at) > longest {
+		c.samples = c.samples[1:]
+	}
+
+	errorBudget := 1 - c.Target
+	burnRates := map[time.Duration]float64{}
+	for _, window := range c.Windows {
+		inScope, violations := 0, 0
+		for _, sample := range c.samples {
+			if now.Sub(sample.at) <= window {
+				inScope += sample.coverage.InScope
+				violations += sample.coverage.Violations
+			}
+		}
+
+		burnRate := 0.0
+		if inScope > 0 && errorBudget > 0 {
+			burnRate = float64(violations) / float64(inScope) / errorBudget
+		}
+		burnRates[window] = burnRate
+	}
+	return burnRates
+}
@@ -0,0 +1,82 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildCoverage(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	annotated, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", map[string]string{
+		cpuKey: "8", memoryKey: "16384", gpuKey: "0", labelsKey: "kubernetes.io/arch=amd64",
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	annotated.Name = "annotated"
+	fresh, _, _, _, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	fresh.Name = "fresh"
+	fresh.CreationTimestamp = metav1.NewTime(now.Add(-time.Minute))
+	stale, _, _, _, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	stale.Name = "stale"
+	stale.CreationTimestamp = metav1.NewTime(now.Add(-time.Hour))
+
+	r := newTestReconciler(g, annotated, fresh, stale, awsMachineTemplate, cluster, awsCluster)
+	coverage, err := r.BuildCoverage(ctx, now, 5*time.Minute)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(coverage).To(Equal(Coverage{InScope: 3, Annotated: 1, Violations: 1}))
+}
+
+func TestParseCoverageWindows(t *testing.T) {
+	g := NewWithT(t)
+
+	windows, err := ParseCoverageWindows("1h, 5m")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(windows).To(Equal([]time.Duration{5 * time.Minute, time.Hour}))
+
+	_, err = ParseCoverageWindows("0s")
+	g.Expect(err).To(HaveOccurred())
+	_, err = ParseCoverageWindows("")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestCoverageSLOBurnRate(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	slo := &CoverageSLO{Target: 0.9, Windows: []time.Duration{5 * time.Minute, time.Hour}}
+
+	// A violation among 10 MachineDeployments consumes the error budget of 10% at exactly the allowed rate
+	burnRates := slo.record(now, Coverage{InScope: 10, Violations: 1})
+	g.Expect(burnRates[5*time.Minute]).To(BeNumerically("~", 1))
+	g.Expect(burnRates[time.Hour]).To(BeNumerically("~", 1))
+
+	// Once the violation is fixed, the short window recovers first
+	now = now.Add(10 * time.Minute)
+	burnRates = slo.record(now, Coverage{InScope: 10})
+	g.Expect(burnRates[5*time.Minute]).To(BeZero())
+	g.Expect(burnRates[time.Hour]).To(BeNumerically("~", 0.5))
+
+	// Samples older than the longest window are dropped
+	now = now.Add(2 * time.Hour)
+	slo.record(now, Coverage{InScope: 10})
+	g.Expect(slo.samples).To(HaveLen(1))
+}
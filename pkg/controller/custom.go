@@ -0,0 +1,132 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// CustomAnnotation is an additional annotation rendered from a Go template over the capacity of the instance type,
+// covering site-specific metadata without code changes.
+type CustomAnnotation struct {
+	// Key is the annotation key.
+	Key string
+	// Template renders the annotation value from CustomAnnotationData.
+	Template *template.Template
+}
+
+// CustomAnnotationData is the data custom annotation templates are rendered with, e.g. "{{ .VCPU }}x{{ .MemoryGiB }}GiB".
+type CustomAnnotationData struct {
+	InstanceType    string
+	Region          string
+	VCPU            int64
+	MemoryMb        int64
+	MemoryGiB       float64
+	GPU             int64
+	GPUManufacturer string
+	GPUName         string
+	Architecture    string
+}
+
+// sampleCustomAnnotationData is used to validate the templates at startup.
+var sampleCustomAnnotationData = CustomAnnotationData{
+	InstanceType:    "g4dn.xlarge",
+	Region:          "us-east-1",
+	VCPU:            4,
+	MemoryMb:        16384,
+	MemoryGiB:       16,
+	GPU:             1,
+	GPUManufacturer: "NVIDIA",
+	GPUName:         "T4",
+	Architecture:    string(ArchitectureAmd64),
+}
+
+// LoadCustomAnnotations reads the custom annotations from a YAML file mapping annotation keys to Go templates.
+func LoadCustomAnnotations(path string) ([]CustomAnnotation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read custom annotations: %w", err)
+	}
+	return ParseCustomAnnotations(data)
+}
+
+// ParseCustomAnnotations parses and validates YAML mapping annotation keys to Go templates. The templates are
+// rendered once with sample data, so that references to unknown fields are rejected at startup.
+func ParseCustomAnnotations(data []byte) ([]CustomAnnotation, error) {
+	templates := map[string]string{}
+	if err := yaml.UnmarshalStrict(data, &templates); err != nil {
+		return nil, fmt.Errorf("failed to parse custom annotations: %w", err)
+	}
+
+	annotations := make([]CustomAnnotation, 0, len(templates))
+	for key, text := range templates {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid custom annotation key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if err := ValidateAdditionalMemoryKey(key); err != nil {
+			return nil, fmt.Errorf("invalid custom annotation key: %w", err)
+		}
+
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of custom annotation %q: %w", key, err)
+		}
+		if err := tmpl.Execute(&bytes.Buffer{}, sampleCustomAnnotationData); err != nil {
+			return nil, fmt.Errorf("invalid template of custom annotation %q: %w", key, err)
+		}
+		annotations = append(annotations, CustomAnnotation{Key: key, Template: tmpl})
+	}
+	sort.Slice(annotations, func(i, j int) bool { return annotations[i].Key < annotations[j].Key })
+	return annotations, nil
+}
+
+// customAnnotationData returns the data the custom annotation templates are rendered with.
+func customAnnotationData(instanceTypeInfo InstanceType, region string) CustomAnnotationData {
+	return CustomAnnotationData{
+		InstanceType:    instanceTypeInfo.InstanceType,
+		Region:          region,
+		VCPU:            instanceTypeInfo.VCPU,
+		MemoryMb:        instanceTypeInfo.MemoryMb,
+		MemoryGiB:       float64(instanceTypeInfo.MemoryMb) / 1024,
+		GPU:             instanceTypeInfo.GPU,
+		GPUManufacturer: instanceTypeInfo.GPUManufacturer,
+		GPUName:         instanceTypeInfo.GPUName,
+		Architecture:    string(instanceTypeInfo.CPUArchitecture),
+	}
+}
+
+// renderCustomAnnotations renders the custom annotations for the instance type. Annotations whose template fails
+// to render are left out, so that a stale value is removed.
+func (r *Reconciler) renderCustomAnnotations(instanceTypeInfo InstanceType, region string) map[string]string {
+	data := customAnnotationData(instanceTypeInfo, region)
+	values := map[string]string{}
+	for _, annotation := range r.CustomAnnotations {
+		value := &bytes.Buffer{}
+		if err := annotation.Template.Execute(value, data); err != nil {
+			klog.Errorf("Failed to render custom annotation %s for instance type %s: %v", annotation.Key, instanceTypeInfo.InstanceType, err)
+			continue
+		}
+		values[annotation.Key] = value.String()
+	}
+	return values
+}
@@ -0,0 +1,61 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseCustomAnnotations(t *testing.T) {
+	g := NewWithT(t)
+
+	annotations, err := ParseCustomAnnotations([]byte(`
+example.com/shape: "{{ .VCPU }}x{{ .MemoryGiB }}GiB"
+example.com/family: '{{ index (split .InstanceType ".") 0 }}'
+`))
+	g.Expect(err).To(MatchError(ContainSubstring(`function "split" not defined`)))
+	g.Expect(annotations).To(BeNil())
+
+	annotations, err = ParseCustomAnnotations([]byte(`
+example.com/shape: "{{ .VCPU }}x{{ .MemoryGiB }}GiB"
+example.com/region: "{{ .Region }}"
+`))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(annotations).To(HaveLen(2))
+	g.Expect(annotations[0].Key).To(Equal("example.com/region"))
+
+	_, err = ParseCustomAnnotations([]byte(`example.com/cores: "{{ .Cores }}"`))
+	g.Expect(err).To(MatchError(ContainSubstring("can't evaluate field Cores")))
+
+	_, err = ParseCustomAnnotations([]byte(`machine.openshift.io/vCPU: "{{ .VCPU }}"`))
+	g.Expect(err).To(MatchError(ContainSubstring("is written by the controller")))
+}
+
+func TestReconcileWithCustomAnnotations(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.CustomAnnotations, err = ParseCustomAnnotations([]byte(`example.com/shape: "{{ .VCPU }}x{{ .MemoryGiB }}GiB {{ .Architecture }} in {{ .Region }}"`))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue("example.com/shape", "8x16GiB amd64 in us-east-1"))
+	g.Expect(getManagedKeys(machineDeployment.Annotations)).To(ContainElement("example.com/shape"))
+}
@@ -16,11 +16,17 @@ package controller
 import (
 	"errors"
 	"fmt"
+	"maps"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	"golang.org/x/sync/singleflight"
 	"k8s.io/klog/v2"
 )
 
@@ -34,6 +40,16 @@ const (
 	ArchitectureArm64 normalizedArch = "arm64"
 )
 
+// DataSource describes where instance type information was obtained from.
+type DataSource string
+
+const (
+	// DataSourceAPI means the information was fetched from the EC2 API during the lookup.
+	DataSourceAPI DataSource = "api"
+	// DataSourceCache means the information was served from the instance types cache.
+	DataSourceCache DataSource = "cache"
+)
+
 // InstanceType holds some of the instance type information that we need to store.
 type InstanceType struct {
 	InstanceType    string
@@ -41,6 +57,24 @@ type InstanceType struct {
 	MemoryMb        int64
 	GPU             int64
 	CPUArchitecture normalizedArch
+	// GPUManufacturer is the manufacturer of the GPUs, e.g. NVIDIA, if the instance type has GPUs.
+	GPUManufacturer string
+	// GPUName is the name of the GPUs, e.g. T4, if the instance type has GPUs.
+	GPUName string
+	// NetworkInterfaces is the maximum number of network interfaces of the default network card.
+	NetworkInterfaces int64
+	// IPv4AddressesPerInterface is the maximum number of IPv4 addresses per network interface.
+	IPv4AddressesPerInterface int64
+	// InstanceStorageGB is the total size of the NVMe instance store volumes in GB.
+	InstanceStorageGB int64
+	// RootVolumeGiB is the size of the root volume in GiB. It is not part of the instance type, but taken
+	// from the AWSMachineTemplate before the annotations are set.
+	RootVolumeGiB int64
+
+	// Source is where the information was obtained from.
+	Source DataSource
+	// FetchedAt is the time the information was fetched from its source.
+	FetchedAt time.Time
 }
 
 // InstanceTypesCache is a cache for instance type information.
@@ -48,81 +82,198 @@ type InstanceTypesCache interface {
 	GetInstanceType(awsClient awsclient.Client, cacheID string, instanceType string) (InstanceType, error)
 }
 
+// cacheIDOf returns the cache ID of the region as seen by the identity. The instance types offered in a region and
+// the zone IDs of its zones differ between accounts, so the regions of each identity are cached apart. The
+// controller's own credentials use the region name.
+func cacheIDOf(identity awsclient.Identity, region string) string {
+	if key := identity.String(); key != "" {
+		return region + "/" + key
+	}
+	return region
+}
+
+// cacheRegion returns the region of the cache ID.
+func cacheRegion(cacheID string) string {
+	region, _, _ := strings.Cut(cacheID, "/")
+	return region
+}
+
 // instanceTypesRegion holds cached instance types for specific region and time when it was last updated.
 type instanceTypesRegion struct {
 	instanceTypes map[string]InstanceType
 	lastUpdate    time.Time
+	// expired holds the instance types force-expired since the last update, they are fetched again on their next use.
+	expired map[string]struct{}
 }
 
-// instanceTypesCache holds cached instance types per region. Acess is synchronized via rwmutex.
+// DefaultInstanceTypesCacheTTL is the duration after which the cached instance types of a region are refreshed.
+const DefaultInstanceTypesCacheTTL = 24 * time.Hour
+
+// instanceTypesErrorTTL is the duration for which a failed refresh of a region is cached, so that lookups do not
+// call the EC2 API on every reconcile while it fails.
+const instanceTypesErrorTTL = time.Minute
+
+// failedRefresh is the cached error of a failed refresh of a region.
+type failedRefresh struct {
+	err error
+	at  time.Time
+}
+
+// instanceTypesCache holds cached instance types per region. Acess is synchronized via rwmutex. Concurrent
+// refreshes of a region are deduplicated via inflight. With maxEntries, the least recently used regions beyond
+// it are evicted.
 type instanceTypesCache struct {
-	cache   map[string]instanceTypesRegion
-	rwmutex sync.RWMutex
+	cache      map[string]instanceTypesRegion
+	failures   map[string]failedRefresh
+	ttl        time.Duration
+	errorTTL   time.Duration
+	maxEntries int
+	lru        *regionLRU
+	rwmutex    sync.RWMutex
+	inflight   singleflight.Group
 }
 
-// NewInstanceTypesCache creates an empty instance types cache.
+// NewInstanceTypesCache creates an empty instance types cache refreshed after DefaultInstanceTypesCacheTTL.
 func NewInstanceTypesCache() InstanceTypesCache {
+	return NewInstanceTypesCacheWithTTL(DefaultInstanceTypesCacheTTL)
+}
+
+// NewInstanceTypesCacheWithTTL creates an empty instance types cache refreshed after the given duration.
+func NewInstanceTypesCacheWithTTL(ttl time.Duration) InstanceTypesCache {
+	return NewBoundedInstanceTypesCache(ttl, 0)
+}
+
+// NewBoundedInstanceTypesCache creates an empty instance types cache refreshed after the given duration, holding
+// at most maxEntries regions. Once full, the least recently used region is evicted and fetched again on its next
+// use. Zero maxEntries is unbounded.
+func NewBoundedInstanceTypesCache(ttl time.Duration, maxEntries int) InstanceTypesCache {
 	cache := &instanceTypesCache{}
 	cache.cache = map[string]instanceTypesRegion{}
+	cache.failures = map[string]failedRefresh{}
+	cache.ttl = ttl
+	cache.errorTTL = instanceTypesErrorTTL
+	cache.maxEntries = maxEntries
+	cache.lru = newRegionLRU()
 	cache.rwmutex = sync.RWMutex{}
 	return cache
 }
 
 // GetInstanceType retrieves InstanceType from cache by name. If the cache is stale or nil it is refreshed first from the EC2 API.
-// The fetched instance types are specific to the region of the awsClient. Using region name as cacheID is recommended.
+// The fetched instance types are specific to the region and the credentials of the awsClient, the cacheID must be
+// the one of cacheIDOf.
 func (i *instanceTypesCache) GetInstanceType(awsClient awsclient.Client, cacheID string, instanceType string) (InstanceType, error) {
 	i.rwmutex.RLock()
 
-	if !i.isCacheFresh(cacheID) {
+	region, source := i.cache[cacheID], DataSourceCache
+	if i.isCacheFresh(cacheID) && !i.isExpired(cacheID, instanceType) {
+		// The region is present while the read lock is held, so that evicted regions are not recorded as used
+		i.lru.touch(cacheID)
+		i.rwmutex.RUnlock()
+	} else {
 		i.rwmutex.RUnlock()
-		if err := i.refresh(awsClient, cacheID); err != nil {
+		// The lookup is served from the refreshed region, which other refreshes may evict before it is read again
+		var err error
+		region, err = i.refresh(awsClient, cacheID, instanceType)
+		if err != nil {
 			return InstanceType{}, fmt.Errorf("error refreshing instance types cache: %w", err)
 		}
-		i.rwmutex.RLock()
+		source = DataSourceAPI
 	}
 
-	instanceTypeInfo, ok := i.cache[cacheID].instanceTypes[instanceType]
+	instanceTypeInfo, ok := region.instanceTypes[instanceType]
 	if !ok {
 		instanceNames := []string{}
-		for _, instanceType := range i.cache[cacheID].instanceTypes {
+		for _, instanceType := range region.instanceTypes {
 			instanceNames = append(instanceNames, instanceType.InstanceType)
 		}
-		i.rwmutex.RUnlock()
-		return InstanceType{}, fmt.Errorf("instance type %q not found: The valid instance types in the current region are: %q", instanceType, instanceNames)
+		// Unknown instance types are served from the snapshot of the region, without calling the EC2 API
+		metrics.InstanceTypeLookupFailures.WithLabelValues(cacheRegion(cacheID), metrics.LookupUnknown).Inc()
+		return InstanceType{}, annotatorerrors.Errorf(annotatorerrors.ErrUnknownInstanceType, "instance type %q not found: The valid instance types in the current region are: %q", instanceType, instanceNames)
 	}
 
-	i.rwmutex.RUnlock()
+	instanceTypeInfo.Source = source
+	instanceTypeInfo.FetchedAt = region.lastUpdate
 	return instanceTypeInfo, nil
 }
 
-// isCacheFresh checks whether the cache for given cacheId is populated and has been refreshed in the last 24 hours.
+// isCacheFresh checks whether the cache for given cacheId is populated and has been refreshed within the TTL.
 func (i *instanceTypesCache) isCacheFresh(cacheID string) bool {
 	cacheForRegion, ok := i.cache[cacheID]
-	return ok && cacheForRegion.instanceTypes != nil && cacheForRegion.lastUpdate.After(time.Now().Add(-24*time.Hour))
+	return ok && cacheForRegion.instanceTypes != nil && cacheForRegion.lastUpdate.After(time.Now().Add(-i.ttl))
 }
 
-// refresh ensures that the cache is updated in a thread safe way.
-func (i *instanceTypesCache) refresh(awsClient awsclient.Client, cacheID string) error {
-	// Only one thread should refresh the cache at a time.
-	// Parallel refresh does not speed up the process and can cause throttling.
-	i.rwmutex.Lock()
-	defer i.rwmutex.Unlock()
+// isExpired checks whether the instance type was force-expired since the last update of the cache for given cacheID.
+func (i *instanceTypesCache) isExpired(cacheID string, instanceType string) bool {
+	_, ok := i.cache[cacheID].expired[instanceType]
+	return ok
+}
 
-	if i.isCacheFresh(cacheID) {
-		// Another thread has already refreshed the cache.
-		return nil
-	}
+// refresh ensures that the cache is updated in a thread safe way, and returns the refreshed region.
+// Only one refresh of a region is in flight at a time: concurrent lookups of a cold region wait for it and share
+// its result, including its error, since parallel refreshes do not speed up the process and can cause throttling.
+// The cache is not locked while fetching, so that lookups of other regions are not blocked.
+func (i *instanceTypesCache) refresh(awsClient awsclient.Client, cacheID string, instanceType string) (instanceTypesRegion, error) {
+	result, err, shared := i.inflight.Do(cacheID, func() (any, error) {
+		i.rwmutex.RLock()
+		region := i.cache[cacheID]
+		fresh := i.isCacheFresh(cacheID) && !i.isExpired(cacheID, instanceType)
+		expired := maps.Clone(region.expired)
+		failure, failed := i.failures[cacheID]
+		if fresh {
+			i.lru.touch(cacheID)
+		}
+		i.rwmutex.RUnlock()
+		if fresh {
+			// Another refresh completed since the lookup.
+			return region, nil
+		}
+		if failed && time.Since(failure.at) < i.errorTTL {
+			metrics.InstanceTypeLookupFailures.WithLabelValues(cacheRegion(cacheID), metrics.LookupRefreshFailedCached).Inc()
+			return nil, failure.err
+		}
+
+		instanceTypes, err := fetchEC2InstanceTypes(awsClient)
+		if err != nil {
+			err = fmt.Errorf("failed to refresh instance types cache: %w", err)
+			metrics.InstanceTypeLookupFailures.WithLabelValues(cacheRegion(cacheID), metrics.LookupRefreshFailed).Inc()
+			i.rwmutex.Lock()
+			i.failures[cacheID] = failedRefresh{err: err, at: time.Now()}
+			i.rwmutex.Unlock()
+			return nil, err
+		}
 
-	instanceTypes, err := fetchEC2InstanceTypes(awsClient)
+		i.rwmutex.Lock()
+		defer i.rwmutex.Unlock()
+		delete(i.failures, cacheID)
+		region = instanceTypesRegion{instanceTypes: instanceTypes, lastUpdate: time.Now()}
+		// Instance types expired while fetching are fetched again on their next use
+		for expiredType := range i.cache[cacheID].expired {
+			if _, ok := expired[expiredType]; !ok {
+				if region.expired == nil {
+					region.expired = map[string]struct{}{}
+				}
+				region.expired[expiredType] = struct{}{}
+			}
+		}
+		i.storeLocked(cacheID, region)
+		return region, nil
+	})
+	if shared {
+		klog.V(4).Infof("Shared the refresh of the instance types cache %s", cacheID)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to refresh instance types cache: %w", err)
+		return instanceTypesRegion{}, err
 	}
-
-	i.cache[cacheID] = instanceTypesRegion{instanceTypes: instanceTypes, lastUpdate: time.Now()}
-	return nil
+	return result.(instanceTypesRegion), nil
 }
 
-// fetchEC2InstanceTypes fetches all available instance types from EC2 API.
+// describeInstanceTypesPageSize is the maximum page size of DescribeInstanceTypes, so that a region is fetched
+// in as few requests as possible.
+const describeInstanceTypesPageSize = 100
+
+// fetchEC2InstanceTypes fetches all available instance types from EC2 API. All MachineDeployments of the region
+// are served from the fetched snapshot, so the API volume grows with the number of regions rather than the
+// number of MachineDeployments.
 func fetchEC2InstanceTypes(awsClient awsclient.Client) (map[string]InstanceType, error) {
 	klog.V(3).Info("Refreshing instance types cache")
 
@@ -130,7 +281,7 @@ func fetchEC2InstanceTypes(awsClient awsclient.Client) (map[string]InstanceType,
 		return nil, errors.New("awsClient is nil")
 	}
 
-	input := ec2.DescribeInstanceTypesInput{}
+	input := ec2.DescribeInstanceTypesInput{MaxResults: aws.Int64(describeInstanceTypesPageSize)}
 	instanceTypes := make(map[string]InstanceType)
 
 	// AWS API paginates responses, so we need to loop until we get all the results
@@ -176,6 +327,14 @@ func transformInstanceType(rawInstanceType *ec2.InstanceTypeInfo) InstanceType {
 	}
 	if rawInstanceType.GpuInfo != nil && len(rawInstanceType.GpuInfo.Gpus) > 0 {
 		instanceType.GPU = getGpuCount(rawInstanceType.GpuInfo)
+		instanceType.GPUManufacturer = getGpuManufacturer(rawInstanceType.GpuInfo)
+		instanceType.GPUName = getGpuName(rawInstanceType.GpuInfo)
+	}
+	if rawInstanceType.InstanceStorageInfo != nil {
+		instanceType.InstanceStorageGB = getNVMeInstanceStorage(rawInstanceType.InstanceStorageInfo)
+	}
+	if rawInstanceType.NetworkInfo != nil {
+		instanceType.NetworkInterfaces, instanceType.IPv4AddressesPerInterface = getNetworkLimits(rawInstanceType.NetworkInfo)
 	}
 	if rawInstanceType.ProcessorInfo != nil && len(rawInstanceType.ProcessorInfo.SupportedArchitectures) > 0 &&
 		rawInstanceType.ProcessorInfo.SupportedArchitectures[0] != nil && *rawInstanceType.ProcessorInfo.SupportedArchitectures[0] != "" {
@@ -197,6 +356,30 @@ func getGpuCount(gpuInfo *ec2.GpuInfo) int64 {
 	return gpuCountSum
 }
 
+// getGpuManufacturer returns the manufacturer of the first GPU in GpuInfo that reports one.
+func getGpuManufacturer(gpuInfo *ec2.GpuInfo) string {
+	for _, gpu := range gpuInfo.Gpus {
+		if gpu.Manufacturer != nil && *gpu.Manufacturer != "" {
+			return *gpu.Manufacturer
+		}
+	}
+	return ""
+}
+
+// getNetworkLimits returns the maximum number of network interfaces of the default network card and the maximum
+// number of IPv4 addresses per interface. Instance types with multiple network cards support more interfaces in
+// total, but the VPC CNI only attaches interfaces to the default network card.
+func getNetworkLimits(networkInfo *ec2.NetworkInfo) (int64, int64) {
+	networkInterfaces := aws.Int64Value(networkInfo.MaximumNetworkInterfaces)
+	for _, card := range networkInfo.NetworkCards {
+		if card.NetworkCardIndex != nil && card.MaximumNetworkInterfaces != nil &&
+			*card.NetworkCardIndex == aws.Int64Value(networkInfo.DefaultNetworkCardIndex) {
+			networkInterfaces = *card.MaximumNetworkInterfaces
+		}
+	}
+	return networkInterfaces, aws.Int64Value(networkInfo.Ipv4AddressesPerInterface)
+}
+
 // normalizeArchitecture converts the given architecture string from the format used by the EC2 API to the one for kubernetes.
 // In particular, at the time of writing,
 // the EC2 API uses the GNU name for the x86_64 architecture, and the Golang/LLVM name for the aarch64.
@@ -0,0 +1,235 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// blockingInstanceTypesClient blocks DescribeInstanceTypes until released, and counts the requests.
+type blockingInstanceTypesClient struct {
+	awsclient.Client
+	started  chan struct{}
+	release  chan struct{}
+	err      error
+	requests atomic.Int32
+}
+
+func (c *blockingInstanceTypesClient) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	if c.requests.Add(1) == 1 {
+		close(c.started)
+	}
+	<-c.release
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.Client.DescribeInstanceTypes(input)
+}
+
+func TestInstanceTypesCacheDeduplicatesRefreshes(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+	}{
+		{
+			name: "successful refresh",
+		},
+		{
+			name: "failed refresh",
+			err:  errors.New("throttled"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			fakeClient, err := fakeawsclient.NewClient(nil, "", "", "")
+			g.Expect(err).ToNot(HaveOccurred())
+			awsClient := &blockingInstanceTypesClient{Client: fakeClient, started: make(chan struct{}), release: make(chan struct{}), err: tc.err}
+			cache := NewInstanceTypesCache()
+
+			// Lookups of a cold region while its refresh is in flight wait for it
+			errs := make(chan error, 10)
+			wg := sync.WaitGroup{}
+			lookup := func(instanceType string) {
+				defer wg.Done()
+				_, err := cache.GetInstanceType(awsClient, "us-east-1", instanceType)
+				errs <- err
+			}
+			wg.Add(1)
+			go lookup("a1.2xlarge")
+			<-awsClient.started
+			for _, instanceType := range []string{"a1.2xlarge", "p2.16xlarge", "m5.large"} {
+				wg.Add(1)
+				go lookup(instanceType)
+			}
+
+			// Lookups of other regions are not blocked by the refresh
+			_, err = cache.GetInstanceType(fakeClient, "eu-west-1", "a1.2xlarge")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			time.Sleep(50 * time.Millisecond)
+			close(awsClient.release)
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				if tc.err != nil {
+					// Lookups joining after the failed refresh retry it
+					g.Expect(err).To(MatchError(ContainSubstring("throttled")))
+				} else if err != nil {
+					g.Expect(err).To(MatchError(ContainSubstring(`instance type "m5.large" not found`)))
+				}
+			}
+			if tc.err == nil {
+				g.Expect(awsClient.requests.Load()).To(BeEquivalentTo(1))
+			}
+		})
+	}
+}
+
+// pagingInstanceTypesClient returns the instance types one per page, and counts the requests per region.
+type pagingInstanceTypesClient struct {
+	awsclient.Client
+	region   string
+	requests map[string]int
+}
+
+func (c *pagingInstanceTypesClient) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	c.requests[c.region]++
+	output, err := c.Client.DescribeInstanceTypes(input)
+	if err != nil {
+		return nil, err
+	}
+
+	page := 0
+	if input.NextToken != nil {
+		page, _ = strconv.Atoi(*input.NextToken)
+	}
+	paged := &ec2.DescribeInstanceTypesOutput{InstanceTypes: output.InstanceTypes[page : page+1]}
+	if page+1 < len(output.InstanceTypes) {
+		paged.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return paged, nil
+}
+
+func TestInstanceTypesCacheFetchesRegionsOnce(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	all, err := fakeClient.DescribeInstanceTypes(&ec2.DescribeInstanceTypesInput{})
+	g.Expect(err).ToNot(HaveOccurred())
+	pages := len(all.InstanceTypes)
+	g.Expect(pages).To(BeNumerically(">", 1))
+
+	requests := map[string]int{}
+	cache := NewInstanceTypesCache()
+	for _, region := range []string{"us-east-1", "eu-west-1"} {
+		awsClient := &pagingInstanceTypesClient{Client: fakeClient, region: region, requests: requests}
+		// The lookups of many MachineDeployments are served from a single paginated fetch of the region
+		for range 50 {
+			for _, instanceType := range all.InstanceTypes {
+				info, err := cache.GetInstanceType(awsClient, region, *instanceType.InstanceType)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(info.InstanceType).To(Equal(*instanceType.InstanceType))
+			}
+		}
+	}
+	g.Expect(requests).To(Equal(map[string]int{"us-east-1": pages, "eu-west-1": pages}))
+}
+
+// failingInstanceTypesClient fails DescribeInstanceTypes while failing is set, and counts the requests.
+type failingInstanceTypesClient struct {
+	awsclient.Client
+	failing  bool
+	requests int
+}
+
+func (c *failingInstanceTypesClient) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	c.requests++
+	if c.failing {
+		return nil, errors.New("throttled")
+	}
+	return c.Client.DescribeInstanceTypes(input)
+}
+
+func TestInstanceTypesCacheNegativeCaching(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	awsClient := &failingInstanceTypesClient{Client: fakeClient, failing: true}
+	cache := NewInstanceTypesCache()
+	failures := func(reason string) float64 {
+		return testutil.ToFloat64(metrics.InstanceTypeLookupFailures.WithLabelValues("ap-south-2", reason))
+	}
+	refreshFailed, refreshFailedCached, unknown := failures(metrics.LookupRefreshFailed), failures(metrics.LookupRefreshFailedCached), failures(metrics.LookupUnknown)
+
+	// Failed refreshes are cached for a short time
+	for range 3 {
+		_, err = cache.GetInstanceType(awsClient, "ap-south-2", "a1.2xlarge")
+		g.Expect(err).To(MatchError(ContainSubstring("throttled")))
+	}
+	g.Expect(awsClient.requests).To(Equal(1))
+	g.Expect(failures(metrics.LookupRefreshFailed)).To(Equal(refreshFailed + 1))
+	g.Expect(failures(metrics.LookupRefreshFailedCached)).To(Equal(refreshFailedCached + 2))
+
+	// And retried afterwards
+	cache.(*instanceTypesCache).errorTTL = 0
+	awsClient.failing = false
+	_, err = cache.GetInstanceType(awsClient, "ap-south-2", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(awsClient.requests).To(Equal(2))
+
+	// Unknown instance types are served from the snapshot of the region
+	for range 3 {
+		_, err = cache.GetInstanceType(awsClient, "ap-south-2", "m9.large")
+		g.Expect(errors.Is(err, annotatorerrors.ErrUnknownInstanceType)).To(BeTrue())
+	}
+	g.Expect(awsClient.requests).To(Equal(2))
+	g.Expect(failures(metrics.LookupUnknown)).To(Equal(unknown + 3))
+}
+
+func TestExpireCachesRetriesFailedRefreshes(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	awsClient := &failingInstanceTypesClient{Client: fakeClient, failing: true}
+	r := &Reconciler{InstanceTypesCache: NewInstanceTypesCache()}
+
+	_, err = r.InstanceTypesCache.GetInstanceType(awsClient, "us-east-1", "a1.2xlarge")
+	g.Expect(err).To(HaveOccurred())
+	awsClient.failing = false
+	r.ExpireCaches("us-east-1", nil)
+	_, err = r.InstanceTypesCache.GetInstanceType(awsClient, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(awsClient.requests).To(Equal(2))
+}
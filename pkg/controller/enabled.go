@@ -0,0 +1,48 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// enabledKey is the annotation of MachineDeployments opting in to or out of being annotated, e.g. by teams
+// managing the capacity of a MachineDeployment by hand.
+const enabledKey = "capa-annotator/enabled"
+
+// annotationEnabled returns whether the MachineDeployment is annotated. Without the enabled annotation, all
+// MachineDeployments are annotated unless OptIn is set. Invalid values disable the annotation, so that a
+// misspelled opt-out does not annotate the MachineDeployment.
+func (r *Reconciler) annotationEnabled(machineDeployment *clusterv1.MachineDeployment) (bool, error) {
+	value, ok := machineDeployment.Annotations[enabledKey]
+	if !ok {
+		return !r.OptIn, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q of annotation %s, must be \"true\" or \"false\"", value, enabledKey)
+	}
+	return enabled, nil
+}
+
+// inScope returns whether the MachineDeployment is annotated by this instance of the controller, i.e. whether
+// it matches the watch filter and the selector, is not in an excluded namespace and its annotation is enabled.
+func (r *Reconciler) inScope(machineDeployment *clusterv1.MachineDeployment) bool {
+	enabled, _ := r.annotationEnabled(machineDeployment)
+	return enabled && r.matchesWatchFilter(machineDeployment) && r.matchesSelector(machineDeployment) &&
+		!r.namespaceExcluded(machineDeployment)
+}
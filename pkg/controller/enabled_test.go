@@ -0,0 +1,96 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileEnabledAnnotation(t *testing.T) {
+	testCases := []struct {
+		name            string
+		optIn           bool
+		enabled         string
+		expectAnnotated bool
+		expectEvent     bool
+	}{
+		{
+			name:            "annotated by default",
+			expectAnnotated: true,
+		},
+		{
+			name:    "opted out",
+			enabled: "false",
+		},
+		{
+			name:  "not opted in",
+			optIn: true,
+		},
+		{
+			name:            "opted in",
+			optIn:           true,
+			enabled:         "true",
+			expectAnnotated: true,
+		},
+		{
+			name:        "invalid value",
+			enabled:     "no",
+			expectEvent: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			annotations := map[string]string{}
+			if tc.enabled != "" {
+				annotations[enabledKey] = tc.enabled
+			}
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", annotations)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Name = "gated"
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+			r.OptIn = tc.optIn
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+			request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+
+			_, err = r.Reconcile(ctx, request)
+			g.Expect(err).ToNot(HaveOccurred())
+			got := &clusterv1.MachineDeployment{}
+			g.Expect(r.Client.Get(ctx, request.NamespacedName, got)).To(Succeed())
+			if tc.expectAnnotated {
+				g.Expect(got.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+			} else {
+				g.Expect(got.Annotations).ToNot(HaveKey(cpuKey))
+			}
+			if tc.expectEvent {
+				g.Expect(recorder.Events).To(Receive(ContainSubstring("InvalidAnnotation")))
+			}
+
+			// MachineDeployments not annotated are not in scope of the annotation health
+			health, err := r.BuildAnnotationHealth(ctx)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(health.Healthy).To(BeTrue())
+			g.Expect(health.Total).To(Equal(map[bool]int{true: 1, false: 0}[tc.expectAnnotated]))
+		})
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+)
+
+// ephemeralDiskKey is the annotation of the cluster-autoscaler Cluster API provider for the ephemeral storage of a node.
+const ephemeralDiskKey = "capacity.cluster-autoscaler.kubernetes.io/ephemeral-disk"
+
+// EphemeralStorageMode is the source of the ephemeral storage of a node.
+type EphemeralStorageMode string
+
+const (
+	// EphemeralStorageModeNone does not write the ephemeral-disk annotation. This is the default.
+	EphemeralStorageModeNone EphemeralStorageMode = ""
+	// EphemeralStorageModeRootVolume takes the ephemeral storage from the root volume of the AWSMachineTemplate,
+	// where the kubelet keeps its data by default.
+	EphemeralStorageModeRootVolume EphemeralStorageMode = "root-volume"
+	// EphemeralStorageModeInstanceStore takes the ephemeral storage from the NVMe instance store volumes of the
+	// instance type, for nodes that set up the kubelet data on them, e.g. as a RAID 0. Instance types without
+	// instance store volumes fall back to the root volume.
+	EphemeralStorageModeInstanceStore EphemeralStorageMode = "instance-store"
+)
+
+// ParseEphemeralStorageMode validates the given ephemeral storage mode.
+func ParseEphemeralStorageMode(mode string) (EphemeralStorageMode, error) {
+	switch EphemeralStorageMode(mode) {
+	case EphemeralStorageModeNone, EphemeralStorageModeRootVolume, EphemeralStorageModeInstanceStore:
+		return EphemeralStorageMode(mode), nil
+	}
+	return "", fmt.Errorf("unknown ephemeral storage mode %q, must be one of %q", mode, []EphemeralStorageMode{EphemeralStorageModeRootVolume, EphemeralStorageModeInstanceStore})
+}
+
+// getNVMeInstanceStorage returns the total size in GB of the NVMe instance store volumes in InstanceStorageInfo.
+// Instance store volumes without NVMe support are not counted, they are not set up by current node images.
+func getNVMeInstanceStorage(storageInfo *ec2.InstanceStorageInfo) int64 {
+	if storageInfo.NvmeSupport == nil || *storageInfo.NvmeSupport == ec2.EphemeralNvmeSupportUnsupported {
+		return 0
+	}
+	if storageInfo.TotalSizeInGB == nil {
+		return 0
+	}
+	return *storageInfo.TotalSizeInGB
+}
+
+// withRootVolume returns the capacity with the size of the root volume of the AWSMachineTemplate, if set.
+func withRootVolume(capacity InstanceType, awsMachineTemplate *infrav1.AWSMachineTemplate) InstanceType {
+	if rootVolume := awsMachineTemplate.Spec.Template.Spec.RootVolume; rootVolume != nil {
+		capacity.RootVolumeGiB = rootVolume.Size
+	}
+	return capacity
+}
+
+// ephemeralStorage returns the ephemeral storage of a node of the instance type in the configured mode as a
+// resource quantity, rounded down to GiB. False is returned if the size of its source is unknown, e.g. if the
+// AWSMachineTemplate does not set the size of the root volume and it depends on the image.
+func (r *Reconciler) ephemeralStorage(instanceTypeInfo InstanceType) (string, bool) {
+	var bytes int64
+	switch {
+	case r.EphemeralStorageMode == EphemeralStorageModeInstanceStore && instanceTypeInfo.InstanceStorageGB > 0:
+		// The EC2 API reports instance store sizes in decimal gigabytes
+		bytes = instanceTypeInfo.InstanceStorageGB * 1000 * 1000 * 1000
+	case instanceTypeInfo.RootVolumeGiB > 0:
+		bytes = instanceTypeInfo.RootVolumeGiB << 30
+	default:
+		return "", false
+	}
+	return fmt.Sprintf("%dGi", bytes>>30), true
+}
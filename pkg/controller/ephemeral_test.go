@@ -0,0 +1,127 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	. "github.com/onsi/gomega"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+)
+
+func TestTransformInstanceTypeInstanceStorage(t *testing.T) {
+	g := NewWithT(t)
+
+	instanceType := transformInstanceType(&ec2.InstanceTypeInfo{
+		InstanceType: aws.String("m6id.2xlarge"),
+		InstanceStorageInfo: &ec2.InstanceStorageInfo{
+			NvmeSupport:   aws.String(ec2.EphemeralNvmeSupportRequired),
+			TotalSizeInGB: aws.Int64(474),
+		},
+	})
+	g.Expect(instanceType.InstanceStorageGB).To(Equal(int64(474)))
+
+	instanceType = transformInstanceType(&ec2.InstanceTypeInfo{
+		InstanceType: aws.String("m3.medium"),
+		InstanceStorageInfo: &ec2.InstanceStorageInfo{
+			NvmeSupport:   aws.String(ec2.EphemeralNvmeSupportUnsupported),
+			TotalSizeInGB: aws.Int64(4),
+		},
+	})
+	g.Expect(instanceType.InstanceStorageGB).To(BeZero())
+}
+
+func TestEphemeralStorage(t *testing.T) {
+	testCases := []struct {
+		name          string
+		mode          EphemeralStorageMode
+		instanceType  InstanceType
+		expected      string
+		expectedKnown bool
+	}{
+		{
+			name:          "root volume",
+			mode:          EphemeralStorageModeRootVolume,
+			instanceType:  InstanceType{RootVolumeGiB: 100, InstanceStorageGB: 474},
+			expected:      "100Gi",
+			expectedKnown: true,
+		},
+		{
+			name:         "root volume of unknown size",
+			mode:         EphemeralStorageModeRootVolume,
+			instanceType: InstanceType{InstanceStorageGB: 474},
+		},
+		{
+			name:          "instance store in decimal gigabytes",
+			mode:          EphemeralStorageModeInstanceStore,
+			instanceType:  InstanceType{RootVolumeGiB: 100, InstanceStorageGB: 474},
+			expected:      "441Gi",
+			expectedKnown: true,
+		},
+		{
+			name:          "instance store falls back to the root volume",
+			mode:          EphemeralStorageModeInstanceStore,
+			instanceType:  InstanceType{RootVolumeGiB: 100},
+			expected:      "100Gi",
+			expectedKnown: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			r := &Reconciler{EphemeralStorageMode: tc.mode}
+			ephemeralStorage, known := r.ephemeralStorage(tc.instanceType)
+			g.Expect(known).To(Equal(tc.expectedKnown))
+			g.Expect(ephemeralStorage).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestReconcileWithEphemeralStorage(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	awsMachineTemplate.Spec.Template.Spec.RootVolume = &infrav1.Volume{Size: 120}
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.EphemeralStorageMode = EphemeralStorageModeRootVolume
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(ephemeralDiskKey, "120Gi"))
+	g.Expect(getManagedKeys(machineDeployment.Annotations)).To(ContainElement(ephemeralDiskKey))
+
+	// The annotation is removed once the size of the root volume is no longer known
+	awsMachineTemplate.Spec.Template.Spec.RootVolume = nil
+	g.Expect(r.Client.Update(ctx, awsMachineTemplate)).To(Succeed())
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).ToNot(HaveKey(ephemeralDiskKey))
+}
+
+func TestParseEphemeralStorageMode(t *testing.T) {
+	g := NewWithT(t)
+
+	mode, err := ParseEphemeralStorageMode("instance-store")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(mode).To(Equal(EphemeralStorageModeInstanceStore))
+
+	_, err = ParseEphemeralStorageMode("ebs")
+	g.Expect(err).To(HaveOccurred())
+}
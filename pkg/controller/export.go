@@ -0,0 +1,139 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats of exported instance types.
+const (
+	ExportFormatYAML = "yaml"
+	ExportFormatJSON = "json"
+	ExportFormatCSV  = "csv"
+)
+
+// exportColumns are the columns of instance types exported as CSV.
+var exportColumns = []string{"instanceType", "vcpu", "memoryMb", "architecture", "gpu", "gpuManufacturer", "gpuName", "maxPods"}
+
+// ExportInstanceTypes fetches the instance types offered in the region and the zone IDs of its availability zones
+// from the EC2 API, and returns them as a dataset that can be served with --instance-types-file. The maximum number
+// of pods of the instance types is calculated in the configured maxPods mode, defaulting to the VPC CNI without
+// ENI trunking.
+func (r *Reconciler) ExportInstanceTypes(region string) (*InstanceTypesDataset, error) {
+	if region == "" {
+		return nil, fmt.Errorf("region must be specified")
+	}
+
+	awsClient, err := r.AwsClientBuilder(r.Client, "", "", region, r.RegionCache)
+	if err != nil {
+		return nil, fmt.Errorf("error creating aws client: %w", err)
+	}
+	instanceTypes, err := fetchEC2InstanceTypes(awsClient)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch instance types of region %s: %w", region, err)
+	}
+	zoneIDs, err := fetchEC2AvailabilityZones(awsClient)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch availability zones of region %s: %w", region, err)
+	}
+
+	generatedAt := time.Now().UTC().Truncate(time.Second)
+	dataset := &InstanceTypesDataset{
+		GeneratedAt: &generatedAt,
+		Regions:     map[string]DatasetRegion{region: {AvailabilityZones: zoneIDs}},
+	}
+	for _, instanceTypeInfo := range instanceTypes {
+		maxPods, _ := r.maxPods(instanceTypeInfo)
+		dataset.InstanceTypes = append(dataset.InstanceTypes, DatasetInstanceType{
+			InstanceType:              instanceTypeInfo.InstanceType,
+			VCPU:                      instanceTypeInfo.VCPU,
+			MemoryMb:                  instanceTypeInfo.MemoryMb,
+			Architecture:              string(instanceTypeInfo.CPUArchitecture),
+			GPU:                       instanceTypeInfo.GPU,
+			GPUManufacturer:           instanceTypeInfo.GPUManufacturer,
+			GPUName:                   instanceTypeInfo.GPUName,
+			NetworkInterfaces:         instanceTypeInfo.NetworkInterfaces,
+			IPv4AddressesPerInterface: instanceTypeInfo.IPv4AddressesPerInterface,
+			InstanceStorageGB:         instanceTypeInfo.InstanceStorageGB,
+			MaxPods:                   maxPods,
+		})
+	}
+	sort.Slice(dataset.InstanceTypes, func(i, j int) bool {
+		return dataset.InstanceTypes[i].InstanceType < dataset.InstanceTypes[j].InstanceType
+	})
+
+	regionData := dataset.Regions[region]
+	for _, instanceType := range dataset.InstanceTypes {
+		regionData.InstanceTypes = append(regionData.InstanceTypes, instanceType.InstanceType)
+	}
+	dataset.Regions[region] = regionData
+	return dataset, nil
+}
+
+// MarshalInstanceTypesDataset encodes the dataset in the given format. YAML and JSON are datasets for
+// --instance-types-file, CSV is a table of the capacities of the instance types for spreadsheets.
+func MarshalInstanceTypesDataset(dataset *InstanceTypesDataset, format string) ([]byte, error) {
+	switch format {
+	case "", ExportFormatYAML:
+		return yaml.Marshal(dataset)
+	case ExportFormatJSON:
+		data, err := json.MarshalIndent(dataset, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case ExportFormatCSV:
+		return marshalInstanceTypesCSV(dataset)
+	}
+	return nil, fmt.Errorf("unknown format %q, must be one of %q", format, []string{ExportFormatYAML, ExportFormatJSON, ExportFormatCSV})
+}
+
+// marshalInstanceTypesCSV encodes the instance types of the dataset as CSV with a header row. The maximum number
+// of pods is empty for instance types with unknown network limits.
+func marshalInstanceTypesCSV(dataset *InstanceTypesDataset) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.Write(exportColumns); err != nil {
+		return nil, err
+	}
+	for _, instanceType := range dataset.InstanceTypes {
+		maxPods := ""
+		if instanceType.MaxPods > 0 {
+			maxPods = strconv.FormatInt(instanceType.MaxPods, 10)
+		}
+		if err := w.Write([]string{
+			instanceType.InstanceType,
+			strconv.FormatInt(instanceType.VCPU, 10),
+			strconv.FormatInt(instanceType.MemoryMb, 10),
+			instanceType.Architecture,
+			strconv.FormatInt(instanceType.GPU, 10),
+			instanceType.GPUManufacturer,
+			instanceType.GPUName,
+			maxPods,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,77 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestExportInstanceTypes(t *testing.T) {
+	g := NewWithT(t)
+	r := &Reconciler{
+		AwsClientBuilder: func(client client.Client, secretName, namespace, region string, regionCache awsclient.RegionCache) (awsclient.Client, error) {
+			return fakeawsclient.NewClient(nil, secretName, namespace, region)
+		},
+	}
+
+	_, err := r.ExportInstanceTypes("")
+	g.Expect(err).To(MatchError(ContainSubstring("region must be specified")))
+
+	dataset, err := r.ExportInstanceTypes("us-east-1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(dataset.GeneratedAt).ToNot(BeNil())
+	g.Expect(dataset.InstanceTypes[0]).To(Equal(DatasetInstanceType{
+		InstanceType:              "a1.2xlarge",
+		VCPU:                      8,
+		MemoryMb:                  16384,
+		Architecture:              "amd64",
+		NetworkInterfaces:         4,
+		IPv4AddressesPerInterface: 15,
+		MaxPods:                   58,
+	}))
+	g.Expect(dataset.Regions["us-east-1"].InstanceTypes).To(ContainElements("a1.2xlarge", "p2.16xlarge", "m6g.4xlarge"))
+	g.Expect(dataset.Regions["us-east-1"].AvailabilityZones).To(HaveKeyWithValue("us-east-1a", "use1-az6"))
+
+	for _, format := range []string{ExportFormatYAML, ExportFormatJSON} {
+		data, err := MarshalInstanceTypesDataset(dataset, format)
+		g.Expect(err).ToNot(HaveOccurred())
+		parsed, err := ParseInstanceTypesDataset(data)
+		g.Expect(err).ToNot(HaveOccurred(), format)
+		g.Expect(parsed.GeneratedAt.Equal(*dataset.GeneratedAt)).To(BeTrue())
+
+		instanceTypeInfo, err := NewStaticInstanceTypesCache(parsed).GetInstanceType(nil, "us-east-1", "p2.16xlarge")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(instanceTypeInfo.GPU).To(Equal(int64(16)))
+		g.Expect(instanceTypeInfo.GPUName).To(Equal("K80"))
+	}
+
+	data, err := MarshalInstanceTypesDataset(dataset, ExportFormatCSV)
+	g.Expect(err).ToNot(HaveOccurred())
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	g.Expect(lines).To(HaveLen(len(dataset.InstanceTypes) + 1))
+	g.Expect(lines[0]).To(Equal("instanceType,vcpu,memoryMb,architecture,gpu,gpuManufacturer,gpuName,maxPods"))
+	g.Expect(lines).To(ContainElements(
+		"a1.2xlarge,8,16384,amd64,0,,,58",
+		"m6g.4xlarge,16,65536,arm64,0,,,",
+	))
+
+	_, err = MarshalInstanceTypesDataset(dataset, "xml")
+	g.Expect(err).To(MatchError(ContainSubstring("unknown format")))
+}
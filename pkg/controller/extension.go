@@ -0,0 +1,127 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	runtimecatalog "sigs.k8s.io/cluster-api/exp/runtime/catalog"
+	runtimehooksv1 "sigs.k8s.io/cluster-api/exp/runtime/hooks/api/v1alpha1"
+	"sigs.k8s.io/cluster-api/exp/runtime/server"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// runtimeExtensionHandlerName is the name of the handlers of the runtime extension, registered for every hook.
+const runtimeExtensionHandlerName = "annotate-machine-deployments"
+
+// RuntimeExtension is a Cluster API Runtime Extension annotating the MachineDeployments of ClusterClass based
+// Clusters when the topology controller calls its lifecycle hooks, i.e. once the control plane is initialized and
+// after every upgrade. Topology patches can only modify templates, not the metadata of MachineDeployments, so the
+// annotations are written by the Reconciler. It shares the configuration and caches of the Reconciler.
+type RuntimeExtension struct {
+	Reconciler *Reconciler
+	// Standalone is true if the MachineDeployment controller of the Reconciler is not running, so the extension
+	// sets up the Reconciler.
+	Standalone bool
+}
+
+// SetupWithManager adds the Runtime SDK server of the hooks to the manager.
+func (e *RuntimeExtension) SetupWithManager(mgr ctrl.Manager, port int, certDir string) error {
+	catalog := runtimecatalog.New()
+	if err := runtimehooksv1.AddToCatalog(catalog); err != nil {
+		return fmt.Errorf("failed adding the runtime hooks to the catalog: %w", err)
+	}
+	srv, err := server.New(server.Options{Catalog: catalog, Port: port, CertDir: certDir})
+	if err != nil {
+		return fmt.Errorf("failed creating the runtime extension server: %w", err)
+	}
+
+	for _, handler := range []server.ExtensionHandler{
+		{Hook: runtimehooksv1.AfterControlPlaneInitialized, Name: runtimeExtensionHandlerName, HandlerFunc: e.AfterControlPlaneInitialized},
+		{Hook: runtimehooksv1.AfterClusterUpgrade, Name: runtimeExtensionHandlerName, HandlerFunc: e.AfterClusterUpgrade},
+	} {
+		if err := srv.AddExtensionHandler(handler); err != nil {
+			return fmt.Errorf("failed adding the runtime extension handler: %w", err)
+		}
+	}
+	if err := mgr.Add(srv); err != nil {
+		return fmt.Errorf("failed adding the runtime extension server: %w", err)
+	}
+
+	if e.Standalone {
+		e.Reconciler.setup(mgr)
+	}
+	return nil
+}
+
+// AfterControlPlaneInitialized annotates the MachineDeployments of the Cluster once its control plane is initialized.
+func (e *RuntimeExtension) AfterControlPlaneInitialized(ctx context.Context, request *runtimehooksv1.AfterControlPlaneInitializedRequest, response *runtimehooksv1.AfterControlPlaneInitializedResponse) {
+	e.annotateCluster(ctx, &request.Cluster, &response.CommonResponse)
+}
+
+// AfterClusterUpgrade annotates the MachineDeployments of the Cluster after an upgrade, which may have rotated their templates.
+func (e *RuntimeExtension) AfterClusterUpgrade(ctx context.Context, request *runtimehooksv1.AfterClusterUpgradeRequest, response *runtimehooksv1.AfterClusterUpgradeResponse) {
+	e.annotateCluster(ctx, &request.Cluster, &response.CommonResponse)
+}
+
+// annotateCluster reconciles the MachineDeployments of the Cluster. A failure response makes the topology
+// controller call the hook again, so errors and reconciles the controller would requeue are reported as failures.
+func (e *RuntimeExtension) annotateCluster(ctx context.Context, cluster *clusterv1.Cluster, response *runtimehooksv1.CommonResponse) {
+	r := e.Reconciler
+
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := r.Client.List(ctx, machineDeployments, client.InNamespace(cluster.Namespace), client.MatchingLabels{clusterv1.ClusterNameLabel: cluster.Name}); err != nil {
+		response.SetStatus(runtimehooksv1.ResponseStatusFailure)
+		response.SetMessage(fmt.Sprintf("failed to list MachineDeployments: %v", err))
+		return
+	}
+
+	var failures []string
+	for _, machineDeployment := range machineDeployments.Items {
+		key := client.ObjectKeyFromObject(&machineDeployment)
+		var status *reconcileStatus
+		observed := context.WithValue(ctx, reconcileObserverKey{}, func(s *reconcileStatus) { status = s })
+		result, err := r.Reconcile(observed, ctrl.Request{NamespacedName: key})
+		switch {
+		case err != nil:
+			failures = append(failures, fmt.Sprintf("%s: %v", key, err))
+		case requeued(result) && !status.periodicReannotation:
+			// The controller would retry the MachineDeployment, e.g. after a transient AWS error, so the hook
+			// has to be called again
+			failure := fmt.Sprintf("%s: requeued after %v", key, result.RequeueAfter)
+			if status.message != "" {
+				failure += ": " + status.message
+			}
+			failures = append(failures, failure)
+		}
+	}
+	if len(failures) > 0 {
+		response.SetStatus(runtimehooksv1.ResponseStatusFailure)
+		response.SetMessage("failed to annotate MachineDeployments: " + strings.Join(failures, "; "))
+		return
+	}
+
+	klog.V(2).Infof("Annotated %d MachineDeployments of Cluster %s/%s from the runtime extension", len(machineDeployments.Items), cluster.Namespace, cluster.Name)
+	response.SetStatus(runtimehooksv1.ResponseStatusSuccess)
+}
+
+// requeued returns true if the result of a reconcile asks for the MachineDeployment to be reconciled again.
+func requeued(result ctrl.Result) bool {
+	return result.Requeue || result.RequeueAfter > 0
+}
@@ -0,0 +1,93 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	runtimehooksv1 "sigs.k8s.io/cluster-api/exp/runtime/hooks/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRuntimeExtensionAnnotatesCluster(t *testing.T) {
+	testCases := []struct {
+		name           string
+		instanceType   string
+		setup          func(r *Reconciler)
+		expectedStatus runtimehooksv1.ResponseStatus
+	}{
+		{
+			name:           "known instance type",
+			instanceType:   "a1.2xlarge",
+			expectedStatus: runtimehooksv1.ResponseStatusSuccess,
+		},
+		{
+			name:           "missing template",
+			expectedStatus: runtimehooksv1.ResponseStatusFailure,
+		},
+		{
+			name:         "periodic reannotation is not retried",
+			instanceType: "a1.2xlarge",
+			setup: func(r *Reconciler) {
+				r.PeriodicReannotationInterval = 24 * time.Hour
+			},
+			expectedStatus: runtimehooksv1.ResponseStatusSuccess,
+		},
+		{
+			name:         "requeued unknown instance type is retried",
+			instanceType: "invalid",
+			setup: func(r *Reconciler) {
+				r.UnknownInstanceTypeRetryInterval = 10 * time.Minute
+				r.unknownRetries = newUnknownInstanceTypeRetries(r.UnknownInstanceTypeRetryInterval)
+			},
+			expectedStatus: runtimehooksv1.ResponseStatusFailure,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", tc.instanceType, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Name = "test-md"
+			machineDeployment.Labels = map[string]string{clusterv1.ClusterNameLabel: cluster.Name}
+
+			objs := []client.Object{machineDeployment, cluster, awsCluster}
+			if tc.instanceType != "" {
+				objs = append(objs, awsMachineTemplate)
+			}
+			r := newTestReconciler(g, objs...)
+			if tc.setup != nil {
+				tc.setup(r)
+			}
+			extension := &RuntimeExtension{Reconciler: r}
+
+			request := &runtimehooksv1.AfterControlPlaneInitializedRequest{Cluster: *cluster}
+			response := &runtimehooksv1.AfterControlPlaneInitializedResponse{}
+			extension.AfterControlPlaneInitialized(ctx, request, response)
+			g.Expect(response.GetStatus()).To(Equal(tc.expectedStatus))
+
+			if tc.expectedStatus == runtimehooksv1.ResponseStatusSuccess {
+				g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machineDeployment), machineDeployment)).To(Succeed())
+				g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+			} else {
+				g.Expect(response.GetMessage()).To(ContainSubstring("default/test-md"))
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+)
+
+// setReconcileReason sets the reason label of the running reconcile if it fails, for failures whose reason cannot
+// be classified from their error alone.
+func setReconcileReason(ctx context.Context, reason string) {
+	if status, ok := ctx.Value(reconcileStatusKey{}).(*reconcileStatus); ok {
+		status.reason = reason
+	}
+}
+
+// failed returns whether the reconcile failed, i.e. it returned an error, could not set the annotations or gave up.
+func (s *reconcileStatus) failed() bool {
+	return s.result == metrics.ResultError || s.result == metrics.ResultFailed || s.result == metrics.ResultGaveUp
+}
+
+// failureReason classifies the error of a failed reconcile whose reason was not set by the reconcile.
+func failureReason(err error) string {
+	var awsErr awserr.Error
+	switch {
+	case errors.Is(err, annotatorerrors.ErrUnknownInstanceType):
+		return metrics.ReasonInstanceUnknown
+	case errors.As(err, &awsErr):
+		return metrics.ReasonAWSError
+	default:
+		return metrics.ReasonOther
+	}
+}
+
+// retriable returns whether the error of an AWS API call is transient, e.g. throttling, timeouts or server errors,
+// so that retrying the call can succeed. Unknown instance types, client and other errors are terminal.
+func retriable(err error) bool {
+	var requestFailure awserr.RequestFailure
+	var awsErr awserr.Error
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, annotatorerrors.ErrUnknownInstanceType):
+		return false
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &requestFailure) && requestFailure.StatusCode() >= http.StatusInternalServerError:
+		return true
+	case errors.As(err, &awsErr):
+		return request.IsErrorThrottle(awsErr) || request.IsErrorRetryable(awsErr)
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	default:
+		return false
+	}
+}
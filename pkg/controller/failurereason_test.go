@@ -0,0 +1,177 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// throttledInstanceTypesClient fails DescribeInstanceTypes with an AWS error.
+type throttledInstanceTypesClient struct {
+	awsclient.Client
+}
+
+func (c *throttledInstanceTypesClient) DescribeInstanceTypes(*ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	return nil, awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+}
+
+func TestReconcileFailureReasonMetric(t *testing.T) {
+	testCases := []struct {
+		name           string
+		namespace      string
+		instanceType   string
+		modify         func(*clusterv1.MachineDeployment, *infrav1.AWSCluster)
+		throttled      bool
+		expectedReason string
+	}{
+		{
+			name:      "with a successful reconcile",
+			namespace: "reason-none",
+		},
+		{
+			name:      "with a missing template",
+			namespace: "reason-template",
+			modify: func(machineDeployment *clusterv1.MachineDeployment, _ *infrav1.AWSCluster) {
+				machineDeployment.Spec.Template.Spec.InfrastructureRef.Name = "missing"
+			},
+			expectedReason: metrics.ReasonTemplateMissing,
+		},
+		{
+			name:      "with an unknown region",
+			namespace: "reason-region",
+			modify: func(_ *clusterv1.MachineDeployment, awsCluster *infrav1.AWSCluster) {
+				awsCluster.Spec.Region = ""
+			},
+			expectedReason: metrics.ReasonRegionUnknown,
+		},
+		{
+			name:           "with an unknown instance type",
+			namespace:      "reason-instance",
+			instanceType:   "invalid",
+			expectedReason: metrics.ReasonInstanceUnknown,
+		},
+		{
+			name:           "with a throttled EC2 API",
+			namespace:      "reason-aws",
+			throttled:      true,
+			expectedReason: metrics.ReasonAWSError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			metrics.SetNamespaceAllowList([]string{tc.namespace})
+			defer metrics.SetNamespaceAllowList(nil)
+
+			instanceType := tc.instanceType
+			if instanceType == "" {
+				instanceType = "a1.2xlarge"
+			}
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment(tc.namespace, instanceType, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Name = "failure"
+			if tc.modify != nil {
+				tc.modify(machineDeployment, awsCluster)
+			}
+
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+			if tc.throttled {
+				awsClient, err := r.AwsClientBuilder(nil, "", "", "", nil)
+				g.Expect(err).ToNot(HaveOccurred())
+				r.AwsClientBuilder = func(client.Client, string, string, string, awsclient.RegionCache) (awsclient.Client, error) {
+					return &throttledInstanceTypesClient{Client: awsClient}, nil
+				}
+			}
+
+			_, _ = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+
+			for _, reason := range []string{metrics.ReasonTemplateMissing, metrics.ReasonRegionUnknown, metrics.ReasonInstanceUnknown, metrics.ReasonAWSError, metrics.ReasonOther} {
+				expected := 0.0
+				if reason == tc.expectedReason {
+					expected = 1
+				}
+				g.Expect(testutil.ToFloat64(metrics.ReconcileFailures.WithLabelValues(tc.namespace, reason))).To(Equal(expected), reason)
+			}
+		})
+	}
+}
+
+func TestAnnotationUpdatesMetric(t *testing.T) {
+	g := NewWithT(t)
+
+	metrics.SetNamespaceAllowList([]string{"annotation-updates"})
+	defer metrics.SetNamespaceAllowList(nil)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("annotation-updates", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "updated"
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	patches := 0
+	r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			patches++
+			return c.Patch(ctx, obj, patch, opts...)
+		},
+	})
+	request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(testutil.ToFloat64(metrics.AnnotationUpdates.WithLabelValues("annotation-updates"))).To(Equal(1.0))
+
+	g.Expect(patches).To(Equal(1))
+
+	// Reconciles without changes are not counted, and do not patch the MachineDeployment
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(testutil.ToFloat64(metrics.AnnotationUpdates.WithLabelValues("annotation-updates"))).To(Equal(1.0))
+	g.Expect(patches).To(Equal(1))
+}
+
+func TestMachineDeploymentCapacityMetrics(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("capacity-metrics", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "workers"
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.MachineDeploymentCapacityMetrics = true
+	request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(testutil.ToFloat64(metrics.MachineDeploymentVCPU.WithLabelValues("capacity-metrics", "workers", machineDeployment.Spec.ClusterName, "a1.2xlarge"))).To(Equal(8.0))
+	g.Expect(testutil.ToFloat64(metrics.MachineDeploymentMemory.WithLabelValues("capacity-metrics", "workers", machineDeployment.Spec.ClusterName, "a1.2xlarge"))).To(Equal(16384.0))
+
+	// The series are removed once the MachineDeployment is deleted
+	g.Expect(r.Client.Delete(ctx, machineDeployment)).To(Succeed())
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(metrics.HasMachineDeploymentCapacity(request.NamespacedName)).To(BeFalse())
+}
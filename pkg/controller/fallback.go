@@ -0,0 +1,114 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"time"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// DataSourceFallback means the information was served from the embedded instance types dataset, because it could
+// not be fetched from the EC2 API.
+const DataSourceFallback DataSource = "fallback"
+
+//go:embed data/instance-types.yaml
+var embeddedInstanceTypes []byte
+
+// EmbeddedInstanceTypesDataset returns the snapshot of common instance types embedded in the binary.
+func EmbeddedInstanceTypesDataset() (*InstanceTypesDataset, error) {
+	return ParseInstanceTypesDataset(embeddedInstanceTypes)
+}
+
+// fallbackInstanceTypesCache serves the instance types of a dataset if they cannot be fetched, e.g. while the EC2
+// API is unreachable or throttled, so that scale-from-zero keeps working during outages. Instance types unknown
+// in the region are not served from the dataset.
+type fallbackInstanceTypesCache struct {
+	cache    InstanceTypesCache
+	fallback InstanceTypesCache
+}
+
+// NewFallbackInstanceTypesCache returns a cache serving the instance types of the dataset when the lookups of the
+// wrapped cache fail. The instance types are served for all regions, since the dataset does not record regional
+// availability.
+func NewFallbackInstanceTypesCache(cache InstanceTypesCache, dataset *InstanceTypesDataset) InstanceTypesCache {
+	return &fallbackInstanceTypesCache{cache: cache, fallback: NewStaticInstanceTypesCache(dataset)}
+}
+
+// GetInstanceType looks the instance type up in the wrapped cache, falling back to the dataset if that fails for
+// another reason than an unknown instance type.
+func (f *fallbackInstanceTypesCache) GetInstanceType(awsClient awsclient.Client, cacheID string, instanceType string) (InstanceType, error) {
+	instanceTypeInfo, err := f.cache.GetInstanceType(awsClient, cacheID, instanceType)
+	if err == nil || errors.Is(err, annotatorerrors.ErrUnknownInstanceType) {
+		return instanceTypeInfo, err
+	}
+
+	fallback, fallbackErr := f.fallback.GetInstanceType(awsClient, cacheID, instanceType)
+	if fallbackErr != nil {
+		return InstanceType{}, err
+	}
+	klog.Warningf("Serving instance type %s of region %s from the embedded instance types dataset: %v", instanceType, cacheID, err)
+	fallback.Source = DataSourceFallback
+	return fallback, nil
+}
+
+// replace replaces the cached instance types of the region in the wrapped cache.
+func (f *fallbackInstanceTypesCache) replace(cacheID string, instanceTypes map[string]InstanceType) {
+	if cache, ok := f.cache.(instanceTypesReplacer); ok {
+		cache.replace(cacheID, instanceTypes)
+	}
+}
+
+// expire force-expires the given instance types in the wrapped cache.
+func (f *fallbackInstanceTypesCache) expire(cacheID string, instanceTypes []string) []string {
+	if cache, ok := f.cache.(instanceTypesExpirer); ok {
+		return cache.expire(cacheID, instanceTypes)
+	}
+	return nil
+}
+
+// expiring returns the regions of the wrapped cache expiring within the duration.
+func (f *fallbackInstanceTypesCache) expiring(within time.Duration) []string {
+	if cache, ok := f.cache.(instanceTypesRefresherCache); ok {
+		return cache.expiring(within)
+	}
+	return nil
+}
+
+// snapshot returns the cached regions of the wrapped cache. Instance types of the dataset are not cached.
+func (f *fallbackInstanceTypesCache) snapshot() map[string]persistedRegion {
+	if cache, ok := f.cache.(instanceTypesPersister); ok {
+		return cache.snapshot()
+	}
+	return nil
+}
+
+// restore restores the regions in the wrapped cache.
+func (f *fallbackInstanceTypesCache) restore(regions map[string]persistedRegion) []string {
+	if cache, ok := f.cache.(instanceTypesPersister); ok {
+		return cache.restore(regions)
+	}
+	return nil
+}
+
+// staleDataMessage returns the message of the event of MachineDeployments annotated from the embedded dataset.
+func staleDataMessage(instanceTypeInfo InstanceType, region string) string {
+	return fmt.Sprintf("The instance types of region %s could not be fetched from the EC2 API, annotated instance type %s with the embedded data of %s, which may be stale",
+		region, instanceTypeInfo.InstanceType, instanceTypeInfo.FetchedAt.UTC().Format(time.DateOnly))
+}
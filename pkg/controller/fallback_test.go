@@ -0,0 +1,98 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEmbeddedInstanceTypesDataset(t *testing.T) {
+	g := NewWithT(t)
+
+	dataset, err := EmbeddedInstanceTypesDataset()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(dataset.GeneratedAt).ToNot(BeNil())
+
+	cache := NewStaticInstanceTypesCache(dataset)
+	instanceType, err := cache.GetInstanceType(nil, "us-east-1", "m6g.large")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(instanceType.VCPU).To(Equal(int64(2)))
+	g.Expect(instanceType.MemoryMb).To(Equal(int64(8192)))
+	g.Expect(instanceType.CPUArchitecture).To(Equal(ArchitectureArm64))
+	g.Expect(instanceType.FetchedAt).To(Equal(*dataset.GeneratedAt))
+
+	instanceType, err = cache.GetInstanceType(nil, "us-east-1", "g5.12xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(instanceType.GPU).To(Equal(int64(4)))
+	g.Expect(instanceType.GPUName).To(Equal("A10G"))
+}
+
+func TestFallbackInstanceTypesCache(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	awsClient := &failingInstanceTypesClient{Client: fakeAWSClient, failing: true}
+	dataset, err := EmbeddedInstanceTypesDataset()
+	g.Expect(err).ToNot(HaveOccurred())
+	cache := NewFallbackInstanceTypesCache(NewInstanceTypesCache(), dataset)
+
+	// Instance types of the dataset are served while the EC2 API fails
+	instanceType, err := cache.GetInstanceType(awsClient, "us-east-1", "m6i.8xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(instanceType.Source).To(Equal(DataSourceFallback))
+	g.Expect(instanceType.VCPU).To(Equal(int64(32)))
+
+	// Others fail with the error of the EC2 API
+	_, err = cache.GetInstanceType(awsClient, "us-east-1", "a1.2xlarge")
+	g.Expect(err).To(MatchError(ContainSubstring("throttled")))
+
+	// Once the EC2 API recovers, its data is served again. Expiring the region retries the failed refresh right away.
+	awsClient.failing = false
+	cache.(instanceTypesExpirer).expire("us-east-1", nil)
+	instanceType, err = cache.GetInstanceType(awsClient, "us-east-1", "m6i.8xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(instanceType.Source).To(Equal(DataSourceAPI))
+
+	// Instance types unknown in the region are not served from the dataset
+	_, err = cache.GetInstanceType(awsClient, "us-east-1", "m5.large")
+	g.Expect(errors.Is(err, annotatorerrors.ErrUnknownInstanceType)).To(BeTrue())
+}
+
+func TestReconcileWithFallbackInstanceTypes(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "m5.large", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	dataset, err := EmbeddedInstanceTypesDataset()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	r.InstanceTypesCache = NewFallbackInstanceTypesCache(r.InstanceTypesCache, dataset)
+	r.AwsClientBuilder = OfflineAWSClientBuilder
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(cpuKey, "2"))
+	g.Expect(machineDeployment.Annotations[provenanceKey]).To(ContainSubstring("source=fallback"))
+	g.Expect(recorder.Events).To(Receive(ContainSubstring("StaleInstanceTypeData")))
+}
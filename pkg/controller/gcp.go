@@ -0,0 +1,155 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/client/gcp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	gcpMachineTemplateKind = "GCPMachineTemplate"
+	gcpClusterKind         = "GCPCluster"
+)
+
+// GCPProvider resolves the capacity of the machine types of GCPMachineTemplates from the Compute Engine API,
+// using the project and region of the GCPCluster of the Cluster of the MachineDeployment.
+type GCPProvider struct {
+	machineTypesClient gcp.MachineTypesClient
+	ttl                time.Duration
+
+	mutex sync.Mutex
+	// machineTypes holds the machine types per project, zone and name.
+	machineTypes map[string]InstanceType
+}
+
+// NewGCPProvider creates a GCP provider looking up the machine types with the client. Machine types are looked up
+// again after the ttl.
+func NewGCPProvider(machineTypesClient gcp.MachineTypesClient, ttl time.Duration) *GCPProvider {
+	return &GCPProvider{
+		machineTypesClient: machineTypesClient,
+		ttl:                ttl,
+		machineTypes:       map[string]InstanceType{},
+	}
+}
+
+// Name returns the name of the provider.
+func (p *GCPProvider) Name() string {
+	return "gcp"
+}
+
+// Handles returns true for GCPMachineTemplates.
+func (p *GCPProvider) Handles(ref corev1.ObjectReference) bool {
+	return ref.Kind == gcpMachineTemplateKind && ref.GroupVersionKind().Group == "infrastructure.cluster.x-k8s.io"
+}
+
+// ResolveCapacity resolves the capacity of the machine type of the GCPMachineTemplate of the MachineDeployment.
+// Machine types are zonal, they are looked up in the failure domain of the MachineDeployment, or in the first
+// failure domain of the GCPCluster.
+func (p *GCPProvider) ResolveCapacity(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (ProviderCapacity, error) {
+	ref := machineDeployment.Spec.Template.Spec.InfrastructureRef
+	template, err := getUnstructured(ctx, c, ref.APIVersion, ref.Kind, ref.Namespace, ref.Name, machineDeployment.Namespace)
+	if err != nil {
+		return ProviderCapacity{}, err
+	}
+	machineType, _, err := unstructured.NestedString(template.Object, "spec", "template", "spec", "instanceType")
+	if err != nil || machineType == "" {
+		return ProviderCapacity{}, fmt.Errorf("GCPMachineTemplate %s/%s has no instanceType", template.GetNamespace(), template.GetName())
+	}
+
+	gcpCluster, err := getInfrastructureCluster(ctx, c, machineDeployment, gcpClusterKind)
+	if err != nil {
+		return ProviderCapacity{}, err
+	}
+	project, _, _ := unstructured.NestedString(gcpCluster.Object, "spec", "project")
+	region, _, _ := unstructured.NestedString(gcpCluster.Object, "spec", "region")
+	if project == "" || region == "" {
+		return ProviderCapacity{}, fmt.Errorf("GCPCluster %s/%s has no project or region", gcpCluster.GetNamespace(), gcpCluster.GetName())
+	}
+
+	zone := pinnedFailureDomain(machineDeployment)
+	if zone == "" {
+		failureDomains, _, _ := unstructured.NestedMap(gcpCluster.Object, "status", "failureDomains")
+		zones := make([]string, 0, len(failureDomains))
+		for zone := range failureDomains {
+			zones = append(zones, zone)
+		}
+		if len(zones) == 0 {
+			return ProviderCapacity{}, fmt.Errorf("GCPCluster %s/%s has no failure domains", gcpCluster.GetNamespace(), gcpCluster.GetName())
+		}
+		sort.Strings(zones)
+		zone = zones[0]
+	}
+
+	instanceType, err := p.getMachineType(ctx, project, zone, machineType)
+	if err != nil {
+		return ProviderCapacity{}, err
+	}
+	return ProviderCapacity{Location: region, InstanceType: instanceType}, nil
+}
+
+// getMachineType returns the capacity of the machine type, looking it up if it is not cached or stale.
+func (p *GCPProvider) getMachineType(ctx context.Context, project, zone, name string) (InstanceType, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	cacheID := project + "/" + zone + "/" + name
+	if instanceType, ok := p.machineTypes[cacheID]; ok && time.Since(instanceType.FetchedAt) <= p.ttl {
+		instanceType.Source = DataSourceCache
+		return instanceType, nil
+	}
+
+	machineType, err := p.machineTypesClient.GetMachineType(ctx, project, zone, name)
+	if err != nil {
+		return InstanceType{}, err
+	}
+	instanceType := gcpInstanceType(machineType)
+	instanceType.FetchedAt = time.Now()
+	p.machineTypes[cacheID] = instanceType
+
+	instanceType.Source = DataSourceAPI
+	return instanceType, nil
+}
+
+// gcpInstanceType converts a machine type. The accelerators are the GPUs attached to every instance of the
+// machine type, GPUs attached to instances of other machine types are not known.
+func gcpInstanceType(machineType gcp.MachineType) InstanceType {
+	instanceType := InstanceType{
+		InstanceType:    machineType.Name,
+		VCPU:            machineType.GuestCpus,
+		MemoryMb:        machineType.MemoryMb,
+		CPUArchitecture: ArchitectureAmd64,
+	}
+	if machineType.Architecture == "ARM64" {
+		instanceType.CPUArchitecture = ArchitectureArm64
+	}
+	for _, accelerator := range machineType.Accelerators {
+		instanceType.GPU += accelerator.GuestAcceleratorCount
+		instanceType.GPUName = accelerator.GuestAcceleratorType
+		if strings.HasPrefix(accelerator.GuestAcceleratorType, "nvidia-") {
+			instanceType.GPUManufacturer = "NVIDIA"
+		}
+	}
+	return instanceType
+}
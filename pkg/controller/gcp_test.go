@@ -0,0 +1,166 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/client/gcp"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+)
+
+type fakeMachineTypesClient struct {
+	zones []string
+}
+
+func (c *fakeMachineTypesClient) GetMachineType(_ context.Context, project, zone, machineType string) (gcp.MachineType, error) {
+	c.zones = append(c.zones, zone)
+	switch machineType {
+	case "n2-standard-4":
+		return gcp.MachineType{Name: machineType, GuestCpus: 4, MemoryMb: 16384}, nil
+	case "a2-highgpu-2g":
+		return gcp.MachineType{Name: machineType, GuestCpus: 24, MemoryMb: 174080, Accelerators: []gcp.Accelerator{{GuestAcceleratorType: "nvidia-tesla-a100", GuestAcceleratorCount: 2}}}, nil
+	case "t2a-standard-4":
+		return gcp.MachineType{Name: machineType, GuestCpus: 4, MemoryMb: 16384, Architecture: "ARM64"}, nil
+	}
+	return gcp.MachineType{}, fmt.Errorf("machine type %s not found", machineType)
+}
+
+func newTestGCPObjects(namespace, machineType string) (*unstructured.Unstructured, *unstructured.Unstructured) {
+	template := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta1",
+		"kind":       "GCPMachineTemplate",
+		"metadata":   map[string]interface{}{"name": "test-gcp-template", "namespace": namespace},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{"spec": map[string]interface{}{"instanceType": machineType}},
+		},
+	}}
+	gcpCluster := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta1",
+		"kind":       "GCPCluster",
+		"metadata":   map[string]interface{}{"name": "test-cluster-gcp", "namespace": namespace},
+		"spec":       map[string]interface{}{"project": "test-project", "region": "us-central1"},
+		"status": map[string]interface{}{
+			"failureDomains": map[string]interface{}{
+				"us-central1-b": map[string]interface{}{"controlPlane": true},
+				"us-central1-a": map[string]interface{}{"controlPlane": true},
+			},
+		},
+	}}
+	return template, gcpCluster
+}
+
+func TestReconcileWithGCPProvider(t *testing.T) {
+	testCases := []struct {
+		name                string
+		machineType         string
+		failureDomain       string
+		expectErr           bool
+		expectedZone        string
+		expectedAnnotations map[string]string
+	}{
+		{
+			name:         "machine type",
+			machineType:  "n2-standard-4",
+			expectedZone: "us-central1-a",
+			expectedAnnotations: map[string]string{
+				cpuKey:    "4",
+				memoryKey: "16384",
+				gpuKey:    "0",
+			},
+		},
+		{
+			name:          "machine type with GPUs in the failure domain",
+			machineType:   "a2-highgpu-2g",
+			failureDomain: "us-central1-b",
+			expectedZone:  "us-central1-b",
+			expectedAnnotations: map[string]string{
+				cpuKey:    "24",
+				memoryKey: "174080",
+				gpuKey:    "2",
+			},
+		},
+		{
+			name:         "arm64 machine type",
+			machineType:  "t2a-standard-4",
+			expectedZone: "us-central1-a",
+			expectedAnnotations: map[string]string{
+				labelsKey: "kubernetes.io/arch=arm64",
+			},
+		},
+		{
+			name:         "unknown machine type",
+			machineType:  "unknown",
+			expectedZone: "us-central1-a",
+			expectErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, _, cluster, _, err := newTestMachineDeployment("default", "", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			template, gcpCluster := newTestGCPObjects("default", tc.machineType)
+			machineDeployment.Spec.Template.Spec.InfrastructureRef = corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+				Kind:       "GCPMachineTemplate",
+				Name:       template.GetName(),
+			}
+			if tc.failureDomain != "" {
+				machineDeployment.Spec.Template.Spec.FailureDomain = ptr.To(tc.failureDomain)
+			}
+			cluster.Spec.InfrastructureRef = &corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+				Kind:       "GCPCluster",
+				Name:       gcpCluster.GetName(),
+			}
+
+			machineTypesClient := &fakeMachineTypesClient{}
+			r := newTestReconciler(g, machineDeployment, template, cluster, gcpCluster)
+			r.Providers = []Provider{NewGCPProvider(machineTypesClient, time.Hour)}
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			g.Expect(machineTypesClient.zones).To(Equal([]string{tc.expectedZone}))
+			if tc.expectErr {
+				g.Expect(err).To(MatchError(ContainSubstring("machine type unknown not found")))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			for key, value := range tc.expectedAnnotations {
+				g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(key, value))
+			}
+		})
+	}
+}
+
+func TestGCPProviderCachesMachineTypes(t *testing.T) {
+	g := NewWithT(t)
+
+	machineTypesClient := &fakeMachineTypesClient{}
+	provider := NewGCPProvider(machineTypesClient, time.Hour)
+	for i := 0; i < 2; i++ {
+		instanceType, err := provider.getMachineType(ctx, "test-project", "us-central1-a", "n2-standard-4")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(instanceType.VCPU).To(Equal(int64(4)))
+	}
+	g.Expect(machineTypesClient.zones).To(HaveLen(1))
+}
@@ -0,0 +1,222 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/jsonpath"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// GenericTemplates configure the GenericProvider.
+type GenericTemplates struct {
+	Templates []GenericTemplate `json:"templates"`
+}
+
+// GenericTemplate describes where the EC2 instance type and the AWS region of the machines of an infrastructure
+// template kind are found, so that templates of providers without compiled-in types can be annotated.
+type GenericTemplate struct {
+	// APIVersion of the template, the version is ignored when matching infrastructure references.
+	APIVersion string `json:"apiVersion"`
+	// Kind of the template.
+	Kind string `json:"kind"`
+	// InstanceTypePath is the JSONPath of the instance type in the template, e.g. {.spec.template.spec.instanceType}.
+	InstanceTypePath string `json:"instanceTypePath"`
+	// RegionPath is the JSONPath of the region in the template.
+	RegionPath string `json:"regionPath,omitempty"`
+	// ClusterRegionPath is the JSONPath of the region in the infrastructure cluster of the Cluster, used if the
+	// template has no region.
+	ClusterRegionPath string `json:"clusterRegionPath,omitempty"`
+
+	instanceTypePath  *jsonpath.JSONPath
+	regionPath        *jsonpath.JSONPath
+	clusterRegionPath *jsonpath.JSONPath
+}
+
+// LoadGenericTemplates reads the generic templates from a YAML file.
+func LoadGenericTemplates(path string) (*GenericTemplates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read generic templates: %w", err)
+	}
+	return ParseGenericTemplates(data)
+}
+
+// ParseGenericTemplates parses and validates the YAML of generic templates.
+func ParseGenericTemplates(data []byte) (*GenericTemplates, error) {
+	templates := &GenericTemplates{}
+	if err := yaml.UnmarshalStrict(data, templates); err != nil {
+		return nil, fmt.Errorf("failed to parse generic templates: %w", err)
+	}
+	for i := range templates.Templates {
+		template := &templates.Templates[i]
+		if template.APIVersion == "" || template.Kind == "" {
+			return nil, fmt.Errorf("generic template %d must have an apiVersion and a kind", i)
+		}
+		if template.Kind == awsMachineTemplateKind {
+			return nil, fmt.Errorf("generic template %d: %s is handled by the controller itself", i, awsMachineTemplateKind)
+		}
+		if _, err := schema.ParseGroupVersion(template.APIVersion); err != nil {
+			return nil, fmt.Errorf("generic template %s has invalid apiVersion: %w", template.Kind, err)
+		}
+		if template.RegionPath == "" && template.ClusterRegionPath == "" {
+			return nil, fmt.Errorf("generic template %s must have a regionPath or a clusterRegionPath", template.Kind)
+		}
+
+		var err error
+		if template.instanceTypePath, err = parseJSONPath(template.Kind, "instanceTypePath", template.InstanceTypePath); err != nil {
+			return nil, err
+		}
+		if template.instanceTypePath == nil {
+			return nil, fmt.Errorf("generic template %s must have an instanceTypePath", template.Kind)
+		}
+		if template.regionPath, err = parseJSONPath(template.Kind, "regionPath", template.RegionPath); err != nil {
+			return nil, err
+		}
+		if template.clusterRegionPath, err = parseJSONPath(template.Kind, "clusterRegionPath", template.ClusterRegionPath); err != nil {
+			return nil, err
+		}
+	}
+	return templates, nil
+}
+
+// parseJSONPath parses the JSONPath of a field of a generic template. An empty path returns nil.
+func parseJSONPath(kind, field, path string) (*jsonpath.JSONPath, error) {
+	if path == "" {
+		return nil, nil
+	}
+	parsed := jsonpath.New(field)
+	if err := parsed.Parse(path); err != nil {
+		return nil, fmt.Errorf("generic template %s has invalid %s: %w", kind, field, err)
+	}
+	return parsed, nil
+}
+
+// evaluate returns the value of the JSONPath in the object, or an empty string if it is not found.
+func evaluate(path *jsonpath.JSONPath, obj *unstructured.Unstructured) (string, error) {
+	if path == nil {
+		return "", nil
+	}
+	results, err := path.FindResults(obj.Object)
+	if err != nil || len(results) == 0 || len(results[0]) == 0 {
+		// Missing fields are not an error, the caller falls back or reports the missing value
+		return "", nil
+	}
+	if len(results) > 1 || len(results[0]) > 1 {
+		return "", fmt.Errorf("JSONPath matches multiple values")
+	}
+	buf := &bytes.Buffer{}
+	if err := path.PrintResults(buf, results[0]); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// GenericProvider resolves the capacity of the machines of infrastructure templates configured with
+// GenericTemplates. The instance types are EC2 instance types looked up like those of AWSMachineTemplates.
+type GenericProvider struct {
+	templates          []GenericTemplate
+	awsClientBuilder   awsclient.AwsClientBuilderFuncType
+	regionCache        awsclient.RegionCache
+	instanceTypesCache InstanceTypesCache
+}
+
+// NewGenericProvider creates a generic provider sharing the AWS clients and caches of the reconciler.
+func NewGenericProvider(templates *GenericTemplates, r *Reconciler) *GenericProvider {
+	return &GenericProvider{
+		templates:          templates.Templates,
+		awsClientBuilder:   r.AwsClientBuilder,
+		regionCache:        r.RegionCache,
+		instanceTypesCache: r.InstanceTypesCache,
+	}
+}
+
+// Name returns the name of the provider.
+func (p *GenericProvider) Name() string {
+	return "generic"
+}
+
+// Handles returns true for the configured template kinds.
+func (p *GenericProvider) Handles(ref corev1.ObjectReference) bool {
+	return p.templateFor(ref) != nil
+}
+
+// templateFor returns the generic template matching the group and kind of the reference.
+func (p *GenericProvider) templateFor(ref corev1.ObjectReference) *GenericTemplate {
+	for i := range p.templates {
+		template := &p.templates[i]
+		if template.Kind == ref.Kind && schema.FromAPIVersionAndKind(template.APIVersion, template.Kind).Group == ref.GroupVersionKind().Group {
+			return template
+		}
+	}
+	return nil
+}
+
+// ResolveCapacity resolves the instance type and region with the JSONPaths of the generic template and looks up
+// the capacity of the instance type in the region.
+func (p *GenericProvider) ResolveCapacity(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (ProviderCapacity, error) {
+	ref := machineDeployment.Spec.Template.Spec.InfrastructureRef
+	config := p.templateFor(ref)
+	if config == nil {
+		return ProviderCapacity{}, fmt.Errorf("no generic template for %s", ref.Kind)
+	}
+
+	template, err := getUnstructured(ctx, c, ref.APIVersion, ref.Kind, ref.Namespace, ref.Name, machineDeployment.Namespace)
+	if err != nil {
+		return ProviderCapacity{}, err
+	}
+	instanceType, err := evaluate(config.instanceTypePath, template)
+	if err != nil || instanceType == "" {
+		return ProviderCapacity{}, fmt.Errorf("%s %s/%s has no instance type at %s: %v", ref.Kind, template.GetNamespace(), template.GetName(), config.InstanceTypePath, err)
+	}
+
+	region, err := evaluate(config.regionPath, template)
+	if err != nil {
+		return ProviderCapacity{}, fmt.Errorf("invalid region of %s %s/%s: %w", ref.Kind, template.GetNamespace(), template.GetName(), err)
+	}
+	if region == "" && config.clusterRegionPath != nil {
+		infrastructureCluster, err := getInfrastructureCluster(ctx, c, machineDeployment, "")
+		if err != nil {
+			return ProviderCapacity{}, err
+		}
+		if region, err = evaluate(config.clusterRegionPath, infrastructureCluster); err != nil {
+			return ProviderCapacity{}, fmt.Errorf("invalid region of %s %s/%s: %w", infrastructureCluster.GetKind(), infrastructureCluster.GetNamespace(), infrastructureCluster.GetName(), err)
+		}
+	}
+	if region == "" {
+		return ProviderCapacity{}, annotatorerrors.Errorf(annotatorerrors.ErrRegionNotResolved, "unable to determine AWS region of %s %s/%s", ref.Kind, template.GetNamespace(), template.GetName())
+	}
+
+	awsClient, err := p.awsClientBuilder(c, "", machineDeployment.Namespace, region, p.regionCache)
+	if err != nil {
+		return ProviderCapacity{}, fmt.Errorf("error creating aws client: %w", err)
+	}
+	instanceTypeInfo, err := p.instanceTypesCache.GetInstanceType(awsClient, region, instanceType)
+	if err != nil {
+		return ProviderCapacity{}, err
+	}
+	return ProviderCapacity{Location: region, InstanceType: instanceTypeInfo}, nil
+}
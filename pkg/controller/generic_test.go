@@ -0,0 +1,169 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testGenericTemplates = `
+templates:
+- apiVersion: infrastructure.example.com/v1alpha1
+  kind: ExampleMachineTemplate
+  instanceTypePath: "{.spec.template.spec.machineType}"
+  regionPath: "{.spec.template.spec.placement.region}"
+  clusterRegionPath: "{.spec.region}"
+`
+
+func TestParseGenericTemplates(t *testing.T) {
+	testCases := []struct {
+		name          string
+		yaml          string
+		expectedError string
+	}{
+		{
+			name: "valid templates",
+			yaml: testGenericTemplates,
+		},
+		{
+			name: "missing instance type path",
+			yaml: `
+templates:
+- apiVersion: infrastructure.example.com/v1alpha1
+  kind: ExampleMachineTemplate
+  regionPath: "{.spec.region}"
+`,
+			expectedError: "generic template ExampleMachineTemplate must have an instanceTypePath",
+		},
+		{
+			name: "missing region paths",
+			yaml: `
+templates:
+- apiVersion: infrastructure.example.com/v1alpha1
+  kind: ExampleMachineTemplate
+  instanceTypePath: "{.spec.template.spec.machineType}"
+`,
+			expectedError: "generic template ExampleMachineTemplate must have a regionPath or a clusterRegionPath",
+		},
+		{
+			name: "invalid JSONPath",
+			yaml: `
+templates:
+- apiVersion: infrastructure.example.com/v1alpha1
+  kind: ExampleMachineTemplate
+  instanceTypePath: "{.spec.template.spec.machineType"
+  regionPath: "{.spec.region}"
+`,
+			expectedError: "generic template ExampleMachineTemplate has invalid instanceTypePath",
+		},
+		{
+			name: "AWSMachineTemplate",
+			yaml: `
+templates:
+- apiVersion: infrastructure.cluster.x-k8s.io/v1beta2
+  kind: AWSMachineTemplate
+  instanceTypePath: "{.spec.template.spec.instanceType}"
+  regionPath: "{.spec.region}"
+`,
+			expectedError: "generic template 0: AWSMachineTemplate is handled by the controller itself",
+		},
+		{
+			name:          "unknown field",
+			yaml:          "templates:\n- kind: ExampleMachineTemplate\n  instanceType: m5.large\n",
+			expectedError: "failed to parse generic templates",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			_, err := ParseGenericTemplates([]byte(tc.yaml))
+			if tc.expectedError == "" {
+				g.Expect(err).ToNot(HaveOccurred())
+			} else {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectedError)))
+			}
+		})
+	}
+}
+
+func TestReconcileWithGenericProvider(t *testing.T) {
+	testCases := []struct {
+		name         string
+		placement    map[string]interface{}
+		instanceType string
+		expectErr    string
+	}{
+		{
+			name:         "region of the template",
+			placement:    map[string]interface{}{"region": "us-east-1"},
+			instanceType: "a1.2xlarge",
+		},
+		{
+			name:         "region of the infrastructure cluster",
+			instanceType: "a1.2xlarge",
+		},
+		{
+			name:      "missing instance type",
+			placement: map[string]interface{}{"region": "us-east-1"},
+			expectErr: "has no instance type at {.spec.template.spec.machineType}",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, _, cluster, awsCluster, err := newTestMachineDeployment("default", "", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			templateSpec := map[string]interface{}{}
+			if tc.instanceType != "" {
+				templateSpec["machineType"] = tc.instanceType
+			}
+			if tc.placement != nil {
+				templateSpec["placement"] = tc.placement
+			}
+			template := &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "infrastructure.example.com/v1alpha1",
+				"kind":       "ExampleMachineTemplate",
+				"metadata":   map[string]interface{}{"name": "test-example-template", "namespace": "default"},
+				"spec":       map[string]interface{}{"template": map[string]interface{}{"spec": templateSpec}},
+			}}
+			machineDeployment.Spec.Template.Spec.InfrastructureRef = corev1.ObjectReference{
+				APIVersion: "infrastructure.example.com/v1alpha1",
+				Kind:       "ExampleMachineTemplate",
+				Name:       template.GetName(),
+			}
+
+			templates, err := ParseGenericTemplates([]byte(testGenericTemplates))
+			g.Expect(err).ToNot(HaveOccurred())
+			r := newTestReconciler(g, machineDeployment, template, cluster, awsCluster)
+			r.Providers = []Provider{NewGenericProvider(templates, r)}
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			if tc.expectErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+			g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(memoryKey, "16384"))
+		})
+	}
+}
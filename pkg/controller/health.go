@@ -0,0 +1,112 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// AnnotationHealth summarizes whether the MachineDeployments visible to the reconciler have complete annotations.
+type AnnotationHealth struct {
+	Healthy    bool     `json:"healthy"`
+	Total      int      `json:"total"`
+	Annotated  int      `json:"annotated"`
+	Incomplete []string `json:"incomplete,omitempty"`
+}
+
+// BuildAnnotationHealth checks the annotations of all MachineDeployments visible to the reconciler.
+// MachineDeployments being deleted or not annotated by the reconciler, e.g. because they opted out, are not in scope.
+func (r *Reconciler) BuildAnnotationHealth(ctx context.Context) (*AnnotationHealth, error) {
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := r.Client.List(ctx, machineDeployments); err != nil {
+		return nil, fmt.Errorf("failed to list MachineDeployments: %w", err)
+	}
+
+	health := &AnnotationHealth{}
+	for i := range machineDeployments.Items {
+		machineDeployment := &machineDeployments.Items[i]
+		if !machineDeployment.DeletionTimestamp.IsZero() || !r.inScope(machineDeployment) {
+			continue
+		}
+
+		health.Total++
+		if r.hasCompleteAnnotations(machineDeployment.Annotations) {
+			health.Annotated++
+			continue
+		}
+		health.Incomplete = append(health.Incomplete, machineDeployment.Namespace+"/"+machineDeployment.Name)
+	}
+
+	sort.Strings(health.Incomplete)
+	health.Healthy = health.Annotated == health.Total
+	return health, nil
+}
+
+// hasCompleteAnnotations returns true if all annotations the reconciler writes for an instance type are present.
+// The GPU annotation is not required if OmitZeroGPU is set, since it is left out for instance types without GPUs.
+func (r *Reconciler) hasCompleteAnnotations(annotations map[string]string) bool {
+	cpu, memory, gpu := r.AnnotationScheme.keys()
+
+	required := []string{cpu, memory, labelsKey}
+	if !r.OmitZeroGPU {
+		required = append(required, gpu)
+	}
+	if r.AdditionalMemoryKey != "" {
+		required = append(required, r.AdditionalMemoryKey)
+	}
+
+	for _, key := range required {
+		if _, ok := annotations[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// AnnotationHealthHandler serves the annotation health of the MachineDeployments as JSON. It responds with
+// 200 if all MachineDeployments have complete annotations and with 503 otherwise, so that it can be used
+// as an HTTP health check.
+type AnnotationHealthHandler struct {
+	// Reconciler is used to list the MachineDeployments.
+	Reconciler *Reconciler
+}
+
+// ServeHTTP serves the current annotation health.
+func (h *AnnotationHealthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	health, err := h.Reconciler.BuildAnnotationHealth(req.Context())
+	if err != nil {
+		klog.Errorf("Failed to check annotation health: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(health)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !health.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(data)
+}
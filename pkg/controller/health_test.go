@@ -0,0 +1,130 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestAnnotationHealth(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "workers"
+
+	unknownMachineDeployment := machineDeployment.DeepCopy()
+	unknownMachineDeployment.Name = "unknown"
+	unknownMachineDeployment.Spec.Template.Spec.InfrastructureRef.Name = "missing"
+
+	r := newTestReconciler(g, machineDeployment, unknownMachineDeployment, awsMachineTemplate, cluster, awsCluster)
+	handler := &AnnotationHealthHandler{Reconciler: r}
+
+	// Nothing was reconciled yet
+	health, err := r.BuildAnnotationHealth(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(health).To(Equal(&AnnotationHealth{
+		Healthy:    false,
+		Total:      2,
+		Annotated:  0,
+		Incomplete: []string{"default/unknown", "default/workers"},
+	}))
+
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	health, err = r.BuildAnnotationHealth(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(health).To(Equal(&AnnotationHealth{
+		Healthy:    false,
+		Total:      2,
+		Annotated:  1,
+		Incomplete: []string{"default/unknown"},
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/annotation-health", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+	g.Expect(recorder.Body.String()).To(ContainSubstring(`"incomplete":["default/unknown"]`))
+
+	g.Expect(r.Client.Delete(ctx, unknownMachineDeployment)).To(Succeed())
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/annotation-health", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	g.Expect(recorder.Body.String()).To(Equal(`{"healthy":true,"total":1,"annotated":1}`))
+}
+
+func TestHasCompleteAnnotations(t *testing.T) {
+	testCases := []struct {
+		name        string
+		reconciler  *Reconciler
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			name:       "complete",
+			reconciler: &Reconciler{},
+			annotations: map[string]string{
+				cpuKey: "8", memoryKey: "16384", gpuKey: "0", labelsKey: "kubernetes.io/arch=amd64",
+			},
+			expected: true,
+		},
+		{
+			name:       "missing GPU",
+			reconciler: &Reconciler{},
+			annotations: map[string]string{
+				cpuKey: "8", memoryKey: "16384", labelsKey: "kubernetes.io/arch=amd64",
+			},
+			expected: false,
+		},
+		{
+			name:       "GPU omitted",
+			reconciler: &Reconciler{OmitZeroGPU: true},
+			annotations: map[string]string{
+				cpuKey: "8", memoryKey: "16384", labelsKey: "kubernetes.io/arch=amd64",
+			},
+			expected: true,
+		},
+		{
+			name:       "missing additional memory key",
+			reconciler: &Reconciler{AdditionalMemoryKey: "example.com/memory"},
+			annotations: map[string]string{
+				cpuKey: "8", memoryKey: "16384", gpuKey: "0", labelsKey: "kubernetes.io/arch=amd64",
+			},
+			expected: false,
+		},
+		{
+			name:       "other annotation scheme",
+			reconciler: &Reconciler{AnnotationScheme: AnnotationSchemeClusterAutoscaler},
+			annotations: map[string]string{
+				cpuKey: "8", memoryKey: "16384", gpuKey: "0", labelsKey: "kubernetes.io/arch=amd64",
+			},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(tc.reconciler.hasCompleteAnnotations(tc.annotations)).To(Equal(tc.expected))
+		})
+	}
+}
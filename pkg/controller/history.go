@@ -0,0 +1,168 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ReconcileOutcome is the outcome of a single reconcile of a MachineDeployment.
+type ReconcileOutcome struct {
+	Time     time.Time `json:"time"`
+	Duration string    `json:"duration"`
+	// Result is the result label of the reconcile metrics, e.g. success or failed.
+	Result string `json:"result"`
+	// Error is the error or failure reason of reconciles that did not succeed.
+	Error string `json:"error,omitempty"`
+	// Values are the annotations managed by the controller after the reconcile.
+	Values map[string]string `json:"values,omitempty"`
+	// Changes are the annotation changes made by the reconcile.
+	Changes map[string]AnnotationChange `json:"changes,omitempty"`
+}
+
+// reconcileOutcomes is a ring buffer of the last reconcile outcomes of a MachineDeployment.
+type reconcileOutcomes struct {
+	outcomes []ReconcileOutcome
+	// next is the index the next outcome is written to once the buffer is full.
+	next int
+}
+
+// reconcileHistory keeps the last reconcile outcomes per MachineDeployment in memory, so that the recent
+// activity of the controller can be inspected without raising the log verbosity. Access is synchronized via mutex.
+type reconcileHistory struct {
+	// size is the number of outcomes kept per MachineDeployment.
+	size int
+
+	outcomes map[types.NamespacedName]*reconcileOutcomes
+	mutex    sync.Mutex
+}
+
+// newReconcileHistory creates a history keeping the last size outcomes per MachineDeployment.
+func newReconcileHistory(size int) *reconcileHistory {
+	return &reconcileHistory{
+		size:     size,
+		outcomes: map[types.NamespacedName]*reconcileOutcomes{},
+	}
+}
+
+// record adds the outcome to the history of the MachineDeployment, replacing its oldest outcome if the history is full.
+func (h *reconcileHistory) record(key types.NamespacedName, outcome ReconcileOutcome) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	buffer, ok := h.outcomes[key]
+	if !ok {
+		buffer = &reconcileOutcomes{outcomes: make([]ReconcileOutcome, 0, h.size)}
+		h.outcomes[key] = buffer
+	}
+	if len(buffer.outcomes) < h.size {
+		buffer.outcomes = append(buffer.outcomes, outcome)
+		return
+	}
+	buffer.outcomes[buffer.next] = outcome
+	buffer.next = (buffer.next + 1) % h.size
+}
+
+// forget removes the history of a deleted MachineDeployment.
+func (h *reconcileHistory) forget(key types.NamespacedName) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.outcomes, key)
+}
+
+// get returns the outcomes of the MachineDeployment, oldest first.
+func (h *reconcileHistory) get(key types.NamespacedName) []ReconcileOutcome {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	buffer, ok := h.outcomes[key]
+	if !ok {
+		return nil
+	}
+	outcomes := make([]ReconcileOutcome, 0, len(buffer.outcomes))
+	outcomes = append(outcomes, buffer.outcomes[buffer.next:]...)
+	return append(outcomes, buffer.outcomes[:buffer.next]...)
+}
+
+// all returns the outcomes of all MachineDeployments by namespace/name, oldest first.
+func (h *reconcileHistory) all() map[string][]ReconcileOutcome {
+	h.mutex.Lock()
+	keys := make([]types.NamespacedName, 0, len(h.outcomes))
+	for key := range h.outcomes {
+		keys = append(keys, key)
+	}
+	h.mutex.Unlock()
+
+	all := make(map[string][]ReconcileOutcome, len(keys))
+	for _, key := range keys {
+		if outcomes := h.get(key); outcomes != nil {
+			all[key.String()] = outcomes
+		}
+	}
+	return all
+}
+
+// managedValues returns the annotations recorded as managed by the controller.
+func managedValues(annotations map[string]string) map[string]string {
+	values := map[string]string{}
+	for _, key := range getManagedKeys(annotations) {
+		if value, ok := annotations[key]; ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// ReconcileHistoryHandler serves the reconcile history as JSON. With the namespace and name query
+// parameters, only the history of that MachineDeployment is served.
+type ReconcileHistoryHandler struct {
+	// Reconciler is the reconciler keeping the history.
+	Reconciler *Reconciler
+}
+
+// ServeHTTP serves the reconcile history.
+func (h *ReconcileHistoryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	history := h.Reconciler.history
+	if history == nil {
+		http.Error(w, "reconcile history is disabled", http.StatusNotFound)
+		return
+	}
+
+	var body interface{}
+	if name := req.URL.Query().Get("name"); name != "" {
+		key := types.NamespacedName{Namespace: req.URL.Query().Get("namespace"), Name: name}
+		outcomes := history.get(key)
+		if outcomes == nil {
+			http.Error(w, "no reconcile history of "+key.String(), http.StatusNotFound)
+			return
+		}
+		body = outcomes
+	} else {
+		body = history.all()
+	}
+
+	data, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
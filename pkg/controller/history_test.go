@@ -0,0 +1,114 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcileHistoryRingBuffer(t *testing.T) {
+	g := NewWithT(t)
+
+	history := newReconcileHistory(3)
+	key := types.NamespacedName{Namespace: "default", Name: "md"}
+	g.Expect(history.get(key)).To(BeNil())
+
+	results := []string{"1", "2", "3", "4", "5"}
+	for i, result := range results {
+		history.record(key, ReconcileOutcome{Result: result})
+
+		// Only the last outcomes are kept, oldest first
+		expected := results[max(0, i-2) : i+1]
+		outcomes := history.get(key)
+		g.Expect(outcomes).To(HaveLen(len(expected)))
+		for j, outcome := range outcomes {
+			g.Expect(outcome.Result).To(Equal(expected[j]))
+		}
+	}
+
+	other := types.NamespacedName{Namespace: "other", Name: "md"}
+	history.record(other, ReconcileOutcome{Result: "1"})
+	g.Expect(history.all()).To(HaveLen(2))
+
+	history.forget(key)
+	g.Expect(history.get(key)).To(BeNil())
+	g.Expect(history.all()).To(HaveKey("other/md"))
+}
+
+func TestReconcileHistory(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "history"
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.history = newReconcileHistory(10)
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+
+	// The first reconcile sets the annotations, the second one does not change them
+	for i := 0; i < 2; i++ {
+		_, err = r.Reconcile(ctx, req)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	// An unknown instance type fails the reconcile
+	awsMachineTemplate.Spec.Template.Spec.InstanceType = "invalid"
+	g.Expect(r.Client.Update(ctx, awsMachineTemplate)).To(Succeed())
+	_, err = r.Reconcile(ctx, req)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	outcomes := r.history.get(req.NamespacedName)
+	g.Expect(outcomes).To(HaveLen(3))
+
+	g.Expect(outcomes[0].Result).To(Equal(metrics.ResultSuccess))
+	g.Expect(outcomes[0].Values).To(HaveKeyWithValue(cpuKey, "8"))
+	g.Expect(outcomes[0].Changes).To(HaveKey(cpuKey))
+	g.Expect(outcomes[0].Error).To(BeEmpty())
+
+	g.Expect(outcomes[1].Result).To(Equal(metrics.ResultSuccess))
+	g.Expect(outcomes[1].Values).To(HaveKeyWithValue(cpuKey, "8"))
+	g.Expect(outcomes[1].Changes).To(BeEmpty())
+
+	g.Expect(outcomes[2].Result).To(Equal(metrics.ResultFailed))
+	g.Expect(outcomes[2].Error).To(ContainSubstring("unknown instance type invalid"))
+
+	// The history is served by the handler
+	handler := &ReconcileHistoryHandler{Reconciler: r}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/reconcile-history?namespace=default&name=history", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	served := []ReconcileOutcome{}
+	g.Expect(json.Unmarshal(recorder.Body.Bytes(), &served)).To(Succeed())
+	g.Expect(served).To(HaveLen(3))
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/reconcile-history?namespace=default&name=unknown", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusNotFound))
+
+	// The history is forgotten once the MachineDeployment is deleted
+	g.Expect(r.Client.Delete(ctx, machineDeployment)).To(Succeed())
+	_, err = r.Reconcile(ctx, req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(r.history.get(req.NamespacedName)).To(BeNil())
+}
@@ -0,0 +1,245 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// maxIdentityChainLength bounds the sourceIdentityRef chain of AWSClusterRoleIdentities, so that a cycle
+	// does not hang the reconcile.
+	maxIdentityChainLength = 10
+
+	// roleARNKey is the annotation of MachineDeployments and Clusters naming a role assumed for the AWS calls,
+	// e.g. of the member account of the Cluster.
+	roleARNKey = "capa-annotator/role-arn"
+)
+
+// ParseRoleARNPatterns parses a comma-separated list of role ARN patterns, in the syntax of path.Match.
+func ParseRoleARNPatterns(value string) ([]string, error) {
+	patterns := []string{}
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid role ARN pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// identity returns the identity of the Cluster of the MachineDeployment, followed by the role of the role-arn
+// annotation of the MachineDeployment or, without one, of its Cluster. The role is only assumed if it matches
+// RoleARNPatterns.
+func (r *Reconciler) identity(ctx context.Context, machineDeployment *clusterv1.MachineDeployment) (awsclient.Identity, error) {
+	identity, err := r.clusterIdentity(ctx, machineDeployment)
+	if err != nil {
+		return identity, err
+	}
+
+	roleARN, err := r.annotatedRoleARN(ctx, machineDeployment)
+	if err != nil || roleARN == "" {
+		return identity, err
+	}
+	identity.Roles = append(identity.Roles, awsclient.AssumeRole{RoleARN: roleARN})
+	return identity, nil
+}
+
+// annotatedRoleARN returns the role-arn annotation of the MachineDeployment or its Cluster. The annotation is
+// ignored without RoleARNPatterns.
+func (r *Reconciler) annotatedRoleARN(ctx context.Context, machineDeployment *clusterv1.MachineDeployment) (string, error) {
+	if len(r.RoleARNPatterns) == 0 {
+		return "", nil
+	}
+
+	roleARN := machineDeployment.Annotations[roleARNKey]
+	if roleARN == "" && machineDeployment.Spec.ClusterName != "" {
+		cluster := &clusterv1.Cluster{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: machineDeployment.Namespace, Name: machineDeployment.Spec.ClusterName}, cluster); err != nil {
+			if apierrors.IsNotFound(err) {
+				return "", nil
+			}
+			return "", annotatorerrors.NewLookupError("Cluster", machineDeployment.Namespace, machineDeployment.Spec.ClusterName, err)
+		}
+		roleARN = cluster.Annotations[roleARNKey]
+	}
+	if roleARN == "" {
+		return "", nil
+	}
+
+	for _, pattern := range r.RoleARNPatterns {
+		if matched, _ := path.Match(pattern, roleARN); matched {
+			return roleARN, nil
+		}
+	}
+	return "", annotatorerrors.Errorf(annotatorerrors.ErrIdentityNotAllowed, "role %s of the %s annotation is not allowed", roleARN, roleARNKey)
+}
+
+// clusterIdentity returns the identity the Cluster of the MachineDeployment authenticates with: the Secret of its
+// AWSClusterStaticIdentity, or the controller's own credentials, followed by the roles of the AWSClusterRoleIdentities
+// of the sourceIdentityRef chain. The controller's own credentials are used without IdentitySecretNamespace, for
+// Clusters without an identityRef and for AWSClusterControllerIdentities.
+func (r *Reconciler) clusterIdentity(ctx context.Context, machineDeployment *clusterv1.MachineDeployment) (awsclient.Identity, error) {
+	identity := awsclient.Identity{}
+	if r.IdentitySecretNamespace == "" || machineDeployment.Spec.ClusterName == "" {
+		return identity, nil
+	}
+
+	ref, err := r.identityRef(ctx, machineDeployment)
+	if err != nil || ref == nil {
+		return identity, err
+	}
+
+	// The roles are collected from the Cluster to the source identity and assumed in reverse order
+	for range maxIdentityChainLength {
+		switch ref.Kind {
+		case infrav1.ControllerIdentityKind:
+			slices.Reverse(identity.Roles)
+			return identity, nil
+		case infrav1.ClusterStaticIdentityKind:
+			staticIdentity := &infrav1.AWSClusterStaticIdentity{}
+			if err := r.Client.Get(ctx, client.ObjectKey{Name: ref.Name}, staticIdentity); err != nil {
+				return identity, annotatorerrors.NewLookupError("AWSClusterStaticIdentity", "", ref.Name, err)
+			}
+			if err := r.checkIdentityAllowed(ctx, staticIdentity.Spec.AllowedNamespaces, machineDeployment.Namespace); err != nil {
+				return identity, fmt.Errorf("AWSClusterStaticIdentity %s: %w", ref.Name, err)
+			}
+			identity.SecretName = staticIdentity.Spec.SecretRef
+			identity.SecretNamespace = r.IdentitySecretNamespace
+			slices.Reverse(identity.Roles)
+			return identity, nil
+		case infrav1.ClusterRoleIdentityKind:
+			roleIdentity := &infrav1.AWSClusterRoleIdentity{}
+			if err := r.Client.Get(ctx, client.ObjectKey{Name: ref.Name}, roleIdentity); err != nil {
+				return identity, annotatorerrors.NewLookupError("AWSClusterRoleIdentity", "", ref.Name, err)
+			}
+			if err := r.checkIdentityAllowed(ctx, roleIdentity.Spec.AllowedNamespaces, machineDeployment.Namespace); err != nil {
+				return identity, fmt.Errorf("AWSClusterRoleIdentity %s: %w", ref.Name, err)
+			}
+			spec := roleIdentity.Spec
+			identity.Roles = append(identity.Roles, awsclient.AssumeRole{
+				RoleARN:      spec.RoleArn,
+				SessionName:  spec.SessionName,
+				ExternalID:   spec.ExternalID,
+				Duration:     time.Duration(spec.DurationSeconds) * time.Second,
+				InlinePolicy: spec.InlinePolicy,
+				PolicyARNs:   spec.PolicyARNs,
+			})
+			// Roles without a source identity are assumed with the controller's own credentials
+			if spec.SourceIdentityRef == nil {
+				slices.Reverse(identity.Roles)
+				return identity, nil
+			}
+			ref = spec.SourceIdentityRef
+		default:
+			return identity, annotatorerrors.Errorf(annotatorerrors.ErrUnsupportedIdentity, "unsupported identityRef kind %s", ref.Kind)
+		}
+	}
+
+	return identity, annotatorerrors.Errorf(annotatorerrors.ErrUnsupportedIdentity, "sourceIdentityRef chain longer than %d identities", maxIdentityChainLength)
+}
+
+// awsClientOf returns the AWS client of the region authenticating with the identity. Without IdentityClientBuilder,
+// the roles of the identity are not assumed.
+func (r *Reconciler) awsClientOf(identity awsclient.Identity, region string) (awsclient.Client, error) {
+	if len(identity.Roles) > 0 && r.IdentityClientBuilder != nil {
+		return r.IdentityClientBuilder(r.Client, identity, region, r.RegionCache)
+	}
+	return r.AwsClientBuilder(r.Client, identity.SecretName, identity.SecretNamespace, region, r.RegionCache)
+}
+
+// identityRef returns the identityRef of the AWSCluster or, for EKS clusters, the AWSManagedControlPlane of the
+// Cluster of the MachineDeployment. A missing Cluster or infrastructure cluster has no identityRef.
+func (r *Reconciler) identityRef(ctx context.Context, machineDeployment *clusterv1.MachineDeployment) (*infrav1.AWSIdentityReference, error) {
+	cluster := &clusterv1.Cluster{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: machineDeployment.Namespace, Name: machineDeployment.Spec.ClusterName}, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, annotatorerrors.NewLookupError("Cluster", machineDeployment.Namespace, machineDeployment.Spec.ClusterName, err)
+	}
+
+	ref := cluster.Spec.InfrastructureRef
+	if controlPlaneRef := cluster.Spec.ControlPlaneRef; controlPlaneRef != nil && controlPlaneRef.Kind == AWSManagedControlPlaneGVK.Kind {
+		ref = controlPlaneRef
+	}
+	if ref == nil || (ref.Kind != "AWSCluster" && ref.Kind != AWSManagedControlPlaneGVK.Kind) {
+		return nil, nil
+	}
+
+	obj, err := getUnstructured(ctx, r.Client, ref.APIVersion, ref.Kind, ref.Namespace, ref.Name, cluster.Namespace)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	kind, _, _ := unstructured.NestedString(obj.Object, "spec", "identityRef", "kind")
+	name, _, _ := unstructured.NestedString(obj.Object, "spec", "identityRef", "name")
+	if kind == "" {
+		return nil, nil
+	}
+	return &infrav1.AWSIdentityReference{Kind: infrav1.AWSIdentityKind(kind), Name: name}, nil
+}
+
+// checkIdentityAllowed returns an error unless Clusters in the namespace may use the identity, following the rules
+// of CAPA: nil allowedNamespaces allow no namespace and empty allowedNamespaces allow all namespaces.
+func (r *Reconciler) checkIdentityAllowed(ctx context.Context, allowed *infrav1.AllowedNamespaces, namespace string) error {
+	if allowed == nil {
+		return annotatorerrors.Errorf(annotatorerrors.ErrIdentityNotAllowed, "namespace %s is not allowed to use the identity", namespace)
+	}
+	if len(allowed.NamespaceList) == 0 && len(allowed.Selector.MatchLabels) == 0 && len(allowed.Selector.MatchExpressions) == 0 {
+		return nil
+	}
+	if slices.Contains(allowed.NamespaceList, namespace) {
+		return nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&allowed.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid allowedNamespaces selector: %w", err)
+	}
+	// An empty selector matches no namespace if a list is given
+	if !selector.Empty() {
+		ns := &corev1.Namespace{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+			return annotatorerrors.NewLookupError("Namespace", "", namespace, err)
+		}
+		if selector.Matches(labels.Set(ns.Labels)) {
+			return nil
+		}
+	}
+	return annotatorerrors.Errorf(annotatorerrors.ErrIdentityNotAllowed, "namespace %s is not allowed to use the identity", namespace)
+}
@@ -0,0 +1,440 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newTestStaticIdentity(name, secretRef string, allowed *infrav1.AllowedNamespaces) *infrav1.AWSClusterStaticIdentity {
+	return &infrav1.AWSClusterStaticIdentity{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: infrav1.AWSClusterStaticIdentitySpec{
+			AWSClusterIdentitySpec: infrav1.AWSClusterIdentitySpec{AllowedNamespaces: allowed},
+			SecretRef:              secretRef,
+		},
+	}
+}
+
+func newTestRoleIdentity(name string, source *infrav1.AWSIdentityReference) *infrav1.AWSClusterRoleIdentity {
+	return &infrav1.AWSClusterRoleIdentity{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: infrav1.AWSClusterRoleIdentitySpec{
+			AWSClusterIdentitySpec: infrav1.AWSClusterIdentitySpec{AllowedNamespaces: &infrav1.AllowedNamespaces{}},
+			AWSRoleSpec:            infrav1.AWSRoleSpec{RoleArn: "arn:aws:iam::123456789012:role/" + name},
+			SourceIdentityRef:      source,
+		},
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	staticRef := &infrav1.AWSIdentityReference{Kind: infrav1.ClusterStaticIdentityKind, Name: "static"}
+	roleRef := &infrav1.AWSIdentityReference{Kind: infrav1.ClusterRoleIdentityKind, Name: "role"}
+
+	testCases := []struct {
+		name            string
+		secretNamespace string
+		identityRef     *infrav1.AWSIdentityReference
+		identities      []client.Object
+		expectedSecret  string
+		expectedRoles   []string
+		expectedErr     error
+	}{
+		{
+			name:           "identities disabled",
+			identityRef:    staticRef,
+			identities:     []client.Object{newTestStaticIdentity("static", "static-credentials", &infrav1.AllowedNamespaces{})},
+			expectedSecret: "",
+		},
+		{
+			name:            "no identityRef",
+			secretNamespace: "capa-system",
+			expectedSecret:  "",
+		},
+		{
+			name:            "controller identity",
+			secretNamespace: "capa-system",
+			identityRef:     &infrav1.AWSIdentityReference{Kind: infrav1.ControllerIdentityKind, Name: "default"},
+			expectedSecret:  "",
+		},
+		{
+			name:            "static identity allowing all namespaces",
+			secretNamespace: "capa-system",
+			identityRef:     staticRef,
+			identities:      []client.Object{newTestStaticIdentity("static", "static-credentials", &infrav1.AllowedNamespaces{})},
+			expectedSecret:  "static-credentials",
+		},
+		{
+			name:            "static identity allowing the namespace",
+			secretNamespace: "capa-system",
+			identityRef:     staticRef,
+			identities: []client.Object{newTestStaticIdentity("static", "static-credentials", &infrav1.AllowedNamespaces{
+				NamespaceList: []string{"default"},
+			})},
+			expectedSecret: "static-credentials",
+		},
+		{
+			name:            "static identity selecting the namespace",
+			secretNamespace: "capa-system",
+			identityRef:     staticRef,
+			identities: []client.Object{
+				newTestStaticIdentity("static", "static-credentials", &infrav1.AllowedNamespaces{
+					Selector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+				}),
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "a"}}},
+			},
+			expectedSecret: "static-credentials",
+		},
+		{
+			name:            "static identity not allowing the namespace",
+			secretNamespace: "capa-system",
+			identityRef:     staticRef,
+			identities: []client.Object{newTestStaticIdentity("static", "static-credentials", &infrav1.AllowedNamespaces{
+				NamespaceList: []string{"other"},
+			})},
+			expectedErr: annotatorerrors.ErrIdentityNotAllowed,
+		},
+		{
+			name:            "static identity without allowed namespaces",
+			secretNamespace: "capa-system",
+			identityRef:     staticRef,
+			identities:      []client.Object{newTestStaticIdentity("static", "static-credentials", nil)},
+			expectedErr:     annotatorerrors.ErrIdentityNotAllowed,
+		},
+		{
+			name:            "missing static identity",
+			secretNamespace: "capa-system",
+			identityRef:     staticRef,
+			expectedErr:     &annotatorerrors.LookupError{},
+		},
+		{
+			name:            "role identity with static source identity",
+			secretNamespace: "capa-system",
+			identityRef:     roleRef,
+			identities: []client.Object{
+				newTestRoleIdentity("role", staticRef),
+				newTestStaticIdentity("static", "static-credentials", &infrav1.AllowedNamespaces{}),
+			},
+			expectedSecret: "static-credentials",
+			expectedRoles:  []string{"arn:aws:iam::123456789012:role/role"},
+		},
+		{
+			name:            "role identity chain",
+			secretNamespace: "capa-system",
+			identityRef:     roleRef,
+			identities: []client.Object{
+				newTestRoleIdentity("role", &infrav1.AWSIdentityReference{Kind: infrav1.ClusterRoleIdentityKind, Name: "source-role"}),
+				newTestRoleIdentity("source-role", staticRef),
+				newTestStaticIdentity("static", "static-credentials", &infrav1.AllowedNamespaces{}),
+			},
+			expectedSecret: "static-credentials",
+			expectedRoles:  []string{"arn:aws:iam::123456789012:role/source-role", "arn:aws:iam::123456789012:role/role"},
+		},
+		{
+			name:            "role identity without source identity",
+			secretNamespace: "capa-system",
+			identityRef:     roleRef,
+			identities:      []client.Object{newTestRoleIdentity("role", nil)},
+			expectedSecret:  "",
+			expectedRoles:   []string{"arn:aws:iam::123456789012:role/role"},
+		},
+		{
+			name:            "role identity cycle",
+			secretNamespace: "capa-system",
+			identityRef:     roleRef,
+			identities:      []client.Object{newTestRoleIdentity("role", roleRef)},
+			expectedErr:     annotatorerrors.ErrUnsupportedIdentity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			awsCluster.Spec.IdentityRef = tc.identityRef
+
+			r := newTestReconciler(g, append([]client.Object{machineDeployment, awsMachineTemplate, cluster, awsCluster}, tc.identities...)...)
+			r.IdentitySecretNamespace = tc.secretNamespace
+
+			identity, err := r.identity(ctx, machineDeployment)
+			if tc.expectedErr != nil {
+				if _, ok := tc.expectedErr.(*annotatorerrors.LookupError); ok {
+					var lookupErr *annotatorerrors.LookupError
+					g.Expect(errors.As(err, &lookupErr)).To(BeTrue())
+					g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+				} else {
+					g.Expect(err).To(MatchError(tc.expectedErr))
+				}
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(identity.SecretName).To(Equal(tc.expectedSecret))
+			roles := []string{}
+			for _, role := range identity.Roles {
+				roles = append(roles, role.RoleARN)
+			}
+			g.Expect(roles).To(Equal(append([]string{}, tc.expectedRoles...)))
+			if tc.expectedSecret != "" {
+				g.Expect(identity.SecretNamespace).To(Equal(tc.secretNamespace))
+			}
+		})
+	}
+}
+
+func TestIdentityRoleARNAnnotation(t *testing.T) {
+	const (
+		memberRole = "arn:aws:iam::111111111111:role/capa-annotator"
+		otherRole  = "arn:aws:iam::222222222222:role/capa-annotator"
+	)
+
+	testCases := []struct {
+		name           string
+		patterns       []string
+		deploymentRole string
+		clusterRole    string
+		staticIdentity bool
+		expectedSecret string
+		expectedRoles  []string
+		expectedErr    error
+	}{
+		{
+			name:           "annotation ignored without patterns",
+			deploymentRole: memberRole,
+		},
+		{
+			name:           "role of the MachineDeployment",
+			patterns:       []string{"arn:aws:iam::*:role/capa-annotator"},
+			deploymentRole: memberRole,
+			expectedRoles:  []string{memberRole},
+		},
+		{
+			name:          "role of the Cluster",
+			patterns:      []string{"arn:aws:iam::*:role/capa-annotator"},
+			clusterRole:   memberRole,
+			expectedRoles: []string{memberRole},
+		},
+		{
+			name:           "role of the MachineDeployment takes precedence",
+			patterns:       []string{"arn:aws:iam::*:role/capa-annotator"},
+			deploymentRole: otherRole,
+			clusterRole:    memberRole,
+			expectedRoles:  []string{otherRole},
+		},
+		{
+			name:           "role assumed with the identity of the Cluster",
+			patterns:       []string{"arn:aws:iam::*:role/capa-annotator"},
+			deploymentRole: memberRole,
+			staticIdentity: true,
+			expectedSecret: "static-credentials",
+			expectedRoles:  []string{memberRole},
+		},
+		{
+			name:           "role not allowed",
+			patterns:       []string{"arn:aws:iam::222222222222:role/*"},
+			deploymentRole: memberRole,
+			expectedErr:    annotatorerrors.ErrIdentityNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			if tc.deploymentRole != "" {
+				machineDeployment.Annotations = map[string]string{roleARNKey: tc.deploymentRole}
+			}
+			if tc.clusterRole != "" {
+				cluster.Annotations = map[string]string{roleARNKey: tc.clusterRole}
+			}
+			objs := []client.Object{machineDeployment, awsMachineTemplate, cluster, awsCluster}
+			if tc.staticIdentity {
+				awsCluster.Spec.IdentityRef = &infrav1.AWSIdentityReference{Kind: infrav1.ClusterStaticIdentityKind, Name: "static"}
+				objs = append(objs, newTestStaticIdentity("static", "static-credentials", &infrav1.AllowedNamespaces{}))
+			}
+
+			r := newTestReconciler(g, objs...)
+			r.IdentitySecretNamespace = "capa-system"
+			r.RoleARNPatterns = tc.patterns
+
+			identity, err := r.identity(ctx, machineDeployment)
+			if tc.expectedErr != nil {
+				g.Expect(err).To(MatchError(tc.expectedErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(identity.SecretName).To(Equal(tc.expectedSecret))
+			roles := []string{}
+			for _, role := range identity.Roles {
+				roles = append(roles, role.RoleARN)
+			}
+			g.Expect(roles).To(Equal(append([]string{}, tc.expectedRoles...)))
+		})
+	}
+}
+
+func TestParseRoleARNPatterns(t *testing.T) {
+	g := NewWithT(t)
+
+	patterns, err := ParseRoleARNPatterns(" arn:aws:iam::*:role/capa-annotator,,arn:aws:iam::111111111111:role/* ")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(patterns).To(Equal([]string{"arn:aws:iam::*:role/capa-annotator", "arn:aws:iam::111111111111:role/*"}))
+
+	_, err = ParseRoleARNPatterns("arn:aws:iam::[:role/*")
+	g.Expect(err).To(MatchError(ContainSubstring(`invalid role ARN pattern "arn:aws:iam::[:role/*"`)))
+}
+
+func TestReconcileWithStaticIdentity(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "static-identity"
+	awsCluster.Spec.IdentityRef = &infrav1.AWSIdentityReference{Kind: infrav1.ClusterStaticIdentityKind, Name: "static"}
+	identity := newTestStaticIdentity("static", "static-credentials", &infrav1.AllowedNamespaces{})
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster, identity)
+	r.IdentitySecretNamespace = "capa-system"
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	var secretKeys []string
+	r.AwsClientBuilder = func(_ client.Client, secretName, namespace, _ string, _ awsclient.RegionCache) (awsclient.Client, error) {
+		secretKeys = append(secretKeys, namespace+"/"+secretName)
+		return fakeAWSClient, nil
+	}
+
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(secretKeys).To(ConsistOf("capa-system/static-credentials"))
+
+	updated := machineDeployment.DeepCopy()
+	g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machineDeployment), updated)).To(Succeed())
+	g.Expect(updated.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+}
+
+func TestReconcileWithRoleIdentity(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "role-identity"
+	awsCluster.Spec.IdentityRef = &infrav1.AWSIdentityReference{Kind: infrav1.ClusterRoleIdentityKind, Name: "role"}
+	identity := newTestRoleIdentity("role", nil)
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster, identity)
+	r.IdentitySecretNamespace = "capa-system"
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	var identities []awsclient.Identity
+	r.IdentityClientBuilder = func(_ client.Client, identity awsclient.Identity, _ string, _ awsclient.RegionCache) (awsclient.Client, error) {
+		identities = append(identities, identity)
+		return fakeAWSClient, nil
+	}
+
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(identities).To(HaveLen(1))
+	g.Expect(identities[0].SecretName).To(BeEmpty())
+	g.Expect(identities[0].Roles).To(HaveLen(1))
+	g.Expect(identities[0].Roles[0].RoleARN).To(Equal("arn:aws:iam::123456789012:role/role"))
+
+	updated := machineDeployment.DeepCopy()
+	g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machineDeployment), updated)).To(Succeed())
+	g.Expect(updated.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+}
+
+// offeringInstanceTypesClient only returns the offered instance types, e.g. of an account without access to some
+// instance types of the region.
+type offeringInstanceTypesClient struct {
+	awsclient.Client
+	offered  []string
+	requests int
+}
+
+func (c *offeringInstanceTypesClient) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	c.requests++
+	output, err := c.Client.DescribeInstanceTypes(input)
+	if err != nil {
+		return nil, err
+	}
+	offered := &ec2.DescribeInstanceTypesOutput{NextToken: output.NextToken}
+	for _, instanceType := range output.InstanceTypes {
+		if slices.Contains(c.offered, aws.StringValue(instanceType.InstanceType)) {
+			offered.InstanceTypes = append(offered.InstanceTypes, instanceType)
+		}
+	}
+	return offered, nil
+}
+
+func TestReconcileCachesInstanceTypesByIdentity(t *testing.T) {
+	g := NewWithT(t)
+
+	const memberRole = "arn:aws:iam::111111111111:role/capa-annotator"
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "controller-credentials"
+	memberDeployment := machineDeployment.DeepCopy()
+	memberDeployment.Name = "member-account"
+	memberDeployment.Annotations = map[string]string{roleARNKey: memberRole}
+
+	r := newTestReconciler(g, machineDeployment, memberDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.RoleARNPatterns = []string{memberRole}
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	// Both identities use the same region, the member account is not offered a1.2xlarge
+	controllerClient := &offeringInstanceTypesClient{Client: fakeAWSClient, offered: []string{"a1.2xlarge", "p2.16xlarge"}}
+	memberClient := &offeringInstanceTypesClient{Client: fakeAWSClient, offered: []string{"p2.16xlarge"}}
+	r.AwsClientBuilder = func(_ client.Client, _, _, _ string, _ awsclient.RegionCache) (awsclient.Client, error) {
+		return controllerClient, nil
+	}
+	r.IdentityClientBuilder = func(_ client.Client, _ awsclient.Identity, _ string, _ awsclient.RegionCache) (awsclient.Client, error) {
+		return memberClient, nil
+	}
+
+	for _, md := range []*clusterv1.MachineDeployment{machineDeployment, memberDeployment} {
+		_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(md)})
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(controllerClient.requests).To(Equal(1))
+	g.Expect(memberClient.requests).To(Equal(1))
+
+	// The instance types fetched with the controller's credentials are not served to the member account
+	updated := &clusterv1.MachineDeployment{}
+	g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machineDeployment), updated)).To(Succeed())
+	g.Expect(updated.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+	g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(memberDeployment), updated)).To(Succeed())
+	g.Expect(updated.Annotations).ToNot(HaveKey(cpuKey))
+
+	// Expiring the region expires it for all identities
+	g.Expect(r.ExpireCaches(awsCluster.Spec.Region, nil).Regions).To(ConsistOf(awsCluster.Spec.Region, awsCluster.Spec.Region+"/"+memberRole))
+}
@@ -0,0 +1,105 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	"github.com/jhjaggars/capa-annotator/pkg/version"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// lookupHashKey records the hash of the inputs of the last successful lookup together with the annotations
+// it resulted in, so that reconciles with unchanged inputs can skip the lookup.
+const lookupHashKey = "capa-annotator/lookup-hash"
+
+// lookupInputs returns the inputs determining the annotations of the MachineDeployment: the controller version
+// and configuration, the template, the instance type and the region. The generation of the MachineDeployment
+// covers its failure domains and the node labels and taints of its template.
+func (r *Reconciler) lookupInputs(machineDeployment *clusterv1.MachineDeployment, awsMachineTemplate *infrav1.AWSMachineTemplate, instanceType, region string) string {
+	return strings.Join([]string{
+		version.Version,
+		r.AnnotationConfigHash,
+		string(awsMachineTemplate.UID),
+		strconv.FormatInt(awsMachineTemplate.Generation, 10),
+		strconv.FormatInt(machineDeployment.Generation, 10),
+		instanceType,
+		region,
+	}, "\n")
+}
+
+// lookupHash returns the hash of the inputs and of all annotations except for the hash itself.
+func lookupHash(inputs string, annotations map[string]string) string {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		if key != lookupHashKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	hash.Write([]byte(inputs))
+	for _, key := range keys {
+		fmt.Fprintf(hash, "\n%s=%s", key, annotations[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+// lookupUnchanged returns whether the lookup of the MachineDeployment can be skipped, since neither its inputs
+// nor the annotations changed since the last successful lookup. Migrations between annotation schemes depend on
+// the time and data of the embedded fallback is replaced once the EC2 API is available, so they are never skipped.
+// Neither are lookups of data fetched longer than the periodic reannotation interval ago, nor lookups of
+// MachineDeployments whose capacity metrics are not exported yet, e.g. after a restart.
+func (r *Reconciler) lookupUnchanged(machineDeployment *clusterv1.MachineDeployment, inputs string) bool {
+	stored, ok := machineDeployment.Annotations[lookupHashKey]
+	if !r.SkipUnchangedLookups || !ok || r.migrationPending(machineDeployment.Annotations) {
+		return false
+	}
+	provenance, err := ParseProvenance(machineDeployment.Annotations[provenanceKey])
+	if err != nil || provenance.Source == DataSourceFallback {
+		return false
+	}
+	if r.PeriodicReannotationInterval > 0 && time.Since(provenance.FetchedAt) >= r.PeriodicReannotationInterval {
+		return false
+	}
+	if r.MachineDeploymentCapacityMetrics && !metrics.HasMachineDeploymentCapacity(client.ObjectKeyFromObject(machineDeployment)) {
+		return false
+	}
+	return stored == lookupHash(inputs, machineDeployment.Annotations)
+}
+
+// setLookupInputs records the inputs of the lookup of the running reconcile, so that their hash is recorded once
+// the MachineDeployment is annotated successfully.
+func setLookupInputs(ctx context.Context, inputs string) {
+	if status, ok := ctx.Value(reconcileStatusKey{}).(*reconcileStatus); ok {
+		status.lookupInputs = inputs
+	}
+}
+
+// setLookupHash records the hash of the inputs and the annotations.
+func setLookupHash(annotations map[string]string, inputs string) {
+	setManagedKeys(annotations, lookupHashKey)
+	annotations[lookupHashKey] = lookupHash(inputs, annotations)
+}
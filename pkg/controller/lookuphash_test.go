@@ -0,0 +1,90 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestSkipUnchangedLookups(t *testing.T) {
+	g := NewWithT(t)
+	metrics.SetNamespaceAllowList([]string{"skip-lookups"})
+	defer metrics.SetNamespaceAllowList(nil)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("skip-lookups", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "unchanged"
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.SkipUnchangedLookups = true
+	r.AnnotationConfigHash = "a"
+	builder := r.AwsClientBuilder
+	clients := 0
+	r.AwsClientBuilder = func(c client.Client, secretName, namespace, region string, regionCache awsclient.RegionCache) (awsclient.Client, error) {
+		clients++
+		return builder(c, secretName, namespace, region, regionCache)
+	}
+	request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+	skipped := func() float64 {
+		return testutil.ToFloat64(metrics.LookupsSkipped.WithLabelValues("skip-lookups"))
+	}
+
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(clients).To(Equal(1))
+	got := &clusterv1.MachineDeployment{}
+	g.Expect(r.Client.Get(ctx, request.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+	g.Expect(got.Annotations).To(HaveKey(lookupHashKey))
+	g.Expect(getManagedKeys(got.Annotations)).To(ContainElement(lookupHashKey))
+
+	// Unchanged inputs and annotations do not need an AWS client
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(clients).To(Equal(1))
+	g.Expect(skipped()).To(Equal(1.0))
+
+	// Drifted annotations are looked up again
+	g.Expect(r.Client.Get(ctx, request.NamespacedName, got)).To(Succeed())
+	delete(got.Annotations, cpuKey)
+	g.Expect(r.Client.Update(ctx, got)).To(Succeed())
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(clients).To(Equal(2))
+	g.Expect(r.Client.Get(ctx, request.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+
+	// So are MachineDeployments after the configuration changed
+	r.AnnotationConfigHash = "b"
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(clients).To(Equal(3))
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(clients).To(Equal(3))
+	g.Expect(skipped()).To(Equal(2.0))
+
+	// Without the option, the lookup is never skipped
+	r.SkipUnchangedLookups = false
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(clients).To(Equal(4))
+}
@@ -0,0 +1,106 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+// AWSMachinePoolGVK is the group version kind of the AWSMachinePools. They are handled as unstructured objects,
+// so that the controller does not depend on the experimental API packages of the AWS provider.
+var AWSMachinePoolGVK = schema.GroupVersionKind{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta2", Kind: "AWSMachinePool"}
+
+// newAWSMachinePool returns an empty unstructured AWSMachinePool.
+func newAWSMachinePool() *unstructured.Unstructured {
+	machinePool := &unstructured.Unstructured{}
+	machinePool.SetGroupVersionKind(AWSMachinePoolGVK)
+	return machinePool
+}
+
+// MachinePoolReconciler writes the capacity annotations of the instance type of the launch template of
+// AWSMachinePools to the AWSMachinePools, so that MachinePools backed by auto scaling groups can be scaled
+// from zero as well. It shares the configuration and caches of the MachineDeployment Reconciler.
+type MachinePoolReconciler struct {
+	Reconciler *Reconciler
+
+	recorder record.EventRecorder
+}
+
+// SetupWithManager creates a new controller for a manager.
+func (m *MachinePoolReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	_, err := ctrl.NewControllerManagedBy(mgr).
+		For(newAWSMachinePool()).
+		WithOptions(options).
+		Build(m)
+
+	if err != nil {
+		return fmt.Errorf("failed setting up with a controller manager: %w", err)
+	}
+
+	m.recorder = mgr.GetEventRecorderFor("awsmachinepool-controller")
+	if m.Reconciler.AuditSink != nil {
+		m.recorder = &auditRecorder{EventRecorder: m.recorder, sink: m.Reconciler.AuditSink}
+	}
+	return nil
+}
+
+// Reconcile implements controller runtime Reconciler interface.
+func (m *MachinePoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r := m.Reconciler
+
+	machinePool := newAWSMachinePool()
+	if err := r.Client.Get(ctx, req.NamespacedName, machinePool); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if machinePool.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, nil
+	}
+
+	// The region resolver operates on MachineDeployments. The instance type is part of the AWSMachinePool,
+	// there is no AWSMachineTemplate to resolve.
+	view := newMachineDeploymentView(machinePool, corev1.ObjectReference{})
+	deleting, err := r.clusterDeleting(ctx, view)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if deleting {
+		return ctrl.Result{}, nil
+	}
+
+	instanceType, _, err := unstructured.NestedString(machinePool.Object, "spec", "awsLaunchTemplate", "instanceType")
+	if err != nil || instanceType == "" {
+		m.recorder.Eventf(machinePool, corev1.EventTypeWarning, "FailedUpdate", "Failed to extract instance type: spec.awsLaunchTemplate.instanceType is not set")
+		return ctrl.Result{}, nil
+	}
+
+	region, err := r.regionResolver().ResolveRegion(ctx, r.Client, view)
+	if err != nil {
+		m.recorder.Eventf(machinePool, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve AWS region: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	return r.annotateUnstructured(ctx, m.recorder, machinePool, region, instanceType)
+}
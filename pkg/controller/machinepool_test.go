@@ -0,0 +1,103 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newTestAWSMachinePool returns an AWSMachinePool of the Cluster launching the instance type.
+func newTestAWSMachinePool(namespace, clusterName, instanceType string) *unstructured.Unstructured {
+	machinePool := newAWSMachinePool()
+	machinePool.SetNamespace(namespace)
+	machinePool.SetName("machine-pool")
+	machinePool.SetLabels(map[string]string{clusterv1.ClusterNameLabel: clusterName})
+	machinePool.Object["spec"] = map[string]interface{}{
+		"minSize": int64(0),
+		"maxSize": int64(10),
+		"awsLaunchTemplate": map[string]interface{}{
+			"name":         "machine-pool",
+			"instanceType": instanceType,
+		},
+	}
+	return machinePool
+}
+
+func TestMachinePoolReconciler(t *testing.T) {
+	testCases := []struct {
+		name              string
+		instanceType      string
+		expectAnnotations bool
+		expectedEvent     string
+	}{
+		{
+			name:              "with a valid instanceType",
+			instanceType:      "a1.2xlarge",
+			expectAnnotations: true,
+		},
+		{
+			name:          "with an invalid instanceType",
+			instanceType:  "invalid",
+			expectedEvent: corev1.EventTypeWarning + " FailedUpdate Failed to set capacity annotations",
+		},
+		{
+			name:          "without an instanceType",
+			expectedEvent: corev1.EventTypeWarning + " FailedUpdate Failed to extract instance type",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			_, _, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machinePool := newTestAWSMachinePool("default", cluster.Name, tc.instanceType)
+
+			r := newTestReconciler(g, machinePool, cluster, awsCluster)
+			recorder := record.NewFakeRecorder(10)
+			m := &MachinePoolReconciler{Reconciler: r, recorder: recorder}
+
+			_, err = m.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machinePool)})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			updated := newAWSMachinePool()
+			g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machinePool), updated)).To(Succeed())
+			if tc.expectAnnotations {
+				g.Expect(updated.GetAnnotations()).To(HaveKeyWithValue(cpuKey, "8"))
+				g.Expect(updated.GetAnnotations()).To(HaveKeyWithValue(memoryKey, "16384"))
+				g.Expect(updated.GetAnnotations()).To(HaveKeyWithValue(labelsKey, "kubernetes.io/arch=amd64"))
+				g.Expect(updated.GetAnnotations()).To(HaveKey(provenanceKey))
+				// The spec is left untouched
+				g.Expect(updated.Object["spec"]).To(Equal(machinePool.Object["spec"]))
+			} else {
+				g.Expect(updated.GetAnnotations()).ToNot(HaveKey(cpuKey))
+			}
+
+			if tc.expectedEvent == "" {
+				g.Expect(recorder.Events).To(BeEmpty())
+				return
+			}
+			g.Expect(recorder.Events).To(Receive(HavePrefix(tc.expectedEvent)))
+		})
+	}
+}
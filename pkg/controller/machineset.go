@@ -0,0 +1,190 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	utils "github.com/jhjaggars/capa-annotator/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+// MachineSetReconciler writes the capacity annotations of the AWSMachineTemplate of standalone MachineSets to
+// the MachineSets, so that MachineSets created without a MachineDeployment can be scaled from zero as well.
+// MachineSets owned by a MachineDeployment are skipped, the autoscaler scales their MachineDeployment.
+// It shares the configuration and caches of the MachineDeployment Reconciler.
+type MachineSetReconciler struct {
+	Reconciler *Reconciler
+
+	recorder record.EventRecorder
+}
+
+// SetupWithManager creates a new controller for a manager.
+func (m *MachineSetReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	_, err := ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1.MachineSet{}).
+		WithOptions(options).
+		Build(m)
+
+	if err != nil {
+		return fmt.Errorf("failed setting up with a controller manager: %w", err)
+	}
+
+	m.recorder = mgr.GetEventRecorderFor("machineset-controller")
+	if m.Reconciler.AuditSink != nil {
+		m.recorder = &auditRecorder{EventRecorder: m.recorder, sink: m.Reconciler.AuditSink}
+	}
+	return nil
+}
+
+// Reconcile implements controller runtime Reconciler interface.
+func (m *MachineSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r := m.Reconciler
+
+	machineSet := &clusterv1.MachineSet{}
+	if err := r.Client.Get(ctx, req.NamespacedName, machineSet); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if !machineSet.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	if ownedByMachineDeployment(machineSet) {
+		klog.V(4).Infof("MachineSet %s is owned by a MachineDeployment, skipping", req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	// The template and region resolvers, and the template policy, operate on MachineDeployments
+	view := machineSetView(machineSet)
+	deleting, err := r.clusterDeleting(ctx, view)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if deleting {
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.checkTemplateNamespace(view); err != nil {
+		klog.Errorf("Refusing to resolve AWSMachineTemplate: %v", err)
+		m.recorder.Eventf(machineSet, corev1.EventTypeWarning, "Forbidden", "Refusing to resolve AWSMachineTemplate: %v", err)
+		return ctrl.Result{}, nil
+	}
+
+	awsMachineTemplate, err := r.templateResolver().ResolveAWSMachineTemplate(ctx, r.Client, view)
+	if err != nil {
+		m.recorder.Eventf(machineSet, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve AWSMachineTemplate: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	instanceType, err := utils.ExtractInstanceType(awsMachineTemplate)
+	if err != nil {
+		m.recorder.Eventf(machineSet, corev1.EventTypeWarning, "FailedUpdate", "Failed to extract instance type: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	region, err := r.regionResolver().ResolveRegion(ctx, r.Client, view)
+	if err != nil {
+		m.recorder.Eventf(machineSet, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve AWS region: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	identity, err := r.identity(ctx, view)
+	if err != nil {
+		m.recorder.Eventf(machineSet, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve AWS identity: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	awsClient, err := r.awsClientOf(identity, region)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error creating aws client: %w", err)
+	}
+
+	cacheID := cacheIDOf(identity, region)
+	instanceTypeInfo, err := r.InstanceTypesCache.GetInstanceType(awsClient, cacheID, instanceType)
+	if err != nil {
+		klog.Errorf("Unable to set capacity annotations of MachineSet %s/%s: unknown instance type %s: %v", machineSet.Namespace, machineSet.Name, instanceType, err)
+		m.recorder.Eventf(machineSet, corev1.EventTypeWarning, "FailedUpdate", "Failed to set autoscaling from zero annotations, instance type unknown")
+		return ctrl.Result{}, nil
+	}
+
+	// A MachineSet pinned to a single failure domain only creates nodes in that zone
+	var topologyLabels map[string]string
+	if zone := pinnedFailureDomain(view); zone != "" {
+		zoneID, err := r.AvailabilityZonesCache.GetZoneID(awsClient, cacheID, zone)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error resolving availability zone %s: %w", zone, err)
+		}
+		topologyLabels = zoneLabels(zone, zoneID)
+	}
+
+	capacity, err := r.postProcessCapacity(view, instanceTypeInfo)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error post-processing capacity of instance type %s: %w", instanceType, err)
+	}
+
+	capacity = withRootVolume(capacity, awsMachineTemplate)
+
+	original := machineSet.DeepCopy()
+	if machineSet.Annotations == nil {
+		machineSet.Annotations = map[string]string{}
+	}
+	r.setCapacityAnnotations(machineSet.Annotations, capacity, region, topologyLabels)
+
+	patch := client.MergeFrom(original)
+	if patchEmpty(machineSet, patch) {
+		return ctrl.Result{}, nil
+	}
+	if err := r.Client.Patch(ctx, machineSet, patch); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to patch MachineSet: %w", err)
+	}
+	r.auditAnnotationChanges(machineSet, original.Annotations)
+	return ctrl.Result{}, nil
+}
+
+// ownedByMachineDeployment returns whether the MachineSet is owned by a MachineDeployment.
+func ownedByMachineDeployment(machineSet *clusterv1.MachineSet) bool {
+	for _, ownerReference := range machineSet.OwnerReferences {
+		gv, err := schema.ParseGroupVersion(ownerReference.APIVersion)
+		if err == nil && gv.Group == clusterv1.GroupVersion.Group && ownerReference.Kind == "MachineDeployment" {
+			return true
+		}
+	}
+	return false
+}
+
+// machineSetView returns a MachineDeployment with the namespace, name, annotations, Cluster and machine
+// template of the MachineSet, so that it can be passed to the resolvers. It is never written.
+func machineSetView(machineSet *clusterv1.MachineSet) *clusterv1.MachineDeployment {
+	view := &clusterv1.MachineDeployment{
+		Spec: clusterv1.MachineDeploymentSpec{
+			ClusterName: machineSet.Spec.ClusterName,
+			Template:    machineSet.Spec.Template,
+		},
+	}
+	view.Namespace = machineSet.Namespace
+	view.Name = machineSet.Name
+	view.Annotations = machineSet.Annotations
+	return view
+}
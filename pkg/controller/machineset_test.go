@@ -0,0 +1,117 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newTestMachineSet returns a MachineSet with the machine template of the MachineDeployment.
+func newTestMachineSet(machineDeployment *clusterv1.MachineDeployment) *clusterv1.MachineSet {
+	return &clusterv1.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-ms",
+			Namespace: machineDeployment.Namespace,
+		},
+		Spec: clusterv1.MachineSetSpec{
+			ClusterName: machineDeployment.Spec.ClusterName,
+			Template:    machineDeployment.Spec.Template,
+		},
+	}
+}
+
+func TestMachineSetReconciler(t *testing.T) {
+	testCases := []struct {
+		name              string
+		instanceType      string
+		owned             bool
+		failureDomain     string
+		expectAnnotations bool
+		expectedLabels    string
+		expectedEvent     string
+	}{
+		{
+			name:              "with a valid instanceType",
+			instanceType:      "a1.2xlarge",
+			expectAnnotations: true,
+			expectedLabels:    "kubernetes.io/arch=amd64",
+		},
+		{
+			name:              "pinned to a failure domain",
+			instanceType:      "a1.2xlarge",
+			failureDomain:     "us-east-1a",
+			expectAnnotations: true,
+			expectedLabels:    "kubernetes.io/arch=amd64,topology.k8s.aws/zone-id=use1-az6,topology.kubernetes.io/zone=us-east-1a",
+		},
+		{
+			name:          "with an invalid instanceType",
+			instanceType:  "invalid",
+			expectedEvent: corev1.EventTypeWarning + " FailedUpdate ",
+		},
+		{
+			name:         "owned by a MachineDeployment",
+			instanceType: "a1.2xlarge",
+			owned:        true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", tc.instanceType, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineSet := newTestMachineSet(machineDeployment)
+			if tc.failureDomain != "" {
+				machineSet.Spec.Template.Spec.FailureDomain = aws.String(tc.failureDomain)
+			}
+			if tc.owned {
+				machineSet.OwnerReferences = []metav1.OwnerReference{{APIVersion: clusterv1.GroupVersion.String(), Kind: "MachineDeployment", Name: "test-md", UID: "uid"}}
+			}
+
+			r := newTestReconciler(g, machineSet, awsMachineTemplate, cluster, awsCluster)
+			recorder := record.NewFakeRecorder(10)
+			m := &MachineSetReconciler{Reconciler: r, recorder: recorder}
+
+			_, err = m.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineSet)})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			updated := &clusterv1.MachineSet{}
+			g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machineSet), updated)).To(Succeed())
+			if tc.expectAnnotations {
+				g.Expect(updated.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+				g.Expect(updated.Annotations).To(HaveKeyWithValue(memoryKey, "16384"))
+				g.Expect(updated.Annotations).To(HaveKeyWithValue(gpuKey, "0"))
+				g.Expect(updated.Annotations).To(HaveKeyWithValue(labelsKey, tc.expectedLabels))
+			} else {
+				g.Expect(updated.Annotations).ToNot(HaveKey(cpuKey))
+			}
+
+			if tc.expectedEvent == "" {
+				g.Expect(recorder.Events).To(BeEmpty())
+				return
+			}
+			g.Expect(recorder.Events).To(Receive(HavePrefix(tc.expectedEvent)))
+		})
+	}
+}
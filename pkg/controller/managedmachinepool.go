@@ -0,0 +1,246 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	expv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var (
+	// AWSManagedMachinePoolGVK is the group version kind of the AWSManagedMachinePools of EKS managed node groups.
+	// They are handled as unstructured objects, like AWSMachinePools.
+	AWSManagedMachinePoolGVK = schema.GroupVersionKind{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta2", Kind: "AWSManagedMachinePool"}
+	// AWSManagedControlPlaneGVK is the group version kind of the EKS control planes, which hold the region of EKS clusters.
+	AWSManagedControlPlaneGVK = schema.GroupVersionKind{Group: "controlplane.cluster.x-k8s.io", Version: "v1beta2", Kind: "AWSManagedControlPlane"}
+)
+
+// newAWSManagedMachinePool returns an empty unstructured AWSManagedMachinePool.
+func newAWSManagedMachinePool() *unstructured.Unstructured {
+	managedMachinePool := &unstructured.Unstructured{}
+	managedMachinePool.SetGroupVersionKind(AWSManagedMachinePoolGVK)
+	return managedMachinePool
+}
+
+// ManagedMachinePoolReconciler writes the capacity annotations of the instance type of AWSManagedMachinePools to
+// their owning MachinePools, so that EKS managed node groups can be scaled from zero. The cluster-autoscaler reads
+// the annotations from the MachinePool, the scalable resource of the node group. It shares the configuration and
+// caches of the MachineDeployment Reconciler.
+type ManagedMachinePoolReconciler struct {
+	Reconciler *Reconciler
+
+	recorder record.EventRecorder
+}
+
+// SetupWithManager creates a new controller for a manager.
+func (m *ManagedMachinePoolReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	_, err := ctrl.NewControllerManagedBy(mgr).
+		For(newAWSManagedMachinePool()).
+		// MachinePools are watched as well, so that annotations removed from them are restored right away
+		Watches(&expv1.MachinePool{}, handler.EnqueueRequestsFromMapFunc(managedMachinePoolOfMachinePool)).
+		WithOptions(options).
+		Build(m)
+
+	if err != nil {
+		return fmt.Errorf("failed setting up with a controller manager: %w", err)
+	}
+
+	m.recorder = mgr.GetEventRecorderFor("awsmanagedmachinepool-controller")
+	if m.Reconciler.AuditSink != nil {
+		m.recorder = &auditRecorder{EventRecorder: m.recorder, sink: m.Reconciler.AuditSink}
+	}
+	return nil
+}
+
+// managedMachinePoolOfMachinePool maps a MachinePool to its AWSManagedMachinePool, if any.
+func managedMachinePoolOfMachinePool(_ context.Context, obj client.Object) []reconcile.Request {
+	machinePool, ok := obj.(*expv1.MachinePool)
+	if !ok {
+		return nil
+	}
+	ref := machinePool.Spec.Template.Spec.InfrastructureRef
+	if ref.Kind != AWSManagedMachinePoolGVK.Kind || ref.Name == "" {
+		return nil
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = machinePool.Namespace
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: namespace, Name: ref.Name}}}
+}
+
+// Reconcile implements controller runtime Reconciler interface.
+func (m *ManagedMachinePoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r := m.Reconciler
+
+	managedMachinePool := newAWSManagedMachinePool()
+	if err := r.Client.Get(ctx, req.NamespacedName, managedMachinePool); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if managedMachinePool.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, nil
+	}
+
+	machinePool, err := m.owningMachinePool(ctx, managedMachinePool)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if machinePool == nil {
+		// The MachinePool controller sets the owner reference, the AWSManagedMachinePool is reconciled again then
+		klog.V(3).Infof("AWSManagedMachinePool %s is not owned by a MachinePool yet", req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+	if !machinePool.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	// The region resolver operates on MachineDeployments. The instance type is part of the AWSManagedMachinePool,
+	// there is no AWSMachineTemplate to resolve.
+	view := newMachineDeploymentView(managedMachinePool, corev1.ObjectReference{})
+	view.Spec.ClusterName = machinePool.Spec.ClusterName
+	view.Annotations = machinePool.Annotations
+	deleting, err := r.clusterDeleting(ctx, view)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if deleting {
+		return ctrl.Result{}, nil
+	}
+
+	instanceType := managedMachinePoolInstanceType(managedMachinePool)
+	if instanceType == "" {
+		m.recorder.Eventf(machinePool, corev1.EventTypeWarning, "FailedUpdate", "Failed to extract instance type: AWSManagedMachinePool %s sets neither spec.instanceType nor spec.awsLaunchTemplate.instanceType", managedMachinePool.GetName())
+		return ctrl.Result{}, nil
+	}
+
+	region, err := m.resolveRegion(ctx, view)
+	if err != nil {
+		m.recorder.Eventf(machinePool, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve AWS region: %v", err)
+		return ctrl.Result{}, err
+	}
+
+	awsClient, err := r.AwsClientBuilder(r.Client, "", machinePool.Namespace, region, r.RegionCache)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error creating aws client: %w", err)
+	}
+
+	instanceTypeInfo, err := r.InstanceTypesCache.GetInstanceType(awsClient, region, instanceType)
+	if err != nil {
+		klog.Errorf("Unable to set capacity annotations of MachinePool %s/%s: unknown instance type %s: %v", machinePool.Namespace, machinePool.Name, instanceType, err)
+		m.recorder.Eventf(machinePool, corev1.EventTypeWarning, "FailedUpdate", "Failed to set capacity annotations, instance type unknown")
+		return ctrl.Result{}, nil
+	}
+
+	capacity, err := r.postProcessCapacity(nil, instanceTypeInfo)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("error post-processing capacity of instance type %s: %w", instanceType, err)
+	}
+
+	original := machinePool.DeepCopy()
+	if machinePool.Annotations == nil {
+		machinePool.Annotations = map[string]string{}
+	}
+	r.setCapacityAnnotations(machinePool.Annotations, capacity, region, nil)
+
+	patch := client.MergeFrom(original)
+	if patchEmpty(machinePool, patch) {
+		return ctrl.Result{}, nil
+	}
+	if err := r.Client.Patch(ctx, machinePool, patch); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to patch MachinePool: %w", err)
+	}
+	r.auditAnnotationChanges(machinePool, original.Annotations)
+	return ctrl.Result{}, nil
+}
+
+// owningMachinePool returns the MachinePool owning the AWSManagedMachinePool, or nil if it is not owned yet.
+func (m *ManagedMachinePoolReconciler) owningMachinePool(ctx context.Context, managedMachinePool *unstructured.Unstructured) (*expv1.MachinePool, error) {
+	for _, ownerReference := range managedMachinePool.GetOwnerReferences() {
+		gv, err := schema.ParseGroupVersion(ownerReference.APIVersion)
+		if err != nil || gv.Group != expv1.GroupVersion.Group || ownerReference.Kind != "MachinePool" {
+			continue
+		}
+
+		machinePool := &expv1.MachinePool{}
+		key := client.ObjectKey{Namespace: managedMachinePool.GetNamespace(), Name: ownerReference.Name}
+		if err := m.Reconciler.Client.Get(ctx, key, machinePool); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, annotatorerrors.NewLookupError("MachinePool", key.Namespace, key.Name, err)
+		}
+		return machinePool, nil
+	}
+	return nil, nil
+}
+
+// managedMachinePoolInstanceType returns the instance type of the node group, which is either set directly or
+// as part of the launch template.
+func managedMachinePoolInstanceType(managedMachinePool *unstructured.Unstructured) string {
+	if instanceType, _, _ := unstructured.NestedString(managedMachinePool.Object, "spec", "instanceType"); instanceType != "" {
+		return instanceType
+	}
+	instanceType, _, _ := unstructured.NestedString(managedMachinePool.Object, "spec", "awsLaunchTemplate", "instanceType")
+	return instanceType
+}
+
+// resolveRegion returns the region of the AWSManagedControlPlane of the Cluster. EKS clusters have no AWSCluster,
+// so the configured region resolver is only used if the Cluster has no AWSManagedControlPlane.
+func (m *ManagedMachinePoolReconciler) resolveRegion(ctx context.Context, view *clusterv1.MachineDeployment) (string, error) {
+	r := m.Reconciler
+
+	if view.Spec.ClusterName != "" {
+		cluster := &clusterv1.Cluster{}
+		key := client.ObjectKey{Namespace: view.Namespace, Name: view.Spec.ClusterName}
+		if err := r.Client.Get(ctx, key, cluster); err != nil && !apierrors.IsNotFound(err) {
+			return "", annotatorerrors.NewLookupError("Cluster", key.Namespace, key.Name, err)
+		}
+
+		if ref := cluster.Spec.ControlPlaneRef; ref != nil && ref.Kind == AWSManagedControlPlaneGVK.Kind {
+			controlPlane := &unstructured.Unstructured{}
+			controlPlane.SetGroupVersionKind(AWSManagedControlPlaneGVK)
+			controlPlaneKey := client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}
+			if controlPlaneKey.Namespace == "" {
+				controlPlaneKey.Namespace = cluster.Namespace
+			}
+			if err := r.Client.Get(ctx, controlPlaneKey, controlPlane); err != nil {
+				return "", annotatorerrors.NewLookupError("AWSManagedControlPlane", controlPlaneKey.Namespace, controlPlaneKey.Name, err)
+			}
+			if region, _, _ := unstructured.NestedString(controlPlane.Object, "spec", "region"); region != "" {
+				return region, nil
+			}
+			klog.V(3).Infof("AWSManagedControlPlane %s has no region, trying the region resolver", controlPlaneKey)
+		}
+	}
+
+	return r.regionResolver().ResolveRegion(ctx, r.Client, view)
+}
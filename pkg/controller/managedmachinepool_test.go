@@ -0,0 +1,172 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	expv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newTestEKSCluster returns an EKS Cluster and its AWSManagedControlPlane in the region.
+func newTestEKSCluster(namespace, region string) (*clusterv1.Cluster, *unstructured.Unstructured) {
+	controlPlane := &unstructured.Unstructured{}
+	controlPlane.SetGroupVersionKind(AWSManagedControlPlaneGVK)
+	controlPlane.SetNamespace(namespace)
+	controlPlane.SetName("eks-control-plane")
+	controlPlane.Object["spec"] = map[string]interface{}{"region": region}
+
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "eks"},
+		Spec: clusterv1.ClusterSpec{
+			ControlPlaneRef: &corev1.ObjectReference{
+				APIVersion: AWSManagedControlPlaneGVK.GroupVersion().String(),
+				Kind:       AWSManagedControlPlaneGVK.Kind,
+				Name:       controlPlane.GetName(),
+			},
+		},
+	}
+	return cluster, controlPlane
+}
+
+// newTestManagedMachinePool returns a MachinePool of the Cluster and its AWSManagedMachinePool with the given spec.
+func newTestManagedMachinePool(namespace, clusterName string, spec map[string]interface{}) (*expv1.MachinePool, *unstructured.Unstructured) {
+	machinePool := &expv1.MachinePool{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "node-group"},
+		Spec: expv1.MachinePoolSpec{
+			ClusterName: clusterName,
+			Template: clusterv1.MachineTemplateSpec{
+				Spec: clusterv1.MachineSpec{
+					ClusterName: clusterName,
+					InfrastructureRef: corev1.ObjectReference{
+						APIVersion: AWSManagedMachinePoolGVK.GroupVersion().String(),
+						Kind:       AWSManagedMachinePoolGVK.Kind,
+						Name:       "node-group",
+					},
+				},
+			},
+		},
+	}
+
+	managedMachinePool := newAWSManagedMachinePool()
+	managedMachinePool.SetNamespace(namespace)
+	managedMachinePool.SetName("node-group")
+	managedMachinePool.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: expv1.GroupVersion.String(), Kind: "MachinePool", Name: machinePool.Name}})
+	managedMachinePool.Object["spec"] = spec
+	return machinePool, managedMachinePool
+}
+
+func TestManagedMachinePoolReconciler(t *testing.T) {
+	testCases := []struct {
+		name              string
+		spec              map[string]interface{}
+		expectAnnotations bool
+		expectedEvent     string
+	}{
+		{
+			name:              "with an instanceType",
+			spec:              map[string]interface{}{"instanceType": "a1.2xlarge"},
+			expectAnnotations: true,
+		},
+		{
+			name:              "with the instanceType of the launch template",
+			spec:              map[string]interface{}{"awsLaunchTemplate": map[string]interface{}{"instanceType": "a1.2xlarge"}},
+			expectAnnotations: true,
+		},
+		{
+			name:          "with an invalid instanceType",
+			spec:          map[string]interface{}{"instanceType": "invalid"},
+			expectedEvent: corev1.EventTypeWarning + " FailedUpdate Failed to set capacity annotations",
+		},
+		{
+			name:          "without an instanceType",
+			spec:          map[string]interface{}{},
+			expectedEvent: corev1.EventTypeWarning + " FailedUpdate Failed to extract instance type",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cluster, controlPlane := newTestEKSCluster("default", "us-east-1")
+			machinePool, managedMachinePool := newTestManagedMachinePool("default", cluster.Name, tc.spec)
+
+			r := newTestReconciler(g, cluster, controlPlane, machinePool, managedMachinePool)
+			recorder := record.NewFakeRecorder(10)
+			m := &ManagedMachinePoolReconciler{Reconciler: r, recorder: recorder}
+
+			_, err := m.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(managedMachinePool)})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			updated := &expv1.MachinePool{}
+			g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machinePool), updated)).To(Succeed())
+			if tc.expectAnnotations {
+				g.Expect(updated.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+				g.Expect(updated.Annotations).To(HaveKeyWithValue(memoryKey, "16384"))
+				g.Expect(updated.Annotations).To(HaveKeyWithValue(labelsKey, "kubernetes.io/arch=amd64"))
+				g.Expect(updated.Annotations).To(HaveKeyWithValue(provenanceKey, ContainSubstring("region=us-east-1")))
+			} else {
+				g.Expect(updated.Annotations).ToNot(HaveKey(cpuKey))
+			}
+
+			// The AWSManagedMachinePool is left untouched
+			updatedManagedMachinePool := newAWSManagedMachinePool()
+			g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(managedMachinePool), updatedManagedMachinePool)).To(Succeed())
+			g.Expect(updatedManagedMachinePool.GetAnnotations()).To(BeEmpty())
+
+			if tc.expectedEvent == "" {
+				g.Expect(recorder.Events).To(BeEmpty())
+				return
+			}
+			g.Expect(recorder.Events).To(Receive(HavePrefix(tc.expectedEvent)))
+		})
+	}
+}
+
+func TestManagedMachinePoolWithoutOwner(t *testing.T) {
+	g := NewWithT(t)
+
+	_, managedMachinePool := newTestManagedMachinePool("default", "eks", map[string]interface{}{"instanceType": "a1.2xlarge"})
+	managedMachinePool.SetOwnerReferences(nil)
+
+	r := newTestReconciler(g, managedMachinePool)
+	recorder := record.NewFakeRecorder(10)
+	m := &ManagedMachinePoolReconciler{Reconciler: r, recorder: recorder}
+
+	// The AWSManagedMachinePool is reconciled again once the MachinePool controller sets the owner reference
+	_, err := m.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(managedMachinePool)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(recorder.Events).To(BeEmpty())
+}
+
+func TestManagedMachinePoolOfMachinePool(t *testing.T) {
+	g := NewWithT(t)
+
+	machinePool, _ := newTestManagedMachinePool("default", "eks", nil)
+	g.Expect(managedMachinePoolOfMachinePool(ctx, machinePool)).To(ConsistOf(
+		reconcile.Request{NamespacedName: client.ObjectKey{Namespace: "default", Name: "node-group"}},
+	))
+
+	machinePool.Spec.Template.Spec.InfrastructureRef.Kind = "AWSMachinePool"
+	g.Expect(managedMachinePoolOfMachinePool(ctx, machinePool)).To(BeEmpty())
+}
@@ -0,0 +1,117 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// maxPodsKey is the annotation of the cluster-autoscaler Cluster API provider for the maximum number of pods of a node.
+const maxPodsKey = "capacity.cluster-autoscaler.kubernetes.io/maxPods"
+
+// MaxPodsMode is the calculation of the maximum number of pods of a node.
+type MaxPodsMode string
+
+const (
+	// MaxPodsModeNone does not write the maxPods annotation. This is the default.
+	MaxPodsModeNone MaxPodsMode = ""
+	// MaxPodsModeENI calculates the maximum number of pods of the VPC CNI, where each pod gets a secondary IP address
+	// of one of the network interfaces of the node.
+	MaxPodsModeENI MaxPodsMode = "eni"
+	// MaxPodsModeENITrunking calculates the maximum number of pods of the VPC CNI with ENI trunking, i.e. security
+	// groups for pods. The trunk interface takes one network interface of the node, and pods with security groups
+	// get a branch interface of the trunk instead of a secondary IP address.
+	MaxPodsModeENITrunking MaxPodsMode = "eni-trunking"
+	// MaxPodsModeENIPrefix calculates the maximum number of pods of the VPC CNI with prefix delegation, where each
+	// secondary IP address slot of the network interfaces of the node holds a /28 prefix of 16 addresses.
+	MaxPodsModeENIPrefix MaxPodsMode = "eni-prefix"
+)
+
+const (
+	// ipv4PrefixAddresses is the number of addresses of a /28 IPv4 prefix assigned with prefix delegation.
+	ipv4PrefixAddresses = 16
+	// The maximum number of pods recommended by EKS with prefix delegation, for instance types with less than
+	// prefixMaxPodsLargeVCPU vCPUs and for larger ones. The addresses of the prefixes exceed what the kubelet of
+	// small instance types can run.
+	prefixMaxPodsSmall     = 110
+	prefixMaxPodsLarge     = 250
+	prefixMaxPodsLargeVCPU = 30
+)
+
+// ParseMaxPodsMode validates the given maxPods mode.
+func ParseMaxPodsMode(mode string) (MaxPodsMode, error) {
+	switch MaxPodsMode(mode) {
+	case MaxPodsModeNone, MaxPodsModeENI, MaxPodsModeENITrunking, MaxPodsModeENIPrefix:
+		return MaxPodsMode(mode), nil
+	}
+	return "", fmt.Errorf("unknown maxPods mode %q, must be one of %q", mode, []MaxPodsMode{MaxPodsModeENI, MaxPodsModeENITrunking, MaxPodsModeENIPrefix})
+}
+
+// LoadBranchInterfaceLimits reads the branch interface limits from a YAML file mapping instance types to their
+// maximum number of branch interfaces.
+func LoadBranchInterfaceLimits(path string) (map[string]int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read branch interface limits: %w", err)
+	}
+	return ParseBranchInterfaceLimits(data)
+}
+
+// ParseBranchInterfaceLimits parses and validates YAML mapping instance types to their maximum number of branch interfaces.
+func ParseBranchInterfaceLimits(data []byte) (map[string]int64, error) {
+	limits := map[string]int64{}
+	if err := yaml.UnmarshalStrict(data, &limits); err != nil {
+		return nil, fmt.Errorf("failed to parse branch interface limits: %w", err)
+	}
+	for instanceType, limit := range limits {
+		if limit < 0 {
+			return nil, fmt.Errorf("branch interface limit of instance type %q must not be negative", instanceType)
+		}
+	}
+	return limits, nil
+}
+
+// maxPods returns the maximum number of pods of a node of the instance type in the configured mode, following
+// the formula of the VPC CNI: every network interface but the trunk hosts one pod per secondary IP address, plus
+// two pods using the host network. With ENI trunking, each branch interface hosts one more pod. Instance types
+// without branch interfaces do not support trunking, no trunk interface is attached to them. False is returned
+// if the network limits of the instance type are unknown, e.g. for instance type aliases with explicit capacity.
+// With prefix delegation, every secondary IP address slot hosts the pods of a prefix, capped at the maximum
+// recommended by EKS for the number of vCPUs.
+func (r *Reconciler) maxPods(instanceTypeInfo InstanceType) (int64, bool) {
+	if instanceTypeInfo.NetworkInterfaces <= 0 || instanceTypeInfo.IPv4AddressesPerInterface <= 0 {
+		return 0, false
+	}
+
+	if r.MaxPodsMode == MaxPodsModeENIPrefix {
+		limit := int64(prefixMaxPodsLarge)
+		if instanceTypeInfo.VCPU < prefixMaxPodsLargeVCPU {
+			limit = prefixMaxPodsSmall
+		}
+		return min(instanceTypeInfo.NetworkInterfaces*(instanceTypeInfo.IPv4AddressesPerInterface-1)*ipv4PrefixAddresses+2, limit), true
+	}
+
+	networkInterfaces := instanceTypeInfo.NetworkInterfaces
+	branchInterfaces := int64(0)
+	if r.MaxPodsMode == MaxPodsModeENITrunking {
+		branchInterfaces = r.BranchInterfaceLimits[instanceTypeInfo.InstanceType]
+		if branchInterfaces > 0 {
+			networkInterfaces--
+		}
+	}
+	return networkInterfaces*(instanceTypeInfo.IPv4AddressesPerInterface-1) + 2 + branchInterfaces, true
+}
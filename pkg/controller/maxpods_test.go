@@ -0,0 +1,149 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+func TestParseBranchInterfaceLimits(t *testing.T) {
+	g := NewWithT(t)
+
+	limits, err := ParseBranchInterfaceLimits([]byte("m5.large: 9\nm5.xlarge: 18\n"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(limits).To(Equal(map[string]int64{"m5.large": 9, "m5.xlarge": 18}))
+
+	_, err = ParseBranchInterfaceLimits([]byte("m5.large: -1\n"))
+	g.Expect(err).To(MatchError(ContainSubstring("must not be negative")))
+
+	_, err = ParseBranchInterfaceLimits([]byte("m5.large: many\n"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestMaxPods(t *testing.T) {
+	m5Large := InstanceType{InstanceType: "m5.large", NetworkInterfaces: 3, IPv4AddressesPerInterface: 10}
+
+	testCases := []struct {
+		name             string
+		mode             MaxPodsMode
+		instanceType     InstanceType
+		expectedMaxPods  int64
+		expectedKnownMax bool
+	}{
+		{
+			name:             "eni",
+			mode:             MaxPodsModeENI,
+			instanceType:     m5Large,
+			expectedMaxPods:  29,
+			expectedKnownMax: true,
+		},
+		{
+			name:             "eni trunking reserves the trunk interface and adds the branch interfaces",
+			mode:             MaxPodsModeENITrunking,
+			instanceType:     m5Large,
+			expectedMaxPods:  2*9 + 2 + 9,
+			expectedKnownMax: true,
+		},
+		{
+			name:             "eni trunking of an instance type without branch interfaces",
+			mode:             MaxPodsModeENITrunking,
+			instanceType:     InstanceType{InstanceType: "t2.medium", NetworkInterfaces: 3, IPv4AddressesPerInterface: 6},
+			expectedMaxPods:  17,
+			expectedKnownMax: true,
+		},
+		{
+			name:             "eni prefix of a small instance type is capped at 110",
+			mode:             MaxPodsModeENIPrefix,
+			instanceType:     InstanceType{InstanceType: "m5.large", VCPU: 2, NetworkInterfaces: 3, IPv4AddressesPerInterface: 10},
+			expectedMaxPods:  110,
+			expectedKnownMax: true,
+		},
+		{
+			name:             "eni prefix of a large instance type is capped at 250",
+			mode:             MaxPodsModeENIPrefix,
+			instanceType:     InstanceType{InstanceType: "m5.8xlarge", VCPU: 32, NetworkInterfaces: 8, IPv4AddressesPerInterface: 30},
+			expectedMaxPods:  250,
+			expectedKnownMax: true,
+		},
+		{
+			name:             "eni prefix below the cap",
+			mode:             MaxPodsModeENIPrefix,
+			instanceType:     InstanceType{InstanceType: "t3.nano", VCPU: 2, NetworkInterfaces: 2, IPv4AddressesPerInterface: 2},
+			expectedMaxPods:  2*1*16 + 2,
+			expectedKnownMax: true,
+		},
+		{
+			name:         "unknown network limits",
+			mode:         MaxPodsModeENI,
+			instanceType: InstanceType{InstanceType: "outpost.large", VCPU: 2, MemoryMb: 8192},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			r := &Reconciler{MaxPodsMode: tc.mode, BranchInterfaceLimits: map[string]int64{"m5.large": 9}}
+			maxPods, ok := r.maxPods(tc.instanceType)
+			g.Expect(ok).To(Equal(tc.expectedKnownMax))
+			g.Expect(maxPods).To(Equal(tc.expectedMaxPods))
+		})
+	}
+}
+
+func TestReconcileWithMaxPods(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "max-pods"
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+
+	// The annotation is not written by default
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).ToNot(HaveKey(maxPodsKey))
+
+	// a1.2xlarge has 4 network interfaces with 15 addresses each
+	r.MaxPodsMode = MaxPodsModeENI
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(maxPodsKey, "58"))
+	g.Expect(getManagedKeys(machineDeployment.Annotations)).To(ContainElement(maxPodsKey))
+
+	r.MaxPodsMode = MaxPodsModeENITrunking
+	r.BranchInterfaceLimits = map[string]int64{"a1.2xlarge": 38}
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(maxPodsKey, "82"))
+
+	// a1.2xlarge has 8 vCPUs, so the prefixes are capped at 110 pods
+	r.MaxPodsMode = MaxPodsModeENIPrefix
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(maxPodsKey, "110"))
+
+	// A stale value is removed if the network limits are unknown
+	r.CapacityPostProcessors = []CapacityPostProcessor{CapacityPostProcessorFunc(func(_ *clusterv1.MachineDeployment, capacity InstanceType) (InstanceType, error) {
+		capacity.NetworkInterfaces = 0
+		return capacity, nil
+	})}
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).ToNot(HaveKey(maxPodsKey))
+}
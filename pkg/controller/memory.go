@@ -0,0 +1,96 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// controllerKeyPrefix is the prefix of the bookkeeping annotations of the controller.
+const controllerKeyPrefix = "capa-annotator/"
+
+// MemoryUnit is the unit used to format memory annotations.
+type MemoryUnit string
+
+const (
+	// MemoryUnitMiB formats memory as a plain number of mebibytes. This is the default.
+	MemoryUnitMiB MemoryUnit = "MiB"
+	// MemoryUnitMi formats memory as a Kubernetes quantity in mebibytes, e.g. "16384Mi".
+	MemoryUnitMi MemoryUnit = "Mi"
+	// MemoryUnitKi formats memory as a Kubernetes quantity in kibibytes, e.g. "16777216Ki".
+	MemoryUnitKi MemoryUnit = "Ki"
+	// MemoryUnitBytes formats memory as a plain number of bytes.
+	MemoryUnitBytes MemoryUnit = "bytes"
+)
+
+// ParseMemoryUnit validates the given memory unit.
+func ParseMemoryUnit(unit string) (MemoryUnit, error) {
+	switch MemoryUnit(unit) {
+	case MemoryUnitMiB, MemoryUnitMi, MemoryUnitKi, MemoryUnitBytes:
+		return MemoryUnit(unit), nil
+	}
+	return "", fmt.Errorf("unknown memory unit %q, must be one of %q", unit, []MemoryUnit{MemoryUnitMiB, MemoryUnitMi, MemoryUnitKi, MemoryUnitBytes})
+}
+
+// Format formats the memory size given in mebibytes in the unit. An empty unit formats as MiB.
+func (u MemoryUnit) Format(memoryMiB int64) string {
+	switch u {
+	case MemoryUnitMi:
+		return strconv.FormatInt(memoryMiB, 10) + "Mi"
+	case MemoryUnitKi:
+		return strconv.FormatInt(memoryMiB*1024, 10) + "Ki"
+	case MemoryUnitBytes:
+		return strconv.FormatInt(memoryMiB*1024*1024, 10)
+	default:
+		return strconv.FormatInt(memoryMiB, 10)
+	}
+}
+
+// writtenAnnotationKeys are the annotation keys the controller writes outside of controllerKeyPrefix. Every
+// annotation written by the controller must be registered here, so that the annotation keys configured by the
+// user, e.g. of the additional memory or custom annotations, cannot overwrite it. The labels written into the
+// labels annotation are registered in writtenLabelKeys.
+var writtenAnnotationKeys = []string{
+	// The capacity annotations of the annotation schemes
+	cpuKey, memoryKey, gpuKey,
+	caCPUKey, caMemoryKey, caGPUCountKey, caGPUTypeKey,
+	labelsKey,
+	maxPodsKey,
+	ephemeralDiskKey,
+	taintsKey,
+}
+
+// writtenLabelKeys are the labels the controller writes into the labels annotation. Every label written by the
+// controller must be registered here, so that it is removed on uninstall while the labels provided by the user
+// are preserved. They are also kept first when truncating the labels annotation.
+var writtenLabelKeys = []string{archLabelKey, zoneLabelKey, zoneIDLabelKey, acceleratorLabelKey}
+
+// ValidateAdditionalMemoryKey returns an error if the additional memory annotation key would overwrite an
+// annotation owned by the controller.
+func ValidateAdditionalMemoryKey(key string) error {
+	if key == "" {
+		return nil
+	}
+	if strings.HasPrefix(key, controllerKeyPrefix) {
+		return fmt.Errorf("annotation key %q is reserved for the controller", key)
+	}
+	for _, reserved := range writtenAnnotationKeys {
+		if key == reserved {
+			return fmt.Errorf("annotation key %q is written by the controller", key)
+		}
+	}
+	return nil
+}
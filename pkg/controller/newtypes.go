@@ -0,0 +1,221 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// unknownInstanceTypes tracks the MachineDeployments referencing instance types unknown in their region,
+// e.g. because they were created ahead of the regional launch of the instance type.
+type unknownInstanceTypes struct {
+	mutex sync.Mutex
+	// regions holds the MachineDeployments by instance type by region.
+	regions map[string]map[string]map[types.NamespacedName]struct{}
+}
+
+func newUnknownInstanceTypes() *unknownInstanceTypes {
+	return &unknownInstanceTypes{regions: map[string]map[string]map[types.NamespacedName]struct{}{}}
+}
+
+// record records that the MachineDeployment references an instance type unknown in the region.
+// A MachineDeployment only waits for a single instance type.
+func (u *unknownInstanceTypes) record(region, instanceType string, key types.NamespacedName) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.forgetLocked(key)
+	if u.regions[region] == nil {
+		u.regions[region] = map[string]map[types.NamespacedName]struct{}{}
+	}
+	if u.regions[region][instanceType] == nil {
+		u.regions[region][instanceType] = map[types.NamespacedName]struct{}{}
+	}
+	u.regions[region][instanceType][key] = struct{}{}
+}
+
+// forget removes the MachineDeployment, e.g. once its instance type is known or it was deleted.
+func (u *unknownInstanceTypes) forget(key types.NamespacedName) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.forgetLocked(key)
+}
+
+func (u *unknownInstanceTypes) forgetLocked(key types.NamespacedName) {
+	for region, instanceTypes := range u.regions {
+		for instanceType, keys := range instanceTypes {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(instanceTypes, instanceType)
+			}
+		}
+		if len(instanceTypes) == 0 {
+			delete(u.regions, region)
+		}
+	}
+}
+
+// get returns a copy of the MachineDeployments by instance type by region.
+func (u *unknownInstanceTypes) get() map[string]map[string][]types.NamespacedName {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	regions := make(map[string]map[string][]types.NamespacedName, len(u.regions))
+	for region, instanceTypes := range u.regions {
+		regions[region] = make(map[string][]types.NamespacedName, len(instanceTypes))
+		for instanceType, keys := range instanceTypes {
+			for key := range keys {
+				regions[region][instanceType] = append(regions[region][instanceType], key)
+			}
+			sort.Slice(regions[region][instanceType], func(i, j int) bool {
+				return regions[region][instanceType][i].String() < regions[region][instanceType][j].String()
+			})
+		}
+	}
+	return regions
+}
+
+// instanceTypesReplacer is implemented by instance types caches that can be refreshed with instance types
+// fetched elsewhere.
+type instanceTypesReplacer interface {
+	replace(cacheID string, instanceTypes map[string]InstanceType)
+}
+
+// replace replaces the cached instance types of the region.
+func (i *instanceTypesCache) replace(cacheID string, instanceTypes map[string]InstanceType) {
+	i.rwmutex.Lock()
+	defer i.rwmutex.Unlock()
+	delete(i.failures, cacheID)
+	i.storeLocked(cacheID, instanceTypesRegion{instanceTypes: instanceTypes, lastUpdate: time.Now()})
+}
+
+// replace replaces the cached instance types of the region in the wrapped cache.
+func (a *aliasedInstanceTypesCache) replace(cacheID string, instanceTypes map[string]InstanceType) {
+	if cache, ok := a.cache.(instanceTypesReplacer); ok {
+		cache.replace(cacheID, instanceTypes)
+	}
+}
+
+// newInstanceTypeWatcher periodically fetches the instance types of the regions with MachineDeployments
+// referencing unknown instance types. Once such an instance type becomes available, the instance types cache
+// of the region is refreshed and the MachineDeployments are enqueued right away, instead of waiting for the
+// next refresh of the cache. It runs on the leader only.
+type newInstanceTypeWatcher struct {
+	reconciler *Reconciler
+	interval   time.Duration
+	events     chan<- event.GenericEvent
+
+	// known holds the instance types of each region fetched by the last poll, to log new instance types.
+	known map[string]map[string]struct{}
+}
+
+// Start polls the regions until the context is done.
+// It implements the controller-runtime Runnable interface.
+func (w *newInstanceTypeWatcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.poll(ctx)
+		}
+	}
+}
+
+// poll fetches the instance types of the regions with unknown instance types and enqueues the MachineDeployments
+// whose instance type became available.
+func (w *newInstanceTypeWatcher) poll(ctx context.Context) {
+	r := w.reconciler
+	if w.known == nil {
+		w.known = map[string]map[string]struct{}{}
+	}
+
+	for region, unknown := range r.unknownInstanceTypes.get() {
+		// The AWS client only depends on the namespace for credentials secrets, which are not used
+		var namespace string
+		for _, keys := range unknown {
+			namespace = keys[0].Namespace
+			break
+		}
+		awsClient, err := r.AwsClientBuilder(r.Client, "", namespace, region, r.RegionCache)
+		if err != nil {
+			klog.Errorf("Failed to create AWS client to watch for new instance types in region %s: %v", region, err)
+			continue
+		}
+		instanceTypes, err := fetchEC2InstanceTypes(awsClient)
+		if err != nil {
+			klog.Errorf("Failed to watch for new instance types in region %s: %v", region, err)
+			continue
+		}
+
+		w.logNewInstanceTypes(region, instanceTypes)
+
+		available := map[string][]types.NamespacedName{}
+		for instanceType, keys := range unknown {
+			if _, ok := instanceTypes[instanceType]; ok {
+				available[instanceType] = keys
+			}
+		}
+		if len(available) == 0 {
+			continue
+		}
+
+		if cache, ok := r.InstanceTypesCache.(instanceTypesReplacer); ok {
+			cache.replace(region, instanceTypes)
+		}
+		for instanceType, keys := range available {
+			klog.Infof("Instance type %s became available in region %s, reconciling %d MachineDeployments", instanceType, region, len(keys))
+			for _, key := range keys {
+				machineDeployment := &clusterv1.MachineDeployment{}
+				machineDeployment.Namespace = key.Namespace
+				machineDeployment.Name = key.Name
+				select {
+				case <-ctx.Done():
+					return
+				case w.events <- event.GenericEvent{Object: machineDeployment}:
+				}
+			}
+		}
+	}
+}
+
+// logNewInstanceTypes logs the instance types of the region that were not available in the previous poll.
+func (w *newInstanceTypeWatcher) logNewInstanceTypes(region string, instanceTypes map[string]InstanceType) {
+	previous := w.known[region]
+	current := make(map[string]struct{}, len(instanceTypes))
+	added := []string{}
+	for instanceType := range instanceTypes {
+		current[instanceType] = struct{}{}
+		if _, ok := previous[instanceType]; previous != nil && !ok {
+			added = append(added, instanceType)
+		}
+	}
+	w.known[region] = current
+
+	if len(added) > 0 {
+		sort.Strings(added)
+		klog.Infof("New instance types available in region %s: %v", region, added)
+	}
+}
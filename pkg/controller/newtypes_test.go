@@ -0,0 +1,124 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// launchingInstanceTypesClient hides an instance type until it is launched, and counts the DescribeInstanceTypes requests.
+type launchingInstanceTypesClient struct {
+	awsclient.Client
+	instanceType string
+	launched     bool
+	requests     int
+}
+
+func (c *launchingInstanceTypesClient) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	c.requests++
+	output, err := c.Client.DescribeInstanceTypes(input)
+	if err != nil || c.launched {
+		return output, err
+	}
+	instanceTypes := []*ec2.InstanceTypeInfo{}
+	for _, instanceType := range output.InstanceTypes {
+		if *instanceType.InstanceType != c.instanceType {
+			instanceTypes = append(instanceTypes, instanceType)
+		}
+	}
+	output.InstanceTypes = instanceTypes
+	return output, nil
+}
+
+func TestUnknownInstanceTypes(t *testing.T) {
+	g := NewWithT(t)
+
+	first := types.NamespacedName{Namespace: "default", Name: "first"}
+	second := types.NamespacedName{Namespace: "default", Name: "second"}
+
+	unknown := newUnknownInstanceTypes()
+	unknown.record("us-east-1", "m8.large", first)
+	unknown.record("us-east-1", "m8.large", second)
+	g.Expect(unknown.get()).To(Equal(map[string]map[string][]types.NamespacedName{
+		"us-east-1": {"m8.large": {first, second}},
+	}))
+
+	// A MachineDeployment only waits for its current instance type
+	unknown.record("eu-west-1", "m9.large", second)
+	g.Expect(unknown.get()).To(Equal(map[string]map[string][]types.NamespacedName{
+		"us-east-1": {"m8.large": {first}},
+		"eu-west-1": {"m9.large": {second}},
+	}))
+
+	unknown.forget(first)
+	unknown.forget(second)
+	g.Expect(unknown.get()).To(BeEmpty())
+}
+
+func TestNewInstanceTypeWatcher(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "p2.16xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "ahead-of-launch"
+
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	awsClient := &launchingInstanceTypesClient{Client: fakeAWSClient, instanceType: "p2.16xlarge"}
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.AwsClientBuilder = func(client.Client, string, string, string, awsclient.RegionCache) (awsclient.Client, error) {
+		return awsClient, nil
+	}
+	r.unknownInstanceTypes = newUnknownInstanceTypes()
+
+	// The instance type is not launched in the region yet
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).ToNot(HaveKey(cpuKey))
+	g.Expect(r.unknownInstanceTypes.get()).To(HaveKey("us-east-1"))
+
+	events := make(chan event.GenericEvent, 10)
+	watcher := &newInstanceTypeWatcher{reconciler: r, events: events}
+
+	watcher.poll(ctx)
+	g.Expect(events).To(BeEmpty())
+
+	// Once launched, the MachineDeployment is enqueued
+	awsClient.launched = true
+	watcher.poll(ctx)
+	g.Expect(events).To(HaveLen(1))
+	enqueued := <-events
+	g.Expect(client.ObjectKeyFromObject(enqueued.Object)).To(Equal(client.ObjectKeyFromObject(machineDeployment)))
+
+	// The cache was refreshed by the watcher, so the reconcile does not describe the instance types again
+	requests := awsClient.requests
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(awsClient.requests).To(Equal(requests))
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(cpuKey, "64"))
+	g.Expect(r.unknownInstanceTypes.get()).To(BeEmpty())
+
+	// Regions without unknown instance types are not polled
+	watcher.poll(ctx)
+	g.Expect(awsClient.requests).To(Equal(requests))
+}
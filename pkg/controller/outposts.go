@@ -0,0 +1,79 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/outposts"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+)
+
+// resolveOutpost returns the ARN of the Outpost the subnet of the AWSMachineTemplate is on.
+// An empty ARN is returned if the template does not set a subnet or the subnet is not on an Outpost.
+func resolveOutpost(awsClient awsclient.Client, awsMachineTemplate *infrav1.AWSMachineTemplate) (string, error) {
+	subnet := awsMachineTemplate.Spec.Template.Spec.Subnet
+	if subnet == nil || (subnet.ID == nil && len(subnet.Filters) == 0) {
+		return "", nil
+	}
+
+	input := &ec2.DescribeSubnetsInput{}
+	if subnet.ID != nil {
+		input.SubnetIds = []*string{subnet.ID}
+	}
+	for _, filter := range subnet.Filters {
+		input.Filters = append(input.Filters, &ec2.Filter{
+			Name:   aws.String(filter.Name),
+			Values: aws.StringSlice(filter.Values),
+		})
+	}
+
+	output, err := awsClient.DescribeSubnets(input)
+	if err != nil {
+		return "", fmt.Errorf("describeSubnets request failed: %w", err)
+	}
+	for _, subnet := range output.Subnets {
+		if outpostARN := aws.StringValue(subnet.OutpostArn); outpostARN != "" {
+			return outpostARN, nil
+		}
+	}
+	return "", nil
+}
+
+// outpostHasInstanceType returns true if the instance type is available on the Outpost.
+// Regional availability of an instance type does not imply its availability on an Outpost.
+func outpostHasInstanceType(awsClient awsclient.Client, outpostARN, instanceType string) (bool, error) {
+	input := &outposts.GetOutpostInstanceTypesInput{OutpostId: aws.String(outpostARN)}
+
+	// AWS API paginates responses, so we need to loop until we get all the results
+	for {
+		output, err := awsClient.GetOutpostInstanceTypes(input)
+		if err != nil {
+			return false, fmt.Errorf("getOutpostInstanceTypes request failed: %w", err)
+		}
+		for _, item := range output.InstanceTypes {
+			if aws.StringValue(item.InstanceType) == instanceType {
+				return true, nil
+			}
+		}
+
+		if output.NextToken == nil {
+			return false, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+)
+
+func TestReconcileOnOutpost(t *testing.T) {
+	testCases := []struct {
+		name          string
+		instanceType  string
+		subnet        *infrav1.AWSResourceReference
+		expectUpdated bool
+	}{
+		{
+			name:          "without subnet",
+			instanceType:  "p2.16xlarge",
+			expectUpdated: true,
+		},
+		{
+			name:          "subnet not on an outpost",
+			instanceType:  "p2.16xlarge",
+			subnet:        &infrav1.AWSResourceReference{ID: ptr.To("subnet-28fddb3c45cae61b5")},
+			expectUpdated: true,
+		},
+		{
+			name:          "instance type available on the outpost",
+			instanceType:  "a1.2xlarge",
+			subnet:        &infrav1.AWSResourceReference{ID: ptr.To(fakeawsclient.OutpostSubnetID)},
+			expectUpdated: true,
+		},
+		{
+			name:          "instance type not available on the outpost",
+			instanceType:  "p2.16xlarge",
+			subnet:        &infrav1.AWSResourceReference{ID: ptr.To(fakeawsclient.OutpostSubnetID)},
+			expectUpdated: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", tc.instanceType, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Name = "outpost"
+			awsMachineTemplate.Spec.Template.Spec.Subnet = tc.subnet
+
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			if tc.expectUpdated {
+				g.Expect(machineDeployment.Annotations).To(HaveKey(cpuKey))
+				g.Expect(recorder.Events).To(BeEmpty())
+				return
+			}
+			g.Expect(machineDeployment.Annotations).ToNot(HaveKey(cpuKey))
+			g.Expect(recorder.Events).To(HaveLen(1))
+			g.Expect(<-recorder.Events).To(And(
+				HavePrefix(corev1.EventTypeWarning+" FailedUpdate "),
+				ContainSubstring(fakeawsclient.OutpostARN),
+			))
+		})
+	}
+}
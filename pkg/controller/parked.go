@@ -0,0 +1,156 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+const (
+	// specChangedKey records the generation of the MachineDeployment and when the controller first observed it,
+	// so that the time since the last spec change survives controller restarts and leader failovers.
+	// The value has the format "<generation>,<RFC3339>". It is only written if the parked tier is enabled.
+	specChangedKey = "capa-annotator/spec-changed"
+)
+
+// parkedActivity holds the last observed activity of a MachineDeployment.
+type parkedActivity struct {
+	generation    int64
+	lastChange    time.Time
+	lastReconcile time.Time
+}
+
+// parkedTracker tracks the activity of MachineDeployments to detect "parked" ones, i.e. MachineDeployments
+// scaled to zero replicas whose spec has not changed for a long time. Parked MachineDeployments are
+// reconciled at a reduced frequency. Access is synchronized via mutex.
+type parkedTracker struct {
+	// after is the duration without spec changes after which a MachineDeployment with zero replicas is parked.
+	after time.Duration
+	// interval is the reconcile interval for parked MachineDeployments.
+	interval time.Duration
+
+	activity map[types.NamespacedName]parkedActivity
+	mutex    sync.Mutex
+	now      func() time.Time
+}
+
+// newParkedTracker creates a tracker for parked MachineDeployments.
+func newParkedTracker(after, interval time.Duration) *parkedTracker {
+	return &parkedTracker{
+		after:    after,
+		interval: interval,
+		activity: map[types.NamespacedName]parkedActivity{},
+		now:      time.Now,
+	}
+}
+
+// shouldSkip records the observed state of the MachineDeployment and returns whether the reconcile
+// can be skipped because the MachineDeployment is parked and was reconciled within the parked interval.
+// If the reconcile is skipped, the returned duration is the time until the next reconcile is due.
+func (p *parkedTracker) shouldSkip(machineDeployment *clusterv1.MachineDeployment) (bool, time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := types.NamespacedName{Namespace: machineDeployment.Namespace, Name: machineDeployment.Name}
+	now := p.now()
+
+	activity, ok := p.activity[key]
+	if !ok {
+		// Continue from the spec change recorded before a restart, if it is still the current generation
+		if generation, changedAt, err := parseSpecChanged(machineDeployment.Annotations[specChangedKey]); err == nil && generation == machineDeployment.Generation {
+			activity = parkedActivity{generation: generation, lastChange: changedAt}
+			p.activity[key] = activity
+		}
+	}
+	if activity.generation != machineDeployment.Generation || activity.lastChange.IsZero() {
+		p.activity[key] = parkedActivity{generation: machineDeployment.Generation, lastChange: now}
+		return false, 0
+	}
+
+	if !isScaledToZero(machineDeployment) || now.Sub(activity.lastChange) < p.after {
+		return false, 0
+	}
+
+	if next := activity.lastReconcile.Add(p.interval); now.Before(next) {
+		return true, next.Sub(now)
+	}
+	return false, 0
+}
+
+// reconciled records a completed reconcile of the MachineDeployment.
+func (p *parkedTracker) reconciled(machineDeployment *clusterv1.MachineDeployment) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := types.NamespacedName{Namespace: machineDeployment.Namespace, Name: machineDeployment.Name}
+	activity := p.activity[key]
+	activity.lastReconcile = p.now()
+	p.activity[key] = activity
+}
+
+// recordSpecChange records the last observed spec change of the MachineDeployment in its annotations.
+func (p *parkedTracker) recordSpecChange(machineDeployment *clusterv1.MachineDeployment) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	activity, ok := p.activity[types.NamespacedName{Namespace: machineDeployment.Namespace, Name: machineDeployment.Name}]
+	if !ok {
+		return
+	}
+
+	if machineDeployment.Annotations == nil {
+		machineDeployment.Annotations = make(map[string]string)
+	}
+	machineDeployment.Annotations[specChangedKey] = fmt.Sprintf("%d,%s", activity.generation, activity.lastChange.UTC().Format(time.RFC3339))
+	setManagedKeys(machineDeployment.Annotations, specChangedKey)
+}
+
+// parseSpecChanged parses the value of the spec-changed annotation.
+func parseSpecChanged(value string) (int64, time.Time, error) {
+	parts := strings.SplitN(value, ",", 2)
+	if len(parts) != 2 {
+		return 0, time.Time{}, fmt.Errorf("invalid spec-changed value %q", value)
+	}
+
+	generation, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid spec-changed generation %q: %w", parts[0], err)
+	}
+
+	changedAt, err := time.Parse(time.RFC3339, parts[1])
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid spec-changed timestamp %q: %w", parts[1], err)
+	}
+	return generation, changedAt, nil
+}
+
+// forget drops the tracked activity of a MachineDeployment, e.g. after it was deleted.
+func (p *parkedTracker) forget(key types.NamespacedName) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.activity, key)
+}
+
+// isScaledToZero returns true if the MachineDeployment has zero desired replicas.
+func isScaledToZero(machineDeployment *clusterv1.MachineDeployment) bool {
+	return machineDeployment.Spec.Replicas != nil && *machineDeployment.Spec.Replicas == 0
+}
@@ -0,0 +1,118 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+func TestParkedTracker(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	tracker := newParkedTracker(time.Hour, 6*time.Hour)
+	tracker.now = func() time.Time { return now }
+
+	machineDeployment := &clusterv1.MachineDeployment{}
+	machineDeployment.Name = "parked"
+	machineDeployment.Namespace = "default"
+	machineDeployment.Generation = 1
+	machineDeployment.Spec.Replicas = ptr.To[int32](0)
+
+	// First observation is always reconciled
+	skip, _ := tracker.shouldSkip(machineDeployment)
+	g.Expect(skip).To(BeFalse())
+	tracker.reconciled(machineDeployment)
+
+	// Not parked yet, the spec changed less than an hour ago
+	now = now.Add(30 * time.Minute)
+	skip, _ = tracker.shouldSkip(machineDeployment)
+	g.Expect(skip).To(BeFalse())
+	tracker.reconciled(machineDeployment)
+
+	// Parked and reconciled recently
+	now = now.Add(time.Hour)
+	skip, requeueAfter := tracker.shouldSkip(machineDeployment)
+	g.Expect(skip).To(BeTrue())
+	g.Expect(requeueAfter).To(Equal(5 * time.Hour))
+
+	// Parked, but the parked interval has passed
+	now = now.Add(5 * time.Hour)
+	skip, _ = tracker.shouldSkip(machineDeployment)
+	g.Expect(skip).To(BeFalse())
+	tracker.reconciled(machineDeployment)
+
+	// A spec change makes the MachineDeployment active again
+	now = now.Add(time.Minute)
+	machineDeployment.Generation = 2
+	skip, _ = tracker.shouldSkip(machineDeployment)
+	g.Expect(skip).To(BeFalse())
+
+	// MachineDeployments with replicas are never parked
+	now = now.Add(2 * time.Hour)
+	machineDeployment.Spec.Replicas = ptr.To[int32](3)
+	skip, _ = tracker.shouldSkip(machineDeployment)
+	g.Expect(skip).To(BeFalse())
+}
+
+func TestParkedTrackerAfterRestart(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	tracker := newParkedTracker(time.Hour, 6*time.Hour)
+	tracker.now = func() time.Time { return now }
+
+	machineDeployment := &clusterv1.MachineDeployment{}
+	machineDeployment.Name = "parked"
+	machineDeployment.Namespace = "default"
+	machineDeployment.Generation = 1
+	machineDeployment.Spec.Replicas = ptr.To[int32](0)
+
+	skip, _ := tracker.shouldSkip(machineDeployment)
+	g.Expect(skip).To(BeFalse())
+	tracker.recordSpecChange(machineDeployment)
+	tracker.reconciled(machineDeployment)
+	g.Expect(machineDeployment.Annotations).To(HaveKey(specChangedKey))
+	g.Expect(getManagedKeys(machineDeployment.Annotations)).To(ContainElement(specChangedKey))
+
+	// A restarted controller continues from the recorded spec change instead of resetting the clock
+	now = now.Add(2 * time.Hour)
+	restarted := newParkedTracker(time.Hour, 6*time.Hour)
+	restarted.now = func() time.Time { return now }
+
+	skip, _ = restarted.shouldSkip(machineDeployment)
+	g.Expect(skip).To(BeFalse(), "the first reconcile after a restart is not skipped")
+	restarted.reconciled(machineDeployment)
+
+	now = now.Add(time.Minute)
+	skip, _ = restarted.shouldSkip(machineDeployment)
+	g.Expect(skip).To(BeTrue())
+
+	// A recorded spec change of an older generation is ignored
+	machineDeployment.Generation = 2
+	restarted = newParkedTracker(time.Hour, 6*time.Hour)
+	restarted.now = func() time.Time { return now }
+	skip, _ = restarted.shouldSkip(machineDeployment)
+	g.Expect(skip).To(BeFalse())
+	restarted.reconciled(machineDeployment)
+
+	now = now.Add(time.Minute)
+	skip, _ = restarted.shouldSkip(machineDeployment)
+	g.Expect(skip).To(BeFalse())
+}
@@ -0,0 +1,84 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// namespaceWildcard matches any namespace in a CrossNamespaceRule.
+const namespaceWildcard = "*"
+
+// CrossNamespaceRule allows MachineDeployments in the Source namespace to reference AWSMachineTemplates
+// in the Target namespace. Either side may be "*" to match any namespace.
+type CrossNamespaceRule struct {
+	Source string
+	Target string
+}
+
+// String serializes the rule into the "<source>:<target>" format.
+func (r CrossNamespaceRule) String() string {
+	return r.Source + ":" + r.Target
+}
+
+// matches returns true if the rule allows a reference from the source to the target namespace.
+func (r CrossNamespaceRule) matches(source, target string) bool {
+	return (r.Source == namespaceWildcard || r.Source == source) &&
+		(r.Target == namespaceWildcard || r.Target == target)
+}
+
+// ParseCrossNamespaceRules parses a comma-separated list of "<source>:<target>" rules.
+func ParseCrossNamespaceRules(value string) ([]CrossNamespaceRule, error) {
+	rules := []CrossNamespaceRule{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid cross-namespace rule %q, expected <source namespace>:<template namespace>", entry)
+		}
+		rules = append(rules, CrossNamespaceRule{
+			Source: strings.TrimSpace(parts[0]),
+			Target: strings.TrimSpace(parts[1]),
+		})
+	}
+	return rules, nil
+}
+
+// checkTemplateNamespace returns an error if the MachineDeployment references an AWSMachineTemplate in
+// another namespace and cross-namespace references are denied without a matching allow rule.
+func (r *Reconciler) checkTemplateNamespace(machineDeployment *clusterv1.MachineDeployment) error {
+	if !r.DenyCrossNamespaceTemplates {
+		return nil
+	}
+
+	target := machineDeployment.Spec.Template.Spec.InfrastructureRef.Namespace
+	if target == "" || target == machineDeployment.Namespace {
+		return nil
+	}
+
+	for _, rule := range r.CrossNamespaceTemplateRules {
+		if rule.matches(machineDeployment.Namespace, target) {
+			return nil
+		}
+	}
+	return annotatorerrors.Errorf(annotatorerrors.ErrCrossNamespaceReference, "reference to AWSMachineTemplate in namespace %q is not allowed from namespace %q", target, machineDeployment.Namespace)
+}
@@ -0,0 +1,188 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestParseCrossNamespaceRules(t *testing.T) {
+	testCases := []struct {
+		name      string
+		value     string
+		expected  []CrossNamespaceRule
+		expectErr bool
+	}{
+		{
+			name:     "empty",
+			value:    "",
+			expected: []CrossNamespaceRule{},
+		},
+		{
+			name:  "multiple rules",
+			value: "team-a:shared, *:templates",
+			expected: []CrossNamespaceRule{
+				{Source: "team-a", Target: "shared"},
+				{Source: "*", Target: "templates"},
+			},
+		},
+		{
+			name:      "missing target",
+			value:     "team-a:",
+			expectErr: true,
+		},
+		{
+			name:      "missing separator",
+			value:     "team-a",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			rules, err := ParseCrossNamespaceRules(tc.value)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(rules).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestCheckTemplateNamespace(t *testing.T) {
+	testCases := []struct {
+		name              string
+		deny              bool
+		rules             []CrossNamespaceRule
+		namespace         string
+		templateNamespace string
+		expectErr         bool
+	}{
+		{
+			name:              "policy disabled",
+			deny:              false,
+			namespace:         "team-a",
+			templateNamespace: "team-b",
+		},
+		{
+			name:      "template namespace unset",
+			deny:      true,
+			namespace: "team-a",
+		},
+		{
+			name:              "same namespace",
+			deny:              true,
+			namespace:         "team-a",
+			templateNamespace: "team-a",
+		},
+		{
+			name:              "denied",
+			deny:              true,
+			namespace:         "team-a",
+			templateNamespace: "team-b",
+			expectErr:         true,
+		},
+		{
+			name:              "allowed by rule",
+			deny:              true,
+			rules:             []CrossNamespaceRule{{Source: "team-a", Target: "shared"}},
+			namespace:         "team-a",
+			templateNamespace: "shared",
+		},
+		{
+			name:              "allowed by wildcard",
+			deny:              true,
+			rules:             []CrossNamespaceRule{{Source: "*", Target: "shared"}},
+			namespace:         "team-b",
+			templateNamespace: "shared",
+		},
+		{
+			name:              "rule for other source",
+			deny:              true,
+			rules:             []CrossNamespaceRule{{Source: "team-a", Target: "shared"}},
+			namespace:         "team-b",
+			templateNamespace: "shared",
+			expectErr:         true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			r := &Reconciler{
+				DenyCrossNamespaceTemplates: tc.deny,
+				CrossNamespaceTemplateRules: tc.rules,
+			}
+
+			machineDeployment := &clusterv1.MachineDeployment{}
+			machineDeployment.Namespace = tc.namespace
+			machineDeployment.Spec.Template.Spec.InfrastructureRef.Namespace = tc.templateNamespace
+
+			err := r.checkTemplateNamespace(machineDeployment)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestReconcileWithDeniedTemplateNamespace(t *testing.T) {
+	g := NewWithT(t)
+
+	existingAnnotations := map[string]string{"existing": "annotation"}
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("team-b", "a1.2xlarge", existingAnnotations)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "workers"
+	machineDeployment.Namespace = "team-a"
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.DenyCrossNamespaceTemplates = true
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+
+	// The template must not be read with the controller's privileges
+	templateGets := 0
+	r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if _, ok := obj.(*infrav1.AWSMachineTemplate); ok {
+				templateGets++
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+	})
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(templateGets).To(BeZero())
+	g.Expect(machineDeployment.Annotations).To(Equal(existingAnnotations))
+
+	g.Expect(recorder.Events).To(HaveLen(1))
+	g.Expect(<-recorder.Events).To(HavePrefix(corev1.EventTypeWarning + " Forbidden "))
+}
@@ -0,0 +1,114 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// CapacityPostProcessor adjusts the capacity of an instance type before it is written to a MachineDeployment,
+// e.g. to subtract the resources reserved for DaemonSets or to cap the reported GPUs.
+type CapacityPostProcessor interface {
+	// PostProcess returns the adjusted capacity. The MachineDeployment is nil when previewing annotations
+	// and for control planes.
+	PostProcess(machineDeployment *clusterv1.MachineDeployment, capacity InstanceType) (InstanceType, error)
+}
+
+// CapacityPostProcessorFunc is a function implementing CapacityPostProcessor.
+type CapacityPostProcessorFunc func(machineDeployment *clusterv1.MachineDeployment, capacity InstanceType) (InstanceType, error)
+
+// PostProcess calls the function.
+func (f CapacityPostProcessorFunc) PostProcess(machineDeployment *clusterv1.MachineDeployment, capacity InstanceType) (InstanceType, error) {
+	return f(machineDeployment, capacity)
+}
+
+// builtinCapacityPostProcessors are the post-processors selectable by name. Each takes a non-negative integer argument.
+var builtinCapacityPostProcessors = map[string]func(value int64) CapacityPostProcessor{
+	// reserve-cpu subtracts the given number of vCPUs, e.g. for DaemonSets and system reserved resources.
+	"reserve-cpu": func(value int64) CapacityPostProcessor {
+		return CapacityPostProcessorFunc(func(_ *clusterv1.MachineDeployment, capacity InstanceType) (InstanceType, error) {
+			capacity.VCPU = max(capacity.VCPU-value, 0)
+			return capacity, nil
+		})
+	},
+	// reserve-memory subtracts the given memory in MiB.
+	"reserve-memory": func(value int64) CapacityPostProcessor {
+		return CapacityPostProcessorFunc(func(_ *clusterv1.MachineDeployment, capacity InstanceType) (InstanceType, error) {
+			capacity.MemoryMb = max(capacity.MemoryMb-value, 0)
+			return capacity, nil
+		})
+	},
+	// max-gpu caps the number of GPUs, e.g. if only some GPUs of an instance are made available to pods.
+	"max-gpu": func(value int64) CapacityPostProcessor {
+		return CapacityPostProcessorFunc(func(_ *clusterv1.MachineDeployment, capacity InstanceType) (InstanceType, error) {
+			capacity.GPU = min(capacity.GPU, value)
+			return capacity, nil
+		})
+	},
+}
+
+// ParseCapacityPostProcessors parses a comma-separated list of built-in post-processors in the form
+// "<name>=<value>", e.g. "reserve-memory=512,max-gpu=4". The post-processors are applied in the given order.
+func ParseCapacityPostProcessors(value string) ([]CapacityPostProcessor, error) {
+	processors := []CapacityPostProcessor{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, argument, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid post-processor %q, must be in the form <name>=<value>", entry)
+		}
+		newProcessor, ok := builtinCapacityPostProcessors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown post-processor %q, must be one of %q", name, capacityPostProcessorNames())
+		}
+		parsed, err := strconv.ParseInt(argument, 10, 64)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid value %q of post-processor %q, must be a non-negative integer", argument, name)
+		}
+		processors = append(processors, newProcessor(parsed))
+	}
+	return processors, nil
+}
+
+// capacityPostProcessorNames returns the sorted names of the built-in post-processors.
+func capacityPostProcessorNames() []string {
+	names := make([]string, 0, len(builtinCapacityPostProcessors))
+	for name := range builtinCapacityPostProcessors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// postProcessCapacity applies the vCPU corrections and then the post-processors of the reconciler to the capacity in order.
+func (r *Reconciler) postProcessCapacity(machineDeployment *clusterv1.MachineDeployment, capacity InstanceType) (InstanceType, error) {
+	capacity = r.correctVCPU(capacity)
+	for _, processor := range r.CapacityPostProcessors {
+		var err error
+		capacity, err = processor.PostProcess(machineDeployment, capacity)
+		if err != nil {
+			return InstanceType{}, err
+		}
+	}
+	return capacity, nil
+}
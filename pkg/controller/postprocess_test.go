@@ -0,0 +1,114 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+func TestParseCapacityPostProcessors(t *testing.T) {
+	capacity := InstanceType{VCPU: 64, MemoryMb: 749568, GPU: 16}
+
+	testCases := []struct {
+		value            string
+		expectedCapacity InstanceType
+		expectErr        bool
+	}{
+		{
+			value:            "",
+			expectedCapacity: capacity,
+		},
+		{
+			value:            "reserve-cpu=2, reserve-memory=1024,max-gpu=4",
+			expectedCapacity: InstanceType{VCPU: 62, MemoryMb: 748544, GPU: 4},
+		},
+		{
+			value:            "reserve-cpu=100,max-gpu=32",
+			expectedCapacity: InstanceType{VCPU: 0, MemoryMb: 749568, GPU: 16},
+		},
+		{
+			value:     "reserve-cpu",
+			expectErr: true,
+		},
+		{
+			value:     "reserve-disk=10",
+			expectErr: true,
+		},
+		{
+			value:     "max-gpu=-1",
+			expectErr: true,
+		},
+		{
+			value:     "reserve-memory=512Mi",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			g := NewWithT(t)
+
+			processors, err := ParseCapacityPostProcessors(tc.value)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+
+			r := &Reconciler{CapacityPostProcessors: processors}
+			processed, err := r.postProcessCapacity(nil, capacity)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(processed).To(Equal(tc.expectedCapacity))
+		})
+	}
+}
+
+func TestReconcileWithCapacityPostProcessors(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "p2.16xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "post-processed"
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.CapacityPostProcessors, err = ParseCapacityPostProcessors("max-gpu=4")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Post-processors registered via the library API receive the MachineDeployment
+	r.CapacityPostProcessors = append(r.CapacityPostProcessors, CapacityPostProcessorFunc(func(md *clusterv1.MachineDeployment, capacity InstanceType) (InstanceType, error) {
+		if md.Name == machineDeployment.Name {
+			capacity.MemoryMb -= 512
+		}
+		return capacity, nil
+	}))
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(cpuKey, "64"))
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(memoryKey, "749056"))
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(gpuKey, "4"))
+
+	// Failing post-processors fail the reconcile instead of writing unadjusted capacity
+	r.CapacityPostProcessors = []CapacityPostProcessor{CapacityPostProcessorFunc(func(*clusterv1.MachineDeployment, InstanceType) (InstanceType, error) {
+		return InstanceType{}, errors.New("overhead unknown")
+	})}
+	machineDeployment.Annotations = nil
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).To(MatchError(ContainSubstring("overhead unknown")))
+	g.Expect(machineDeployment.Annotations).ToNot(HaveKey(cpuKey))
+}
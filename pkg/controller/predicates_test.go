@@ -0,0 +1,85 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestMachineDeploymentChangedPredicate(t *testing.T) {
+	old := &clusterv1.MachineDeployment{}
+	old.Generation = 1
+	old.ResourceVersion = "1"
+	old.Labels = map[string]string{"team": "a"}
+	old.Annotations = map[string]string{cpuKey: "8"}
+
+	testCases := []struct {
+		name          string
+		update        func(*clusterv1.MachineDeployment)
+		expectEnqueue bool
+	}{
+		{
+			name: "status update",
+			update: func(md *clusterv1.MachineDeployment) {
+				md.Status.Replicas = 3
+			},
+		},
+		{
+			name: "spec change",
+			update: func(md *clusterv1.MachineDeployment) {
+				md.Generation = 2
+				md.Spec.Template.Spec.InfrastructureRef.Name = "rotated"
+			},
+			expectEnqueue: true,
+		},
+		{
+			name: "annotation drift",
+			update: func(md *clusterv1.MachineDeployment) {
+				delete(md.Annotations, cpuKey)
+			},
+			expectEnqueue: true,
+		},
+		{
+			name: "label change",
+			update: func(md *clusterv1.MachineDeployment) {
+				md.Labels[clusterv1.WatchLabel] = "canary"
+			},
+			expectEnqueue: true,
+		},
+		{
+			name:          "resync",
+			expectEnqueue: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			updated := old.DeepCopy()
+			if tc.update != nil {
+				updated.ResourceVersion = "2"
+				tc.update(updated)
+			}
+			g.Expect(machineDeploymentChangedPredicate().Update(event.UpdateEvent{ObjectOld: old, ObjectNew: updated})).To(Equal(tc.expectEnqueue))
+		})
+	}
+
+	g := NewWithT(t)
+	g.Expect(machineDeploymentChangedPredicate().Create(event.CreateEvent{Object: old})).To(BeTrue())
+}
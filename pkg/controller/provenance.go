@@ -0,0 +1,85 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/version"
+)
+
+const (
+	// provenanceKey records where the capacity values written by the controller came from.
+	// The value has the format "source=<source>,region=<region>,fetchedAt=<RFC3339>,version=<controller version>".
+	provenanceKey = "capa-annotator/provenance"
+)
+
+// Provenance describes the origin of the capacity values written to a MachineDeployment.
+type Provenance struct {
+	Source    DataSource
+	Region    string
+	FetchedAt time.Time
+	Version   string
+}
+
+// String serializes the provenance into the annotation format.
+func (p Provenance) String() string {
+	return fmt.Sprintf("source=%s,region=%s,fetchedAt=%s,version=%s", p.Source, p.Region, p.FetchedAt.UTC().Format(time.RFC3339), p.Version)
+}
+
+// ParseProvenance parses the provenance annotation value. Unknown fields are ignored.
+func ParseProvenance(value string) (Provenance, error) {
+	provenance := Provenance{}
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) != 2 {
+			return Provenance{}, fmt.Errorf("invalid provenance field %q", field)
+		}
+
+		switch parts[0] {
+		case "source":
+			provenance.Source = DataSource(parts[1])
+		case "region":
+			provenance.Region = parts[1]
+		case "fetchedAt":
+			fetchedAt, err := time.Parse(time.RFC3339, parts[1])
+			if err != nil {
+				return Provenance{}, fmt.Errorf("invalid provenance fetchedAt %q: %w", parts[1], err)
+			}
+			provenance.FetchedAt = fetchedAt
+		case "version":
+			provenance.Version = parts[1]
+		}
+	}
+	return provenance, nil
+}
+
+// setProvenance records the provenance of the instance type information in the annotations.
+// The provenance is only updated when the capacity values, the region or the controller version changed (or
+// no provenance was recorded yet), so that it describes the lookup that produced the values currently written
+// and does not cause a patch on every reconcile served from cache.
+func setProvenance(annotations map[string]string, instanceTypeInfo InstanceType, region string, valuesChanged bool) {
+	if existing, err := ParseProvenance(annotations[provenanceKey]); err == nil && existing.Region == region && existing.Version == version.Version && !valuesChanged {
+		return
+	}
+
+	annotations[provenanceKey] = Provenance{
+		Source:    instanceTypeInfo.Source,
+		Region:    region,
+		FetchedAt: instanceTypeInfo.FetchedAt,
+		Version:   version.Version,
+	}.String()
+}
@@ -0,0 +1,134 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// awsMachineTemplateKind is the kind of the infrastructure templates handled by the built-in AWS implementation.
+const awsMachineTemplateKind = "AWSMachineTemplate"
+
+// Provider resolves the capacity of the machines of MachineDeployments of another infrastructure provider, so that
+// they can be annotated without forking the reconciler. AWSMachineTemplates are always handled by the built-in
+// AWS implementation, including its AWS specific features such as Outposts and zone labels.
+type Provider interface {
+	// Name identifies the provider in logs and events.
+	Name() string
+	// Handles returns true if the provider resolves infrastructure templates of the given reference.
+	Handles(ref corev1.ObjectReference) bool
+	// ResolveCapacity resolves the location and the capacity of the instance type of the machines of the
+	// MachineDeployment. The capacity is post-processed and annotated like the capacity of AWS instance types.
+	ResolveCapacity(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (ProviderCapacity, error)
+}
+
+// ProviderCapacity is the capacity of the machines of a MachineDeployment resolved by a Provider.
+type ProviderCapacity struct {
+	// Location is the region or an equivalent of the provider, recorded in the provenance annotation.
+	Location string
+	// InstanceType is the capacity of the instance type of the machines.
+	InstanceType InstanceType
+}
+
+// providerFor returns the provider handling the infrastructure template of the MachineDeployment, or nil if it
+// is handled by the built-in AWS implementation.
+func (r *Reconciler) providerFor(machineDeployment *clusterv1.MachineDeployment) Provider {
+	ref := machineDeployment.Spec.Template.Spec.InfrastructureRef
+	if ref.Kind == awsMachineTemplateKind {
+		return nil
+	}
+	for _, provider := range r.Providers {
+		if provider.Handles(ref) {
+			return provider
+		}
+	}
+	return nil
+}
+
+// reconcileWithProvider sets the capacity annotations resolved by the provider. It returns true if the
+// MachineDeployment was annotated, so that its instance type is reported as in use.
+func (r *Reconciler) reconcileWithProvider(ctx context.Context, machineDeployment *clusterv1.MachineDeployment, provider Provider) (bool, error) {
+	resolved, err := provider.ResolveCapacity(ctx, r.Client, machineDeployment)
+	if err != nil {
+		klog.Errorf("Failed to resolve capacity with provider %s: %v", provider.Name(), err)
+		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "FailedUpdate", "Failed to resolve capacity with provider %s: %v", provider.Name(), err)
+		return false, err
+	}
+
+	instanceTypeInfo := resolved.InstanceType
+	if err := r.InstanceTypePolicy.Check(machineDeployment.Namespace, instanceTypeInfo.InstanceType); err != nil {
+		klog.Errorf("%v: Not setting scale from zero annotations: %v", machineDeployment.Name, err)
+		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "PolicyViolation", "Not setting autoscaling from zero annotations: %v", err)
+		setReconcileResult(ctx, metrics.ResultForbidden, err.Error())
+		return false, nil
+	}
+
+	capacity, err := r.postProcessCapacity(machineDeployment, instanceTypeInfo)
+	if err != nil {
+		return false, fmt.Errorf("error post-processing capacity of instance type %s: %w", instanceTypeInfo.InstanceType, err)
+	}
+
+	if machineDeployment.Annotations == nil {
+		machineDeployment.Annotations = make(map[string]string)
+	}
+	r.setCapacityAnnotations(machineDeployment.Annotations, capacity, resolved.Location, nil)
+	r.startMigration(machineDeployment.Annotations)
+	r.enforceLabelsSizeLimit(machineDeployment)
+
+	metrics.SetInstanceTypeInUse(client.ObjectKeyFromObject(machineDeployment).String(), resolved.Location, instanceTypeInfo.InstanceType,
+		instanceTypeInfo.VCPU, instanceTypeInfo.MemoryMb, instanceTypeInfo.GPU)
+	r.setCapacityMetrics(machineDeployment, instanceTypeInfo.InstanceType, capacity)
+	return true, nil
+}
+
+// getUnstructured fetches the referenced object, defaulting its namespace to the given one.
+func getUnstructured(ctx context.Context, c client.Client, apiVersion, kind, namespace, name, defaultNamespace string) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(schema.FromAPIVersionAndKind(apiVersion, kind))
+	key := client.ObjectKey{Namespace: namespace, Name: name}
+	if key.Namespace == "" {
+		key.Namespace = defaultNamespace
+	}
+	if err := c.Get(ctx, key, obj); err != nil {
+		return nil, annotatorerrors.NewLookupError(kind, key.Namespace, key.Name, err)
+	}
+	return obj, nil
+}
+
+// getInfrastructureCluster fetches the infrastructure cluster of the given kind of the Cluster of the MachineDeployment.
+// An empty kind accepts any infrastructure cluster.
+func getInfrastructureCluster(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment, kind string) (*unstructured.Unstructured, error) {
+	cluster := &clusterv1.Cluster{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: machineDeployment.Namespace, Name: machineDeployment.Spec.ClusterName}, cluster); err != nil {
+		return nil, annotatorerrors.NewLookupError("Cluster", machineDeployment.Namespace, machineDeployment.Spec.ClusterName, err)
+	}
+	ref := cluster.Spec.InfrastructureRef
+	if ref == nil {
+		return nil, annotatorerrors.Errorf(annotatorerrors.ErrInvalidReference, "cluster %s has nil infrastructureRef", cluster.Name)
+	}
+	if kind != "" && ref.Kind != kind {
+		return nil, annotatorerrors.Errorf(annotatorerrors.ErrInvalidReference, "cluster %s has a %s instead of a %s", cluster.Name, ref.Kind, kind)
+	}
+	return getUnstructured(ctx, c, ref.APIVersion, ref.Kind, ref.Namespace, ref.Name, cluster.Namespace)
+}
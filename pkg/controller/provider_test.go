@@ -0,0 +1,115 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type fakeProvider struct {
+	kind     string
+	capacity ProviderCapacity
+	err      error
+}
+
+func (p fakeProvider) Name() string { return "fake" }
+
+func (p fakeProvider) Handles(ref corev1.ObjectReference) bool { return ref.Kind == p.kind }
+
+func (p fakeProvider) ResolveCapacity(_ context.Context, _ client.Client, _ *clusterv1.MachineDeployment) (ProviderCapacity, error) {
+	return p.capacity, p.err
+}
+
+func TestReconcileWithProvider(t *testing.T) {
+	capacity := ProviderCapacity{
+		Location: "westeurope",
+		InstanceType: InstanceType{
+			InstanceType:    "Standard_D4s_v3",
+			VCPU:            4,
+			MemoryMb:        16384,
+			CPUArchitecture: ArchitectureAmd64,
+		},
+	}
+
+	testCases := []struct {
+		name                string
+		kind                string
+		provider            fakeProvider
+		expectErr           bool
+		expectedAnnotations map[string]string
+		expectedEvent       string
+	}{
+		{
+			name:     "template handled by the provider",
+			kind:     "FakeMachineTemplate",
+			provider: fakeProvider{kind: "FakeMachineTemplate", capacity: capacity},
+			expectedAnnotations: map[string]string{
+				cpuKey:    "4",
+				memoryKey: "16384",
+			},
+		},
+		{
+			name:          "provider fails to resolve the capacity",
+			kind:          "FakeMachineTemplate",
+			provider:      fakeProvider{kind: "FakeMachineTemplate", err: errors.New("unknown SKU")},
+			expectErr:     true,
+			expectedEvent: corev1.EventTypeWarning + " FailedUpdate Failed to resolve capacity with provider fake: unknown SKU",
+		},
+		{
+			name:     "AWSMachineTemplate is always handled by the built-in implementation",
+			kind:     "AWSMachineTemplate",
+			provider: fakeProvider{kind: "AWSMachineTemplate", capacity: capacity},
+			expectedAnnotations: map[string]string{
+				cpuKey:    "8",
+				memoryKey: "16384",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Spec.Template.Spec.InfrastructureRef.Kind = tc.kind
+
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+			r.Providers = []Provider{tc.provider}
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			for key, value := range tc.expectedAnnotations {
+				g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(key, value))
+			}
+			if tc.expectedEvent != "" {
+				g.Expect(recorder.Events).To(Receive(Equal(tc.expectedEvent)))
+			}
+		})
+	}
+}
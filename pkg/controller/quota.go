@@ -0,0 +1,162 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NamespaceQuota is the budget of each namespace for patches and warning events per time window. It protects the
+// API server and the event storage from a single tenant, e.g. with many misconfigured MachineDeployments.
+type NamespaceQuota struct {
+	// Patches is the number of MachineDeployment patches per window. Zero is unlimited. Patches beyond the
+	// budget are deferred to the next window.
+	Patches int
+	// WarningEvents is the number of warning events per window. Zero is unlimited. Warning events beyond
+	// the budget are dropped, they are still sent to the audit sink.
+	WarningEvents int
+	// Window is the duration of the budget windows.
+	Window time.Duration
+}
+
+// enabled returns true if any budget is limited.
+func (q NamespaceQuota) enabled() bool {
+	return q.Window > 0 && (q.Patches > 0 || q.WarningEvents > 0)
+}
+
+// namespaceWindow is the usage of the budgets of a namespace in the current window.
+type namespaceWindow struct {
+	start time.Time
+	used  map[string]int
+	// throttled records the budgets used up in the window, to log it once per window.
+	throttled map[string]bool
+}
+
+// namespaceBudgets tracks the usage of the budgets per namespace in fixed windows. Access is synchronized via mutex.
+type namespaceBudgets struct {
+	quota   NamespaceQuota
+	windows map[string]*namespaceWindow
+	mutex   sync.Mutex
+	now     func() time.Time
+}
+
+func newNamespaceBudgets(quota NamespaceQuota) *namespaceBudgets {
+	return &namespaceBudgets{
+		quota:   quota,
+		windows: map[string]*namespaceWindow{},
+		now:     time.Now,
+	}
+}
+
+// take uses one unit of the budget of the namespace. If the budget is used up, false is returned together
+// with the time until the window ends. The budget is one of metrics.QuotaPatches or metrics.QuotaWarningEvents.
+func (b *namespaceBudgets) take(namespace, budget string) (bool, time.Duration) {
+	limit := b.quota.Patches
+	if budget == metrics.QuotaWarningEvents {
+		limit = b.quota.WarningEvents
+	}
+	if limit <= 0 {
+		return true, 0
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	window, ok := b.windows[namespace]
+	if !ok || now.Sub(window.start) >= b.quota.Window {
+		window = &namespaceWindow{start: now, used: map[string]int{}, throttled: map[string]bool{}}
+		b.windows[namespace] = window
+		b.pruneLocked(now)
+	}
+
+	if window.used[budget] >= limit {
+		if !window.throttled[budget] {
+			klog.Infof("Namespace %s used up its budget of %d %s per %v, throttling until the window ends", namespace, limit, budget, b.quota.Window)
+			window.throttled[budget] = true
+		}
+		metrics.RecordBudgetExceeded(namespace, budget)
+		return false, b.quota.Window - now.Sub(window.start)
+	}
+	window.used[budget]++
+	return true, 0
+}
+
+// pruneLocked removes the windows that ended, so that namespaces without recent activity are not kept.
+func (b *namespaceBudgets) pruneLocked(now time.Time) {
+	for namespace, window := range b.windows {
+		if now.Sub(window.start) >= b.quota.Window {
+			delete(b.windows, namespace)
+		}
+	}
+}
+
+// quotaRecorder is an event recorder that drops warning events beyond the warning event budget of the namespace.
+type quotaRecorder struct {
+	record.EventRecorder
+	budgets *namespaceBudgets
+}
+
+// Event records the event unless it is a warning event beyond the budget.
+func (q *quotaRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if q.allow(object, eventtype) {
+		q.EventRecorder.Event(object, eventtype, reason, message)
+	}
+}
+
+// Eventf records the event unless it is a warning event beyond the budget.
+func (q *quotaRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if q.allow(object, eventtype) {
+		q.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	}
+}
+
+// AnnotatedEventf records the event unless it is a warning event beyond the budget.
+func (q *quotaRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if q.allow(object, eventtype) {
+		q.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	}
+}
+
+func (q *quotaRecorder) allow(object runtime.Object, eventtype string) bool {
+	if eventtype != corev1.EventTypeWarning {
+		return true
+	}
+	obj, ok := object.(client.Object)
+	if !ok {
+		return true
+	}
+	allowed, _ := q.budgets.take(obj.GetNamespace(), metrics.QuotaWarningEvents)
+	return allowed
+}
+
+// throttlePatch takes a unit of the patch budget of the namespace of the MachineDeployment if the patch changes it.
+// It returns true with the time until the budget is renewed if the budget is used up.
+func (r *Reconciler) throttlePatch(machineDeployment *clusterv1.MachineDeployment, patch client.Patch) (bool, time.Duration) {
+	if patchEmpty(machineDeployment, patch) {
+		// Patches without changes do not count against the budget
+		return false, 0
+	}
+	allowed, requeueAfter := r.budgets.take(machineDeployment.Namespace, metrics.QuotaPatches)
+	return !allowed, requeueAfter
+}
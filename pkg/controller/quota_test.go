@@ -0,0 +1,105 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestNamespaceBudgets(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	budgets := newNamespaceBudgets(NamespaceQuota{Patches: 2, Window: time.Minute})
+	budgets.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		allowed, _ := budgets.take("tenant-a", metrics.QuotaPatches)
+		g.Expect(allowed).To(BeTrue())
+	}
+	now = now.Add(20 * time.Second)
+	allowed, requeueAfter := budgets.take("tenant-a", metrics.QuotaPatches)
+	g.Expect(allowed).To(BeFalse())
+	g.Expect(requeueAfter).To(Equal(40 * time.Second))
+
+	// Other namespaces and unlimited budgets are not affected
+	allowed, _ = budgets.take("tenant-b", metrics.QuotaPatches)
+	g.Expect(allowed).To(BeTrue())
+	allowed, _ = budgets.take("tenant-a", metrics.QuotaWarningEvents)
+	g.Expect(allowed).To(BeTrue())
+
+	// The budget is renewed with the next window
+	now = now.Add(40 * time.Second)
+	allowed, _ = budgets.take("tenant-a", metrics.QuotaPatches)
+	g.Expect(allowed).To(BeTrue())
+}
+
+func TestQuotaRecorder(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeRecorder := record.NewFakeRecorder(10)
+	recorder := &quotaRecorder{EventRecorder: fakeRecorder, budgets: newNamespaceBudgets(NamespaceQuota{WarningEvents: 1, Window: time.Minute})}
+
+	machineDeployment := &clusterv1.MachineDeployment{}
+	machineDeployment.Namespace = "tenant-a"
+	machineDeployment.Name = "noisy"
+
+	recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "FailedUpdate", "first")
+	recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "FailedUpdate", "second")
+	recorder.Event(machineDeployment, corev1.EventTypeNormal, "Updated", "normal events are not limited")
+
+	g.Expect(fakeRecorder.Events).To(HaveLen(2))
+	g.Expect(<-fakeRecorder.Events).To(HaveSuffix("first"))
+	g.Expect(<-fakeRecorder.Events).To(HaveSuffix("normal events are not limited"))
+}
+
+func TestReconcileWithPatchBudget(t *testing.T) {
+	g := NewWithT(t)
+
+	first, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	first.Name = "first"
+	second := first.DeepCopy()
+	second.Name = "second"
+
+	r := newTestReconciler(g, first, second, awsMachineTemplate, cluster, awsCluster)
+	r.budgets = newNamespaceBudgets(NamespaceQuota{Patches: 1, Window: time.Minute})
+
+	result, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(first)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeZero())
+
+	// The patch of the second MachineDeployment is deferred to the next window
+	result, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(second)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+	g.Expect(result.RequeueAfter).To(BeNumerically("<=", time.Minute))
+	updated := &clusterv1.MachineDeployment{}
+	g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(second), updated)).To(Succeed())
+	g.Expect(updated.Annotations).ToNot(HaveKey(cpuKey))
+
+	// Reconciles without changes do not count against the budget
+	result, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(first)})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeZero())
+}
@@ -0,0 +1,360 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	"github.com/jhjaggars/capa-annotator/pkg/utils"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// vcpuQuotaCodes are the codes of the EC2 service quotas of running On-Demand instances by the instance family
+// prefix they apply to. The quotas are counted in vCPUs across all instance types of the prefixes.
+var vcpuQuotaCodes = map[string]string{
+	"a": "L-1216C47A", "c": "L-1216C47A", "d": "L-1216C47A", "h": "L-1216C47A", "i": "L-1216C47A",
+	"m": "L-1216C47A", "r": "L-1216C47A", "t": "L-1216C47A", "z": "L-1216C47A",
+	"f":   "L-74FC7D96",
+	"g":   "L-DB2E81BA",
+	"gr":  "L-DB2E81BA",
+	"vt":  "L-DB2E81BA",
+	"inf": "L-1945791B",
+	"p":   "L-417A185B",
+	"x":   "L-7295265B",
+	"dl":  "L-6E869C2A",
+	"trn": "L-2C3B7624",
+	"u":   "L-43DA4232",
+	"hpc": "L-F7808C92",
+}
+
+// vcpuQuotaCode returns the code of the vCPU quota of the instance type, e.g. L-1216C47A for m5.large. Prefixes
+// without a quota of their own, e.g. im of im4gn, fall back to their first letter. Mac instances run on dedicated
+// hosts and are not subject to a vCPU quota.
+func vcpuQuotaCode(instanceType string) (string, bool) {
+	family, _, _ := strings.Cut(instanceType, ".")
+	prefix := family
+	if end := strings.IndexFunc(family, func(r rune) bool { return r < 'a' || r > 'z' }); end >= 0 {
+		prefix = family[:end]
+	}
+	if prefix == "" || prefix == "mac" {
+		return "", false
+	}
+	if code, ok := vcpuQuotaCodes[prefix]; ok {
+		return code, true
+	}
+	code, ok := vcpuQuotaCodes[prefix[:1]]
+	return code, ok
+}
+
+// QuotaPreflight tells for every MachineDeployment whether scaling it up by one replica would exceed the vCPU
+// quota of the account in its region, which fails the scale up regardless of the capacity of the instance type.
+type QuotaPreflight struct {
+	GeneratedAt        time.Time                        `json:"generatedAt"`
+	Quotas             []VCPUQuotaUsage                 `json:"quotas"`
+	MachineDeployments []MachineDeploymentQuotaHeadroom `json:"machineDeployments"`
+	Unresolved         []UnresolvedMachineDeployment    `json:"unresolved,omitempty"`
+}
+
+// VCPUQuotaUsage is a vCPU quota of a region and the vCPUs of the instances counting against it.
+type VCPUQuotaUsage struct {
+	Region    string  `json:"region"`
+	QuotaCode string  `json:"quotaCode"`
+	QuotaName string  `json:"quotaName"`
+	Limit     float64 `json:"limit"`
+	Usage     int64   `json:"usage"`
+}
+
+// MachineDeploymentQuotaHeadroom is the vCPU quota headroom of a MachineDeployment.
+type MachineDeploymentQuotaHeadroom struct {
+	MachineDeployment string `json:"machineDeployment"`
+	Region            string `json:"region"`
+	InstanceType      string `json:"instanceType"`
+	QuotaCode         string `json:"quotaCode"`
+	VCPU              int64  `json:"vcpu"`
+	// ExceedsQuota is true if one more replica would exceed the quota.
+	ExceedsQuota bool `json:"exceedsQuota"`
+}
+
+// BuildQuotaPreflight checks the vCPU quota headroom of all MachineDeployments in scope of the reconciler.
+// The quotas and the running instances are read once per region with the controller's own credentials, i.e.
+// for the account of the controller. MachineDeployments of Spot instances count against the Spot quotas and are
+// not checked.
+func (r *Reconciler) BuildQuotaPreflight(ctx context.Context) (*QuotaPreflight, error) {
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := r.Client.List(ctx, machineDeployments); err != nil {
+		return nil, fmt.Errorf("failed to list MachineDeployments: %w", err)
+	}
+
+	preflight := &QuotaPreflight{
+		GeneratedAt:        time.Now().UTC(),
+		Quotas:             []VCPUQuotaUsage{},
+		MachineDeployments: []MachineDeploymentQuotaHeadroom{},
+	}
+
+	// region -> MachineDeployments
+	byRegion := map[string][]MachineDeploymentQuotaHeadroom{}
+	for i := range machineDeployments.Items {
+		machineDeployment := &machineDeployments.Items[i]
+		if !machineDeployment.DeletionTimestamp.IsZero() || !r.inScope(machineDeployment) {
+			continue
+		}
+		name := machineDeployment.Namespace + "/" + machineDeployment.Name
+
+		region, instanceType, spot, err := r.resolveOnDemandInstanceType(ctx, machineDeployment)
+		if err != nil {
+			preflight.Unresolved = append(preflight.Unresolved, UnresolvedMachineDeployment{MachineDeployment: name, Error: err.Error()})
+			continue
+		}
+		if spot {
+			continue
+		}
+		quotaCode, ok := vcpuQuotaCode(instanceType)
+		if !ok {
+			continue
+		}
+		byRegion[region] = append(byRegion[region], MachineDeploymentQuotaHeadroom{
+			MachineDeployment: name,
+			Region:            region,
+			InstanceType:      instanceType,
+			QuotaCode:         quotaCode,
+		})
+	}
+
+	for region, headrooms := range byRegion {
+		unresolved := func(err error) {
+			for _, headroom := range headrooms {
+				preflight.Unresolved = append(preflight.Unresolved, UnresolvedMachineDeployment{MachineDeployment: headroom.MachineDeployment, Error: err.Error()})
+			}
+		}
+
+		awsClient, err := r.AwsClientBuilder(r.Client, "", "", region, r.RegionCache)
+		if err != nil {
+			// The other regions are still checked
+			unresolved(fmt.Errorf("error creating aws client for region %s: %w", region, err))
+			continue
+		}
+		usage, err := vcpuQuotaUsage(awsClient)
+		if err != nil {
+			unresolved(fmt.Errorf("failed to describe the instances of region %s: %w", region, err))
+			continue
+		}
+
+		quotas := map[string]*VCPUQuotaUsage{}
+		for _, headroom := range headrooms {
+			quota, ok := quotas[headroom.QuotaCode]
+			if !ok {
+				quota, err = getVCPUQuota(awsClient, region, headroom.QuotaCode)
+				if err != nil {
+					preflight.Unresolved = append(preflight.Unresolved, UnresolvedMachineDeployment{MachineDeployment: headroom.MachineDeployment, Error: err.Error()})
+					continue
+				}
+				quota.Usage = usage[headroom.QuotaCode]
+				quotas[headroom.QuotaCode] = quota
+			}
+
+			instanceTypeInfo, err := r.InstanceTypesCache.GetInstanceType(awsClient, region, headroom.InstanceType)
+			if err != nil {
+				preflight.Unresolved = append(preflight.Unresolved, UnresolvedMachineDeployment{MachineDeployment: headroom.MachineDeployment, Error: err.Error()})
+				continue
+			}
+			headroom.VCPU = instanceTypeInfo.VCPU
+			headroom.ExceedsQuota = float64(quota.Usage+headroom.VCPU) > quota.Limit
+			preflight.MachineDeployments = append(preflight.MachineDeployments, headroom)
+		}
+		for _, quota := range quotas {
+			preflight.Quotas = append(preflight.Quotas, *quota)
+		}
+	}
+
+	sort.Slice(preflight.Quotas, func(i, j int) bool {
+		if preflight.Quotas[i].Region != preflight.Quotas[j].Region {
+			return preflight.Quotas[i].Region < preflight.Quotas[j].Region
+		}
+		return preflight.Quotas[i].QuotaCode < preflight.Quotas[j].QuotaCode
+	})
+	sort.Slice(preflight.MachineDeployments, func(i, j int) bool {
+		return preflight.MachineDeployments[i].MachineDeployment < preflight.MachineDeployments[j].MachineDeployment
+	})
+	sort.Slice(preflight.Unresolved, func(i, j int) bool {
+		return preflight.Unresolved[i].MachineDeployment < preflight.Unresolved[j].MachineDeployment
+	})
+	return preflight, nil
+}
+
+// resolveOnDemandInstanceType returns the region and instance type of the MachineDeployment, and whether it runs
+// Spot instances.
+func (r *Reconciler) resolveOnDemandInstanceType(ctx context.Context, machineDeployment *clusterv1.MachineDeployment) (string, string, bool, error) {
+	if err := r.checkTemplateNamespace(machineDeployment); err != nil {
+		return "", "", false, err
+	}
+
+	awsMachineTemplate, err := r.templateResolver().ResolveAWSMachineTemplate(ctx, r.Client, machineDeployment)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	instanceType, err := utils.ExtractInstanceType(awsMachineTemplate)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	region, err := r.regionResolver().ResolveRegion(ctx, r.Client, machineDeployment)
+	if err != nil {
+		return "", "", false, err
+	}
+	return region, instanceType, awsMachineTemplate.Spec.Template.Spec.SpotMarketOptions != nil, nil
+}
+
+// vcpuQuotaUsage returns the vCPUs of the pending and running On-Demand instances of the region by quota code.
+func vcpuQuotaUsage(awsClient awsclient.Client) (map[string]int64, error) {
+	usage := map[string]int64{}
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"pending", "running"})}},
+	}
+	for {
+		output, err := awsClient.DescribeInstances(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range output.Reservations {
+			for _, instance := range reservation.Instances {
+				if aws.StringValue(instance.InstanceLifecycle) == ec2.InstanceLifecycleTypeSpot || instance.CpuOptions == nil {
+					continue
+				}
+				quotaCode, ok := vcpuQuotaCode(aws.StringValue(instance.InstanceType))
+				if !ok {
+					continue
+				}
+				usage[quotaCode] += aws.Int64Value(instance.CpuOptions.CoreCount) * aws.Int64Value(instance.CpuOptions.ThreadsPerCore)
+			}
+		}
+		if aws.StringValue(output.NextToken) == "" {
+			return usage, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// getVCPUQuota returns the applied value of the vCPU quota of the region.
+func getVCPUQuota(awsClient awsclient.Client, region, quotaCode string) (*VCPUQuotaUsage, error) {
+	output, err := awsClient.GetServiceQuota(&servicequotas.GetServiceQuotaInput{
+		ServiceCode: aws.String("ec2"),
+		QuotaCode:   aws.String(quotaCode),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service quota %s of region %s: %w", quotaCode, region, err)
+	}
+	if output.Quota == nil {
+		return nil, fmt.Errorf("service quota %s of region %s not found", quotaCode, region)
+	}
+	return &VCPUQuotaUsage{
+		Region:    region,
+		QuotaCode: quotaCode,
+		QuotaName: aws.StringValue(output.Quota.QuotaName),
+		Limit:     aws.Float64Value(output.Quota.Value),
+	}, nil
+}
+
+// QuotaPreflighter periodically builds the quota preflight, exports it as metrics and serves the latest one as
+// JSON. Access to the latest preflight is synchronized via rwmutex.
+type QuotaPreflighter struct {
+	// Reconciler is used to resolve the MachineDeployments and instance types.
+	Reconciler *Reconciler
+	// Interval is the interval between two preflights.
+	Interval time.Duration
+
+	latest  []byte
+	rwmutex sync.RWMutex
+}
+
+// Start builds a preflight immediately and then once per interval until the context is cancelled.
+// It implements the controller-runtime Runnable interface.
+func (q *QuotaPreflighter) Start(ctx context.Context) error {
+	ticker := time.NewTicker(q.Interval)
+	defer ticker.Stop()
+
+	for {
+		q.generate(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection returns false, the preflight is read-only and available on every replica.
+func (q *QuotaPreflighter) NeedLeaderElection() bool {
+	return false
+}
+
+// generate builds a preflight, exports it and stores it as the latest preflight.
+func (q *QuotaPreflighter) generate(ctx context.Context) {
+	preflight, err := q.Reconciler.BuildQuotaPreflight(ctx)
+	if err != nil {
+		klog.Errorf("Failed to build quota preflight: %v", err)
+		return
+	}
+
+	exceeded := map[string]int{}
+	for _, headroom := range preflight.MachineDeployments {
+		if headroom.ExceedsQuota {
+			exceeded[headroom.Region+"/"+headroom.QuotaCode]++
+			klog.V(2).Infof("Scaling up MachineDeployment %s would exceed the vCPU quota %s of region %s", headroom.MachineDeployment, headroom.QuotaCode, headroom.Region)
+		}
+	}
+	metrics.ResetVCPUQuotas()
+	for _, quota := range preflight.Quotas {
+		metrics.SetVCPUQuota(quota.Region, quota.QuotaCode, quota.Limit, quota.Usage, exceeded[quota.Region+"/"+quota.QuotaCode])
+	}
+
+	data, err := json.MarshalIndent(preflight, "", "  ")
+	if err != nil {
+		klog.Errorf("Failed to encode quota preflight: %v", err)
+		return
+	}
+
+	q.rwmutex.Lock()
+	defer q.rwmutex.Unlock()
+	q.latest = data
+	klog.V(2).Infof("Built quota preflight of %d MachineDeployments", len(preflight.MachineDeployments))
+}
+
+// ServeHTTP serves the latest quota preflight as JSON.
+func (q *QuotaPreflighter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	q.rwmutex.RLock()
+	defer q.rwmutex.RUnlock()
+
+	if q.latest == nil {
+		http.Error(w, "quota preflight not available yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(q.latest)
+}
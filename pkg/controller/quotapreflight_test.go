@@ -0,0 +1,138 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestVCPUQuotaCode(t *testing.T) {
+	testCases := []struct {
+		instanceType string
+		expectedCode string
+	}{
+		{instanceType: "m5.large", expectedCode: "L-1216C47A"},
+		{instanceType: "a1.2xlarge", expectedCode: "L-1216C47A"},
+		{instanceType: "im4gn.large", expectedCode: "L-1216C47A"},
+		{instanceType: "g4dn.xlarge", expectedCode: "L-DB2E81BA"},
+		{instanceType: "p4d.24xlarge", expectedCode: "L-417A185B"},
+		{instanceType: "inf2.xlarge", expectedCode: "L-1945791B"},
+		{instanceType: "trn1.2xlarge", expectedCode: "L-2C3B7624"},
+		{instanceType: "dl1.24xlarge", expectedCode: "L-6E869C2A"},
+		{instanceType: "u-6tb1.metal", expectedCode: "L-43DA4232"},
+		{instanceType: "mac2.metal"},
+		{instanceType: "invalid"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.instanceType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			code, ok := vcpuQuotaCode(tc.instanceType)
+			g.Expect(ok).To(Equal(tc.expectedCode != ""))
+			g.Expect(code).To(Equal(tc.expectedCode))
+		})
+	}
+}
+
+// runningInstancesAWSClient is the fake AWS client with running instances.
+type runningInstancesAWSClient struct {
+	awsclient.Client
+	instances []*ec2.Instance
+}
+
+func (c *runningInstancesAWSClient) DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: c.instances}}}, nil
+}
+
+func TestBuildQuotaPreflight(t *testing.T) {
+	testCases := []struct {
+		name           string
+		instances      []*ec2.Instance
+		spot           bool
+		expectedUsage  int64
+		expectExceeded bool
+		expectSkipped  bool
+	}{
+		{
+			name: "headroom for another replica",
+			instances: []*ec2.Instance{
+				{InstanceType: aws.String("m5.4xlarge"), CpuOptions: &ec2.CpuOptions{CoreCount: aws.Int64(8), ThreadsPerCore: aws.Int64(2)}},
+				// Other quotas and Spot instances do not count
+				{InstanceType: aws.String("p3.2xlarge"), CpuOptions: &ec2.CpuOptions{CoreCount: aws.Int64(4), ThreadsPerCore: aws.Int64(2)}},
+				{InstanceType: aws.String("m5.4xlarge"), InstanceLifecycle: aws.String(ec2.InstanceLifecycleTypeSpot), CpuOptions: &ec2.CpuOptions{CoreCount: aws.Int64(8), ThreadsPerCore: aws.Int64(2)}},
+			},
+			expectedUsage: 16,
+		},
+		{
+			name: "quota exceeded by another replica",
+			instances: []*ec2.Instance{
+				{InstanceType: aws.String("m5.4xlarge"), CpuOptions: &ec2.CpuOptions{CoreCount: aws.Int64(8), ThreadsPerCore: aws.Int64(2)}},
+				{InstanceType: aws.String("c5.2xlarge"), CpuOptions: &ec2.CpuOptions{CoreCount: aws.Int64(4), ThreadsPerCore: aws.Int64(2)}},
+				{InstanceType: aws.String("t3.micro"), CpuOptions: &ec2.CpuOptions{CoreCount: aws.Int64(1), ThreadsPerCore: aws.Int64(1)}},
+			},
+			expectedUsage:  25,
+			expectExceeded: true,
+		},
+		{
+			name:          "spot instances are not checked",
+			spot:          true,
+			expectSkipped: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("quota", "a1.2xlarge", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Name = "workers"
+			if tc.spot {
+				awsMachineTemplate.Spec.Template.Spec.SpotMarketOptions = &infrav1.SpotMarketOptions{}
+			}
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+			r.AwsClientBuilder = func(client.Client, string, string, string, awsclient.RegionCache) (awsclient.Client, error) {
+				fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+				return &runningInstancesAWSClient{Client: fakeAWSClient, instances: tc.instances}, err
+			}
+
+			preflight, err := r.BuildQuotaPreflight(ctx)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(preflight.Unresolved).To(BeEmpty())
+			if tc.expectSkipped {
+				g.Expect(preflight.MachineDeployments).To(BeEmpty())
+				g.Expect(preflight.Quotas).To(BeEmpty())
+				return
+			}
+
+			g.Expect(preflight.Quotas).To(HaveLen(1))
+			g.Expect(preflight.Quotas[0].QuotaCode).To(Equal("L-1216C47A"))
+			g.Expect(preflight.Quotas[0].Limit).To(BeEquivalentTo(fakeawsclient.VCPUQuota))
+			g.Expect(preflight.Quotas[0].Usage).To(Equal(tc.expectedUsage))
+			g.Expect(preflight.MachineDeployments).To(HaveLen(1))
+			g.Expect(preflight.MachineDeployments[0].MachineDeployment).To(Equal("quota/workers"))
+			g.Expect(preflight.MachineDeployments[0].VCPU).To(BeEquivalentTo(8))
+			g.Expect(preflight.MachineDeployments[0].ExceedsQuota).To(Equal(tc.expectExceeded))
+		})
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/version"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// fleetReannotator reannotates the MachineDeployments annotated by another version of the controller after an
+// upgrade. The MachineDeployments are enqueued one per interval, so that new annotation features roll out at a
+// predictable, bounded rate instead of with the next resyncs. It runs on the leader only.
+type fleetReannotator struct {
+	client   client.Client
+	interval time.Duration
+	events   chan<- event.GenericEvent
+}
+
+// Start enqueues the outdated MachineDeployments and returns once all are enqueued.
+// It implements the controller-runtime Runnable interface.
+func (f *fleetReannotator) Start(ctx context.Context) error {
+	outdated, err := f.outdatedMachineDeployments(ctx)
+	if err != nil {
+		// The MachineDeployments are still reannotated with the next resyncs
+		klog.Errorf("Failed to list MachineDeployments for reannotation: %v", err)
+		return nil
+	}
+	if len(outdated) == 0 {
+		return nil
+	}
+	klog.Infof("Reannotating %d MachineDeployments annotated by another controller version, one every %v", len(outdated), f.interval)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for i, machineDeployment := range outdated {
+		select {
+		case <-ctx.Done():
+			return nil
+		case f.events <- event.GenericEvent{Object: machineDeployment}:
+		}
+		klog.V(3).Infof("Enqueued MachineDeployment %s/%s for reannotation (%d/%d)", machineDeployment.Namespace, machineDeployment.Name, i+1, len(outdated))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+	klog.Infof("Enqueued all %d MachineDeployments for reannotation", len(outdated))
+	return nil
+}
+
+// outdatedMachineDeployments returns the MachineDeployments whose provenance records another controller version.
+// MachineDeployments without provenance have not been annotated yet and are left to the regular reconciles.
+func (f *fleetReannotator) outdatedMachineDeployments(ctx context.Context) ([]*clusterv1.MachineDeployment, error) {
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := f.client.List(ctx, machineDeployments); err != nil {
+		return nil, fmt.Errorf("failed to list MachineDeployments: %w", err)
+	}
+
+	outdated := []*clusterv1.MachineDeployment{}
+	for i := range machineDeployments.Items {
+		machineDeployment := &machineDeployments.Items[i]
+		value, ok := machineDeployment.Annotations[provenanceKey]
+		if !ok || !machineDeployment.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if provenance, err := ParseProvenance(value); err != nil || provenance.Version != version.Version {
+			outdated = append(outdated, machineDeployment)
+		}
+	}
+	return outdated, nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	"github.com/jhjaggars/capa-annotator/pkg/version"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestFleetReannotator(t *testing.T) {
+	g := NewWithT(t)
+
+	current := Provenance{Source: DataSourceAPI, Region: "us-east-1", Version: version.Version}.String()
+	previous := Provenance{Source: DataSourceAPI, Region: "us-east-1", Version: "v0.0.1"}.String()
+
+	objs := []client.Object{}
+	for name, annotations := range map[string]map[string]string{
+		"current":     {provenanceKey: current},
+		"previous":    {provenanceKey: previous},
+		"unparsable":  {provenanceKey: "invalid"},
+		"unannotated": nil,
+	} {
+		machineDeployment, _, _, _, err := newTestMachineDeployment("default", "a1.2xlarge", annotations)
+		g.Expect(err).ToNot(HaveOccurred())
+		machineDeployment.Name = name
+		objs = append(objs, machineDeployment)
+	}
+	r := newTestReconciler(g, objs...)
+
+	events := make(chan event.GenericEvent)
+	reannotator := &fleetReannotator{client: r.Client, interval: 50 * time.Millisecond, events: events}
+	done := make(chan error)
+	go func() {
+		done <- reannotator.Start(context.Background())
+	}()
+
+	// Only MachineDeployments annotated by another version are enqueued, one per interval
+	start := time.Now()
+	enqueued := []string{}
+	for i := 0; i < 2; i++ {
+		var e event.GenericEvent
+		g.Eventually(events, 5*time.Second).Should(Receive(&e))
+		enqueued = append(enqueued, e.Object.GetName())
+	}
+	g.Expect(enqueued).To(ConsistOf("previous", "unparsable"))
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+	g.Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+
+	// The reannotator stops when the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g.Expect(reannotator.Start(ctx)).To(Succeed())
+}
+
+func TestSetProvenanceAfterUpgrade(t *testing.T) {
+	g := NewWithT(t)
+
+	fetchedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	annotations := map[string]string{
+		provenanceKey: Provenance{Source: DataSourceAPI, Region: "us-east-1", FetchedAt: fetchedAt, Version: "v0.0.1"}.String(),
+	}
+
+	// The version is updated even if the capacity values did not change
+	setProvenance(annotations, InstanceType{Source: DataSourceCache, FetchedAt: fetchedAt}, "us-east-1", false)
+	provenance, err := ParseProvenance(annotations[provenanceKey])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(provenance.Version).To(Equal(version.Version))
+
+	// Afterwards the provenance is kept
+	setProvenance(annotations, InstanceType{Source: DataSourceAPI, FetchedAt: time.Now()}, "us-east-1", false)
+	provenance, err = ParseProvenance(annotations[provenanceKey])
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(provenance.Source).To(Equal(DataSourceCache))
+	g.Expect(provenance.FetchedAt).To(Equal(fetchedAt))
+}
+
+func TestPeriodicReannotation(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "periodic"
+	unknown, _, _, _, err := newTestMachineDeployment("default", "invalid", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	unknown.Name = "periodic-unknown"
+	unknown.Spec.Template.Spec.InfrastructureRef.Name = "missing"
+
+	r := newTestReconciler(g, machineDeployment, unknown, awsMachineTemplate, cluster, awsCluster)
+	r.PeriodicReannotationInterval = 24 * time.Hour
+	request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+
+	// Annotated MachineDeployments are requeued with a jitter
+	result, err := r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically(">=", 24*time.Hour))
+	g.Expect(result.RequeueAfter).To(BeNumerically("<=", 24*time.Hour+24*time.Hour/10))
+
+	// Failed reconciles are not
+	result, _ = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(unknown)})
+	g.Expect(result.RequeueAfter).To(BeZero())
+
+	// Lookups of data older than the interval are not skipped
+	r.SkipUnchangedLookups = true
+	r.PeriodicReannotationInterval = time.Nanosecond
+	builder := r.AwsClientBuilder
+	clients := 0
+	r.AwsClientBuilder = func(c client.Client, secretName, namespace, region string, regionCache awsclient.RegionCache) (awsclient.Client, error) {
+		clients++
+		return builder(c, secretName, namespace, region, regionCache)
+	}
+	for range 2 {
+		_, err = r.Reconcile(ctx, request)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(clients).To(Equal(2))
+}
@@ -0,0 +1,108 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// instanceTypesRefresherCache is implemented by instance types caches whose regions can be refreshed ahead of
+// their expiry.
+type instanceTypesRefresherCache interface {
+	instanceTypesReplacer
+	// expiring returns the cache IDs of the regions expiring within the duration, or already expired.
+	expiring(within time.Duration) []string
+}
+
+// expiring returns the cache IDs of the regions expiring within the duration, or already expired.
+func (i *instanceTypesCache) expiring(within time.Duration) []string {
+	i.rwmutex.RLock()
+	defer i.rwmutex.RUnlock()
+
+	expiring := []string{}
+	for cacheID, region := range i.cache {
+		if time.Since(region.lastUpdate) >= i.ttl-within {
+			expiring = append(expiring, cacheID)
+		}
+	}
+	sort.Strings(expiring)
+	return expiring
+}
+
+// expiring returns the regions of the wrapped cache expiring within the duration.
+func (a *aliasedInstanceTypesCache) expiring(within time.Duration) []string {
+	if cache, ok := a.cache.(instanceTypesRefresherCache); ok {
+		return cache.expiring(within)
+	}
+	return nil
+}
+
+// instanceTypesRefresher refreshes the cached instance types of regions before they expire, so that reconciles
+// do not wait for the EC2 API on a cache miss and newly launched instance types become available in the
+// background. Regions are fetched with the controller's own credentials, the regions cached for other identities
+// are refreshed by their next lookup once expired. It runs on the leader only.
+type instanceTypesRefresher struct {
+	reconciler *Reconciler
+	// ahead is the duration before their expiry at which regions are refreshed.
+	ahead time.Duration
+}
+
+// Start refreshes the expiring regions until the context is done. Regions are checked twice per ahead, so that
+// each region is refreshed before it expires.
+// It implements the controller-runtime Runnable interface.
+func (w *instanceTypesRefresher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(w.ahead / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.refresh()
+		}
+	}
+}
+
+// refresh fetches the instance types of the regions expiring within ahead and replaces them in the cache.
+// Regions failing to refresh are retried on the next tick, or refreshed by the next lookup once expired.
+func (w *instanceTypesRefresher) refresh() {
+	r := w.reconciler
+	cache, ok := r.InstanceTypesCache.(instanceTypesRefresherCache)
+	if !ok {
+		return
+	}
+
+	for _, region := range cache.expiring(w.ahead) {
+		if cacheRegion(region) != region {
+			continue
+		}
+		awsClient, err := r.AwsClientBuilder(r.Client, "", "", region, r.RegionCache)
+		if err != nil {
+			klog.Errorf("Failed to create AWS client to refresh the instance types of region %s: %v", region, err)
+			continue
+		}
+		instanceTypes, err := fetchEC2InstanceTypes(awsClient)
+		if err != nil {
+			klog.Errorf("Failed to refresh the instance types of region %s: %v", region, err)
+			continue
+		}
+		cache.replace(region, instanceTypes)
+		klog.V(2).Infof("Refreshed %d instance types of region %s ahead of their expiry", len(instanceTypes), region)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestInstanceTypesRefresher(t *testing.T) {
+	g := NewWithT(t)
+
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	requests := map[string]int{}
+
+	cache := NewInstanceTypesCacheWithTTL(time.Hour).(*instanceTypesCache)
+	r := &Reconciler{
+		InstanceTypesCache: NewAliasedInstanceTypesCache(cache, nil),
+		AwsClientBuilder: func(_ client.Client, _, _, region string, _ awsclient.RegionCache) (awsclient.Client, error) {
+			return &pagingInstanceTypesClient{Client: fakeAWSClient, region: region, requests: requests}, nil
+		},
+	}
+	refresher := &instanceTypesRefresher{reconciler: r, ahead: 10 * time.Minute}
+
+	_, err = r.InstanceTypesCache.GetInstanceType(&pagingInstanceTypesClient{Client: fakeAWSClient, region: "us-east-1", requests: requests}, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = r.InstanceTypesCache.GetInstanceType(&pagingInstanceTypesClient{Client: fakeAWSClient, region: "eu-west-1", requests: requests}, "eu-west-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	fetched := map[string]int{"us-east-1": requests["us-east-1"], "eu-west-1": requests["eu-west-1"]}
+
+	// Fresh regions are not refreshed
+	refresher.refresh()
+	g.Expect(requests).To(Equal(fetched))
+
+	// Regions expiring within ahead are refreshed, others are not
+	region := cache.cache["us-east-1"]
+	region.lastUpdate = time.Now().Add(-55 * time.Minute)
+	cache.cache["us-east-1"] = region
+	g.Expect(cache.expiring(10 * time.Minute)).To(Equal([]string{"us-east-1"}))
+
+	refresher.refresh()
+	g.Expect(requests["us-east-1"]).To(Equal(2 * fetched["us-east-1"]))
+	g.Expect(requests["eu-west-1"]).To(Equal(fetched["eu-west-1"]))
+	g.Expect(cache.cache["us-east-1"].lastUpdate).To(BeTemporally("~", time.Now(), time.Minute))
+
+	// Lookups are served from the refreshed cache
+	_, err = r.InstanceTypesCache.GetInstanceType(&pagingInstanceTypesClient{Client: fakeAWSClient, region: "us-east-1", requests: requests}, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(requests["us-east-1"]).To(Equal(2 * fetched["us-east-1"]))
+	g.Expect(cache.expiring(10 * time.Minute)).To(BeEmpty())
+}
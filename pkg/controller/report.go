@@ -0,0 +1,226 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/utils"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// CapacityReport lists every instance type in use, its AWS-reported capacity and the MachineDeployments using it.
+type CapacityReport struct {
+	GeneratedAt   time.Time                     `json:"generatedAt"`
+	InstanceTypes []InstanceTypeUsage           `json:"instanceTypes"`
+	Unresolved    []UnresolvedMachineDeployment `json:"unresolved,omitempty"`
+}
+
+// InstanceTypeUsage is the capacity of an instance type in a region and the MachineDeployments using it.
+type InstanceTypeUsage struct {
+	Region             string   `json:"region"`
+	InstanceType       string   `json:"instanceType"`
+	VCPU               int64    `json:"vcpu"`
+	MemoryMb           int64    `json:"memoryMb"`
+	GPU                int64    `json:"gpu"`
+	CPUArchitecture    string   `json:"cpuArchitecture"`
+	MachineDeployments []string `json:"machineDeployments"`
+}
+
+// UnresolvedMachineDeployment is a MachineDeployment whose instance type could not be determined.
+type UnresolvedMachineDeployment struct {
+	MachineDeployment string `json:"machineDeployment"`
+	Error             string `json:"error"`
+}
+
+// BuildCapacityReport builds a capacity report of all MachineDeployments visible to the reconciler.
+// Instance types are looked up once per region, so the EC2 API is queried with one batched
+// DescribeInstanceTypes listing per region instead of once per MachineDeployment.
+func (r *Reconciler) BuildCapacityReport(ctx context.Context) (*CapacityReport, error) {
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := r.Client.List(ctx, machineDeployments); err != nil {
+		return nil, fmt.Errorf("failed to list MachineDeployments: %w", err)
+	}
+
+	report := &CapacityReport{
+		GeneratedAt:   time.Now().UTC(),
+		InstanceTypes: []InstanceTypeUsage{},
+	}
+
+	// region -> instance type -> MachineDeployments
+	usage := map[string]map[string][]string{}
+	for i := range machineDeployments.Items {
+		machineDeployment := &machineDeployments.Items[i]
+		name := machineDeployment.Namespace + "/" + machineDeployment.Name
+
+		region, instanceType, err := r.resolveInstanceType(ctx, machineDeployment)
+		if err != nil {
+			report.Unresolved = append(report.Unresolved, UnresolvedMachineDeployment{MachineDeployment: name, Error: err.Error()})
+			continue
+		}
+
+		if usage[region] == nil {
+			usage[region] = map[string][]string{}
+		}
+		usage[region][instanceType] = append(usage[region][instanceType], name)
+	}
+
+	for region, instanceTypes := range usage {
+		awsClient, err := r.AwsClientBuilder(r.Client, "", "", region, r.RegionCache)
+		if err != nil {
+			// The other regions are still reported
+			for _, names := range instanceTypes {
+				for _, name := range names {
+					report.Unresolved = append(report.Unresolved, UnresolvedMachineDeployment{
+						MachineDeployment: name,
+						Error:             fmt.Sprintf("error creating aws client for region %s: %v", region, err),
+					})
+				}
+			}
+			continue
+		}
+
+		for instanceType, names := range instanceTypes {
+			sort.Strings(names)
+
+			instanceTypeInfo, err := r.InstanceTypesCache.GetInstanceType(awsClient, region, instanceType)
+			if err != nil {
+				for _, name := range names {
+					report.Unresolved = append(report.Unresolved, UnresolvedMachineDeployment{MachineDeployment: name, Error: err.Error()})
+				}
+				continue
+			}
+
+			report.InstanceTypes = append(report.InstanceTypes, InstanceTypeUsage{
+				Region:             region,
+				InstanceType:       instanceType,
+				VCPU:               instanceTypeInfo.VCPU,
+				MemoryMb:           instanceTypeInfo.MemoryMb,
+				GPU:                instanceTypeInfo.GPU,
+				CPUArchitecture:    string(instanceTypeInfo.CPUArchitecture),
+				MachineDeployments: names,
+			})
+		}
+	}
+
+	sort.Slice(report.InstanceTypes, func(i, j int) bool {
+		if report.InstanceTypes[i].Region != report.InstanceTypes[j].Region {
+			return report.InstanceTypes[i].Region < report.InstanceTypes[j].Region
+		}
+		return report.InstanceTypes[i].InstanceType < report.InstanceTypes[j].InstanceType
+	})
+	sort.Slice(report.Unresolved, func(i, j int) bool {
+		return report.Unresolved[i].MachineDeployment < report.Unresolved[j].MachineDeployment
+	})
+	return report, nil
+}
+
+// resolveInstanceType returns the region and instance type of the MachineDeployment.
+func (r *Reconciler) resolveInstanceType(ctx context.Context, machineDeployment *clusterv1.MachineDeployment) (string, string, error) {
+	if err := r.checkTemplateNamespace(machineDeployment); err != nil {
+		return "", "", err
+	}
+
+	awsMachineTemplate, err := r.templateResolver().ResolveAWSMachineTemplate(ctx, r.Client, machineDeployment)
+	if err != nil {
+		return "", "", err
+	}
+
+	instanceType, err := utils.ExtractInstanceType(awsMachineTemplate)
+	if err != nil {
+		return "", "", err
+	}
+
+	region, err := r.regionResolver().ResolveRegion(ctx, r.Client, machineDeployment)
+	if err != nil {
+		return "", "", err
+	}
+	return region, instanceType, nil
+}
+
+// CapacityReporter periodically builds the capacity report and serves the latest one as JSON.
+// Access to the latest report is synchronized via rwmutex.
+type CapacityReporter struct {
+	// Reconciler is used to resolve the MachineDeployments and instance types.
+	Reconciler *Reconciler
+	// Interval is the interval between two reports.
+	Interval time.Duration
+
+	latest  []byte
+	rwmutex sync.RWMutex
+}
+
+// Start builds a report immediately and then once per interval until the context is cancelled.
+// It implements the controller-runtime Runnable interface.
+func (c *CapacityReporter) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.generate(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection returns false, the report is read-only and available on every replica.
+func (c *CapacityReporter) NeedLeaderElection() bool {
+	return false
+}
+
+// generate builds a report and stores it as the latest report.
+func (c *CapacityReporter) generate(ctx context.Context) {
+	report, err := c.Reconciler.BuildCapacityReport(ctx)
+	if err != nil {
+		klog.Errorf("Failed to build capacity report: %v", err)
+		return
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		klog.Errorf("Failed to encode capacity report: %v", err)
+		return
+	}
+
+	c.rwmutex.Lock()
+	defer c.rwmutex.Unlock()
+	c.latest = data
+	klog.V(2).Infof("Built capacity report with %d instance types", len(report.InstanceTypes))
+}
+
+// ServeHTTP serves the latest capacity report as JSON.
+func (c *CapacityReporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	c.rwmutex.RLock()
+	defer c.rwmutex.RUnlock()
+
+	if c.latest == nil {
+		http.Error(w, "capacity report not available yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="capacity-report.json"`)
+	_, _ = w.Write(c.latest)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	fakeawsclient "github.com/jhjaggars/capa-annotator/pkg/client/fake"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestBuildCapacityReport(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "workers"
+
+	secondMachineDeployment := machineDeployment.DeepCopy()
+	secondMachineDeployment.Name = "more-workers"
+
+	brokenMachineDeployment := machineDeployment.DeepCopy()
+	brokenMachineDeployment.Name = "broken"
+	brokenMachineDeployment.Spec.Template.Spec.InfrastructureRef.Name = "missing"
+
+	r := newTestReconciler(g, machineDeployment, secondMachineDeployment, brokenMachineDeployment, awsMachineTemplate, cluster, awsCluster)
+
+	report, err := r.BuildCapacityReport(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(report.InstanceTypes).To(Equal([]InstanceTypeUsage{
+		{
+			Region:             "us-east-1",
+			InstanceType:       "a1.2xlarge",
+			VCPU:               8,
+			MemoryMb:           16384,
+			GPU:                0,
+			CPUArchitecture:    "amd64",
+			MachineDeployments: []string{"default/more-workers", "default/workers"},
+		},
+	}))
+	g.Expect(report.Unresolved).To(HaveLen(1))
+	g.Expect(report.Unresolved[0].MachineDeployment).To(Equal("default/broken"))
+
+	// The report is only served once it was built
+	reporter := &CapacityReporter{Reconciler: r}
+	recorder := httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/capacity-report", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+
+	reporter.generate(ctx)
+	recorder = httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/capacity-report", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	g.Expect(recorder.Body.String()).To(ContainSubstring(`"instanceType": "a1.2xlarge"`))
+}
+
+func TestBuildCapacityReportWithFailingRegion(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "workers"
+
+	euMachineDeployment, euAWSMachineTemplate, euCluster, euAWSCluster, err := newTestMachineDeployment("eu", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	euMachineDeployment.Name = "workers"
+	euAWSCluster.Spec.Region = "eu-west-1"
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster,
+		euMachineDeployment, euAWSMachineTemplate, euCluster, euAWSCluster)
+	fakeAWSClient, err := fakeawsclient.NewClient(nil, "", "", "")
+	g.Expect(err).ToNot(HaveOccurred())
+	r.AwsClientBuilder = func(client client.Client, secretName, namespace, region string, regionCache awsclient.RegionCache) (awsclient.Client, error) {
+		if region == "eu-west-1" {
+			return nil, errors.New("no credentials")
+		}
+		return fakeAWSClient, nil
+	}
+
+	// A region without an AWS client must not hide the capacity of the other regions
+	report, err := r.BuildCapacityReport(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(report.InstanceTypes).To(HaveLen(1))
+	g.Expect(report.InstanceTypes[0].Region).To(Equal("us-east-1"))
+	g.Expect(report.InstanceTypes[0].MachineDeployments).To(Equal([]string{"default/workers"}))
+	g.Expect(report.Unresolved).To(Equal([]UnresolvedMachineDeployment{
+		{
+			MachineDeployment: "eu/workers",
+			Error:             "error creating aws client for region eu-west-1: no credentials",
+		},
+	}))
+}
@@ -0,0 +1,75 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	utils "github.com/jhjaggars/capa-annotator/pkg/utils"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TemplateResolver resolves the AWSMachineTemplate referenced by a MachineDeployment.
+// Implementations can be verified with the conformance subpackage.
+type TemplateResolver interface {
+	ResolveAWSMachineTemplate(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (*infrav1.AWSMachineTemplate, error)
+}
+
+// TemplateResolverFunc is a function implementing TemplateResolver.
+type TemplateResolverFunc func(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (*infrav1.AWSMachineTemplate, error)
+
+// ResolveAWSMachineTemplate calls the function.
+func (f TemplateResolverFunc) ResolveAWSMachineTemplate(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (*infrav1.AWSMachineTemplate, error) {
+	return f(ctx, c, machineDeployment)
+}
+
+// RegionResolver resolves the AWS region of a MachineDeployment.
+// Implementations can be verified with the conformance subpackage.
+type RegionResolver interface {
+	ResolveRegion(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (string, error)
+}
+
+// RegionResolverFunc is a function implementing RegionResolver.
+type RegionResolverFunc func(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (string, error)
+
+// ResolveRegion calls the function.
+func (f RegionResolverFunc) ResolveRegion(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (string, error) {
+	return f(ctx, c, machineDeployment)
+}
+
+var (
+	// DefaultTemplateResolver fetches the AWSMachineTemplate of the infrastructureRef of the MachineDeployment.
+	DefaultTemplateResolver TemplateResolver = TemplateResolverFunc(utils.ResolveAWSMachineTemplate)
+	// DefaultRegionResolver takes the region from the AWSCluster or, for EKS clusters, the AWSManagedControlPlane
+	// of the Cluster of the MachineDeployment, falling back to the region annotation of the MachineDeployment.
+	DefaultRegionResolver RegionResolver = RegionResolverFunc(utils.ResolveRegion)
+)
+
+// templateResolver returns the configured template resolver or the default one.
+func (r *Reconciler) templateResolver() TemplateResolver {
+	if r.TemplateResolver == nil {
+		return DefaultTemplateResolver
+	}
+	return r.TemplateResolver
+}
+
+// regionResolver returns the configured region resolver or the default one.
+func (r *Reconciler) regionResolver() RegionResolver {
+	if r.RegionResolver == nil {
+		return DefaultRegionResolver
+	}
+	return r.RegionResolver
+}
@@ -0,0 +1,163 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// maxGaveUpCauses is the number of distinct error causes listed in a GaveUp event.
+const maxGaveUpCauses = 3
+
+// RetryBudget is the number of failed reconciles of a MachineDeployment per time window after which the
+// controller gives up retrying it right away. Instead of a steady drip of identical ReconcileError events,
+// a single GaveUp event aggregating the error causes is emitted and the MachineDeployment is retried after
+// a long backoff.
+type RetryBudget struct {
+	// Attempts is the number of failed reconciles within Window after which the controller gives up.
+	// Zero disables the budget.
+	Attempts int
+	// Window is the duration in which the failed reconciles are counted.
+	Window time.Duration
+	// Backoff is the interval at which MachineDeployments are retried after giving up.
+	Backoff time.Duration
+}
+
+// enabled returns true if the budget is limited.
+func (b RetryBudget) enabled() bool {
+	return b.Attempts > 0 && b.Window > 0 && b.Backoff > 0
+}
+
+// retryState holds the failed reconciles of a MachineDeployment.
+type retryState struct {
+	generation int64
+	failures   []time.Time
+	causes     map[string]int
+	// retryAt is the time the MachineDeployment is retried after giving up, zero if the budget is not exhausted.
+	retryAt time.Time
+}
+
+// retryTracker tracks the failed reconciles of MachineDeployments against the retry budget. Access is
+// synchronized via mutex.
+type retryTracker struct {
+	budget RetryBudget
+	states map[types.NamespacedName]*retryState
+	mutex  sync.Mutex
+	now    func() time.Time
+}
+
+func newRetryTracker(budget RetryBudget) *retryTracker {
+	return &retryTracker{
+		budget: budget,
+		states: map[types.NamespacedName]*retryState{},
+		now:    time.Now,
+	}
+}
+
+// backingOff returns true with the time until the next retry if the controller gave up on the MachineDeployment
+// and the backoff has not passed. A new generation of the MachineDeployment, e.g. after fixing its spec,
+// renews the budget.
+func (t *retryTracker) backingOff(key types.NamespacedName, generation int64) (bool, time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	state, ok := t.states[key]
+	if !ok {
+		return false, 0
+	}
+	if state.generation != generation {
+		delete(t.states, key)
+		return false, 0
+	}
+	if wait := state.retryAt.Sub(t.now()); !state.retryAt.IsZero() && wait > 0 {
+		return true, wait
+	}
+	return false, 0
+}
+
+// failed records a failed reconcile of the MachineDeployment. If the budget is exhausted, true is returned
+// together with the aggregated error causes of the failures since the budget was last renewed.
+func (t *retryTracker) failed(key types.NamespacedName, generation int64, err error) (bool, string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	state, ok := t.states[key]
+	if !ok || state.generation != generation {
+		state = &retryState{generation: generation, causes: map[string]int{}}
+		t.states[key] = state
+	}
+
+	failures := state.failures[:0]
+	for _, failure := range state.failures {
+		if now.Sub(failure) < t.budget.Window {
+			failures = append(failures, failure)
+		}
+	}
+	state.failures = append(failures, now)
+	state.causes[err.Error()]++
+
+	// After the backoff a single attempt is made, a failure gives up again right away
+	if len(state.failures) < t.budget.Attempts && state.retryAt.IsZero() {
+		return false, ""
+	}
+	causes := summarizeCauses(state.causes)
+	state.retryAt = now.Add(t.budget.Backoff)
+	state.failures = nil
+	state.causes = map[string]int{}
+	return true, causes
+}
+
+// succeeded renews the budget of the MachineDeployment.
+func (t *retryTracker) succeeded(key types.NamespacedName) {
+	t.forget(key)
+}
+
+// forget removes the MachineDeployment from the tracker.
+func (t *retryTracker) forget(key types.NamespacedName) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.states, key)
+}
+
+// summarizeCauses lists the most frequent error causes with their number of occurrences.
+func summarizeCauses(causes map[string]int) string {
+	messages := make([]string, 0, len(causes))
+	for message := range causes {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if causes[messages[i]] != causes[messages[j]] {
+			return causes[messages[i]] > causes[messages[j]]
+		}
+		return messages[i] < messages[j]
+	})
+
+	summary := []string{}
+	for i, message := range messages {
+		if i == maxGaveUpCauses {
+			summary = append(summary, fmt.Sprintf("and %d other cause(s)", len(messages)-maxGaveUpCauses))
+			break
+		}
+		summary = append(summary, fmt.Sprintf("%dx %s", causes[message], message))
+	}
+	return strings.Join(summary, "; ")
+}
@@ -0,0 +1,114 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestRetryTracker(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := newRetryTracker(RetryBudget{Attempts: 3, Window: time.Hour, Backoff: 6 * time.Hour})
+	tracker.now = func() time.Time { return now }
+	key := types.NamespacedName{Namespace: "default", Name: "workers"}
+
+	gaveUp, _ := tracker.failed(key, 1, errors.New("throttled"))
+	g.Expect(gaveUp).To(BeFalse())
+
+	// Failures outside of the window are not counted
+	now = now.Add(2 * time.Hour)
+	gaveUp, _ = tracker.failed(key, 1, errors.New("throttled"))
+	g.Expect(gaveUp).To(BeFalse())
+	gaveUp, _ = tracker.failed(key, 1, errors.New("access denied"))
+	g.Expect(gaveUp).To(BeFalse())
+	gaveUp, causes := tracker.failed(key, 1, errors.New("throttled"))
+	g.Expect(gaveUp).To(BeTrue())
+	g.Expect(causes).To(Equal("3x throttled; 1x access denied"))
+
+	backingOff, wait := tracker.backingOff(key, 1)
+	g.Expect(backingOff).To(BeTrue())
+	g.Expect(wait).To(Equal(6 * time.Hour))
+
+	// After the backoff, a single failure gives up again
+	now = now.Add(6 * time.Hour)
+	backingOff, _ = tracker.backingOff(key, 1)
+	g.Expect(backingOff).To(BeFalse())
+	gaveUp, causes = tracker.failed(key, 1, errors.New("access denied"))
+	g.Expect(gaveUp).To(BeTrue())
+	g.Expect(causes).To(Equal("1x access denied"))
+
+	// A new generation renews the budget
+	backingOff, _ = tracker.backingOff(key, 2)
+	g.Expect(backingOff).To(BeFalse())
+	gaveUp, _ = tracker.failed(key, 2, errors.New("access denied"))
+	g.Expect(gaveUp).To(BeFalse())
+
+	// So does a successful reconcile
+	tracker.failed(key, 2, errors.New("access denied"))
+	tracker.succeeded(key)
+	gaveUp, _ = tracker.failed(key, 2, errors.New("access denied"))
+	g.Expect(gaveUp).To(BeFalse())
+}
+
+func TestSummarizeCauses(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(summarizeCauses(map[string]int{"a": 1, "b": 4, "c": 2, "d": 1, "e": 1})).To(Equal("4x b; 2x c; 1x a; and 2 other cause(s)"))
+}
+
+func TestReconcileWithRetryBudget(t *testing.T) {
+	g := NewWithT(t)
+
+	// The AWSMachineTemplate is missing, so every reconcile fails
+	machineDeployment, _, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "workers"
+
+	r := newTestReconciler(g, machineDeployment, cluster, awsCluster)
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	r.RetryBudget = RetryBudget{Attempts: 2, Window: time.Hour, Backoff: 6 * time.Hour}
+	r.retries = newRetryTracker(r.RetryBudget)
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+
+	_, err = r.Reconcile(ctx, req)
+	g.Expect(err).To(HaveOccurred())
+
+	result, err := r.Reconcile(ctx, req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(6 * time.Hour))
+
+	events := []string{}
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	g.Expect(events).To(ContainElement(HavePrefix(corev1.EventTypeWarning + " GaveUp Giving up after repeated failures, retrying in 6h0m0s: 2x failed to fetch AWSMachineTemplate")))
+
+	// Reconciles within the backoff are skipped without further events
+	result, err = r.Reconcile(ctx, req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeNumerically(">", 5*time.Hour))
+	g.Expect(recorder.Events).To(BeEmpty())
+}
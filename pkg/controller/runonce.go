@@ -0,0 +1,182 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RunOnceSummary is the outcome of reconciling all MachineDeployments once.
+type RunOnceSummary struct {
+	// Annotated is the number of MachineDeployments annotated successfully.
+	Annotated int `json:"annotated"`
+	// Skipped is the number of MachineDeployments that were not reconciled, e.g. those of deleting Clusters.
+	Skipped int `json:"skipped"`
+	// Failed lists the MachineDeployments that could not be annotated.
+	Failed []RunOnceFailure `json:"failed,omitempty"`
+	// Changed lists the annotation changes of the MachineDeployments annotated successfully, if any.
+	Changed []MachineDeploymentChanges `json:"changed,omitempty"`
+}
+
+// MachineDeploymentChanges are the annotation changes of a MachineDeployment.
+type MachineDeploymentChanges struct {
+	Namespace string                      `json:"namespace"`
+	Name      string                      `json:"name"`
+	Changes   map[string]AnnotationChange `json:"changes"`
+}
+
+// RunOnceFailure is a MachineDeployment that could not be annotated.
+type RunOnceFailure struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Result is the result label of the reconcile metrics, e.g. failed or error.
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Print writes the summary in a human-readable form.
+func (s RunOnceSummary) Print(w io.Writer) {
+	fmt.Fprintf(w, "Annotated %d MachineDeployment(s), skipped %d, failed %d\n", s.Annotated, s.Skipped, len(s.Failed))
+	for _, failure := range s.Failed {
+		fmt.Fprintf(w, "  %s/%s: %s: %s\n", failure.Namespace, failure.Name, failure.Result, failure.Error)
+	}
+}
+
+// PrintDiff writes the annotation changes in the form of a unified diff, followed by the failures.
+func (s RunOnceSummary) PrintDiff(w io.Writer) {
+	for _, changed := range s.Changed {
+		fmt.Fprintf(w, "--- %s/%s\n+++ %s/%s\n", changed.Namespace, changed.Name, changed.Namespace, changed.Name)
+		keys := make([]string, 0, len(changed.Changes))
+		for key := range changed.Changes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			change := changed.Changes[key]
+			if change.Old != nil {
+				fmt.Fprintf(w, "-%s: %s\n", key, *change.Old)
+			}
+			if change.New != nil {
+				fmt.Fprintf(w, "+%s: %s\n", key, *change.New)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d MachineDeployment(s) would change, %d unchanged, skipped %d, failed %d\n",
+		len(s.Changed), s.Annotated-len(s.Changed), s.Skipped, len(s.Failed))
+	for _, failure := range s.Failed {
+		fmt.Fprintf(w, "  %s/%s: %s: %s\n", failure.Namespace, failure.Name, failure.Result, failure.Error)
+	}
+}
+
+// ReconcileAll reconciles all MachineDeployments in scope once, in sequence, and summarizes the outcomes.
+// Requeues are not followed, MachineDeployments that would be retried later count as failed.
+func (r *Reconciler) ReconcileAll(ctx context.Context) (RunOnceSummary, error) {
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := r.Client.List(ctx, machineDeployments); err != nil {
+		return RunOnceSummary{}, fmt.Errorf("failed to list MachineDeployments: %w", err)
+	}
+
+	summary := RunOnceSummary{}
+	r.observe = func(key types.NamespacedName, status *reconcileStatus) {
+		switch {
+		case status.result != metrics.ResultSuccess:
+			summary.Failed = append(summary.Failed, RunOnceFailure{Namespace: key.Namespace, Name: key.Name, Result: status.result, Error: status.message})
+		case status.reconciled:
+			summary.Annotated++
+			if len(status.changes) > 0 {
+				summary.Changed = append(summary.Changed, MachineDeploymentChanges{Namespace: key.Namespace, Name: key.Name, Changes: status.changes})
+			}
+		default:
+			summary.Skipped++
+		}
+	}
+	defer func() { r.observe = nil }()
+
+	for i := range machineDeployments.Items {
+		machineDeployment := &machineDeployments.Items[i]
+		if !machineDeployment.DeletionTimestamp.IsZero() || !r.inScope(machineDeployment) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		// Errors are recorded by the observer
+		_, _ = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+	}
+	return summary, nil
+}
+
+// RunOnce reconciles all MachineDeployments once as soon as the caches of the manager are synced and stops the
+// manager afterwards, so that the controller can run as a Job or CronJob instead of a long-lived Deployment. It
+// implements the controller-runtime Runnable interface.
+type RunOnce struct {
+	Reconciler *Reconciler
+	// Stop is called once all MachineDeployments are reconciled, e.g. to cancel the context of the manager.
+	Stop func()
+	// DryRun computes the annotation changes without writing anything: the patches are sent as server-side dry
+	// runs, and events and audit records are discarded.
+	DryRun bool
+
+	// Summary and Err are the outcome of the run, set before Stop is called.
+	Summary RunOnceSummary
+	Err     error
+}
+
+// SetupWithManager initializes the reconciler without starting its controller and adds the run to the manager.
+func (o *RunOnce) SetupWithManager(mgr ctrl.Manager) error {
+	if o.DryRun {
+		o.Reconciler.Client = client.NewDryRunClient(o.Reconciler.Client)
+		o.Reconciler.AuditSink = nil
+	}
+	o.Reconciler.setup(mgr)
+	if o.DryRun {
+		o.Reconciler.recorder = discardRecorder{}
+	}
+	if err := mgr.Add(o); err != nil {
+		return fmt.Errorf("failed adding the run: %w", err)
+	}
+	return nil
+}
+
+// discardRecorder is an event recorder discarding all events.
+type discardRecorder struct{}
+
+func (discardRecorder) Event(runtime.Object, string, string, string) {}
+
+func (discardRecorder) Eventf(runtime.Object, string, string, string, ...interface{}) {}
+
+func (discardRecorder) AnnotatedEventf(runtime.Object, map[string]string, string, string, string, ...interface{}) {
+}
+
+// Start reconciles all MachineDeployments and stops the manager.
+func (o *RunOnce) Start(ctx context.Context) error {
+	defer o.Stop()
+	o.Summary, o.Err = o.Reconciler.ReconcileAll(ctx)
+	return nil
+}
+
+// NeedLeaderElection returns false, the run does not wait for the leader election.
+func (o *RunOnce) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,89 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	. "github.com/onsi/gomega"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestReconcileAll(t *testing.T) {
+	g := NewWithT(t)
+
+	objs := []client.Object{}
+	for name, instanceType := range map[string]string{"workers": "a1.2xlarge", "unknown": "invalid", "disabled": "a1.2xlarge"} {
+		machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("run-once", instanceType, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		machineDeployment.Name = name
+		awsMachineTemplate.Name = name
+		machineDeployment.Spec.Template.Spec.InfrastructureRef.Name = name
+		cluster.Name = name
+		awsCluster.Name = name
+		machineDeployment.Spec.ClusterName = name
+		cluster.Spec.InfrastructureRef.Name = name
+		if name == "disabled" {
+			machineDeployment.Annotations = map[string]string{enabledKey: "false"}
+		}
+		objs = append(objs, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	}
+	r := newTestReconciler(g, objs...)
+
+	summary, err := r.ReconcileAll(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(summary.Annotated).To(Equal(1))
+	g.Expect(summary.Skipped).To(BeZero())
+	g.Expect(summary.Failed).To(HaveLen(1))
+	g.Expect(summary.Failed[0].Name).To(Equal("unknown"))
+	g.Expect(summary.Failed[0].Result).To(Equal(metrics.ResultFailed))
+	g.Expect(r.observe).To(BeNil())
+
+	out := &bytes.Buffer{}
+	summary.Print(out)
+	g.Expect(out.String()).To(HavePrefix("Annotated 1 MachineDeployment(s), skipped 0, failed 1\n  run-once/unknown: failed: unknown instance type invalid"))
+}
+
+func TestReconcileAllDryRun(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("dry-run", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "workers"
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.Client = client.NewDryRunClient(r.Client)
+	r.recorder = discardRecorder{}
+
+	summary, err := r.ReconcileAll(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(summary.Annotated).To(Equal(1))
+	g.Expect(summary.Changed).To(HaveLen(1))
+	g.Expect(summary.Changed[0].Name).To(Equal("workers"))
+	g.Expect(summary.Changed[0].Changes).To(HaveKey(cpuKey))
+	g.Expect(*summary.Changed[0].Changes[cpuKey].New).To(Equal("8"))
+
+	// Nothing is written
+	stored := &clusterv1.MachineDeployment{}
+	g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machineDeployment), stored)).To(Succeed())
+	g.Expect(stored.Annotations).ToNot(HaveKey(cpuKey))
+
+	out := &bytes.Buffer{}
+	summary.PrintDiff(out)
+	g.Expect(out.String()).To(HavePrefix("--- dry-run/workers\n+++ dry-run/workers\n"))
+	g.Expect(out.String()).To(ContainSubstring("\n+" + cpuKey + ": 8\n"))
+	g.Expect(out.String()).To(HaveSuffix("1 MachineDeployment(s) would change, 0 unchanged, skipped 0, failed 0\n"))
+}
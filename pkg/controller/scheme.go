@@ -0,0 +1,182 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Capacity annotation keys of the upstream cluster-autoscaler Cluster API provider.
+	caCPUKey      = "capacity.cluster-autoscaler.kubernetes.io/cpu"
+	caMemoryKey   = "capacity.cluster-autoscaler.kubernetes.io/memory"
+	caGPUCountKey = "capacity.cluster-autoscaler.kubernetes.io/gpu-count"
+	// caGPUTypeKey is the extended resource name of the GPUs, e.g. nvidia.com/gpu. It is only written for
+	// instance types with GPUs of a known manufacturer.
+	caGPUTypeKey = "capacity.cluster-autoscaler.kubernetes.io/gpu-type"
+
+	// migrationStartedKey records when the controller started writing both the old and the new annotation
+	// scheme, in RFC3339 format. The old keys are removed once the migration window has passed.
+	migrationStartedKey = "capa-annotator/migration-started"
+)
+
+// AnnotationScheme is the set of annotation keys the capacity is written to.
+type AnnotationScheme string
+
+const (
+	// AnnotationSchemeOpenShift writes the machine.openshift.io capacity annotations. This is the default.
+	AnnotationSchemeOpenShift AnnotationScheme = "openshift"
+	// AnnotationSchemeClusterAutoscaler writes the capacity.cluster-autoscaler.kubernetes.io capacity annotations.
+	AnnotationSchemeClusterAutoscaler AnnotationScheme = "cluster-autoscaler"
+)
+
+// ParseAnnotationScheme validates the given annotation scheme.
+func ParseAnnotationScheme(scheme string) (AnnotationScheme, error) {
+	switch AnnotationScheme(scheme) {
+	case AnnotationSchemeOpenShift, AnnotationSchemeClusterAutoscaler:
+		return AnnotationScheme(scheme), nil
+	}
+	return "", fmt.Errorf("unknown annotation scheme %q, must be one of %q", scheme, []AnnotationScheme{AnnotationSchemeOpenShift, AnnotationSchemeClusterAutoscaler})
+}
+
+// additionalScheme returns the scheme written in addition to the AnnotationScheme, or an empty scheme if none.
+func (r *Reconciler) additionalScheme() AnnotationScheme {
+	if r.AdditionalAnnotationScheme == "" || r.AdditionalAnnotationScheme.orDefault() == r.AnnotationScheme.orDefault() {
+		return ""
+	}
+	return r.AdditionalAnnotationScheme
+}
+
+// orDefault returns the scheme, or the OpenShift scheme if the scheme is empty.
+func (s AnnotationScheme) orDefault() AnnotationScheme {
+	if s == "" {
+		return AnnotationSchemeOpenShift
+	}
+	return s
+}
+
+// keys returns the cpu, memory and GPU annotation keys of the scheme. An empty scheme is the OpenShift scheme.
+func (s AnnotationScheme) keys() (string, string, string) {
+	if s == AnnotationSchemeClusterAutoscaler {
+		return caCPUKey, caMemoryKey, caGPUCountKey
+	}
+	return cpuKey, memoryKey, gpuKey
+}
+
+// gpuTypeKey returns the GPU type annotation key of the scheme, or an empty string if the scheme has none.
+func (s AnnotationScheme) gpuTypeKey() string {
+	if s == AnnotationSchemeClusterAutoscaler {
+		return caGPUTypeKey
+	}
+	return ""
+}
+
+// gpuResourceNames maps the GPU manufacturers reported by the EC2 API, in lower case, to the extended resource
+// names advertised by their device plugins.
+var gpuResourceNames = map[string]string{
+	"nvidia": "nvidia.com/gpu",
+	"amd":    "amd.com/gpu",
+	"habana": "habana.ai/gaudi",
+}
+
+// gpuResourceName returns the extended resource name of the GPUs of the manufacturer, or an empty string if unknown.
+func gpuResourceName(manufacturer string) string {
+	return gpuResourceNames[strings.ToLower(manufacturer)]
+}
+
+// schemeCapacity returns the capacity annotations of the instance type in the given scheme.
+// The GPU annotation is left out if OmitZeroGPU is set and the instance type has no GPUs.
+func (r *Reconciler) schemeCapacity(scheme AnnotationScheme, instanceTypeInfo InstanceType) map[string]string {
+	cpu, memory, gpu := scheme.keys()
+
+	memoryUnit := r.MemoryUnit
+	if scheme == AnnotationSchemeClusterAutoscaler && (memoryUnit == "" || memoryUnit == MemoryUnitMiB) {
+		// The upstream memory annotation is a resource quantity, a plain number would be read as bytes
+		memoryUnit = MemoryUnitMi
+	}
+
+	capacity := map[string]string{
+		cpu:    strconv.FormatInt(instanceTypeInfo.VCPU, 10),
+		memory: memoryUnit.Format(instanceTypeInfo.MemoryMb),
+	}
+	if !r.OmitZeroGPU || instanceTypeInfo.GPU != 0 {
+		capacity[gpu] = strconv.FormatInt(instanceTypeInfo.GPU, 10)
+	}
+	if gpuType := scheme.gpuTypeKey(); gpuType != "" && instanceTypeInfo.GPU > 0 {
+		if resourceName := gpuResourceName(instanceTypeInfo.GPUManufacturer); resourceName != "" {
+			capacity[gpuType] = resourceName
+		}
+	}
+	return capacity
+}
+
+// migrationPending returns true if the annotations still need to be migrated from MigrateFromAnnotationScheme.
+// This is the case while a migration window is running, while keys of the old scheme are present, and if the
+// capacity was not written in the new scheme yet, e.g. for MachineDeployments created during the migration.
+func (r *Reconciler) migrationPending(annotations map[string]string) bool {
+	if r.MigrateFromAnnotationScheme == "" || r.MigrateFromAnnotationScheme.orDefault() == r.AnnotationScheme.orDefault() {
+		return false
+	}
+	// The keys of the old scheme are kept if it is written as the additional scheme
+	if r.AdditionalAnnotationScheme != "" && r.MigrateFromAnnotationScheme.orDefault() == r.AdditionalAnnotationScheme.orDefault() {
+		return false
+	}
+	if _, ok := annotations[migrationStartedKey]; ok {
+		return true
+	}
+
+	oldCPU, _, _ := r.MigrateFromAnnotationScheme.keys()
+	newCPU, _, _ := r.AnnotationScheme.keys()
+	_, hasOld := annotations[oldCPU]
+	_, hasNew := annotations[newCPU]
+	return hasOld || !hasNew
+}
+
+// inMigrationWindow returns true if the migration window recorded in the annotations has not passed yet.
+// A migration that was not started yet is within its window.
+func (r *Reconciler) inMigrationWindow(annotations map[string]string) bool {
+	started, err := time.Parse(time.RFC3339, annotations[migrationStartedKey])
+	if err != nil {
+		return true
+	}
+	return time.Since(started) < r.MigrationWindow
+}
+
+// startMigration records the start of the migration window if the migration is pending and was not started yet.
+// This is kept out of setCapacityAnnotations, so that previews of the annotations do not start a window.
+func (r *Reconciler) startMigration(annotations map[string]string) {
+	if !r.migrationPending(annotations) {
+		return
+	}
+	if _, ok := annotations[migrationStartedKey]; !ok {
+		annotations[migrationStartedKey] = time.Now().UTC().Format(time.RFC3339)
+	}
+	setManagedKeys(annotations, migrationStartedKey)
+}
+
+// completeMigration removes the keys of the old scheme and the migration start after the migration window.
+func (r *Reconciler) completeMigration(annotations map[string]string) {
+	oldCPU, oldMemory, oldGPU := r.MigrateFromAnnotationScheme.keys()
+	keys := []string{oldCPU, oldMemory, oldGPU, migrationStartedKey}
+	if gpuType := r.MigrateFromAnnotationScheme.gpuTypeKey(); gpuType != "" {
+		keys = append(keys, gpuType)
+	}
+	for _, key := range keys {
+		delete(annotations, key)
+	}
+	unsetManagedKeys(annotations, keys...)
+}
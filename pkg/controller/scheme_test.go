@@ -0,0 +1,219 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestReconcileWithAnnotationScheme(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.AnnotationScheme = AnnotationSchemeClusterAutoscaler
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(caCPUKey, "8"))
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(caMemoryKey, "16384Mi"))
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(caGPUCountKey, "0"))
+	g.Expect(machineDeployment.Annotations).ToNot(HaveKey(cpuKey))
+	g.Expect(machineDeployment.Annotations).ToNot(HaveKey(migrationStartedKey))
+}
+
+func TestReconcileWithAdditionalAnnotationScheme(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "p2.16xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.AdditionalAnnotationScheme = AnnotationSchemeClusterAutoscaler
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	annotations := machineDeployment.Annotations
+	g.Expect(annotations).To(HaveKeyWithValue(cpuKey, "64"))
+	g.Expect(annotations).To(HaveKeyWithValue(gpuKey, "16"))
+	g.Expect(annotations).To(HaveKeyWithValue(caCPUKey, "64"))
+	g.Expect(annotations).To(HaveKeyWithValue(caGPUCountKey, "16"))
+	g.Expect(annotations).To(HaveKeyWithValue(caGPUTypeKey, "nvidia.com/gpu"))
+	g.Expect(annotations).ToNot(HaveKey(migrationStartedKey))
+	g.Expect(getManagedKeys(annotations)).To(ContainElements(cpuKey, caCPUKey, caGPUTypeKey))
+
+	// The GPU type is removed when switching to an instance type without GPUs
+	awsMachineTemplate.Spec.Template.Spec.InstanceType = "a1.2xlarge"
+	g.Expect(r.Client.Update(ctx, awsMachineTemplate)).To(Succeed())
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(annotations).To(HaveKeyWithValue(caGPUCountKey, "0"))
+	g.Expect(annotations).ToNot(HaveKey(caGPUTypeKey))
+}
+
+func TestGPUResourceName(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(gpuResourceName("NVIDIA")).To(Equal("nvidia.com/gpu"))
+	g.Expect(gpuResourceName("AMD")).To(Equal("amd.com/gpu"))
+	g.Expect(gpuResourceName("Xilinx")).To(BeEmpty())
+	g.Expect(gpuResourceName("")).To(BeEmpty())
+}
+
+func TestReconcileWithAnnotationSchemeMigration(t *testing.T) {
+	testCases := []struct {
+		name                string
+		existingAnnotations map[string]string
+		expectOldKeys       bool
+		expectStarted       bool
+	}{
+		{
+			name: "migration starts with existing old keys",
+			existingAnnotations: map[string]string{
+				cpuKey:    "8",
+				memoryKey: "16384",
+				gpuKey:    "0",
+			},
+			expectOldKeys: true,
+			expectStarted: true,
+		},
+		{
+			name:          "new MachineDeployment gets both schemes",
+			expectOldKeys: true,
+			expectStarted: true,
+		},
+		{
+			name: "both schemes are written within the window",
+			existingAnnotations: map[string]string{
+				cpuKey:              "8",
+				memoryKey:           "16384",
+				gpuKey:              "0",
+				migrationStartedKey: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+			},
+			expectOldKeys: true,
+			expectStarted: true,
+		},
+		{
+			name: "old keys and migration start are removed after the window",
+			existingAnnotations: map[string]string{
+				cpuKey:              "8",
+				memoryKey:           "16384",
+				gpuKey:              "0",
+				caCPUKey:            "8",
+				caMemoryKey:         "16384Mi",
+				caGPUCountKey:       "0",
+				migrationStartedKey: time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339),
+				managedKeysKey:      migrationStartedKey + "," + gpuKey + "," + memoryKey + "," + cpuKey,
+			},
+		},
+		{
+			name: "completed migration is not started again",
+			existingAnnotations: map[string]string{
+				caCPUKey:      "8",
+				caMemoryKey:   "16384Mi",
+				caGPUCountKey: "0",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", tc.existingAnnotations)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+			r.AnnotationScheme = AnnotationSchemeClusterAutoscaler
+			r.MigrateFromAnnotationScheme = AnnotationSchemeOpenShift
+			r.MigrationWindow = 24 * time.Hour
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			annotations := machineDeployment.Annotations
+			g.Expect(annotations).To(HaveKeyWithValue(caCPUKey, "8"))
+			g.Expect(annotations).To(HaveKeyWithValue(caMemoryKey, "16384Mi"))
+			managedKeys := getManagedKeys(annotations)
+			g.Expect(managedKeys).To(ContainElements(caCPUKey, caMemoryKey, caGPUCountKey))
+
+			if tc.expectOldKeys {
+				g.Expect(annotations).To(HaveKeyWithValue(cpuKey, "8"))
+				g.Expect(annotations).To(HaveKeyWithValue(memoryKey, "16384"))
+				g.Expect(annotations).To(HaveKeyWithValue(gpuKey, "0"))
+				g.Expect(managedKeys).To(ContainElements(cpuKey, memoryKey, gpuKey))
+			} else {
+				g.Expect(annotations).ToNot(HaveKey(cpuKey))
+				g.Expect(annotations).ToNot(HaveKey(memoryKey))
+				g.Expect(annotations).ToNot(HaveKey(gpuKey))
+				g.Expect(managedKeys).ToNot(ContainElements(cpuKey, memoryKey, gpuKey))
+			}
+
+			if tc.expectStarted {
+				g.Expect(annotations).To(HaveKey(migrationStartedKey))
+				g.Expect(managedKeys).To(ContainElement(migrationStartedKey))
+			} else {
+				g.Expect(annotations).ToNot(HaveKey(migrationStartedKey))
+				g.Expect(managedKeys).ToNot(ContainElement(migrationStartedKey))
+			}
+		})
+	}
+}
+
+func TestAdditionalAnnotationSchemeKeepsMigratedKeys(t *testing.T) {
+	g := NewWithT(t)
+
+	r := &Reconciler{
+		AnnotationScheme:            AnnotationSchemeClusterAutoscaler,
+		AdditionalAnnotationScheme:  AnnotationSchemeOpenShift,
+		MigrateFromAnnotationScheme: AnnotationSchemeOpenShift,
+	}
+	g.Expect(r.migrationPending(map[string]string{cpuKey: "8"})).To(BeFalse())
+	g.Expect(r.additionalScheme()).To(Equal(AnnotationSchemeOpenShift))
+
+	// An additional scheme equal to the annotation scheme is ignored
+	r.AdditionalAnnotationScheme = AnnotationSchemeClusterAutoscaler
+	g.Expect(r.additionalScheme()).To(BeEmpty())
+}
+
+func TestWhatIfDoesNotStartMigration(t *testing.T) {
+	g := NewWithT(t)
+
+	r := newTestReconciler(g)
+	r.AnnotationScheme = AnnotationSchemeClusterAutoscaler
+	r.MigrateFromAnnotationScheme = AnnotationSchemeOpenShift
+	r.MigrationWindow = 24 * time.Hour
+
+	annotations, err := r.WhatIf("us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(annotations).To(HaveKey(caCPUKey))
+	g.Expect(annotations).To(HaveKey(cpuKey))
+	g.Expect(annotations).ToNot(HaveKey(migrationStartedKey))
+}
+
+func TestParseAnnotationScheme(t *testing.T) {
+	g := NewWithT(t)
+
+	scheme, err := ParseAnnotationScheme("cluster-autoscaler")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(scheme).To(Equal(AnnotationSchemeClusterAutoscaler))
+
+	_, err = ParseAnnotationScheme("unknown")
+	g.Expect(err).To(HaveOccurred())
+}
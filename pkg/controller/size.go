@@ -0,0 +1,121 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+const (
+	// labelsAnnotationSizeLimit is the maximum size of the labels annotation value in bytes. The labels are
+	// copied to the node template of every node group by the cluster-autoscaler, so they are kept well below
+	// the limit of the API server for all annotations of an object.
+	labelsAnnotationSizeLimit = 32 * 1024
+	// annotationSizeWarningRatio is the share of the size limit above which a warning event is emitted.
+	annotationSizeWarningRatio = 0.8
+)
+
+// annotationsSize returns the size of the annotations as accounted by the API server.
+func annotationsSize(annotations map[string]string) int {
+	size := 0
+	for key, value := range annotations {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+// labelsSizeLimit returns the maximum size of the labels annotation value, so that neither the limit of the labels
+// annotation nor the limit of the API server for all annotations of the object is exceeded.
+func labelsSizeLimit(annotations map[string]string) int {
+	remaining := apivalidation.TotalAnnotationSizeLimitB - (annotationsSize(annotations) - len(annotations[labelsKey]))
+	return max(min(remaining, labelsAnnotationSizeLimit), 0)
+}
+
+// truncateLabels returns the labels whose serialization fits into the limit and the keys of the dropped labels.
+// The well-known labels are kept first, followed by the other labels in the order of their keys, so that
+// the same labels are dropped on every reconcile.
+func truncateLabels(labelsMap map[string]string, limit int) (map[string]string, []string) {
+	keys := []string{}
+	for _, key := range writtenLabelKeys {
+		if _, ok := labelsMap[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	others := []string{}
+	for key := range labelsMap {
+		if !isWellKnownLabelKey(key) {
+			others = append(others, key)
+		}
+	}
+	sort.Strings(others)
+	keys = append(keys, others...)
+
+	kept := map[string]string{}
+	dropped := []string{}
+	size := 0
+	for _, key := range keys {
+		// key=value, separated by a comma from the previous label
+		labelSize := len(key) + 1 + len(labelsMap[key])
+		if len(kept) > 0 {
+			labelSize++
+		}
+		if size+labelSize > limit {
+			dropped = append(dropped, key)
+			continue
+		}
+		kept[key] = labelsMap[key]
+		size += labelSize
+	}
+	return kept, dropped
+}
+
+// isWellKnownLabelKey returns true if the label is written by the controller.
+func isWellKnownLabelKey(key string) bool {
+	for _, wellKnown := range writtenLabelKeys {
+		if key == wellKnown {
+			return true
+		}
+	}
+	return false
+}
+
+// enforceLabelsSizeLimit truncates the labels annotation of the MachineDeployment if it exceeds its size limit,
+// instead of producing a patch the API server rejects. A warning event is emitted if labels are dropped or
+// the size of the labels annotation approaches the limit.
+func (r *Reconciler) enforceLabelsSizeLimit(machineDeployment *clusterv1.MachineDeployment) {
+	value, ok := machineDeployment.Annotations[labelsKey]
+	if !ok {
+		return
+	}
+
+	limit := labelsSizeLimit(machineDeployment.Annotations)
+	if len(value) > limit {
+		kept, dropped := truncateLabels(parseLabels(value), limit)
+		machineDeployment.Annotations[labelsKey] = serializeLabels(kept)
+		// Only the first keys are listed, so that the event stays short
+		listed := dropped[:min(len(dropped), 5)]
+		klog.Warningf("%v: Dropped %d labels from the labels annotation to stay within %d bytes, starting with %q", machineDeployment.Name, len(dropped), limit, listed)
+		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "AnnotationSizeLimit", "Dropped %d labels from the labels annotation to stay within %d bytes, starting with %q", len(dropped), limit, listed)
+		return
+	}
+
+	if float64(len(value)) > annotationSizeWarningRatio*float64(limit) {
+		r.recorder.Eventf(machineDeployment, corev1.EventTypeWarning, "AnnotationSizeLimit", "The labels annotation is %d bytes, approaching the limit of %d bytes", len(value), limit)
+	}
+}
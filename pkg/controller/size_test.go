@@ -0,0 +1,129 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestTruncateLabels(t *testing.T) {
+	g := NewWithT(t)
+
+	labelsMap := map[string]string{
+		"b":          "2",
+		"a":          "1",
+		archLabelKey: "amd64",
+		"c":          "3",
+	}
+
+	// Well-known labels are kept first, the others in the order of their keys
+	kept, dropped := truncateLabels(labelsMap, len("kubernetes.io/arch=amd64,a=1,b=2"))
+	g.Expect(serializeLabels(kept)).To(Equal("a=1,b=2,kubernetes.io/arch=amd64"))
+	g.Expect(dropped).To(Equal([]string{"c"}))
+
+	kept, dropped = truncateLabels(labelsMap, len("kubernetes.io/arch=amd64"))
+	g.Expect(kept).To(Equal(map[string]string{archLabelKey: "amd64"}))
+	g.Expect(dropped).To(Equal([]string{"a", "b", "c"}))
+
+	kept, dropped = truncateLabels(labelsMap, 1000)
+	g.Expect(kept).To(Equal(labelsMap))
+	g.Expect(dropped).To(BeEmpty())
+}
+
+func TestReconcileWithLargeLabels(t *testing.T) {
+	// userLabels returns n labels of about 100 bytes each
+	userLabels := func(n int) string {
+		labels := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			labels = append(labels, fmt.Sprintf("example.com/label-%03d=%s", i, strings.Repeat("x", 75)))
+		}
+		return strings.Join(labels, ",")
+	}
+
+	testCases := []struct {
+		name               string
+		annotations        map[string]string
+		expectTruncated    bool
+		expectedEventStart string
+	}{
+		{
+			name:        "small labels",
+			annotations: map[string]string{labelsKey: userLabels(10)},
+		},
+		{
+			name:               "labels approaching the limit",
+			annotations:        map[string]string{labelsKey: userLabels(300)},
+			expectedEventStart: corev1.EventTypeWarning + " AnnotationSizeLimit The labels annotation is",
+		},
+		{
+			name:               "labels exceeding the limit",
+			annotations:        map[string]string{labelsKey: userLabels(400)},
+			expectTruncated:    true,
+			expectedEventStart: corev1.EventTypeWarning + " AnnotationSizeLimit Dropped 66 labels",
+		},
+		{
+			name: "labels exceeding the space left by other annotations",
+			annotations: map[string]string{
+				labelsKey:              userLabels(100),
+				"example.com/manifest": strings.Repeat("x", apivalidation.TotalAnnotationSizeLimitB-8*1024),
+			},
+			expectTruncated:    true,
+			expectedEventStart: corev1.EventTypeWarning + " AnnotationSizeLimit Dropped 22 labels",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", tc.annotations)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Name = "large-labels"
+
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			labels := machineDeployment.Annotations[labelsKey]
+			g.Expect(parseLabels(labels)).To(HaveKeyWithValue(archLabelKey, "amd64"))
+			g.Expect(annotationsSize(machineDeployment.Annotations)).To(BeNumerically("<=", apivalidation.TotalAnnotationSizeLimitB))
+			if tc.expectTruncated {
+				// Labels are only dropped as far as needed
+				limit := labelsSizeLimit(machineDeployment.Annotations)
+				g.Expect(limit).To(BeNumerically("<=", labelsAnnotationSizeLimit))
+				g.Expect(len(labels)).To(BeNumerically("<=", limit))
+				g.Expect(len(labels)).To(BeNumerically(">", limit-100))
+			} else {
+				g.Expect(len(parseLabels(labels))).To(Equal(len(parseLabels(tc.annotations[labelsKey])) + 1))
+			}
+
+			if tc.expectedEventStart == "" {
+				g.Expect(recorder.Events).To(BeEmpty())
+				return
+			}
+			g.Expect(recorder.Events).To(HaveLen(1))
+			g.Expect(<-recorder.Events).To(HavePrefix(tc.expectedEventStart))
+		})
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/outposts"
+	"github.com/aws/aws-sdk-go/service/servicequotas"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	"github.com/jhjaggars/capa-annotator/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// DataSourceFile means the information was served from a static instance types dataset.
+const DataSourceFile DataSource = "file"
+
+// errOffline is returned by the AWS clients of the offline mode.
+var errOffline = errors.New("the EC2 API is not called with a static instance types dataset")
+
+// InstanceTypesDataset is a static dataset of instance type capacities, for environments that cannot reach the
+// EC2 API from the management cluster.
+type InstanceTypesDataset struct {
+	// GeneratedAt is the time the dataset was generated, reported as the time of fetch of its instance types.
+	// Without it, the time the dataset was loaded is reported.
+	GeneratedAt *time.Time `json:"generatedAt,omitempty"`
+	// InstanceTypes are the instance types of the dataset.
+	InstanceTypes []DatasetInstanceType `json:"instanceTypes"`
+	// Regions optionally restricts the instance types offered in a region and maps its availability zones to
+	// their zone IDs. All instance types are offered in regions without an entry.
+	Regions map[string]DatasetRegion `json:"regions,omitempty"`
+}
+
+// DatasetInstanceType is the capacity of an instance type in a dataset.
+type DatasetInstanceType struct {
+	InstanceType              string `json:"instanceType"`
+	VCPU                      int64  `json:"vcpu"`
+	MemoryMb                  int64  `json:"memoryMb"`
+	Architecture              string `json:"architecture,omitempty"`
+	GPU                       int64  `json:"gpu,omitempty"`
+	GPUManufacturer           string `json:"gpuManufacturer,omitempty"`
+	GPUName                   string `json:"gpuName,omitempty"`
+	NetworkInterfaces         int64  `json:"networkInterfaces,omitempty"`
+	IPv4AddressesPerInterface int64  `json:"ipv4AddressesPerInterface,omitempty"`
+	InstanceStorageGB         int64  `json:"instanceStorageGB,omitempty"`
+	// MaxPods is the maximum number of pods of a node written by the export subcommand, for capacity planning. It
+	// is not served, the maxPods annotation is calculated from the network limits in the configured mode.
+	MaxPods int64 `json:"maxPods,omitempty"`
+}
+
+// DatasetRegion is a region of a dataset.
+type DatasetRegion struct {
+	// InstanceTypes are the instance types offered in the region. Empty offers all instance types.
+	InstanceTypes []string `json:"instanceTypes,omitempty"`
+	// AvailabilityZones maps the availability zones of the region to their zone IDs.
+	AvailabilityZones map[string]string `json:"availabilityZones,omitempty"`
+}
+
+// LoadInstanceTypesDataset reads an instance types dataset from a YAML or JSON file.
+func LoadInstanceTypesDataset(path string) (*InstanceTypesDataset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance types dataset: %w", err)
+	}
+	return ParseInstanceTypesDataset(data)
+}
+
+// ParseInstanceTypesDataset parses and validates a YAML or JSON instance types dataset.
+func ParseInstanceTypesDataset(data []byte) (*InstanceTypesDataset, error) {
+	dataset := &InstanceTypesDataset{}
+	if err := yaml.UnmarshalStrict(data, dataset); err != nil {
+		return nil, fmt.Errorf("failed to parse instance types dataset: %w", err)
+	}
+	if len(dataset.InstanceTypes) == 0 {
+		return nil, errors.New("instance types dataset has no instance types")
+	}
+
+	known := map[string]struct{}{}
+	for _, instanceType := range dataset.InstanceTypes {
+		if instanceType.InstanceType == "" {
+			return nil, errors.New("instance type without instanceType in dataset")
+		}
+		if _, ok := known[instanceType.InstanceType]; ok {
+			return nil, fmt.Errorf("instance type %q is listed twice in dataset", instanceType.InstanceType)
+		}
+		known[instanceType.InstanceType] = struct{}{}
+		if instanceType.VCPU <= 0 || instanceType.MemoryMb <= 0 {
+			return nil, fmt.Errorf("vcpu and memoryMb of instance type %q must be positive", instanceType.InstanceType)
+		}
+		switch normalizedArch(instanceType.Architecture) {
+		case "", ArchitectureAmd64, ArchitectureArm64:
+		default:
+			return nil, fmt.Errorf("architecture %q of instance type %q must be %s or %s", instanceType.Architecture, instanceType.InstanceType, ArchitectureAmd64, ArchitectureArm64)
+		}
+	}
+	for region, regionData := range dataset.Regions {
+		for _, instanceType := range regionData.InstanceTypes {
+			if _, ok := known[instanceType]; !ok {
+				return nil, fmt.Errorf("instance type %q of region %s is not in dataset", instanceType, region)
+			}
+		}
+	}
+	return dataset, nil
+}
+
+// instanceTypes returns the instance types offered in the region.
+func (d *InstanceTypesDataset) instanceTypes(region string) map[string]InstanceType {
+	offered := map[string]struct{}{}
+	for _, instanceType := range d.Regions[region].InstanceTypes {
+		offered[instanceType] = struct{}{}
+	}
+
+	instanceTypes := map[string]InstanceType{}
+	for _, instanceType := range d.InstanceTypes {
+		if _, ok := offered[instanceType.InstanceType]; len(offered) > 0 && !ok {
+			continue
+		}
+		arch := normalizedArch(instanceType.Architecture)
+		if arch == "" {
+			arch = ArchitectureAmd64
+		}
+		instanceTypes[instanceType.InstanceType] = InstanceType{
+			InstanceType:              instanceType.InstanceType,
+			VCPU:                      instanceType.VCPU,
+			MemoryMb:                  instanceType.MemoryMb,
+			GPU:                       instanceType.GPU,
+			CPUArchitecture:           arch,
+			GPUManufacturer:           instanceType.GPUManufacturer,
+			GPUName:                   instanceType.GPUName,
+			NetworkInterfaces:         instanceType.NetworkInterfaces,
+			IPv4AddressesPerInterface: instanceType.IPv4AddressesPerInterface,
+			InstanceStorageGB:         instanceType.InstanceStorageGB,
+		}
+	}
+	return instanceTypes
+}
+
+// staticInstanceTypesCache serves instance types from a dataset, without calling the EC2 API.
+type staticInstanceTypesCache struct {
+	dataset  *InstanceTypesDataset
+	loadedAt time.Time
+}
+
+// NewStaticInstanceTypesCache returns an instance types cache serving the instance types of the dataset. The AWS
+// clients of the lookups are not used.
+func NewStaticInstanceTypesCache(dataset *InstanceTypesDataset) InstanceTypesCache {
+	return &staticInstanceTypesCache{dataset: dataset, loadedAt: dataset.fetchedAt()}
+}
+
+// fetchedAt returns the time the dataset was generated, or the current time if unknown.
+func (d *InstanceTypesDataset) fetchedAt() time.Time {
+	if d.GeneratedAt != nil {
+		return *d.GeneratedAt
+	}
+	return time.Now()
+}
+
+// GetInstanceType returns the instance type of the dataset offered in the region.
+func (s *staticInstanceTypesCache) GetInstanceType(_ awsclient.Client, cacheID string, instanceType string) (InstanceType, error) {
+	region := cacheRegion(cacheID)
+	instanceTypes := s.dataset.instanceTypes(region)
+	instanceTypeInfo, ok := instanceTypes[instanceType]
+	if !ok {
+		metrics.InstanceTypeLookupFailures.WithLabelValues(region, metrics.LookupUnknown).Inc()
+		return InstanceType{}, annotatorerrors.Errorf(annotatorerrors.ErrUnknownInstanceType, "instance type %q not found in the static instance types dataset of region %s", instanceType, region)
+	}
+	instanceTypeInfo.Source = DataSourceFile
+	instanceTypeInfo.FetchedAt = s.loadedAt
+	return instanceTypeInfo, nil
+}
+
+// staticAvailabilityZonesCache serves the zone IDs of a dataset, without calling the EC2 API.
+type staticAvailabilityZonesCache struct {
+	dataset *InstanceTypesDataset
+}
+
+// NewStaticAvailabilityZonesCache returns an availability zones cache serving the zone IDs of the dataset. Zones
+// missing from the dataset have no zone ID.
+func NewStaticAvailabilityZonesCache(dataset *InstanceTypesDataset) AvailabilityZonesCache {
+	return &staticAvailabilityZonesCache{dataset: dataset}
+}
+
+// GetZoneID returns the zone ID of the zone in the dataset, or an empty string.
+func (s *staticAvailabilityZonesCache) GetZoneID(_ awsclient.Client, cacheID string, zone string) (string, error) {
+	return s.dataset.Regions[cacheRegion(cacheID)].AvailabilityZones[zone], nil
+}
+
+// OfflineAWSClientBuilder builds AWS clients that do not call AWS, for use with a static instance types dataset.
+// Subnets are described as not placed on an Outpost, the other calls of the controller fail.
+func OfflineAWSClientBuilder(client.Client, string, string, string, awsclient.RegionCache) (awsclient.Client, error) {
+	return &offlineAWSClient{}, nil
+}
+
+// OfflineIdentityClientBuilder is OfflineAWSClientBuilder for identities.
+func OfflineIdentityClientBuilder(client.Client, awsclient.Identity, string, awsclient.RegionCache) (awsclient.Client, error) {
+	return &offlineAWSClient{}, nil
+}
+
+// offlineAWSClient implements the AWS calls of the controller without calling AWS.
+type offlineAWSClient struct {
+	awsclient.Client
+}
+
+func (c *offlineAWSClient) DescribeInstanceTypes(*ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	return nil, errOffline
+}
+
+func (c *offlineAWSClient) DescribeAvailabilityZones(*ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	return nil, errOffline
+}
+
+func (c *offlineAWSClient) DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return nil, errOffline
+}
+
+func (c *offlineAWSClient) DescribeSubnets(*ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	return &ec2.DescribeSubnetsOutput{}, nil
+}
+
+func (c *offlineAWSClient) GetOutpostInstanceTypes(*outposts.GetOutpostInstanceTypesInput) (*outposts.GetOutpostInstanceTypesOutput, error) {
+	return nil, errOffline
+}
+
+func (c *offlineAWSClient) DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return nil, errOffline
+}
+
+func (c *offlineAWSClient) GetServiceQuota(*servicequotas.GetServiceQuotaInput) (*servicequotas.GetServiceQuotaOutput, error) {
+	return nil, errOffline
+}
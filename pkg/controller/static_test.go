@@ -0,0 +1,151 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	. "github.com/onsi/gomega"
+)
+
+const testDataset = `
+instanceTypes:
+- instanceType: a1.2xlarge
+  vcpu: 8
+  memoryMb: 16384
+  architecture: arm64
+- instanceType: p3.2xlarge
+  vcpu: 8
+  memoryMb: 62464
+  gpu: 1
+  gpuManufacturer: NVIDIA
+  gpuName: V100
+regions:
+  us-gov-west-1:
+    instanceTypes: [p3.2xlarge]
+    availabilityZones:
+      us-gov-west-1a: usgw1-az1
+`
+
+func TestParseInstanceTypesDataset(t *testing.T) {
+	testCases := []struct {
+		name          string
+		data          string
+		expectedError string
+	}{
+		{
+			name: "valid dataset",
+			data: testDataset,
+		},
+		{
+			name: "JSON dataset",
+			data: `{"instanceTypes": [{"instanceType": "m5.large", "vcpu": 2, "memoryMb": 8192}]}`,
+		},
+		{
+			name:          "empty dataset",
+			data:          "instanceTypes: []",
+			expectedError: "has no instance types",
+		},
+		{
+			name:          "duplicate instance type",
+			data:          "instanceTypes: [{instanceType: m5.large, vcpu: 2, memoryMb: 8192}, {instanceType: m5.large, vcpu: 2, memoryMb: 8192}]",
+			expectedError: "listed twice",
+		},
+		{
+			name:          "missing capacity",
+			data:          "instanceTypes: [{instanceType: m5.large, vcpu: 2}]",
+			expectedError: "must be positive",
+		},
+		{
+			name:          "invalid architecture",
+			data:          "instanceTypes: [{instanceType: m5.large, vcpu: 2, memoryMb: 8192, architecture: x86_64}]",
+			expectedError: "architecture",
+		},
+		{
+			name:          "unknown instance type of region",
+			data:          "instanceTypes: [{instanceType: m5.large, vcpu: 2, memoryMb: 8192}]\nregions: {us-east-1: {instanceTypes: [m6.large]}}",
+			expectedError: `instance type "m6.large" of region us-east-1 is not in dataset`,
+		},
+		{
+			name:          "unknown field",
+			data:          "instanceTypes: [{instanceType: m5.large, vcpu: 2, memoryMb: 8192, cores: 1}]",
+			expectedError: "failed to parse",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			_, err := ParseInstanceTypesDataset([]byte(tc.data))
+			if tc.expectedError == "" {
+				g.Expect(err).ToNot(HaveOccurred())
+			} else {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectedError)))
+			}
+		})
+	}
+}
+
+func TestStaticInstanceTypesCache(t *testing.T) {
+	g := NewWithT(t)
+
+	dataset, err := ParseInstanceTypesDataset([]byte(testDataset))
+	g.Expect(err).ToNot(HaveOccurred())
+	cache := NewStaticInstanceTypesCache(dataset)
+
+	// Regions without an entry offer all instance types
+	instanceType, err := cache.GetInstanceType(nil, "us-east-1", "a1.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(instanceType.VCPU).To(Equal(int64(8)))
+	g.Expect(instanceType.CPUArchitecture).To(Equal(ArchitectureArm64))
+	g.Expect(instanceType.Source).To(Equal(DataSourceFile))
+
+	instanceType, err = cache.GetInstanceType(nil, "us-gov-west-1", "p3.2xlarge")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(instanceType.GPU).To(Equal(int64(1)))
+	g.Expect(instanceType.GPUName).To(Equal("V100"))
+	g.Expect(instanceType.CPUArchitecture).To(Equal(ArchitectureAmd64))
+
+	_, err = cache.GetInstanceType(nil, "us-gov-west-1", "a1.2xlarge")
+	g.Expect(errors.Is(err, annotatorerrors.ErrUnknownInstanceType)).To(BeTrue())
+
+	zones := NewStaticAvailabilityZonesCache(dataset)
+	g.Expect(zones.GetZoneID(nil, "us-gov-west-1", "us-gov-west-1a")).To(Equal("usgw1-az1"))
+	g.Expect(zones.GetZoneID(nil, "us-east-1", "us-east-1a")).To(BeEmpty())
+}
+
+func TestReconcileWithStaticInstanceTypes(t *testing.T) {
+	g := NewWithT(t)
+
+	dataset, err := ParseInstanceTypesDataset([]byte(testDataset))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.InstanceTypesCache = NewStaticInstanceTypesCache(dataset)
+	r.AvailabilityZonesCache = NewStaticAvailabilityZonesCache(dataset)
+	r.AwsClientBuilder = OfflineAWSClientBuilder
+	r.IdentityClientBuilder = OfflineIdentityClientBuilder
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(memoryKey, "16384"))
+	g.Expect(machineDeployment.Annotations[provenanceKey]).To(ContainSubstring("source=file"))
+}
@@ -0,0 +1,193 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// taintsKey is the annotation of the cluster-autoscaler Cluster API provider for the taints of the nodes of a node group.
+const taintsKey = "capacity.cluster-autoscaler.kubernetes.io/taints"
+
+// kubeadmConfigTemplateKind is the kind of the bootstrap templates the taints are read from. They are handled as
+// unstructured objects, so that the controller does not depend on the kubeadm bootstrap API package.
+const kubeadmConfigTemplateKind = "KubeadmConfigTemplate"
+
+// ParseTaints parses taints in the format of the taints annotation, e.g. "dedicated=gpu:NoSchedule,spot:NoExecute".
+func ParseTaints(value string) ([]corev1.Taint, error) {
+	taints := []corev1.Taint{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		keyValue, effect, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid taint %q, expected <key>[=<value>]:<effect>", entry)
+		}
+		key, value, _ := strings.Cut(keyValue, "=")
+		taint := corev1.Taint{Key: key, Value: value, Effect: corev1.TaintEffect(effect)}
+		if err := validateTaint(taint); err != nil {
+			return nil, err
+		}
+		taints = append(taints, taint)
+	}
+	return taints, nil
+}
+
+// validateTaint returns an error if the taint is not valid on a node.
+func validateTaint(taint corev1.Taint) error {
+	if errs := validation.IsQualifiedName(taint.Key); len(errs) > 0 {
+		return fmt.Errorf("invalid taint key %q: %s", taint.Key, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsValidLabelValue(taint.Value); len(errs) > 0 {
+		return fmt.Errorf("invalid value %q of taint %q: %s", taint.Value, taint.Key, strings.Join(errs, "; "))
+	}
+	switch taint.Effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		return nil
+	}
+	return fmt.Errorf("invalid effect %q of taint %q", taint.Effect, taint.Key)
+}
+
+// serializeTaints serializes the taints into the format of the taints annotation, ordered by key and effect.
+func serializeTaints(taints []corev1.Taint) string {
+	sorted := append([]corev1.Taint{}, taints...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Key != sorted[j].Key {
+			return sorted[i].Key < sorted[j].Key
+		}
+		return sorted[i].Effect < sorted[j].Effect
+	})
+
+	entries := make([]string, 0, len(sorted))
+	for _, taint := range sorted {
+		entry := taint.Key
+		if taint.Value != "" {
+			entry += "=" + taint.Value
+		}
+		entries = append(entries, entry+":"+string(taint.Effect))
+	}
+	return strings.Join(entries, ",")
+}
+
+// ValidateTaintsSourceAnnotation returns an error if the taints source annotation key is owned by the controller.
+func ValidateTaintsSourceAnnotation(key string) error {
+	return ValidateAdditionalMemoryKey(key)
+}
+
+// taintsEnabled returns true if a source of the taints annotation is configured.
+func (r *Reconciler) taintsEnabled() bool {
+	return r.TaintsFromBootstrapTemplate || r.TaintsSourceAnnotation != ""
+}
+
+// resolveTaints returns the taints of the nodes of the MachineDeployment. The taints of the node registration of
+// the KubeadmConfigTemplate are merged with the taints of the source annotation, which take precedence for taints
+// with the same key and effect.
+func (r *Reconciler) resolveTaints(ctx context.Context, machineDeployment *clusterv1.MachineDeployment) ([]corev1.Taint, error) {
+	taints := []corev1.Taint{}
+	if r.TaintsFromBootstrapTemplate {
+		templateTaints, err := r.bootstrapTemplateTaints(ctx, machineDeployment)
+		if err != nil {
+			return nil, err
+		}
+		taints = append(taints, templateTaints...)
+	}
+
+	if value, ok := machineDeployment.Annotations[r.TaintsSourceAnnotation]; ok && r.TaintsSourceAnnotation != "" {
+		annotationTaints, err := ParseTaints(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %w", r.TaintsSourceAnnotation, err)
+		}
+		for _, taint := range annotationTaints {
+			taints = append(removeTaint(taints, taint), taint)
+		}
+	}
+	return taints, nil
+}
+
+// removeTaint returns the taints without the taints of the same key and effect as the given taint.
+func removeTaint(taints []corev1.Taint, remove corev1.Taint) []corev1.Taint {
+	kept := []corev1.Taint{}
+	for _, taint := range taints {
+		if taint.Key != remove.Key || taint.Effect != remove.Effect {
+			kept = append(kept, taint)
+		}
+	}
+	return kept
+}
+
+// bootstrapTemplateTaints returns the taints of the node registration of the join configuration of the
+// KubeadmConfigTemplate of the MachineDeployment. MachineDeployments using another bootstrap provider have no taints.
+func (r *Reconciler) bootstrapTemplateTaints(ctx context.Context, machineDeployment *clusterv1.MachineDeployment) ([]corev1.Taint, error) {
+	ref := machineDeployment.Spec.Template.Spec.Bootstrap.ConfigRef
+	if ref == nil || ref.Kind != kubeadmConfigTemplateKind {
+		return nil, nil
+	}
+
+	template := &unstructured.Unstructured{}
+	template.SetGroupVersionKind(schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind))
+	key := client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}
+	if key.Namespace == "" {
+		key.Namespace = machineDeployment.Namespace
+	}
+	if err := r.Client.Get(ctx, key, template); err != nil {
+		return nil, annotatorerrors.NewLookupError("KubeadmConfigTemplate", key.Namespace, key.Name, err)
+	}
+
+	rawTaints, _, err := unstructured.NestedSlice(template.Object, "spec", "template", "spec", "joinConfiguration", "nodeRegistration", "taints")
+	if err != nil {
+		return nil, fmt.Errorf("invalid taints of KubeadmConfigTemplate %s: %w", key, err)
+	}
+
+	taints := []corev1.Taint{}
+	for _, rawTaint := range rawTaints {
+		fields, ok := rawTaint.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid taint %v of KubeadmConfigTemplate %s", rawTaint, key)
+		}
+		taint := corev1.Taint{}
+		taint.Key, _, _ = unstructured.NestedString(fields, "key")
+		taint.Value, _, _ = unstructured.NestedString(fields, "value")
+		effect, _, _ := unstructured.NestedString(fields, "effect")
+		taint.Effect = corev1.TaintEffect(effect)
+		if err := validateTaint(taint); err != nil {
+			return nil, fmt.Errorf("invalid taint of KubeadmConfigTemplate %s: %w", key, err)
+		}
+		taints = append(taints, taint)
+	}
+	return taints, nil
+}
+
+// setTaintsAnnotation sets the taints annotation on the given annotations, or removes it if there are no taints.
+func setTaintsAnnotation(annotations map[string]string, taints []corev1.Taint) {
+	if len(taints) == 0 {
+		delete(annotations, taintsKey)
+	} else {
+		annotations[taintsKey] = serializeTaints(taints)
+	}
+	setManagedKeys(annotations, taintsKey)
+}
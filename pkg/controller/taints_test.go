@@ -0,0 +1,101 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// newTestKubeadmConfigTemplate returns a KubeadmConfigTemplate registering nodes with the given taints.
+func newTestKubeadmConfigTemplate(namespace, name string, taints ...interface{}) *unstructured.Unstructured {
+	template := &unstructured.Unstructured{}
+	template.SetAPIVersion("bootstrap.cluster.x-k8s.io/v1beta1")
+	template.SetKind(kubeadmConfigTemplateKind)
+	template.SetNamespace(namespace)
+	template.SetName(name)
+	template.Object["spec"] = map[string]interface{}{
+		"template": map[string]interface{}{
+			"spec": map[string]interface{}{
+				"joinConfiguration": map[string]interface{}{
+					"nodeRegistration": map[string]interface{}{
+						"taints": taints,
+					},
+				},
+			},
+		},
+	}
+	return template
+}
+
+func TestParseTaints(t *testing.T) {
+	g := NewWithT(t)
+
+	taints, err := ParseTaints("dedicated=gpu:NoSchedule, spot:NoExecute")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(taints).To(Equal([]corev1.Taint{
+		{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule},
+		{Key: "spot", Effect: corev1.TaintEffectNoExecute},
+	}))
+	g.Expect(serializeTaints(taints)).To(Equal("dedicated=gpu:NoSchedule,spot:NoExecute"))
+
+	_, err = ParseTaints("dedicated=gpu")
+	g.Expect(err).To(MatchError(ContainSubstring("expected <key>[=<value>]:<effect>")))
+
+	_, err = ParseTaints("dedicated=gpu:NoPlacement")
+	g.Expect(err).To(MatchError(ContainSubstring("invalid effect")))
+}
+
+func TestReconcileWithTaints(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", map[string]string{
+		"example.com/taints": "dedicated=ml:NoSchedule",
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Spec.Template.Spec.Bootstrap.ConfigRef = &corev1.ObjectReference{
+		APIVersion: "bootstrap.cluster.x-k8s.io/v1beta1",
+		Kind:       kubeadmConfigTemplateKind,
+		Name:       "workers",
+	}
+	kubeadmConfigTemplate := newTestKubeadmConfigTemplate("default", "workers",
+		map[string]interface{}{"key": "dedicated", "value": "gpu", "effect": "NoSchedule"},
+		map[string]interface{}{"key": "nvidia.com/gpu", "effect": "NoSchedule"},
+	)
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster, kubeadmConfigTemplate)
+	r.TaintsFromBootstrapTemplate = true
+	r.TaintsSourceAnnotation = "example.com/taints"
+
+	// The source annotation takes precedence over the taint of the template with the same key and effect
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(taintsKey, "dedicated=ml:NoSchedule,nvidia.com/gpu:NoSchedule"))
+	g.Expect(getManagedKeys(machineDeployment.Annotations)).To(ContainElement(taintsKey))
+
+	// The annotation is removed once there are no taints
+	delete(machineDeployment.Annotations, "example.com/taints")
+	machineDeployment.Spec.Template.Spec.Bootstrap.ConfigRef = nil
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).ToNot(HaveKey(taintsKey))
+
+	// Invalid taints fail the reconcile
+	machineDeployment.Annotations["example.com/taints"] = "dedicated"
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).To(MatchError(ContainSubstring("invalid annotation example.com/taints")))
+}
@@ -0,0 +1,63 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/klog/v2"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// topologyManaged returns true if the object is generated by the topology controller of a ClusterClass based Cluster.
+func topologyManaged(obj client.Object) bool {
+	_, ok := obj.GetLabels()[clusterv1.ClusterTopologyOwnedLabel]
+	return ok
+}
+
+// machineDeploymentsOfTemplate maps a topology generated AWSMachineTemplate to the MachineDeployments referencing
+// it. The topology controller rotates the template on every change of the machine template of the ClusterClass: it
+// creates a new template before pointing the MachineDeployment to it, so the MachineDeployment may be reconciled
+// before the new template is in the cache. Templates are matched through the cluster and deployment name labels
+// the topology controller sets on both.
+func (r *Reconciler) machineDeploymentsOfTemplate(ctx context.Context, obj client.Object) []reconcile.Request {
+	template, ok := obj.(*infrav1.AWSMachineTemplate)
+	if !ok || !topologyManaged(template) {
+		return nil
+	}
+	clusterName, deploymentName := template.Labels[clusterv1.ClusterNameLabel], template.Labels[clusterv1.ClusterTopologyMachineDeploymentNameLabel]
+	if clusterName == "" || deploymentName == "" {
+		return nil
+	}
+
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := r.Client.List(ctx, machineDeployments, client.InNamespace(template.Namespace), client.MatchingLabels{
+		clusterv1.ClusterNameLabel:                          clusterName,
+		clusterv1.ClusterTopologyMachineDeploymentNameLabel: deploymentName,
+	}); err != nil {
+		klog.Errorf("Failed to list MachineDeployments of AWSMachineTemplate %s/%s: %v", template.Namespace, template.Name, err)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, machineDeployment := range machineDeployments.Items {
+		if machineDeployment.Spec.Template.Spec.InfrastructureRef.Name == template.Name {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&machineDeployment)})
+		}
+	}
+	return requests
+}
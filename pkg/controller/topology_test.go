@@ -0,0 +1,104 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func topologyLabels(deploymentName string) map[string]string {
+	return map[string]string{
+		clusterv1.ClusterNameLabel:                          "test-cluster",
+		clusterv1.ClusterTopologyOwnedLabel:                 "",
+		clusterv1.ClusterTopologyMachineDeploymentNameLabel: deploymentName,
+	}
+}
+
+func TestMachineDeploymentsOfTemplate(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "test-cluster-md-0-abcde"
+	machineDeployment.Labels = topologyLabels("md-0")
+	awsMachineTemplate.Labels = topologyLabels("md-0")
+
+	// The MachineDeployment still referencing the previous template is not enqueued for the old template
+	previous := awsMachineTemplate.DeepCopy()
+	previous.Name = "test-aws-template-previous"
+
+	// Neither are MachineDeployments of other topology MachineDeployment names
+	other := machineDeployment.DeepCopy()
+	other.Name = "test-cluster-md-1-fghij"
+	other.Labels = topologyLabels("md-1")
+
+	r := newTestReconciler(g, machineDeployment, other, awsMachineTemplate, previous, cluster, awsCluster)
+
+	g.Expect(r.machineDeploymentsOfTemplate(ctx, awsMachineTemplate)).To(ConsistOf(
+		reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)},
+	))
+	g.Expect(r.machineDeploymentsOfTemplate(ctx, previous)).To(BeEmpty())
+
+	// Templates not generated by the topology controller are ignored
+	awsMachineTemplate.Labels = nil
+	g.Expect(r.machineDeploymentsOfTemplate(ctx, awsMachineTemplate)).To(BeEmpty())
+}
+
+func TestReconcileWaitsForRotatedTemplate(t *testing.T) {
+	testCases := []struct {
+		name      string
+		labels    map[string]string
+		expectErr bool
+	}{
+		{
+			name:   "topology managed MachineDeployment",
+			labels: topologyLabels("md-0"),
+		},
+		{
+			name:      "MachineDeployment without topology",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			machineDeployment, _, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Labels = tc.labels
+
+			// The template is not created yet
+			r := newTestReconciler(g, machineDeployment, cluster, awsCluster)
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(recorder.Events).To(HaveLen(1))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(recorder.Events).To(BeEmpty())
+			}
+			g.Expect(machineDeployment.Annotations).ToNot(HaveKey(cpuKey))
+		})
+	}
+}
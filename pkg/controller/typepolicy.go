@@ -0,0 +1,153 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+// InstanceTypePolicy restricts the instance types node groups may use, giving platform teams guardrails on
+// what node groups can be defined.
+type InstanceTypePolicy struct {
+	// Rules are evaluated together: an instance type is denied if any rule applying to the namespace denies it,
+	// or if rules applying to the namespace have allow lists and none of them allows it.
+	Rules []InstanceTypePolicyRule `json:"rules"`
+}
+
+// InstanceTypePolicyRule allows or denies instance types in namespaces. Entries of the allow and deny lists are
+// either instance types, e.g. m5.large, or instance families matching all sizes, e.g. m5.
+type InstanceTypePolicyRule struct {
+	// Namespaces the rule applies to, "*" matches any namespace. An empty list applies to all namespaces.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Allow lists the allowed instance types and families. An empty list allows all instance types.
+	Allow []string `json:"allow,omitempty"`
+	// Deny lists the denied instance types and families.
+	Deny []string `json:"deny,omitempty"`
+}
+
+// LoadInstanceTypePolicy reads the instance type policy from a YAML file.
+func LoadInstanceTypePolicy(path string) (*InstanceTypePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance type policy: %w", err)
+	}
+	return ParseInstanceTypePolicy(data)
+}
+
+// ParseInstanceTypePolicy parses and validates the YAML of an instance type policy.
+func ParseInstanceTypePolicy(data []byte) (*InstanceTypePolicy, error) {
+	policy := &InstanceTypePolicy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse instance type policy: %w", err)
+	}
+	for i, rule := range policy.Rules {
+		if len(rule.Allow) == 0 && len(rule.Deny) == 0 {
+			return nil, fmt.Errorf("rule %d of the instance type policy must allow or deny instance types", i)
+		}
+		for _, entry := range append(append([]string{}, rule.Allow...), rule.Deny...) {
+			if entry == "" || strings.Count(entry, ".") > 1 {
+				return nil, fmt.Errorf("rule %d of the instance type policy has invalid entry %q, expected an instance type or family", i, entry)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// appliesTo returns true if the rule applies to the namespace.
+func (r InstanceTypePolicyRule) appliesTo(namespace string) bool {
+	if len(r.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range r.Namespaces {
+		if ns == namespaceWildcard || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesInstanceType returns true if one of the instance types or families matches the instance type.
+func matchesInstanceType(entries []string, instanceType string) bool {
+	family, _, _ := strings.Cut(instanceType, ".")
+	for _, entry := range entries {
+		if entry == instanceType || entry == family {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error if the instance type is not allowed in the namespace.
+func (p *InstanceTypePolicy) Check(namespace, instanceType string) error {
+	if p == nil {
+		return nil
+	}
+
+	restricted, allowed := false, false
+	for _, rule := range p.Rules {
+		if !rule.appliesTo(namespace) {
+			continue
+		}
+		if matchesInstanceType(rule.Deny, instanceType) {
+			return annotatorerrors.Errorf(annotatorerrors.ErrInstanceTypeNotAllowed, "instance type %s is denied in namespace %s", instanceType, namespace)
+		}
+		if len(rule.Allow) > 0 {
+			restricted = true
+			allowed = allowed || matchesInstanceType(rule.Allow, instanceType)
+		}
+	}
+	if restricted && !allowed {
+		return annotatorerrors.Errorf(annotatorerrors.ErrInstanceTypeNotAllowed, "instance type %s is not allowed in namespace %s", instanceType, namespace)
+	}
+	return nil
+}
+
+// InstanceTypePolicyWebhook is a validating admission webhook rejecting AWSMachineTemplates whose instance
+// type is not allowed by the policy in their namespace.
+type InstanceTypePolicyWebhook struct {
+	// Policy is the enforced instance type policy.
+	Policy *InstanceTypePolicy
+}
+
+// Handle validates the instance type of the AWSMachineTemplate of the admission request.
+func (w *InstanceTypePolicyWebhook) Handle(_ context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	awsMachineTemplate := &infrav1.AWSMachineTemplate{}
+	if err := json.Unmarshal(req.Object.Raw, awsMachineTemplate); err != nil {
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to decode AWSMachineTemplate: %w", err))
+	}
+
+	instanceType := awsMachineTemplate.Spec.Template.Spec.InstanceType
+	if instanceType == "" {
+		return admission.Allowed("")
+	}
+	if err := w.Policy.Check(req.Namespace, instanceType); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
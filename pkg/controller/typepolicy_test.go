@@ -0,0 +1,127 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestParseInstanceTypePolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	policy, err := ParseInstanceTypePolicy([]byte(`
+rules:
+- deny: [p4d, p5]
+- namespaces: [team-a]
+  allow: [m5, c6i.xlarge]
+`))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(policy.Rules).To(HaveLen(2))
+
+	_, err = ParseInstanceTypePolicy([]byte(`rules: [{namespaces: [team-a]}]`))
+	g.Expect(err).To(MatchError(ContainSubstring("must allow or deny instance types")))
+
+	_, err = ParseInstanceTypePolicy([]byte(`rules: [{deny: [m5.large.x]}]`))
+	g.Expect(err).To(MatchError(ContainSubstring("invalid entry")))
+
+	_, err = ParseInstanceTypePolicy([]byte(`rules: [{forbid: [p5]}]`))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestInstanceTypePolicyCheck(t *testing.T) {
+	policy := &InstanceTypePolicy{Rules: []InstanceTypePolicyRule{
+		{Deny: []string{"p4d", "p5"}},
+		{Namespaces: []string{"team-a"}, Allow: []string{"m5", "c6i.xlarge"}, Deny: []string{"m5.24xlarge"}},
+	}}
+
+	testCases := []struct {
+		namespace    string
+		instanceType string
+		expectedErr  string
+	}{
+		{namespace: "team-b", instanceType: "m6i.large"},
+		{namespace: "team-b", instanceType: "p5.48xlarge", expectedErr: "instance type p5.48xlarge is denied in namespace team-b"},
+		{namespace: "team-a", instanceType: "m5.large"},
+		{namespace: "team-a", instanceType: "c6i.xlarge"},
+		{namespace: "team-a", instanceType: "c6i.2xlarge", expectedErr: "instance type c6i.2xlarge is not allowed in namespace team-a"},
+		{namespace: "team-a", instanceType: "m5.24xlarge", expectedErr: "instance type m5.24xlarge is denied in namespace team-a"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.namespace+"/"+tc.instanceType, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := policy.Check(tc.namespace, tc.instanceType)
+			if tc.expectedErr == "" {
+				g.Expect(err).ToNot(HaveOccurred())
+			} else {
+				g.Expect(err).To(MatchError(tc.expectedErr))
+			}
+		})
+	}
+
+	var nilPolicy *InstanceTypePolicy
+	NewWithT(t).Expect(nilPolicy.Check("team-a", "p5.48xlarge")).To(Succeed())
+}
+
+func TestInstanceTypePolicyWebhook(t *testing.T) {
+	g := NewWithT(t)
+
+	webhook := &InstanceTypePolicyWebhook{Policy: &InstanceTypePolicy{Rules: []InstanceTypePolicyRule{{Deny: []string{"p5"}}}}}
+	request := func(instanceType string) admission.Request {
+		awsMachineTemplate := &infrav1.AWSMachineTemplate{}
+		awsMachineTemplate.Spec.Template.Spec.InstanceType = instanceType
+		raw, err := json.Marshal(awsMachineTemplate)
+		g.Expect(err).ToNot(HaveOccurred())
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Namespace: "team-a",
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+
+	response := webhook.Handle(context.Background(), request("p5.48xlarge"))
+	g.Expect(response.Allowed).To(BeFalse())
+	g.Expect(response.Result.Message).To(Equal("instance type p5.48xlarge is denied in namespace team-a"))
+
+	response = webhook.Handle(context.Background(), request("m5.large"))
+	g.Expect(response.Allowed).To(BeTrue())
+}
+
+func TestReconcileWithInstanceTypePolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "p2.16xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	r.InstanceTypePolicy = &InstanceTypePolicy{Rules: []InstanceTypePolicyRule{{Deny: []string{"p2"}}}}
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machineDeployment.Annotations).ToNot(HaveKey(cpuKey))
+	g.Expect(recorder.Events).To(Receive(Equal(corev1.EventTypeWarning + " PolicyViolation Not setting autoscaling from zero annotations: instance type p2.16xlarge is denied in namespace default")))
+}
@@ -0,0 +1,142 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// setManagedKeys records the given annotation keys as managed by the controller.
+// Keys recorded by earlier reconciles are kept, so that keys which are no longer written are still cleaned up.
+func setManagedKeys(annotations map[string]string, keys ...string) {
+	managed := map[string]struct{}{}
+	for _, key := range getManagedKeys(annotations) {
+		managed[key] = struct{}{}
+	}
+	for _, key := range keys {
+		managed[key] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(managed))
+	for key := range managed {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	annotations[managedKeysKey] = strings.Join(sorted, ",")
+}
+
+// unsetManagedKeys removes the given annotation keys from the keys recorded as managed by the controller,
+// e.g. after the controller removed them itself.
+func unsetManagedKeys(annotations map[string]string, keys ...string) {
+	remove := map[string]struct{}{}
+	for _, key := range keys {
+		remove[key] = struct{}{}
+	}
+
+	managed := []string{}
+	for _, key := range getManagedKeys(annotations) {
+		if _, ok := remove[key]; !ok {
+			managed = append(managed, key)
+		}
+	}
+	if len(managed) == 0 {
+		delete(annotations, managedKeysKey)
+		return
+	}
+	annotations[managedKeysKey] = strings.Join(managed, ",")
+}
+
+// getManagedKeys returns the annotation keys recorded as managed by the controller.
+func getManagedKeys(annotations map[string]string) []string {
+	value, ok := annotations[managedKeysKey]
+	if !ok || value == "" {
+		return nil
+	}
+
+	keys := []string{}
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// RemoveManagedAnnotations removes all annotations recorded as managed by the controller, including
+// the managed-keys annotation itself. User-provided labels in the labels annotation are preserved.
+// It returns true if the annotations were modified.
+func RemoveManagedAnnotations(annotations map[string]string) bool {
+	if _, ok := annotations[managedKeysKey]; !ok {
+		return false
+	}
+
+	for _, key := range getManagedKeys(annotations) {
+		if key != labelsKey {
+			delete(annotations, key)
+			continue
+		}
+
+		labelsMap := parseLabels(annotations[labelsKey])
+		for _, label := range writtenLabelKeys {
+			delete(labelsMap, label)
+		}
+		if len(labelsMap) == 0 {
+			delete(annotations, labelsKey)
+		} else {
+			annotations[labelsKey] = serializeLabels(labelsMap)
+		}
+	}
+	delete(annotations, managedKeysKey)
+	return true
+}
+
+// Uninstall removes the controller-managed annotations from all MachineDeployments in the given namespace,
+// or in all namespaces if namespace is empty. When dryRun is set, no changes are written.
+// It returns the number of MachineDeployments that were (or would have been) cleaned up.
+func Uninstall(ctx context.Context, c client.Client, namespace string, dryRun bool) (int, error) {
+	machineDeployments := &clusterv1.MachineDeploymentList{}
+	if err := c.List(ctx, machineDeployments, client.InNamespace(namespace)); err != nil {
+		return 0, fmt.Errorf("failed to list MachineDeployments: %w", err)
+	}
+
+	cleaned := 0
+	for i := range machineDeployments.Items {
+		machineDeployment := &machineDeployments.Items[i]
+		original := client.MergeFrom(machineDeployment.DeepCopy())
+
+		if !RemoveManagedAnnotations(machineDeployment.Annotations) {
+			continue
+		}
+		cleaned++
+
+		if dryRun {
+			klog.Infof("Would remove managed annotations from MachineDeployment %s/%s", machineDeployment.Namespace, machineDeployment.Name)
+			continue
+		}
+
+		if err := c.Patch(ctx, machineDeployment, original); err != nil {
+			return cleaned, fmt.Errorf("failed to patch MachineDeployment %s/%s: %w", machineDeployment.Namespace, machineDeployment.Name, err)
+		}
+		klog.Infof("Removed managed annotations from MachineDeployment %s/%s", machineDeployment.Namespace, machineDeployment.Name)
+	}
+
+	return cleaned, nil
+}
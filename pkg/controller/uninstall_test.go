@@ -0,0 +1,196 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestRemoveManagedAnnotations(t *testing.T) {
+	testCases := []struct {
+		name                string
+		existingAnnotations map[string]string
+		expectedAnnotations map[string]string
+		expectModified      bool
+	}{
+		{
+			name: "without managed keys",
+			existingAnnotations: map[string]string{
+				cpuKey:     "8",
+				"existing": "annotation",
+			},
+			expectedAnnotations: map[string]string{
+				cpuKey:     "8",
+				"existing": "annotation",
+			},
+			expectModified: false,
+		},
+		{
+			name: "with managed keys",
+			existingAnnotations: map[string]string{
+				"existing":     "annotation",
+				cpuKey:         "8",
+				memoryKey:      "16384",
+				gpuKey:         "0",
+				labelsKey:      "kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
+			},
+			expectedAnnotations: map[string]string{
+				"existing": "annotation",
+			},
+			expectModified: true,
+		},
+		{
+			name: "removes topology and accelerator labels",
+			existingAnnotations: map[string]string{
+				cpuKey:         "8",
+				memoryKey:      "16384",
+				gpuKey:         "1",
+				labelsKey:      "cluster-api/accelerator=nvidia-t4,custom-label=value,kubernetes.io/arch=amd64,topology.k8s.aws/zone-id=use1-az1,topology.kubernetes.io/zone=us-east-1a",
+				managedKeysKey: defaultManagedKeys,
+			},
+			expectedAnnotations: map[string]string{
+				labelsKey: "custom-label=value",
+			},
+			expectModified: true,
+		},
+		{
+			name: "preserves user-provided labels",
+			existingAnnotations: map[string]string{
+				cpuKey:         "8",
+				memoryKey:      "16384",
+				gpuKey:         "0",
+				labelsKey:      "custom-label=value,kubernetes.io/arch=amd64",
+				managedKeysKey: defaultManagedKeys,
+			},
+			expectedAnnotations: map[string]string{
+				labelsKey: "custom-label=value",
+			},
+			expectModified: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(tt *testing.T) {
+			g := NewWithT(tt)
+			g.Expect(RemoveManagedAnnotations(tc.existingAnnotations)).To(Equal(tc.expectModified))
+			g.Expect(tc.existingAnnotations).To(Equal(tc.expectedAnnotations))
+		})
+	}
+}
+
+func TestUninstall(t *testing.T) {
+	g := NewWithT(t)
+
+	testScheme := runtime.NewScheme()
+	g.Expect(scheme.AddToScheme(testScheme)).To(Succeed())
+	g.Expect(clusterv1.AddToScheme(testScheme)).To(Succeed())
+
+	managed := &clusterv1.MachineDeployment{}
+	managed.Name = "managed"
+	managed.Namespace = "default"
+	managed.Annotations = map[string]string{
+		cpuKey:         "8",
+		memoryKey:      "16384",
+		gpuKey:         "0",
+		labelsKey:      "kubernetes.io/arch=amd64",
+		managedKeysKey: defaultManagedKeys,
+	}
+
+	unmanaged := &clusterv1.MachineDeployment{}
+	unmanaged.Name = "unmanaged"
+	unmanaged.Namespace = "default"
+	unmanaged.Annotations = map[string]string{cpuKey: "4"}
+
+	fakeK8sClient := fake.NewClientBuilder().WithScheme(testScheme).WithObjects(managed, unmanaged).Build()
+
+	cleaned, err := Uninstall(ctx, fakeK8sClient, "", true)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cleaned).To(Equal(1))
+
+	md := &clusterv1.MachineDeployment{}
+	g.Expect(fakeK8sClient.Get(ctx, client.ObjectKeyFromObject(managed), md)).To(Succeed())
+	g.Expect(md.Annotations).To(HaveKey(managedKeysKey), "dry run must not modify the MachineDeployment")
+
+	cleaned, err = Uninstall(ctx, fakeK8sClient, "", false)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cleaned).To(Equal(1))
+
+	g.Expect(fakeK8sClient.Get(ctx, client.ObjectKeyFromObject(managed), md)).To(Succeed())
+	g.Expect(md.Annotations).To(BeEmpty())
+
+	g.Expect(fakeK8sClient.Get(ctx, client.ObjectKeyFromObject(unmanaged), md)).To(Succeed())
+	g.Expect(md.Annotations).To(Equal(map[string]string{cpuKey: "4"}))
+}
+
+func TestRemoveManagedAnnotationsAfterReconcile(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "p2.16xlarge", map[string]string{
+		labelsKey: "node-role=worker",
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Spec.Template.Spec.FailureDomain = ptr.To("us-east-1a")
+
+	// Write every label of the controller
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.AcceleratorLabel = true
+
+	_, err = r.reconcile(ctx, machineDeployment)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(parseLabels(machineDeployment.Annotations[labelsKey])).To(HaveLen(len(writtenLabelKeys) + 1))
+
+	// A written label missing from writtenLabelKeys would be left behind
+	g.Expect(RemoveManagedAnnotations(machineDeployment.Annotations)).To(BeTrue())
+	g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(labelsKey, "node-role=worker"))
+}
+
+// TestReconcileAfterUninstall documents why the controller must be stopped before running the uninstall:
+// a reconcile of an uninstalled MachineDeployment writes all annotations straight back.
+func TestReconcileAfterUninstall(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "uninstalled"
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	cleaned, err := Uninstall(ctx, r.Client, "", false)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cleaned).To(Equal(1))
+
+	md := &clusterv1.MachineDeployment{}
+	g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machineDeployment), md)).To(Succeed())
+	g.Expect(md.Annotations).ToNot(HaveKey(managedKeysKey))
+
+	// The patch of the uninstall triggers a reconcile if the controller is still running
+	_, err = r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(machineDeployment), md)).To(Succeed())
+	g.Expect(md.Annotations).To(HaveKey(managedKeysKey))
+	g.Expect(md.Annotations).To(HaveKey(cpuKey))
+}
@@ -0,0 +1,119 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// negativeLookup is the lookup of an instance type found unknown in the region of a MachineDeployment.
+type negativeLookup struct {
+	template     types.UID
+	generation   int64
+	region       string
+	instanceType string
+}
+
+// unknownInstanceTypeRetries tracks the negative lookup results of MachineDeployments, so that they are retried
+// against the EC2 API instead of the cached instance types of the region, which do not change until the cache
+// expires. An instance type is fetched again at most once per interval, however many MachineDeployments
+// reference it. Access is synchronized via mutex.
+type unknownInstanceTypeRetries struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	results  map[types.NamespacedName]negativeLookup
+	// refetched holds the time unknown instance types were last fetched again.
+	refetched map[negativeLookupKey]time.Time
+	now       func() time.Time
+}
+
+// negativeLookupKey identifies an instance type of a region.
+type negativeLookupKey struct {
+	region       string
+	instanceType string
+}
+
+func newUnknownInstanceTypeRetries(interval time.Duration) *unknownInstanceTypeRetries {
+	return &unknownInstanceTypeRetries{
+		interval:  interval,
+		results:   map[types.NamespacedName]negativeLookup{},
+		refetched: map[negativeLookupKey]time.Time{},
+		now:       time.Now,
+	}
+}
+
+// due returns true if the instance type of the lookup was found unknown for the MachineDeployment before and was
+// not fetched again within the interval. A negative result of another template, template generation or region
+// is cleared, the lookup is not retried but made anew.
+func (u *unknownInstanceTypeRetries) due(key types.NamespacedName, lookup negativeLookup) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	result, ok := u.results[key]
+	if !ok {
+		return false
+	}
+	if result != lookup {
+		u.forgetLocked(key)
+		return false
+	}
+	instanceType := negativeLookupKey{region: lookup.region, instanceType: lookup.instanceType}
+	now := u.now()
+	if refetched, ok := u.refetched[instanceType]; ok && now.Sub(refetched) < u.interval {
+		return false
+	}
+	u.refetched[instanceType] = now
+	return true
+}
+
+// record records that the instance type of the lookup is unknown for the MachineDeployment.
+func (u *unknownInstanceTypeRetries) record(key types.NamespacedName, lookup negativeLookup) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	result, ok := u.results[key]
+	u.results[key] = lookup
+	if ok && result != lookup {
+		u.pruneLocked(result)
+	}
+}
+
+// forget removes the negative result of the MachineDeployment, e.g. once its instance type is known or it was deleted.
+func (u *unknownInstanceTypeRetries) forget(key types.NamespacedName) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.forgetLocked(key)
+}
+
+func (u *unknownInstanceTypeRetries) forgetLocked(key types.NamespacedName) {
+	result, ok := u.results[key]
+	if !ok {
+		return
+	}
+	delete(u.results, key)
+	u.pruneLocked(result)
+}
+
+// pruneLocked removes the refetch time of the instance type of the lookup once no MachineDeployment references it.
+func (u *unknownInstanceTypeRetries) pruneLocked(lookup negativeLookup) {
+	for _, other := range u.results {
+		if other.region == lookup.region && other.instanceType == lookup.instanceType {
+			return
+		}
+	}
+	delete(u.refetched, negativeLookupKey{region: lookup.region, instanceType: lookup.instanceType})
+}
@@ -0,0 +1,122 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awsclient "github.com/jhjaggars/capa-annotator/pkg/client"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// countingInstanceTypesClient counts the DescribeInstanceTypes calls.
+type countingInstanceTypesClient struct {
+	awsclient.Client
+	calls int
+}
+
+func (c *countingInstanceTypesClient) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	c.calls++
+	return c.Client.DescribeInstanceTypes(input)
+}
+
+func TestUnknownInstanceTypeRetries(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	retries := newUnknownInstanceTypeRetries(10 * time.Minute)
+	retries.now = func() time.Time { return now }
+	workers := types.NamespacedName{Namespace: "default", Name: "workers"}
+	gpus := types.NamespacedName{Namespace: "default", Name: "gpus"}
+	lookup := negativeLookup{template: "uid", generation: 1, region: "us-east-1", instanceType: "m9.large"}
+
+	// Lookups without a negative result are not retried
+	g.Expect(retries.due(workers, lookup)).To(BeFalse())
+
+	// The instance type is fetched again once per interval for all MachineDeployments referencing it
+	retries.record(workers, lookup)
+	retries.record(gpus, lookup)
+	g.Expect(retries.due(workers, lookup)).To(BeTrue())
+	g.Expect(retries.due(gpus, lookup)).To(BeFalse())
+	now = now.Add(10 * time.Minute)
+	g.Expect(retries.due(gpus, lookup)).To(BeTrue())
+
+	// A changed template clears the negative result
+	changed := lookup
+	changed.generation = 2
+	g.Expect(retries.due(workers, changed)).To(BeFalse())
+	g.Expect(retries.results).ToNot(HaveKey(workers))
+	g.Expect(retries.refetched).To(HaveLen(1))
+
+	retries.forget(gpus)
+	g.Expect(retries.results).To(BeEmpty())
+	g.Expect(retries.refetched).To(BeEmpty())
+}
+
+func TestReconcileWithUnknownInstanceTypeRetries(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "invalid", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "unknown"
+
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.UnknownInstanceTypeRetryInterval = 10 * time.Minute
+	r.unknownRetries = newUnknownInstanceTypeRetries(r.UnknownInstanceTypeRetryInterval)
+	awsClient, err := r.AwsClientBuilder(nil, "", "", "", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	counting := &countingInstanceTypesClient{Client: awsClient}
+	r.AwsClientBuilder = func(client.Client, string, string, string, awsclient.RegionCache) (awsclient.Client, error) {
+		return counting, nil
+	}
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+
+	result, err := r.Reconcile(ctx, req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(10 * time.Minute))
+	g.Expect(counting.calls).To(Equal(1))
+
+	// The retry fetches the instance type again instead of serving the cached region
+	result, err = r.Reconcile(ctx, req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(10 * time.Minute))
+	g.Expect(counting.calls).To(Equal(2))
+
+	// Reconciles within the interval are served from the cache
+	result, err = r.Reconcile(ctx, req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(10 * time.Minute))
+	g.Expect(counting.calls).To(Equal(2))
+
+	// Changing the template to a known instance type clears the negative result
+	known := awsMachineTemplate.DeepCopy()
+	known.ResourceVersion = ""
+	known.Name = "known"
+	known.UID = "known"
+	known.Spec.Template.Spec.InstanceType = "a1.2xlarge"
+	g.Expect(r.Client.Create(ctx, known)).To(Succeed())
+	g.Expect(r.Client.Get(ctx, req.NamespacedName, machineDeployment)).To(Succeed())
+	machineDeployment.Spec.Template.Spec.InfrastructureRef.Name = known.Name
+	g.Expect(r.Client.Update(ctx, machineDeployment)).To(Succeed())
+
+	result, err = r.Reconcile(ctx, req)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(BeZero())
+	g.Expect(r.unknownRetries.results).To(BeEmpty())
+}
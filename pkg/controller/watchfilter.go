@@ -0,0 +1,82 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	capilabels "sigs.k8s.io/cluster-api/util/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// matchesWatchFilter returns whether the object is reconciled by this instance, i.e. whether its
+// cluster.x-k8s.io/watch-filter label matches the WatchFilterValue. Without a value all objects match.
+func (r *Reconciler) matchesWatchFilter(obj client.Object) bool {
+	return r.WatchFilterValue == "" || capilabels.HasWatchLabel(obj, r.WatchFilterValue)
+}
+
+// watchFilterPredicate filters the events of objects not matching the watch filter, like the
+// ResourceHasFilterLabel predicate of the Cluster API controllers.
+func (r *Reconciler) watchFilterPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(r.matchesWatchFilter)
+}
+
+// matchesSelector returns whether the object matches the MachineDeploymentSelector. Without a selector all
+// objects match.
+func (r *Reconciler) matchesSelector(obj client.Object) bool {
+	return r.MachineDeploymentSelector == nil || r.MachineDeploymentSelector.Matches(labels.Set(obj.GetLabels()))
+}
+
+// selectorPredicate filters the events of objects not matching the MachineDeploymentSelector. The manager's cache
+// is usually restricted to the selector as well, so that the objects of other teams are not even read.
+func (r *Reconciler) selectorPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(r.matchesSelector)
+}
+
+// ParseNamespacePatterns parses a comma-separated list of namespace patterns, in the syntax of path.Match.
+func ParseNamespacePatterns(value string) ([]string, error) {
+	patterns := []string{}
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// namespaceExcluded returns whether the namespace of the object matches one of the ExcludedNamespaces.
+func (r *Reconciler) namespaceExcluded(obj client.Object) bool {
+	for _, pattern := range r.ExcludedNamespaces {
+		if matched, _ := path.Match(pattern, obj.GetNamespace()); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// excludedNamespacesPredicate filters the events of objects in the ExcludedNamespaces.
+func (r *Reconciler) excludedNamespacesPredicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return !r.namespaceExcluded(obj)
+	})
+}
@@ -0,0 +1,130 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/labels"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestWatchFilter(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "partitioned"
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.WatchFilterValue = "team-a"
+	request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+
+	// Events of MachineDeployments of other instances are filtered
+	g.Expect(r.watchFilterPredicate().Create(event.CreateEvent{Object: machineDeployment})).To(BeFalse())
+
+	// And they are not annotated if enqueued otherwise, e.g. through their template
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	got := &clusterv1.MachineDeployment{}
+	g.Expect(r.Client.Get(ctx, request.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Annotations).ToNot(HaveKey(cpuKey))
+
+	got.Labels = map[string]string{clusterv1.WatchLabel: "team-a"}
+	g.Expect(r.Client.Update(ctx, got)).To(Succeed())
+	g.Expect(r.watchFilterPredicate().Create(event.CreateEvent{Object: got})).To(BeTrue())
+
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(r.Client.Get(ctx, request.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+
+	// Without a value all MachineDeployments are reconciled
+	r.WatchFilterValue = ""
+	g.Expect(r.watchFilterPredicate().Create(event.CreateEvent{Object: machineDeployment})).To(BeTrue())
+}
+
+func TestMachineDeploymentSelector(t *testing.T) {
+	g := NewWithT(t)
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "selected"
+	machineDeployment.Labels = map[string]string{"team": "b"}
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.MachineDeploymentSelector, err = labels.Parse("team notin (b)")
+	g.Expect(err).ToNot(HaveOccurred())
+	request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+
+	g.Expect(r.selectorPredicate().Create(event.CreateEvent{Object: machineDeployment})).To(BeFalse())
+
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	got := &clusterv1.MachineDeployment{}
+	g.Expect(r.Client.Get(ctx, request.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Annotations).ToNot(HaveKey(cpuKey))
+
+	// MachineDeployments not matching the selector are not in scope of the annotation health
+	health, err := r.BuildAnnotationHealth(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(health.Total).To(BeZero())
+
+	got.Labels["team"] = "a"
+	g.Expect(r.Client.Update(ctx, got)).To(Succeed())
+	g.Expect(r.selectorPredicate().Create(event.CreateEvent{Object: got})).To(BeTrue())
+
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(r.Client.Get(ctx, request.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+}
+
+func TestExcludedNamespaces(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := ParseNamespacePatterns("capi-system,[")
+	g.Expect(err).To(HaveOccurred())
+	patterns, err := ParseNamespacePatterns(" capi-system, tenant-* ,")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(patterns).To(Equal([]string{"capi-system", "tenant-*"}))
+
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("tenant-a", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	machineDeployment.Name = "excluded"
+	r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+	r.ExcludedNamespaces = patterns
+	request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(machineDeployment)}
+
+	g.Expect(r.excludedNamespacesPredicate().Create(event.CreateEvent{Object: machineDeployment})).To(BeFalse())
+
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	got := &clusterv1.MachineDeployment{}
+	g.Expect(r.Client.Get(ctx, request.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Annotations).ToNot(HaveKey(cpuKey))
+
+	health, err := r.BuildAnnotationHealth(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(health.Total).To(BeZero())
+
+	r.ExcludedNamespaces = []string{"capi-system"}
+	g.Expect(r.excludedNamespacesPredicate().Create(event.CreateEvent{Object: machineDeployment})).To(BeTrue())
+	_, err = r.Reconcile(ctx, request)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(r.Client.Get(ctx, request.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+}
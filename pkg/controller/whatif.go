@@ -0,0 +1,49 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+)
+
+// WhatIf returns the annotations the controller would write for a MachineDeployment using the given
+// instance type in the given region, without reading or modifying any MachineDeployment.
+// This allows evaluating node group changes before applying them.
+func (r *Reconciler) WhatIf(region, instanceType string) (map[string]string, error) {
+	if region == "" {
+		return nil, fmt.Errorf("region must be specified")
+	}
+	if instanceType == "" {
+		return nil, fmt.Errorf("instance type must be specified")
+	}
+
+	awsClient, err := r.AwsClientBuilder(r.Client, "", "", region, r.RegionCache)
+	if err != nil {
+		return nil, fmt.Errorf("error creating aws client: %w", err)
+	}
+
+	instanceTypeInfo, err := r.InstanceTypesCache.GetInstanceType(awsClient, region, instanceType)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up instance type %s in region %s: %w", instanceType, region, err)
+	}
+
+	capacity, err := r.postProcessCapacity(nil, instanceTypeInfo)
+	if err != nil {
+		return nil, fmt.Errorf("unable to post-process capacity of instance type %s: %w", instanceType, err)
+	}
+
+	annotations := map[string]string{}
+	r.setCapacityAnnotations(annotations, capacity, region, nil)
+	return annotations, nil
+}
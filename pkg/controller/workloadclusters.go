@@ -0,0 +1,225 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/controllers/remote"
+	"sigs.k8s.io/cluster-api/util/secret"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// workloadClusterSourceName is the user agent of the clients of workload clusters.
+const workloadClusterSourceName = "capa-annotator"
+
+// WorkloadClusters annotates the MachineDeployments of workload clusters running their own Cluster API instances,
+// e.g. in hub-and-spoke topologies. The workload clusters of the Clusters matching Selector are connected with
+// the kubeconfig Secrets of the Clusters, and all their MachineDeployments are annotated once per Interval like
+// by ReconcileAll, with a copy of Reconciler reading the templates and AWSClusters from the workload cluster.
+// It implements the controller-runtime Runnable interface and runs on the leader only.
+type WorkloadClusters struct {
+	// Reconciler is the configuration of the reconcilers of the workload clusters. Its caches and AWS clients
+	// are shared, its client, event recorder and trackers are not.
+	Reconciler *Reconciler
+	// Selector selects the Clusters whose workload clusters are annotated.
+	Selector labels.Selector
+	// Interval is the interval between two passes over the workload clusters.
+	Interval time.Duration
+
+	client client.Client
+	reader client.Reader
+	scheme *runtime.Scheme
+	qps    float32
+	burst  int
+
+	// clusters are the connected workload clusters by the key of their Cluster.
+	clusters map[client.ObjectKey]*workloadCluster
+	// connect returns the client and event broadcaster of a workload cluster. Defaults to connecting with the
+	// kubeconfig Secret of the Cluster.
+	connect func(ctx context.Context, cluster client.ObjectKey) (client.Client, record.EventBroadcaster, error)
+}
+
+// workloadCluster is a connected workload cluster.
+type workloadCluster struct {
+	reconciler  *Reconciler
+	broadcaster record.EventBroadcaster
+	// kubeconfigVersion is the resource version of the kubeconfig Secret connected with, the workload cluster is
+	// connected again once the Secret is rotated.
+	kubeconfigVersion string
+}
+
+// SetupWithManager adds the workload clusters to the manager.
+func (w *WorkloadClusters) SetupWithManager(mgr ctrl.Manager) error {
+	w.client = mgr.GetClient()
+	// The kubeconfig Secrets are read directly instead of caching all Secrets
+	w.reader = mgr.GetAPIReader()
+	w.scheme = mgr.GetScheme()
+	w.qps = mgr.GetConfig().QPS
+	w.burst = mgr.GetConfig().Burst
+	if err := mgr.Add(w); err != nil {
+		return fmt.Errorf("failed adding the workload clusters: %w", err)
+	}
+	return nil
+}
+
+// Start annotates the workload clusters immediately and then once per interval until the context is cancelled.
+func (w *WorkloadClusters) Start(ctx context.Context) error {
+	if w.connect == nil {
+		w.connect = w.connectWithKubeconfig
+	}
+	w.clusters = map[client.ObjectKey]*workloadCluster{}
+	defer func() {
+		for _, cluster := range w.clusters {
+			cluster.broadcaster.Shutdown()
+		}
+	}()
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.annotate(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// annotate annotates the MachineDeployments of all selected workload clusters in sequence and disconnects the
+// workload clusters that are no longer selected.
+func (w *WorkloadClusters) annotate(ctx context.Context) {
+	clusters := &clusterv1.ClusterList{}
+	if err := w.client.List(ctx, clusters, client.MatchingLabelsSelector{Selector: w.Selector}); err != nil {
+		klog.Errorf("Failed to list the Clusters of workload clusters: %v", err)
+		return
+	}
+
+	selected := map[client.ObjectKey]bool{}
+	for i := range clusters.Items {
+		cluster := &clusters.Items[i]
+		// The kubeconfig Secret is only written once the control plane is ready
+		if !cluster.DeletionTimestamp.IsZero() || !cluster.Status.ControlPlaneReady {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		key := client.ObjectKeyFromObject(cluster)
+		selected[key] = true
+
+		workload, err := w.workloadCluster(ctx, key)
+		if err != nil {
+			klog.Errorf("Failed to connect to workload cluster %s: %v", key, err)
+			continue
+		}
+		summary, err := workload.reconciler.ReconcileAll(ctx)
+		if err != nil {
+			klog.Errorf("Failed to annotate the MachineDeployments of workload cluster %s: %v", key, err)
+			continue
+		}
+		klog.V(2).Infof("Annotated %d MachineDeployment(s) of workload cluster %s, skipped %d, failed %d", summary.Annotated, key, summary.Skipped, len(summary.Failed))
+		for _, failure := range summary.Failed {
+			klog.Warningf("Failed to annotate MachineDeployment %s/%s of workload cluster %s: %s: %s", failure.Namespace, failure.Name, key, failure.Result, failure.Error)
+		}
+	}
+
+	for key, cluster := range w.clusters {
+		if !selected[key] {
+			cluster.broadcaster.Shutdown()
+			delete(w.clusters, key)
+			klog.V(2).Infof("Disconnected from workload cluster %s", key)
+		}
+	}
+}
+
+// workloadCluster returns the connected workload cluster of a Cluster, connecting to it if it is not connected
+// yet or its kubeconfig Secret changed.
+func (w *WorkloadClusters) workloadCluster(ctx context.Context, key client.ObjectKey) (*workloadCluster, error) {
+	kubeconfig := &corev1.Secret{}
+	kubeconfigKey := client.ObjectKey{Namespace: key.Namespace, Name: secret.Name(key.Name, secret.Kubeconfig)}
+	if err := w.reader.Get(ctx, kubeconfigKey, kubeconfig); err != nil {
+		return nil, fmt.Errorf("failed to get kubeconfig Secret %s: %w", kubeconfigKey, err)
+	}
+	if cluster, ok := w.clusters[key]; ok {
+		if cluster.kubeconfigVersion == kubeconfig.ResourceVersion {
+			return cluster, nil
+		}
+		cluster.broadcaster.Shutdown()
+		delete(w.clusters, key)
+	}
+
+	remoteClient, broadcaster, err := w.connect(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	cluster := &workloadCluster{
+		reconciler:        w.Reconciler.forWorkloadCluster(key, remoteClient, broadcaster.NewRecorder(w.scheme, corev1.EventSource{Component: "machinedeployment-controller"}), w.scheme),
+		broadcaster:       broadcaster,
+		kubeconfigVersion: kubeconfig.ResourceVersion,
+	}
+	w.clusters[key] = cluster
+	klog.V(2).Infof("Connected to workload cluster %s", key)
+	return cluster, nil
+}
+
+// connectWithKubeconfig connects to the workload cluster of a Cluster with its kubeconfig Secret. The events of
+// the workload cluster are recorded in the workload cluster.
+func (w *WorkloadClusters) connectWithKubeconfig(ctx context.Context, key client.ObjectKey) (client.Client, record.EventBroadcaster, error) {
+	config, err := remote.RESTConfig(ctx, workloadClusterSourceName, w.reader, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The workload clusters are rate limited like the management cluster
+	config.QPS = w.qps
+	config.Burst = w.burst
+	remoteClient, err := client.New(config, client.Options{Scheme: w.scheme})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create event client: %w", err)
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return remoteClient, broadcaster, nil
+}
+
+// forWorkloadCluster returns a copy of the reconciler for the MachineDeployments of a workload cluster, sharing
+// the caches and AWS clients but with its own client, event recorder and trackers.
+func (r *Reconciler) forWorkloadCluster(key client.ObjectKey, c client.Client, recorder record.EventRecorder, scheme *runtime.Scheme) *Reconciler {
+	workload := *r
+	workload.Client = c
+	workload.Log = r.Log.WithValues("workloadCluster", key.String())
+	// Instance types becoming available are only polled for the management cluster
+	workload.unknownInstanceTypes = nil
+	workload.observe = nil
+	workload.setupTrackers(recorder, scheme)
+	return &workload
+}
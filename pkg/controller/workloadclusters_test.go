@@ -0,0 +1,103 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestWorkloadClusters(t *testing.T) {
+	g := NewWithT(t)
+
+	// The workload cluster runs its own Cluster API instance
+	machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("workload", "a1.2xlarge", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	workload := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+
+	spoke := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "spoke", Namespace: "fleet", Labels: map[string]string{"hub": "spoke"}},
+		Status:     clusterv1.ClusterStatus{ControlPlaneReady: true},
+	}
+	provisioning := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "provisioning", Namespace: "fleet", Labels: map[string]string{"hub": "spoke"}},
+	}
+	kubeconfig := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "spoke-kubeconfig", Namespace: "fleet"}}
+	management := fake.NewClientBuilder().WithScheme(workload.Client.Scheme()).WithObjects(spoke, provisioning, kubeconfig).Build()
+
+	connects := 0
+	w := &WorkloadClusters{
+		Reconciler: workload,
+		Selector:   labels.SelectorFromSet(labels.Set{"hub": "spoke"}),
+		client:     management,
+		reader:     management,
+		scheme:     management.Scheme(),
+		clusters:   map[client.ObjectKey]*workloadCluster{},
+		connect: func(_ context.Context, key client.ObjectKey) (client.Client, record.EventBroadcaster, error) {
+			g.Expect(key).To(Equal(client.ObjectKeyFromObject(spoke)))
+			connects++
+			return workload.Client, record.NewBroadcaster(), nil
+		},
+	}
+
+	w.annotate(ctx)
+	g.Expect(connects).To(Equal(1))
+	g.Expect(w.clusters).To(HaveLen(1))
+	stored := &clusterv1.MachineDeployment{}
+	g.Expect(workload.Client.Get(ctx, client.ObjectKeyFromObject(machineDeployment), stored)).To(Succeed())
+	g.Expect(stored.Annotations).To(HaveKeyWithValue(cpuKey, "8"))
+
+	// The connection is reused until the kubeconfig is rotated
+	w.annotate(ctx)
+	g.Expect(connects).To(Equal(1))
+	g.Expect(management.Get(ctx, client.ObjectKeyFromObject(kubeconfig), kubeconfig)).To(Succeed())
+	kubeconfig.Data = map[string][]byte{"value": []byte("rotated")}
+	g.Expect(management.Update(ctx, kubeconfig)).To(Succeed())
+	w.annotate(ctx)
+	g.Expect(connects).To(Equal(2))
+
+	// Clusters no longer selected are disconnected
+	g.Expect(management.Get(ctx, client.ObjectKeyFromObject(spoke), spoke)).To(Succeed())
+	spoke.Labels = nil
+	g.Expect(management.Update(ctx, spoke)).To(Succeed())
+	w.annotate(ctx)
+	g.Expect(w.clusters).To(BeEmpty())
+}
+
+func TestForWorkloadCluster(t *testing.T) {
+	g := NewWithT(t)
+
+	r := newTestReconciler(g)
+	r.RetryBudget = RetryBudget{Attempts: 3, Window: time.Hour, Backoff: time.Hour}
+	r.retries = newRetryTracker(r.RetryBudget)
+	r.unknownInstanceTypes = newUnknownInstanceTypes()
+
+	workloadClient := fake.NewClientBuilder().WithScheme(r.Client.Scheme()).Build()
+	workload := r.forWorkloadCluster(client.ObjectKey{Namespace: "fleet", Name: "spoke"}, workloadClient, record.NewFakeRecorder(1), r.Client.Scheme())
+	g.Expect(workload.Client).To(BeIdenticalTo(workloadClient))
+	g.Expect(workload.InstanceTypesCache).To(BeIdenticalTo(r.InstanceTypesCache))
+	g.Expect(workload.retries).ToNot(BeNil())
+	g.Expect(workload.retries).ToNot(BeIdenticalTo(r.retries))
+	g.Expect(workload.unknownInstanceTypes).To(BeNil())
+}
@@ -0,0 +1,40 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+const (
+	zoneLabelKey   = "topology.kubernetes.io/zone"
+	zoneIDLabelKey = "topology.k8s.aws/zone-id"
+)
+
+// pinnedFailureDomain returns the failure domain a MachineDeployment pins all its machines to,
+// or an empty string if the machines may be spread across failure domains.
+func pinnedFailureDomain(machineDeployment *clusterv1.MachineDeployment) string {
+	return aws.StringValue(machineDeployment.Spec.Template.Spec.FailureDomain)
+}
+
+// zoneLabels returns the topology labels of the zone, so that zonal topology spread constraints
+// can be satisfied by a node group scaled to zero.
+func zoneLabels(zone, zoneID string) map[string]string {
+	labels := map[string]string{zoneLabelKey: zone}
+	if zoneID != "" {
+		labels[zoneIDLabelKey] = zoneID
+	}
+	return labels
+}
@@ -0,0 +1,83 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/ptr"
+)
+
+func TestReconcileWithFailureDomain(t *testing.T) {
+	testCases := []struct {
+		name           string
+		failureDomain  *string
+		labels         string
+		expectedLabels string
+	}{
+		{
+			name:           "not pinned",
+			expectedLabels: "kubernetes.io/arch=amd64",
+		},
+		{
+			name:           "un-pinned",
+			labels:         "node-role=worker,topology.k8s.aws/zone-id=use1-az6,topology.kubernetes.io/zone=us-east-1a",
+			expectedLabels: "kubernetes.io/arch=amd64,node-role=worker",
+		},
+		{
+			name:           "pinned to a zone",
+			failureDomain:  ptr.To("us-east-1a"),
+			expectedLabels: "kubernetes.io/arch=amd64,topology.k8s.aws/zone-id=use1-az6,topology.kubernetes.io/zone=us-east-1a",
+		},
+		{
+			name:           "pinned to an unknown zone",
+			failureDomain:  ptr.To("us-east-1z"),
+			expectedLabels: "kubernetes.io/arch=amd64,topology.kubernetes.io/zone=us-east-1z",
+		},
+		{
+			name:           "re-pinned to an unknown zone",
+			failureDomain:  ptr.To("us-east-1z"),
+			labels:         "kubernetes.io/arch=amd64,topology.k8s.aws/zone-id=use1-az6,topology.kubernetes.io/zone=us-east-1a",
+			expectedLabels: "kubernetes.io/arch=amd64,topology.kubernetes.io/zone=us-east-1z",
+		},
+		{
+			name:           "pinned with user-provided labels",
+			failureDomain:  ptr.To("us-east-1b"),
+			labels:         "node-role=worker,topology.kubernetes.io/zone=us-east-1a",
+			expectedLabels: "kubernetes.io/arch=amd64,node-role=worker,topology.k8s.aws/zone-id=use1-az1,topology.kubernetes.io/zone=us-east-1b",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			annotations := map[string]string{}
+			if tc.labels != "" {
+				annotations[labelsKey] = tc.labels
+			}
+			machineDeployment, awsMachineTemplate, cluster, awsCluster, err := newTestMachineDeployment("default", "a1.2xlarge", annotations)
+			g.Expect(err).ToNot(HaveOccurred())
+			machineDeployment.Name = "zonal"
+			machineDeployment.Spec.Template.Spec.FailureDomain = tc.failureDomain
+
+			r := newTestReconciler(g, machineDeployment, awsMachineTemplate, cluster, awsCluster)
+
+			_, err = r.reconcile(ctx, machineDeployment)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(machineDeployment.Annotations).To(HaveKeyWithValue(labelsKey, tc.expectedLabels))
+		})
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package errors defines the errors of the resolution and lookup failures of the controller, so that library
+// consumers and the reconciler can branch on them with errors.Is and errors.As instead of matching messages.
+package errors
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidReference is returned for missing or unexpected object references, e.g. an infrastructureRef
+	// of a MachineDeployment that is not an AWSMachineTemplate.
+	ErrInvalidReference = errors.New("invalid reference")
+	// ErrInstanceTypeNotSet is returned for templates without an instance type.
+	ErrInstanceTypeNotSet = errors.New("instance type not set")
+	// ErrRegionNotResolved is returned if the AWS region of a MachineDeployment cannot be determined.
+	ErrRegionNotResolved = errors.New("region not resolved")
+	// ErrUnknownInstanceType is returned for instance types not offered in the region.
+	ErrUnknownInstanceType = errors.New("unknown instance type")
+	// ErrCrossNamespaceReference is returned for template references denied by the cross-namespace template policy.
+	ErrCrossNamespaceReference = errors.New("cross-namespace reference not allowed")
+	// ErrInstanceTypeNotAllowed is returned for instance types denied by the instance type policy.
+	ErrInstanceTypeNotAllowed = errors.New("instance type not allowed")
+	// ErrIdentityNotAllowed is returned for AWS cluster identities whose allowedNamespaces exclude the namespace.
+	ErrIdentityNotAllowed = errors.New("identity not allowed")
+	// ErrUnsupportedIdentity is returned for identityRefs of unknown kinds.
+	ErrUnsupportedIdentity = errors.New("unsupported identity")
+)
+
+// reasonError is an error of one of the sentinel errors. Its message is the one of the wrapped error.
+type reasonError struct {
+	reason error
+	err    error
+}
+
+func (e *reasonError) Error() string {
+	return e.err.Error()
+}
+
+func (e *reasonError) Unwrap() []error {
+	return []error{e.reason, e.err}
+}
+
+// Errorf formats an error like fmt.Errorf that additionally matches the reason, one of the sentinel errors of this
+// package, with errors.Is. The message is not prefixed with the reason.
+func Errorf(reason error, format string, args ...any) error {
+	return &reasonError{reason: reason, err: fmt.Errorf(format, args...)}
+}
+
+// LookupError is returned if an object cannot be fetched. It wraps the error of the client, so that
+// apierrors.IsNotFound applies to it.
+type LookupError struct {
+	// Kind is the kind of the object, e.g. AWSMachineTemplate.
+	Kind string
+	// Namespace is the namespace of the object, empty for cluster-scoped objects.
+	Namespace string
+	// Name is the name of the object.
+	Name string
+	// Err is the error of the client.
+	Err error
+}
+
+// NewLookupError returns a LookupError of the object.
+func NewLookupError(kind, namespace, name string, err error) error {
+	return &LookupError{Kind: kind, Namespace: namespace, Name: name, Err: err}
+}
+
+func (e *LookupError) Error() string {
+	if e.Namespace == "" {
+		return fmt.Sprintf("failed to fetch %s %s: %v", e.Kind, e.Name, e.Err)
+	}
+	return fmt.Sprintf("failed to fetch %s %s/%s: %v", e.Kind, e.Namespace, e.Name, e.Err)
+}
+
+func (e *LookupError) Unwrap() error {
+	return e.Err
+}
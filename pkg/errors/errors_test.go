@@ -0,0 +1,56 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestErrorf(t *testing.T) {
+	g := NewWithT(t)
+
+	cause := errors.New("cause")
+	err := Errorf(ErrUnknownInstanceType, "instance type %q not found: %w", "m5.large", cause)
+	g.Expect(err).To(MatchError(`instance type "m5.large" not found: cause`))
+	g.Expect(errors.Is(err, ErrUnknownInstanceType)).To(BeTrue())
+	g.Expect(errors.Is(err, cause)).To(BeTrue())
+	g.Expect(errors.Is(err, ErrRegionNotResolved)).To(BeFalse())
+
+	// The reason survives further wrapping
+	g.Expect(errors.Is(fmt.Errorf("reconcile: %w", err), ErrUnknownInstanceType)).To(BeTrue())
+}
+
+func TestLookupError(t *testing.T) {
+	g := NewWithT(t)
+
+	notFound := apierrors.NewNotFound(schema.GroupResource{Resource: "awsmachinetemplates"}, "workers")
+	err := fmt.Errorf("resolve: %w", NewLookupError("AWSMachineTemplate", "default", "workers", notFound))
+	g.Expect(err).To(MatchError(`resolve: failed to fetch AWSMachineTemplate default/workers: awsmachinetemplates "workers" not found`))
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+	var lookupErr *LookupError
+	g.Expect(errors.As(err, &lookupErr)).To(BeTrue())
+	g.Expect(lookupErr.Kind).To(Equal("AWSMachineTemplate"))
+	g.Expect(lookupErr.Namespace).To(Equal("default"))
+	g.Expect(lookupErr.Name).To(Equal("workers"))
+
+	g.Expect(NewLookupError("AWSClusterStaticIdentity", "", "static", notFound)).
+		To(MatchError(`failed to fetch AWSClusterStaticIdentity static: awsmachinetemplates "workers" not found`))
+}
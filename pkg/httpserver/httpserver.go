@@ -0,0 +1,231 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package httpserver serves the metrics, health and profiling endpoints on multiple listeners, e.g. on both an
+// IPv4 and an IPv6 address, or on sockets passed by systemd socket activation.
+package httpserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+// Listen opens a TCP listener on each of the comma-separated addresses, e.g. "0.0.0.0:8080,[::]:8080".
+// An empty address or "0" disables the endpoint and returns no listeners.
+func Listen(addresses string) ([]net.Listener, error) {
+	listeners := []net.Listener{}
+	for _, address := range strings.Split(addresses, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" || address == "0" {
+			continue
+		}
+
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			closeAll(listeners)
+			return nil, fmt.Errorf("error listening on %s: %w", address, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// ActivatedListeners returns the listeners passed by systemd socket activation, grouped by the name set via
+// FileDescriptorName= in the socket unit. The socket activation environment variables are unset, so that
+// they are not inherited by child processes.
+func ActivatedListeners() (map[string][]net.Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	return activatedListeners(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"), listenFDsStart)
+}
+
+// activatedListeners creates listeners from the socket activation environment, starting at file descriptor firstFD.
+func activatedListeners(listenPID, listenFDs, listenFDNames string, firstFD int) (map[string][]net.Listener, error) {
+	if listenPID == "" || listenFDs == "" {
+		return nil, errors.New("no sockets passed by socket activation, LISTEN_PID and LISTEN_FDS are not set")
+	}
+
+	pid, err := strconv.Atoi(listenPID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_PID %q: %w", listenPID, err)
+	}
+	if pid != os.Getpid() {
+		return nil, fmt.Errorf("sockets passed by socket activation are meant for process %d", pid)
+	}
+
+	count, err := strconv.Atoi(listenFDs)
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", listenFDs)
+	}
+
+	names := strings.Split(listenFDNames, ":")
+	listeners := map[string][]net.Listener{}
+	for i := 0; i < count; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(firstFD+i), name)
+		listener, err := net.FileListener(file)
+		// FileListener duplicates the file descriptor
+		_ = file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				closeAll(l)
+			}
+			return nil, fmt.Errorf("socket %d (%s) is not a listening socket: %w", firstFD+i, name, err)
+		}
+		listeners[name] = append(listeners[name], listener)
+	}
+	return listeners, nil
+}
+
+// closeAll closes the listeners.
+func closeAll(listeners []net.Listener) {
+	for _, listener := range listeners {
+		_ = listener.Close()
+	}
+}
+
+// MetricsHandler serves the controller-runtime metrics registry at /metrics and the extra handlers at their paths.
+func MetricsHandler(extraHandlers map[string]http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{
+		ErrorHandling: promhttp.HTTPErrorOnError,
+	}))
+	for path, handler := range extraHandlers {
+		mux.Handle(path, handler)
+	}
+	return mux
+}
+
+// BearerTokenHandler requires requests to the handler to present the token read from tokenFile as bearer
+// token. The file is read on every request, so that a rotated token, e.g. of a mounted Secret, takes effect
+// without a restart. Requests are rejected if the file cannot be read or is empty.
+func BearerTokenHandler(tokenFile string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := os.ReadFile(tokenFile)
+		token := strings.TrimSpace(string(data))
+		if err != nil || token == "" {
+			klog.Errorf("Error reading the bearer token file %s: %v", tokenFile, err)
+			http.Error(w, "bearer token is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		presented, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// HealthHandler serves the checks at /healthz and /readyz, including the individual checks at /healthz/<name>.
+func HealthHandler(healthzChecks, readyzChecks map[string]healthz.Checker) http.Handler {
+	mux := http.NewServeMux()
+	for endpoint, checks := range map[string]map[string]healthz.Checker{"/healthz": healthzChecks, "/readyz": readyzChecks} {
+		handler := http.StripPrefix(endpoint, &healthz.Handler{Checks: checks})
+		mux.Handle(endpoint, handler)
+		mux.Handle(endpoint+"/", handler)
+	}
+	return mux
+}
+
+// ProfilingHandler serves the runtime profiles of net/http/pprof at /debug/pprof/.
+func ProfilingHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Server serves a handler on a set of listeners until the context is cancelled.
+// It implements the controller-runtime Runnable interface.
+type Server struct {
+	// Name is used in log messages.
+	Name string
+	// Handler serves the requests.
+	Handler http.Handler
+	// Listeners are the listeners the handler is served on.
+	Listeners []net.Listener
+}
+
+// Start serves the handler on all listeners. It returns once the context is cancelled or any listener fails.
+func (s *Server) Start(ctx context.Context) error {
+	if len(s.Listeners) == 0 {
+		return nil
+	}
+
+	server := &http.Server{
+		Handler:           s.Handler,
+		ReadHeaderTimeout: 32 * time.Second,
+	}
+
+	errs := make(chan error, len(s.Listeners))
+	for _, listener := range s.Listeners {
+		klog.Infof("Serving %s on %s", s.Name, listener.Addr())
+		go func(listener net.Listener) {
+			errs <- server.Serve(listener)
+		}(listener)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+		err = shutdownErr
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error serving %s: %w", s.Name, err)
+	}
+	return nil
+}
+
+// NeedLeaderElection returns false, the endpoints are served on every replica.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
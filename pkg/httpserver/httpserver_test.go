@@ -0,0 +1,196 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+func TestListen(t *testing.T) {
+	g := NewWithT(t)
+
+	listeners, err := Listen("0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listeners).To(BeEmpty())
+
+	addresses := "127.0.0.1:0"
+	if listener, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		_ = listener.Close()
+		addresses += ", [::1]:0"
+	}
+
+	listeners, err = Listen(addresses)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	server := &Server{
+		Name: "test",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}),
+		Listeners: listeners,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- server.Start(ctx)
+	}()
+
+	// The handler is served on every listener
+	for _, listener := range listeners {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		g.Expect(err).ToNot(HaveOccurred())
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		g.Expect(string(body)).To(Equal("ok"))
+	}
+
+	cancel()
+	g.Expect(<-done).To(Succeed())
+
+	_, err = Listen("127.0.0.1:0,not-an-address")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestActivatedListeners(t *testing.T) {
+	g := NewWithT(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer listener.Close()
+
+	// Pass a duplicate of the listening socket the way systemd passes its sockets
+	file, err := listener.(*net.TCPListener).File()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer file.Close()
+	fd := int(file.Fd())
+
+	pid := strconv.Itoa(os.Getpid())
+
+	_, err = activatedListeners("", "", "", fd)
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = activatedListeners("1", "1", "metrics", fd)
+	g.Expect(err).To(MatchError(ContainSubstring("meant for process 1")))
+
+	activated, err := activatedListeners(pid, "1", "metrics", fd)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(activated).To(HaveKey("metrics"))
+	g.Expect(activated["metrics"]).To(HaveLen(1))
+	g.Expect(activated["metrics"][0].Addr().String()).To(Equal(listener.Addr().String()))
+	closeAll(activated["metrics"])
+}
+
+func TestHealthHandler(t *testing.T) {
+	g := NewWithT(t)
+
+	handler := HealthHandler(
+		map[string]healthz.Checker{"ping": healthz.Ping},
+		map[string]healthz.Checker{"ping": healthz.Ping, "failing": func(*http.Request) error { return errors.New("not ready") }},
+	)
+
+	testCases := map[string]int{
+		"/healthz":      http.StatusOK,
+		"/healthz/ping": http.StatusOK,
+		"/readyz":       http.StatusInternalServerError,
+		"/readyz/ping":  http.StatusOK,
+	}
+	for path, expectedCode := range testCases {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		g.Expect(recorder.Code).To(Equal(expectedCode), path)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	g := NewWithT(t)
+
+	handler := MetricsHandler(map[string]http.Handler{
+		"/version": http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("v0"))
+		}),
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/version", nil))
+	g.Expect(recorder.Body.String()).To(Equal("v0"))
+}
+
+func TestProfilingHandler(t *testing.T) {
+	g := NewWithT(t)
+
+	handler := ProfilingHandler()
+	for path, expectedCode := range map[string]int{
+		"/debug/pprof/":                  http.StatusOK,
+		"/debug/pprof/heap":              http.StatusOK,
+		"/debug/pprof/goroutine?debug=1": http.StatusOK,
+		"/debug/pprof/cmdline":           http.StatusOK,
+		"/metrics":                       http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		g.Expect(recorder.Code).To(Equal(expectedCode), path)
+	}
+}
+
+func TestBearerTokenHandler(t *testing.T) {
+	g := NewWithT(t)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	handler := BearerTokenHandler(tokenFile, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("dump"))
+	}))
+
+	serve := func(authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/debug/caches", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// A missing token file rejects all requests
+	g.Expect(serve("Bearer ").Code).To(Equal(http.StatusServiceUnavailable))
+
+	g.Expect(os.WriteFile(tokenFile, []byte("secret\n"), 0o600)).To(Succeed())
+	g.Expect(serve("").Code).To(Equal(http.StatusUnauthorized))
+	g.Expect(serve("Bearer wrong").Code).To(Equal(http.StatusUnauthorized))
+	g.Expect(serve("Basic secret").Code).To(Equal(http.StatusUnauthorized))
+
+	recorder := serve("Bearer secret")
+	g.Expect(recorder.Code).To(Equal(http.StatusOK))
+	g.Expect(recorder.Body.String()).To(Equal("dump"))
+
+	// A rotated token takes effect right away
+	g.Expect(os.WriteFile(tokenFile, []byte("rotated"), 0o600)).To(Succeed())
+	g.Expect(serve("Bearer secret").Code).To(Equal(http.StatusUnauthorized))
+	g.Expect(serve("Bearer rotated").Code).To(Equal(http.StatusOK))
+}
@@ -0,0 +1,167 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/yaml"
+)
+
+// AlertRuleGroup is a Prometheus rule group.
+type AlertRuleGroup struct {
+	Name  string      `json:"name"`
+	Rules []AlertRule `json:"rules"`
+}
+
+// AlertRule is a Prometheus alerting rule.
+type AlertRule struct {
+	Alert       string            `json:"alert"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// metricName returns the fully qualified name of a metric of the controller.
+func metricName(name string) string {
+	return prometheus.BuildFQName(Namespace, "", name)
+}
+
+// Alerts returns the recommended alerts on the metrics of the controller. The expressions are generated from the
+// metric names and label values of the running version, so they stay in sync across renames.
+func Alerts() []AlertRuleGroup {
+	return []AlertRuleGroup{
+		{
+			Name: "capa-annotator",
+			Rules: []AlertRule{
+				{
+					Alert:  "CapaAnnotatorDown",
+					Expr:   fmt.Sprintf("absent(%s)", metricName("build_info")),
+					For:    "10m",
+					Labels: map[string]string{"severity": "critical"},
+					Annotations: map[string]string{
+						"summary": "The capa-annotator controller is not running, MachineDeployments are not annotated.",
+					},
+				},
+				{
+					Alert:  "CapaAnnotatorIncompatibleCRDs",
+					Expr:   fmt.Sprintf("min(%s) == 0", metricName("crd_compatible")),
+					For:    "5m",
+					Labels: map[string]string{"severity": "critical"},
+					Annotations: map[string]string{
+						"summary": "The management cluster does not serve the Cluster API or CAPA versions supported by capa-annotator, upgrade the controller.",
+					},
+				},
+				{
+					Alert:  "CapaAnnotatorReconcileErrors",
+					Expr:   fmt.Sprintf(`sum by (namespace) (rate(%s{result=%q}[15m])) > 0`, metricName("reconcile_total"), ResultError),
+					For:    "30m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary": "Reconciles of MachineDeployments in namespace {{ $labels.namespace }} keep failing with errors.",
+					},
+				},
+				{
+					Alert:  "CapaAnnotatorReconcileFailures",
+					Expr:   fmt.Sprintf(`sum by (namespace, result) (increase(%s{result=~"%s|%s|%s"}[1h])) > 0`, metricName("reconcile_total"), ResultFailed, ResultForbidden, ResultGaveUp),
+					For:    "15m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary": "MachineDeployments in namespace {{ $labels.namespace }} cannot be annotated ({{ $labels.result }}), see their events.",
+					},
+				},
+				{
+					Alert:  "CapaAnnotatorSlowReconciles",
+					Expr:   fmt.Sprintf("histogram_quantile(0.99, sum by (le) (rate(%s_bucket[15m]))) > 30", metricName("reconcile_duration_seconds")),
+					For:    "15m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary": "The 99th percentile of the reconcile duration is above 30s, the AWS API may be throttling.",
+					},
+				},
+				{
+					Alert:  "CapaAnnotatorAWSClientFailures",
+					Expr:   fmt.Sprintf("sum by (region) (rate(%s[15m])) > 0", metricName("aws_client_construction_failures_total")),
+					For:    "15m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary": "AWS clients for region {{ $labels.region }} cannot be constructed, check the credentials of the controller.",
+					},
+				},
+				{
+					Alert:  "CapaAnnotatorCoverageViolations",
+					Expr:   fmt.Sprintf("%s > 0", metricName("coverage_violations")),
+					For:    "30m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary": "{{ $value }} MachineDeployments are not annotated after the grace period.",
+					},
+				},
+				burnRateAlert("CapaAnnotatorCoverageBudgetFastBurn", time.Hour, 5*time.Minute, 14.4, "critical"),
+				burnRateAlert("CapaAnnotatorCoverageBudgetSlowBurn", 6*time.Hour, 30*time.Minute, 6, "warning"),
+			},
+		},
+	}
+}
+
+// burnRateAlert returns a multiwindow burn rate alert on the annotation coverage SLO. The windows must be among
+// the windows of --coverage-slo-windows, the defaults include both windows of both alerts.
+func burnRateAlert(name string, long, short time.Duration, factor float64, severity string) AlertRule {
+	burnRate := metricName("coverage_slo_burn_rate")
+	return AlertRule{
+		Alert:  name,
+		Expr:   fmt.Sprintf(`%s{window=%q} > %g and %s{window=%q} > %g`, burnRate, WindowLabel(long), factor, burnRate, WindowLabel(short), factor),
+		For:    "2m",
+		Labels: map[string]string{"severity": severity},
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("The error budget of the annotation coverage SLO is consumed %gx faster than allowed over %s.", factor, WindowLabel(long)),
+		},
+	}
+}
+
+// AlertsHandler serves the recommended alerts as a PrometheusRule of the Prometheus Operator, or as a Prometheus
+// rule file with ?format=prometheus.
+type AlertsHandler struct{}
+
+// ServeHTTP implements http.Handler.
+func (h *AlertsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	data, err := MarshalAlerts(req.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(data)
+}
+
+// MarshalAlerts returns the YAML of the recommended alerts in the format, "prometheusrule" (the default) or "prometheus".
+func MarshalAlerts(format string) ([]byte, error) {
+	groups := map[string]interface{}{"groups": Alerts()}
+	switch format {
+	case "", "prometheusrule":
+		return yaml.Marshal(map[string]interface{}{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "PrometheusRule",
+			"metadata":   map[string]interface{}{"name": "capa-annotator"},
+			"spec":       groups,
+		})
+	case "prometheus":
+		return yaml.Marshal(groups)
+	}
+	return nil, fmt.Errorf("unknown format %q, must be one of %q", format, []string{"prometheusrule", "prometheus"})
+}
@@ -0,0 +1,114 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+// registeredMetricNames returns the names of the metrics registered by the package.
+func registeredMetricNames(g Gomega) map[string]bool {
+	families, err := ctrlmetrics.Registry.Gather()
+	g.Expect(err).ToNot(HaveOccurred())
+	names := map[string]bool{}
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+
+	// Vectors without any series are not gathered, so their descriptors are checked as well
+	descs := make(chan *prometheus.Desc, 100)
+	for _, collector := range []prometheus.Collector{ReconcileTotal, ReconcileDuration, AWSClientConstructionFailures, CoverageViolations, CoverageBurnRate, CRDCompatible} {
+		collector.Describe(descs)
+	}
+	close(descs)
+	fqName := regexp.MustCompile(`fqName: "([^"]+)"`)
+	for desc := range descs {
+		names[fqName.FindStringSubmatch(desc.String())[1]] = true
+	}
+	return names
+}
+
+func TestAlertsReferenceRegisteredMetrics(t *testing.T) {
+	g := NewWithT(t)
+
+	names := registeredMetricNames(g)
+	metric := regexp.MustCompile(Namespace + `_[a-z_]+`)
+	for _, group := range Alerts() {
+		for _, rule := range group.Rules {
+			referenced := metric.FindAllString(rule.Expr, -1)
+			g.Expect(referenced).ToNot(BeEmpty(), rule.Alert)
+			for _, name := range referenced {
+				g.Expect(names).To(HaveKey(strings.TrimSuffix(name, "_bucket")), rule.Alert)
+			}
+		}
+	}
+}
+
+func TestAlertsHandler(t *testing.T) {
+	testCases := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedKind   string
+	}{
+		{
+			name:           "PrometheusRule",
+			expectedStatus: http.StatusOK,
+			expectedKind:   "PrometheusRule",
+		},
+		{
+			name:           "Prometheus rule file",
+			query:          "?format=prometheus",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unknown format",
+			query:          "?format=json",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			recorder := httptest.NewRecorder()
+			(&AlertsHandler{}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/alerts"+tc.query, nil))
+			g.Expect(recorder.Code).To(Equal(tc.expectedStatus))
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var parsed struct {
+				Kind   string           `json:"kind"`
+				Groups []AlertRuleGroup `json:"groups"`
+				Spec   struct {
+					Groups []AlertRuleGroup `json:"groups"`
+				} `json:"spec"`
+			}
+			g.Expect(yaml.Unmarshal(recorder.Body.Bytes(), &parsed)).To(Succeed())
+			g.Expect(parsed.Kind).To(Equal(tc.expectedKind))
+			g.Expect(append(parsed.Groups, parsed.Spec.Groups...)).To(Equal(Alerts()))
+		})
+	}
+}
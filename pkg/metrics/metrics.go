@@ -0,0 +1,673 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// Namespace is the prefix used for all metrics exported by the controller.
+	Namespace = "capa_annotator"
+
+	// OtherNamespace is the namespace label value used for namespaces that are not in the namespace allow-list.
+	// It is not a valid namespace name, so it cannot collide with an allow-listed namespace.
+	OtherNamespace = "_other"
+
+	// ResultSuccess is the result label value of reconciles that completed without error.
+	ResultSuccess = "success"
+	// ResultError is the result label value of reconciles that returned an error and are retried.
+	ResultError = "error"
+	// ResultFailed is the result label value of reconciles that could not set the annotations and are not
+	// retried, e.g. because the instance type is unknown.
+	ResultFailed = "failed"
+	// ResultForbidden is the result label value of reconciles refused by the cross-namespace template policy.
+	ResultForbidden = "forbidden"
+	// ResultThrottled is the result label value of reconciles whose patch was deferred because the patch budget
+	// of the namespace was used up.
+	ResultThrottled = "throttled"
+	// ResultGaveUp is the result label value of reconciles that exhausted the retry budget of the MachineDeployment,
+	// which is retried after a long backoff.
+	ResultGaveUp = "gave_up"
+
+	// ReasonTemplateMissing is the reason label value of failed reconciles whose AWSMachineTemplate reference
+	// is missing, of another kind or not found.
+	ReasonTemplateMissing = "template_missing"
+	// ReasonTemplateInvalid is the reason label value of failed reconciles whose AWSMachineTemplate has no
+	// instance type.
+	ReasonTemplateInvalid = "template_invalid"
+	// ReasonRegionUnknown is the reason label value of failed reconciles whose region cannot be resolved.
+	ReasonRegionUnknown = "region_unknown"
+	// ReasonIdentity is the reason label value of failed reconciles whose AWS identity is not allowed or unsupported.
+	ReasonIdentity = "identity"
+	// ReasonInstanceUnknown is the reason label value of failed reconciles whose instance type is not offered in
+	// the region.
+	ReasonInstanceUnknown = "instance_unknown"
+	// ReasonAWSError is the reason label value of failed reconciles whose AWS API calls failed.
+	ReasonAWSError = "aws_error"
+	// ReasonOther is the reason label value of failed reconciles of other reasons.
+	ReasonOther = "other"
+
+	// QuotaPatches is the budget label value of the namespace patch budget.
+	QuotaPatches = "patches"
+	// QuotaWarningEvents is the budget label value of the namespace warning event budget.
+	QuotaWarningEvents = "warning_events"
+
+	// LookupUnknown is the reason label value of lookups of instance types not offered in the region.
+	LookupUnknown = "unknown"
+	// LookupRefreshFailed is the reason label value of lookups whose refresh of the instance types failed.
+	LookupRefreshFailed = "refresh_failed"
+	// LookupRefreshFailedCached is the reason label value of lookups failing with the cached error of a recent
+	// refresh, without calling the EC2 API.
+	LookupRefreshFailedCached = "refresh_failed_cached"
+)
+
+var (
+	// BuildInfo is always 1 and carries the build information of the running binary as labels.
+	BuildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "build_info",
+			Help:      "Build information of the running controller. The value is always 1.",
+		},
+		[]string{"version", "git_commit", "build_date", "go_version"},
+	)
+
+	// ReconcileTotal counts reconciles by namespace and result.
+	ReconcileTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "reconcile_total",
+			Help:      "Total number of MachineDeployment reconciles by namespace and result. Namespaces not in the allow-list are reported as \"_other\".",
+		},
+		[]string{"namespace", "result"},
+	)
+
+	// ReconcileDuration observes the duration of reconciles by namespace.
+	ReconcileDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "reconcile_duration_seconds",
+			Help:      "Duration of MachineDeployment reconciles by namespace. Namespaces not in the allow-list are reported as \"_other\".",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"namespace"},
+	)
+
+	// ReconcileFailures counts the failed reconciles by namespace and reason.
+	ReconcileFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "reconcile_failures_total",
+			Help:      "Total number of MachineDeployment reconciles with the error, failed or gave_up result by namespace and reason. Namespaces not in the allow-list are reported as \"_other\".",
+		},
+		[]string{"namespace", "reason"},
+	)
+
+	// AnnotationUpdates counts the MachineDeployments patched with changed annotations.
+	AnnotationUpdates = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "annotation_updates_total",
+			Help:      "Total number of MachineDeployment patches changing annotations by namespace. Namespaces not in the allow-list are reported as \"_other\".",
+		},
+		[]string{"namespace"},
+	)
+
+	// LookupsSkipped counts the reconciles skipping the instance type lookup since their inputs are unchanged.
+	LookupsSkipped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "lookups_skipped_total",
+			Help:      "Total number of reconciles skipping the instance type lookup since the template, region and annotations are unchanged, by namespace. Namespaces not in the allow-list are reported as \"_other\".",
+		},
+		[]string{"namespace"},
+	)
+
+	// AWSAPICalls counts the AWS API calls by service and operation.
+	AWSAPICalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "aws_api_calls_total",
+			Help:      "Total number of AWS API calls by service and operation, including failed calls. Retries of the AWS SDK are not counted separately.",
+		},
+		[]string{"service", "operation"},
+	)
+
+	// AWSAPICallErrors counts the failed AWS API calls by service, operation and error code.
+	AWSAPICallErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "aws_api_call_errors_total",
+			Help:      "Total number of failed AWS API calls by service, operation and AWS error code, e.g. RequestLimitExceeded or UnauthorizedOperation.",
+		},
+		[]string{"service", "operation", "code"},
+	)
+
+	// NamespaceBudgetExceeded counts patches and warning events throttled by the namespace budgets.
+	NamespaceBudgetExceeded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "namespace_budget_exceeded_total",
+			Help:      "Total number of patches and warning events throttled because the budget of the namespace was used up, by namespace and budget. Namespaces not in the allow-list are reported as \"_other\".",
+		},
+		[]string{"namespace", "budget"},
+	)
+
+	// AWSClientConstructionDuration observes the duration of AWS client constructions by region.
+	AWSClientConstructionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "aws_client_construction_duration_seconds",
+			Help:      "Duration of AWS client constructions by region. Clients are constructed once per identity and region and reused afterwards.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"region"},
+	)
+
+	// AWSClientConstructionFailures counts failed AWS client constructions by region.
+	AWSClientConstructionFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "aws_client_construction_failures_total",
+			Help:      "Total number of failed AWS client constructions by region.",
+		},
+		[]string{"region"},
+	)
+
+	// InstanceTypeLookupFailures counts failed instance type lookups by region and reason.
+	InstanceTypeLookupFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "instance_type_lookup_failures_total",
+			Help:      "Total number of failed instance type lookups by region and reason. Only the refresh_failed reason calls the EC2 API.",
+		},
+		[]string{"region", "reason"},
+	)
+
+	// InstanceTypesCacheEvictions counts the regions evicted from the instance types cache by its entry limit.
+	InstanceTypesCacheEvictions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "instance_types_cache_evictions_total",
+			Help:      "Total number of regions evicted from the instance types cache as the least recently used once its maximum number of entries was reached.",
+		},
+		[]string{"region"},
+	)
+
+	// InstanceTypesCacheEntries is the number of regions in the instance types cache.
+	InstanceTypesCacheEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "instance_types_cache_entries",
+			Help:      "Number of regions in the instance types cache.",
+		},
+	)
+
+	// CRDCompatible is 1 for the kinds watched by the controllers that are served in a supported version, 0 otherwise.
+	CRDCompatible = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "crd_compatible",
+			Help:      "Whether the kinds watched by the controllers are served in a version supported by the controller, checked at startup.",
+		},
+		[]string{"group_version", "kind"},
+	)
+
+	// ChaosFaults counts the faults injected by the chaos mode by fault.
+	ChaosFaults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "chaos_faults_total",
+			Help:      "Total number of faults injected by the chaos mode by fault.",
+		},
+		[]string{"fault"},
+	)
+
+	// VCPUCorrections counts the vCPU corrections applied by instance type.
+	VCPUCorrections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "vcpu_corrections_total",
+			Help:      "Total number of capacity computations whose vCPUs were replaced by the configured correction of the instance type.",
+		},
+		[]string{"instance_type"},
+	)
+
+	// InstanceTypeVCPU is the number of vCPUs of the instance types in use.
+	InstanceTypeVCPU = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "instance_type_vcpu",
+			Help:      "Number of vCPUs of the instance types currently used by MachineDeployments.",
+		},
+		[]string{"region", "instance_type"},
+	)
+
+	// InstanceTypeMemory is the memory in MiB of the instance types in use.
+	InstanceTypeMemory = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "instance_type_memory_mb",
+			Help:      "Memory in MiB of the instance types currently used by MachineDeployments.",
+		},
+		[]string{"region", "instance_type"},
+	)
+
+	// InstanceTypeGPU is the number of GPUs of the instance types in use.
+	InstanceTypeGPU = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "instance_type_gpu",
+			Help:      "Number of GPUs of the instance types currently used by MachineDeployments.",
+		},
+		[]string{"region", "instance_type"},
+	)
+
+	// MachineDeploymentVCPU is the number of vCPUs annotated on each MachineDeployment.
+	MachineDeploymentVCPU = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "machinedeployment_vcpu",
+			Help:      "Number of vCPUs per node annotated on each MachineDeployment.",
+		},
+		[]string{"namespace", "name", "cluster", "instance_type"},
+	)
+
+	// MachineDeploymentMemory is the memory in MiB annotated on each MachineDeployment.
+	MachineDeploymentMemory = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "machinedeployment_memory_mb",
+			Help:      "Memory in MiB per node annotated on each MachineDeployment.",
+		},
+		[]string{"namespace", "name", "cluster", "instance_type"},
+	)
+
+	// MachineDeploymentGPU is the number of GPUs annotated on each MachineDeployment.
+	MachineDeploymentGPU = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "machinedeployment_gpu",
+			Help:      "Number of GPUs per node annotated on each MachineDeployment.",
+		},
+		[]string{"namespace", "name", "cluster", "instance_type"},
+	)
+)
+
+var (
+	// VCPUQuota is the applied value of the vCPU quotas of the regions with MachineDeployments.
+	VCPUQuota = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "vcpu_quota",
+			Help:      "Applied value of the running On-Demand instances vCPU quota of the account in the region.",
+		},
+		[]string{"region", "quota_code"},
+	)
+
+	// VCPUQuotaUsage is the number of vCPUs of the running instances counting against the vCPU quotas.
+	VCPUQuotaUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "vcpu_quota_usage",
+			Help:      "Number of vCPUs of the pending and running On-Demand instances of the account in the region counting against the quota.",
+		},
+		[]string{"region", "quota_code"},
+	)
+
+	// QuotaPreflightExceeded is the number of MachineDeployments whose next replica would exceed the vCPU quota.
+	QuotaPreflightExceeded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "quota_preflight_exceeded",
+			Help:      "Number of MachineDeployments whose scale up by one replica would exceed the vCPU quota.",
+		},
+		[]string{"region", "quota_code"},
+	)
+)
+
+var (
+	// CoverageInScope is the number of MachineDeployments in scope of the annotation coverage SLO.
+	CoverageInScope = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "coverage_in_scope",
+			Help:      "Number of MachineDeployments in scope of the annotation coverage, i.e. not being deleted.",
+		},
+	)
+
+	// CoverageAnnotated is the number of MachineDeployments in scope with complete annotations.
+	CoverageAnnotated = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "coverage_annotated",
+			Help:      "Number of MachineDeployments in scope with complete annotations.",
+		},
+	)
+
+	// CoverageViolations is the number of MachineDeployments in scope without complete annotations after the grace period.
+	CoverageViolations = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "coverage_violations",
+			Help:      "Number of MachineDeployments in scope without complete annotations after the grace period since their creation.",
+		},
+	)
+
+	// CoverageRatio is the share of MachineDeployments in scope with complete annotations.
+	CoverageRatio = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "coverage_ratio",
+			Help:      "Share of MachineDeployments in scope with complete annotations, 1 if none are in scope.",
+		},
+	)
+
+	// CoverageTarget is the target of the annotation coverage SLO.
+	CoverageTarget = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "coverage_slo_target",
+			Help:      "Target share of MachineDeployments annotated within the grace period of the annotation coverage SLO.",
+		},
+	)
+
+	// CoverageBurnRate is the rate at which the error budget of the annotation coverage SLO is consumed per window.
+	CoverageBurnRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "coverage_slo_burn_rate",
+			Help:      "Rate at which the error budget of the annotation coverage SLO is consumed over the window. 1 consumes the budget exactly at the rate allowed by the target.",
+		},
+		[]string{"window"},
+	)
+)
+
+var (
+	// namespaceAllowList holds the namespaces that are reported with their own label value.
+	// The allow-list bounds the cardinality of the namespace label. Access is synchronized via rwmutex.
+	namespaceAllowList = map[string]struct{}{}
+	namespaceMutex     sync.RWMutex
+)
+
+// instanceTypeUse identifies an instance type in a region.
+type instanceTypeUse struct {
+	region       string
+	instanceType string
+}
+
+var (
+	// instanceTypeUsers maps each MachineDeployment to the instance type it uses, so that the series of an
+	// instance type can be removed once no MachineDeployment uses it anymore. Access is synchronized via mutex.
+	instanceTypeUsers = map[string]instanceTypeUse{}
+	instanceTypeMutex sync.Mutex
+)
+
+// machineDeploymentCapacity identifies the capacity series of a MachineDeployment.
+type machineDeploymentCapacity struct {
+	cluster      string
+	instanceType string
+}
+
+var (
+	// machineDeploymentCapacities maps each MachineDeployment to the labels of its capacity series, so that the
+	// series are replaced once the cluster or instance type changes. Access is synchronized via mutex.
+	machineDeploymentCapacities = map[types.NamespacedName]machineDeploymentCapacity{}
+	machineDeploymentMutex      sync.Mutex
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(BuildInfo)
+	ctrlmetrics.Registry.MustRegister(ReconcileTotal)
+	ctrlmetrics.Registry.MustRegister(ReconcileDuration)
+	ctrlmetrics.Registry.MustRegister(ReconcileFailures)
+	ctrlmetrics.Registry.MustRegister(AnnotationUpdates)
+	ctrlmetrics.Registry.MustRegister(LookupsSkipped)
+	ctrlmetrics.Registry.MustRegister(AWSAPICalls)
+	ctrlmetrics.Registry.MustRegister(AWSAPICallErrors)
+	ctrlmetrics.Registry.MustRegister(NamespaceBudgetExceeded)
+	ctrlmetrics.Registry.MustRegister(AWSClientConstructionDuration)
+	ctrlmetrics.Registry.MustRegister(AWSClientConstructionFailures)
+	ctrlmetrics.Registry.MustRegister(ChaosFaults)
+	ctrlmetrics.Registry.MustRegister(CRDCompatible)
+	ctrlmetrics.Registry.MustRegister(InstanceTypeLookupFailures)
+	ctrlmetrics.Registry.MustRegister(InstanceTypesCacheEvictions)
+	ctrlmetrics.Registry.MustRegister(InstanceTypesCacheEntries)
+	ctrlmetrics.Registry.MustRegister(VCPUCorrections)
+	ctrlmetrics.Registry.MustRegister(InstanceTypeVCPU)
+	ctrlmetrics.Registry.MustRegister(InstanceTypeMemory)
+	ctrlmetrics.Registry.MustRegister(InstanceTypeGPU)
+	ctrlmetrics.Registry.MustRegister(MachineDeploymentVCPU)
+	ctrlmetrics.Registry.MustRegister(MachineDeploymentMemory)
+	ctrlmetrics.Registry.MustRegister(MachineDeploymentGPU)
+	ctrlmetrics.Registry.MustRegister(VCPUQuota)
+	ctrlmetrics.Registry.MustRegister(VCPUQuotaUsage)
+	ctrlmetrics.Registry.MustRegister(QuotaPreflightExceeded)
+	ctrlmetrics.Registry.MustRegister(CoverageInScope)
+	ctrlmetrics.Registry.MustRegister(CoverageAnnotated)
+	ctrlmetrics.Registry.MustRegister(CoverageViolations)
+	ctrlmetrics.Registry.MustRegister(CoverageRatio)
+	ctrlmetrics.Registry.MustRegister(CoverageTarget)
+	ctrlmetrics.Registry.MustRegister(CoverageBurnRate)
+
+	info := version.Get()
+	BuildInfo.WithLabelValues(info.Version, info.GitCommit, info.BuildDate, info.GoVersion).Set(1)
+}
+
+// SetNamespaceAllowList sets the namespaces that are reported with their own namespace label value.
+// All other namespaces are reported as OtherNamespace.
+func SetNamespaceAllowList(namespaces []string) {
+	namespaceMutex.Lock()
+	defer namespaceMutex.Unlock()
+
+	namespaceAllowList = map[string]struct{}{}
+	for _, namespace := range namespaces {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaceAllowList[namespace] = struct{}{}
+		}
+	}
+}
+
+// NamespaceLabel returns the namespace label value for the given namespace.
+func NamespaceLabel(namespace string) string {
+	namespaceMutex.RLock()
+	defer namespaceMutex.RUnlock()
+
+	if _, ok := namespaceAllowList[namespace]; ok {
+		return namespace
+	}
+	return OtherNamespace
+}
+
+// RecordReconcile records the result and duration of a reconcile in the given namespace.
+func RecordReconcile(namespace, result string, duration time.Duration) {
+	label := NamespaceLabel(namespace)
+
+	ReconcileTotal.WithLabelValues(label, result).Inc()
+	ReconcileDuration.WithLabelValues(label).Observe(duration.Seconds())
+}
+
+// RecordReconcileFailure records a failed reconcile of the given reason in the given namespace.
+func RecordReconcileFailure(namespace, reason string) {
+	ReconcileFailures.WithLabelValues(NamespaceLabel(namespace), reason).Inc()
+}
+
+// RecordAnnotationUpdate records a patch changing the annotations of a MachineDeployment in the given namespace.
+func RecordAnnotationUpdate(namespace string) {
+	AnnotationUpdates.WithLabelValues(NamespaceLabel(namespace)).Inc()
+}
+
+// RecordLookupSkipped records a reconcile skipping the instance type lookup.
+func RecordLookupSkipped(namespace string) {
+	LookupsSkipped.WithLabelValues(NamespaceLabel(namespace)).Inc()
+}
+
+// RecordAWSAPICall records an AWS API call. The code is the AWS error code of failed calls, empty otherwise.
+func RecordAWSAPICall(service, operation, code string) {
+	AWSAPICalls.WithLabelValues(service, operation).Inc()
+	if code != "" {
+		AWSAPICallErrors.WithLabelValues(service, operation, code).Inc()
+	}
+}
+
+// RecordBudgetExceeded records a patch or warning event throttled by the given budget of the namespace.
+func RecordBudgetExceeded(namespace, budget string) {
+	NamespaceBudgetExceeded.WithLabelValues(NamespaceLabel(namespace), budget).Inc()
+}
+
+// RecordVCPUCorrection records that the vCPUs of the instance type were corrected.
+func RecordVCPUCorrection(instanceType string) {
+	VCPUCorrections.WithLabelValues(instanceType).Inc()
+}
+
+// ResetVCPUQuotas removes the vCPU quotas of the previous quota preflight, e.g. of regions without
+// MachineDeployments anymore.
+func ResetVCPUQuotas() {
+	VCPUQuota.Reset()
+	VCPUQuotaUsage.Reset()
+	QuotaPreflightExceeded.Reset()
+}
+
+// SetVCPUQuota exports a vCPU quota of the region, its usage and the number of MachineDeployments whose next
+// replica would exceed it.
+func SetVCPUQuota(region, quotaCode string, limit float64, usage int64, exceeded int) {
+	VCPUQuota.WithLabelValues(region, quotaCode).Set(limit)
+	VCPUQuotaUsage.WithLabelValues(region, quotaCode).Set(float64(usage))
+	QuotaPreflightExceeded.WithLabelValues(region, quotaCode).Set(float64(exceeded))
+}
+
+// SetCoverage exports the annotation coverage of the MachineDeployments in scope.
+func SetCoverage(inScope, annotated, violations int) {
+	CoverageInScope.Set(float64(inScope))
+	CoverageAnnotated.Set(float64(annotated))
+	CoverageViolations.Set(float64(violations))
+	if inScope == 0 {
+		CoverageRatio.Set(1)
+		return
+	}
+	CoverageRatio.Set(float64(annotated) / float64(inScope))
+}
+
+// SetCoverageBurnRate exports the burn rate of the annotation coverage SLO over the window.
+func SetCoverageBurnRate(window time.Duration, burnRate float64) {
+	CoverageBurnRate.WithLabelValues(WindowLabel(window)).Set(burnRate)
+}
+
+// WindowLabel formats the window as a label value without trailing zero units, e.g. "1h" instead of "1h0m0s".
+func WindowLabel(window time.Duration) string {
+	label := window.String()
+	if strings.HasSuffix(label, "m0s") {
+		label = strings.TrimSuffix(label, "0s")
+	}
+	if strings.HasSuffix(label, "h0m") {
+		label = strings.TrimSuffix(label, "0m")
+	}
+	return label
+}
+
+// SetInstanceTypeInUse records that the MachineDeployment identified by key uses the instance type in the region
+// and exports the capacity of the instance type.
+func SetInstanceTypeInUse(key, region, instanceType string, vcpu, memoryMb, gpu int64) {
+	instanceTypeMutex.Lock()
+	defer instanceTypeMutex.Unlock()
+
+	use := instanceTypeUse{region: region, instanceType: instanceType}
+	if previous, ok := instanceTypeUsers[key]; ok && previous != use {
+		delete(instanceTypeUsers, key)
+		deleteUnusedInstanceType(previous)
+	}
+	instanceTypeUsers[key] = use
+
+	InstanceTypeVCPU.WithLabelValues(region, instanceType).Set(float64(vcpu))
+	InstanceTypeMemory.WithLabelValues(region, instanceType).Set(float64(memoryMb))
+	InstanceTypeGPU.WithLabelValues(region, instanceType).Set(float64(gpu))
+}
+
+// ForgetInstanceTypeUse records that the MachineDeployment identified by key no longer uses an instance type.
+func ForgetInstanceTypeUse(key string) {
+	instanceTypeMutex.Lock()
+	defer instanceTypeMutex.Unlock()
+
+	if previous, ok := instanceTypeUsers[key]; ok {
+		delete(instanceTypeUsers, key)
+		deleteUnusedInstanceType(previous)
+	}
+}
+
+// deleteUnusedInstanceType removes the series of the instance type if no MachineDeployment uses it anymore.
+// The caller must hold instanceTypeMutex.
+func deleteUnusedInstanceType(use instanceTypeUse) {
+	for _, other := range instanceTypeUsers {
+		if other == use {
+			return
+		}
+	}
+	InstanceTypeVCPU.DeleteLabelValues(use.region, use.instanceType)
+	InstanceTypeMemory.DeleteLabelValues(use.region, use.instanceType)
+	InstanceTypeGPU.DeleteLabelValues(use.region, use.instanceType)
+}
+
+// SetMachineDeploymentCapacity exports the capacity annotated on the MachineDeployment. The namespace is not
+// bounded by the namespace allow-list, the series are per MachineDeployment anyway.
+func SetMachineDeploymentCapacity(key types.NamespacedName, cluster, instanceType string, vcpu, memoryMb, gpu int64) {
+	machineDeploymentMutex.Lock()
+	defer machineDeploymentMutex.Unlock()
+
+	capacity := machineDeploymentCapacity{cluster: cluster, instanceType: instanceType}
+	if previous, ok := machineDeploymentCapacities[key]; ok && previous != capacity {
+		deleteMachineDeploymentCapacity(key, previous)
+	}
+	machineDeploymentCapacities[key] = capacity
+
+	MachineDeploymentVCPU.WithLabelValues(key.Namespace, key.Name, cluster, instanceType).Set(float64(vcpu))
+	MachineDeploymentMemory.WithLabelValues(key.Namespace, key.Name, cluster, instanceType).Set(float64(memoryMb))
+	MachineDeploymentGPU.WithLabelValues(key.Namespace, key.Name, cluster, instanceType).Set(float64(gpu))
+}
+
+// ForgetMachineDeploymentCapacity removes the capacity series of the MachineDeployment, e.g. once it was deleted
+// or cannot be annotated anymore.
+func ForgetMachineDeploymentCapacity(key types.NamespacedName) {
+	machineDeploymentMutex.Lock()
+	defer machineDeploymentMutex.Unlock()
+
+	if previous, ok := machineDeploymentCapacities[key]; ok {
+		deleteMachineDeploymentCapacity(key, previous)
+		delete(machineDeploymentCapacities, key)
+	}
+}
+
+// HasMachineDeploymentCapacity returns true if the capacity of the MachineDeployment is exported.
+func HasMachineDeploymentCapacity(key types.NamespacedName) bool {
+	machineDeploymentMutex.Lock()
+	defer machineDeploymentMutex.Unlock()
+
+	_, ok := machineDeploymentCapacities[key]
+	return ok
+}
+
+// deleteMachineDeploymentCapacity removes the capacity series of the MachineDeployment.
+// The caller must hold machineDeploymentMutex.
+func deleteMachineDeploymentCapacity(key types.NamespacedName, capacity machineDeploymentCapacity) {
+	MachineDeploymentVCPU.DeleteLabelValues(key.Namespace, key.Name, capacity.cluster, capacity.instanceType)
+	MachineDeploymentMemory.DeleteLabelValues(key.Namespace, key.Name, capacity.cluster, capacity.instanceType)
+	MachineDeploymentGPU.DeleteLabelValues(key.Namespace, key.Name, capacity.cluster, capacity.instanceType)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jhjaggars/capa-annotator/pkg/version"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestBuildInfo(t *testing.T) {
+	g := NewWithT(t)
+
+	info := version.Get()
+	g.Expect(testutil.CollectAndCount(BuildInfo)).To(Equal(1))
+	g.Expect(testutil.ToFloat64(BuildInfo.WithLabelValues(info.Version, info.GitCommit, info.BuildDate, info.GoVersion))).To(Equal(1.0))
+}
+
+func TestInstanceTypeInUse(t *testing.T) {
+	g := NewWithT(t)
+
+	SetInstanceTypeInUse("default/a", "us-east-1", "m5.large", 2, 8192, 0)
+	SetInstanceTypeInUse("default/b", "us-east-1", "m5.large", 2, 8192, 0)
+	g.Expect(testutil.ToFloat64(InstanceTypeVCPU.WithLabelValues("us-east-1", "m5.large"))).To(Equal(2.0))
+	g.Expect(testutil.ToFloat64(InstanceTypeMemory.WithLabelValues("us-east-1", "m5.large"))).To(Equal(8192.0))
+	g.Expect(testutil.CollectAndCount(InstanceTypeGPU)).To(Equal(1))
+
+	// Switching one MachineDeployment to another type keeps the type still in use by the other one
+	SetInstanceTypeInUse("default/a", "us-east-1", "p2.xlarge", 4, 62464, 1)
+	g.Expect(testutil.CollectAndCount(InstanceTypeGPU)).To(Equal(2))
+
+	// Series are removed once no MachineDeployment uses the type anymore
+	ForgetInstanceTypeUse("default/b")
+	g.Expect(testutil.CollectAndCount(InstanceTypeGPU)).To(Equal(1))
+	g.Expect(testutil.ToFloat64(InstanceTypeGPU.WithLabelValues("us-east-1", "p2.xlarge"))).To(Equal(1.0))
+
+	ForgetInstanceTypeUse("default/a")
+	g.Expect(testutil.CollectAndCount(InstanceTypeGPU)).To(Equal(0))
+}
+
+func TestMachineDeploymentCapacity(t *testing.T) {
+	g := NewWithT(t)
+
+	key := types.NamespacedName{Namespace: "default", Name: "workers"}
+	SetMachineDeploymentCapacity(key, "prod", "m5.large", 2, 8192, 0)
+	g.Expect(HasMachineDeploymentCapacity(key)).To(BeTrue())
+	g.Expect(testutil.ToFloat64(MachineDeploymentVCPU.WithLabelValues("default", "workers", "prod", "m5.large"))).To(Equal(2.0))
+	g.Expect(testutil.ToFloat64(MachineDeploymentMemory.WithLabelValues("default", "workers", "prod", "m5.large"))).To(Equal(8192.0))
+
+	// Switching the instance type replaces the series
+	SetMachineDeploymentCapacity(key, "prod", "p2.xlarge", 4, 62464, 1)
+	g.Expect(testutil.CollectAndCount(MachineDeploymentGPU)).To(Equal(1))
+	g.Expect(testutil.ToFloat64(MachineDeploymentGPU.WithLabelValues("default", "workers", "prod", "p2.xlarge"))).To(Equal(1.0))
+
+	ForgetMachineDeploymentCapacity(key)
+	g.Expect(HasMachineDeploymentCapacity(key)).To(BeFalse())
+	g.Expect(testutil.CollectAndCount(MachineDeploymentVCPU)).To(Equal(0))
+}
+
+func TestNamespaceLabel(t *testing.T) {
+	g := NewWithT(t)
+
+	SetNamespaceAllowList([]string{"team-a", " team-b ", "other"})
+	defer SetNamespaceAllowList(nil)
+
+	g.Expect(NamespaceLabel("team-a")).To(Equal("team-a"))
+	g.Expect(NamespaceLabel("team-b")).To(Equal("team-b"))
+	g.Expect(NamespaceLabel("team-c")).To(Equal(OtherNamespace))
+	// A namespace named "other" must not be merged with the namespaces outside the allow-list
+	g.Expect(NamespaceLabel("other")).To(Equal("other"))
+	g.Expect(NamespaceLabel("other")).NotTo(Equal(OtherNamespace))
+}
+
+func TestRecordReconcile(t *testing.T) {
+	g := NewWithT(t)
+
+	SetNamespaceAllowList([]string{"team-a"})
+	defer SetNamespaceAllowList(nil)
+
+	for _, result := range []string{ResultSuccess, ResultError, ResultFailed, ResultForbidden} {
+		before := testutil.ToFloat64(ReconcileTotal.WithLabelValues("team-a", result))
+		RecordReconcile("team-a", result, 0)
+		g.Expect(testutil.ToFloat64(ReconcileTotal.WithLabelValues("team-a", result))).To(Equal(before + 1))
+	}
+
+	before := testutil.ToFloat64(ReconcileTotal.WithLabelValues(OtherNamespace, ResultFailed))
+	RecordReconcile("team-c", ResultFailed, 0)
+	g.Expect(testutil.ToFloat64(ReconcileTotal.WithLabelValues(OtherNamespace, ResultFailed))).To(Equal(before + 1))
+}
+
+func TestSetCoverage(t *testing.T) {
+	g := NewWithT(t)
+
+	SetCoverage(4, 3, 1)
+	g.Expect(testutil.ToFloat64(CoverageRatio)).To(Equal(0.75))
+	SetCoverage(0, 0, 0)
+	g.Expect(testutil.ToFloat64(CoverageRatio)).To(Equal(1.0))
+
+	SetCoverageBurnRate(time.Hour, 2)
+	g.Expect(testutil.ToFloat64(CoverageBurnRate.WithLabelValues("1h"))).To(Equal(2.0))
+	g.Expect(WindowLabel(5 * time.Minute)).To(Equal("5m"))
+	g.Expect(WindowLabel(90 * time.Minute)).To(Equal("1h30m"))
+}
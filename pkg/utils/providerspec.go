@@ -18,12 +18,15 @@ package utils
 
 import (
 	"context"
-	"fmt"
 
-	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	annotatorerrors "github.com/jhjaggars/capa-annotator/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
 	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"k8s.io/klog/v2"
 )
 
 const (
@@ -36,12 +39,12 @@ func ResolveAWSMachineTemplate(ctx context.Context, c client.Client, machineDepl
 	// Extract infrastructureRef
 	infraRef := machineDeployment.Spec.Template.Spec.InfrastructureRef
 	if infraRef.Name == "" {
-		return nil, fmt.Errorf("infrastructureRef.name is empty")
+		return nil, annotatorerrors.Errorf(annotatorerrors.ErrInvalidReference, "infrastructureRef.name is empty")
 	}
 
 	// Validate it's an AWSMachineTemplate
 	if infraRef.Kind != "AWSMachineTemplate" {
-		return nil, fmt.Errorf("expected AWSMachineTemplate, got %s", infraRef.Kind)
+		return nil, annotatorerrors.Errorf(annotatorerrors.ErrInvalidReference, "expected AWSMachineTemplate, got %s", infraRef.Kind)
 	}
 
 	// Fetch the template
@@ -56,7 +59,7 @@ func ResolveAWSMachineTemplate(ctx context.Context, c client.Client, machineDepl
 	}
 
 	if err := c.Get(ctx, key, template); err != nil {
-		return nil, fmt.Errorf("failed to fetch AWSMachineTemplate %s/%s: %w", key.Namespace, key.Name, err)
+		return nil, annotatorerrors.NewLookupError("AWSMachineTemplate", key.Namespace, key.Name, err)
 	}
 
 	klog.V(3).Infof("Resolved AWSMachineTemplate %s/%s for MachineDeployment %s", key.Namespace, key.Name, machineDeployment.Name)
@@ -66,23 +69,28 @@ func ResolveAWSMachineTemplate(ctx context.Context, c client.Client, machineDepl
 // ExtractInstanceType gets the instance type from AWSMachineTemplate
 func ExtractInstanceType(template *infrav1.AWSMachineTemplate) (string, error) {
 	if template == nil {
-		return "", fmt.Errorf("AWSMachineTemplate is nil")
+		return "", annotatorerrors.Errorf(annotatorerrors.ErrInstanceTypeNotSet, "AWSMachineTemplate is nil")
 	}
 	if template.Spec.Template.Spec.InstanceType == "" {
-		return "", fmt.Errorf("instanceType is empty in AWSMachineTemplate")
+		return "", annotatorerrors.Errorf(annotatorerrors.ErrInstanceTypeNotSet, "instanceType is empty in AWSMachineTemplate")
 	}
 	return template.Spec.Template.Spec.InstanceType, nil
 }
 
-// ResolveRegion attempts to get AWS region from AWSCluster, falls back to annotation
+// awsManagedControlPlaneGVK is the kind of the control plane of EKS clusters. It is read as unstructured
+// object since the EKS API types are not part of the scheme of the controller.
+var awsManagedControlPlaneGVK = schema.GroupVersionKind{Group: "controlplane.cluster.x-k8s.io", Version: "v1beta2", Kind: "AWSManagedControlPlane"}
+
+// ResolveRegion attempts to get AWS region from the AWSCluster or, for EKS clusters, the
+// AWSManagedControlPlane of the Cluster, falls back to annotation
 func ResolveRegion(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (string, error) {
-	// Try to get region from AWSCluster
+	// Try to get region from the Cluster
 	if machineDeployment.Spec.ClusterName != "" {
-		region, err := getRegionFromAWSCluster(ctx, c, machineDeployment)
+		region, err := getRegionFromCluster(ctx, c, machineDeployment)
 		if err == nil {
 			return region, nil
 		}
-		klog.V(3).Infof("Failed to get region from AWSCluster: %v, trying annotation fallback", err)
+		klog.V(3).Infof("Failed to get region from Cluster: %v, trying annotation fallback", err)
 	}
 
 	// Fallback to annotation
@@ -91,11 +99,11 @@ func ResolveRegion(ctx context.Context, c client.Client, machineDeployment *clus
 		return region, nil
 	}
 
-	return "", fmt.Errorf("unable to determine AWS region from AWSCluster or annotation %s", RegionAnnotation)
+	return "", annotatorerrors.Errorf(annotatorerrors.ErrRegionNotResolved, "unable to determine AWS region from AWSCluster, AWSManagedControlPlane or annotation %s", RegionAnnotation)
 }
 
-// getRegionFromAWSCluster fetches region from the AWSCluster resource
-func getRegionFromAWSCluster(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (string, error) {
+// getRegionFromCluster fetches region from the AWSManagedControlPlane or AWSCluster of the Cluster
+func getRegionFromCluster(ctx context.Context, c client.Client, machineDeployment *clusterv1.MachineDeployment) (string, error) {
 	// Fetch the Cluster resource
 	cluster := &clusterv1.Cluster{}
 	clusterKey := client.ObjectKey{
@@ -104,15 +112,23 @@ func getRegionFromAWSCluster(ctx context.Context, c client.Client, machineDeploy
 	}
 
 	if err := c.Get(ctx, clusterKey, cluster); err != nil {
-		return "", fmt.Errorf("failed to fetch Cluster %s/%s: %w", clusterKey.Namespace, clusterKey.Name, err)
+		return "", annotatorerrors.NewLookupError("Cluster", clusterKey.Namespace, clusterKey.Name, err)
+	}
+
+	// EKS clusters reference an AWSManagedControlPlane as control plane, and as infrastructure
+	// with the older single-resource topology, instead of an AWSCluster
+	for _, ref := range []*corev1.ObjectReference{cluster.Spec.ControlPlaneRef, cluster.Spec.InfrastructureRef} {
+		if ref != nil && ref.Kind == awsManagedControlPlaneGVK.Kind {
+			return getRegionFromAWSManagedControlPlane(ctx, c, cluster, ref)
+		}
 	}
 
 	// Fetch AWSCluster
 	if cluster.Spec.InfrastructureRef == nil {
-		return "", fmt.Errorf("cluster %s has nil infrastructureRef", cluster.Name)
+		return "", annotatorerrors.Errorf(annotatorerrors.ErrInvalidReference, "cluster %s has nil infrastructureRef", cluster.Name)
 	}
 	if cluster.Spec.InfrastructureRef.Name == "" {
-		return "", fmt.Errorf("cluster %s has empty infrastructureRef.Name", cluster.Name)
+		return "", annotatorerrors.Errorf(annotatorerrors.ErrInvalidReference, "cluster %s has empty infrastructureRef.Name", cluster.Name)
 	}
 
 	awsCluster := &infrav1.AWSCluster{}
@@ -125,13 +141,38 @@ func getRegionFromAWSCluster(ctx context.Context, c client.Client, machineDeploy
 	}
 
 	if err := c.Get(ctx, awsClusterKey, awsCluster); err != nil {
-		return "", fmt.Errorf("failed to fetch AWSCluster %s/%s: %w", awsClusterKey.Namespace, awsClusterKey.Name, err)
+		return "", annotatorerrors.NewLookupError("AWSCluster", awsClusterKey.Namespace, awsClusterKey.Name, err)
 	}
 
 	if awsCluster.Spec.Region == "" {
-		return "", fmt.Errorf("AWSCluster %s has empty region", awsCluster.Name)
+		return "", annotatorerrors.Errorf(annotatorerrors.ErrRegionNotResolved, "AWSCluster %s has empty region", awsCluster.Name)
 	}
 
 	klog.V(3).Infof("Resolved region %s from AWSCluster %s", awsCluster.Spec.Region, awsClusterKey.Name)
 	return awsCluster.Spec.Region, nil
 }
+
+// getRegionFromAWSManagedControlPlane fetches region from the AWSManagedControlPlane referenced by the Cluster
+func getRegionFromAWSManagedControlPlane(ctx context.Context, c client.Client, cluster *clusterv1.Cluster, ref *corev1.ObjectReference) (string, error) {
+	controlPlane := &unstructured.Unstructured{}
+	controlPlane.SetGroupVersionKind(awsManagedControlPlaneGVK)
+	controlPlaneKey := client.ObjectKey{
+		Name:      ref.Name,
+		Namespace: ref.Namespace,
+	}
+	if controlPlaneKey.Namespace == "" {
+		controlPlaneKey.Namespace = cluster.Namespace
+	}
+
+	if err := c.Get(ctx, controlPlaneKey, controlPlane); err != nil {
+		return "", annotatorerrors.NewLookupError("AWSManagedControlPlane", controlPlaneKey.Namespace, controlPlaneKey.Name, err)
+	}
+
+	region, _, _ := unstructured.NestedString(controlPlane.Object, "spec", "region")
+	if region == "" {
+		return "", annotatorerrors.Errorf(annotatorerrors.ErrRegionNotResolved, "AWSManagedControlPlane %s has empty region", controlPlaneKey.Name)
+	}
+
+	klog.V(3).Infof("Resolved region %s from AWSManagedControlPlane %s", region, controlPlaneKey.Name)
+	return region, nil
+}
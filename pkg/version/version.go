@@ -1,6 +1,43 @@
 package version
 
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
 var (
 	String  = "v0.1.0"
 	Version = String
+
+	// GitCommit is the git SHA the binary was built from. It is set at build time via -ldflags.
+	GitCommit = "unknown"
+	// BuildDate is the RFC3339 timestamp of the build. It is set at build time via -ldflags.
+	BuildDate = "unknown"
 )
+
+// Info describes the running build of the controller.
+type Info struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// Handler serves the build information as JSON.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Get())
+	})
+}